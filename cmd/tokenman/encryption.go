@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/allaspectsdev/tokenman/internal/audit"
+	"github.com/allaspectsdev/tokenman/internal/config"
+	"github.com/allaspectsdev/tokenman/internal/daemon"
+	"github.com/allaspectsdev/tokenman/internal/store"
+	"github.com/allaspectsdev/tokenman/internal/vault"
+)
+
+const encryptionUsage = "Usage: tokenman encryption <init|status|rotate|rewrap> [options]"
+
+// encryptionVaultName is the vault entry "encryption init" stores the master
+// key under, matching the default security.encryption.key_ref.
+const encryptionVaultName = "encryption"
+
+// cmdEncryption manages encryption at rest of stored bodies.
+func cmdEncryption(args []string) {
+	if len(args) == 0 {
+		fmt.Println(encryptionUsage)
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "init":
+		fs := flag.NewFlagSet("encryption init", flag.ExitOnError)
+		printOnly := fs.Bool("print", false, "print the new master key instead of storing it in the OS keychain")
+		fs.Parse(args[1:])
+
+		key, err := store.GenerateMasterKey()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		if *printOnly {
+			fmt.Println(key)
+			fmt.Println()
+			fmt.Println("Store this key somewhere safe; bodies encrypted with it cannot be read without it.")
+			fmt.Println("Reference it from security.encryption.key_ref as env:VARIABLE or file:///path.")
+			return
+		}
+		v := vault.New()
+		if existing, err := v.Get(encryptionVaultName); err == nil && existing != "" {
+			fmt.Fprintln(os.Stderr, "error: a master key is already stored; use 'tokenman encryption rewrap' to replace it")
+			os.Exit(1)
+		}
+		if err := v.Set(encryptionVaultName, key); err != nil {
+			fmt.Fprintf(os.Stderr, "error storing master key: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Master key stored in the OS keychain. Enable encryption with:")
+		fmt.Println()
+		fmt.Println("  [security.encryption]")
+		fmt.Println("  enabled = true")
+		fmt.Printf("  key_ref = \"keyring://tokenman/%s\"\n", encryptionVaultName)
+
+	case "status":
+		cfg := loadConfigOrExit()
+		if !cfg.Security.Encryption.Enabled {
+			fmt.Println("Encryption at rest: disabled")
+			return
+		}
+		st := openEncryptedStoreOrExit(cfg)
+		defer st.Close()
+		active, count := st.DataKeys()
+		fmt.Println("Encryption at rest: enabled")
+		fmt.Printf("  Master key:      %s\n", cfg.Security.Encryption.KeyRef)
+		fmt.Printf("  Active data key: %d (%d total)\n", active, count)
+
+	case "rotate":
+		cfg := loadConfigOrExit()
+		if !cfg.Security.Encryption.Enabled {
+			fmt.Fprintln(os.Stderr, "error: encryption is not enabled (security.encryption.enabled)")
+			os.Exit(1)
+		}
+		st := openEncryptedStoreOrExit(cfg)
+		defer st.Close()
+
+		id, rows, err := st.RotateDataKey()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error rotating data key: %v\n", err)
+			os.Exit(1)
+		}
+		recordCLIEvent(st, audit.Event{
+			Kind:   audit.KindEncryptionRotated,
+			Action: "encryption rotate",
+			Detail: map[string]interface{}{"data_key": id, "rows": rows},
+		})
+		fmt.Printf("Created data key %d and re-encrypted %d rows\n", id, rows)
+
+	case "rewrap":
+		fs := flag.NewFlagSet("encryption rewrap", flag.ExitOnError)
+		newRef := fs.String("new-key-ref", "", "reference to the new master key (required)")
+		fs.Parse(args[1:])
+		if *newRef == "" {
+			fmt.Fprintln(os.Stderr, "error: --new-key-ref is required")
+			os.Exit(1)
+		}
+
+		cfg := loadConfigOrExit()
+		if !cfg.Security.Encryption.Enabled {
+			fmt.Fprintln(os.Stderr, "error: encryption is not enabled (security.encryption.enabled)")
+			os.Exit(1)
+		}
+		next, err := daemon.ResolveMasterKey(*newRef)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		st := openEncryptedStoreOrExit(cfg)
+		defer st.Close()
+
+		n, err := st.RewrapDataKeys(next)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error re-wrapping data keys: %v\n", err)
+			os.Exit(1)
+		}
+		recordCLIEvent(st, audit.Event{
+			Kind:   audit.KindEncryptionRotated,
+			Action: "encryption rewrap",
+			Detail: map[string]interface{}{"data_keys": n, "key_ref": *newRef},
+		})
+		fmt.Printf("Re-wrapped %d data keys with the new master key\n", n)
+		fmt.Printf("Set security.encryption.key_ref = %q and restart the daemon\n", *newRef)
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown encryption command: %s\n", args[0])
+		fmt.Println(encryptionUsage)
+		os.Exit(1)
+	}
+}
+
+func loadConfigOrExit() *config.Config {
+	cfg, err := config.Load("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	return cfg
+}
+
+func openEncryptedStoreOrExit(cfg *config.Config) *store.Store {
+	st, err := daemon.OpenStore(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error opening database: %v\n", err)
+		os.Exit(1)
+	}
+	return st
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/allaspectsdev/tokenman/internal/auth"
+)
+
+// cmdHashToken generates (or reads) a bearer token and prints the salted
+// hash to put in config, so the plaintext never has to be stored there.
+func cmdHashToken(args []string) {
+	fs := flag.NewFlagSet("hash-token", flag.ExitOnError)
+	name := fs.String("name", "", "dashboard token name (with --role)")
+	role := fs.String("role", "", "dashboard role: viewer, auditor, or admin; omit to hash the shared auth token")
+	fromStdin := fs.Bool("stdin", false, "hash a token read from stdin instead of generating one")
+	fs.Parse(args)
+
+	if *role != "" {
+		*role = strings.ToLower(*role)
+		if !slices.Contains(auth.ValidRoles, *role) {
+			fmt.Fprintf(os.Stderr, "error: --role must be one of %s\n", strings.Join(auth.ValidRoles, ", "))
+			os.Exit(1)
+		}
+		if *name == "" {
+			fmt.Fprintln(os.Stderr, "error: --name is required with --role")
+			os.Exit(1)
+		}
+	}
+
+	var token string
+	if *fromStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		token = strings.TrimSpace(line)
+		if token == "" {
+			fmt.Fprintf(os.Stderr, "error reading token: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		var err error
+		token, err = auth.GenerateDashboardToken()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	hash, err := auth.HashToken(token)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !*fromStdin {
+		fmt.Printf("Token: %s\n", token)
+		fmt.Println("Store it now; it cannot be recovered from the hash.")
+		fmt.Println()
+	}
+	fmt.Println("Add to your config:")
+	fmt.Println()
+	if *role == "" {
+		fmt.Println("[auth]")
+		fmt.Printf("token_hash = %q\n", hash)
+		return
+	}
+	fmt.Println("[[dashboard.tokens]]")
+	fmt.Printf("name = %q\n", *name)
+	fmt.Printf("role = %q\n", *role)
+	fmt.Printf("hash = %q\n", hash)
+}
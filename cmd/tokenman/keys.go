@@ -6,6 +6,8 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/allaspectsdev/tokenman/internal/audit"
+	"github.com/allaspectsdev/tokenman/internal/config"
 	"github.com/allaspectsdev/tokenman/internal/vault"
 	"golang.org/x/term"
 )
@@ -13,9 +15,15 @@ import (
 func cmdKeys(args []string) {
 	if len(args) == 0 {
 		fmt.Println("Usage: tokenman keys <list|set|delete> [provider]")
+		fmt.Println("       tokenman keys virtual <create|list|revoke> [options]")
 		os.Exit(1)
 	}
 
+	if args[0] == "virtual" {
+		cmdVirtualKeys(args[1:])
+		return
+	}
+
 	v := vault.New()
 
 	switch args[0] {
@@ -50,6 +58,7 @@ func cmdKeys(args []string) {
 			fmt.Fprintf(os.Stderr, "error storing key: %v\n", err)
 			os.Exit(1)
 		}
+		recordProviderKeyChange(audit.KindProviderKeySet, "keys set", provider)
 		fmt.Printf("Key for %s stored successfully\n", provider)
 
 	case "delete":
@@ -62,6 +71,7 @@ func cmdKeys(args []string) {
 			fmt.Fprintf(os.Stderr, "error deleting key: %v\n", err)
 			os.Exit(1)
 		}
+		recordProviderKeyChange(audit.KindProviderKeyDeleted, "keys delete", provider)
 		fmt.Printf("Key for %s deleted\n", provider)
 
 	default:
@@ -69,3 +79,17 @@ func cmdKeys(args []string) {
 		os.Exit(1)
 	}
 }
+
+// recordProviderKeyChange audits a change to a provider key in the vault.
+func recordProviderKeyChange(kind, action, provider string) {
+	cfg, err := config.Load("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to record audit event: %v\n", err)
+		return
+	}
+	recordCLIEventWithConfig(cfg, audit.Event{
+		Kind:   kind,
+		Action: action,
+		Detail: map[string]interface{}{"provider": provider},
+	})
+}
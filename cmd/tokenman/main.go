@@ -24,6 +24,14 @@ func main() {
 		cmdSetup(os.Args[2:])
 	case "keys":
 		cmdKeys(os.Args[2:])
+	case "policy":
+		cmdPolicy(os.Args[2:])
+	case "hash-token":
+		cmdHashToken(os.Args[2:])
+	case "audit":
+		cmdAudit(os.Args[2:])
+	case "encryption":
+		cmdEncryption(os.Args[2:])
 	case "init-config":
 		cmdInitConfig()
 	case "install-service":
@@ -52,6 +60,11 @@ Commands:
   status           Show daemon status and summary stats
   setup            Interactive setup wizard
   keys             Manage API keys (list|set|delete <provider>)
+  keys virtual     Manage virtual keys (create|list|revoke)
+  policy test      Dry-run a request JSON file against the request policy
+  hash-token       Generate a dashboard or shared token and print its hash
+  audit verify     Check the audit log's hash chain for tampering
+  encryption       Manage encryption at rest (init|status|rotate|rewrap)
   init-config      Generate default config file
   config-export    Export current config to a TOML file
   config-import    Import config from a TOML file
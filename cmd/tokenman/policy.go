@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/allaspectsdev/tokenman/internal/compress"
+	"github.com/allaspectsdev/tokenman/internal/config"
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+	"github.com/allaspectsdev/tokenman/internal/proxy"
+	"github.com/allaspectsdev/tokenman/internal/router"
+	"github.com/allaspectsdev/tokenman/internal/security"
+	"github.com/allaspectsdev/tokenman/internal/tokenizer"
+)
+
+const policyUsage = "Usage: tokenman policy test [options] <request.json|->"
+
+// cmdPolicy inspects the request policy configured in [security.policy].
+func cmdPolicy(args []string) {
+	if len(args) == 0 || args[0] != "test" {
+		fmt.Println(policyUsage)
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("policy test", flag.ExitOnError)
+	format := fs.String("format", "anthropic", "request body format: anthropic or openai")
+	project := fs.String("project", "default", "project the request is sent under (X-Tokenman-Project)")
+	keyID := fs.String("key-id", "", "virtual key ID the request is authenticated with")
+	keyOwner := fs.String("key-owner", "", "owner of the virtual key")
+	provider := fs.String("provider", "", "provider to evaluate against (default: resolved from the model)")
+	fs.Parse(args[1:])
+
+	if fs.NArg() != 1 {
+		fmt.Println(policyUsage)
+		os.Exit(1)
+	}
+
+	cfg, err := config.Load("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	var body []byte
+	if path := fs.Arg(0); path == "-" {
+		body, err = io.ReadAll(os.Stdin)
+	} else {
+		body, err = os.ReadFile(path)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading request: %v\n", err)
+		os.Exit(1)
+	}
+
+	var req *pipeline.Request
+	switch *format {
+	case "anthropic":
+		req, err = proxy.ParseAnthropicRequest(body)
+	case "openai":
+		req, err = proxy.ParseOpenAIRequest(body)
+	default:
+		fmt.Fprintln(os.Stderr, "error: --format must be anthropic or openai")
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error parsing request: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Routing is only needed to name providers, so no API keys are resolved.
+	providers := make(map[string]*router.ProviderConfig)
+	for name, pcfg := range cfg.Providers {
+		if !pcfg.Enabled {
+			continue
+		}
+		providers[name] = &router.ProviderConfig{
+			Name:     pcfg.Name,
+			BaseURL:  pcfg.APIBase,
+			Models:   pcfg.Models,
+			Enabled:  true,
+			Priority: pcfg.Priority,
+		}
+	}
+	rtr := router.NewRouter(providers, cfg.Routing.ModelMap, cfg.Routing.DefaultProvider, cfg.Routing.FallbackEnabled)
+	resolve := func(model string) string {
+		if p, err := rtr.Resolve(model); err == nil {
+			return p.Name
+		}
+		return ""
+	}
+
+	req.ID = "dry-run"
+	req.Project = *project
+	req.KeyID = *keyID
+	req.KeyOwner = *keyOwner
+	if req.Metadata == nil {
+		req.Metadata = make(map[string]interface{})
+	}
+	if *provider != "" {
+		req.Metadata["provider"] = *provider
+	} else if p := resolve(req.Model); p != "" {
+		req.Metadata["provider"] = p
+	}
+	var msgs []tokenizer.Message
+	for _, m := range req.Messages {
+		msgs = append(msgs, tokenizer.Message{Role: m.Role, Content: compress.ExtractText(m.Content)})
+	}
+	req.TokensIn = tokenizer.New().CountMessages(req.Model, msgs)
+
+	if !cfg.Security.Policy.Enabled {
+		fmt.Println("note: security.policy.enabled is false; showing what the rules would do if enabled")
+	}
+
+	decisions, evalErr := security.NewPolicyEngine(cfg.Security.Policy, resolve).Evaluate(req)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RULE\tACTION\tDETAIL")
+	for _, d := range decisions {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", d.Rule, d.Action, d.Detail)
+	}
+	w.Flush()
+	fmt.Println()
+
+	var policyErr *security.PolicyError
+	if errors.As(evalErr, &policyErr) {
+		fmt.Printf("Result:      denied by %q: %s\n", policyErr.Rule, policyErr.Message)
+		os.Exit(2)
+	}
+
+	providerName, _ := req.Metadata["provider"].(string)
+	fmt.Println("Result:      allowed")
+	fmt.Printf("Model:       %s\n", req.Model)
+	fmt.Printf("Provider:    %s\n", providerName)
+	fmt.Printf("Max tokens:  %d\n", req.MaxTokens)
+	if req.Temperature != nil {
+		fmt.Printf("Temperature: %g\n", *req.Temperature)
+	}
+}
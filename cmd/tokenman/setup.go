@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/allaspectsdev/tokenman/internal/audit"
 	"github.com/allaspectsdev/tokenman/internal/config"
 	"github.com/allaspectsdev/tokenman/internal/daemon"
 )
@@ -108,9 +109,23 @@ func cmdConfigImport(args []string) {
 		fmt.Fprintln(os.Stderr, "usage: tokenman config-import <file>")
 		os.Exit(1)
 	}
+	old, err := config.Load("")
+	if err != nil {
+		old = config.DefaultConfig()
+	}
 	if err := config.ImportConfig(args[0]); err != nil {
 		fmt.Fprintf(os.Stderr, "error importing config: %v\n", err)
 		os.Exit(1)
 	}
+	imported := config.Get()
+	changes, err := config.Diff(old, imported)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to diff imported config: %v\n", err)
+	}
+	recordCLIEventWithConfig(imported, audit.Event{
+		Kind:   audit.KindConfigImported,
+		Action: "config-import",
+		Detail: map[string]interface{}{"file": args[0], "changes": changes},
+	})
 	fmt.Printf("Config imported from %s\n", args[0])
 }
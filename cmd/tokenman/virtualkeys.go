@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/allaspectsdev/tokenman/internal/audit"
+	"github.com/allaspectsdev/tokenman/internal/auth"
+	"github.com/allaspectsdev/tokenman/internal/config"
+	"github.com/allaspectsdev/tokenman/internal/daemon"
+	"github.com/allaspectsdev/tokenman/internal/store"
+)
+
+const virtualKeysUsage = "Usage: tokenman keys virtual <create|list|revoke> [options]"
+
+// cmdVirtualKeys manages tokenman-issued virtual keys stored in the database.
+func cmdVirtualKeys(args []string) {
+	if len(args) == 0 {
+		fmt.Println(virtualKeysUsage)
+		os.Exit(1)
+	}
+
+	cfg, err := config.Load("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	st, err := store.Open(daemon.DBPath(cfg))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error opening database: %v\n", err)
+		os.Exit(1)
+	}
+	defer st.Close()
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("keys virtual create", flag.ExitOnError)
+		name := fs.String("name", "", "human-readable key name (required)")
+		owner := fs.String("owner", "", "team or user the key belongs to")
+		scopes := fs.String("scopes", auth.ScopeProxy, "comma-separated scopes: "+strings.Join(auth.ValidScopes, ", "))
+		models := fs.String("models", "", "comma-separated allowed models; globs like claude-* are accepted")
+		priority := fs.String("priority", "", "scheduler priority class: "+strings.Join(config.ValidPriorityClasses, ", ")+" (default: from project or header)")
+		expires := fs.String("expires", "", "lifetime such as 30d or 12h (default: never)")
+		fs.Parse(args[1:])
+
+		if *name == "" {
+			fmt.Fprintln(os.Stderr, "error: --name is required")
+			os.Exit(1)
+		}
+		if *priority != "" && !config.IsPriorityClass(*priority) {
+			fmt.Fprintf(os.Stderr, "error: --priority must be one of %s\n", strings.Join(config.ValidPriorityClasses, ", "))
+			os.Exit(1)
+		}
+		scopeList, err := auth.ParseScopes(*scopes)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		var expiresAt time.Time
+		if *expires != "" {
+			ttl, err := auth.ParseTTL(*expires)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			expiresAt = time.Now().Add(ttl)
+		}
+		var modelList []string
+		for _, m := range strings.Split(*models, ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				modelList = append(modelList, m)
+			}
+		}
+
+		plaintext, k, err := st.CreateVirtualKey(*name, *owner, scopeList, modelList, *priority, expiresAt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error creating key: %v\n", err)
+			os.Exit(1)
+		}
+		recordCLIEvent(st, audit.Event{
+			Kind:   audit.KindKeyCreated,
+			Action: "keys virtual create",
+			Detail: map[string]interface{}{"key_id": k.ID, "name": k.Name, "owner": k.Owner, "scopes": k.Scopes},
+		})
+		fmt.Printf("Created virtual key %s (%s)\n", k.ID, k.Name)
+		fmt.Printf("  key:    %s\n", plaintext)
+		fmt.Printf("  scopes: %s\n", strings.Join(k.Scopes, ", "))
+		if k.Priority != "" {
+			fmt.Printf("  priority: %s\n", k.Priority)
+		}
+		if k.ExpiresAt != "" {
+			fmt.Printf("  expires: %s\n", k.ExpiresAt)
+		}
+		fmt.Println("Store this key now; it cannot be shown again.")
+
+	case "list":
+		keys, err := st.ListVirtualKeys()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error listing keys: %v\n", err)
+			os.Exit(1)
+		}
+		if len(keys) == 0 {
+			fmt.Println("No virtual keys")
+			return
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tOWNER\tPREFIX\tSCOPES\tMODELS\tPRIORITY\tEXPIRES\tSTATUS")
+		for _, k := range keys {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s…\t%s\t%s\t%s\t%s\t%s\n",
+				k.ID, k.Name, orDash(k.Owner), k.KeyPrefix,
+				strings.Join(k.Scopes, ","), orDash(strings.Join(k.AllowedModels, ",")),
+				orDash(k.Priority), orDash(k.ExpiresAt), virtualKeyStatus(k))
+		}
+		tw.Flush()
+
+	case "revoke":
+		if len(args) < 2 {
+			fmt.Println("Usage: tokenman keys virtual revoke <id>")
+			os.Exit(1)
+		}
+		if err := st.RevokeVirtualKey(args[1]); err != nil {
+			fmt.Fprintf(os.Stderr, "error revoking key: %v\n", err)
+			os.Exit(1)
+		}
+		recordCLIEvent(st, audit.Event{
+			Kind:   audit.KindKeyRevoked,
+			Action: "keys virtual revoke",
+			Detail: map[string]interface{}{"key_id": args[1]},
+		})
+		fmt.Printf("Virtual key %s revoked\n", args[1])
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown keys virtual command: %s\n", args[0])
+		os.Exit(1)
+	}
+}
+
+// virtualKeyStatus summarizes whether a key is usable.
+func virtualKeyStatus(k *store.VirtualKey) string {
+	if k.RevokedAt != "" {
+		return "revoked"
+	}
+	if k.ExpiresAt != "" {
+		if t, err := time.Parse(time.RFC3339, k.ExpiresAt); err == nil && !time.Now().Before(t) {
+			return "expired"
+		}
+	}
+	return "active"
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,169 @@
+// Package alert delivers operational alerts — budget thresholds, circuit
+// breaker trips, PII blocks — to a persistent alert log and to configurable
+// sinks such as webhooks, Slack, a local command, or desktop notifications.
+package alert
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Alert kinds.
+const (
+	KindBudgetThreshold = "budget_threshold"
+	KindCircuitOpen     = "circuit_open"
+	KindPIIBlocked      = "pii_blocked"
+)
+
+// Severities.
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// queueSize bounds the number of alerts waiting for delivery. Alerts beyond
+// it are dropped rather than blocking the request path.
+const queueSize = 256
+
+// sendTimeout bounds delivery to a single sink.
+const sendTimeout = 10 * time.Second
+
+// maxSeen bounds the in-memory dedup set; it is reset when full and the
+// store's unique dedup key takes over.
+const maxSeen = 10000
+
+// Alert is a single notification.
+type Alert struct {
+	Kind     string                 `json:"kind"`
+	Severity string                 `json:"severity"`
+	Title    string                 `json:"title"`
+	Message  string                 `json:"message"`
+	Fields   map[string]interface{} `json:"fields,omitempty"`
+	// DedupKey makes an alert fire at most once; alerts with the same
+	// non-empty key are recorded and delivered only the first time.
+	DedupKey  string    `json:"dedup_key,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Notifier accepts alerts for delivery. Components that raise alerts hold a
+// Notifier, which may be nil when alerting is disabled.
+type Notifier interface {
+	Notify(a Alert)
+}
+
+// Sink delivers alerts to one destination.
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, a Alert) error
+}
+
+// Store persists alerts. RecordAlert returns false without error when an
+// alert with the same dedup key was already recorded.
+type Store interface {
+	RecordAlert(a Alert) (bool, error)
+}
+
+// Dispatcher records alerts and fans them out to sinks on a background
+// goroutine so raising an alert never blocks a request.
+type Dispatcher struct {
+	store Store
+	sinks []Sink
+	queue chan Alert
+
+	mu     sync.Mutex
+	seen   map[string]bool
+	closed bool
+
+	done chan struct{}
+}
+
+// Compile-time assertion that Dispatcher implements Notifier.
+var _ Notifier = (*Dispatcher)(nil)
+
+// NewDispatcher creates a Dispatcher and starts its delivery goroutine.
+// store may be nil, in which case alerts are deduplicated in memory only.
+func NewDispatcher(store Store, sinks ...Sink) *Dispatcher {
+	d := &Dispatcher{
+		store: store,
+		sinks: sinks,
+		queue: make(chan Alert, queueSize),
+		seen:  make(map[string]bool),
+		done:  make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+// Notify queues an alert. Alerts whose dedup key has already been seen are
+// ignored, and alerts are dropped with a warning if the queue is full.
+func (d *Dispatcher) Notify(a Alert) {
+	if a.Timestamp.IsZero() {
+		a.Timestamp = time.Now().UTC()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	if a.DedupKey != "" {
+		if d.seen[a.DedupKey] {
+			return
+		}
+		if len(d.seen) >= maxSeen {
+			d.seen = make(map[string]bool)
+		}
+		d.seen[a.DedupKey] = true
+	}
+
+	select {
+	case d.queue <- a:
+	default:
+		log.Warn().Str("kind", a.Kind).Str("title", a.Title).Msg("alert queue full, dropping alert")
+	}
+}
+
+// Close stops accepting alerts and waits for queued alerts to be delivered.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	close(d.queue)
+	d.mu.Unlock()
+	<-d.done
+}
+
+// run records and delivers queued alerts until the queue is closed.
+func (d *Dispatcher) run() {
+	defer close(d.done)
+	for a := range d.queue {
+		if d.store != nil {
+			fresh, err := d.store.RecordAlert(a)
+			if err != nil {
+				log.Error().Err(err).Str("kind", a.Kind).Msg("failed to record alert")
+			} else if !fresh {
+				// Already fired in an earlier run of the process.
+				continue
+			}
+		}
+		d.deliver(a)
+	}
+}
+
+// deliver sends a to every sink, logging failures.
+func (d *Dispatcher) deliver(a Alert) {
+	for _, s := range d.sinks {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		if err := s.Send(ctx, a); err != nil {
+			log.Warn().Err(err).Str("sink", s.Name()).Str("kind", a.Kind).Msg("alert delivery failed")
+		}
+		cancel()
+	}
+}
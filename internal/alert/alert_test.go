@@ -0,0 +1,212 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingSink collects every alert it receives.
+type recordingSink struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (r *recordingSink) Name() string { return "recording" }
+
+func (r *recordingSink) Send(_ context.Context, a Alert) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts = append(r.alerts, a)
+	return nil
+}
+
+// memStore is an in-memory Store keyed by dedup key.
+type memStore struct {
+	mu   sync.Mutex
+	seen map[string]bool
+	all  []Alert
+}
+
+func (m *memStore) RecordAlert(a Alert) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if a.DedupKey != "" {
+		if m.seen[a.DedupKey] {
+			return false, nil
+		}
+		m.seen[a.DedupKey] = true
+	}
+	m.all = append(m.all, a)
+	return true, nil
+}
+
+func TestDispatcher_DeduplicatesAndRecords(t *testing.T) {
+	st := &memStore{seen: map[string]bool{"budget/already-fired": true}}
+	sink := &recordingSink{}
+	d := NewDispatcher(st, sink)
+
+	d.Notify(Alert{Kind: KindBudgetThreshold, Title: "50%", DedupKey: "budget/daily/50"})
+	d.Notify(Alert{Kind: KindBudgetThreshold, Title: "50% again", DedupKey: "budget/daily/50"})
+	d.Notify(Alert{Kind: KindBudgetThreshold, Title: "from last run", DedupKey: "budget/already-fired"})
+	d.Notify(Alert{Kind: KindCircuitOpen, Title: "trip 1"})
+	d.Notify(Alert{Kind: KindCircuitOpen, Title: "trip 2"})
+	d.Close()
+
+	// Notify after Close is a no-op.
+	d.Notify(Alert{Kind: KindCircuitOpen, Title: "late"})
+
+	var titles []string
+	for _, a := range sink.alerts {
+		titles = append(titles, a.Title)
+		if a.Timestamp.IsZero() {
+			t.Errorf("alert %q has no timestamp", a.Title)
+		}
+	}
+	if got, want := strings.Join(titles, ","), "50%,trip 1,trip 2"; got != want {
+		t.Errorf("delivered = %s, want %s", got, want)
+	}
+	if len(st.all) != 3 {
+		t.Errorf("recorded %d alerts, want 3", len(st.all))
+	}
+}
+
+func TestWebhookSink_PostsAlertJSON(t *testing.T) {
+	var (
+		got    Alert
+		header string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("X-Token")
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Content-Type = %q", r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	sink, err := NewSink(SinkConfig{Type: SinkWebhook, URL: srv.URL, Headers: map[string]string{"X-Token": "secret"}})
+	if err != nil {
+		t.Fatalf("NewSink: %v", err)
+	}
+	a := Alert{
+		Kind:     KindBudgetThreshold,
+		Severity: SeverityCritical,
+		Title:    "daily budget at 90%",
+		Fields:   map[string]interface{}{"limit": 10.0},
+	}
+	if err := sink.Send(context.Background(), a); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if got.Kind != a.Kind || got.Title != a.Title || got.Fields["limit"] != 10.0 {
+		t.Errorf("received %+v", got)
+	}
+	if header != "secret" {
+		t.Errorf("X-Token = %q, want secret", header)
+	}
+}
+
+func TestWebhookSink_ErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	sink := &WebhookSink{URL: srv.URL}
+	if err := sink.Send(context.Background(), Alert{Kind: KindCircuitOpen}); err == nil {
+		t.Error("expected error for 500 response")
+	}
+}
+
+func TestSlackSink_Payload(t *testing.T) {
+	var payload map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	sink := &SlackSink{URL: srv.URL}
+	err := sink.Send(context.Background(), Alert{
+		Severity: SeverityWarning,
+		Title:    "Request blocked for PII",
+		Message:  "Request abc contains EMAIL.",
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if len(payload) != 1 {
+		t.Errorf("payload has keys %v, want only text", payload)
+	}
+	want := ":warning: *Request blocked for PII*\nRequest abc contains EMAIL."
+	if payload["text"] != want {
+		t.Errorf("text = %q, want %q", payload["text"], want)
+	}
+}
+
+func TestCommandSink_ReceivesAlert(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	dir := t.TempDir()
+	sink := &CommandSink{Command: []string{"sh", "-c", `cat > "$0/alert.json"; printf %s "$TOKENMAN_ALERT_KIND" > "$0/kind"`, dir}}
+
+	if err := sink.Send(context.Background(), Alert{Kind: KindCircuitOpen, Title: "Circuit open for openai"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	var got Alert
+	data, err := os.ReadFile(filepath.Join(dir, "alert.json"))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("stdin was not alert JSON: %v", err)
+	}
+	if got.Title != "Circuit open for openai" {
+		t.Errorf("stdin title = %q", got.Title)
+	}
+	kind, err := os.ReadFile(filepath.Join(dir, "kind"))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(kind) != KindCircuitOpen {
+		t.Errorf("TOKENMAN_ALERT_KIND = %q, want %q", kind, KindCircuitOpen)
+	}
+}
+
+func TestFilterKinds(t *testing.T) {
+	rec := &recordingSink{}
+	sink := FilterKinds(rec, []string{KindCircuitOpen})
+
+	_ = sink.Send(context.Background(), Alert{Kind: KindBudgetThreshold})
+	_ = sink.Send(context.Background(), Alert{Kind: KindCircuitOpen})
+
+	if len(rec.alerts) != 1 || rec.alerts[0].Kind != KindCircuitOpen {
+		t.Errorf("delivered %+v, want only circuit_open", rec.alerts)
+	}
+	if sink.Name() != "recording" {
+		t.Errorf("Name() = %q, want wrapped sink name", sink.Name())
+	}
+}
+
+func TestNewSink_Errors(t *testing.T) {
+	if _, err := NewSink(SinkConfig{Type: "pager"}); err == nil {
+		t.Error("expected error for unknown sink type")
+	}
+	if _, err := NewSink(SinkConfig{Type: SinkCommand}); err == nil {
+		t.Error("expected error for command sink without command")
+	}
+}
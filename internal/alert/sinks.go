@@ -0,0 +1,218 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Sink types accepted by NewSink.
+const (
+	SinkWebhook = "webhook"
+	SinkSlack   = "slack"
+	SinkCommand = "command"
+	SinkDesktop = "desktop"
+)
+
+// SinkConfig describes one configured sink.
+type SinkConfig struct {
+	Type    string
+	URL     string            // webhook and slack
+	Headers map[string]string // webhook
+	Command []string          // command: program and arguments
+	Kinds   []string          // deliver only these alert kinds; empty means all
+}
+
+// NewSink builds a sink from its configuration.
+func NewSink(cfg SinkConfig) (Sink, error) {
+	var s Sink
+	switch cfg.Type {
+	case SinkWebhook:
+		s = &WebhookSink{URL: cfg.URL, Headers: cfg.Headers, Client: http.DefaultClient}
+	case SinkSlack:
+		s = &SlackSink{URL: cfg.URL, Client: http.DefaultClient}
+	case SinkCommand:
+		if len(cfg.Command) == 0 {
+			return nil, fmt.Errorf("alert: command sink requires a command")
+		}
+		s = &CommandSink{Command: cfg.Command}
+	case SinkDesktop:
+		s = &DesktopSink{}
+	default:
+		return nil, fmt.Errorf("alert: unknown sink type %q", cfg.Type)
+	}
+	if len(cfg.Kinds) > 0 {
+		s = FilterKinds(s, cfg.Kinds)
+	}
+	return s, nil
+}
+
+// WebhookSink POSTs each alert as JSON to a URL.
+type WebhookSink struct {
+	URL     string
+	Headers map[string]string
+	Client  *http.Client
+}
+
+// Name returns the sink name.
+func (w *WebhookSink) Name() string { return SinkWebhook }
+
+// Send posts the alert.
+func (w *WebhookSink) Send(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("alert: marshal webhook payload: %w", err)
+	}
+	return postJSON(ctx, w.Client, w.URL, w.Headers, body)
+}
+
+// SlackSink posts alerts to a Slack incoming webhook (or any endpoint that
+// accepts Slack's {"text": ...} payload).
+type SlackSink struct {
+	URL    string
+	Client *http.Client
+}
+
+// Name returns the sink name.
+func (s *SlackSink) Name() string { return SinkSlack }
+
+// Send posts the alert as a Slack message.
+func (s *SlackSink) Send(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(map[string]string{"text": slackText(a)})
+	if err != nil {
+		return fmt.Errorf("alert: marshal slack payload: %w", err)
+	}
+	return postJSON(ctx, s.Client, s.URL, nil, body)
+}
+
+// slackText formats an alert using Slack mrkdwn.
+func slackText(a Alert) string {
+	icon := ":information_source:"
+	switch a.Severity {
+	case SeverityWarning:
+		icon = ":warning:"
+	case SeverityCritical:
+		icon = ":rotating_light:"
+	}
+	return fmt.Sprintf("%s *%s*\n%s", icon, a.Title, a.Message)
+}
+
+// postJSON sends body to url and treats any non-2xx status as an error.
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("alert: build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("alert: post: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("alert: post: unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// CommandSink runs a local program for each alert. The alert is written to
+// the program's stdin as JSON, and its kind, severity, title, and message
+// are also passed as TOKENMAN_ALERT_* environment variables.
+type CommandSink struct {
+	Command []string
+}
+
+// Name returns the sink name.
+func (c *CommandSink) Name() string { return SinkCommand }
+
+// Send runs the command.
+func (c *CommandSink) Send(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("alert: marshal command payload: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, c.Command[0], c.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"TOKENMAN_ALERT_KIND="+a.Kind,
+		"TOKENMAN_ALERT_SEVERITY="+a.Severity,
+		"TOKENMAN_ALERT_TITLE="+a.Title,
+		"TOKENMAN_ALERT_MESSAGE="+a.Message,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("alert: command %s: %w: %s", c.Command[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// DesktopSink shows a desktop notification using osascript on macOS or
+// notify-send on Linux.
+type DesktopSink struct{}
+
+// Name returns the sink name.
+func (d *DesktopSink) Name() string { return SinkDesktop }
+
+// Send shows the notification.
+func (d *DesktopSink) Send(ctx context.Context, a Alert) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(a.Message), appleScriptString("TokenMan: "+a.Title))
+		cmd = exec.CommandContext(ctx, "osascript", "-e", script)
+	case "linux":
+		urgency := "normal"
+		if a.Severity == SeverityCritical {
+			urgency = "critical"
+		}
+		cmd = exec.CommandContext(ctx, "notify-send", "-u", urgency, "TokenMan: "+a.Title, a.Message)
+	default:
+		return fmt.Errorf("alert: desktop notifications are not supported on %s", runtime.GOOS)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("alert: desktop notification: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// appleScriptString quotes s as an AppleScript string literal.
+func appleScriptString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// kindFilter forwards only selected alert kinds to the wrapped sink.
+type kindFilter struct {
+	Sink
+	kinds map[string]bool
+}
+
+// FilterKinds wraps s so it only receives alerts of the given kinds.
+func FilterKinds(s Sink, kinds []string) Sink {
+	set := make(map[string]bool, len(kinds))
+	for _, k := range kinds {
+		set[k] = true
+	}
+	return &kindFilter{Sink: s, kinds: set}
+}
+
+// Send forwards a if its kind is selected.
+func (f *kindFilter) Send(ctx context.Context, a Alert) error {
+	if !f.kinds[a.Kind] {
+		return nil
+	}
+	return f.Sink.Send(ctx, a)
+}
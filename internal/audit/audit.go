@@ -0,0 +1,57 @@
+// Package audit defines the security and administrative events written to
+// tokenman's tamper-evident audit log. Producers describe what happened as
+// an Event; the store assigns timestamps and links each row to the previous
+// one with a hash chain.
+package audit
+
+import (
+	"github.com/rs/zerolog/log"
+)
+
+// Event kinds.
+const (
+	KindAuthDenied         = "auth_denied"
+	KindPIIDetected        = "pii_detected"
+	KindInjectionDetected  = "injection_detected"
+	KindPolicyAction       = "policy_action"
+	KindBudgetBlocked      = "budget_blocked"
+	KindBudgetReset        = "budget_reset"
+	KindCachePurged        = "cache_purged"
+	KindConfigReloaded     = "config_reloaded"
+	KindConfigImported     = "config_imported"
+	KindKeyCreated         = "key_created"
+	KindKeyRevoked         = "key_revoked"
+	KindProviderKeySet     = "provider_key_set"
+	KindProviderKeyDeleted = "provider_key_deleted"
+	KindEncryptionRotated  = "encryption_key_rotated"
+)
+
+// Event is a single audit log entry.
+type Event struct {
+	Kind string
+	// Actor identifies who caused the event: a virtual key ID, a dashboard
+	// token name, "cli:<user>", or empty when the caller is unknown.
+	Actor string
+	// Action is a short description of what was done, such as "block",
+	// "POST /api/cache/purge", or "reload".
+	Action     string
+	RemoteAddr string
+	// Detail holds structured, non-secret context for the event.
+	Detail map[string]interface{}
+}
+
+// Recorder persists audit events.
+type Recorder interface {
+	Record(e Event) error
+}
+
+// Record writes e to r, logging rather than returning failures so that an
+// audit outage never fails the operation being audited. A nil r is a no-op.
+func Record(r Recorder, e Event) {
+	if r == nil {
+		return
+	}
+	if err := r.Record(e); err != nil {
+		log.Warn().Err(err).Str("kind", e.Kind).Msg("failed to record audit event")
+	}
+}
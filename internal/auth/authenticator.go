@@ -0,0 +1,348 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Errors returned by Authenticate.
+var (
+	ErrMissingToken = errors.New("authentication required")
+	ErrInvalidToken = errors.New("invalid token")
+	ErrKeyExpired   = errors.New("key expired")
+	ErrKeyRevoked   = errors.New("key revoked")
+)
+
+// touchInterval limits how often a key's last-used time is written back.
+const touchInterval = time.Minute
+
+// maxRejectedTokens bounds how many tokens that matched no hashed token are
+// remembered. When the set is full it is cleared and starts over.
+const maxRejectedTokens = 10_000
+
+// KeyStore looks up virtual keys by hash. LookupVirtualKey returns nil and
+// no error when no key has the given hash.
+type KeyStore interface {
+	LookupVirtualKey(hash string) (*KeyRecord, error)
+	TouchVirtualKey(id string, at time.Time) error
+}
+
+// HashedToken is a bearer token configured as a salted hash (see HashToken)
+// rather than in plaintext.
+type HashedToken struct {
+	Name   string
+	Scopes []string
+	Hash   string
+}
+
+// Denial describes a rejected authentication or authorization attempt.
+type Denial struct {
+	Actor      string // key ID or token name; empty if the caller did not authenticate
+	Method     string
+	Path       string
+	RemoteAddr string
+	Status     int
+	Reason     string
+}
+
+// DenialRecorder records rejected attempts, for example to an audit log.
+type DenialRecorder interface {
+	RecordDenial(d Denial) error
+}
+
+// hashedEntry is a parsed HashedToken.
+type hashedEntry struct {
+	id   *Identity
+	hash *parsedTokenHash
+}
+
+// Authenticator validates bearer tokens against the shared auth token,
+// hashed tokens from config, and the virtual key store.
+type Authenticator struct {
+	token []byte
+	keys  KeyStore
+
+	mu          sync.Mutex
+	lastTouched map[string]time.Time
+
+	// tokensMu guards hashed, verified, rejected, and generation. verified
+	// maps HashKey(token) to the identity of hashed tokens that have already
+	// been checked, and rejected holds the HashKey of tokens that matched
+	// none, so the slow hash is computed once per token rather than once per
+	// request, including for callers retrying a bad token. generation counts
+	// SetHashedTokens calls so a result for replaced tokens is not cached.
+	tokensMu   sync.RWMutex
+	hashed     []hashedEntry
+	verified   map[string]*Identity
+	rejected   map[string]struct{}
+	generation uint64
+
+	recorder DenialRecorder
+}
+
+// NewAuthenticator creates an Authenticator. token is the shared auth token
+// (empty disables it) and keys may be nil when virtual keys are not in use.
+func NewAuthenticator(token string, keys KeyStore) *Authenticator {
+	return &Authenticator{
+		token:       []byte(token),
+		keys:        keys,
+		lastTouched: make(map[string]time.Time),
+		verified:    make(map[string]*Identity),
+		rejected:    make(map[string]struct{}),
+	}
+}
+
+// SetHashedTokens replaces the hashed tokens the authenticator accepts. It
+// returns an error, and changes nothing, if any hash is malformed.
+func (a *Authenticator) SetHashedTokens(tokens []HashedToken) error {
+	entries := make([]hashedEntry, 0, len(tokens))
+	for _, t := range tokens {
+		h, err := parseTokenHash(t.Hash)
+		if err != nil {
+			return fmt.Errorf("token %q: %w", t.Name, err)
+		}
+		entries = append(entries, hashedEntry{
+			id:   &Identity{Name: t.Name, Scopes: t.Scopes},
+			hash: h,
+		})
+	}
+
+	a.tokensMu.Lock()
+	a.hashed = entries
+	a.verified = make(map[string]*Identity)
+	a.rejected = make(map[string]struct{})
+	a.generation++
+	a.tokensMu.Unlock()
+	return nil
+}
+
+// SetDenialRecorder configures where rejected attempts are recorded.
+func (a *Authenticator) SetDenialRecorder(r DenialRecorder) {
+	a.recorder = r
+}
+
+// authenticateHashed checks token against the hashed tokens, returning nil
+// if none matches.
+func (a *Authenticator) authenticateHashed(token string) *Identity {
+	digest := HashKey(token)
+	a.tokensMu.RLock()
+	id, ok := a.verified[digest]
+	_, rejected := a.rejected[digest]
+	entries, generation := a.hashed, a.generation
+	a.tokensMu.RUnlock()
+	if ok {
+		return id
+	}
+	if rejected || len(entries) == 0 {
+		return nil
+	}
+
+	for _, e := range entries {
+		if e.hash.verify(token) {
+			a.tokensMu.Lock()
+			if a.generation == generation {
+				a.verified[digest] = e.id
+			}
+			a.tokensMu.Unlock()
+			return e.id
+		}
+	}
+	a.tokensMu.Lock()
+	if a.generation == generation {
+		if len(a.rejected) >= maxRejectedTokens {
+			a.rejected = make(map[string]struct{})
+		}
+		a.rejected[digest] = struct{}{}
+	}
+	a.tokensMu.Unlock()
+	return nil
+}
+
+// Authenticate resolves a bearer token to an identity.
+func (a *Authenticator) Authenticate(token string) (*Identity, error) {
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+	if len(a.token) > 0 && subtle.ConstantTimeCompare([]byte(token), a.token) == 1 {
+		return sharedIdentity, nil
+	}
+	if !strings.HasPrefix(token, KeyPrefix) {
+		if id := a.authenticateHashed(token); id != nil {
+			return id, nil
+		}
+		return nil, ErrInvalidToken
+	}
+	if a.keys == nil {
+		return nil, ErrInvalidToken
+	}
+
+	rec, err := a.keys.LookupVirtualKey(HashKey(token))
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, ErrInvalidToken
+	}
+	now := time.Now().UTC()
+	if rec.Revoked() {
+		return nil, ErrKeyRevoked
+	}
+	if rec.Expired(now) {
+		return nil, ErrKeyExpired
+	}
+
+	a.touch(rec.ID, now)
+
+	return keyIdentity(rec), nil
+}
+
+// keyIdentity returns the identity of a virtual key.
+func keyIdentity(rec *KeyRecord) *Identity {
+	return &Identity{
+		KeyID:         rec.ID,
+		Name:          rec.Name,
+		Owner:         rec.Owner,
+		Scopes:        rec.Scopes,
+		AllowedModels: rec.AllowedModels,
+		Priority:      rec.Priority,
+	}
+}
+
+// touch records key use, writing at most once per touchInterval per key.
+func (a *Authenticator) touch(id string, now time.Time) {
+	a.mu.Lock()
+	if now.Sub(a.lastTouched[id]) < touchInterval {
+		a.mu.Unlock()
+		return
+	}
+	a.lastTouched[id] = now
+	a.mu.Unlock()
+
+	if err := a.keys.TouchVirtualKey(id, now); err != nil {
+		log.Warn().Err(err).Str("key_id", id).Msg("failed to record virtual key use")
+	}
+}
+
+// Middleware returns an HTTP middleware that requires a bearer token holding
+// scope. Missing tokens get 401; invalid, expired, revoked, or
+// under-scoped tokens get 403. The identity is stored in the request context.
+// A request without a bearer token that already carries an identity, such
+// as one from a client certificate, only needs that identity to hold scope.
+// Gemini SDKs, which send their key in x-goog-api-key, may pass the token
+// there instead.
+func (a *Authenticator) Middleware(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := requestToken(r)
+			if !ok {
+				if id := IdentityFromContext(r.Context()); id != nil {
+					if !id.HasScope(scope) {
+						a.deny(w, r, id, http.StatusForbidden, "identity lacks scope "+scope)
+						return
+					}
+					next.ServeHTTP(w, r)
+					return
+				}
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				a.deny(w, r, nil, http.StatusUnauthorized, ErrMissingToken.Error())
+				return
+			}
+
+			id, err := a.Authenticate(token)
+			switch {
+			case errors.Is(err, ErrMissingToken):
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				a.deny(w, r, nil, http.StatusUnauthorized, err.Error())
+				return
+			case errors.Is(err, ErrInvalidToken), errors.Is(err, ErrKeyExpired), errors.Is(err, ErrKeyRevoked):
+				a.deny(w, r, nil, http.StatusForbidden, err.Error())
+				return
+			case err != nil:
+				log.Error().Err(err).Msg("virtual key lookup failed")
+				writeError(w, http.StatusInternalServerError, "authentication unavailable")
+				return
+			}
+
+			if !id.HasScope(scope) {
+				a.deny(w, r, id, http.StatusForbidden, "key lacks scope "+scope)
+				return
+			}
+			// Keep the project a client certificate attributes the
+			// connection to.
+			if cert := IdentityFromContext(r.Context()); cert != nil && cert.Project != "" && id.Project == "" {
+				withProject := *id
+				withProject.Project = cert.Project
+				id = &withProject
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+		})
+	}
+}
+
+// requestToken returns the token a request authenticates with: a bearer
+// token, or else an x-goog-api-key header.
+func requestToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	if authHeader := r.Header.Get("Authorization"); strings.HasPrefix(authHeader, prefix) {
+		return strings.TrimPrefix(authHeader, prefix), true
+	}
+	if key := r.Header.Get("X-Goog-Api-Key"); key != "" {
+		return key, true
+	}
+	return "", false
+}
+
+// deny writes an error response and records the rejected attempt. id is
+// the caller's identity when it authenticated but lacked a scope.
+func (a *Authenticator) deny(w http.ResponseWriter, r *http.Request, id *Identity, status int, reason string) {
+	writeError(w, status, reason)
+	if a.recorder == nil {
+		return
+	}
+	d := Denial{
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		RemoteAddr: r.RemoteAddr,
+		Status:     status,
+		Reason:     reason,
+	}
+	if id != nil {
+		d.Actor = id.Actor()
+	}
+	if err := a.recorder.RecordDenial(d); err != nil {
+		log.Warn().Err(err).Str("path", d.Path).Msg("failed to record denied request")
+	}
+}
+
+// writeError writes a JSON error body in the shape used by the proxy and
+// dashboard auth responses.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// identityKey is the context key for the authenticated identity.
+type identityKey struct{}
+
+// WithIdentity stores id in the context.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the authenticated identity, or nil when the
+// request was not authenticated.
+func IdentityFromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
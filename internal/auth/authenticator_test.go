@@ -0,0 +1,261 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// memKeyStore is an in-memory KeyStore for tests.
+type memKeyStore struct {
+	keys    map[string]*KeyRecord // by hash
+	touches int
+}
+
+func (m *memKeyStore) LookupVirtualKey(hash string) (*KeyRecord, error) {
+	return m.keys[hash], nil
+}
+
+func (m *memKeyStore) TouchVirtualKey(id string, at time.Time) error {
+	m.touches++
+	return nil
+}
+
+func newTestKey(t *testing.T, ks *memKeyStore, rec KeyRecord) string {
+	t.Helper()
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	rec.Hash = HashKey(key)
+	ks.keys[rec.Hash] = &rec
+	return key
+}
+
+func TestAuthenticate(t *testing.T) {
+	ks := &memKeyStore{keys: make(map[string]*KeyRecord)}
+	active := newTestKey(t, ks, KeyRecord{ID: "vk_active", Owner: "team-a", Scopes: []string{ScopeProxy}})
+	expired := newTestKey(t, ks, KeyRecord{ID: "vk_expired", Scopes: []string{ScopeProxy}, ExpiresAt: time.Now().Add(-time.Hour)})
+	revoked := newTestKey(t, ks, KeyRecord{ID: "vk_revoked", Scopes: []string{ScopeProxy}, RevokedAt: time.Now()})
+
+	a := NewAuthenticator("shared-secret", ks)
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+		wantID  string
+	}{
+		{"shared token", "shared-secret", nil, ""},
+		{"active key", active, nil, "vk_active"},
+		{"expired key", expired, ErrKeyExpired, ""},
+		{"revoked key", revoked, ErrKeyRevoked, ""},
+		{"unknown key", KeyPrefix + "nope", ErrInvalidToken, ""},
+		{"wrong token", "other", ErrInvalidToken, ""},
+		{"empty", "", ErrMissingToken, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := a.Authenticate(tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && id.KeyID != tt.wantID {
+				t.Errorf("KeyID = %q, want %q", id.KeyID, tt.wantID)
+			}
+		})
+	}
+
+	// Repeated use within touchInterval writes last-used only once.
+	a.Authenticate(active)
+	a.Authenticate(active)
+	if ks.touches != 1 {
+		t.Errorf("touches = %d, want 1", ks.touches)
+	}
+}
+
+func TestMiddleware_Scopes(t *testing.T) {
+	ks := &memKeyStore{keys: make(map[string]*KeyRecord)}
+	proxyKey := newTestKey(t, ks, KeyRecord{ID: "vk_p", Scopes: []string{ScopeProxy}})
+	adminKey := newTestKey(t, ks, KeyRecord{ID: "vk_a", Scopes: []string{ScopeDashboardAdmin}})
+
+	a := NewAuthenticator("", ks)
+	var gotID *Identity
+	h := a.Middleware(ScopeDashboardRead)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = IdentityFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"no header", "", http.StatusUnauthorized},
+		{"missing scope", "Bearer " + proxyKey, http.StatusForbidden},
+		{"admin implies read", "Bearer " + adminKey, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+	if gotID == nil || gotID.KeyID != "vk_a" {
+		t.Errorf("identity not propagated: %+v", gotID)
+	}
+}
+
+func TestMiddleware_GoogAPIKey(t *testing.T) {
+	a := NewAuthenticator("shared-secret", nil)
+	h := a.Middleware(ScopeProxy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for key, want := range map[string]int{"shared-secret": http.StatusOK, "other": http.StatusForbidden} {
+		req := httptest.NewRequest("POST", "/v1beta/models/gemini-2.5-flash:generateContent", nil)
+		req.Header.Set("x-goog-api-key", key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("x-goog-api-key %q: status = %d, want %d", key, w.Code, want)
+		}
+	}
+}
+
+type denialLog struct {
+	denials []Denial
+}
+
+func (l *denialLog) RecordDenial(d Denial) error {
+	l.denials = append(l.denials, d)
+	return nil
+}
+
+func TestMiddleware_HashedRolesAndDenials(t *testing.T) {
+	viewerToken, _ := GenerateDashboardToken()
+	auditorToken, _ := GenerateDashboardToken()
+	viewerHash, err := HashToken(viewerToken)
+	if err != nil {
+		t.Fatalf("HashToken: %v", err)
+	}
+	auditorHash, _ := HashToken(auditorToken)
+
+	a := NewAuthenticator("", nil)
+	if err := a.SetHashedTokens([]HashedToken{
+		{Name: "grafana", Scopes: RoleScopes(RoleViewer), Hash: viewerHash},
+		{Name: "compliance", Scopes: RoleScopes(RoleAuditor), Hash: auditorHash},
+	}); err != nil {
+		t.Fatalf("SetHashedTokens: %v", err)
+	}
+	rec := &denialLog{}
+	a.SetDenialRecorder(rec)
+
+	h := a.Middleware(ScopeDashboardAudit)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	do := func(token string) int {
+		req := httptest.NewRequest("GET", "/api/security/pii", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if got := do(auditorToken); got != http.StatusOK {
+		t.Errorf("auditor: status = %d, want 200", got)
+	}
+	// A second request is served from the verification cache.
+	if got := do(auditorToken); got != http.StatusOK {
+		t.Errorf("auditor (cached): status = %d, want 200", got)
+	}
+	if got := do(viewerToken); got != http.StatusForbidden {
+		t.Errorf("viewer: status = %d, want 403", got)
+	}
+	if got := do("not-a-token"); got != http.StatusForbidden {
+		t.Errorf("unknown token: status = %d, want 403", got)
+	}
+
+	if len(rec.denials) != 2 {
+		t.Fatalf("denials = %+v, want 2", rec.denials)
+	}
+	if d := rec.denials[0]; d.Actor != "grafana" || d.Path != "/api/security/pii" || d.Status != http.StatusForbidden {
+		t.Errorf("viewer denial = %+v", d)
+	}
+	if d := rec.denials[1]; d.Actor != "" || d.Reason != ErrInvalidToken.Error() {
+		t.Errorf("unknown token denial = %+v", d)
+	}
+
+	// Replacing the tokens drops cached verifications.
+	if err := a.SetHashedTokens(nil); err != nil {
+		t.Fatalf("SetHashedTokens: %v", err)
+	}
+	if got := do(auditorToken); got != http.StatusForbidden {
+		t.Errorf("removed auditor token: status = %d, want 403", got)
+	}
+
+	if err := a.SetHashedTokens([]HashedToken{{Name: "bad", Hash: "plaintext"}}); err == nil {
+		t.Error("SetHashedTokens accepted a malformed hash")
+	}
+}
+
+func TestAuthenticate_RemembersRejectedTokens(t *testing.T) {
+	token, _ := GenerateDashboardToken()
+	hash, err := HashToken(token)
+	if err != nil {
+		t.Fatalf("HashToken: %v", err)
+	}
+	a := NewAuthenticator("", nil)
+	if err := a.SetHashedTokens([]HashedToken{{Name: "grafana", Scopes: RoleScopes(RoleViewer), Hash: hash}}); err != nil {
+		t.Fatalf("SetHashedTokens: %v", err)
+	}
+
+	if _, err := a.Authenticate("garbage"); err != ErrInvalidToken {
+		t.Fatalf("Authenticate(garbage) = %v, want ErrInvalidToken", err)
+	}
+	if _, ok := a.rejected[HashKey("garbage")]; !ok {
+		t.Fatal("rejected token was not remembered")
+	}
+	// A remembered rejection is answered without hashing: with the entry
+	// swapped for one that would match, the token is still refused.
+	a.hashed[0].hash, _ = parseTokenHash(mustHashToken(t, "garbage"))
+	if _, err := a.Authenticate("garbage"); err != ErrInvalidToken {
+		t.Errorf("second Authenticate(garbage) = %v, want the remembered rejection", err)
+	}
+
+	// The set is bounded.
+	for i := len(a.rejected); i < maxRejectedTokens; i++ {
+		a.rejected[HashKey(strconv.Itoa(i))] = struct{}{}
+	}
+	if _, err := a.Authenticate("other-garbage"); err != ErrInvalidToken {
+		t.Fatalf("Authenticate(other-garbage) = %v, want ErrInvalidToken", err)
+	}
+	if len(a.rejected) != 1 {
+		t.Errorf("rejected set holds %d tokens after overflowing, want 1", len(a.rejected))
+	}
+
+	// Replacing the tokens forgets rejections.
+	if err := a.SetHashedTokens([]HashedToken{{Name: "new", Hash: mustHashToken(t, "other-garbage")}}); err != nil {
+		t.Fatalf("SetHashedTokens: %v", err)
+	}
+	if id, err := a.Authenticate("other-garbage"); err != nil || id.Name != "new" {
+		t.Errorf("Authenticate after reload = %v, %v; want the new token", id, err)
+	}
+	if id, err := a.Authenticate(token); err != ErrInvalidToken {
+		t.Errorf("Authenticate(removed token) = %v, %v; want ErrInvalidToken", id, err)
+	}
+}
+
+func mustHashToken(t *testing.T, token string) string {
+	t.Helper()
+	hash, err := HashToken(token)
+	if err != nil {
+		t.Fatalf("HashToken: %v", err)
+	}
+	return hash
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// KeyIDStore looks up virtual keys by ID. LookupVirtualKeyByID returns nil
+// and no error when no key has the ID.
+type KeyIDStore interface {
+	LookupVirtualKeyByID(id string) (*KeyRecord, error)
+}
+
+// CertMapping attributes clients whose certificate matches Match to a
+// project and, optionally, a virtual key. Match is a glob compared with the
+// certificate's subject common name, its full subject
+// ("CN=ci,OU=platform,O=Example"), and its DNS, email, and URI SANs.
+type CertMapping struct {
+	Match   string
+	Project string
+	// KeyID gives matching clients the scopes, model allow-list, and
+	// priority of this virtual key. Without it a client gets the proxy
+	// scope only.
+	KeyID string
+}
+
+// CertAuthenticator turns verified client certificates into identities.
+type CertAuthenticator struct {
+	keys KeyIDStore
+
+	mu       sync.RWMutex
+	mappings []CertMapping
+}
+
+// NewCertAuthenticator creates a CertAuthenticator. keys may be nil when no
+// mapping names a virtual key.
+func NewCertAuthenticator(keys KeyIDStore) *CertAuthenticator {
+	return &CertAuthenticator{keys: keys}
+}
+
+// SetMappings replaces the certificate mappings, checked in order. It
+// returns an error, and changes nothing, if a pattern is malformed.
+func (c *CertAuthenticator) SetMappings(mappings []CertMapping) error {
+	for _, m := range mappings {
+		if _, err := path.Match(m.Match, ""); err != nil {
+			return fmt.Errorf("certificate mapping %q: %w", m.Match, err)
+		}
+	}
+	c.mu.Lock()
+	c.mappings = append([]CertMapping(nil), mappings...)
+	c.mu.Unlock()
+	return nil
+}
+
+// certNames returns the names a mapping pattern is compared with.
+func certNames(cert *x509.Certificate) []string {
+	names := []string{cert.Subject.CommonName, cert.Subject.String()}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	return names
+}
+
+// match returns the first mapping matching cert, or nil.
+func (c *CertAuthenticator) match(cert *x509.Certificate) *CertMapping {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	names := certNames(cert)
+	for i := range c.mappings {
+		for _, name := range names {
+			if ok, _ := path.Match(c.mappings[i].Match, name); ok && name != "" {
+				m := c.mappings[i]
+				return &m
+			}
+		}
+	}
+	return nil
+}
+
+// Identify returns the identity of a client presenting cert, which must
+// already have been verified against the trusted CAs. It fails with
+// ErrKeyRevoked or ErrKeyExpired when the mapped virtual key is no longer
+// usable, and ErrInvalidToken when it does not exist.
+func (c *CertAuthenticator) Identify(cert *x509.Certificate) (*Identity, error) {
+	name := "cert:" + cert.Subject.CommonName
+	m := c.match(cert)
+	if m == nil {
+		return &Identity{Name: name, Scopes: []string{ScopeProxy}}, nil
+	}
+	if m.KeyID == "" {
+		return &Identity{Name: name, Scopes: []string{ScopeProxy}, Project: m.Project}, nil
+	}
+	if c.keys == nil {
+		return nil, ErrInvalidToken
+	}
+
+	rec, err := c.keys.LookupVirtualKeyByID(m.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, ErrInvalidToken
+	}
+	if rec.Revoked() {
+		return nil, ErrKeyRevoked
+	}
+	if rec.Expired(time.Now().UTC()) {
+		return nil, ErrKeyExpired
+	}
+	id := keyIdentity(rec)
+	id.Project = m.Project
+	return id, nil
+}
+
+// Middleware returns an HTTP middleware that stores the identity of a
+// verified client certificate in the request context. Requests without one
+// pass through unchanged, so bearer-token authentication still applies. A
+// certificate mapped to a revoked, expired, or missing key gets 403.
+func (c *CertAuthenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id, err := c.Identify(r.TLS.VerifiedChains[0][0])
+		switch {
+		case errors.Is(err, ErrInvalidToken), errors.Is(err, ErrKeyExpired), errors.Is(err, ErrKeyRevoked):
+			writeError(w, http.StatusForbidden, "client certificate: "+err.Error())
+			return
+		case err != nil:
+			log.Error().Err(err).Msg("client certificate key lookup failed")
+			writeError(w, http.StatusInternalServerError, "authentication unavailable")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+	})
+}
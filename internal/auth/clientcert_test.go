@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// memKeyIDStore is an in-memory KeyIDStore for tests.
+type memKeyIDStore map[string]*KeyRecord
+
+func (m memKeyIDStore) LookupVirtualKeyByID(id string) (*KeyRecord, error) {
+	return m[id], nil
+}
+
+func TestCertAuthenticator_Identify(t *testing.T) {
+	keys := memKeyIDStore{
+		"vk_ci":      {ID: "vk_ci", Owner: "platform", Scopes: []string{ScopeProxy, ScopeDashboardRead}},
+		"vk_revoked": {ID: "vk_revoked", Scopes: []string{ScopeProxy}, RevokedAt: time.Now()},
+	}
+	c := NewCertAuthenticator(keys)
+	if err := c.SetMappings([]CertMapping{
+		{Match: "ci-*", Project: "ci", KeyID: "vk_ci"},
+		{Match: "*.build.example.com", Project: "builds"},
+		{Match: "old-runner", KeyID: "vk_revoked"},
+	}); err != nil {
+		t.Fatalf("SetMappings: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		cert        *x509.Certificate
+		wantErr     error
+		wantKey     string
+		wantProject string
+	}{
+		{"common name maps to key", &x509.Certificate{Subject: pkix.Name{CommonName: "ci-runner-1"}}, nil, "vk_ci", "ci"},
+		{"DNS SAN maps to project", &x509.Certificate{Subject: pkix.Name{CommonName: "x"}, DNSNames: []string{"a.build.example.com"}}, nil, "", "builds"},
+		{"unmapped certificate", &x509.Certificate{Subject: pkix.Name{CommonName: "laptop"}}, nil, "", ""},
+		{"revoked key", &x509.Certificate{Subject: pkix.Name{CommonName: "old-runner"}}, ErrKeyRevoked, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := c.Identify(tt.cert)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if id.KeyID != tt.wantKey || id.Project != tt.wantProject {
+				t.Errorf("identity = %+v, want key %q project %q", id, tt.wantKey, tt.wantProject)
+			}
+			if !id.HasScope(ScopeProxy) {
+				t.Error("certificate identity lacks the proxy scope")
+			}
+		})
+	}
+
+	if err := c.SetMappings([]CertMapping{{Match: "["}}); err == nil {
+		t.Error("SetMappings with a malformed pattern: want error")
+	}
+}
+
+func TestCertAuthenticator_SatisfiesBearerMiddleware(t *testing.T) {
+	c := NewCertAuthenticator(nil)
+	if err := c.SetMappings([]CertMapping{{Match: "svc", Project: "team-a"}}); err != nil {
+		t.Fatalf("SetMappings: %v", err)
+	}
+	a := NewAuthenticator("shared-secret", nil)
+
+	var got *Identity
+	h := c.Middleware(a.Middleware(ScopeProxy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = IdentityFromContext(r.Context())
+	})))
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "svc"}}
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || got == nil || got.Project != "team-a" {
+		t.Fatalf("certificate without token: status %d, identity %+v", w.Code, got)
+	}
+
+	// A bearer token still authenticates, keeping the certificate's project.
+	req.Header.Set("Authorization", "Bearer shared-secret")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || got.Name != sharedIdentity.Name || got.Project != "team-a" {
+		t.Fatalf("certificate with token: status %d, identity %+v", w.Code, got)
+	}
+
+	// Dashboard scopes are not granted by an unmapped certificate.
+	dash := c.Middleware(a.Middleware(ScopeDashboardRead)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+	req.Header.Del("Authorization")
+	w = httptest.NewRecorder()
+	dash.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("dashboard with proxy-only certificate: status %d, want 403", w.Code)
+	}
+}
@@ -0,0 +1,243 @@
+// Package auth authenticates proxy and dashboard callers. It accepts the
+// shared auth.token from config as well as tokenman-issued virtual keys,
+// which are stored hashed and carry their own owner, scopes, allowed models,
+// and expiry.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scopes a virtual key can be granted. dashboard-audit adds stored request
+// bodies, the PII log, and the audit log to dashboard-read; dashboard-admin
+// adds configuration and maintenance changes on top of dashboard-audit.
+const (
+	ScopeProxy          = "proxy"
+	ScopeDashboardRead  = "dashboard-read"
+	ScopeDashboardAudit = "dashboard-audit"
+	ScopeDashboardAdmin = "dashboard-admin"
+)
+
+// ValidScopes lists every scope a virtual key can be granted.
+var ValidScopes = []string{ScopeProxy, ScopeDashboardRead, ScopeDashboardAudit, ScopeDashboardAdmin}
+
+// Dashboard roles that can be assigned to a hashed dashboard token.
+const (
+	RoleViewer  = "viewer"
+	RoleAuditor = "auditor"
+	RoleAdmin   = "admin"
+)
+
+// ValidRoles lists the dashboard roles, least privileged first.
+var ValidRoles = []string{RoleViewer, RoleAuditor, RoleAdmin}
+
+// RoleScopes returns the scopes granted by a dashboard role, or nil for an
+// unknown role.
+func RoleScopes(role string) []string {
+	switch role {
+	case RoleViewer:
+		return []string{ScopeDashboardRead}
+	case RoleAuditor:
+		return []string{ScopeDashboardAudit}
+	case RoleAdmin:
+		return []string{ScopeDashboardAdmin}
+	}
+	return nil
+}
+
+// KeyPrefix marks tokenman-issued virtual keys.
+const KeyPrefix = "tkm_"
+
+// displayPrefixLen is how much of a key is kept in clear for identification.
+const displayPrefixLen = 12
+
+// KeyRecord is the stored form of a virtual key. Only the SHA-256 hash of the
+// key is kept; the plaintext is shown once at creation.
+type KeyRecord struct {
+	ID            string
+	Name          string
+	Owner         string
+	Prefix        string
+	Hash          string
+	Scopes        []string
+	AllowedModels []string
+	Priority      string // scheduler priority class; empty means unset
+	CreatedAt     time.Time
+	ExpiresAt     time.Time // zero means no expiry
+	RevokedAt     time.Time // zero means active
+	LastUsedAt    time.Time
+}
+
+// Expired reports whether the key has passed its expiry at time now.
+func (k *KeyRecord) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)
+}
+
+// Revoked reports whether the key has been revoked.
+func (k *KeyRecord) Revoked() bool {
+	return !k.RevokedAt.IsZero()
+}
+
+// GenerateKey returns a new random virtual key.
+func GenerateKey() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("auth: generate key: %w", err)
+	}
+	return KeyPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// GenerateKeyID returns a short random identifier for a virtual key.
+func GenerateKeyID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("auth: generate key id: %w", err)
+	}
+	return "vk_" + hex.EncodeToString(buf), nil
+}
+
+// HashKey returns the hex SHA-256 digest under which a key is stored. Keys
+// are 256-bit random values, so an unsalted fast hash is sufficient.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// DisplayPrefix returns the leading part of a key that is safe to show in
+// listings so users can tell their keys apart.
+func DisplayPrefix(key string) string {
+	if len(key) <= displayPrefixLen {
+		return key
+	}
+	return key[:displayPrefixLen]
+}
+
+// ParseScopes splits a comma-separated scope list and validates each entry.
+func ParseScopes(s string) ([]string, error) {
+	var scopes []string
+	for _, part := range strings.Split(s, ",") {
+		scope := strings.TrimSpace(part)
+		if scope == "" {
+			continue
+		}
+		valid := false
+		for _, v := range ValidScopes {
+			if scope == v {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("auth: unknown scope %q (valid: %s)", scope, strings.Join(ValidScopes, ", "))
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("auth: at least one scope is required")
+	}
+	return scopes, nil
+}
+
+// ParseTTL parses a key lifetime such as "30d", "12h", or "90m".
+func ParseTTL(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("auth: invalid lifetime %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("auth: invalid lifetime %q", s)
+	}
+	return d, nil
+}
+
+// Identity describes an authenticated caller.
+type Identity struct {
+	KeyID         string // empty for the shared auth token
+	Name          string
+	Owner         string
+	Scopes        []string
+	AllowedModels []string // empty allows every model
+	Priority      string   // scheduler priority class; empty means unset
+	// Project attributes the caller's requests to a project regardless of
+	// the X-Tokenman-Project header. It is set by client-certificate
+	// mappings.
+	Project string
+}
+
+// sharedIdentity is the identity of callers using the shared auth.token.
+// It holds every scope so existing deployments keep working.
+var sharedIdentity = &Identity{
+	Name:   "shared-token",
+	Scopes: ValidScopes,
+}
+
+// SharedHashedToken returns the hashed form of the shared auth token, which
+// holds every scope like the plaintext token does.
+func SharedHashedToken(hash string) HashedToken {
+	return HashedToken{Name: sharedIdentity.Name, Scopes: sharedIdentity.Scopes, Hash: hash}
+}
+
+// Actor names the identity in audit records: the virtual key ID, or the
+// token name for the shared and dashboard tokens.
+func (id *Identity) Actor() string {
+	if id.KeyID != "" {
+		return id.KeyID
+	}
+	return id.Name
+}
+
+// HasScope reports whether the identity holds scope. The dashboard scopes
+// are nested: dashboard-admin implies dashboard-audit, which implies
+// dashboard-read.
+func (id *Identity) HasScope(scope string) bool {
+	want := dashboardLevel(scope)
+	for _, s := range id.Scopes {
+		if s == scope || (want > 0 && dashboardLevel(s) >= want) {
+			return true
+		}
+	}
+	return false
+}
+
+// dashboardLevel ranks the dashboard scopes; other scopes rank 0.
+func dashboardLevel(scope string) int {
+	switch scope {
+	case ScopeDashboardRead:
+		return 1
+	case ScopeDashboardAudit:
+		return 2
+	case ScopeDashboardAdmin:
+		return 3
+	}
+	return 0
+}
+
+// AllowsModel reports whether the identity may call model. Entries in
+// AllowedModels may be exact names or path.Match globs such as "claude-*".
+func (id *Identity) AllowsModel(model string) bool {
+	if len(id.AllowedModels) == 0 {
+		return true
+	}
+	for _, pattern := range id.AllowedModels {
+		if pattern == model {
+			return true
+		}
+		if ok, err := path.Match(pattern, model); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateKey(t *testing.T) {
+	k1, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	k2, _ := GenerateKey()
+	if !strings.HasPrefix(k1, KeyPrefix) {
+		t.Errorf("key %q missing prefix %q", k1, KeyPrefix)
+	}
+	if k1 == k2 {
+		t.Error("two generated keys are identical")
+	}
+	if HashKey(k1) == HashKey(k2) || HashKey(k1) != HashKey(k1) {
+		t.Error("HashKey is not a stable, distinct digest")
+	}
+	if got := DisplayPrefix(k1); len(got) != displayPrefixLen || !strings.HasPrefix(k1, got) {
+		t.Errorf("DisplayPrefix = %q", got)
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	got, err := ParseScopes(" proxy, dashboard-read ")
+	if err != nil {
+		t.Fatalf("ParseScopes: %v", err)
+	}
+	if len(got) != 2 || got[0] != ScopeProxy || got[1] != ScopeDashboardRead {
+		t.Errorf("ParseScopes = %v", got)
+	}
+	if _, err := ParseScopes("proxy,root"); err == nil {
+		t.Error("expected error for unknown scope")
+	}
+	if _, err := ParseScopes(""); err == nil {
+		t.Error("expected error for empty scope list")
+	}
+}
+
+func TestParseTTL(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"30d", 30 * 24 * time.Hour, true},
+		{"12h", 12 * time.Hour, true},
+		{"0d", 0, false},
+		{"-1h", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseTTL(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseTTL(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestIdentity_AllowsModel(t *testing.T) {
+	open := &Identity{}
+	if !open.AllowsModel("anything") {
+		t.Error("empty allow-list should allow every model")
+	}
+
+	id := &Identity{AllowedModels: []string{"gpt-4o-mini", "claude-*"}}
+	for model, want := range map[string]bool{
+		"gpt-4o-mini":     true,
+		"claude-sonnet-4": true,
+		"gpt-4o":          false,
+	} {
+		if got := id.AllowsModel(model); got != want {
+			t.Errorf("AllowsModel(%q) = %v, want %v", model, got, want)
+		}
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	hash, err := HashToken("s3cret")
+	if err != nil {
+		t.Fatalf("HashToken: %v", err)
+	}
+	if !strings.HasPrefix(hash, TokenHashPrefix) {
+		t.Errorf("hash %q lacks prefix %q", hash, TokenHashPrefix)
+	}
+	if other, _ := HashToken("s3cret"); other == hash {
+		t.Error("hashes of the same token are not salted")
+	}
+	if !VerifyToken(hash, "s3cret") {
+		t.Error("VerifyToken rejected the hashed token")
+	}
+	if VerifyToken(hash, "s3cret ") {
+		t.Error("VerifyToken accepted a different token")
+	}
+	for _, bad := range []string{"", "s3cret", TokenHashPrefix + "x$y", TokenHashPrefix + "0$c2FsdA$ZGln"} {
+		if ValidateTokenHash(bad) == nil {
+			t.Errorf("ValidateTokenHash(%q) = nil, want error", bad)
+		}
+	}
+}
+
+func TestIdentity_HasScopeNested(t *testing.T) {
+	tests := []struct {
+		role  string
+		scope string
+		want  bool
+	}{
+		{RoleViewer, ScopeDashboardRead, true},
+		{RoleViewer, ScopeDashboardAudit, false},
+		{RoleAuditor, ScopeDashboardRead, true},
+		{RoleAuditor, ScopeDashboardAudit, true},
+		{RoleAuditor, ScopeDashboardAdmin, false},
+		{RoleAdmin, ScopeDashboardAudit, true},
+		{RoleAdmin, ScopeProxy, false},
+	}
+	for _, tt := range tests {
+		id := &Identity{Scopes: RoleScopes(tt.role)}
+		if got := id.HasScope(tt.scope); got != tt.want {
+			t.Errorf("%s.HasScope(%s) = %v, want %v", tt.role, tt.scope, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TokenHashPrefix marks a salted token hash produced by HashToken. Hashes
+// have the form "pbkdf2-sha256$<iterations>$<salt>$<digest>" with the salt
+// and digest in unpadded base64.
+const TokenHashPrefix = "pbkdf2-sha256$"
+
+// DashboardTokenPrefix marks tokens generated by GenerateDashboardToken.
+const DashboardTokenPrefix = "tkd_"
+
+// tokenHashIterations is the PBKDF2 work factor for new hashes. Dashboard
+// and shared tokens may be chosen by hand, so unlike virtual keys they are
+// hashed with a slow, salted KDF.
+const tokenHashIterations = 100_000
+
+const (
+	tokenSaltLen   = 16
+	tokenDigestLen = 32
+)
+
+// GenerateDashboardToken returns a new random dashboard token.
+func GenerateDashboardToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("auth: generate token: %w", err)
+	}
+	return DashboardTokenPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashToken returns a salted hash of token suitable for storing in config.
+func HashToken(token string) (string, error) {
+	salt := make([]byte, tokenSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("auth: generate salt: %w", err)
+	}
+	digest, err := pbkdf2.Key(sha256.New, token, salt, tokenHashIterations, tokenDigestLen)
+	if err != nil {
+		return "", fmt.Errorf("auth: hash token: %w", err)
+	}
+	return fmt.Sprintf("%s%d$%s$%s", TokenHashPrefix, tokenHashIterations,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(digest)), nil
+}
+
+// parsedTokenHash is a decoded HashToken result.
+type parsedTokenHash struct {
+	iterations int
+	salt       []byte
+	digest     []byte
+}
+
+// parseTokenHash decodes a hash produced by HashToken.
+func parseTokenHash(encoded string) (*parsedTokenHash, error) {
+	rest, ok := strings.CutPrefix(encoded, TokenHashPrefix)
+	if !ok {
+		return nil, fmt.Errorf("auth: token hash must start with %q", TokenHashPrefix)
+	}
+	parts := strings.Split(rest, "$")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("auth: malformed token hash")
+	}
+	iterations, err := strconv.Atoi(parts[0])
+	if err != nil || iterations < 1 {
+		return nil, fmt.Errorf("auth: malformed token hash iterations")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil || len(salt) == 0 {
+		return nil, fmt.Errorf("auth: malformed token hash salt")
+	}
+	digest, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil || len(digest) == 0 {
+		return nil, fmt.Errorf("auth: malformed token hash digest")
+	}
+	return &parsedTokenHash{iterations: iterations, salt: salt, digest: digest}, nil
+}
+
+// ValidateTokenHash reports whether encoded is a well-formed token hash.
+func ValidateTokenHash(encoded string) error {
+	_, err := parseTokenHash(encoded)
+	return err
+}
+
+// verify reports whether token hashes to h.
+func (h *parsedTokenHash) verify(token string) bool {
+	digest, err := pbkdf2.Key(sha256.New, token, h.salt, h.iterations, len(h.digest))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(digest, h.digest) == 1
+}
+
+// VerifyToken reports whether token matches the hash encoded by HashToken.
+func VerifyToken(encoded, token string) bool {
+	h, err := parseTokenHash(encoded)
+	if err != nil {
+		return false
+	}
+	return h.verify(token)
+}
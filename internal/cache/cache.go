@@ -236,6 +236,12 @@ func (c *CacheMiddleware) StartPurger(ctx context.Context) <-chan struct{} {
 	return done
 }
 
+// Clear removes every entry from the in-memory cache. Entries in the
+// persistent store are left to the caller.
+func (c *CacheMiddleware) Clear() {
+	c.memory.Purge()
+}
+
 // purge removes expired entries from both the persistent store and the
 // in-memory LRU cache.
 func (c *CacheMiddleware) purge() {
@@ -109,6 +109,28 @@ func TestCacheKey_DifferentToolsDifferentKey(t *testing.T) {
 	}
 }
 
+func TestCacheKey_FormatAndPreviousResponseDifferentKey(t *testing.T) {
+	base := pipeline.Request{
+		Format:   pipeline.FormatOpenAI,
+		Model:    "gpt-4",
+		Messages: []pipeline.Message{{Role: "user", Content: "hello"}},
+	}
+	responses := base
+	responses.Format = pipeline.FormatOpenAIResponses
+	chained := responses
+	chained.PreviousResponseID = "resp_1"
+	other := responses
+	other.PreviousResponseID = "resp_2"
+
+	keys := map[string]bool{}
+	for _, req := range []pipeline.Request{base, responses, chained, other} {
+		keys[CacheKey(&req)] = true
+	}
+	if len(keys) != 4 {
+		t.Errorf("expected 4 distinct keys across formats and previous responses, got %d", len(keys))
+	}
+}
+
 func TestCacheKey_DifferentSystemPromptDifferentKey(t *testing.T) {
 	req1 := &pipeline.Request{
 		Model:    "gpt-4",
@@ -507,3 +529,74 @@ func TestTTLExpiry(t *testing.T) {
 		t.Error("expected cache miss after TTL expiry")
 	}
 }
+
+// ---------------------------------------------------------------------------
+// EmbeddingCache tests
+// ---------------------------------------------------------------------------
+
+type mockEmbeddingStore struct {
+	entries map[string]*EmbeddingEntry
+}
+
+func (m *mockEmbeddingStore) GetEmbedding(key string) (*EmbeddingEntry, error) {
+	if e, ok := m.entries[key]; ok {
+		return e, nil
+	}
+	return nil, fmt.Errorf("not found")
+}
+
+func (m *mockEmbeddingStore) SetEmbedding(key string, entry *EmbeddingEntry) error {
+	m.entries[key] = entry
+	return nil
+}
+
+func (m *mockEmbeddingStore) DeleteExpiredEmbeddings() error {
+	return nil
+}
+
+func TestEmbeddingKey_DependsOnModelOptionsAndInput(t *testing.T) {
+	base := EmbeddingKey("text-embedding-3-small", 0, "", []byte(`"hello"`))
+	if EmbeddingKey("text-embedding-3-small", 0, "", []byte(`"hello"`)) != base {
+		t.Error("same inputs should produce the same key")
+	}
+	for name, key := range map[string]string{
+		"model":      EmbeddingKey("text-embedding-3-large", 0, "", []byte(`"hello"`)),
+		"dimensions": EmbeddingKey("text-embedding-3-small", 256, "", []byte(`"hello"`)),
+		"encoding":   EmbeddingKey("text-embedding-3-small", 0, "base64", []byte(`"hello"`)),
+		"input":      EmbeddingKey("text-embedding-3-small", 0, "", []byte(`"hello!"`)),
+	} {
+		if key == base {
+			t.Errorf("different %s should produce a different key", name)
+		}
+	}
+}
+
+func TestEmbeddingCache_PutGetAndStoreTier(t *testing.T) {
+	store := &mockEmbeddingStore{entries: make(map[string]*EmbeddingEntry)}
+	c, err := NewEmbeddingCache(store, 3600, 10)
+	if err != nil {
+		t.Fatalf("NewEmbeddingCache: %v", err)
+	}
+	if c.Get("k") != nil {
+		t.Fatal("expected miss on empty cache")
+	}
+
+	c.Put("k", "text-embedding-3-small", []byte(`[0.1,0.2]`), 3)
+	if got := c.Get("k"); got == nil || string(got.Embedding) != `[0.1,0.2]` || got.Tokens != 3 {
+		t.Errorf("Get = %+v; want the stored embedding", got)
+	}
+	if _, ok := store.entries["k"]; !ok {
+		t.Error("embedding should be persisted to the store")
+	}
+
+	// A fresh cache over the same store is served from the persistent tier.
+	c2, _ := NewEmbeddingCache(store, 3600, 10)
+	if got := c2.Get("k"); got == nil {
+		t.Error("expected hit from the persistent store")
+	}
+
+	store.entries["old"] = &EmbeddingEntry{Embedding: []byte(`[1]`), ExpiresAt: time.Now().Add(-time.Second)}
+	if c2.Get("old") != nil {
+		t.Error("expired embedding should be a miss")
+	}
+}
@@ -0,0 +1,170 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// EmbeddingEntry is one cached embedding vector. Embedding holds the JSON
+// value the provider returned for the input (a float array, or a base64
+// string when the client asked for that encoding).
+type EmbeddingEntry struct {
+	Embedding []byte
+	Tokens    int
+	Model     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Expired returns true if the entry has passed its expiration time.
+func (e *EmbeddingEntry) Expired() bool {
+	return time.Now().After(e.ExpiresAt)
+}
+
+// EmbeddingStore is the persistence interface for cached embeddings.
+type EmbeddingStore interface {
+	GetEmbedding(key string) (*EmbeddingEntry, error)
+	SetEmbedding(key string, entry *EmbeddingEntry) error
+	DeleteExpiredEmbeddings() error
+}
+
+// EmbeddingCache caches embeddings per input in a two-tier cache (in-memory
+// LRU + persistent store), so re-embedding unchanged text costs nothing.
+type EmbeddingCache struct {
+	memory *lru.Cache[string, *EmbeddingEntry]
+	store  EmbeddingStore
+	ttl    atomic.Int64 // stores nanoseconds
+}
+
+// NewEmbeddingCache creates a new EmbeddingCache.
+//
+//   - store is the persistent backend (may be nil for memory-only).
+//   - ttlSeconds is the time-to-live for cached embeddings in seconds.
+//   - maxMemoryEntries is the maximum number of embeddings kept in memory.
+func NewEmbeddingCache(store EmbeddingStore, ttlSeconds int, maxMemoryEntries int) (*EmbeddingCache, error) {
+	if maxMemoryEntries <= 0 {
+		maxMemoryEntries = 1000
+	}
+
+	memCache, err := lru.New[string, *EmbeddingEntry](maxMemoryEntries)
+	if err != nil {
+		return nil, fmt.Errorf("cache: creating embedding LRU: %w", err)
+	}
+
+	c := &EmbeddingCache{memory: memCache, store: store}
+	c.ttl.Store(int64(time.Duration(ttlSeconds) * time.Second))
+	return c, nil
+}
+
+// EmbeddingKey computes the hex-encoded SHA-256 key for one embedding input.
+// The requested dimensions and encoding format change the returned vector,
+// so they are part of the key along with the model. input is the input's
+// raw JSON (a string or a token array).
+func EmbeddingKey(model string, dimensions int, encodingFormat string, input []byte) string {
+	h := sha256.New()
+	h.Write([]byte(model))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.Itoa(dimensions)))
+	h.Write([]byte{0})
+	h.Write([]byte(encodingFormat))
+	h.Write([]byte{0})
+	h.Write(input)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get returns the cached embedding for key, or nil if there is none or it
+// has expired.
+func (c *EmbeddingCache) Get(key string) *EmbeddingEntry {
+	// Tier 1: check in-memory LRU.
+	if entry, ok := c.memory.Get(key); ok {
+		if !entry.Expired() {
+			return entry
+		}
+		c.memory.Remove(key)
+	}
+
+	// Tier 2: check persistent store.
+	if c.store != nil {
+		entry, err := c.store.GetEmbedding(key)
+		if err == nil && entry != nil && !entry.Expired() {
+			c.memory.Add(key, entry)
+			return entry
+		}
+	}
+	return nil
+}
+
+// Put caches an embedding for key, returned by model for an input of tokens
+// tokens.
+func (c *EmbeddingCache) Put(key, model string, embedding []byte, tokens int) {
+	now := time.Now()
+	entry := &EmbeddingEntry{
+		Embedding: embedding,
+		Tokens:    tokens,
+		Model:     model,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Duration(c.ttl.Load())),
+	}
+	c.memory.Add(key, entry)
+	if c.store != nil {
+		if err := c.store.SetEmbedding(key, entry); err != nil {
+			log.Error().Err(err).Str("key", key).Msg("failed to persist embedding")
+		}
+	}
+}
+
+// SetTTL updates the TTL for new embeddings. This is called when the config
+// is hot-reloaded.
+func (c *EmbeddingCache) SetTTL(ttlSeconds int) {
+	c.ttl.Store(int64(time.Duration(ttlSeconds) * time.Second))
+}
+
+// StartPurger starts a background goroutine that removes expired embeddings
+// from the persistent store and the in-memory LRU every 5 minutes until the
+// context is cancelled. The returned channel is closed when it exits.
+func (c *EmbeddingCache) StartPurger(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	ticker := time.NewTicker(5 * time.Minute)
+	go func() {
+		defer close(done)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							log.Error().Interface("panic", r).Msg("embedding purger: recovered from panic")
+						}
+					}()
+					c.purge()
+				}()
+			}
+		}
+	}()
+	return done
+}
+
+// purge removes expired embeddings from both tiers.
+func (c *EmbeddingCache) purge() {
+	if c.store != nil {
+		if err := c.store.DeleteExpiredEmbeddings(); err != nil {
+			log.Error().Err(err).Msg("failed to purge expired embeddings")
+		}
+	}
+	for _, key := range c.memory.Keys() {
+		if entry, ok := c.memory.Peek(key); ok && entry.Expired() {
+			c.memory.Remove(key)
+		}
+	}
+}
@@ -9,8 +9,9 @@ import (
 )
 
 // CacheKey computes a deterministic SHA-256 cache key from the request's
-// model, messages, tools, system prompt, system blocks, and max_tokens.
-// The key is hex-encoded.
+// model, messages, tools, system prompt, system blocks, max_tokens, API
+// format, and the stored response an OpenAI Responses request continues
+// from. The key is hex-encoded.
 func CacheKey(req *pipeline.Request) string {
 	h := sha256.New()
 
@@ -64,6 +65,16 @@ func CacheKey(req *pipeline.Request) string {
 
 	// Write max_tokens.
 	fmt.Fprintf(h, "%d", req.MaxTokens)
+	h.Write([]byte{0}) // separator
+
+	// Write the format: the same conversation is answered with a
+	// differently shaped body in each API.
+	h.Write([]byte(req.Format))
+	h.Write([]byte{0}) // separator
+
+	// Write the previous response ID: a chained request's messages are only
+	// the new turn, so the same input continues different conversations.
+	h.Write([]byte(req.PreviousResponseID))
 
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
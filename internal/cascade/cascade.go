@@ -0,0 +1,227 @@
+// Package cascade implements model cascading: a request is answered by a
+// cheap model first, and escalated to a stronger model only when the cheap
+// answer fails one of a set of local checks.
+package cascade
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+)
+
+// Answer is the part of a model response the checks look at.
+type Answer struct {
+	// Text is the concatenated text content of the response.
+	Text string
+	// StopReason is the Anthropic-style stop reason ("end_turn",
+	// "max_tokens", "tool_use", "refusal", ...). OpenAI and Gemini finish
+	// reasons, and Responses API statuses, are mapped onto it.
+	StopReason string
+	// Body is the raw response body.
+	Body []byte
+}
+
+// ParseAnswer extracts the answer from a non-streaming response body in the
+// given API format.
+func ParseAnswer(body []byte, format pipeline.APIFormat) Answer {
+	a := Answer{Body: body}
+	switch format {
+	case pipeline.FormatAnthropic:
+		var resp struct {
+			Content []struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"content"`
+			StopReason string `json:"stop_reason"`
+		}
+		if json.Unmarshal(body, &resp) != nil {
+			return a
+		}
+		var b strings.Builder
+		for _, c := range resp.Content {
+			if c.Type == "text" {
+				b.WriteString(c.Text)
+			}
+		}
+		a.Text, a.StopReason = b.String(), resp.StopReason
+	case pipeline.FormatOpenAI:
+		var resp struct {
+			Choices []struct {
+				Message struct {
+					Content string `json:"content"`
+					Refusal string `json:"refusal"`
+				} `json:"message"`
+				FinishReason string `json:"finish_reason"`
+			} `json:"choices"`
+		}
+		if json.Unmarshal(body, &resp) != nil || len(resp.Choices) == 0 {
+			return a
+		}
+		c := resp.Choices[0]
+		a.Text = c.Message.Content
+		switch {
+		case c.Message.Refusal != "":
+			a.StopReason = "refusal"
+		case c.FinishReason == "length":
+			a.StopReason = "max_tokens"
+		case c.FinishReason == "stop":
+			a.StopReason = "end_turn"
+		case c.FinishReason == "tool_calls":
+			a.StopReason = "tool_use"
+		default:
+			a.StopReason = c.FinishReason
+		}
+	case pipeline.FormatGemini:
+		var resp struct {
+			Candidates []struct {
+				Content struct {
+					Parts []struct {
+						Text         string          `json:"text"`
+						FunctionCall json.RawMessage `json:"functionCall"`
+					} `json:"parts"`
+				} `json:"content"`
+				FinishReason string `json:"finishReason"`
+			} `json:"candidates"`
+		}
+		if json.Unmarshal(body, &resp) != nil || len(resp.Candidates) == 0 {
+			return a
+		}
+		c := resp.Candidates[0]
+		var b strings.Builder
+		calls := false
+		for _, p := range c.Content.Parts {
+			b.WriteString(p.Text)
+			calls = calls || p.FunctionCall != nil
+		}
+		a.Text = b.String()
+		switch c.FinishReason {
+		case "MAX_TOKENS":
+			a.StopReason = "max_tokens"
+		case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+			a.StopReason = "refusal"
+		case "STOP":
+			a.StopReason = "end_turn"
+			if calls {
+				a.StopReason = "tool_use"
+			}
+		default:
+			a.StopReason = c.FinishReason
+		}
+	case pipeline.FormatOpenAIResponses:
+		var resp struct {
+			Status            string `json:"status"`
+			IncompleteDetails struct {
+				Reason string `json:"reason"`
+			} `json:"incomplete_details"`
+			Output []struct {
+				Type    string `json:"type"`
+				Content []struct {
+					Type string `json:"type"`
+					Text string `json:"text"`
+				} `json:"content"`
+			} `json:"output"`
+		}
+		if json.Unmarshal(body, &resp) != nil {
+			return a
+		}
+		var b strings.Builder
+		calls, refused := false, false
+		for _, item := range resp.Output {
+			calls = calls || item.Type == "function_call"
+			for _, c := range item.Content {
+				switch c.Type {
+				case "output_text":
+					b.WriteString(c.Text)
+				case "refusal":
+					refused = true
+				}
+			}
+		}
+		a.Text = b.String()
+		switch {
+		case refused || resp.IncompleteDetails.Reason == "content_filter":
+			a.StopReason = "refusal"
+		case resp.IncompleteDetails.Reason == "max_output_tokens":
+			a.StopReason = "max_tokens"
+		case calls:
+			a.StopReason = "tool_use"
+		case resp.Status == "completed":
+			a.StopReason = "end_turn"
+		default:
+			a.StopReason = resp.Status
+		}
+	}
+	return a
+}
+
+// Cascade sends requests to CheapModel first and escalates to StrongModel
+// when the answer fails any of Checks. A cascade applies to requests from
+// any of Projects, and to requests for any of Aliases, virtual model names
+// that stand for the cascade itself. An empty StrongModel means the model
+// the client requested.
+type Cascade struct {
+	Name        string
+	Projects    []string
+	Aliases     []string
+	CheapModel  string
+	StrongModel string
+	Checks      []Check
+}
+
+// Evaluate runs the checks in order and returns the name of the first one
+// the answer fails, with the reason, or "" and nil when it passes them all.
+func (c *Cascade) Evaluate(a Answer) (string, error) {
+	for _, check := range c.Checks {
+		if err := check.Check(a); err != nil {
+			return check.Name(), err
+		}
+	}
+	return "", nil
+}
+
+// Set is the configured cascades. A nil Set matches nothing.
+type Set struct {
+	cascades []*Cascade
+}
+
+// NewSet creates a set of cascades. Earlier cascades win when several
+// match the same request.
+func NewSet(cascades []*Cascade) *Set {
+	return &Set{cascades: cascades}
+}
+
+// Match returns the cascade for a request from project for model, or nil.
+// A cascade named by the model as an alias takes precedence over one
+// configured for the project.
+func (s *Set) Match(project, model string) *Cascade {
+	if s == nil {
+		return nil
+	}
+	if c := s.Alias(model); c != nil {
+		return c
+	}
+	for _, c := range s.cascades {
+		for _, p := range c.Projects {
+			if p == project {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// Alias returns the cascade model names as an alias, or nil.
+func (s *Set) Alias(model string) *Cascade {
+	if s == nil {
+		return nil
+	}
+	for _, c := range s.cascades {
+		for _, a := range c.Aliases {
+			if a == model {
+				return c
+			}
+		}
+	}
+	return nil
+}
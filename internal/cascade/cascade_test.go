@@ -0,0 +1,110 @@
+package cascade
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+)
+
+func TestParseAnswer(t *testing.T) {
+	a := ParseAnswer([]byte(`{"content":[{"type":"text","text":"hello "},{"type":"tool_use"},{"type":"text","text":"world"}],"stop_reason":"max_tokens"}`), pipeline.FormatAnthropic)
+	if a.Text != "hello world" || a.StopReason != "max_tokens" {
+		t.Errorf("anthropic answer = %q, %q", a.Text, a.StopReason)
+	}
+
+	a = ParseAnswer([]byte(`{"choices":[{"message":{"content":"hi"},"finish_reason":"length"}]}`), pipeline.FormatOpenAI)
+	if a.Text != "hi" || a.StopReason != "max_tokens" {
+		t.Errorf("openai answer = %q, %q; want finish_reason length mapped to max_tokens", a.Text, a.StopReason)
+	}
+
+	a = ParseAnswer([]byte(`{"candidates":[{"content":{"role":"model","parts":[{"text":"no"}]},"finishReason":"SAFETY"}]}`), pipeline.FormatGemini)
+	if a.Text != "no" || a.StopReason != "refusal" {
+		t.Errorf("gemini answer = %q, %q; want finishReason SAFETY mapped to refusal", a.Text, a.StopReason)
+	}
+
+	a = ParseAnswer([]byte(`{"status":"incomplete","incomplete_details":{"reason":"max_output_tokens"},"output":[{"type":"reasoning"},{"type":"message","content":[{"type":"output_text","text":"par"}]}]}`), pipeline.FormatOpenAIResponses)
+	if a.Text != "par" || a.StopReason != "max_tokens" {
+		t.Errorf("responses answer = %q, %q; want max_output_tokens mapped to max_tokens", a.Text, a.StopReason)
+	}
+}
+
+func TestChecks(t *testing.T) {
+	schema, err := JSONCheck(`{"type":"object","required":["label"],"properties":{"label":{"enum":["spam","ham"]},"score":{"type":"number","minimum":0,"maximum":1}},"additionalProperties":false}`)
+	if err != nil {
+		t.Fatalf("JSONCheck: %v", err)
+	}
+	anyJSON, _ := JSONCheck("")
+	re, err := RegexCheck(`^(yes|no)$`)
+	if err != nil {
+		t.Fatalf("RegexCheck: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		check  Check
+		answer Answer
+		pass   bool
+	}{
+		{"json ok", anyJSON, Answer{Text: `[1, 2]`}, true},
+		{"json fenced", anyJSON, Answer{Text: "```json\n{\"a\": 1}\n```"}, true},
+		{"json invalid", anyJSON, Answer{Text: `{"a": `}, false},
+		{"schema ok", schema, Answer{Text: `{"label":"spam","score":0.9}`}, true},
+		{"schema missing", schema, Answer{Text: `{"score":0.9}`}, false},
+		{"schema enum", schema, Answer{Text: `{"label":"eggs"}`}, false},
+		{"schema range", schema, Answer{Text: `{"label":"ham","score":2}`}, false},
+		{"schema extra", schema, Answer{Text: `{"label":"ham","why":"x"}`}, false},
+		{"schema type", schema, Answer{Text: `["spam"]`}, false},
+		{"regex ok", re, Answer{Text: "yes"}, true},
+		{"regex fail", re, Answer{Text: "maybe"}, false},
+		{"length ok", LengthCheck(2, 10), Answer{Text: " short "}, true},
+		{"length short", LengthCheck(2, 10), Answer{Text: "a"}, false},
+		{"length long", LengthCheck(0, 3), Answer{Text: "too long"}, false},
+		{"refusal text", RefusalCheck(), Answer{Text: "I’m unable to help with that."}, false},
+		{"refusal stop", RefusalCheck(), Answer{Text: "x", StopReason: "refusal"}, false},
+		{"no refusal", RefusalCheck(), Answer{Text: "Sure, the answer is 4."}, true},
+		{"truncated", MaxTokensCheck(), Answer{StopReason: "max_tokens"}, false},
+		{"complete", MaxTokensCheck(), Answer{StopReason: "end_turn"}, true},
+	}
+	for _, tt := range tests {
+		err := tt.check.Check(tt.answer)
+		if (err == nil) != tt.pass {
+			t.Errorf("%s: Check(%q) = %v; want pass=%v", tt.name, tt.answer.Text, err, tt.pass)
+		}
+	}
+}
+
+func TestParseSchema_RejectsBadSchemas(t *testing.T) {
+	for _, schema := range []string{`not json`, `{"pattern":"("}`, `{"properties":{"a":{"minLength":"x"}}}`} {
+		if _, err := ParseSchema([]byte(schema)); err == nil {
+			t.Errorf("ParseSchema(%s) succeeded; want error", schema)
+		}
+	}
+}
+
+func TestSet_MatchAndEvaluate(t *testing.T) {
+	classify := &Cascade{Name: "classify", Aliases: []string{"triage"}, CheapModel: "haiku", Checks: []Check{MaxTokensCheck(), LengthCheck(1, 0)}}
+	support := &Cascade{Name: "support", Projects: []string{"support-bot"}, CheapModel: "mini"}
+	s := NewSet([]*Cascade{classify, support})
+
+	if c := s.Match("support-bot", "triage"); c != classify {
+		t.Errorf("Match(alias) = %v; want classify to take precedence over the project", c)
+	}
+	if c := s.Match("support-bot", "gpt-4o"); c != support {
+		t.Errorf("Match(project) = %v; want support", c)
+	}
+	if c := s.Match("other", "gpt-4o"); c != nil {
+		t.Errorf("Match(unrelated) = %v; want nil", c)
+	}
+	if c := (*Set)(nil).Match("support-bot", "triage"); c != nil {
+		t.Error("nil Set should match nothing")
+	}
+
+	check, err := classify.Evaluate(Answer{Text: "", StopReason: "end_turn"})
+	if check != "length" || err == nil || !strings.Contains(err.Error(), "fewer than 1") {
+		t.Errorf("Evaluate = %q, %v; want length failure", check, err)
+	}
+	if check, err := classify.Evaluate(Answer{Text: "spam", StopReason: "end_turn"}); check != "" || err != nil {
+		t.Errorf("Evaluate = %q, %v; want pass", check, err)
+	}
+}
@@ -0,0 +1,173 @@
+package cascade
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Check judges whether a cheap model's answer is good enough to return.
+// Check returns nil when it is and an error explaining why not otherwise.
+// Implementations must be safe for concurrent use.
+type Check interface {
+	Name() string
+	Check(a Answer) error
+}
+
+// jsonCheck requires the answer text to be JSON, optionally satisfying a
+// schema.
+type jsonCheck struct {
+	schema *Schema
+}
+
+// JSONCheck returns a check requiring the answer to be valid JSON. When
+// schema is non-empty the JSON must also satisfy it; see ParseSchema for the
+// supported keywords. A JSON answer wrapped in a Markdown code fence is
+// accepted.
+func JSONCheck(schema string) (Check, error) {
+	c := &jsonCheck{}
+	if strings.TrimSpace(schema) != "" {
+		s, err := ParseSchema([]byte(schema))
+		if err != nil {
+			return nil, err
+		}
+		c.schema = s
+	}
+	return c, nil
+}
+
+func (c *jsonCheck) Name() string {
+	if c.schema != nil {
+		return "json_schema"
+	}
+	return "json"
+}
+
+func (c *jsonCheck) Check(a Answer) error {
+	text := stripCodeFence(a.Text)
+	var v interface{}
+	if err := json.Unmarshal([]byte(text), &v); err != nil {
+		return fmt.Errorf("answer is not valid JSON: %w", err)
+	}
+	if c.schema != nil {
+		return c.schema.Validate(v)
+	}
+	return nil
+}
+
+// stripCodeFence removes a surrounding ```json ... ``` fence, which models
+// often add even when asked for bare JSON.
+func stripCodeFence(s string) string {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "```") || !strings.HasSuffix(s, "```") || len(s) < 6 {
+		return s
+	}
+	s = strings.TrimSuffix(s[3:], "```")
+	if i := strings.IndexByte(s, '\n'); i >= 0 && !strings.ContainsAny(s[:i], "{[\"") {
+		s = s[i+1:]
+	}
+	return strings.TrimSpace(s)
+}
+
+type regexCheck struct {
+	re *regexp.Regexp
+}
+
+// RegexCheck returns a check requiring the answer text to match expr, an
+// RE2 expression that may match anywhere unless anchored.
+func RegexCheck(expr string) (Check, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("cascade: regex: %w", err)
+	}
+	return &regexCheck{re: re}, nil
+}
+
+func (c *regexCheck) Name() string { return "regex" }
+
+func (c *regexCheck) Check(a Answer) error {
+	if !c.re.MatchString(a.Text) {
+		return fmt.Errorf("answer does not match %s", c.re)
+	}
+	return nil
+}
+
+type lengthCheck struct {
+	min, max int
+}
+
+// LengthCheck returns a check requiring the answer text to be between min
+// and max characters long; zero disables either bound.
+func LengthCheck(min, max int) Check {
+	return &lengthCheck{min: min, max: max}
+}
+
+func (c *lengthCheck) Name() string { return "length" }
+
+func (c *lengthCheck) Check(a Answer) error {
+	n := utf8.RuneCountInString(strings.TrimSpace(a.Text))
+	if c.min > 0 && n < c.min {
+		return fmt.Errorf("answer is %d characters, fewer than %d", n, c.min)
+	}
+	if c.max > 0 && n > c.max {
+		return fmt.Errorf("answer is %d characters, more than %d", n, c.max)
+	}
+	return nil
+}
+
+// refusalPrefixes are how models typically open a refusal.
+var refusalPrefixes = []string{
+	"i can't help",
+	"i cannot help",
+	"i can't assist",
+	"i cannot assist",
+	"i can't provide",
+	"i cannot provide",
+	"i'm not able to",
+	"i am not able to",
+	"i'm unable to",
+	"i am unable to",
+	"i'm sorry, but i can",
+	"i apologize, but i can",
+	"sorry, i can't",
+	"as an ai",
+}
+
+type refusalCheck struct{}
+
+// RefusalCheck returns a check that fails when the model refused: the
+// provider reported a refusal, or the answer opens like one.
+func RefusalCheck() Check { return refusalCheck{} }
+
+func (refusalCheck) Name() string { return "refusal" }
+
+func (refusalCheck) Check(a Answer) error {
+	if a.StopReason == "refusal" {
+		return fmt.Errorf("provider reported a refusal")
+	}
+	text := strings.ToLower(strings.TrimSpace(a.Text))
+	text = strings.ReplaceAll(text, "’", "'")
+	for _, p := range refusalPrefixes {
+		if strings.HasPrefix(text, p) {
+			return fmt.Errorf("answer looks like a refusal")
+		}
+	}
+	return nil
+}
+
+type maxTokensCheck struct{}
+
+// MaxTokensCheck returns a check that fails when the answer was cut off by
+// the request's max_tokens.
+func MaxTokensCheck() Check { return maxTokensCheck{} }
+
+func (maxTokensCheck) Name() string { return "max_tokens" }
+
+func (maxTokensCheck) Check(a Answer) error {
+	if a.StopReason == "max_tokens" {
+		return fmt.Errorf("answer was truncated at max_tokens")
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package cascade
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+)
+
+// Schema is a compiled subset of JSON Schema, enough to check the shape of
+// structured model output. Supported keywords are type, enum, properties,
+// required, additionalProperties (boolean), items, minItems, maxItems,
+// minLength, maxLength, pattern, minimum, and maximum; others are ignored.
+type Schema struct {
+	Types                []string
+	Enum                 []interface{}
+	Properties           map[string]*Schema
+	Required             []string
+	AdditionalProperties *bool
+	Items                *Schema
+	MinItems, MaxItems   *int
+	MinLength, MaxLength *int
+	Pattern              *regexp.Regexp
+	Minimum, Maximum     *float64
+}
+
+// ParseSchema compiles a JSON Schema document.
+func ParseSchema(data []byte) (*Schema, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("cascade: json_schema: %w", err)
+	}
+	return compileSchema(raw, "")
+}
+
+func compileSchema(raw map[string]json.RawMessage, path string) (*Schema, error) {
+	s := &Schema{}
+	fail := func(keyword string, err error) (*Schema, error) {
+		return nil, fmt.Errorf("cascade: json_schema: %s%s: %w", path, keyword, err)
+	}
+
+	if t, ok := raw["type"]; ok {
+		var one string
+		if json.Unmarshal(t, &one) == nil {
+			s.Types = []string{one}
+		} else if err := json.Unmarshal(t, &s.Types); err != nil {
+			return fail("type", err)
+		}
+	}
+	if e, ok := raw["enum"]; ok {
+		if err := json.Unmarshal(e, &s.Enum); err != nil {
+			return fail("enum", err)
+		}
+	}
+	if p, ok := raw["properties"]; ok {
+		var props map[string]map[string]json.RawMessage
+		if err := json.Unmarshal(p, &props); err != nil {
+			return fail("properties", err)
+		}
+		s.Properties = make(map[string]*Schema, len(props))
+		for name, sub := range props {
+			cs, err := compileSchema(sub, path+"properties."+name+".")
+			if err != nil {
+				return nil, err
+			}
+			s.Properties[name] = cs
+		}
+	}
+	if r, ok := raw["required"]; ok {
+		if err := json.Unmarshal(r, &s.Required); err != nil {
+			return fail("required", err)
+		}
+	}
+	if a, ok := raw["additionalProperties"]; ok {
+		var b bool
+		if json.Unmarshal(a, &b) == nil {
+			s.AdditionalProperties = &b
+		}
+	}
+	if i, ok := raw["items"]; ok {
+		var sub map[string]json.RawMessage
+		if err := json.Unmarshal(i, &sub); err != nil {
+			return fail("items", err)
+		}
+		cs, err := compileSchema(sub, path+"items.")
+		if err != nil {
+			return nil, err
+		}
+		s.Items = cs
+	}
+	for keyword, dst := range map[string]**int{
+		"minItems": &s.MinItems, "maxItems": &s.MaxItems,
+		"minLength": &s.MinLength, "maxLength": &s.MaxLength,
+	} {
+		if v, ok := raw[keyword]; ok {
+			var n int
+			if err := json.Unmarshal(v, &n); err != nil {
+				return fail(keyword, err)
+			}
+			*dst = &n
+		}
+	}
+	for keyword, dst := range map[string]**float64{"minimum": &s.Minimum, "maximum": &s.Maximum} {
+		if v, ok := raw[keyword]; ok {
+			var n float64
+			if err := json.Unmarshal(v, &n); err != nil {
+				return fail(keyword, err)
+			}
+			*dst = &n
+		}
+	}
+	if p, ok := raw["pattern"]; ok {
+		var expr string
+		if err := json.Unmarshal(p, &expr); err != nil {
+			return fail("pattern", err)
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fail("pattern", err)
+		}
+		s.Pattern = re
+	}
+	return s, nil
+}
+
+// Validate checks a decoded JSON value (as produced by encoding/json into an
+// interface{}) against the schema.
+func (s *Schema) Validate(v interface{}) error {
+	return s.validate(v, "$")
+}
+
+func (s *Schema) validate(v interface{}, path string) error {
+	if len(s.Types) > 0 && !s.typeMatches(v) {
+		return fmt.Errorf("%s: want type %v, got %s", path, s.Types, jsonType(v))
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value is not one of %v", path, s.Enum)
+		}
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := val[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		names := make([]string, 0, len(val))
+		for name := range val {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			sub, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s: unexpected property %q", path, name)
+				}
+				continue
+			}
+			if err := sub.validate(val[name], path+"."+name); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(val) < *s.MinItems {
+			return fmt.Errorf("%s: %d items, fewer than %d", path, len(val), *s.MinItems)
+		}
+		if s.MaxItems != nil && len(val) > *s.MaxItems {
+			return fmt.Errorf("%s: %d items, more than %d", path, len(val), *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range val {
+				if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(val)
+		if s.MinLength != nil && n < *s.MinLength {
+			return fmt.Errorf("%s: string shorter than %d", path, *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return fmt.Errorf("%s: string longer than %d", path, *s.MaxLength)
+		}
+		if s.Pattern != nil && !s.Pattern.MatchString(val) {
+			return fmt.Errorf("%s: string does not match %s", path, s.Pattern)
+		}
+	case float64:
+		if s.Minimum != nil && val < *s.Minimum {
+			return fmt.Errorf("%s: %g is less than %g", path, val, *s.Minimum)
+		}
+		if s.Maximum != nil && val > *s.Maximum {
+			return fmt.Errorf("%s: %g is greater than %g", path, val, *s.Maximum)
+		}
+	}
+	return nil
+}
+
+func (s *Schema) typeMatches(v interface{}) bool {
+	actual := jsonType(v)
+	for _, t := range s.Types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType returns the JSON Schema type name of a decoded value.
+func jsonType(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if val == math.Trunc(val) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
@@ -0,0 +1,192 @@
+// Package certs loads the TLS server certificate and the client CA bundle
+// used for mutual TLS, and reloads them when the files change so that
+// certificates can be rotated without restarting tokenman.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// Client certificate modes for mutual TLS.
+const (
+	ClientAuthRequire  = "require"  // every client must present a valid certificate
+	ClientAuthOptional = "optional" // certificates are verified when presented
+)
+
+// Reloader serves a certificate and client CA pool that are re-read from
+// disk whenever one of their files changes. A reload that fails keeps the
+// previous certificates in use.
+type Reloader struct {
+	certFile   string
+	keyFile    string
+	caFile     string // empty disables client certificate verification
+	clientAuth tls.ClientAuthType
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	pool *x509.CertPool
+
+	fsWatcher *fsnotify.Watcher
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewReloader loads certFile and keyFile and, when caFile is set, the PEM
+// bundle of CAs that client certificates must chain to. clientAuth is
+// ClientAuthRequire or ClientAuthOptional and is ignored without caFile.
+func NewReloader(certFile, keyFile, caFile, clientAuth string) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		done:     make(chan struct{}),
+	}
+	switch {
+	case caFile == "":
+		r.clientAuth = tls.NoClientCert
+	case clientAuth == ClientAuthOptional:
+		r.clientAuth = tls.VerifyClientCertIfGiven
+	default:
+		r.clientAuth = tls.RequireAndVerifyClientCert
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads the certificate, key, and CA files.
+func (r *Reloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("certs: load key pair: %w", err)
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("certs: read client CA file: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("certs: no certificates found in client CA file %s", r.caFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.pool = pool
+	r.mu.Unlock()
+	return nil
+}
+
+// TLSConfig returns a server TLS configuration that always uses the most
+// recently loaded certificate and CA pool. Client certificates are only
+// requested when clientCerts is true.
+func (r *Reloader) TLSConfig(clientCerts bool) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+			}
+			if clientCerts {
+				cfg.ClientAuth = r.clientAuth
+				cfg.ClientCAs = r.pool
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// Watch starts reloading the certificates when their files change. It
+// watches the containing directories, so atomic renames and Kubernetes
+// secret updates are picked up.
+func (r *Reloader) Watch() error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("certs: create watcher: %w", err)
+	}
+	dirs := make(map[string]bool)
+	for _, f := range []string{r.certFile, r.keyFile, r.caFile} {
+		if f == "" {
+			continue
+		}
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			fsw.Close()
+			return fmt.Errorf("certs: resolve %s: %w", f, err)
+		}
+		dirs[filepath.Dir(abs)] = true
+	}
+	for dir := range dirs {
+		if err := fsw.Add(dir); err != nil {
+			fsw.Close()
+			return fmt.Errorf("certs: watch %s: %w", dir, err)
+		}
+	}
+	r.fsWatcher = fsw
+	go r.loop()
+	return nil
+}
+
+// loop reloads, debounced, after any change in a watched directory.
+func (r *Reloader) loop() {
+	const debounce = 200 * time.Millisecond
+	var timer *time.Timer
+
+	for {
+		select {
+		case <-r.done:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+
+		case _, ok := <-r.fsWatcher.Events:
+			if !ok {
+				return
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.AfterFunc(debounce, func() {
+				if err := r.Reload(); err != nil {
+					log.Error().Err(err).Msg("certificate reload failed; keeping previous certificates")
+					return
+				}
+				log.Info().Str("cert_file", r.certFile).Msg("TLS certificates reloaded")
+			})
+
+		case err, ok := <-r.fsWatcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warn().Err(err).Msg("certificate watcher error")
+		}
+	}
+}
+
+// Close stops watching for changes.
+func (r *Reloader) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		close(r.done)
+		if r.fsWatcher != nil {
+			err = r.fsWatcher.Close()
+		}
+	})
+	return err
+}
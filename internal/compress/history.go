@@ -47,6 +47,12 @@ func (h *HistoryMiddleware) ProcessRequest(_ context.Context, req *pipeline.Requ
 		}
 	}
 
+	// A request chained onto a stored response carries only the new turn;
+	// the conversation it would compress lives upstream.
+	if req.PreviousResponseID != "" {
+		return req, nil
+	}
+
 	totalMessages := len(req.Messages)
 	if totalMessages <= h.windowSize {
 		return req, nil
@@ -78,7 +84,7 @@ func (h *HistoryMiddleware) ProcessRequest(_ context.Context, req *pipeline.Requ
 	// Determine the role for the summary message. Use "system" when the
 	// format supports it natively; fall back to "user" otherwise.
 	summaryRole := "user"
-	if req.Format == pipeline.FormatOpenAI {
+	if req.Format == pipeline.FormatOpenAI || req.Format == pipeline.FormatOpenAIResponses {
 		summaryRole = "system"
 	}
 
@@ -152,6 +152,29 @@ func TestHistoryMiddleware_NoCompressHeaderBypass(t *testing.T) {
 	}
 }
 
+func TestHistoryMiddleware_SkipsChainedResponsesRequest(t *testing.T) {
+	mw := NewHistoryMiddleware(1, true)
+
+	req := &pipeline.Request{
+		Format:             pipeline.FormatOpenAIResponses,
+		PreviousResponseID: "resp_123",
+		Messages: []pipeline.Message{
+			{Role: "tool", ToolCallID: "call_1", Content: "18C"},
+			{Role: "user", Content: "And tomorrow?"},
+		},
+	}
+
+	result, err := mw.ProcessRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ProcessRequest error: %v", err)
+	}
+
+	// The earlier turns live upstream; the new input must reach it intact.
+	if len(result.Messages) != 2 || result.Flags["history_compressed"] {
+		t.Fatalf("expected the chained input untouched, got %+v", result.Messages)
+	}
+}
+
 func TestHistoryMiddleware_MetadataTracksOriginalAndCompressed(t *testing.T) {
 	mw := NewHistoryMiddleware(1, true)
 
@@ -65,6 +65,12 @@ func (s *SummarizationMiddleware) ProcessRequest(ctx context.Context, req *pipel
 		}
 	}
 
+	// A request chained onto a stored response carries only the new turn;
+	// the conversation it would compress lives upstream.
+	if req.PreviousResponseID != "" {
+		return req, nil
+	}
+
 	totalMessages := len(req.Messages)
 	if totalMessages <= s.config.MaxMessages {
 		return req, nil
@@ -91,7 +97,7 @@ func (s *SummarizationMiddleware) ProcessRequest(ctx context.Context, req *pipel
 
 	// Determine the role for the summary message.
 	summaryRole := "user"
-	if req.Format == pipeline.FormatOpenAI {
+	if req.Format == pipeline.FormatOpenAI || req.Format == pipeline.FormatOpenAIResponses {
 		summaryRole = "system"
 	}
 
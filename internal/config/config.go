@@ -47,6 +47,8 @@ type Config struct {
 	Tracing     TracingConfig             `mapstructure:"tracing"     toml:"tracing"`
 	Dashboard   DashboardConfig           `mapstructure:"dashboard"   toml:"dashboard"`
 	Metrics     MetricsConfig             `mapstructure:"metrics"     toml:"metrics"`
+	Alerts      AlertsConfig              `mapstructure:"alerts"      toml:"alerts"`
+	Scheduler   SchedulerConfig           `mapstructure:"scheduler"   toml:"scheduler"`
 	Plugins     PluginConfig              `mapstructure:"plugins"     toml:"plugins"`
 }
 
@@ -70,12 +72,48 @@ type ServerConfig struct {
 	SessionTTL        int    `mapstructure:"session_ttl"          toml:"session_ttl"`
 	StoreBody         bool   `mapstructure:"store_body"           toml:"store_body"`
 	MaxLogBody        int    `mapstructure:"max_log_body"         toml:"max_log_body"`
+	// LogBody and StoreBodyMode choose what reaches trace-level logs and
+	// stored request/response bodies: "raw", "redacted" (PII and secrets
+	// masked), or "none".
+	LogBody       string `mapstructure:"log_body"        toml:"log_body"`
+	StoreBodyMode string `mapstructure:"store_body_mode" toml:"store_body_mode"`
+	// StoreBodySource selects the stored request body: the "original"
+	// client body or the "upstream" body produced by the pipeline.
+	StoreBodySource string `mapstructure:"store_body_source" toml:"store_body_source"`
+	// MTLS requires or accepts client certificates on the TLS listeners.
+	MTLS MTLSConfig `mapstructure:"mtls" toml:"mtls"`
+}
+
+// MTLSConfig controls mutual TLS. Client certificates are verified against
+// ClientCAFile; a verified certificate authenticates the caller for the
+// proxy without a bearer token. The certificate, key, and CA files are
+// reloaded when they change.
+type MTLSConfig struct {
+	Enabled      bool   `mapstructure:"enabled"        toml:"enabled"`
+	ClientCAFile string `mapstructure:"client_ca_file" toml:"client_ca_file"`
+	ClientAuth   string `mapstructure:"client_auth"    toml:"client_auth"` // "require" or "optional"
+	// Dashboard applies the same client certificate checks to the
+	// dashboard listener.
+	Dashboard  bool                 `mapstructure:"dashboard"  toml:"dashboard"`
+	Identities []CertIdentityConfig `mapstructure:"identities" toml:"identities"`
+}
+
+// CertIdentityConfig maps client certificates to a project and optionally a
+// virtual key. Match is a glob compared with the certificate's common name,
+// full subject, and DNS, email, and URI SANs; the first match wins.
+type CertIdentityConfig struct {
+	Match   string `mapstructure:"match"   toml:"match"`
+	Project string `mapstructure:"project" toml:"project"`
+	KeyID   string `mapstructure:"key_id"  toml:"key_id"`
 }
 
 // AuthConfig holds the dashboard authentication settings.
 type AuthConfig struct {
 	Enabled bool   `mapstructure:"enabled" toml:"enabled"`
 	Token   string `mapstructure:"token"   toml:"token"`
+	// TokenHash is a salted hash of the shared token, generated with
+	// "tokenman hash-token", used instead of keeping Token in plaintext.
+	TokenHash string `mapstructure:"token_hash" toml:"token_hash"`
 }
 
 // ProviderConfig describes a single LLM provider.
@@ -87,6 +125,25 @@ type ProviderConfig struct {
 	Enabled  bool   `mapstructure:"enabled"  toml:"enabled"`
 	Priority int    `mapstructure:"priority" toml:"priority"`
 	Timeout  int    `mapstructure:"timeout"  toml:"timeout"` // seconds
+
+	// Keys pools several API keys for the provider. When set, KeyRef is
+	// ignored and requests are spread across the keys using KeyStrategy
+	// ("round_robin", "least_limited", or "weighted").
+	Keys        []ProviderKeyConfig `mapstructure:"keys"         toml:"keys,omitempty"`
+	KeyStrategy string              `mapstructure:"key_strategy" toml:"key_strategy,omitempty"`
+
+	// ProbeModel, when set, makes active health checks send a one-token
+	// completion to this model instead of listing the provider's models.
+	ProbeModel string `mapstructure:"probe_model" toml:"probe_model,omitempty"`
+}
+
+// ProviderKeyConfig is one API key in a provider's key pool. Name labels the
+// key in metrics and the requests table; it defaults to a fingerprint of the
+// key. Weight only applies to the "weighted" strategy and defaults to 1.
+type ProviderKeyConfig struct {
+	Name   string `mapstructure:"name"   toml:"name,omitempty"`
+	Ref    string `mapstructure:"ref"    toml:"ref"`
+	Weight int    `mapstructure:"weight" toml:"weight,omitempty"`
 }
 
 // TimeoutDuration returns the provider timeout as a time.Duration.
@@ -103,6 +160,68 @@ type RoutingConfig struct {
 	ModelMap        map[string]string `mapstructure:"model_map"        toml:"model_map"`
 	HeartbeatModel  string            `mapstructure:"heartbeat_model"  toml:"heartbeat_model"`
 	FallbackEnabled bool              `mapstructure:"fallback_enabled" toml:"fallback_enabled"`
+
+	// Strategy orders the providers able to serve a request: "priority"
+	// (static priority), "lowest_cost" (cheapest provider whose rolling p95
+	// latency is within slo_p95_ms and error rate within max_error_rate),
+	// "lowest_latency", or "weighted_random".
+	Strategy     string  `mapstructure:"strategy"       toml:"strategy"`
+	SLOP95Ms     int     `mapstructure:"slo_p95_ms"     toml:"slo_p95_ms"`
+	MaxErrorRate float64 `mapstructure:"max_error_rate" toml:"max_error_rate"`
+
+	// Hedging sends a duplicate of a slow non-streaming request to the next
+	// fallback provider once the primary has not answered within
+	// hedge_delay_ms (0 = the primary's rolling p95 latency), and uses
+	// whichever response arrives first. Hedges are capped at
+	// hedge_budget_percent of each provider's requests.
+	HedgeEnabled       bool    `mapstructure:"hedge_enabled"        toml:"hedge_enabled"`
+	HedgeDelayMs       int     `mapstructure:"hedge_delay_ms"       toml:"hedge_delay_ms"`
+	HedgeBudgetPercent float64 `mapstructure:"hedge_budget_percent" toml:"hedge_budget_percent"`
+
+	// Aliases maps a virtual model name such as "fast" to the concrete
+	// model each provider serves for it, e.g. aliases.fast.anthropic =
+	// "claude-haiku-4-20250414".
+	Aliases map[string]map[string]string `mapstructure:"aliases" toml:"aliases,omitempty"`
+	// Rules route models matching a pattern to a provider. They are tried
+	// in order after model_map and before provider model lists.
+	Rules []RouteRuleConfig `mapstructure:"rules" toml:"rules,omitempty"`
+	// Cascades answer matching non-streaming requests with a cheap model
+	// first and escalate to a stronger one when the answer fails a check.
+	Cascades []CascadeConfig `mapstructure:"cascades" toml:"cascades,omitempty"`
+}
+
+// CascadeConfig sends requests from Projects, or for the virtual model names
+// in Aliases, to CheapModel first. When the answer fails any of Checks the
+// request is escalated to StrongModel, which defaults to the requested model
+// and is required when Aliases is set.
+type CascadeConfig struct {
+	Name        string              `mapstructure:"name"         toml:"name"`
+	Projects    []string            `mapstructure:"projects"     toml:"projects,omitempty"`
+	Aliases     []string            `mapstructure:"aliases"      toml:"aliases,omitempty"`
+	CheapModel  string              `mapstructure:"cheap_model"  toml:"cheap_model"`
+	StrongModel string              `mapstructure:"strong_model" toml:"strong_model,omitempty"`
+	Checks      CascadeChecksConfig `mapstructure:"checks"       toml:"checks"`
+}
+
+// CascadeChecksConfig selects the local checks a cheap answer must pass.
+type CascadeChecksConfig struct {
+	JSON       bool   `mapstructure:"json"        toml:"json"`        // answer must be valid JSON
+	JSONSchema string `mapstructure:"json_schema" toml:"json_schema"` // JSON Schema the answer must satisfy
+	Regex      string `mapstructure:"regex"       toml:"regex"`
+	MinLength  int    `mapstructure:"min_length"  toml:"min_length"`
+	MaxLength  int    `mapstructure:"max_length"  toml:"max_length"`
+	Refusal    bool   `mapstructure:"refusal"     toml:"refusal"`    // escalate refusals
+	MaxTokens  bool   `mapstructure:"max_tokens"  toml:"max_tokens"` // escalate answers cut off by max_tokens
+}
+
+// RouteRuleConfig routes models matching Match (a glob such as
+// "claude-sonnet-4-*") or Regex (an RE2 expression matched against the whole
+// name) to Provider. Model, if set, rewrites matching requests to it.
+type RouteRuleConfig struct {
+	Match    string `mapstructure:"match"    toml:"match,omitempty"`
+	Regex    string `mapstructure:"regex"    toml:"regex,omitempty"`
+	Provider string `mapstructure:"provider" toml:"provider"`
+	Model    string `mapstructure:"model"    toml:"model,omitempty"`
 }
 
 // CompressionConfig groups the token-compression sub-sections.
@@ -159,24 +278,90 @@ type PluginConfig struct {
 
 // SecurityConfig groups the security sub-sections.
 type SecurityConfig struct {
-	PII       PIIConfig       `mapstructure:"pii"        toml:"pii"`
-	Injection InjectionConfig `mapstructure:"injection"  toml:"injection"`
-	Budget    BudgetConfig    `mapstructure:"budget"     toml:"budget"`
-	RateLimit RateLimitConfig `mapstructure:"rate_limit" toml:"rate_limit"`
-}
-
-// RateLimitConfig controls per-provider rate limiting.
+	PII        PIIConfig        `mapstructure:"pii"        toml:"pii"`
+	Injection  InjectionConfig  `mapstructure:"injection"  toml:"injection"`
+	Budget     BudgetConfig     `mapstructure:"budget"     toml:"budget"`
+	RateLimit  RateLimitConfig  `mapstructure:"rate_limit" toml:"rate_limit"`
+	Policy     PolicyConfig     `mapstructure:"policy"     toml:"policy"`
+	Encryption EncryptionConfig `mapstructure:"encryption" toml:"encryption"`
+}
+
+// EncryptionConfig controls encryption at rest of stored request and
+// response bodies and cached responses. KeyRef names the master key in any
+// format accepted by vault.ResolveKeyRef; the key itself is 32 bytes, hex
+// or base64 encoded, as printed by "tokenman encryption init".
+type EncryptionConfig struct {
+	Enabled bool   `mapstructure:"enabled" toml:"enabled"`
+	KeyRef  string `mapstructure:"key_ref" toml:"key_ref"`
+}
+
+// PolicyConfig controls the request policy engine. Rules are evaluated in
+// order; an allow or deny rule ends evaluation, while rewrite and downgrade
+// rules change the request and evaluation continues.
+type PolicyConfig struct {
+	Enabled       bool         `mapstructure:"enabled"        toml:"enabled"`
+	DefaultAction string       `mapstructure:"default_action" toml:"default_action"` // "allow" or "deny"
+	Rules         []PolicyRule `mapstructure:"rules"          toml:"rules"`
+}
+
+// PolicyRule is one ordered policy rule.
+type PolicyRule struct {
+	Name   string      `mapstructure:"name"   toml:"name"`
+	Match  PolicyMatch `mapstructure:"match"  toml:"match"`
+	Action string      `mapstructure:"action" toml:"action"` // "allow", "deny", "rewrite", "downgrade"
+	// Message is returned to the client when a deny rule matches.
+	Message string `mapstructure:"message" toml:"message"`
+	// Set lists request fields to overwrite for a rewrite rule, e.g.
+	// {max_tokens = 8192, temperature = 0}.
+	Set map[string]interface{} `mapstructure:"set" toml:"set"`
+	// Model is the replacement model for a downgrade rule.
+	Model string `mapstructure:"model" toml:"model"`
+}
+
+// PolicyMatch selects the requests a rule applies to. Every non-empty
+// condition must hold; a list condition holds when any entry matches.
+// Models and Tools accept globs such as "claude-opus-*".
+type PolicyMatch struct {
+	Projects        []string `mapstructure:"projects"          toml:"projects"`
+	Keys            []string `mapstructure:"keys"              toml:"keys"`       // virtual key IDs
+	KeyOwners       []string `mapstructure:"key_owners"        toml:"key_owners"` // virtual key owners
+	Models          []string `mapstructure:"models"            toml:"models"`
+	Providers       []string `mapstructure:"providers"         toml:"providers"`
+	Tools           []string `mapstructure:"tools"             toml:"tools"`
+	ContentTypes    []string `mapstructure:"content_types"     toml:"content_types"` // e.g. "image", "document", "tool_result"
+	InputTokensOver int      `mapstructure:"input_tokens_over" toml:"input_tokens_over"`
+	MaxTokensOver   int      `mapstructure:"max_tokens_over"   toml:"max_tokens_over"`
+}
+
+// RateLimitConfig controls per-provider and per-model rate limiting.
+// Requests over a limit wait in a per-provider queue; they are rejected only
+// when the queue is full or they have waited MaxWaitSeconds.
 type RateLimitConfig struct {
-	Enabled        bool                        `mapstructure:"enabled"         toml:"enabled"`
-	DefaultRate    float64                     `mapstructure:"default_rate"    toml:"default_rate"`    // requests per second
-	DefaultBurst   int                         `mapstructure:"default_burst"   toml:"default_burst"`
-	ProviderLimits map[string]ProviderRateLimit `mapstructure:"provider_limits" toml:"provider_limits"`
+	Enabled              bool                         `mapstructure:"enabled"                toml:"enabled"`
+	DefaultRate          float64                      `mapstructure:"default_rate"           toml:"default_rate"`           // requests per second
+	DefaultBurst         int                          `mapstructure:"default_burst"          toml:"default_burst"`
+	DefaultTPM           int                          `mapstructure:"default_tpm"            toml:"default_tpm"`            // tokens per minute, 0 = unlimited
+	DefaultMaxConcurrent int                          `mapstructure:"default_max_concurrent" toml:"default_max_concurrent"` // 0 = unlimited
+	QueueSize            int                          `mapstructure:"queue_size"             toml:"queue_size"`             // waiting requests per provider
+	MaxWaitSeconds       int                          `mapstructure:"max_wait_seconds"       toml:"max_wait_seconds"`       // 0 = reject immediately
+	ProviderLimits       map[string]ProviderRateLimit `mapstructure:"provider_limits"        toml:"provider_limits"`
+	ModelLimits          map[string]ModelRateLimit    `mapstructure:"model_limits"           toml:"model_limits"`
 }
 
 // ProviderRateLimit defines rate limit settings for a specific provider.
+// Zero TPM and MaxConcurrent fall back to the defaults.
 type ProviderRateLimit struct {
-	Rate  float64 `mapstructure:"rate"  toml:"rate"`
-	Burst int     `mapstructure:"burst" toml:"burst"`
+	Rate          float64 `mapstructure:"rate"           toml:"rate"`
+	Burst         int     `mapstructure:"burst"          toml:"burst"`
+	TPM           int     `mapstructure:"tpm"            toml:"tpm"`
+	MaxConcurrent int     `mapstructure:"max_concurrent" toml:"max_concurrent"`
+}
+
+// ModelRateLimit defines limits for a specific model, applied in addition to
+// its provider's limits. Zero means unlimited.
+type ModelRateLimit struct {
+	TPM           int `mapstructure:"tpm"            toml:"tpm"`
+	MaxConcurrent int `mapstructure:"max_concurrent" toml:"max_concurrent"`
 }
 
 // PIIConfig controls PII detection and remediation.
@@ -188,8 +373,25 @@ type PIIConfig struct {
 
 // InjectionConfig controls prompt-injection detection.
 type InjectionConfig struct {
-	Enabled bool   `mapstructure:"enabled" toml:"enabled"`
-	Action  string `mapstructure:"action"  toml:"action"`
+	Enabled        bool             `mapstructure:"enabled"         toml:"enabled"`
+	Action         string           `mapstructure:"action"          toml:"action"`
+	LogThreshold   float64          `mapstructure:"log_threshold"   toml:"log_threshold"`
+	BlockThreshold float64          `mapstructure:"block_threshold" toml:"block_threshold"`
+	ToolResults    ToolResultConfig `mapstructure:"tool_results"    toml:"tool_results"`
+}
+
+// ToolResultConfig controls how tool outputs are scanned for indirect prompt
+// injection. Trust maps tool names to "trusted", "standard", or "untrusted".
+// An empty Action and zero thresholds fall back to the InjectionConfig
+// settings, so tool output is never treated more leniently than messages by
+// default.
+type ToolResultConfig struct {
+	Enabled        bool              `mapstructure:"enabled"         toml:"enabled"`
+	Action         string            `mapstructure:"action"          toml:"action"`
+	LogThreshold   float64           `mapstructure:"log_threshold"   toml:"log_threshold"`
+	BlockThreshold float64           `mapstructure:"block_threshold" toml:"block_threshold"`
+	DefaultTrust   string            `mapstructure:"default_trust"   toml:"default_trust"`
+	Trust          map[string]string `mapstructure:"trust"           toml:"trust"`
 }
 
 // BudgetConfig controls spend budgets and alerts.
@@ -199,6 +401,24 @@ type BudgetConfig struct {
 	DailyLimit      int       `mapstructure:"daily_limit"      toml:"daily_limit"`
 	MonthlyLimit    int       `mapstructure:"monthly_limit"    toml:"monthly_limit"`
 	AlertThresholds []float64 `mapstructure:"alert_thresholds" toml:"alert_thresholds"`
+	// ReservationTTLSeconds is how long a request's worst-case cost stays
+	// reserved if the request never completes.
+	ReservationTTLSeconds int `mapstructure:"reservation_ttl_seconds" toml:"reservation_ttl_seconds"`
+	// Scopes are additional budgets for a project, virtual key, model, or
+	// provider. A request must fit within the global limits and every
+	// scoped budget that matches it.
+	Scopes []ScopedBudgetConfig `mapstructure:"scopes" toml:"scopes"`
+}
+
+// ScopedBudgetConfig is a spend budget that applies to a subset of requests.
+// Match is an exact value or a glob such as "team-*"; every distinct value
+// that matches gets its own budget.
+type ScopedBudgetConfig struct {
+	Scope        string  `mapstructure:"scope"         toml:"scope"` // "project", "key", "model", or "provider"
+	Match        string  `mapstructure:"match"         toml:"match"`
+	HourlyLimit  float64 `mapstructure:"hourly_limit"  toml:"hourly_limit"`
+	DailyLimit   float64 `mapstructure:"daily_limit"   toml:"daily_limit"`
+	MonthlyLimit float64 `mapstructure:"monthly_limit" toml:"monthly_limit"`
 }
 
 // TracingConfig controls OpenTelemetry distributed tracing.
@@ -216,12 +436,61 @@ type DashboardConfig struct {
 	Enabled        bool     `mapstructure:"enabled"         toml:"enabled"`
 	AutoOpen       bool     `mapstructure:"auto_open"       toml:"auto_open"`
 	AllowedOrigins []string `mapstructure:"allowed_origins" toml:"allowed_origins"`
+	// Tokens are additional dashboard bearer tokens, each limited to a role.
+	Tokens []DashboardToken `mapstructure:"tokens" toml:"tokens"`
+}
+
+// DashboardToken is a dashboard bearer token stored as a salted hash.
+type DashboardToken struct {
+	Name string `mapstructure:"name" toml:"name"`
+	Role string `mapstructure:"role" toml:"role"` // "viewer", "auditor", or "admin"
+	Hash string `mapstructure:"hash" toml:"hash"`
 }
 
 // MetricsConfig controls metrics storage and caching.
 type MetricsConfig struct {
 	RetentionDays   int `mapstructure:"retention_days"    toml:"retention_days"`
 	CacheTTLSeconds int `mapstructure:"cache_ttl_seconds" toml:"cache_ttl_seconds"`
+	// EmbeddingCacheTTLSeconds is how long a cached /v1/embeddings vector is
+	// reused for the same model and input.
+	EmbeddingCacheTTLSeconds int `mapstructure:"embedding_cache_ttl_seconds" toml:"embedding_cache_ttl_seconds"`
+}
+
+// AlertsConfig controls delivery of budget, circuit breaker, and PII alerts.
+// Alerts are always recorded in the local alert log when enabled; Sinks lists
+// additional destinations.
+type AlertsConfig struct {
+	Enabled bool              `mapstructure:"enabled" toml:"enabled"`
+	Sinks   []AlertSinkConfig `mapstructure:"sinks"   toml:"sinks"`
+}
+
+// AlertSinkConfig configures one alert destination.
+type AlertSinkConfig struct {
+	Type    string            `mapstructure:"type"    toml:"type"`    // "webhook", "slack", "command", "desktop"
+	URL     string            `mapstructure:"url"     toml:"url"`     // webhook and slack
+	Headers map[string]string `mapstructure:"headers" toml:"headers"` // extra webhook request headers
+	Command []string          `mapstructure:"command" toml:"command"` // program and arguments
+	Kinds   []string          `mapstructure:"kinds"   toml:"kinds"`   // alert kinds to deliver; empty means all
+}
+
+// SchedulerConfig controls priority scheduling of upstream requests. When
+// more than MaxConcurrent requests are ready to be forwarded, they wait in
+// priority classes ("interactive", "default", "batch"); higher classes are
+// always served first, and projects within a class share capacity in
+// proportion to their weights.
+type SchedulerConfig struct {
+	Enabled         bool                       `mapstructure:"enabled"          toml:"enabled"`
+	MaxConcurrent   int                        `mapstructure:"max_concurrent"   toml:"max_concurrent"`
+	MaxWaitSeconds  int                        `mapstructure:"max_wait_seconds" toml:"max_wait_seconds"`
+	DefaultPriority string                     `mapstructure:"default_priority" toml:"default_priority"`
+	Projects        map[string]ProjectSchedule `mapstructure:"projects"         toml:"projects"`
+}
+
+// ProjectSchedule sets a project's priority class and its fair-share weight
+// within that class. A zero weight counts as 1.
+type ProjectSchedule struct {
+	Priority string  `mapstructure:"priority" toml:"priority"`
+	Weight   float64 `mapstructure:"weight"   toml:"weight"`
 }
 
 // ResilienceConfig controls retry, circuit breaker, and related resilience settings.
@@ -233,6 +502,17 @@ type ResilienceConfig struct {
 	CBFailureThreshold int  `mapstructure:"cb_failure_threshold"     toml:"cb_failure_threshold"`
 	CBResetTimeoutSec  int  `mapstructure:"cb_reset_timeout_seconds" toml:"cb_reset_timeout_seconds"`
 	CBHalfOpenMax      int  `mapstructure:"cb_half_open_max_calls"   toml:"cb_half_open_max_calls"`
+	PacingMaxDelayMs   int  `mapstructure:"pacing_max_delay_ms"      toml:"pacing_max_delay_ms"`
+
+	// Active health checks probe every provider each
+	// HealthCheckIntervalSec. A provider is marked unhealthy after
+	// HealthCheckUnhealthyThreshold consecutive failed probes or requests,
+	// and healthy again after HealthCheckHealthyThreshold successes.
+	HealthCheckEnabled            bool `mapstructure:"health_check_enabled"             toml:"health_check_enabled"`
+	HealthCheckIntervalSec        int  `mapstructure:"health_check_interval_seconds"    toml:"health_check_interval_seconds"`
+	HealthCheckTimeoutSec         int  `mapstructure:"health_check_timeout_seconds"     toml:"health_check_timeout_seconds"`
+	HealthCheckUnhealthyThreshold int  `mapstructure:"health_check_unhealthy_threshold" toml:"health_check_unhealthy_threshold"`
+	HealthCheckHealthyThreshold   int  `mapstructure:"health_check_healthy_threshold"   toml:"health_check_healthy_threshold"`
 }
 
 // Load reads configuration from disk with the following precedence:
@@ -407,15 +687,29 @@ func setViperDefaults(v *viper.Viper) {
 	v.SetDefault("server.max_body_size", d.Server.MaxBodySize)
 	v.SetDefault("server.store_body", d.Server.StoreBody)
 	v.SetDefault("server.max_log_body", d.Server.MaxLogBody)
+	v.SetDefault("server.log_body", d.Server.LogBody)
+	v.SetDefault("server.store_body_mode", d.Server.StoreBodyMode)
+	v.SetDefault("server.store_body_source", d.Server.StoreBodySource)
+	v.SetDefault("server.mtls.enabled", d.Server.MTLS.Enabled)
+	v.SetDefault("server.mtls.client_ca_file", d.Server.MTLS.ClientCAFile)
+	v.SetDefault("server.mtls.client_auth", d.Server.MTLS.ClientAuth)
+	v.SetDefault("server.mtls.dashboard", d.Server.MTLS.Dashboard)
 
 	// Auth
 	v.SetDefault("auth.enabled", d.Auth.Enabled)
 	v.SetDefault("auth.token", d.Auth.Token)
+	v.SetDefault("auth.token_hash", d.Auth.TokenHash)
 
 	// Routing
 	v.SetDefault("routing.default_provider", d.Routing.DefaultProvider)
 	v.SetDefault("routing.heartbeat_model", d.Routing.HeartbeatModel)
 	v.SetDefault("routing.fallback_enabled", d.Routing.FallbackEnabled)
+	v.SetDefault("routing.strategy", d.Routing.Strategy)
+	v.SetDefault("routing.slo_p95_ms", d.Routing.SLOP95Ms)
+	v.SetDefault("routing.max_error_rate", d.Routing.MaxErrorRate)
+	v.SetDefault("routing.hedge_enabled", d.Routing.HedgeEnabled)
+	v.SetDefault("routing.hedge_delay_ms", d.Routing.HedgeDelayMs)
+	v.SetDefault("routing.hedge_budget_percent", d.Routing.HedgeBudgetPercent)
 
 	// Compression.Dedup
 	v.SetDefault("compression.dedup.enabled", d.Compression.Dedup.Enabled)
@@ -451,18 +745,38 @@ func setViperDefaults(v *viper.Viper) {
 	// Security.Injection
 	v.SetDefault("security.injection.enabled", d.Security.Injection.Enabled)
 	v.SetDefault("security.injection.action", d.Security.Injection.Action)
+	v.SetDefault("security.injection.log_threshold", d.Security.Injection.LogThreshold)
+	v.SetDefault("security.injection.block_threshold", d.Security.Injection.BlockThreshold)
+	v.SetDefault("security.injection.tool_results.enabled", d.Security.Injection.ToolResults.Enabled)
+	v.SetDefault("security.injection.tool_results.action", d.Security.Injection.ToolResults.Action)
+	v.SetDefault("security.injection.tool_results.log_threshold", d.Security.Injection.ToolResults.LogThreshold)
+	v.SetDefault("security.injection.tool_results.block_threshold", d.Security.Injection.ToolResults.BlockThreshold)
+	v.SetDefault("security.injection.tool_results.default_trust", d.Security.Injection.ToolResults.DefaultTrust)
+	v.SetDefault("security.injection.tool_results.trust", d.Security.Injection.ToolResults.Trust)
 
 	// Security.Budget
+	v.SetDefault("security.policy.enabled", d.Security.Policy.Enabled)
+	v.SetDefault("security.policy.default_action", d.Security.Policy.DefaultAction)
 	v.SetDefault("security.budget.enabled", d.Security.Budget.Enabled)
 	v.SetDefault("security.budget.hourly_limit", d.Security.Budget.HourlyLimit)
 	v.SetDefault("security.budget.daily_limit", d.Security.Budget.DailyLimit)
 	v.SetDefault("security.budget.monthly_limit", d.Security.Budget.MonthlyLimit)
 	v.SetDefault("security.budget.alert_thresholds", d.Security.Budget.AlertThresholds)
+	v.SetDefault("security.budget.reservation_ttl_seconds", d.Security.Budget.ReservationTTLSeconds)
+	v.SetDefault("security.budget.scopes", d.Security.Budget.Scopes)
+
+	// Security.Encryption
+	v.SetDefault("security.encryption.enabled", d.Security.Encryption.Enabled)
+	v.SetDefault("security.encryption.key_ref", d.Security.Encryption.KeyRef)
 
 	// Security.RateLimit
 	v.SetDefault("security.rate_limit.enabled", d.Security.RateLimit.Enabled)
 	v.SetDefault("security.rate_limit.default_rate", d.Security.RateLimit.DefaultRate)
 	v.SetDefault("security.rate_limit.default_burst", d.Security.RateLimit.DefaultBurst)
+	v.SetDefault("security.rate_limit.default_tpm", d.Security.RateLimit.DefaultTPM)
+	v.SetDefault("security.rate_limit.default_max_concurrent", d.Security.RateLimit.DefaultMaxConcurrent)
+	v.SetDefault("security.rate_limit.queue_size", d.Security.RateLimit.QueueSize)
+	v.SetDefault("security.rate_limit.max_wait_seconds", d.Security.RateLimit.MaxWaitSeconds)
 
 	// Dashboard
 	v.SetDefault("dashboard.enabled", d.Dashboard.Enabled)
@@ -472,6 +786,17 @@ func setViperDefaults(v *viper.Viper) {
 	// Metrics
 	v.SetDefault("metrics.retention_days", d.Metrics.RetentionDays)
 	v.SetDefault("metrics.cache_ttl_seconds", d.Metrics.CacheTTLSeconds)
+	v.SetDefault("metrics.embedding_cache_ttl_seconds", d.Metrics.EmbeddingCacheTTLSeconds)
+
+	// Alerts
+	v.SetDefault("alerts.enabled", d.Alerts.Enabled)
+	v.SetDefault("alerts.sinks", d.Alerts.Sinks)
+
+	// Scheduler
+	v.SetDefault("scheduler.enabled", d.Scheduler.Enabled)
+	v.SetDefault("scheduler.max_concurrent", d.Scheduler.MaxConcurrent)
+	v.SetDefault("scheduler.max_wait_seconds", d.Scheduler.MaxWaitSeconds)
+	v.SetDefault("scheduler.default_priority", d.Scheduler.DefaultPriority)
 
 	// Resilience
 	v.SetDefault("resilience.retry_max_attempts", d.Resilience.RetryMaxAttempts)
@@ -481,6 +806,12 @@ func setViperDefaults(v *viper.Viper) {
 	v.SetDefault("resilience.cb_failure_threshold", d.Resilience.CBFailureThreshold)
 	v.SetDefault("resilience.cb_reset_timeout_seconds", d.Resilience.CBResetTimeoutSec)
 	v.SetDefault("resilience.cb_half_open_max_calls", d.Resilience.CBHalfOpenMax)
+	v.SetDefault("resilience.pacing_max_delay_ms", d.Resilience.PacingMaxDelayMs)
+	v.SetDefault("resilience.health_check_enabled", d.Resilience.HealthCheckEnabled)
+	v.SetDefault("resilience.health_check_interval_seconds", d.Resilience.HealthCheckIntervalSec)
+	v.SetDefault("resilience.health_check_timeout_seconds", d.Resilience.HealthCheckTimeoutSec)
+	v.SetDefault("resilience.health_check_unhealthy_threshold", d.Resilience.HealthCheckUnhealthyThreshold)
+	v.SetDefault("resilience.health_check_healthy_threshold", d.Resilience.HealthCheckHealthyThreshold)
 
 	// Server (new resilience-related fields)
 	v.SetDefault("server.max_response_size", d.Server.MaxResponseSize)
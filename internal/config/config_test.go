@@ -91,6 +91,45 @@ data_dir = "` + dir + `"
 	}
 }
 
+func TestLoad_ScopedBudgets(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "test.toml")
+
+	content := `
+[server]
+data_dir = "` + dir + `"
+
+[[security.budget.scopes]]
+scope = "project"
+match = "team-*"
+daily_limit = 25
+
+[[security.budget.scopes]]
+scope = "model"
+match = "gpt-4o"
+hourly_limit = 2.5
+`
+	if err := os.WriteFile(configPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	scopes := cfg.Security.Budget.Scopes
+	if len(scopes) != 2 {
+		t.Fatalf("Scopes: got %d entries, want 2", len(scopes))
+	}
+	if scopes[0].Scope != "project" || scopes[0].Match != "team-*" || scopes[0].DailyLimit != 25 {
+		t.Errorf("Scopes[0] = %+v", scopes[0])
+	}
+	if scopes[1].HourlyLimit != 2.5 {
+		t.Errorf("Scopes[1].HourlyLimit = %v, want 2.5", scopes[1].HourlyLimit)
+	}
+}
+
 func TestLoad_ValidationFailure_BadPort(t *testing.T) {
 	dir := t.TempDir()
 	configPath := filepath.Join(dir, "bad.toml")
@@ -230,3 +269,49 @@ data_dir = "` + dir + `"
 	// Reset to default to not affect other tests.
 	set(DefaultConfig())
 }
+
+func TestDiff(t *testing.T) {
+	old := DefaultConfig()
+	old.Auth.Token = "old-secret"
+	old.Security.PII.AllowList = []string{"support@example.com"}
+
+	updated := DefaultConfig()
+	updated.Auth.Token = "new-secret"
+	updated.Security.PII.AllowList = []string{"support@example.com", "sales@example.com"}
+	updated.Security.RateLimit.DefaultRate = old.Security.RateLimit.DefaultRate + 5
+	updated.Providers["extra"] = ProviderConfig{Name: "extra", APIBase: "https://llm.example.com", KeyRef: "env:EXTRA_KEY"}
+
+	changes, err := Diff(old, updated)
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	got := make(map[string]Change)
+	for _, c := range changes {
+		got[c.Path] = c
+	}
+
+	if c, ok := got["auth.token"]; !ok || c.Old != "****" || c.New != "****" {
+		t.Errorf("auth.token change = %+v (present %v), want redacted", c, ok)
+	}
+	if c := got["security.pii.allow_list"]; c.New != "****" {
+		t.Errorf("allow_list change = %+v, want redacted", c)
+	}
+	if c, ok := got["security.rate_limit.default_rate"]; !ok || c.Old == c.New {
+		t.Errorf("default_rate change = %+v (present %v)", c, ok)
+	}
+	if c := got["providers.extra.key_ref"]; c.Old != nil || c.New != "env:EXTRA_KEY" {
+		t.Errorf("added provider key_ref = %+v", c)
+	}
+	if _, ok := got["server.proxy_port"]; ok {
+		t.Error("unchanged setting reported")
+	}
+	for i := 1; i < len(changes); i++ {
+		if changes[i-1].Path > changes[i].Path {
+			t.Fatalf("changes not sorted: %q before %q", changes[i-1].Path, changes[i].Path)
+		}
+	}
+
+	if same, _ := Diff(old, old); len(same) != 0 {
+		t.Errorf("Diff of identical configs = %+v", same)
+	}
+}
@@ -33,6 +33,9 @@ const DefaultRetentionDays = 30
 // DefaultCacheTTL is the default metrics cache TTL in seconds.
 const DefaultCacheTTL = 300
 
+// DefaultEmbeddingCacheTTL is the default embedding cache TTL in seconds (30 days).
+const DefaultEmbeddingCacheTTL = 2592000
+
 // DefaultProviderTimeout is the default provider timeout in seconds.
 const DefaultProviderTimeout = 30
 
@@ -83,6 +86,34 @@ const DefaultCBResetTimeout = 60
 // DefaultCBHalfOpenMax is the default number of successful calls in half-open state to close the circuit.
 const DefaultCBHalfOpenMax = 1
 
+// DefaultSchedulerMaxConcurrent is the default number of upstream requests
+// the priority scheduler lets run at once.
+const DefaultSchedulerMaxConcurrent = 16
+
+// DefaultSchedulerMaxWait is the default number of seconds a request may wait
+// in the priority scheduler's queue.
+const DefaultSchedulerMaxWait = 60
+
+// DefaultPacingMaxDelayMs is the default cap in milliseconds on how long a
+// request is held back to stay within a provider's reported rate limit.
+const DefaultPacingMaxDelayMs = 10000
+
+// DefaultHealthCheckInterval is the default number of seconds between
+// active provider health checks.
+const DefaultHealthCheckInterval = 30
+
+// DefaultHealthCheckTimeout is the default timeout in seconds of one
+// provider health check.
+const DefaultHealthCheckTimeout = 10
+
+// DefaultHealthCheckUnhealthyThreshold is the default number of consecutive
+// failures that mark a provider unhealthy.
+const DefaultHealthCheckUnhealthyThreshold = 3
+
+// DefaultHealthCheckHealthyThreshold is the default number of consecutive
+// successes that mark an unhealthy provider healthy again.
+const DefaultHealthCheckHealthyThreshold = 2
+
 // DefaultTracingExporter is the default tracing exporter type.
 const DefaultTracingExporter = "otlp-grpc"
 
@@ -95,18 +126,78 @@ const DefaultTracingServiceName = "tokenman"
 // DefaultTracingSampleRate is the default sampling rate (1.0 = 100%).
 const DefaultTracingSampleRate = 1.0
 
+// DefaultRateLimitQueueSize is the default number of requests per provider
+// that may wait for rate limit capacity.
+const DefaultRateLimitQueueSize = 100
+
+// DefaultRateLimitMaxWait is the default maximum time in seconds a request
+// waits for rate limit capacity.
+const DefaultRateLimitMaxWait = 30
+
 // DefaultBudgetAlertThresholds are the default alert thresholds (percentages).
 var DefaultBudgetAlertThresholds = []float64{50, 75, 90}
 
 // ValidLogLevels lists the allowed log level values.
 var ValidLogLevels = []string{"trace", "debug", "info", "warn", "error", "fatal"}
 
+// ValidBodyModes lists the allowed server.log_body and server.store_body_mode
+// values.
+var ValidBodyModes = []string{"raw", "redacted", "none"}
+
+// ValidBodySources lists the allowed server.store_body_source values.
+var ValidBodySources = []string{"original", "upstream"}
+
+// ValidClientAuthModes lists the allowed server.mtls.client_auth values.
+var ValidClientAuthModes = []string{"require", "optional"}
+
+// ValidKeyStrategies lists the allowed providers.<name>.key_strategy values.
+var ValidKeyStrategies = []string{"round_robin", "least_limited", "weighted"}
+
+// ValidRoutingStrategies lists the allowed routing.strategy values.
+var ValidRoutingStrategies = []string{"priority", "lowest_cost", "lowest_latency", "weighted_random"}
+
 // ValidPIIActions lists the allowed PII action values.
 var ValidPIIActions = []string{"redact", "hash", "log", "block"}
 
 // ValidInjectionActions lists the allowed injection detection action values.
 var ValidInjectionActions = []string{"log", "block", "sanitize", "warn"}
 
+// ValidToolResultActions lists the allowed tool-result injection action values.
+var ValidToolResultActions = []string{"log", "sanitize", "quarantine", "block"}
+
+// ValidToolTrustLevels lists the allowed per-tool trust levels.
+var ValidToolTrustLevels = []string{"trusted", "standard", "untrusted"}
+
+// ValidBudgetScopes lists the allowed scoped budget kinds.
+var ValidBudgetScopes = []string{"project", "key", "model", "provider"}
+
+// ValidAlertSinkTypes lists the allowed alert sink types.
+var ValidAlertSinkTypes = []string{"webhook", "slack", "command", "desktop"}
+
+// ValidAlertKinds lists the alert kinds a sink can subscribe to.
+var ValidAlertKinds = []string{"budget_threshold", "circuit_open", "pii_blocked"}
+
+// ValidDashboardRoles lists the roles a dashboard token can hold.
+var ValidDashboardRoles = []string{"viewer", "auditor", "admin"}
+
+// tokenHashPrefix is the prefix of salted token hashes written by
+// "tokenman hash-token".
+const tokenHashPrefix = "pbkdf2-sha256$"
+
+// ValidPolicyActions lists the allowed policy rule actions.
+var ValidPolicyActions = []string{"allow", "deny", "rewrite", "downgrade"}
+
+// ValidPolicyDefaultActions lists the allowed policy default actions.
+var ValidPolicyDefaultActions = []string{"allow", "deny"}
+
+// ValidPriorityClasses enumerates the scheduler priority classes, highest first.
+var ValidPriorityClasses = []string{"interactive", "default", "batch"}
+
+// IsPriorityClass reports whether p names a scheduler priority class.
+func IsPriorityClass(p string) bool {
+	return isValidEnum(p, ValidPriorityClasses)
+}
+
 // DefaultConfig returns a Config populated with all default values.
 func DefaultConfig() *Config {
 	return &Config{
@@ -129,6 +220,13 @@ func DefaultConfig() *Config {
 			SessionTTL:        DefaultSessionTTL,
 			StoreBody:         false,
 			MaxLogBody:        DefaultMaxLogBody,
+			LogBody:           "redacted",
+			StoreBodyMode:     "redacted",
+			StoreBodySource:   "original",
+			MTLS: MTLSConfig{
+				Enabled:    false,
+				ClientAuth: "require",
+			},
 		},
 		Auth: AuthConfig{
 			Enabled: false,
@@ -155,10 +253,14 @@ func DefaultConfig() *Config {
 			},
 		},
 		Routing: RoutingConfig{
-			DefaultProvider: "anthropic",
-			ModelMap:        map[string]string{},
-			HeartbeatModel:  "",
-			FallbackEnabled: true,
+			DefaultProvider:    "anthropic",
+			ModelMap:           map[string]string{},
+			HeartbeatModel:     "",
+			FallbackEnabled:    true,
+			Strategy:           "priority",
+			SLOP95Ms:           10000,
+			MaxErrorRate:       0.2,
+			HedgeBudgetPercent: 10,
 		},
 		Compression: CompressionConfig{
 			Dedup: DedupConfig{
@@ -197,19 +299,36 @@ func DefaultConfig() *Config {
 			Injection: InjectionConfig{
 				Enabled: true,
 				Action:  "log",
+				ToolResults: ToolResultConfig{
+					Enabled:      true,
+					DefaultTrust: "untrusted",
+					Trust:        map[string]string{},
+				},
 			},
 			Budget: BudgetConfig{
-				Enabled:         false,
-				HourlyLimit:     0,
-				DailyLimit:      0,
-				MonthlyLimit:    0,
-				AlertThresholds: DefaultBudgetAlertThresholds,
+				Enabled:               false,
+				HourlyLimit:           0,
+				DailyLimit:            0,
+				MonthlyLimit:          0,
+				AlertThresholds:       DefaultBudgetAlertThresholds,
+				ReservationTTLSeconds: 600,
 			},
 			RateLimit: RateLimitConfig{
 				Enabled:        false,
 				DefaultRate:    10.0,
 				DefaultBurst:   20,
+				QueueSize:      DefaultRateLimitQueueSize,
+				MaxWaitSeconds: DefaultRateLimitMaxWait,
 				ProviderLimits: map[string]ProviderRateLimit{},
+				ModelLimits:    map[string]ModelRateLimit{},
+			},
+			Policy: PolicyConfig{
+				Enabled:       false,
+				DefaultAction: "allow",
+			},
+			Encryption: EncryptionConfig{
+				Enabled: false,
+				KeyRef:  "keyring://tokenman/encryption",
 			},
 		},
 		Resilience: ResilienceConfig{
@@ -220,6 +339,13 @@ func DefaultConfig() *Config {
 			CBFailureThreshold: DefaultCBFailureThreshold,
 			CBResetTimeoutSec:  DefaultCBResetTimeout,
 			CBHalfOpenMax:      DefaultCBHalfOpenMax,
+			PacingMaxDelayMs:   DefaultPacingMaxDelayMs,
+
+			HealthCheckEnabled:            false,
+			HealthCheckIntervalSec:        DefaultHealthCheckInterval,
+			HealthCheckTimeoutSec:         DefaultHealthCheckTimeout,
+			HealthCheckUnhealthyThreshold: DefaultHealthCheckUnhealthyThreshold,
+			HealthCheckHealthyThreshold:   DefaultHealthCheckHealthyThreshold,
 		},
 		Tracing: TracingConfig{
 			Enabled:     false,
@@ -235,8 +361,18 @@ func DefaultConfig() *Config {
 			AllowedOrigins: []string{"http://localhost:7677", "http://localhost:7678"},
 		},
 		Metrics: MetricsConfig{
-			RetentionDays:   DefaultRetentionDays,
-			CacheTTLSeconds: DefaultCacheTTL,
+			RetentionDays:            DefaultRetentionDays,
+			CacheTTLSeconds:          DefaultCacheTTL,
+			EmbeddingCacheTTLSeconds: DefaultEmbeddingCacheTTL,
+		},
+		Alerts: AlertsConfig{
+			Enabled: true,
+		},
+		Scheduler: SchedulerConfig{
+			Enabled:         false,
+			MaxConcurrent:   DefaultSchedulerMaxConcurrent,
+			MaxWaitSeconds:  DefaultSchedulerMaxWait,
+			DefaultPriority: "default",
 		},
 		Plugins: PluginConfig{
 			Enabled: false,
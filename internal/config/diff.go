@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Change is one setting that differs between two configs. Old or New is
+// nil when the setting is absent on that side.
+type Change struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// redactedSettings are TOML keys whose values are credentials or personal
+// data. Diff reports that they changed without showing their values.
+var redactedSettings = map[string]bool{
+	"token":      true,
+	"token_hash": true,
+	"hash":       true,
+	"url":        true, // alert sink URLs embed webhook tokens
+	"headers":    true,
+	"allow_list": true, // PII allow-list entries are real PII values
+}
+
+// Diff returns the settings that differ between old and new, named by their
+// dotted TOML path (e.g. "security.rate_limit.default_rate") and sorted by
+// path. Arrays are compared as a whole. Credential-like values are replaced
+// with "****", so the result is safe to log and persist.
+func Diff(old, new *Config) ([]Change, error) {
+	before, err := flattenConfig(old)
+	if err != nil {
+		return nil, err
+	}
+	after, err := flattenConfig(new)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	for path, o := range before {
+		n, ok := after[path]
+		if !ok {
+			changes = append(changes, Change{Path: path, Old: o.shown})
+		} else if !reflect.DeepEqual(o.raw, n.raw) {
+			changes = append(changes, Change{Path: path, Old: o.shown, New: n.shown})
+		}
+	}
+	for path, n := range after {
+		if _, ok := before[path]; !ok {
+			changes = append(changes, Change{Path: path, New: n.shown})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// setting is a leaf value and the form of it that is safe to show.
+type setting struct {
+	raw   interface{}
+	shown interface{}
+}
+
+// flattenConfig maps the dotted path of each leaf setting of cfg to its value.
+func flattenConfig(cfg *Config) (map[string]setting, error) {
+	data, err := toml.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("config: diff: %w", err)
+	}
+	var tree map[string]interface{}
+	if err := toml.Unmarshal(data, &tree); err != nil {
+		return nil, fmt.Errorf("config: diff: %w", err)
+	}
+	flat := make(map[string]setting)
+	flattenInto(flat, "", tree)
+	return flat, nil
+}
+
+func flattenInto(flat map[string]setting, prefix string, tree map[string]interface{}) {
+	for k, v := range tree {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		if sub, ok := v.(map[string]interface{}); ok && !redactedSettings[k] {
+			flattenInto(flat, path, sub)
+			continue
+		}
+		flat[path] = setting{raw: v, shown: redactSetting(k, v)}
+	}
+}
+
+// redactSetting masks v if key names a sensitive setting, and masks
+// sensitive fields inside arrays of tables.
+func redactSetting(key string, v interface{}) interface{} {
+	if redactedSettings[key] {
+		if v == nil || reflect.ValueOf(v).IsZero() {
+			return v
+		}
+		return "****"
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[k] = redactSetting(k, item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = redactSetting("", item)
+		}
+		return out
+	}
+	return v
+}
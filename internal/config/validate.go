@@ -1,7 +1,11 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/url"
+	"path"
+	"regexp"
 	"strings"
 )
 
@@ -23,6 +27,15 @@ func validate(cfg *Config) error {
 	if !isValidEnum(cfg.Server.LogLevel, ValidLogLevels) {
 		errs = append(errs, fmt.Sprintf("server.log_level must be one of %v, got %q", ValidLogLevels, cfg.Server.LogLevel))
 	}
+	if !isValidEnum(cfg.Server.LogBody, ValidBodyModes) {
+		errs = append(errs, fmt.Sprintf("server.log_body must be one of %v, got %q", ValidBodyModes, cfg.Server.LogBody))
+	}
+	if !isValidEnum(cfg.Server.StoreBodyMode, ValidBodyModes) {
+		errs = append(errs, fmt.Sprintf("server.store_body_mode must be one of %v, got %q", ValidBodyModes, cfg.Server.StoreBodyMode))
+	}
+	if !isValidEnum(cfg.Server.StoreBodySource, ValidBodySources) {
+		errs = append(errs, fmt.Sprintf("server.store_body_source must be one of %v, got %q", ValidBodySources, cfg.Server.StoreBodySource))
+	}
 	if cfg.Server.DataDir == "" {
 		errs = append(errs, "server.data_dir must not be empty")
 	}
@@ -34,6 +47,24 @@ func validate(cfg *Config) error {
 			errs = append(errs, "server.key_file must be set when tls_enabled is true")
 		}
 	}
+	if mtls := cfg.Server.MTLS; mtls.Enabled {
+		if !cfg.Server.TLSEnabled {
+			errs = append(errs, "server.mtls requires server.tls_enabled")
+		}
+		if mtls.ClientCAFile == "" {
+			errs = append(errs, "server.mtls.client_ca_file must be set when mtls is enabled")
+		}
+		if !isValidEnum(mtls.ClientAuth, ValidClientAuthModes) {
+			errs = append(errs, fmt.Sprintf("server.mtls.client_auth must be one of %v, got %q", ValidClientAuthModes, mtls.ClientAuth))
+		}
+		for i, id := range mtls.Identities {
+			if id.Match == "" {
+				errs = append(errs, fmt.Sprintf("server.mtls.identities[%d].match must not be empty", i))
+			} else if _, err := path.Match(id.Match, ""); err != nil {
+				errs = append(errs, fmt.Sprintf("server.mtls.identities[%d].match is not a valid pattern: %v", i, err))
+			}
+		}
+	}
 	if cfg.Server.ReadTimeout < 0 {
 		errs = append(errs, fmt.Sprintf("server.read_timeout must be non-negative, got %d", cfg.Server.ReadTimeout))
 	}
@@ -54,8 +85,26 @@ func validate(cfg *Config) error {
 	}
 
 	// Auth validation
-	if cfg.Auth.Enabled && cfg.Auth.Token == "" {
-		errs = append(errs, "auth.token must be set when auth.enabled is true")
+	if cfg.Auth.Enabled && cfg.Auth.Token == "" && cfg.Auth.TokenHash == "" {
+		errs = append(errs, "auth.token or auth.token_hash must be set when auth.enabled is true")
+	}
+	if cfg.Auth.TokenHash != "" && !strings.HasPrefix(cfg.Auth.TokenHash, tokenHashPrefix) {
+		errs = append(errs, fmt.Sprintf("auth.token_hash must start with %q; generate it with tokenman hash-token", tokenHashPrefix))
+	}
+	tokenNames := make(map[string]bool, len(cfg.Dashboard.Tokens))
+	for i, t := range cfg.Dashboard.Tokens {
+		if t.Name == "" {
+			errs = append(errs, fmt.Sprintf("dashboard.tokens[%d].name must not be empty", i))
+		} else if tokenNames[t.Name] {
+			errs = append(errs, fmt.Sprintf("dashboard.tokens[%d].name %q is not unique", i, t.Name))
+		}
+		tokenNames[t.Name] = true
+		if !isValidEnum(t.Role, ValidDashboardRoles) {
+			errs = append(errs, fmt.Sprintf("dashboard.tokens[%d].role must be one of %v, got %q", i, ValidDashboardRoles, t.Role))
+		}
+		if !strings.HasPrefix(t.Hash, tokenHashPrefix) {
+			errs = append(errs, fmt.Sprintf("dashboard.tokens[%d].hash must start with %q; generate it with tokenman hash-token", i, tokenHashPrefix))
+		}
 	}
 
 	// Provider validation
@@ -69,6 +118,24 @@ func validate(cfg *Config) error {
 		if p.Timeout < 0 {
 			errs = append(errs, fmt.Sprintf("providers.%s.timeout must be non-negative", name))
 		}
+		if p.KeyStrategy != "" && !isValidEnum(p.KeyStrategy, ValidKeyStrategies) {
+			errs = append(errs, fmt.Sprintf("providers.%s.key_strategy must be one of %v, got %q", name, ValidKeyStrategies, p.KeyStrategy))
+		}
+		keyNames := make(map[string]bool, len(p.Keys))
+		for i, k := range p.Keys {
+			if k.Ref == "" {
+				errs = append(errs, fmt.Sprintf("providers.%s.keys[%d].ref must not be empty", name, i))
+			}
+			if k.Weight < 0 {
+				errs = append(errs, fmt.Sprintf("providers.%s.keys[%d].weight must be non-negative, got %d", name, i, k.Weight))
+			}
+			if k.Name != "" {
+				if keyNames[k.Name] {
+					errs = append(errs, fmt.Sprintf("providers.%s.keys[%d].name %q is duplicated", name, i, k.Name))
+				}
+				keyNames[k.Name] = true
+			}
+		}
 	}
 
 	// Routing validation
@@ -82,6 +149,93 @@ func validate(cfg *Config) error {
 			errs = append(errs, fmt.Sprintf("routing.model_map[%q] references unknown provider %q", model, provider))
 		}
 	}
+	if !isValidEnum(cfg.Routing.Strategy, ValidRoutingStrategies) {
+		errs = append(errs, fmt.Sprintf("routing.strategy must be one of %v, got %q", ValidRoutingStrategies, cfg.Routing.Strategy))
+	}
+	if cfg.Routing.SLOP95Ms < 0 {
+		errs = append(errs, fmt.Sprintf("routing.slo_p95_ms must be non-negative, got %d", cfg.Routing.SLOP95Ms))
+	}
+	if cfg.Routing.MaxErrorRate < 0 || cfg.Routing.MaxErrorRate > 1 {
+		errs = append(errs, fmt.Sprintf("routing.max_error_rate must be between 0 and 1, got %g", cfg.Routing.MaxErrorRate))
+	}
+	if cfg.Routing.HedgeDelayMs < 0 {
+		errs = append(errs, fmt.Sprintf("routing.hedge_delay_ms must be non-negative, got %d", cfg.Routing.HedgeDelayMs))
+	}
+	if cfg.Routing.HedgeBudgetPercent < 0 || cfg.Routing.HedgeBudgetPercent > 100 {
+		errs = append(errs, fmt.Sprintf("routing.hedge_budget_percent must be between 0 and 100, got %g", cfg.Routing.HedgeBudgetPercent))
+	}
+	for alias, targets := range cfg.Routing.Aliases {
+		if len(targets) == 0 {
+			errs = append(errs, fmt.Sprintf("routing.aliases.%s must map at least one provider to a model", alias))
+		}
+		for provider, model := range targets {
+			if _, ok := cfg.Providers[provider]; !ok {
+				errs = append(errs, fmt.Sprintf("routing.aliases.%s references unknown provider %q", alias, provider))
+			}
+			if model == "" {
+				errs = append(errs, fmt.Sprintf("routing.aliases.%s.%s must name a model", alias, provider))
+			}
+		}
+	}
+	for i, rule := range cfg.Routing.Rules {
+		switch {
+		case rule.Match == "" && rule.Regex == "":
+			errs = append(errs, fmt.Sprintf("routing.rules[%d] must set match or regex", i))
+		case rule.Match != "" && rule.Regex != "":
+			errs = append(errs, fmt.Sprintf("routing.rules[%d] must set only one of match and regex", i))
+		case rule.Regex != "":
+			if _, err := regexp.Compile(rule.Regex); err != nil {
+				errs = append(errs, fmt.Sprintf("routing.rules[%d].regex is invalid: %v", i, err))
+			}
+		default:
+			if _, err := path.Match(rule.Match, ""); err != nil {
+				errs = append(errs, fmt.Sprintf("routing.rules[%d].match is not a valid pattern: %v", i, err))
+			}
+		}
+		if _, ok := cfg.Providers[rule.Provider]; !ok {
+			errs = append(errs, fmt.Sprintf("routing.rules[%d] references unknown provider %q", i, rule.Provider))
+		}
+	}
+	cascadeNames := make(map[string]bool)
+	cascadeAliases := make(map[string]string)
+	for i, c := range cfg.Routing.Cascades {
+		prefix := fmt.Sprintf("routing.cascades[%d]", i)
+		switch {
+		case c.Name == "":
+			errs = append(errs, fmt.Sprintf("%s.name is required", prefix))
+		case cascadeNames[c.Name]:
+			errs = append(errs, fmt.Sprintf("%s.name %q is used by another cascade", prefix, c.Name))
+		}
+		cascadeNames[c.Name] = true
+		if c.CheapModel == "" {
+			errs = append(errs, fmt.Sprintf("%s.cheap_model is required", prefix))
+		}
+		if len(c.Projects) == 0 && len(c.Aliases) == 0 {
+			errs = append(errs, fmt.Sprintf("%s must set projects or aliases", prefix))
+		}
+		if len(c.Aliases) > 0 && c.StrongModel == "" {
+			errs = append(errs, fmt.Sprintf("%s.strong_model is required when aliases are set", prefix))
+		}
+		for _, alias := range c.Aliases {
+			if other, ok := cascadeAliases[alias]; ok {
+				errs = append(errs, fmt.Sprintf("%s alias %q is already used by cascade %q", prefix, alias, other))
+			}
+			cascadeAliases[alias] = c.Name
+		}
+		if c.Checks.JSONSchema != "" && !json.Valid([]byte(c.Checks.JSONSchema)) {
+			errs = append(errs, fmt.Sprintf("%s.checks.json_schema is not valid JSON", prefix))
+		}
+		if c.Checks.Regex != "" {
+			if _, err := regexp.Compile(c.Checks.Regex); err != nil {
+				errs = append(errs, fmt.Sprintf("%s.checks.regex is invalid: %v", prefix, err))
+			}
+		}
+		if c.Checks.MinLength < 0 || c.Checks.MaxLength < 0 {
+			errs = append(errs, fmt.Sprintf("%s.checks min_length and max_length must be non-negative", prefix))
+		} else if c.Checks.MaxLength > 0 && c.Checks.MinLength > c.Checks.MaxLength {
+			errs = append(errs, fmt.Sprintf("%s.checks.min_length must not exceed max_length", prefix))
+		}
+	}
 
 	// Compression validation
 	if cfg.Compression.Dedup.TTLSeconds < 0 {
@@ -101,6 +255,30 @@ func validate(cfg *Config) error {
 	if !isValidEnum(cfg.Security.Injection.Action, ValidInjectionActions) {
 		errs = append(errs, fmt.Sprintf("security.injection.action must be one of %v, got %q", ValidInjectionActions, cfg.Security.Injection.Action))
 	}
+	if cfg.Security.Injection.LogThreshold < 0 || cfg.Security.Injection.LogThreshold > 1 {
+		errs = append(errs, fmt.Sprintf("security.injection.log_threshold must be between 0 and 1, got %.2f", cfg.Security.Injection.LogThreshold))
+	}
+	if cfg.Security.Injection.BlockThreshold < 0 || cfg.Security.Injection.BlockThreshold > 1 {
+		errs = append(errs, fmt.Sprintf("security.injection.block_threshold must be between 0 and 1, got %.2f", cfg.Security.Injection.BlockThreshold))
+	}
+	toolResults := cfg.Security.Injection.ToolResults
+	if toolResults.Action != "" && !isValidEnum(toolResults.Action, ValidToolResultActions) {
+		errs = append(errs, fmt.Sprintf("security.injection.tool_results.action must be one of %v, got %q", ValidToolResultActions, toolResults.Action))
+	}
+	if toolResults.LogThreshold < 0 || toolResults.LogThreshold > 1 {
+		errs = append(errs, fmt.Sprintf("security.injection.tool_results.log_threshold must be between 0 and 1, got %.2f", toolResults.LogThreshold))
+	}
+	if toolResults.BlockThreshold < 0 || toolResults.BlockThreshold > 1 {
+		errs = append(errs, fmt.Sprintf("security.injection.tool_results.block_threshold must be between 0 and 1, got %.2f", toolResults.BlockThreshold))
+	}
+	if !isValidEnum(toolResults.DefaultTrust, ValidToolTrustLevels) {
+		errs = append(errs, fmt.Sprintf("security.injection.tool_results.default_trust must be one of %v, got %q", ValidToolTrustLevels, toolResults.DefaultTrust))
+	}
+	for tool, level := range toolResults.Trust {
+		if !isValidEnum(level, ValidToolTrustLevels) {
+			errs = append(errs, fmt.Sprintf("security.injection.tool_results.trust[%q] must be one of %v, got %q", tool, ValidToolTrustLevels, level))
+		}
+	}
 	if cfg.Security.Budget.HourlyLimit < 0 {
 		errs = append(errs, fmt.Sprintf("security.budget.hourly_limit must be non-negative, got %d", cfg.Security.Budget.HourlyLimit))
 	}
@@ -115,6 +293,76 @@ func validate(cfg *Config) error {
 			errs = append(errs, fmt.Sprintf("security.budget.alert_thresholds[%d] must be between 0 and 100, got %.1f", i, threshold))
 		}
 	}
+	if cfg.Security.Budget.ReservationTTLSeconds < 0 {
+		errs = append(errs, fmt.Sprintf("security.budget.reservation_ttl_seconds must be non-negative, got %d", cfg.Security.Budget.ReservationTTLSeconds))
+	}
+	for i, sb := range cfg.Security.Budget.Scopes {
+		if !isValidEnum(sb.Scope, ValidBudgetScopes) {
+			errs = append(errs, fmt.Sprintf("security.budget.scopes[%d].scope must be one of %v, got %q", i, ValidBudgetScopes, sb.Scope))
+		}
+		if sb.Match == "" {
+			errs = append(errs, fmt.Sprintf("security.budget.scopes[%d].match must not be empty", i))
+		} else if _, err := path.Match(sb.Match, ""); err != nil {
+			errs = append(errs, fmt.Sprintf("security.budget.scopes[%d].match is not a valid pattern: %q", i, sb.Match))
+		}
+		if sb.HourlyLimit < 0 || sb.DailyLimit < 0 || sb.MonthlyLimit < 0 {
+			errs = append(errs, fmt.Sprintf("security.budget.scopes[%d] limits must be non-negative", i))
+		} else if sb.HourlyLimit == 0 && sb.DailyLimit == 0 && sb.MonthlyLimit == 0 {
+			errs = append(errs, fmt.Sprintf("security.budget.scopes[%d] must set at least one limit", i))
+		}
+	}
+
+	// Encryption validation
+	if cfg.Security.Encryption.Enabled && cfg.Security.Encryption.KeyRef == "" {
+		errs = append(errs, "security.encryption.key_ref must be set when encryption is enabled")
+	}
+
+	// Policy validation
+	policy := cfg.Security.Policy
+	if !isValidEnum(policy.DefaultAction, ValidPolicyDefaultActions) {
+		errs = append(errs, fmt.Sprintf("security.policy.default_action must be one of %v, got %q", ValidPolicyDefaultActions, policy.DefaultAction))
+	}
+	for i, rule := range policy.Rules {
+		prefix := fmt.Sprintf("security.policy.rules[%d]", i)
+		if rule.Name == "" {
+			errs = append(errs, prefix+".name must not be empty")
+		}
+		if !isValidEnum(rule.Action, ValidPolicyActions) {
+			errs = append(errs, fmt.Sprintf("%s.action must be one of %v, got %q", prefix, ValidPolicyActions, rule.Action))
+		}
+		for _, pattern := range append(append([]string{}, rule.Match.Models...), rule.Match.Tools...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Sprintf("%s.match has an invalid pattern: %q", prefix, pattern))
+			}
+		}
+		if rule.Match.InputTokensOver < 0 || rule.Match.MaxTokensOver < 0 {
+			errs = append(errs, prefix+".match token thresholds must be non-negative")
+		}
+		switch rule.Action {
+		case "rewrite":
+			if len(rule.Set) == 0 {
+				errs = append(errs, prefix+".set must not be empty for a rewrite rule")
+			}
+			for field, value := range rule.Set {
+				switch field {
+				case "model", "messages", "stream", "system", "tools":
+					errs = append(errs, fmt.Sprintf("%s.set cannot rewrite %q", prefix, field))
+				case "max_tokens":
+					if n, ok := PolicyNumber(value); !ok || n < 1 {
+						errs = append(errs, fmt.Sprintf("%s.set.max_tokens must be a positive number, got %v", prefix, value))
+					}
+				case "temperature":
+					if _, ok := PolicyNumber(value); !ok {
+						errs = append(errs, fmt.Sprintf("%s.set.temperature must be a number, got %v", prefix, value))
+					}
+				}
+			}
+		case "downgrade":
+			if rule.Model == "" {
+				errs = append(errs, prefix+".model must not be empty for a downgrade rule")
+			}
+		}
+	}
 
 	// Rate limit validation
 	if cfg.Security.RateLimit.Enabled {
@@ -131,6 +379,26 @@ func validate(cfg *Config) error {
 			if pl.Burst < 1 {
 				errs = append(errs, fmt.Sprintf("security.rate_limit.provider_limits[%q].burst must be at least 1, got %d", name, pl.Burst))
 			}
+			if pl.TPM < 0 || pl.MaxConcurrent < 0 {
+				errs = append(errs, fmt.Sprintf("security.rate_limit.provider_limits[%q] tpm and max_concurrent must be non-negative", name))
+			}
+		}
+		for model, ml := range cfg.Security.RateLimit.ModelLimits {
+			if ml.TPM < 0 || ml.MaxConcurrent < 0 {
+				errs = append(errs, fmt.Sprintf("security.rate_limit.model_limits[%q] tpm and max_concurrent must be non-negative", model))
+			}
+		}
+		if cfg.Security.RateLimit.DefaultTPM < 0 {
+			errs = append(errs, fmt.Sprintf("security.rate_limit.default_tpm must be non-negative, got %d", cfg.Security.RateLimit.DefaultTPM))
+		}
+		if cfg.Security.RateLimit.DefaultMaxConcurrent < 0 {
+			errs = append(errs, fmt.Sprintf("security.rate_limit.default_max_concurrent must be non-negative, got %d", cfg.Security.RateLimit.DefaultMaxConcurrent))
+		}
+		if cfg.Security.RateLimit.QueueSize < 0 {
+			errs = append(errs, fmt.Sprintf("security.rate_limit.queue_size must be non-negative, got %d", cfg.Security.RateLimit.QueueSize))
+		}
+		if cfg.Security.RateLimit.MaxWaitSeconds < 0 {
+			errs = append(errs, fmt.Sprintf("security.rate_limit.max_wait_seconds must be non-negative, got %d", cfg.Security.RateLimit.MaxWaitSeconds))
 		}
 	}
 
@@ -153,6 +421,23 @@ func validate(cfg *Config) error {
 	if cfg.Resilience.CBHalfOpenMax < 1 {
 		errs = append(errs, fmt.Sprintf("resilience.cb_half_open_max_calls must be at least 1, got %d", cfg.Resilience.CBHalfOpenMax))
 	}
+	if cfg.Resilience.PacingMaxDelayMs < 0 {
+		errs = append(errs, fmt.Sprintf("resilience.pacing_max_delay_ms must be non-negative, got %d", cfg.Resilience.PacingMaxDelayMs))
+	}
+	if cfg.Resilience.HealthCheckEnabled {
+		if cfg.Resilience.HealthCheckIntervalSec <= 0 {
+			errs = append(errs, fmt.Sprintf("resilience.health_check_interval_seconds must be positive, got %d", cfg.Resilience.HealthCheckIntervalSec))
+		}
+		if cfg.Resilience.HealthCheckTimeoutSec <= 0 {
+			errs = append(errs, fmt.Sprintf("resilience.health_check_timeout_seconds must be positive, got %d", cfg.Resilience.HealthCheckTimeoutSec))
+		}
+		if cfg.Resilience.HealthCheckUnhealthyThreshold < 1 {
+			errs = append(errs, fmt.Sprintf("resilience.health_check_unhealthy_threshold must be at least 1, got %d", cfg.Resilience.HealthCheckUnhealthyThreshold))
+		}
+		if cfg.Resilience.HealthCheckHealthyThreshold < 1 {
+			errs = append(errs, fmt.Sprintf("resilience.health_check_healthy_threshold must be at least 1, got %d", cfg.Resilience.HealthCheckHealthyThreshold))
+		}
+	}
 
 	// Tracing validation
 	if cfg.Tracing.Enabled {
@@ -168,6 +453,47 @@ func validate(cfg *Config) error {
 		errs = append(errs, fmt.Sprintf("tracing.sample_rate must be between 0 and 1, got %f", cfg.Tracing.SampleRate))
 	}
 
+	// Alerts validation
+	for i, sink := range cfg.Alerts.Sinks {
+		if !isValidEnum(sink.Type, ValidAlertSinkTypes) {
+			errs = append(errs, fmt.Sprintf("alerts.sinks[%d].type must be one of %v, got %q", i, ValidAlertSinkTypes, sink.Type))
+		}
+		switch sink.Type {
+		case "webhook", "slack":
+			if u, err := url.Parse(sink.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errs = append(errs, fmt.Sprintf("alerts.sinks[%d].url must be an http(s) URL, got %q", i, sink.URL))
+			}
+		case "command":
+			if len(sink.Command) == 0 || sink.Command[0] == "" {
+				errs = append(errs, fmt.Sprintf("alerts.sinks[%d].command must not be empty", i))
+			}
+		}
+		for _, kind := range sink.Kinds {
+			if !isValidEnum(kind, ValidAlertKinds) {
+				errs = append(errs, fmt.Sprintf("alerts.sinks[%d].kinds must contain only %v, got %q", i, ValidAlertKinds, kind))
+			}
+		}
+	}
+
+	// Scheduler validation
+	if cfg.Scheduler.Enabled && cfg.Scheduler.MaxConcurrent < 1 {
+		errs = append(errs, fmt.Sprintf("scheduler.max_concurrent must be at least 1, got %d", cfg.Scheduler.MaxConcurrent))
+	}
+	if cfg.Scheduler.MaxWaitSeconds < 0 {
+		errs = append(errs, fmt.Sprintf("scheduler.max_wait_seconds must be non-negative, got %d", cfg.Scheduler.MaxWaitSeconds))
+	}
+	if !isValidEnum(cfg.Scheduler.DefaultPriority, ValidPriorityClasses) {
+		errs = append(errs, fmt.Sprintf("scheduler.default_priority must be one of %v, got %q", ValidPriorityClasses, cfg.Scheduler.DefaultPriority))
+	}
+	for name, p := range cfg.Scheduler.Projects {
+		if p.Priority != "" && !isValidEnum(p.Priority, ValidPriorityClasses) {
+			errs = append(errs, fmt.Sprintf("scheduler.projects.%s.priority must be one of %v, got %q", name, ValidPriorityClasses, p.Priority))
+		}
+		if p.Weight < 0 {
+			errs = append(errs, fmt.Sprintf("scheduler.projects.%s.weight must be non-negative, got %g", name, p.Weight))
+		}
+	}
+
 	// Metrics validation
 	if cfg.Metrics.RetentionDays < 1 {
 		errs = append(errs, fmt.Sprintf("metrics.retention_days must be at least 1, got %d", cfg.Metrics.RetentionDays))
@@ -175,6 +501,9 @@ func validate(cfg *Config) error {
 	if cfg.Metrics.CacheTTLSeconds < 0 {
 		errs = append(errs, fmt.Sprintf("metrics.cache_ttl_seconds must be non-negative, got %d", cfg.Metrics.CacheTTLSeconds))
 	}
+	if cfg.Metrics.EmbeddingCacheTTLSeconds < 0 {
+		errs = append(errs, fmt.Sprintf("metrics.embedding_cache_ttl_seconds must be non-negative, got %d", cfg.Metrics.EmbeddingCacheTTLSeconds))
+	}
 
 	if len(errs) > 0 {
 		return fmt.Errorf("config validation failed:\n  - %s", strings.Join(errs, "\n  - "))
@@ -192,3 +521,17 @@ func isValidEnum(val string, allowed []string) bool {
 	}
 	return false
 }
+
+// PolicyNumber converts a numeric policy value decoded from TOML, JSON, or
+// environment variables to a float64.
+func PolicyNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
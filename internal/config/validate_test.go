@@ -132,6 +132,32 @@ func TestValidate_AuthTokenRequired(t *testing.T) {
 	}
 }
 
+func TestValidate_AuthTokenHash(t *testing.T) {
+	cfg := validConfig()
+	cfg.Auth.Enabled = true
+	cfg.Auth.Token = ""
+	cfg.Auth.TokenHash = "pbkdf2-sha256$100000$c2FsdA$ZGlnZXN0"
+	cfg.Dashboard.Tokens = []DashboardToken{{Name: "alice", Role: "auditor", Hash: cfg.Auth.TokenHash}}
+	if err := validate(cfg); err != nil {
+		t.Fatalf("hashed tokens rejected: %v", err)
+	}
+
+	cfg.Auth.TokenHash = "plaintext"
+	cfg.Dashboard.Tokens = []DashboardToken{
+		{Name: "alice", Role: "root", Hash: "pbkdf2-sha256$1$c2FsdA$ZGlnZXN0"},
+		{Name: "alice", Role: "viewer", Hash: "secret"},
+	}
+	err := validate(cfg)
+	if err == nil {
+		t.Fatal("expected error for invalid token hashes")
+	}
+	for _, want := range []string{"auth.token_hash", "dashboard.tokens[0].role", "dashboard.tokens[1].name", "dashboard.tokens[1].hash"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
 func TestValidate_ProviderBadAPIBase(t *testing.T) {
 	cfg := validConfig()
 	cfg.Providers["bad"] = ProviderConfig{
@@ -159,6 +185,29 @@ func TestValidate_ProviderNegativePriority(t *testing.T) {
 	}
 }
 
+func TestValidate_ProviderKeyPool(t *testing.T) {
+	cfg := validConfig()
+	cfg.Providers["pooled"] = ProviderConfig{
+		APIBase:     "https://example.com",
+		KeyStrategy: "random",
+		Keys: []ProviderKeyConfig{
+			{Name: "a", Ref: "env:KEY_A"},
+			{Name: "a", Ref: ""},
+			{Ref: "env:KEY_C", Weight: -1},
+		},
+	}
+
+	err := validate(cfg)
+	if err == nil {
+		t.Fatal("expected errors for bad key pool")
+	}
+	for _, want := range []string{"key_strategy", "keys[1].ref", "keys[1].name", "keys[2].weight"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
 func TestValidate_RoutingUnknownProvider(t *testing.T) {
 	cfg := validConfig()
 	cfg.Routing.DefaultProvider = "nonexistent"
@@ -179,6 +228,43 @@ func TestValidate_ModelMapUnknownProvider(t *testing.T) {
 	}
 }
 
+func TestValidate_RoutingAliasesAndRules(t *testing.T) {
+	cfg := validConfig()
+	cfg.Routing.Aliases = map[string]map[string]string{
+		"fast": {"anthropic": "claude-haiku-4-20250414", "ghost": "x"},
+		"slow": {"anthropic": ""},
+	}
+	cfg.Routing.Rules = []RouteRuleConfig{
+		{Match: "claude-sonnet-4-*", Provider: "anthropic"},
+		{Provider: "anthropic"},
+		{Match: "a", Regex: "b", Provider: "anthropic"},
+		{Regex: "gpt-4o-(", Provider: "anthropic"},
+		{Match: "[", Provider: "anthropic"},
+		{Match: "gpt-*", Provider: "ghost"},
+	}
+
+	err := validate(cfg)
+	if err == nil {
+		t.Fatal("expected errors for bad aliases and rules")
+	}
+	for _, want := range []string{
+		`routing.aliases.fast references unknown provider "ghost"`,
+		"routing.aliases.slow.anthropic",
+		"routing.rules[1] must set match or regex",
+		"routing.rules[2] must set only one",
+		"routing.rules[3].regex",
+		"routing.rules[4].match",
+		`routing.rules[5] references unknown provider "ghost"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "routing.rules[0]") {
+		t.Errorf("valid rule 0 reported: %v", err)
+	}
+}
+
 func TestValidate_BadPIIAction(t *testing.T) {
 	cfg := validConfig()
 	cfg.Security.PII.Action = "explode"
@@ -199,6 +285,34 @@ func TestValidate_BadInjectionAction(t *testing.T) {
 	}
 }
 
+func TestValidate_InjectionThresholdOutOfRange(t *testing.T) {
+	cfg := validConfig()
+	cfg.Security.Injection.LogThreshold = -0.1
+	cfg.Security.Injection.BlockThreshold = 1.5
+
+	err := validate(cfg)
+	if err == nil {
+		t.Fatal("expected error for out-of-range injection thresholds")
+	}
+	if !strings.Contains(err.Error(), "log_threshold") || !strings.Contains(err.Error(), "block_threshold") {
+		t.Errorf("error should mention both thresholds: %v", err)
+	}
+}
+
+func TestValidate_BadToolResultSettings(t *testing.T) {
+	cfg := validConfig()
+	cfg.Security.Injection.ToolResults.Action = "ignore"
+	cfg.Security.Injection.ToolResults.Trust = map[string]string{"web_fetch": "sketchy"}
+
+	err := validate(cfg)
+	if err == nil {
+		t.Fatal("expected error for bad tool_results settings")
+	}
+	if !strings.Contains(err.Error(), "tool_results.action") || !strings.Contains(err.Error(), "web_fetch") {
+		t.Errorf("error should mention action and the bad trust entry: %v", err)
+	}
+}
+
 func TestValidate_NegativeBudgetLimit(t *testing.T) {
 	cfg := validConfig()
 	cfg.Security.Budget.HourlyLimit = -1
@@ -209,6 +323,65 @@ func TestValidate_NegativeBudgetLimit(t *testing.T) {
 	}
 }
 
+func TestValidate_BadScopedBudget(t *testing.T) {
+	tests := []struct {
+		name string
+		sb   ScopedBudgetConfig
+	}{
+		{"unknown scope", ScopedBudgetConfig{Scope: "team", Match: "a", DailyLimit: 1}},
+		{"empty match", ScopedBudgetConfig{Scope: "project", DailyLimit: 1}},
+		{"bad glob", ScopedBudgetConfig{Scope: "model", Match: "gpt-[", DailyLimit: 1}},
+		{"negative limit", ScopedBudgetConfig{Scope: "key", Match: "*", HourlyLimit: -1}},
+		{"no limits", ScopedBudgetConfig{Scope: "provider", Match: "openai"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			cfg.Security.Budget.Scopes = []ScopedBudgetConfig{tt.sb}
+			if err := validate(cfg); err == nil {
+				t.Error("expected validation error")
+			}
+		})
+	}
+
+	cfg := validConfig()
+	cfg.Security.Budget.Scopes = []ScopedBudgetConfig{{Scope: "project", Match: "team-*", DailyLimit: 5}}
+	if err := validate(cfg); err != nil {
+		t.Errorf("valid scoped budget rejected: %v", err)
+	}
+}
+
+func TestValidate_BadAlertSink(t *testing.T) {
+	tests := []struct {
+		name string
+		sink AlertSinkConfig
+	}{
+		{"unknown type", AlertSinkConfig{Type: "pager"}},
+		{"webhook without url", AlertSinkConfig{Type: "webhook"}},
+		{"slack with bad url", AlertSinkConfig{Type: "slack", URL: "hooks.slack.com/x"}},
+		{"empty command", AlertSinkConfig{Type: "command"}},
+		{"unknown kind", AlertSinkConfig{Type: "desktop", Kinds: []string{"disk_full"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			cfg.Alerts.Sinks = []AlertSinkConfig{tt.sink}
+			if err := validate(cfg); err == nil {
+				t.Error("expected validation error")
+			}
+		})
+	}
+
+	cfg := validConfig()
+	cfg.Alerts.Sinks = []AlertSinkConfig{
+		{Type: "webhook", URL: "https://example.com/hook", Kinds: []string{"budget_threshold"}},
+		{Type: "command", Command: []string{"/usr/local/bin/page-oncall"}},
+	}
+	if err := validate(cfg); err != nil {
+		t.Errorf("valid alert sinks rejected: %v", err)
+	}
+}
+
 func TestValidate_AlertThresholdOutOfRange(t *testing.T) {
 	cfg := validConfig()
 	cfg.Security.Budget.AlertThresholds = []float64{50, 150}
@@ -259,6 +432,105 @@ func TestValidate_Resilience_ZeroHalfOpenMax(t *testing.T) {
 	}
 }
 
+func TestValidate_Resilience_NegativePacingDelay(t *testing.T) {
+	cfg := validConfig()
+	cfg.Resilience.PacingMaxDelayMs = -1
+
+	err := validate(cfg)
+	if err == nil {
+		t.Fatal("expected error for pacing_max_delay_ms = -1")
+	}
+}
+
+func TestValidate_Scheduler(t *testing.T) {
+	cfg := validConfig()
+	cfg.Scheduler.Enabled = true
+	cfg.Scheduler.Projects = map[string]ProjectSchedule{
+		"ide":   {Priority: "interactive", Weight: 2},
+		"batch": {Priority: "batch"},
+	}
+	if err := validate(cfg); err != nil {
+		t.Fatalf("valid scheduler config rejected: %v", err)
+	}
+
+	cfg.Scheduler.MaxConcurrent = 0
+	cfg.Scheduler.Projects["ide"] = ProjectSchedule{Priority: "urgent", Weight: -1}
+	err := validate(cfg)
+	if err == nil {
+		t.Fatal("expected error for invalid scheduler config")
+	}
+	for _, want := range []string{"scheduler.max_concurrent", "scheduler.projects.ide.priority", "scheduler.projects.ide.weight"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestValidate_Policy(t *testing.T) {
+	cfg := validConfig()
+	cfg.Security.Policy.Enabled = true
+	cfg.Security.Policy.Rules = []PolicyRule{
+		{Name: "no-opus", Match: PolicyMatch{Projects: []string{"x"}, Models: []string{"claude-opus-*"}}, Action: "deny", Message: "use sonnet"},
+		{Name: "ci", Match: PolicyMatch{KeyOwners: []string{"ci"}}, Action: "rewrite", Set: map[string]interface{}{"temperature": int64(0)}},
+		{Name: "cheap", Match: PolicyMatch{InputTokensOver: 100000}, Action: "downgrade", Model: "claude-haiku-4-5"},
+	}
+	if err := validate(cfg); err != nil {
+		t.Fatalf("valid policy config rejected: %v", err)
+	}
+
+	cfg.Security.Policy.DefaultAction = "maybe"
+	cfg.Security.Policy.Rules = []PolicyRule{
+		{Name: "bad-glob", Match: PolicyMatch{Models: []string{"[opus"}}, Action: "allow"},
+		{Name: "bad-set", Action: "rewrite", Set: map[string]interface{}{"model": "x", "max_tokens": 0}},
+		{Name: "no-model", Action: "downgrade"},
+		{Action: "block"},
+	}
+	err := validate(cfg)
+	if err == nil {
+		t.Fatal("expected error for invalid policy config")
+	}
+	for _, want := range []string{
+		"security.policy.default_action",
+		"rules[0].match has an invalid pattern",
+		`rules[1].set cannot rewrite "model"`,
+		"rules[1].set.max_tokens",
+		"rules[2].model",
+		"rules[3].name",
+		"rules[3].action",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestValidate_MTLS(t *testing.T) {
+	cfg := validConfig()
+	cfg.Server.MTLS.Enabled = true
+	cfg.Server.MTLS.ClientAuth = "sometimes"
+	cfg.Server.MTLS.Identities = []CertIdentityConfig{{Match: "["}}
+
+	err := validate(cfg)
+	if err == nil {
+		t.Fatal("expected errors for invalid mtls settings")
+	}
+	for _, want := range []string{"server.tls_enabled", "client_ca_file", "client_auth", "identities[0].match"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+
+	cfg.Server.TLSEnabled = true
+	cfg.Server.CertFile = "server.crt"
+	cfg.Server.KeyFile = "server.key"
+	cfg.Server.MTLS.ClientCAFile = "clients.pem"
+	cfg.Server.MTLS.ClientAuth = "optional"
+	cfg.Server.MTLS.Identities = []CertIdentityConfig{{Match: "ci-*", Project: "ci"}}
+	if err := validate(cfg); err != nil {
+		t.Errorf("valid mtls config: %v", err)
+	}
+}
+
 func TestValidate_MetricsRetentionZero(t *testing.T) {
 	cfg := validConfig()
 	cfg.Metrics.RetentionDays = 0
@@ -345,6 +617,22 @@ func TestValidate_RateLimit_NegativeProviderRate(t *testing.T) {
 	}
 }
 
+func TestValidate_RateLimit_NegativeModelLimit(t *testing.T) {
+	cfg := validConfig()
+	cfg.Security.RateLimit.Enabled = true
+	cfg.Security.RateLimit.ModelLimits = map[string]ModelRateLimit{
+		"gpt-4o": {TPM: -5},
+	}
+
+	err := validate(cfg)
+	if err == nil {
+		t.Fatal("expected error for negative model tpm")
+	}
+	if !strings.Contains(err.Error(), "model_limits") {
+		t.Errorf("error should mention model_limits: %v", err)
+	}
+}
+
 func TestValidate_RateLimit_ZeroProviderBurst(t *testing.T) {
 	cfg := validConfig()
 	cfg.Security.RateLimit.Enabled = true
@@ -383,3 +671,66 @@ func TestIsValidEnum(t *testing.T) {
 		t.Error("verbose should not be valid")
 	}
 }
+
+func TestValidate_RoutingHedging(t *testing.T) {
+	cfg := validConfig()
+	cfg.Routing.HedgeDelayMs = -1
+	cfg.Routing.HedgeBudgetPercent = 150
+
+	err := validate(cfg)
+	if err == nil {
+		t.Fatal("expected errors for bad hedging settings")
+	}
+	for _, want := range []string{"routing.hedge_delay_ms", "routing.hedge_budget_percent"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestValidate_RoutingCascades(t *testing.T) {
+	cfg := validConfig()
+	cfg.Routing.Cascades = []CascadeConfig{
+		{Name: "classify", Aliases: []string{"triage"}, CheapModel: "claude-haiku-4-20250414", StrongModel: "claude-sonnet-4-20250514"},
+		{Name: "classify", Projects: []string{"bot"}, CheapModel: "gpt-4o-mini"},
+		{Name: "nowhere", CheapModel: "gpt-4o-mini"},
+		{Name: "alias", Aliases: []string{"triage"}, CheapModel: "gpt-4o-mini"},
+		{Name: "checks", Projects: []string{"bot"}, Checks: CascadeChecksConfig{JSONSchema: "{", Regex: "(", MinLength: 10, MaxLength: 5}},
+	}
+
+	err := validate(cfg)
+	if err == nil {
+		t.Fatal("expected errors for bad cascades")
+	}
+	for _, want := range []string{
+		`routing.cascades[1].name "classify" is used by another cascade`,
+		"routing.cascades[2] must set projects or aliases",
+		"routing.cascades[3].strong_model is required",
+		`routing.cascades[3] alias "triage" is already used by cascade "classify"`,
+		"routing.cascades[4].cheap_model is required",
+		"routing.cascades[4].checks.json_schema",
+		"routing.cascades[4].checks.regex",
+		"routing.cascades[4].checks.min_length",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestValidate_HealthChecks(t *testing.T) {
+	cfg := validConfig()
+	cfg.Resilience.HealthCheckEnabled = true
+	cfg.Resilience.HealthCheckIntervalSec = 0
+	cfg.Resilience.HealthCheckUnhealthyThreshold = 0
+
+	err := validate(cfg)
+	if err == nil {
+		t.Fatal("expected errors for bad health check settings")
+	}
+	for _, want := range []string{"resilience.health_check_interval_seconds", "resilience.health_check_unhealthy_threshold"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
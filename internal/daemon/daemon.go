@@ -16,7 +16,12 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
+	"github.com/allaspectsdev/tokenman/internal/alert"
+	"github.com/allaspectsdev/tokenman/internal/audit"
+	"github.com/allaspectsdev/tokenman/internal/auth"
 	"github.com/allaspectsdev/tokenman/internal/cache"
+	"github.com/allaspectsdev/tokenman/internal/cascade"
+	"github.com/allaspectsdev/tokenman/internal/certs"
 	"github.com/allaspectsdev/tokenman/internal/compress"
 	"github.com/allaspectsdev/tokenman/internal/config"
 	"github.com/allaspectsdev/tokenman/internal/metrics"
@@ -79,13 +84,13 @@ func Run(cfg *config.Config, foreground bool) error {
 	}
 
 	// 3. Open store.
-	dbPath := filepath.Join(dataDir, "tokenman.db")
-	st, err := store.Open(dbPath)
+	dbPath := DBPath(cfg)
+	st, err := OpenStore(cfg)
 	if err != nil {
 		return fmt.Errorf("opening store: %w", err)
 	}
 
-	log.Info().Str("db_path", dbPath).Msg("store opened")
+	log.Info().Str("db_path", dbPath).Bool("encrypted", st.EncryptionEnabled()).Msg("store opened")
 
 	// 4. Create metrics collector.
 	collector := metrics.NewCollector()
@@ -172,6 +177,18 @@ func Run(cfg *config.Config, foreground bool) error {
 	fingerprintAdapter := store.NewFingerprintAdapter(st)
 	cacheAdapter := store.NewCacheAdapter(st)
 	budgetAdapter := store.NewBudgetAdapter(st)
+	auditor := store.NewAuditAdapter(st)
+
+	// Alerts are recorded in the store and delivered to configured sinks on
+	// a background goroutine; notifier stays nil when alerting is disabled.
+	var (
+		alertDispatcher *alert.Dispatcher
+		notifier        alert.Notifier
+	)
+	if cfg.Alerts.Enabled {
+		alertDispatcher = newAlertDispatcher(cfg.Alerts, st)
+		notifier = alertDispatcher
+	}
 
 	// 8b. Init vault and resolve API keys for enabled providers.
 	v := vault.New()
@@ -182,7 +199,16 @@ func Run(cfg *config.Config, foreground bool) error {
 			continue
 		}
 		apiKey := ""
-		if pcfg.KeyRef != "" {
+		var keyPool *router.KeyPool
+		if len(pcfg.Keys) > 0 {
+			keys := resolveProviderKeys(v, name, pcfg.Keys)
+			if len(keys) == 0 {
+				log.Warn().Str("provider", name).Msg("failed to resolve any pooled API key; provider will be unavailable")
+				continue
+			}
+			keyPool = router.NewKeyPool(pcfg.KeyStrategy, keys)
+			apiKey = keys[0].Secret
+		} else if pcfg.KeyRef != "" {
 			key, err := v.ResolveKeyRef(pcfg.KeyRef)
 			if err != nil {
 				log.Warn().Err(err).Str("provider", name).Msg("failed to resolve API key; provider will be unavailable")
@@ -195,10 +221,15 @@ func Run(cfg *config.Config, foreground bool) error {
 		// Determine format from provider name.
 		if strings.Contains(strings.ToLower(name), "openai") {
 			format = pipeline.FormatOpenAI
+		} else if strings.Contains(strings.ToLower(name), "gemini") || strings.Contains(strings.ToLower(name), "google") {
+			format = pipeline.FormatGemini
 		}
 
+		// The router identifies providers by their config key, the name
+		// model_map, aliases, rules, rate limits, and /api/providers use;
+		// pcfg.Name is only a display name.
 		providerConfigs[name] = &router.ProviderConfig{
-			Name:     pcfg.Name,
+			Name:     name,
 			BaseURL:  pcfg.APIBase,
 			APIKey:   apiKey,
 			Format:   format,
@@ -206,25 +237,68 @@ func Run(cfg *config.Config, foreground bool) error {
 			Enabled:  true,
 			Priority: pcfg.Priority,
 			Timeout:  pcfg.TimeoutDuration(),
+			Keys:     keyPool,
+
+			ProbeModel: pcfg.ProbeModel,
 		}
 	}
 
 	// 8c. Create router.
 	rtr := router.NewRouter(providerConfigs, cfg.Routing.ModelMap, cfg.Routing.DefaultProvider, cfg.Routing.FallbackEnabled)
+	rtr.SetAliases(cfg.Routing.Aliases)
+	rtr.SetStrategy(router.Strategy{
+		Name:         cfg.Routing.Strategy,
+		SLO:          time.Duration(cfg.Routing.SLOP95Ms) * time.Millisecond,
+		MaxErrorRate: cfg.Routing.MaxErrorRate,
+	})
+	if err := rtr.SetRules(routeRules(cfg.Routing.Rules)); err != nil {
+		return fmt.Errorf("routing rules: %w", err)
+	}
 	models := rtr.ListModels()
 	log.Info().Int("providers", len(providerConfigs)).Int("models", len(models)).Msg("router initialized")
 
 	// 8d. Build the middleware chain.
-	injectionMW := security.NewInjectionMiddleware(cfg.Security.Injection.Action, cfg.Security.Injection.Enabled)
+	policyMW := security.NewPolicyMiddleware(cfg.Security.Policy, func(model string) string {
+		if p, err := rtr.Resolve(model); err == nil {
+			return p.Name
+		}
+		return ""
+	})
+	policyMW.SetLogger(store.NewPolicyAdapter(st))
+	policyMW.SetAuditor(auditor)
+
+	injectionMW := security.NewInjectionMiddleware(
+		cfg.Security.Injection.Action,
+		cfg.Security.Injection.LogThreshold,
+		cfg.Security.Injection.BlockThreshold,
+		security.ToolResultPolicy{
+			Enabled:        cfg.Security.Injection.ToolResults.Enabled,
+			Action:         cfg.Security.Injection.ToolResults.Action,
+			LogThreshold:   cfg.Security.Injection.ToolResults.LogThreshold,
+			BlockThreshold: cfg.Security.Injection.ToolResults.BlockThreshold,
+			DefaultTrust:   cfg.Security.Injection.ToolResults.DefaultTrust,
+			Trust:          cfg.Security.Injection.ToolResults.Trust,
+		},
+		cfg.Security.Injection.Enabled,
+	)
+	injectionMW.SetAuditor(auditor)
 	piiMW := security.NewPIIMiddleware(cfg.Security.PII.Action, cfg.Security.PII.AllowList, cfg.Security.PII.Enabled)
+	piiMW.SetAuditor(auditor)
+	if notifier != nil {
+		piiMW.SetNotifier(notifier)
+	}
 
 	thresholds := make([]float64, len(cfg.Security.Budget.AlertThresholds))
 	for i, t := range cfg.Security.Budget.AlertThresholds {
 		thresholds[i] = t / 100.0 // convert from percentage to fraction
 	}
-	budgetMW := security.NewBudgetMiddleware(budgetAdapter, float64(cfg.Security.Budget.HourlyLimit), float64(cfg.Security.Budget.DailyLimit), float64(cfg.Security.Budget.MonthlyLimit), thresholds, cfg.Security.Budget.Enabled)
+	budgetMW := security.NewBudgetMiddleware(budgetAdapter, float64(cfg.Security.Budget.HourlyLimit), float64(cfg.Security.Budget.DailyLimit), float64(cfg.Security.Budget.MonthlyLimit), security.BudgetRulesFromConfig(cfg.Security.Budget.Scopes), time.Duration(cfg.Security.Budget.ReservationTTLSeconds)*time.Second, thresholds, cfg.Security.Budget.Enabled)
+	budgetMW.SetAuditor(auditor)
+	if notifier != nil {
+		budgetMW.SetNotifier(notifier)
+	}
 
-	rateLimitMW := security.NewRateLimitMiddleware(cfg.Security.RateLimit.DefaultRate, cfg.Security.RateLimit.DefaultBurst, cfg.Security.RateLimit.ProviderLimits, cfg.Security.RateLimit.Enabled)
+	rateLimitMW := security.NewRateLimitMiddleware(cfg.Security.RateLimit)
 
 	heartbeatMW := compress.NewHeartbeatMiddleware(cfg.Compression.Heartbeat.Enabled, cfg.Compression.Heartbeat.DedupWindowSeconds, cfg.Compression.Heartbeat.HeartbeatModel)
 	dedupMW := compress.NewDedupMiddleware(fingerprintAdapter, cfg.Compression.Dedup.TTLSeconds, cfg.Compression.Dedup.Enabled)
@@ -242,9 +316,14 @@ func Run(cfg *config.Config, foreground bool) error {
 	if err != nil {
 		return fmt.Errorf("creating cache middleware: %w", err)
 	}
+	embeddingCache, err := cache.NewEmbeddingCache(store.NewEmbeddingAdapter(st), cfg.Metrics.EmbeddingCacheTTLSeconds, 10000)
+	if err != nil {
+		return fmt.Errorf("creating embedding cache: %w", err)
+	}
 
 	chain := pipeline.NewChain(
-		cacheMW,      // check cache first
+		policyMW,     // security: org policy, before anything is cached or spent
+		cacheMW,      // check cache before any other work
 		injectionMW,  // security: injection detection
 		piiMW,        // security: PII detection
 		budgetMW,     // security: budget enforcement
@@ -258,14 +337,16 @@ func Run(cfg *config.Config, foreground bool) error {
 	// Wire hot-reload refresh for middleware that supports reconfiguration.
 	if watcher != nil {
 		watcher.OnChange(func(old, newCfg *config.Config) {
-			rateLimitMW.Reconfigure(
-				newCfg.Security.RateLimit.DefaultRate,
-				newCfg.Security.RateLimit.DefaultBurst,
-				newCfg.Security.RateLimit.ProviderLimits,
-			)
+			recordConfigReload(auditor, configFile, old, newCfg)
+
+			policyMW.Reconfigure(newCfg.Security.Policy)
+			log.Info().Int("rules", len(newCfg.Security.Policy.Rules)).Msg("request policy reconfigured")
+
+			rateLimitMW.Reconfigure(newCfg.Security.RateLimit)
 			log.Info().Msg("rate limiter reconfigured")
 
 			cacheMW.SetTTL(newCfg.Metrics.CacheTTLSeconds)
+			embeddingCache.SetTTL(newCfg.Metrics.EmbeddingCacheTTLSeconds)
 			log.Info().Msg("cache TTL updated")
 		})
 	}
@@ -288,6 +369,9 @@ func Run(cfg *config.Config, foreground bool) error {
 			time.Duration(cfg.Resilience.CBResetTimeoutSec)*time.Second,
 			cfg.Resilience.CBHalfOpenMax,
 		)
+		if notifier != nil {
+			cbRegistry.SetNotifier(notifier)
+		}
 	}
 
 	streamTimeout := time.Duration(cfg.Server.StreamTimeout) * time.Second
@@ -307,21 +391,116 @@ func Run(cfg *config.Config, foreground bool) error {
 		cfg.Server.StoreBody,
 		cfg.Server.MaxLogBody,
 	)
+	proxyHandler.SetBodySinks(proxy.BodySinks{
+		Log:           cfg.Server.LogBody,
+		Store:         cfg.Server.StoreBodyMode,
+		StoreUpstream: cfg.Server.StoreBodySource == "upstream",
+		Redactor:      security.NewRedactor(cfg.Security.PII.AllowList),
+	})
+	proxyHandler.SetQuotaTracker(proxy.NewQuotaTracker(
+		collector,
+		time.Duration(cfg.Resilience.PacingMaxDelayMs)*time.Millisecond,
+	))
+	if cfg.Routing.HedgeEnabled {
+		proxyHandler.SetHedging(proxy.HedgeConfig{
+			Delay:         time.Duration(cfg.Routing.HedgeDelayMs) * time.Millisecond,
+			BudgetPercent: cfg.Routing.HedgeBudgetPercent,
+		})
+	}
+	if len(cfg.Routing.Cascades) > 0 {
+		cascades, err := cascadeSet(cfg.Routing.Cascades)
+		if err != nil {
+			return fmt.Errorf("routing cascades: %w", err)
+		}
+		proxyHandler.SetCascades(cascades)
+	}
+	proxyHandler.SetEmbeddingCache(embeddingCache)
+	var healthChecker *proxy.HealthChecker
+	if cfg.Resilience.HealthCheckEnabled {
+		healthChecker = proxy.NewHealthChecker(proxy.HealthCheckConfig{
+			Interval:           time.Duration(cfg.Resilience.HealthCheckIntervalSec) * time.Second,
+			Timeout:            time.Duration(cfg.Resilience.HealthCheckTimeoutSec) * time.Second,
+			UnhealthyThreshold: cfg.Resilience.HealthCheckUnhealthyThreshold,
+			HealthyThreshold:   cfg.Resilience.HealthCheckHealthyThreshold,
+		}, rtr.Providers(), upstreamClient, collector, log.Logger)
+		proxyHandler.SetHealthChecker(healthChecker)
+	}
+	if cfg.Scheduler.Enabled {
+		scheduler := proxy.NewScheduler(cfg.Scheduler, collector)
+		proxyHandler.SetScheduler(scheduler)
+		if watcher != nil {
+			watcher.OnChange(func(old, newCfg *config.Config) {
+				scheduler.Reconfigure(newCfg.Scheduler)
+				log.Info().Msg("scheduler reconfigured")
+			})
+		}
+	}
 
 	proxyAddr := fmt.Sprintf("%s:%d", cfg.Server.BindAddress, cfg.Server.ProxyPort)
 	readTimeout := time.Duration(cfg.Server.ReadTimeout) * time.Second
 	writeTimeout := time.Duration(cfg.Server.WriteTimeout) * time.Second
 	idleTimeout := time.Duration(cfg.Server.IdleTimeout) * time.Second
-	authToken := ""
-	if cfg.Auth.Enabled && cfg.Auth.Token != "" {
-		authToken = cfg.Auth.Token
+	var authenticator *auth.Authenticator
+	if cfg.Auth.Enabled {
+		authenticator = auth.NewAuthenticator(cfg.Auth.Token, store.NewVirtualKeyAdapter(st))
+		authenticator.SetDenialRecorder(auditor)
+		if cfg.Auth.TokenHash != "" {
+			if err := authenticator.SetHashedTokens([]auth.HashedToken{auth.SharedHashedToken(cfg.Auth.TokenHash)}); err != nil {
+				return fmt.Errorf("auth.token_hash: %w", err)
+			}
+		}
+		if cfg.Auth.Token != "" {
+			log.Warn().Msg("auth.token is stored in plaintext; consider auth.token_hash (see `tokenman hash-token`)")
+		}
 		log.Info().Msg("proxy API authentication enabled")
 	}
-	proxyServer := proxy.NewServer(proxyHandler, proxyAddr, readTimeout, writeTimeout, idleTimeout, cfg.Tracing.Enabled, authToken)
+	proxyServer := proxy.NewServer(proxyHandler, proxyAddr, readTimeout, writeTimeout, idleTimeout, cfg.Tracing.Enabled, authenticator)
+
+	// TLS certificates are served through a reloader so they can be rotated
+	// without a restart. With mTLS, verified client certificates become
+	// caller identities.
+	var (
+		tlsReloader *certs.Reloader
+		certAuth    *auth.CertAuthenticator
+	)
+	if cfg.Server.TLSEnabled {
+		var caFile string
+		if cfg.Server.MTLS.Enabled {
+			caFile = cfg.Server.MTLS.ClientCAFile
+		}
+		tlsReloader, err = certs.NewReloader(cfg.Server.CertFile, cfg.Server.KeyFile, caFile, strings.ToLower(cfg.Server.MTLS.ClientAuth))
+		if err != nil {
+			return fmt.Errorf("loading TLS certificates: %w", err)
+		}
+		if err := tlsReloader.Watch(); err != nil {
+			log.Warn().Err(err).Msg("failed to watch TLS certificates; continuing without reload")
+		}
+		defer tlsReloader.Close()
+	}
+	if cfg.Server.MTLS.Enabled {
+		certAuth = auth.NewCertAuthenticator(store.NewVirtualKeyAdapter(st))
+		if err := certAuth.SetMappings(certMappings(cfg.Server.MTLS)); err != nil {
+			return fmt.Errorf("server.mtls.identities: %w", err)
+		}
+		proxyServer.SetCertAuthenticator(certAuth)
+		if watcher != nil {
+			watcher.OnChange(func(old, newCfg *config.Config) {
+				if err := certAuth.SetMappings(certMappings(newCfg.Server.MTLS)); err != nil {
+					log.Error().Err(err).Msg("failed to reload client certificate mappings")
+				}
+			})
+		}
+		log.Info().Str("client_auth", cfg.Server.MTLS.ClientAuth).Int("identities", len(cfg.Server.MTLS.Identities)).Msg("mutual TLS enabled")
+	}
 
 	// Start cache purger and session reaper (reuse pruneCtx).
 	purgerDone := cacheMW.StartPurger(pruneCtx)
+	embeddingPurgerDone := embeddingCache.StartPurger(pruneCtx)
 	reaperDone := proxyHandler.StartSessionReaper(pruneCtx)
+	var healthDone <-chan struct{}
+	if healthChecker != nil {
+		healthDone = healthChecker.Start(pruneCtx)
+	}
 
 	// Channel to collect server startup errors.
 	errCh := make(chan error, 2)
@@ -329,7 +508,7 @@ func Run(cfg *config.Config, foreground bool) error {
 	go func() {
 		if cfg.Server.TLSEnabled {
 			log.Info().Str("addr", proxyAddr).Msg("proxy server starting (TLS)")
-			if err := proxyServer.StartTLS(cfg.Server.CertFile, cfg.Server.KeyFile); err != nil {
+			if err := proxyServer.StartTLSConfig(tlsReloader.TLSConfig(cfg.Server.MTLS.Enabled)); err != nil {
 				errCh <- fmt.Errorf("proxy server: %w", err)
 			}
 		} else {
@@ -345,10 +524,23 @@ func Run(cfg *config.Config, foreground bool) error {
 	if cfg.Dashboard.Enabled {
 		dashAddr := fmt.Sprintf("%s:%d", cfg.Server.BindAddress, cfg.Server.DashboardPort)
 		dashServer = metrics.NewDashboardServer(collector, st, cfg, dashAddr)
+		dashServer.SetCachePurger(cacheMW.Clear)
+		if healthChecker != nil {
+			dashServer.SetProviderStatus(healthChecker)
+		}
+		dashMTLS := cfg.Server.MTLS.Enabled && cfg.Server.MTLS.Dashboard
+		if dashMTLS {
+			dashServer.SetCertAuthenticator(certAuth)
+		}
+		if watcher != nil {
+			watcher.OnChange(func(old, newCfg *config.Config) {
+				dashServer.ReloadTokens(newCfg)
+			})
+		}
 
 		go func() {
 			if cfg.Server.TLSEnabled {
-				if err := dashServer.StartTLS(cfg.Server.CertFile, cfg.Server.KeyFile); err != nil {
+				if err := dashServer.StartTLSConfig(tlsReloader.TLSConfig(dashMTLS)); err != nil {
 					errCh <- fmt.Errorf("dashboard server: %w", err)
 				}
 			} else {
@@ -435,8 +627,15 @@ drained:
 	// 12. Clean up — wait for background goroutines before closing the store.
 	pruneCancel()
 	<-purgerDone
+	<-embeddingPurgerDone
 	<-reaperDone
+	if healthDone != nil {
+		<-healthDone
+	}
 	<-prunerDone
+	if alertDispatcher != nil {
+		alertDispatcher.Close()
+	}
 	st.Close()
 	if err := RemovePID(dataDir); err != nil {
 		log.Error().Err(err).Msg("failed to remove PID file during shutdown")
@@ -446,6 +645,28 @@ drained:
 	return nil
 }
 
+// newAlertDispatcher builds the alert dispatcher from configuration. Sinks
+// that cannot be built are skipped with a warning.
+func newAlertDispatcher(cfg config.AlertsConfig, st *store.Store) *alert.Dispatcher {
+	var sinks []alert.Sink
+	for i, sc := range cfg.Sinks {
+		sink, err := alert.NewSink(alert.SinkConfig{
+			Type:    sc.Type,
+			URL:     sc.URL,
+			Headers: sc.Headers,
+			Command: sc.Command,
+			Kinds:   sc.Kinds,
+		})
+		if err != nil {
+			log.Warn().Err(err).Int("sink", i).Msg("skipping alert sink")
+			continue
+		}
+		sinks = append(sinks, sink)
+	}
+	log.Info().Int("sinks", len(sinks)).Msg("alerting enabled")
+	return alert.NewDispatcher(store.NewAlertAdapter(st), sinks...)
+}
+
 // Stop reads the PID file and sends SIGTERM to the running daemon.
 func Stop() error {
 	dataDir := expandHome(config.Get().Server.DataDir)
@@ -587,6 +808,117 @@ func parseLogLevel(level string) zerolog.Level {
 	}
 }
 
+// routeRules converts the configured pattern routing rules.
+func routeRules(cfg []config.RouteRuleConfig) []router.Rule {
+	rules := make([]router.Rule, 0, len(cfg))
+	for _, r := range cfg {
+		rules = append(rules, router.Rule{Match: r.Match, Regex: r.Regex, Provider: r.Provider, Model: r.Model})
+	}
+	return rules
+}
+
+// cascadeSet builds the configured model cascades and their checks.
+func cascadeSet(cfg []config.CascadeConfig) (*cascade.Set, error) {
+	cascades := make([]*cascade.Cascade, 0, len(cfg))
+	for _, cc := range cfg {
+		c := &cascade.Cascade{
+			Name:        cc.Name,
+			Projects:    cc.Projects,
+			Aliases:     cc.Aliases,
+			CheapModel:  cc.CheapModel,
+			StrongModel: cc.StrongModel,
+		}
+		if cc.Checks.MaxTokens {
+			c.Checks = append(c.Checks, cascade.MaxTokensCheck())
+		}
+		if cc.Checks.Refusal {
+			c.Checks = append(c.Checks, cascade.RefusalCheck())
+		}
+		if cc.Checks.MinLength > 0 || cc.Checks.MaxLength > 0 {
+			c.Checks = append(c.Checks, cascade.LengthCheck(cc.Checks.MinLength, cc.Checks.MaxLength))
+		}
+		if cc.Checks.JSON || cc.Checks.JSONSchema != "" {
+			check, err := cascade.JSONCheck(cc.Checks.JSONSchema)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", cc.Name, err)
+			}
+			c.Checks = append(c.Checks, check)
+		}
+		if cc.Checks.Regex != "" {
+			check, err := cascade.RegexCheck(cc.Checks.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", cc.Name, err)
+			}
+			c.Checks = append(c.Checks, check)
+		}
+		cascades = append(cascades, c)
+	}
+	return cascade.NewSet(cascades), nil
+}
+
+// resolveProviderKeys resolves the key refs of a provider's key pool. Keys
+// that fail to resolve are logged and left out so the rest of the pool
+// keeps serving.
+func resolveProviderKeys(v *vault.Vault, provider string, refs []config.ProviderKeyConfig) []router.APIKey {
+	keys := make([]router.APIKey, 0, len(refs))
+	for i, kc := range refs {
+		secret, err := v.ResolveKeyRef(kc.Ref)
+		if err != nil {
+			log.Warn().Err(err).Str("provider", provider).Int("key", i).Str("name", kc.Name).Msg("failed to resolve pooled API key; skipping it")
+			continue
+		}
+		keys = append(keys, router.APIKey{ID: kc.Name, Secret: secret, Weight: kc.Weight})
+	}
+	return keys
+}
+
+// certMappings converts the configured client certificate identities.
+func certMappings(cfg config.MTLSConfig) []auth.CertMapping {
+	mappings := make([]auth.CertMapping, 0, len(cfg.Identities))
+	for _, id := range cfg.Identities {
+		mappings = append(mappings, auth.CertMapping{Match: id.Match, Project: id.Project, KeyID: id.KeyID})
+	}
+	return mappings
+}
+
+// DBPath returns the path of the SQLite database inside the configured data
+// directory. CLI commands use it to manage state alongside a running daemon.
+func DBPath(cfg *config.Config) string {
+	return filepath.Join(expandHome(cfg.Server.DataDir), "tokenman.db")
+}
+
+// OpenStore opens the configured database and, when encryption at rest is
+// enabled, resolves the master key through the vault and turns it on. It
+// fails rather than falling back to plaintext if the key is unavailable.
+func OpenStore(cfg *config.Config) (*store.Store, error) {
+	st, err := store.Open(DBPath(cfg))
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.Security.Encryption.Enabled {
+		return st, nil
+	}
+	master, err := ResolveMasterKey(cfg.Security.Encryption.KeyRef)
+	if err != nil {
+		st.Close()
+		return nil, err
+	}
+	if err := st.EnableEncryption(master); err != nil {
+		st.Close()
+		return nil, err
+	}
+	return st, nil
+}
+
+// ResolveMasterKey resolves and decodes an encryption master key reference.
+func ResolveMasterKey(keyRef string) ([]byte, error) {
+	raw, err := vault.New().ResolveKeyRef(keyRef)
+	if err != nil {
+		return nil, fmt.Errorf("resolving encryption master key: %w", err)
+	}
+	return store.ParseMasterKey(raw)
+}
+
 // expandHome replaces a leading ~ with the user's home directory.
 func expandHome(path string) string {
 	if !strings.HasPrefix(path, "~") {
@@ -598,3 +930,24 @@ func expandHome(path string) string {
 	}
 	return filepath.Join(home, path[1:])
 }
+
+// recordConfigReload writes the settings changed by a config reload to the
+// audit log. Reloads that change nothing are not recorded.
+func recordConfigReload(r audit.Recorder, file string, old, newCfg *config.Config) {
+	changes, err := config.Diff(old, newCfg)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to diff reloaded config")
+		return
+	}
+	if len(changes) == 0 {
+		return
+	}
+	audit.Record(r, audit.Event{
+		Kind:   audit.KindConfigReloaded,
+		Action: "reload",
+		Detail: map[string]interface{}{
+			"file":    file,
+			"changes": changes,
+		},
+	})
+}
@@ -2,10 +2,13 @@ package metrics
 
 import (
 	"context"
-	"crypto/subtle"
+	"crypto/tls"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
@@ -15,7 +18,11 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rs/zerolog/log"
 
+	"github.com/allaspectsdev/tokenman/internal/audit"
+	"github.com/allaspectsdev/tokenman/internal/auth"
 	"github.com/allaspectsdev/tokenman/internal/config"
+	"github.com/allaspectsdev/tokenman/internal/router"
+	"github.com/allaspectsdev/tokenman/internal/security"
 	"github.com/allaspectsdev/tokenman/internal/store"
 	"github.com/allaspectsdev/tokenman/web"
 )
@@ -29,6 +36,24 @@ type DashboardServer struct {
 	cfg       *config.Config
 	addr      string
 	server    *http.Server
+
+	// authenticator is nil when [auth] is disabled.
+	authenticator *auth.Authenticator
+	auditor       audit.Recorder
+	// clearMemoryCache, when set, empties the proxy's in-memory cache tier
+	// alongside the SQLite cache on purge.
+	clearMemoryCache func()
+	// certAuth, when set, derives caller identities from verified client
+	// certificates.
+	certAuth *auth.CertAuthenticator
+	// providerStatus, when set, reports active health check results.
+	providerStatus ProviderStatusSource
+}
+
+// ProviderStatusSource reports a provider's live health state and its
+// recent health checks, oldest first.
+type ProviderStatusSource interface {
+	ProviderStatus(provider string) (router.ProviderStatus, []router.HealthCheck, bool)
 }
 
 // NewDashboardServer creates a new DashboardServer wired to the given
@@ -39,6 +64,7 @@ func NewDashboardServer(collector *Collector, st *store.Store, cfg *config.Confi
 		store:     st,
 		cfg:       cfg,
 		addr:      addr,
+		auditor:   store.NewAuditAdapter(st),
 	}
 
 	r := chi.NewRouter()
@@ -58,32 +84,123 @@ func NewDashboardServer(collector *Collector, st *store.Store, cfg *config.Confi
 	r.Get("/", d.handleDashboard)
 	r.Get("/*", d.handleDashboard)
 
-	// Protected API routes — conditionally require auth.
-	r.Group(func(r chi.Router) {
-		if cfg.Auth.Enabled && cfg.Auth.Token != "" {
-			r.Use(authMiddleware(cfg.Auth.Token))
-			log.Info().Msg("dashboard API authentication enabled")
-		} else {
-			log.Warn().Msg("dashboard API authentication is disabled; set [auth] enabled=true with a token for production use")
+	// Protected API routes — conditionally require auth. Roles nest:
+	// viewers read metrics and history, auditors additionally see request
+	// bodies, the PII log and the audit log, and admins can change things.
+	if cfg.Auth.Enabled {
+		d.authenticator = auth.NewAuthenticator(cfg.Auth.Token, store.NewVirtualKeyAdapter(st))
+		d.authenticator.SetDenialRecorder(store.NewAuditAdapter(st))
+		d.ReloadTokens(cfg)
+		log.Info().Msg("dashboard API authentication enabled")
+	} else {
+		log.Warn().Msg("dashboard API authentication is disabled; set [auth] enabled=true with a token for production use")
+	}
+	requireScope := func(r chi.Router, scope string) {
+		r.Use(d.clientCertIdentity)
+		if d.authenticator != nil {
+			r.Use(d.authenticator.Middleware(scope))
 		}
+	}
+
+	r.Group(func(r chi.Router) {
+		requireScope(r, auth.ScopeDashboardRead)
 
 		r.Get("/api/stats", d.handleStats)
 		r.Get("/api/stats/history", d.handleStatsHistory)
 		r.Get("/api/requests", d.handleListRequests)
 		r.Get("/api/requests/{id}", d.handleGetRequest)
 		r.Get("/api/config", d.handleGetConfig)
-		r.Post("/api/config", d.handleUpdateConfig)
 		r.Get("/api/providers", d.handleProviders)
-		r.Get("/api/security/pii", d.handlePIILog)
 		r.Get("/api/security/budget", d.handleBudget)
+		r.Get("/api/security/policy", d.handlePolicyDecisions)
+		r.Get("/api/alerts", d.handleAlerts)
 		r.Get("/api/projects", d.handleProjects)
+		r.Get("/api/cascades", d.handleCascades)
 		r.Get("/api/plugins", d.handlePlugins)
 	})
 
+	r.Group(func(r chi.Router) {
+		requireScope(r, auth.ScopeDashboardAudit)
+
+		r.Get("/api/security/pii", d.handlePIILog)
+		r.Get("/api/audit", d.handleAuditEvents)
+		r.Get("/api/audit/export", d.handleAuditExport)
+	})
+
+	r.Group(func(r chi.Router) {
+		requireScope(r, auth.ScopeDashboardAdmin)
+
+		r.Post("/api/config", d.handleUpdateConfig)
+		r.Get("/api/keys", d.handleListKeys)
+		r.Post("/api/keys", d.handleCreateKey)
+		r.Delete("/api/keys/{id}", d.handleRevokeKey)
+		r.Post("/api/cache/purge", d.handlePurgeCache)
+		r.Post("/api/security/budget/reset", d.handleResetBudget)
+	})
+
 	d.router = r
 	return d
 }
 
+// SetCachePurger registers fn to be called when an admin purges the cache,
+// so that in-memory cache entries are dropped along with stored ones.
+func (d *DashboardServer) SetCachePurger(fn func()) {
+	d.clearMemoryCache = fn
+}
+
+// SetCertAuthenticator enables identities from client certificates, which
+// are only presented when the server is started with StartTLSConfig and a
+// configuration that requests them.
+func (d *DashboardServer) SetCertAuthenticator(c *auth.CertAuthenticator) {
+	d.certAuth = c
+}
+
+// SetProviderStatus makes /api/providers report live provider health and
+// uptime from s.
+func (d *DashboardServer) SetProviderStatus(s ProviderStatusSource) {
+	d.providerStatus = s
+}
+
+// clientCertIdentity applies the cert authenticator, if any.
+func (d *DashboardServer) clientCertIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d.certAuth == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		d.certAuth.Middleware(next).ServeHTTP(w, r)
+	})
+}
+
+// ReloadTokens replaces the hashed shared and dashboard tokens accepted by
+// the dashboard API with those in cfg. It is a no-op when auth is disabled.
+func (d *DashboardServer) ReloadTokens(cfg *config.Config) {
+	if d.authenticator == nil {
+		return
+	}
+	if err := d.authenticator.SetHashedTokens(dashboardTokens(cfg)); err != nil {
+		log.Error().Err(err).Msg("failed to load dashboard tokens")
+	}
+}
+
+// dashboardTokens returns the hashed tokens configured for the dashboard:
+// the shared auth.token_hash, which grants every scope, and one token per
+// [[dashboard.tokens]] entry limited to its role.
+func dashboardTokens(cfg *config.Config) []auth.HashedToken {
+	var tokens []auth.HashedToken
+	if cfg.Auth.TokenHash != "" {
+		tokens = append(tokens, auth.SharedHashedToken(cfg.Auth.TokenHash))
+	}
+	for _, t := range cfg.Dashboard.Tokens {
+		tokens = append(tokens, auth.HashedToken{
+			Name:   t.Name,
+			Scopes: auth.RoleScopes(strings.ToLower(t.Role)),
+			Hash:   t.Hash,
+		})
+	}
+	return tokens
+}
+
 // Start begins listening on the configured address. It blocks until the
 // server is shut down or an error occurs.
 func (d *DashboardServer) Start() error {
@@ -120,6 +237,25 @@ func (d *DashboardServer) StartTLS(certFile, keyFile string) error {
 	return nil
 }
 
+// StartTLSConfig is StartTLS with certificates supplied by cfg, for example
+// from a certs.Reloader.
+func (d *DashboardServer) StartTLSConfig(cfg *tls.Config) error {
+	d.server = &http.Server{
+		Addr:         d.addr,
+		Handler:      d.router,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		TLSConfig:    cfg,
+	}
+
+	log.Info().Str("addr", d.addr).Msg("dashboard server starting (TLS)")
+	if err := d.server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("dashboard server (TLS): %w", err)
+	}
+	return nil
+}
+
 // Shutdown gracefully shuts down the dashboard server.
 func (d *DashboardServer) Shutdown(ctx context.Context) error {
 	if d.server == nil {
@@ -246,24 +382,39 @@ func (d *DashboardServer) handleListRequests(w http.ResponseWriter, r *http.Requ
 		CacheHit    bool    `json:"cache_hit"`
 		RequestType string  `json:"request_type"`
 		Provider    string  `json:"provider"`
+		KeyID       string  `json:"key_id,omitempty"`
+		ProviderKey string  `json:"provider_key,omitempty"`
+		// RequestedModel is set when an alias or routing rule rewrote Model.
+		RequestedModel    string  `json:"requested_model,omitempty"`
+		ParentID          string  `json:"parent_id,omitempty"`
+		Cascade           string  `json:"cascade,omitempty"`
+		CascadeOutcome    string  `json:"cascade_outcome,omitempty"`
+		CascadeSavingsUSD float64 `json:"cascade_savings_usd,omitempty"`
 	}
 
 	entries := make([]requestEntry, 0, len(requests))
 	for _, req := range requests {
 		entries = append(entries, requestEntry{
-			ID:          req.ID,
-			Timestamp:   req.Timestamp,
-			Model:       req.Model,
-			TokensIn:    req.TokensIn,
-			TokensOut:   req.TokensOut,
-			TokensSaved: req.TokensSaved,
-			CostUSD:     req.CostUSD,
-			SavingsUSD:  req.SavingsUSD,
-			LatencyMs:   req.LatencyMs,
-			StatusCode:  req.StatusCode,
-			CacheHit:    req.CacheHit,
-			RequestType: req.RequestType,
-			Provider:    req.Provider,
+			ID:                req.ID,
+			Timestamp:         req.Timestamp,
+			Model:             req.Model,
+			TokensIn:          req.TokensIn,
+			TokensOut:         req.TokensOut,
+			TokensSaved:       req.TokensSaved,
+			CostUSD:           req.CostUSD,
+			SavingsUSD:        req.SavingsUSD,
+			LatencyMs:         req.LatencyMs,
+			StatusCode:        req.StatusCode,
+			CacheHit:          req.CacheHit,
+			RequestType:       req.RequestType,
+			Provider:          req.Provider,
+			KeyID:             req.KeyID,
+			ProviderKey:       req.ProviderKey,
+			RequestedModel:    req.RequestedModel,
+			ParentID:          req.ParentID,
+			Cascade:           req.Cascade,
+			CascadeOutcome:    req.CascadeOutcome,
+			CascadeSavingsUSD: req.CascadeSavingsUSD,
 		})
 	}
 
@@ -295,49 +446,80 @@ func (d *DashboardServer) handleGetRequest(w http.ResponseWriter, r *http.Reques
 
 	// Build a response that includes request and response bodies for debugging.
 	type requestDetail struct {
-		ID           string  `json:"id"`
-		Timestamp    string  `json:"timestamp"`
-		Method       string  `json:"method"`
-		Path         string  `json:"path"`
-		Format       string  `json:"format"`
-		Model        string  `json:"model"`
-		TokensIn     int64   `json:"tokens_in"`
-		TokensOut    int64   `json:"tokens_out"`
-		TokensCached int64   `json:"tokens_cached"`
-		TokensSaved  int64   `json:"tokens_saved"`
-		CostUSD      float64 `json:"cost_usd"`
-		SavingsUSD   float64 `json:"savings_usd"`
-		LatencyMs    int64   `json:"latency_ms"`
-		StatusCode   int     `json:"status_code"`
-		CacheHit     bool    `json:"cache_hit"`
-		RequestType  string  `json:"request_type"`
-		Provider     string  `json:"provider"`
-		ErrorMessage string  `json:"error_message"`
-		RequestBody  string  `json:"request_body"`
-		ResponseBody string  `json:"response_body"`
+		ID                string  `json:"id"`
+		Timestamp         string  `json:"timestamp"`
+		Method            string  `json:"method"`
+		Path              string  `json:"path"`
+		Format            string  `json:"format"`
+		Model             string  `json:"model"`
+		TokensIn          int64   `json:"tokens_in"`
+		TokensOut         int64   `json:"tokens_out"`
+		TokensCached      int64   `json:"tokens_cached"`
+		TokensSaved       int64   `json:"tokens_saved"`
+		CostUSD           float64 `json:"cost_usd"`
+		SavingsUSD        float64 `json:"savings_usd"`
+		LatencyMs         int64   `json:"latency_ms"`
+		StatusCode        int     `json:"status_code"`
+		CacheHit          bool    `json:"cache_hit"`
+		RequestType       string  `json:"request_type"`
+		Provider          string  `json:"provider"`
+		ErrorMessage      string  `json:"error_message"`
+		RequestBody       string  `json:"request_body,omitempty"`
+		ResponseBody      string  `json:"response_body,omitempty"`
+		Project           string  `json:"project"`
+		KeyID             string  `json:"key_id,omitempty"`
+		ProviderKey       string  `json:"provider_key,omitempty"`
+		RequestedModel    string  `json:"requested_model,omitempty"`
+		ParentID          string  `json:"parent_id,omitempty"`
+		Cascade           string  `json:"cascade,omitempty"`
+		CascadeOutcome    string  `json:"cascade_outcome,omitempty"`
+		CascadeSavingsUSD float64 `json:"cascade_savings_usd,omitempty"`
+		// Attempts lists the IDs of linked attempts that were not returned
+		// to the client, such as escalated cascade answers.
+		Attempts []string `json:"attempts,omitempty"`
+		// BodiesRedacted is set when the caller's role may not see bodies.
+		BodiesRedacted bool `json:"bodies_redacted,omitempty"`
 	}
 
 	detail := requestDetail{
-		ID:           req.ID,
-		Timestamp:    req.Timestamp,
-		Method:       req.Method,
-		Path:         req.Path,
-		Format:       req.Format,
-		Model:        req.Model,
-		TokensIn:     req.TokensIn,
-		TokensOut:    req.TokensOut,
-		TokensCached: req.TokensCached,
-		TokensSaved:  req.TokensSaved,
-		CostUSD:      req.CostUSD,
-		SavingsUSD:   req.SavingsUSD,
-		LatencyMs:    req.LatencyMs,
-		StatusCode:   req.StatusCode,
-		CacheHit:     req.CacheHit,
-		RequestType:  req.RequestType,
-		Provider:     req.Provider,
-		ErrorMessage: req.ErrorMessage,
-		RequestBody:  req.RequestBody,
-		ResponseBody: req.ResponseBody,
+		ID:                req.ID,
+		Timestamp:         req.Timestamp,
+		Method:            req.Method,
+		Path:              req.Path,
+		Format:            req.Format,
+		Model:             req.Model,
+		TokensIn:          req.TokensIn,
+		TokensOut:         req.TokensOut,
+		TokensCached:      req.TokensCached,
+		TokensSaved:       req.TokensSaved,
+		CostUSD:           req.CostUSD,
+		SavingsUSD:        req.SavingsUSD,
+		LatencyMs:         req.LatencyMs,
+		StatusCode:        req.StatusCode,
+		CacheHit:          req.CacheHit,
+		RequestType:       req.RequestType,
+		Provider:          req.Provider,
+		ErrorMessage:      req.ErrorMessage,
+		RequestBody:       req.RequestBody,
+		ResponseBody:      req.ResponseBody,
+		Project:           req.Project,
+		KeyID:             req.KeyID,
+		ProviderKey:       req.ProviderKey,
+		RequestedModel:    req.RequestedModel,
+		ParentID:          req.ParentID,
+		Cascade:           req.Cascade,
+		CascadeOutcome:    req.CascadeOutcome,
+		CascadeSavingsUSD: req.CascadeSavingsUSD,
+	}
+	if detail.Attempts, err = d.store.RequestAttempts(id); err != nil {
+		log.Error().Err(err).Str("id", id).Msg("failed to list request attempts")
+	}
+
+	// Bodies can hold prompts and PII, so only auditors and admins see them.
+	if id := auth.IdentityFromContext(r.Context()); id != nil && !id.HasScope(auth.ScopeDashboardAudit) {
+		detail.RequestBody = ""
+		detail.ResponseBody = ""
+		detail.BodiesRedacted = true
 	}
 
 	writeJSON(w, http.StatusOK, detail)
@@ -361,6 +543,7 @@ func (d *DashboardServer) handleGetConfig(w http.ResponseWriter, _ *http.Request
 	}
 
 	redactKeys(cfgMap)
+	redactAlertSinks(cfgMap)
 	writeJSON(w, http.StatusOK, cfgMap)
 }
 
@@ -391,27 +574,71 @@ func (d *DashboardServer) handleProviders(w http.ResponseWriter, _ *http.Request
 	cfg := config.Get()
 
 	type providerInfo struct {
-		Name     string   `json:"name"`
-		Enabled  bool     `json:"enabled"`
-		Models   []string `json:"models"`
-		Priority int      `json:"priority"`
-		APIBase  string   `json:"api_base"`
+		Name       string          `json:"name"`
+		Enabled    bool            `json:"enabled"`
+		Models     []string        `json:"models"`
+		Priority   int             `json:"priority"`
+		APIBase    string          `json:"api_base"`
+		RateLimits []ProviderQuota `json:"rate_limits"`
+		// Health is the rolling latency and error rate of upstream calls.
+		Health ProviderHealth `json:"health"`
+		// Routing is the latest routing strategy decision that ranked
+		// the provider.
+		Routing *ProviderRouting `json:"routing,omitempty"`
+		// Status is the live state from active health checks, with the
+		// share of recent checks during which the provider was healthy
+		// and the checks themselves for uptime timelines.
+		Status        *router.ProviderStatus `json:"status,omitempty"`
+		UptimePercent *float64               `json:"uptime_percent,omitempty"`
+		History       []router.HealthCheck   `json:"history,omitempty"`
 	}
 
 	providers := make([]providerInfo, 0, len(cfg.Providers))
 	for key, p := range cfg.Providers {
-		providers = append(providers, providerInfo{
-			Name:     key,
-			Enabled:  p.Enabled,
-			Models:   p.Models,
-			Priority: p.Priority,
-			APIBase:  p.APIBase,
-		})
+		quotas := d.collector.ProviderQuotas(key)
+		if quotas == nil {
+			quotas = []ProviderQuota{}
+		}
+		info := providerInfo{
+			Name:       key,
+			Enabled:    p.Enabled,
+			Models:     p.Models,
+			Priority:   p.Priority,
+			APIBase:    p.APIBase,
+			RateLimits: quotas,
+			Health:     d.collector.ProviderHealth(key),
+		}
+		if r, ok := d.collector.LastRouting(key); ok {
+			info.Routing = &r
+		}
+		if d.providerStatus != nil {
+			if st, history, ok := d.providerStatus.ProviderStatus(key); ok {
+				info.Status = &st
+				info.History = history
+				if len(history) > 0 {
+					uptime := healthyPercent(history)
+					info.UptimePercent = &uptime
+				}
+			}
+		}
+		providers = append(providers, info)
 	}
 
 	writeJSON(w, http.StatusOK, providers)
 }
 
+// healthyPercent returns the share of checks after which the provider was
+// healthy.
+func healthyPercent(history []router.HealthCheck) float64 {
+	healthy := 0
+	for _, c := range history {
+		if c.Healthy {
+			healthy++
+		}
+	}
+	return float64(healthy) / float64(len(history)) * 100
+}
+
 // handlePIILog returns paginated PII detection logs.
 func (d *DashboardServer) handlePIILog(w http.ResponseWriter, r *http.Request) {
 	page := queryInt(r, "page", 1)
@@ -461,16 +688,174 @@ func (d *DashboardServer) handlePIILog(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// handleBudget returns budget usage versus configured limits for each period.
+// handlePolicyDecisions handles GET /api/security/policy?page=1&limit=50&request_id=
+func (d *DashboardServer) handlePolicyDecisions(w http.ResponseWriter, r *http.Request) {
+	page := queryInt(r, "page", 1)
+	limit := queryInt(r, "limit", 50)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+	offset := (page - 1) * limit
+
+	decisions, err := d.store.ListPolicyDecisions(limit, offset, r.URL.Query().Get("request_id"))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list policy decisions")
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "database error"})
+		return
+	}
+
+	type policyEntry struct {
+		ID        int64  `json:"id"`
+		Timestamp string `json:"timestamp"`
+		RequestID string `json:"request_id"`
+		Rule      string `json:"rule"`
+		Action    string `json:"action"`
+		Detail    string `json:"detail,omitempty"`
+	}
+
+	results := make([]policyEntry, 0, len(decisions))
+	for _, dec := range decisions {
+		results = append(results, policyEntry{
+			ID:        dec.ID,
+			Timestamp: dec.Timestamp,
+			RequestID: dec.RequestID,
+			Rule:      dec.Rule,
+			Action:    dec.Action,
+			Detail:    dec.Detail,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"page":      page,
+		"limit":     limit,
+		"decisions": results,
+	})
+}
+
+// handleAuditEvents handles GET /api/audit?page=1&limit=50&kind=
+func (d *DashboardServer) handleAuditEvents(w http.ResponseWriter, r *http.Request) {
+	page := queryInt(r, "page", 1)
+	limit := queryInt(r, "limit", 50)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+	offset := (page - 1) * limit
+
+	events, err := d.store.ListAuditEvents(limit, offset, r.URL.Query().Get("kind"))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list audit events")
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "database error"})
+		return
+	}
+
+	type auditEntry struct {
+		ID         int64           `json:"id"`
+		Timestamp  string          `json:"timestamp"`
+		Kind       string          `json:"kind"`
+		Actor      string          `json:"actor,omitempty"`
+		Action     string          `json:"action"`
+		RemoteAddr string          `json:"remote_addr,omitempty"`
+		Detail     json.RawMessage `json:"detail,omitempty"`
+		Hash       string          `json:"hash,omitempty"`
+	}
+
+	results := make([]auditEntry, 0, len(events))
+	for _, e := range events {
+		entry := auditEntry{
+			ID:         e.ID,
+			Timestamp:  e.Timestamp,
+			Kind:       e.Kind,
+			Actor:      e.Actor,
+			Action:     e.Action,
+			RemoteAddr: e.RemoteAddr,
+			Hash:       e.Hash,
+		}
+		if json.Valid([]byte(e.Detail)) {
+			entry.Detail = json.RawMessage(e.Detail)
+		}
+		results = append(results, entry)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"page":   page,
+		"limit":  limit,
+		"events": results,
+	})
+}
+
+// handleAlerts handles GET /api/alerts?page=1&limit=50&kind=
+func (d *DashboardServer) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	page := queryInt(r, "page", 1)
+	limit := queryInt(r, "limit", 50)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+	offset := (page - 1) * limit
+
+	alerts, err := d.store.ListAlerts(limit, offset, r.URL.Query().Get("kind"))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list alerts")
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "database error"})
+		return
+	}
+
+	type alertEntry struct {
+		ID        int64                  `json:"id"`
+		Timestamp string                 `json:"timestamp"`
+		Kind      string                 `json:"kind"`
+		Severity  string                 `json:"severity"`
+		Title     string                 `json:"title"`
+		Message   string                 `json:"message"`
+		Fields    map[string]interface{} `json:"fields,omitempty"`
+	}
+
+	results := make([]alertEntry, 0, len(alerts))
+	for _, a := range alerts {
+		entry := alertEntry{
+			ID:        a.ID,
+			Timestamp: a.Timestamp,
+			Kind:      a.Kind,
+			Severity:  a.Severity,
+			Title:     a.Title,
+			Message:   a.Message,
+		}
+		if a.Fields != "" {
+			_ = json.Unmarshal([]byte(a.Fields), &entry.Fields)
+		}
+		results = append(results, entry)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"page":   page,
+		"limit":  limit,
+		"alerts": results,
+	})
+}
+
+// handleBudget returns budget usage versus configured limits for each
+// period: the global budget first, then every scoped budget that is
+// configured for an exact value or has recorded spending this period.
 func (d *DashboardServer) handleBudget(w http.ResponseWriter, _ *http.Request) {
 	cfg := config.Get()
 	now := time.Now().UTC()
+	rules := security.BudgetRulesFromConfig(cfg.Security.Budget.Scopes)
 
 	type budgetEntry struct {
-		Period string  `json:"period"`
-		Spent  float64 `json:"spent"`
-		Limit  float64 `json:"limit"`
-		Pct    float64 `json:"pct"`
+		Scope     string  `json:"scope"`
+		Period    string  `json:"period"`
+		Spent     float64 `json:"spent"`
+		Limit     float64 `json:"limit"`
+		Remaining float64 `json:"remaining"`
+		Pct       float64 `json:"pct"`
 	}
 
 	periods := []struct {
@@ -483,26 +868,51 @@ func (d *DashboardServer) handleBudget(w http.ResponseWriter, _ *http.Request) {
 		{"monthly", time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339), cfg.Security.Budget.MonthlyLimit},
 	}
 
+	newEntry := func(scope, period string, spent, limit float64) budgetEntry {
+		entry := budgetEntry{Scope: scope, Period: period, Spent: spent, Limit: limit}
+		if limit > 0 {
+			entry.Remaining = math.Max(limit-spent, 0)
+			entry.Pct = math.Min((spent/limit)*100, 100)
+		}
+		return entry
+	}
+
 	budgets := make([]budgetEntry, 0, len(periods))
 	for _, p := range periods {
-		limitF := float64(p.limit)
-		entry := budgetEntry{
-			Period: p.name,
-			Limit:  limitF,
-		}
-
-		b, err := d.store.GetBudget(p.name, p.start)
-		if err == nil {
-			entry.Spent = b.AmountUSD
-			if limitF > 0 {
-				entry.Pct = (b.AmountUSD / limitF) * 100
-				if entry.Pct > 100 {
-					entry.Pct = 100
-				}
-			}
+		rows, err := d.store.ListBudgets(p.name, p.start)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to list budgets")
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "database error"})
+			return
+		}
+		spent := make(map[string]float64, len(rows))
+		for _, b := range rows {
+			spent[b.Scope] = b.AmountUSD
 		}
 
-		budgets = append(budgets, entry)
+		budgets = append(budgets, newEntry(security.BudgetScopeGlobal, p.name, spent[security.BudgetScopeGlobal], float64(p.limit)))
+
+		// Exact-match rules are reported even before any spending.
+		seen := map[string]bool{security.BudgetScopeGlobal: true}
+		for _, r := range rules {
+			if strings.ContainsAny(r.Match, "*?[\\") {
+				continue
+			}
+			scope := security.BudgetScopeName(r.Scope, r.Match)
+			if limit := security.BudgetLimit(rules, scope, p.name); limit > 0 && !seen[scope] {
+				seen[scope] = true
+				budgets = append(budgets, newEntry(scope, p.name, spent[scope], limit))
+			}
+		}
+		for _, b := range rows {
+			if seen[b.Scope] {
+				continue
+			}
+			if limit := security.BudgetLimit(rules, b.Scope, p.name); limit > 0 {
+				seen[b.Scope] = true
+				budgets = append(budgets, newEntry(b.Scope, p.name, b.AmountUSD, limit))
+			}
+		}
 	}
 
 	writeJSON(w, http.StatusOK, budgets)
@@ -555,6 +965,184 @@ func (d *DashboardServer) handleProjects(w http.ResponseWriter, _ *http.Request)
 	writeJSON(w, http.StatusOK, projects)
 }
 
+// handleCascades returns per-cascade outcomes and net savings: what the
+// cascade saved on accepted cheap answers less what it spent on escalated
+// ones.
+func (d *DashboardServer) handleCascades(w http.ResponseWriter, _ *http.Request) {
+	type cascadeEntry struct {
+		Cascade       string  `json:"cascade"`
+		Requests      int64   `json:"requests"`
+		Accepted      int64   `json:"accepted"`
+		Escalated     int64   `json:"escalated"`
+		CostUSD       float64 `json:"cost_usd"`
+		NetSavingsUSD float64 `json:"net_savings_usd"`
+	}
+
+	rows, err := d.store.Reader().Query(`
+		SELECT cascade,
+		       COALESCE(SUM(CASE WHEN parent_id = '' THEN 1 ELSE 0 END), 0),
+		       COALESCE(SUM(CASE WHEN cascade_outcome = 'accepted' THEN 1 ELSE 0 END), 0),
+		       COALESCE(SUM(CASE WHEN cascade_outcome = 'escalated' THEN 1 ELSE 0 END), 0),
+		       COALESCE(SUM(cost_usd), 0.0),
+		       COALESCE(SUM(cascade_savings_usd), 0.0)
+		FROM requests
+		WHERE cascade != ''
+		GROUP BY cascade
+		ORDER BY cascade`)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to query cascades")
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "database error"})
+		return
+	}
+	defer rows.Close()
+
+	cascades := []cascadeEntry{}
+	for rows.Next() {
+		var c cascadeEntry
+		if err := rows.Scan(&c.Cascade, &c.Requests, &c.Accepted, &c.Escalated, &c.CostUSD, &c.NetSavingsUSD); err != nil {
+			log.Error().Err(err).Msg("failed to scan cascade row")
+			continue
+		}
+		cascades = append(cascades, c)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Msg("cascades rows iteration error")
+	}
+
+	writeJSON(w, http.StatusOK, cascades)
+}
+
+// virtualKeyEntry is the API representation of a virtual key. The key hash
+// is never exposed; only the display prefix is.
+type virtualKeyEntry struct {
+	ID            string   `json:"id"`
+	Name          string   `json:"name"`
+	Owner         string   `json:"owner"`
+	Prefix        string   `json:"prefix"`
+	Scopes        []string `json:"scopes"`
+	AllowedModels []string `json:"allowed_models"`
+	Priority      string   `json:"priority,omitempty"`
+	CreatedAt     string   `json:"created_at"`
+	ExpiresAt     string   `json:"expires_at,omitempty"`
+	RevokedAt     string   `json:"revoked_at,omitempty"`
+	LastUsedAt    string   `json:"last_used_at,omitempty"`
+}
+
+func toVirtualKeyEntry(k *store.VirtualKey) virtualKeyEntry {
+	e := virtualKeyEntry{
+		ID:            k.ID,
+		Name:          k.Name,
+		Owner:         k.Owner,
+		Prefix:        k.KeyPrefix,
+		Scopes:        k.Scopes,
+		AllowedModels: k.AllowedModels,
+		Priority:      k.Priority,
+		CreatedAt:     k.CreatedAt,
+		ExpiresAt:     k.ExpiresAt,
+		RevokedAt:     k.RevokedAt,
+		LastUsedAt:    k.LastUsedAt,
+	}
+	if e.Scopes == nil {
+		e.Scopes = []string{}
+	}
+	if e.AllowedModels == nil {
+		e.AllowedModels = []string{}
+	}
+	return e
+}
+
+// handleListKeys returns all virtual keys, including revoked ones.
+func (d *DashboardServer) handleListKeys(w http.ResponseWriter, _ *http.Request) {
+	keys, err := d.store.ListVirtualKeys()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list virtual keys")
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "database error"})
+		return
+	}
+
+	entries := make([]virtualKeyEntry, 0, len(keys))
+	for _, k := range keys {
+		entries = append(entries, toVirtualKeyEntry(k))
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// handleCreateKey issues a new virtual key. The plaintext key is returned
+// once in the response and cannot be retrieved again.
+func (d *DashboardServer) handleCreateKey(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name          string   `json:"name"`
+		Owner         string   `json:"owner"`
+		Scopes        []string `json:"scopes"`
+		AllowedModels []string `json:"allowed_models"`
+		Priority      string   `json:"priority"`
+		ExpiresIn     string   `json:"expires_in"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+		return
+	}
+	if body.Name == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name is required"})
+		return
+	}
+	scopes, err := auth.ParseScopes(strings.Join(body.Scopes, ","))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if body.Priority != "" && !config.IsPriorityClass(body.Priority) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "priority must be one of " + strings.Join(config.ValidPriorityClasses, ", ")})
+		return
+	}
+	var expiresAt time.Time
+	if body.ExpiresIn != "" {
+		ttl, err := auth.ParseTTL(body.ExpiresIn)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid expires_in"})
+			return
+		}
+		expiresAt = time.Now().Add(ttl)
+	}
+
+	plaintext, k, err := d.store.CreateVirtualKey(body.Name, body.Owner, scopes, body.AllowedModels, body.Priority, expiresAt)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create virtual key")
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "database error"})
+		return
+	}
+
+	log.Info().Str("key_id", k.ID).Str("owner", k.Owner).Strs("scopes", k.Scopes).Msg("virtual key created via API")
+	d.recordAdmin(r, audit.KindKeyCreated, map[string]interface{}{
+		"key_id": k.ID,
+		"name":   k.Name,
+		"owner":  k.Owner,
+		"scopes": k.Scopes,
+	})
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"key":     plaintext,
+		"details": toVirtualKeyEntry(k),
+	})
+}
+
+// handleRevokeKey revokes a virtual key by ID.
+func (d *DashboardServer) handleRevokeKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := d.store.RevokeVirtualKey(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "key not found"})
+			return
+		}
+		log.Error().Err(err).Str("key_id", id).Msg("failed to revoke virtual key")
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "database error"})
+		return
+	}
+
+	log.Info().Str("key_id", id).Msg("virtual key revoked via API")
+	d.recordAdmin(r, audit.KindKeyRevoked, map[string]interface{}{"key_id": id})
+	writeJSON(w, http.StatusOK, map[string]string{"status": "revoked", "id": id})
+}
+
 // handleDashboard serves the embedded HTML dashboard.
 func (d *DashboardServer) handleDashboard(w http.ResponseWriter, _ *http.Request) {
 	data, err := web.Assets.ReadFile("templates/index.html")
@@ -607,11 +1195,13 @@ func parseDurationParam(s string) (time.Duration, error) {
 }
 
 // redactKeys recursively walks a map and replaces any string value whose
-// key contains "key", "secret", or "token" (case-insensitive) with "****".
+// key contains "key", "secret", "token", or "hash" (case-insensitive) with
+// "****". Dashboard token hashes are redacted because a viewer could
+// otherwise guess hand-chosen auditor and admin tokens offline.
 func redactKeys(m map[string]interface{}) {
 	for k, v := range m {
 		lower := strings.ToLower(k)
-		if strings.Contains(lower, "key") || strings.Contains(lower, "secret") || strings.Contains(lower, "token") {
+		if strings.Contains(lower, "key") || strings.Contains(lower, "secret") || strings.Contains(lower, "token") || strings.Contains(lower, "hash") {
 			if _, ok := v.(string); ok {
 				m[k] = "****"
 				continue
@@ -630,6 +1220,27 @@ func redactKeys(m map[string]interface{}) {
 	}
 }
 
+// redactAlertSinks hides alert sink URLs and headers, which usually embed
+// credentials (Slack webhook URLs carry their token in the path).
+func redactAlertSinks(cfgMap map[string]interface{}) {
+	alerts, _ := cfgMap["Alerts"].(map[string]interface{})
+	sinks, _ := alerts["Sinks"].([]interface{})
+	for _, item := range sinks {
+		sink, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if u, _ := sink["URL"].(string); u != "" {
+			sink["URL"] = "****"
+		}
+		if headers, ok := sink["Headers"].(map[string]interface{}); ok {
+			for k := range headers {
+				headers[k] = "****"
+			}
+		}
+	}
+}
+
 // makeCORSMiddleware returns a CORS middleware configured with the given
 // allowed origins. When the list contains "*", all origins are permitted
 // (backward compatible with existing behavior). Otherwise, the Origin
@@ -669,7 +1280,7 @@ func makeCORSMiddleware(allowedOrigins []string) func(http.Handler) http.Handler
 				}
 			}
 
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
 			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 			if r.Method == http.MethodOptions {
 				w.WriteHeader(http.StatusNoContent)
@@ -680,35 +1291,130 @@ func makeCORSMiddleware(allowedOrigins []string) func(http.Handler) http.Handler
 	}
 }
 
-// authMiddleware returns a middleware that validates a Bearer token using
-// constant-time comparison. Returns 401 for missing tokens and 403 for
-// invalid tokens.
-func authMiddleware(token string) func(http.Handler) http.Handler {
-	tokenBytes := []byte(token)
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
-				w.Header().Set("WWW-Authenticate", "Bearer")
-				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "authentication required"})
-				return
-			}
+// handlePurgeCache handles POST /api/cache/purge, removing every cached
+// response from the store and the in-memory tier.
+func (d *DashboardServer) handlePurgeCache(w http.ResponseWriter, r *http.Request) {
+	n, err := d.store.PurgeCache()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to purge cache")
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "database error"})
+		return
+	}
+	if d.clearMemoryCache != nil {
+		d.clearMemoryCache()
+	}
 
-			// Expect "Bearer <token>".
-			const prefix = "Bearer "
-			if !strings.HasPrefix(authHeader, prefix) {
-				w.Header().Set("WWW-Authenticate", "Bearer")
-				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "authentication required"})
-				return
-			}
+	log.Info().Int64("entries", n).Msg("cache purged via API")
+	d.recordAdmin(r, audit.KindCachePurged, map[string]interface{}{"entries": n})
+	writeJSON(w, http.StatusOK, map[string]int64{"purged": n})
+}
 
-			provided := []byte(strings.TrimPrefix(authHeader, prefix))
-			if subtle.ConstantTimeCompare(provided, tokenBytes) != 1 {
-				writeJSON(w, http.StatusForbidden, map[string]string{"error": "invalid token"})
-				return
-			}
+// handleResetBudget handles POST /api/security/budget/reset. The body names
+// the period to reset and optionally the scope ("global" by default, or
+// e.g. "project:search"); only the current period's spending is cleared.
+func (d *DashboardServer) handleResetBudget(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Scope  string `json:"scope"`
+		Period string `json:"period"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+		return
+	}
+	if body.Scope == "" {
+		body.Scope = store.GlobalBudgetScope
+	}
+	switch body.Period {
+	case "hourly", "daily", "monthly":
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "period must be hourly, daily, or monthly"})
+		return
+	}
 
-			next.ServeHTTP(w, r)
-		})
+	if err := d.store.ResetScopedBudget(body.Scope, body.Period, security.BudgetPeriodStart(body.Period)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no spending recorded for this budget period"})
+			return
+		}
+		log.Error().Err(err).Msg("failed to reset budget")
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "database error"})
+		return
+	}
+
+	log.Info().Str("scope", body.Scope).Str("period", body.Period).Msg("budget reset via API")
+	d.recordAdmin(r, audit.KindBudgetReset, map[string]interface{}{"scope": body.Scope, "period": body.Period})
+	writeJSON(w, http.StatusOK, map[string]string{"scope": body.Scope, "period": body.Period, "status": "reset"})
+}
+
+// recordAdmin writes an admin action taken through the API to the audit log.
+func (d *DashboardServer) recordAdmin(r *http.Request, kind string, detail map[string]interface{}) {
+	var actor string
+	if id := auth.IdentityFromContext(r.Context()); id != nil {
+		actor = id.Actor()
+	}
+	audit.Record(d.auditor, audit.Event{
+		Kind:       kind,
+		Actor:      actor,
+		Action:     r.Method + " " + r.URL.Path,
+		RemoteAddr: r.RemoteAddr,
+		Detail:     detail,
+	})
+}
+
+// handleAuditExport handles GET /api/audit/export?after_id=0&limit=1000 for
+// SIEM collectors. It streams events oldest first as JSON Lines, including
+// the chain hashes so the collector can verify the log independently. To
+// page, pass the id of the last event received as after_id.
+func (d *DashboardServer) handleAuditExport(w http.ResponseWriter, r *http.Request) {
+	afterID, err := strconv.ParseInt(r.URL.Query().Get("after_id"), 10, 64)
+	if err != nil {
+		afterID = 0
+	}
+	limit := queryInt(r, "limit", 1000)
+	if limit < 1 || limit > 10000 {
+		limit = 1000
+	}
+
+	events, err := d.store.ExportAuditEvents(afterID, limit)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to export audit events")
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "database error"})
+		return
+	}
+
+	type exportEntry struct {
+		ID         int64           `json:"id"`
+		Timestamp  string          `json:"timestamp"`
+		Kind       string          `json:"kind"`
+		Actor      string          `json:"actor"`
+		Action     string          `json:"action"`
+		RemoteAddr string          `json:"remote_addr"`
+		Detail     json.RawMessage `json:"detail,omitempty"`
+		RawDetail  string          `json:"raw_detail"`
+		PrevHash   string          `json:"prev_hash"`
+		Hash       string          `json:"hash"`
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	for _, e := range events {
+		entry := exportEntry{
+			ID:         e.ID,
+			Timestamp:  e.Timestamp,
+			Kind:       e.Kind,
+			Actor:      e.Actor,
+			Action:     e.Action,
+			RemoteAddr: e.RemoteAddr,
+			RawDetail:  e.Detail,
+			PrevHash:   e.PrevHash,
+			Hash:       e.Hash,
+		}
+		if json.Valid([]byte(e.Detail)) {
+			entry.Detail = json.RawMessage(e.Detail)
+		}
+		if err := enc.Encode(entry); err != nil {
+			return
+		}
 	}
 }
@@ -163,6 +163,19 @@ func (gv *gaugeVec) set(labels map[string]string, v float64) {
 	atomic.StoreUint64(&g.value, math.Float64bits(v))
 }
 
+// add adds delta to a labeled value, for float counters such as costs.
+func (gv *gaugeVec) add(labels map[string]string, delta float64) {
+	key := labelsKey(labels)
+	gv.mu.Lock()
+	g, ok := gv.gauges[key]
+	if !ok {
+		g = &labeledGauge{labels: copyLabels(labels)}
+		gv.gauges[key] = g
+	}
+	gv.mu.Unlock()
+	addFloat64(&g.value, delta)
+}
+
 func (gv *gaugeVec) snapshot() []struct {
 	labels map[string]string
 	value  float64
@@ -231,8 +244,40 @@ type Collector struct {
 	errors           *counterVec   // labels: type, provider, status_code
 	latency          *histogramVec // labels: provider, model, streaming
 	providerRequests *counterVec   // labels: provider, status
+	keyRequests      *counterVec   // labels: provider, key, status
 	circuitState     *gaugeVec     // labels: provider
 	middlewareTime   *histogramVec // labels: middleware, phase
+	quotaRemaining   *gaugeVec     // labels: provider, key, limit
+	quotaLimit       *gaugeVec     // labels: provider, key, limit
+	schedulerQueue   *gaugeVec     // labels: class
+	schedulerWait    *histogramVec // labels: class
+	routingSelected  *counterVec   // labels: strategy, provider
+	hedges           *counterVec   // labels: provider, outcome
+	hedgeCost        *gaugeVec     // labels: provider
+	cascades         *counterVec   // labels: cascade, outcome, check
+	cascadeSavings   *gaugeVec     // labels: cascade
+	providerHealthy  *gaugeVec     // labels: provider
+	healthChecks     *counterVec   // labels: provider, outcome
+
+	quotaMu sync.RWMutex
+	quotas  map[string]ProviderQuota
+
+	healthMu sync.Mutex
+	health   map[string][]upstreamSample
+	routing  map[string]ProviderRouting
+}
+
+// ProviderQuota is the most recently reported upstream rate-limit window for
+// one provider, API key, and limit dimension ("requests", "tokens", ...).
+// Key is a short fingerprint of the API key, never the key itself. Max is -1
+// when the provider did not report the window's size.
+type ProviderQuota struct {
+	Provider  string    `json:"provider"`
+	Key       string    `json:"key"`
+	Limit     string    `json:"limit"`
+	Max       int64     `json:"max"`
+	Remaining int64     `json:"remaining"`
+	ResetAt   time.Time `json:"reset_at"`
 }
 
 // Stats is a point-in-time snapshot of the collector's counters,
@@ -255,6 +300,9 @@ type Stats struct {
 // latencyBuckets are tuned for LLM API call durations.
 var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
 
+// queueWaitBuckets are tuned for time spent waiting in the scheduler queue.
+var queueWaitBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 15, 60}
+
 // middlewareBuckets are tuned for per-middleware execution times (smaller).
 var middlewareBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
 
@@ -268,8 +316,23 @@ func NewCollector() *Collector {
 		errors:           newCounterVec(),
 		latency:          newHistogramVec(latencyBuckets),
 		providerRequests: newCounterVec(),
+		keyRequests:      newCounterVec(),
 		circuitState:     newGaugeVec(),
 		middlewareTime:   newHistogramVec(middlewareBuckets),
+		quotaRemaining:   newGaugeVec(),
+		quotaLimit:       newGaugeVec(),
+		schedulerQueue:   newGaugeVec(),
+		schedulerWait:    newHistogramVec(queueWaitBuckets),
+		routingSelected:  newCounterVec(),
+		hedges:           newCounterVec(),
+		hedgeCost:        newGaugeVec(),
+		cascades:         newCounterVec(),
+		cascadeSavings:   newGaugeVec(),
+		providerHealthy:  newGaugeVec(),
+		healthChecks:     newCounterVec(),
+		quotas:           make(map[string]ProviderQuota),
+		health:           make(map[string][]upstreamSample),
+		routing:          make(map[string]ProviderRouting),
 	}
 }
 
@@ -369,6 +432,47 @@ func (c *Collector) RecordProviderRequest(provider, status string) {
 	})
 }
 
+// RecordProviderKeyRequest increments the request counter for one pooled
+// upstream API key. key is the key's ID, never the key itself.
+func (c *Collector) RecordProviderKeyRequest(provider, key, status string) {
+	c.keyRequests.inc(map[string]string{
+		"provider": provider,
+		"key":      key,
+		"status":   status,
+	})
+}
+
+// RecordCascade counts a cascade decision. outcome is "accepted" when the
+// cheap answer was returned and "escalated" otherwise, with check naming
+// what rejected it. savingsUSD is added to the cascade's net savings and is
+// negative for escalations.
+func (c *Collector) RecordCascade(cascade, outcome, check string, savingsUSD float64) {
+	c.cascades.inc(map[string]string{
+		"cascade": cascade,
+		"outcome": outcome,
+		"check":   check,
+	})
+	c.cascadeSavings.add(map[string]string{"cascade": cascade}, savingsUSD)
+}
+
+// RecordHealthCheck counts an active health check of a provider. outcome is
+// "ok" or "fail".
+func (c *Collector) RecordHealthCheck(provider, outcome string) {
+	c.healthChecks.inc(map[string]string{
+		"provider": provider,
+		"outcome":  outcome,
+	})
+}
+
+// SetProviderHealthy sets whether health checks consider a provider healthy.
+func (c *Collector) SetProviderHealthy(provider string, healthy bool) {
+	v := 0.0
+	if healthy {
+		v = 1
+	}
+	c.providerHealthy.set(map[string]string{"provider": provider}, v)
+}
+
 // SetCircuitState sets the current circuit breaker state gauge for a provider.
 // 0=closed, 1=open, 2=half-open.
 func (c *Collector) SetCircuitState(provider string, state float64) {
@@ -377,6 +481,61 @@ func (c *Collector) SetCircuitState(provider string, state float64) {
 	}, state)
 }
 
+// SetProviderQuota records the latest upstream rate-limit window reported by
+// a provider and updates the corresponding gauges.
+func (c *Collector) SetProviderQuota(q ProviderQuota) {
+	labels := map[string]string{
+		"provider": q.Provider,
+		"key":      q.Key,
+		"limit":    q.Limit,
+	}
+	c.quotaRemaining.set(labels, float64(q.Remaining))
+	if q.Max >= 0 {
+		c.quotaLimit.set(labels, float64(q.Max))
+	}
+
+	c.quotaMu.Lock()
+	c.quotas[labelsKey(labels)] = q
+	c.quotaMu.Unlock()
+}
+
+// ProviderQuotas returns the latest rate-limit windows for the given
+// provider, ordered by key and limit.
+func (c *Collector) ProviderQuotas(provider string) []ProviderQuota {
+	c.quotaMu.RLock()
+	var out []ProviderQuota
+	for _, q := range c.quotas {
+		if q.Provider == provider {
+			out = append(out, q)
+		}
+	}
+	c.quotaMu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Key != out[j].Key {
+			return out[i].Key < out[j].Key
+		}
+		return out[i].Limit < out[j].Limit
+	})
+	return out
+}
+
+// SetSchedulerQueueDepth sets the number of requests waiting in a scheduler
+// priority class.
+func (c *Collector) SetSchedulerQueueDepth(class string, depth int) {
+	c.schedulerQueue.set(map[string]string{
+		"class": class,
+	}, float64(depth))
+}
+
+// ObserveSchedulerWait records how long a request waited in the scheduler
+// before being forwarded, in seconds.
+func (c *Collector) ObserveSchedulerWait(class string, seconds float64) {
+	c.schedulerWait.observe(map[string]string{
+		"class": class,
+	}, seconds)
+}
+
 // ObserveMiddlewareTime records a middleware execution time in seconds.
 func (c *Collector) ObserveMiddlewareTime(middleware, phase string, seconds float64) {
 	c.middlewareTime.observe(map[string]string{
@@ -394,12 +553,39 @@ func (c *Collector) Latency() *histogramVec { return c.latency }
 // ProviderRequests returns the provider request counter vec for Prometheus export.
 func (c *Collector) ProviderRequests() *counterVec { return c.providerRequests }
 
+// ProviderKeyRequests returns the per-API-key request counter vec for Prometheus export.
+func (c *Collector) ProviderKeyRequests() *counterVec { return c.keyRequests }
+
 // CircuitState returns the circuit state gauge vec for Prometheus export.
 func (c *Collector) CircuitState() *gaugeVec { return c.circuitState }
 
 // MiddlewareTime returns the middleware timing histogram vec for Prometheus export.
 func (c *Collector) MiddlewareTime() *histogramVec { return c.middlewareTime }
 
+// QuotaRemaining returns the upstream remaining-quota gauge vec for Prometheus export.
+func (c *Collector) QuotaRemaining() *gaugeVec { return c.quotaRemaining }
+
+// QuotaLimit returns the upstream quota-limit gauge vec for Prometheus export.
+func (c *Collector) QuotaLimit() *gaugeVec { return c.quotaLimit }
+
+// SchedulerQueue returns the scheduler queue depth gauge vec for Prometheus export.
+func (c *Collector) SchedulerQueue() *gaugeVec { return c.schedulerQueue }
+
+// SchedulerWait returns the scheduler wait-time histogram vec for Prometheus export.
+func (c *Collector) SchedulerWait() *histogramVec { return c.schedulerWait }
+
+// Cascades returns the cascade decision counter vec for Prometheus export.
+func (c *Collector) Cascades() *counterVec { return c.cascades }
+
+// CascadeSavings returns the per-cascade net savings for Prometheus export.
+func (c *Collector) CascadeSavings() *gaugeVec { return c.cascadeSavings }
+
+// HealthChecks returns the health check counter vec for Prometheus export.
+func (c *Collector) HealthChecks() *counterVec { return c.healthChecks }
+
+// ProviderHealthy returns the provider health gauge vec for Prometheus export.
+func (c *Collector) ProviderHealthy() *gaugeVec { return c.providerHealthy }
+
 // addFloat64 atomically adds delta to the float64 stored in addr using a CAS loop.
 func addFloat64(addr *uint64, delta float64) {
 	for {
@@ -207,6 +207,51 @@ func TestCollector_SetCircuitState(t *testing.T) {
 	}
 }
 
+func TestCollector_SetProviderQuota(t *testing.T) {
+	c := NewCollector()
+
+	c.SetProviderQuota(ProviderQuota{Provider: "openai", Key: "k1", Limit: "tokens", Max: 1000, Remaining: 900})
+	c.SetProviderQuota(ProviderQuota{Provider: "openai", Key: "k1", Limit: "tokens", Max: 1000, Remaining: 400})
+	c.SetProviderQuota(ProviderQuota{Provider: "openai", Key: "k1", Limit: "requests", Max: -1, Remaining: 7})
+	c.SetProviderQuota(ProviderQuota{Provider: "anthropic", Key: "k2", Limit: "requests", Max: 50, Remaining: 49})
+
+	quotas := c.ProviderQuotas("openai")
+	if len(quotas) != 2 {
+		t.Fatalf("expected 2 openai quotas, got %d", len(quotas))
+	}
+	if quotas[0].Limit != "requests" || quotas[1].Limit != "tokens" {
+		t.Errorf("quotas not ordered by limit: %+v", quotas)
+	}
+	if quotas[1].Remaining != 400 {
+		t.Errorf("tokens remaining: got %d, want 400", quotas[1].Remaining)
+	}
+
+	if n := len(c.QuotaRemaining().snapshot()); n != 3 {
+		t.Errorf("expected 3 remaining gauges, got %d", n)
+	}
+	// The unreported requests limit has no limit gauge.
+	if n := len(c.QuotaLimit().snapshot()); n != 2 {
+		t.Errorf("expected 2 limit gauges, got %d", n)
+	}
+}
+
+func TestCollector_SchedulerMetrics(t *testing.T) {
+	c := NewCollector()
+
+	c.SetSchedulerQueueDepth("batch", 3)
+	c.SetSchedulerQueueDepth("batch", 1)
+	c.ObserveSchedulerWait("batch", 0.2)
+	c.ObserveSchedulerWait("interactive", 0.01)
+
+	depth := c.SchedulerQueue().snapshot()
+	if len(depth) != 1 || depth[0].value != 1 {
+		t.Errorf("queue depth = %+v, want batch=1", depth)
+	}
+	if n := len(c.SchedulerWait().snapshot()); n != 2 {
+		t.Errorf("expected 2 wait histograms, got %d", n)
+	}
+}
+
 func TestCollector_ObserveMiddlewareTime(t *testing.T) {
 	c := NewCollector()
 
@@ -237,3 +282,26 @@ func TestFormatDuration(t *testing.T) {
 		}
 	}
 }
+
+func TestCollector_ProviderHealth(t *testing.T) {
+	c := NewCollector()
+	for i := 1; i <= 10; i++ {
+		c.ObserveUpstream("anthropic", time.Duration(i)*100*time.Millisecond, false)
+	}
+	c.ObserveUpstream("anthropic", 5*time.Second, true)
+	c.ObserveUpstream("anthropic", 5*time.Second, true)
+
+	h := c.ProviderHealth("anthropic")
+	if h.Samples != 12 {
+		t.Errorf("Samples: got %d, want 12", h.Samples)
+	}
+	if h.P50Ms != 500 || h.P95Ms != 1000 {
+		t.Errorf("P50/P95: got %.0f/%.0f ms, want 500/1000 (failures excluded)", h.P50Ms, h.P95Ms)
+	}
+	if want := 2.0 / 12; h.ErrorRate != want {
+		t.Errorf("ErrorRate: got %f, want %f", h.ErrorRate, want)
+	}
+	if h := c.ProviderHealth("openai"); h.Samples != 0 {
+		t.Errorf("unobserved provider Samples: got %d, want 0", h.Samples)
+	}
+}
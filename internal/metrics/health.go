@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"sort"
+	"time"
+)
+
+// Rolling upstream health is computed over at most healthMaxSamples calls
+// per provider from the last healthWindow.
+const (
+	healthWindow     = 5 * time.Minute
+	healthMaxSamples = 256
+)
+
+// ProviderHealth is a rolling summary of a provider's recent upstream calls.
+// Latency percentiles only cover calls that succeeded.
+type ProviderHealth struct {
+	Samples   int     `json:"samples"`
+	P50Ms     float64 `json:"p50_ms"`
+	P95Ms     float64 `json:"p95_ms"`
+	ErrorRate float64 `json:"error_rate"`
+}
+
+// ProviderRouting is the most recent routing decision that ranked a
+// provider: where it was placed among the candidates and why.
+type ProviderRouting struct {
+	Time     time.Time `json:"time"`
+	Model    string    `json:"model"`
+	Strategy string    `json:"strategy"`
+	Rank     int       `json:"rank"`
+	Reason   string    `json:"reason"`
+}
+
+type upstreamSample struct {
+	at      time.Time
+	seconds float64
+	failed  bool
+}
+
+// ObserveUpstream records the duration and outcome of one upstream call.
+// failed covers transport errors, 429s, and 5xx responses.
+func (c *Collector) ObserveUpstream(provider string, d time.Duration, failed bool) {
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+	samples := append(c.health[provider], upstreamSample{at: time.Now(), seconds: d.Seconds(), failed: failed})
+	if len(samples) > healthMaxSamples {
+		samples = samples[len(samples)-healthMaxSamples:]
+	}
+	c.health[provider] = samples
+}
+
+// ProviderHealth returns the rolling health of a provider.
+func (c *Collector) ProviderHealth(provider string) ProviderHealth {
+	cutoff := time.Now().Add(-healthWindow)
+	c.healthMu.Lock()
+	var latencies []float64
+	var h ProviderHealth
+	failures := 0
+	for _, s := range c.health[provider] {
+		if s.at.Before(cutoff) {
+			continue
+		}
+		h.Samples++
+		if s.failed {
+			failures++
+			continue
+		}
+		latencies = append(latencies, s.seconds)
+	}
+	c.healthMu.Unlock()
+
+	if h.Samples == 0 {
+		return h
+	}
+	h.ErrorRate = float64(failures) / float64(h.Samples)
+	if len(latencies) > 0 {
+		sort.Float64s(latencies)
+		h.P50Ms = quantile(latencies, 0.50) * 1000
+		h.P95Ms = quantile(latencies, 0.95) * 1000
+	}
+	return h
+}
+
+// quantile returns the q-quantile of sorted values by nearest rank.
+func quantile(sorted []float64, q float64) float64 {
+	i := int(q*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// RecordRouting stores the latest routing decision for a provider and, when
+// it was ranked first, counts it as selected.
+func (c *Collector) RecordRouting(provider string, r ProviderRouting) {
+	c.healthMu.Lock()
+	c.routing[provider] = r
+	c.healthMu.Unlock()
+	if r.Rank == 1 {
+		c.routingSelected.inc(map[string]string{
+			"strategy": r.Strategy,
+			"provider": provider,
+		})
+	}
+}
+
+// LastRouting returns the latest routing decision that ranked provider.
+func (c *Collector) LastRouting(provider string) (ProviderRouting, bool) {
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+	r, ok := c.routing[provider]
+	return r, ok
+}
+
+// RoutingSelected returns the routing selection counter vec for Prometheus export.
+func (c *Collector) RoutingSelected() *counterVec { return c.routingSelected }
+
+// RecordHedge counts a hedging decision for requests whose primary was
+// provider. outcome is "won" (the hedge answered first), "lost" (the primary
+// did), "failed" (the hedge failed), or "budget_exhausted" (no hedge was
+// sent). extraCostUSD is the estimated cost of the discarded duplicate.
+func (c *Collector) RecordHedge(provider, outcome string, extraCostUSD float64) {
+	c.hedges.inc(map[string]string{
+		"provider": provider,
+		"outcome":  outcome,
+	})
+	if extraCostUSD > 0 {
+		c.hedgeCost.add(map[string]string{"provider": provider}, extraCostUSD)
+	}
+}
+
+// Hedges returns the hedge counter vec for Prometheus export.
+func (c *Collector) Hedges() *counterVec { return c.hedges }
+
+// HedgeCost returns the hedge extra-cost vec for Prometheus export.
+func (c *Collector) HedgeCost() *gaugeVec { return c.hedgeCost }
@@ -83,12 +83,60 @@ func PrometheusHandler(collector *Collector) http.HandlerFunc {
 		writeCounterVec(w, "tokenman_provider_requests_total",
 			"Total requests per provider and outcome status.",
 			collector.ProviderRequests())
+		writeCounterVec(w, "tokenman_provider_key_requests_total",
+			"Total requests per provider, pooled API key, and outcome status.",
+			collector.ProviderKeyRequests())
+
+		// Providers chosen first by the routing strategy.
+		writeCounterVec(w, "tokenman_routing_selected_total",
+			"Requests for which the routing strategy ranked a provider first.",
+			collector.RoutingSelected())
+
+		// Request hedging outcomes and the cost of discarded duplicates.
+		writeCounterVec(w, "tokenman_hedges_total",
+			"Hedging decisions per primary provider and outcome.",
+			collector.Hedges())
+		writeFloatCounterVec(w, "tokenman_hedge_extra_cost_usd_total",
+			"Estimated cost in USD of discarded hedged duplicates per primary provider.",
+			collector.HedgeCost())
+
+		// Model cascade decisions and net savings.
+		writeCounterVec(w, "tokenman_cascade_requests_total",
+			"Cascade decisions per cascade, outcome, and failed check.",
+			collector.Cascades())
+		writeGaugeVec(w, "tokenman_cascade_net_savings_usd",
+			"Net USD saved per cascade: savings on accepted cheap answers less the cost of escalated ones.",
+			collector.CascadeSavings())
+
+		// Active health check results and provider health.
+		writeCounterVec(w, "tokenman_health_checks_total",
+			"Active health checks per provider and outcome.",
+			collector.HealthChecks())
+		writeGaugeVec(w, "tokenman_provider_healthy",
+			"Whether health checks consider a provider healthy (1) or not (0).",
+			collector.ProviderHealthy())
 
 		// Circuit breaker state gauges.
 		writeGaugeVec(w, "tokenman_provider_circuit_state",
 			"Circuit breaker state per provider (0=closed, 1=open, 2=half-open).",
 			collector.CircuitState())
 
+		// Upstream rate-limit quota gauges, from provider response headers.
+		writeGaugeVec(w, "tokenman_provider_ratelimit_remaining",
+			"Remaining upstream rate-limit quota per provider, API key fingerprint, and limit.",
+			collector.QuotaRemaining())
+		writeGaugeVec(w, "tokenman_provider_ratelimit_limit",
+			"Upstream rate-limit window size per provider, API key fingerprint, and limit.",
+			collector.QuotaLimit())
+
+		// Priority scheduler queue depth and wait time.
+		writeGaugeVec(w, "tokenman_scheduler_queue_depth",
+			"Requests waiting for an upstream slot per priority class.",
+			collector.SchedulerQueue())
+		writeHistogramVec(w, "tokenman_scheduler_wait_seconds",
+			"Time requests spent waiting in the priority scheduler in seconds.",
+			collector.SchedulerWait())
+
 		// Middleware timing histograms.
 		writeHistogramVec(w, "tokenman_middleware_duration_seconds",
 			"Per-middleware execution time in seconds.",
@@ -212,3 +260,16 @@ func writeGaugeVec(w http.ResponseWriter, name, help string, gv *gaugeVec) {
 		fmt.Fprintf(w, "%s%s %g\n", name, formatLabels(e.labels), e.value)
 	}
 }
+
+// writeFloatCounterVec writes a labeled float counter in Prometheus text format.
+func writeFloatCounterVec(w http.ResponseWriter, name, help string, gv *gaugeVec) {
+	entries := gv.snapshot()
+	if len(entries) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s%s %g\n", name, formatLabels(e.labels), e.value)
+	}
+}
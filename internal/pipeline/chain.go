@@ -172,6 +172,26 @@ func (c *Chain) Middlewares() []Middleware {
 	return result
 }
 
+// Release lets every enabled middleware that implements Releaser free the
+// resources it holds for req. It is meant to be deferred by the caller after
+// ProcessRequest so that failed or abandoned requests clean up promptly.
+func (c *Chain) Release(ctx context.Context, req *Request) {
+	if req == nil {
+		return
+	}
+	for _, mw := range c.middlewares {
+		if !mw.Enabled() {
+			continue
+		}
+		if r, ok := mw.(Releaser); ok {
+			_ = recoverMiddleware(mw.Name(), func() error {
+				r.Release(ctx, req)
+				return nil
+			})
+		}
+	}
+}
+
 // recordTiming stores the latest execution time for a middleware phase.
 func (c *Chain) recordTiming(name string, d time.Duration) {
 	c.mu.Lock()
@@ -613,3 +613,41 @@ func TestMiddlewaresAccessor(t *testing.T) {
 		t.Error("mutating returned Middlewares() slice should not affect the chain")
 	}
 }
+
+// releasingMiddleware records Release calls.
+type releasingMiddleware struct {
+	mockMiddleware
+	released []string
+}
+
+func (m *releasingMiddleware) Release(ctx context.Context, req *Request) {
+	m.released = append(m.released, req.ID)
+}
+
+// TestRelease verifies that Release reaches enabled Releasers only, even
+// when the request phase failed.
+func TestRelease(t *testing.T) {
+	failing := &releasingMiddleware{mockMiddleware: mockMiddleware{
+		name:    "failing",
+		enabled: true,
+		onReq: func(ctx context.Context, req *Request) (*Request, error) {
+			return nil, errors.New("boom")
+		},
+	}}
+	disabled := &releasingMiddleware{mockMiddleware: mockMiddleware{name: "disabled"}}
+	chain := NewChain(failing, disabled, &mockMiddleware{name: "plain", enabled: true})
+
+	req := newRequest()
+	if _, _, err := chain.ProcessRequest(context.Background(), req); err == nil {
+		t.Fatal("expected request error")
+	}
+	chain.Release(context.Background(), req)
+	chain.Release(context.Background(), nil)
+
+	if len(failing.released) != 1 || failing.released[0] != "test-req" {
+		t.Errorf("failing.released = %v, want [test-req]", failing.released)
+	}
+	if len(disabled.released) != 0 {
+		t.Errorf("disabled middleware was released: %v", disabled.released)
+	}
+}
@@ -19,3 +19,11 @@ type Middleware interface {
 	// response or return an error.
 	ProcessResponse(ctx context.Context, req *Request, resp *Response) (*Response, error)
 }
+
+// Releaser is implemented by middleware that holds per-request resources
+// (such as budget reservations) acquired in ProcessRequest. Release is called
+// once the request is finished, whether or not ProcessResponse ran, and must
+// be safe to call after the resources were already settled.
+type Releaser interface {
+	Release(ctx context.Context, req *Request)
+}
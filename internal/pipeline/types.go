@@ -13,7 +13,14 @@ type APIFormat string
 const (
 	FormatAnthropic APIFormat = "anthropic"
 	FormatOpenAI    APIFormat = "openai"
-	FormatUnknown   APIFormat = "unknown"
+	FormatGemini    APIFormat = "gemini"
+	// FormatOpenAIResponses is OpenAI's Responses API (/v1/responses). It
+	// is served only by OpenAI-format providers.
+	FormatOpenAIResponses APIFormat = "openai_responses"
+	// FormatOpenAIEmbeddings is OpenAI's embeddings endpoint
+	// (/v1/embeddings), also served only by OpenAI-format providers.
+	FormatOpenAIEmbeddings APIFormat = "openai_embeddings"
+	FormatUnknown          APIFormat = "unknown"
 )
 
 // Message represents a chat message in normalized form.
@@ -149,6 +156,17 @@ type Request struct {
 	TokensIn     int
 	Flags        map[string]bool
 	Headers      map[string]string // original request headers
+	Project      string            // X-Tokenman-Project header, "default" when absent
+	KeyID        string            // virtual key ID; empty for the shared token
+	KeyOwner     string            // owner of the virtual key
+	ProviderKey  string            // ID of the upstream API key chosen for the request
+	// RequestedModel is the model the client asked for, before aliases and
+	// routing rules rewrote Model.
+	RequestedModel string
+	// PreviousResponseID is the stored response an OpenAI Responses request
+	// continues from. The earlier turns live upstream, so Messages holds
+	// only the new input.
+	PreviousResponseID string
 }
 
 // Response represents a normalized API response flowing through the pipeline.
@@ -0,0 +1,270 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+
+	"github.com/allaspectsdev/tokenman/internal/auth"
+	"github.com/allaspectsdev/tokenman/internal/cascade"
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+	"github.com/allaspectsdev/tokenman/internal/security"
+	"github.com/allaspectsdev/tokenman/internal/store"
+	"github.com/allaspectsdev/tokenman/internal/tokenizer"
+)
+
+// Cascade outcomes recorded on the request and in metrics.
+const (
+	cascadeAccepted  = "accepted"
+	cascadeEscalated = "escalated"
+)
+
+// SetCascades enables model cascading for the requests the set matches.
+func (h *ProxyHandler) SetCascades(s *cascade.Set) {
+	h.cascades = s
+}
+
+// cascadeRun tracks one request handled by a cascade.
+type cascadeRun struct {
+	c           *cascade.Cascade
+	strongModel string // the model the request escalates to
+	outcome     string
+	check       string  // the check that rejected the cheap answer
+	attemptCost float64 // cost of the rejected cheap attempt
+	savings     float64
+}
+
+// name returns the cascade's name, or "" when r is nil or the cascade was
+// skipped.
+func (r *cascadeRun) name() string {
+	if r == nil || r.outcome == "" {
+		return ""
+	}
+	return r.c.Name
+}
+
+// result returns the outcome and net savings to store with the request.
+func (r *cascadeRun) result() (string, float64) {
+	if r == nil {
+		return "", 0
+	}
+	return r.outcome, r.savings
+}
+
+// matchCascade returns the cascade for pipeReq, if any. A request for a
+// cascade alias is rewritten to the cascade's strong model, which also
+// serves streaming requests since only complete answers can be checked.
+func (h *ProxyHandler) matchCascade(project string, pipeReq *pipeline.Request, logger zerolog.Logger) *cascadeRun {
+	c := h.cascades.Match(project, pipeReq.Model)
+	if c == nil {
+		return nil
+	}
+	if h.cascades.Alias(pipeReq.Model) == c {
+		pipeReq.Model = c.StrongModel
+	}
+	if pipeReq.Stream {
+		logger.Debug().Str("cascade", c.Name).Msg("streaming request, skipping cascade")
+		return nil
+	}
+	return &cascadeRun{c: c}
+}
+
+// forward sends pipeReq upstream, with retries and fallback when enabled.
+func (h *ProxyHandler) forward(ctx context.Context, pipeReq *pipeline.Request, logger zerolog.Logger) (*http.Response, error) {
+	if h.cbRegistry != nil && h.retryConfig.MaxAttempts > 0 {
+		return h.forwardWithRetry(ctx, pipeReq, logger)
+	}
+	return h.forwardOnce(ctx, pipeReq, logger)
+}
+
+// forwardCascade sends pipeReq to the cascade's cheap model first. An
+// answer that passes every check is returned with pipeReq switched to the
+// cheap model. Otherwise the attempt is stored, linked to the request, and
+// pipeReq is forwarded to the strong model as usual.
+func (h *ProxyHandler) forwardCascade(ctx context.Context, r *http.Request, body []byte, pipeReq *pipeline.Request, run *cascadeRun, logger zerolog.Logger) (*http.Response, error) {
+	run.strongModel = pipeReq.Model
+	cheap := *pipeReq
+	cheap.ID = uuid.New().String()
+	cheap.RequestedModel = run.c.CheapModel
+	cheap.Model = run.c.CheapModel
+	cheap.Metadata = make(map[string]interface{}, len(pipeReq.Metadata))
+	for k, v := range pipeReq.Metadata {
+		cheap.Metadata[k] = v
+	}
+	delete(cheap.Metadata, "provider")
+	if provider, model, err := h.router.ResolveModel(run.c.CheapModel); err == nil {
+		cheap.Model = model
+		cheap.Metadata["provider"] = provider.Name
+	}
+	// The attempt runs within the request's own concurrency slots, and it
+	// must not queue for capacity: escalating is cheaper than waiting.
+	cheap.Metadata[security.ParentMetadataKey] = pipeReq.ID
+	cheap.Metadata[security.MaxWaitMetadataKey] = time.Duration(0)
+	if cheap.Model == pipeReq.Model {
+		run.outcome = cascadeAccepted
+		return h.forward(ctx, pipeReq, logger)
+	}
+	cheap.RawBody = rebuildRequestBody(&cheap)
+	cheap.ProviderKey = ""
+	logger = logger.With().Str("cascade", run.c.Name).Logger()
+
+	// The cheap attempt is a request of its own: the key and policy must
+	// allow its model, and it is admitted and charged against budgets and
+	// rate limits. If it cannot be sent, the strong model answers directly.
+	if err := h.cheapAllowed(ctx, &cheap); err != nil {
+		logger.Debug().Err(err).Str("model", cheap.Model).Msg("cheap model not allowed, skipping cascade")
+		return h.forward(ctx, pipeReq, logger)
+	}
+	spend := h.spendChain()
+	defer spend.Release(context.WithoutCancel(ctx), &cheap)
+	if _, _, err := spend.ProcessRequest(ctx, &cheap); err != nil {
+		logger.Debug().Err(err).Str("model", cheap.Model).Msg("cheap attempt not admitted, skipping cascade")
+		return h.forward(ctx, pipeReq, logger)
+	}
+
+	start := time.Now()
+	status := http.StatusBadGateway
+	var respBody []byte
+	var reason error
+	resp, err := h.forward(ctx, &cheap, logger)
+	if err == nil {
+		status = resp.StatusCode
+		respBody, err = h.readCascadeBody(resp)
+	}
+	switch {
+	case err != nil:
+		run.check, reason = "upstream_error", err
+	case status >= 300:
+		run.check, reason = "status", fmt.Errorf("upstream returned status %d", status)
+	default:
+		run.check, reason = run.c.Evaluate(cascade.ParseAnswer(respBody, cheap.Format))
+	}
+
+	if reason == nil {
+		run.outcome = cascadeAccepted
+		pipeReq.Model = cheap.Model
+		pipeReq.RawBody = cheap.RawBody
+		pipeReq.ProviderKey = cheap.ProviderKey
+		metadata := make(map[string]interface{}, len(pipeReq.Metadata)+1)
+		for k, v := range pipeReq.Metadata {
+			metadata[k] = v
+		}
+		metadata["provider"] = cheap.Metadata["provider"]
+		pipeReq.Metadata = metadata
+		logger.Debug().Str("model", cheap.Model).Msg("cascade accepted cheap answer")
+		resp.Body = io.NopCloser(bytes.NewReader(respBody))
+		return resp, nil
+	}
+
+	run.outcome = cascadeEscalated
+	tokensOut, tokensCached := extractResponseUsage(respBody, cheap.Format)
+	run.attemptCost = tokenizer.EstimateCost(cheap.Model, cheap.TokensIn, tokensOut)
+	attempt := &pipeline.Response{
+		RequestID:    pipeReq.ID,
+		StatusCode:   status,
+		TokensOut:    tokensOut,
+		TokensCached: tokensCached,
+		CostUSD:      run.attemptCost,
+		Latency:      time.Since(start),
+	}
+	if _, err := spend.ProcessResponse(ctx, &cheap, attempt); err != nil {
+		logger.Error().Err(err).Msg("failed to charge cascade attempt")
+	}
+	logger.Info().Str("check", run.check).Str("reason", reason.Error()).Str("model", cheap.Model).Str("strong_model", pipeReq.Model).Msg("cascade escalating to strong model")
+
+	if h.collector != nil {
+		h.collector.Record(&cheap, attempt)
+	}
+	if h.store != nil {
+		if err := h.store.InsertRequest(&store.Request{
+			ID:             cheap.ID,
+			Timestamp:      start.UTC().Format(time.RFC3339),
+			Method:         r.Method,
+			Path:           r.URL.Path,
+			Format:         string(cheap.Format),
+			Model:          cheap.Model,
+			TokensIn:       int64(cheap.TokensIn),
+			TokensOut:      int64(tokensOut),
+			TokensCached:   int64(tokensCached),
+			CostUSD:        run.attemptCost,
+			LatencyMs:      time.Since(start).Milliseconds(),
+			StatusCode:     status,
+			RequestType:    "cascade_attempt",
+			ErrorMessage:   reason.Error(),
+			RequestBody:    h.storedRequestBody(body, &cheap),
+			ResponseBody:   h.storedBody(respBody),
+			Project:        pipeReq.Project,
+			KeyID:          pipeReq.KeyID,
+			ProviderKey:    cheap.ProviderKey,
+			RequestedModel: requestedModel(&cheap),
+			ParentID:       pipeReq.ID,
+			Cascade:        run.c.Name,
+			CascadeOutcome: "rejected by " + run.check,
+		}); err != nil {
+			logger.Error().Err(err).Msg("failed to persist cascade attempt")
+		}
+	}
+
+	return h.forward(ctx, pipeReq, logger)
+}
+
+// cheapAllowed returns why a cascade's cheap attempt may not be sent, or nil
+// if it may: the caller's key must allow the cheap model, and the policy
+// must not deny it.
+func (h *ProxyHandler) cheapAllowed(ctx context.Context, cheap *pipeline.Request) error {
+	if id := auth.IdentityFromContext(ctx); id != nil && !id.AllowsModel(cheap.Model) {
+		return fmt.Errorf("model %q is not allowed for this key", cheap.Model)
+	}
+	for _, mw := range h.chain.Middlewares() {
+		if policy, ok := mw.(*security.PolicyMiddleware); ok {
+			if err := policy.Check(cheap); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readCascadeBody reads and closes a cheap answer's body, which must be
+// complete before it can be checked.
+func (h *ProxyHandler) readCascadeBody(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	var reader io.Reader = resp.Body
+	if h.maxResponseSize > 0 {
+		reader = io.LimitReader(resp.Body, h.maxResponseSize+1)
+	}
+	b, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading upstream response: %w", err)
+	}
+	if h.maxResponseSize > 0 && int64(len(b)) > h.maxResponseSize {
+		return nil, fmt.Errorf("upstream response too large")
+	}
+	return b, nil
+}
+
+// finishCascade computes the cascade's net savings for a request whose
+// final answer produced tokensOut at costUSD, and records the decision. An
+// accepted answer saves what the strong model would have cost; an
+// escalation loses what the cheap attempt cost.
+func (h *ProxyHandler) finishCascade(run *cascadeRun, pipeReq *pipeline.Request, tokensOut int, costUSD float64) {
+	if run == nil || run.outcome == "" {
+		return
+	}
+	if run.outcome == cascadeAccepted {
+		if run.strongModel != pipeReq.Model {
+			run.savings = tokenizer.EstimateCost(run.strongModel, pipeReq.TokensIn, tokensOut) - costUSD
+		}
+	} else {
+		run.savings = -run.attemptCost
+	}
+	if h.collector != nil {
+		h.collector.RecordCascade(run.c.Name, run.outcome, run.check, run.savings)
+	}
+}
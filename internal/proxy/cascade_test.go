@@ -0,0 +1,274 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/allaspectsdev/tokenman/internal/auth"
+	"github.com/allaspectsdev/tokenman/internal/cascade"
+	"github.com/allaspectsdev/tokenman/internal/config"
+	"github.com/allaspectsdev/tokenman/internal/metrics"
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+	"github.com/allaspectsdev/tokenman/internal/router"
+	"github.com/allaspectsdev/tokenman/internal/security"
+	"github.com/allaspectsdev/tokenman/internal/store"
+	"github.com/allaspectsdev/tokenman/internal/tokenizer"
+)
+
+// cascadeUpstream answers each model with the given text.
+func cascadeUpstream(t *testing.T, answers map[string]string) *httptest.Server {
+	return mockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		text, _ := json.Marshal(answers[req.Model])
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"msg_cascade","type":"message","role":"assistant","content":[{"type":"text","text":` + string(text) + `}],"model":"` + req.Model + `","stop_reason":"end_turn","usage":{"input_tokens":10,"output_tokens":20}}`))
+	})
+}
+
+func serveCascade(t *testing.T, upstreamURL string, collector *metrics.Collector, st *store.Store) *httptest.Server {
+	t.Helper()
+	return newTestServer(cascadeHandler(t, upstreamURL, pipeline.NewChain(), collector, st))
+}
+
+func cascadeHandler(t *testing.T, upstreamURL string, chain *pipeline.Chain, collector *metrics.Collector, st *store.Store) *ProxyHandler {
+	t.Helper()
+	rtr := router.NewRouter(map[string]*router.ProviderConfig{
+		"anthropic": {
+			Name: "anthropic", BaseURL: upstreamURL, APIKey: "test-key",
+			Format: pipeline.FormatAnthropic, Enabled: true, Priority: 1, Timeout: 10 * time.Second,
+		},
+	}, nil, "anthropic", false)
+	handler := NewProxyHandler(chain, NewUpstreamClient(), zerolog.Nop(), collector, tokenizer.New(), st, 10<<20, 0, 0, nil, RetryConfig{}, rtr, 0, 0, false, 0)
+	check, _ := cascade.JSONCheck("")
+	handler.SetCascades(cascade.NewSet([]*cascade.Cascade{{
+		Name: "classify", Aliases: []string{"triage"},
+		CheapModel: "claude-haiku-4-5", StrongModel: "claude-sonnet-4",
+		Checks: []cascade.Check{check},
+	}}))
+	return handler
+}
+
+func postTriage(t *testing.T, url string) string {
+	t.Helper()
+	reqBody := `{"model":"triage","messages":[{"role":"user","content":"Classify this message as spam or ham."}],"max_tokens":100}`
+	resp, err := http.Post(url+"/v1/messages", "application/json", strings.NewReader(reqBody))
+	if err != nil {
+		t.Fatalf("POST /v1/messages failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d; want 200; body = %s", resp.StatusCode, body)
+	}
+	return string(body)
+}
+
+// metricValue returns the value of the series named by name in a
+// Prometheus text export, or 0 if it is missing.
+func metricValue(export, name string) float64 {
+	for _, line := range strings.Split(export, "\n") {
+		if v, ok := strings.CutPrefix(line, name+" "); ok {
+			f, _ := strconv.ParseFloat(v, 64)
+			return f
+		}
+	}
+	return 0
+}
+
+func TestCascade_AcceptsCheapAnswer(t *testing.T) {
+	upstream := cascadeUpstream(t, map[string]string{"claude-haiku-4-5": `{"label":"spam"}`})
+	defer upstream.Close()
+	collector := metrics.NewCollector()
+	ts := serveCascade(t, upstream.URL, collector, nil)
+	defer ts.Close()
+
+	if body := postTriage(t, ts.URL); !strings.Contains(body, `"model":"claude-haiku-4-5"`) {
+		t.Errorf("body = %s; want the cheap model's answer", body)
+	}
+	out := metricsText(collector)
+	if !strings.Contains(out, `tokenman_cascade_requests_total{cascade="classify",check="",outcome="accepted"} 1`) {
+		t.Errorf("metrics missing accepted cascade:\n%s", out)
+	}
+	if savings := metricValue(out, `tokenman_cascade_net_savings_usd{cascade="classify"}`); savings <= 0 {
+		t.Errorf("cascade net savings = %g; want positive savings for an accepted answer", savings)
+	}
+}
+
+func TestCascade_EscalatesAndLinksAttempt(t *testing.T) {
+	upstream := cascadeUpstream(t, map[string]string{
+		"claude-haiku-4-5": "probably spam?",
+		"claude-sonnet-4":  `{"label":"spam"}`,
+	})
+	defer upstream.Close()
+	st, err := store.Open(filepath.Join(t.TempDir(), "cascade.db"))
+	if err != nil {
+		t.Fatalf("store.Open: %v", err)
+	}
+	defer st.Close()
+	collector := metrics.NewCollector()
+	ts := serveCascade(t, upstream.URL, collector, st)
+	defer ts.Close()
+
+	if body := postTriage(t, ts.URL); !strings.Contains(body, `"model":"claude-sonnet-4"`) {
+		t.Errorf("body = %s; want the strong model's answer", body)
+	}
+	if !strings.Contains(metricsText(collector), `tokenman_cascade_requests_total{cascade="classify",check="json",outcome="escalated"} 1`) {
+		t.Error("metrics missing escalation by the json check")
+	}
+
+	rows, err := st.ListRequests(10, 0)
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("ListRequests: %d rows, err %v; want attempt and final request", len(rows), err)
+	}
+	var final *store.Request
+	for _, row := range rows {
+		if row.ParentID == "" {
+			final = row
+		}
+	}
+	if final == nil || final.Model != "claude-sonnet-4" || final.CascadeOutcome != "escalated" || final.CascadeSavingsUSD >= 0 {
+		t.Fatalf("final request = %+v; want escalated strong request with negative savings", final)
+	}
+	attempts, err := st.RequestAttempts(final.ID)
+	if err != nil || len(attempts) != 1 {
+		t.Fatalf("RequestAttempts = %v, %v; want one cheap attempt", attempts, err)
+	}
+	attempt, err := st.GetRequest(attempts[0])
+	if err != nil {
+		t.Fatalf("GetRequest: %v", err)
+	}
+	if attempt.Model != "claude-haiku-4-5" || attempt.RequestType != "cascade_attempt" || attempt.CascadeOutcome != "rejected by json" {
+		t.Errorf("attempt = %q, %q, %q; want rejected cheap attempt", attempt.Model, attempt.RequestType, attempt.CascadeOutcome)
+	}
+}
+
+func TestCascade_ChargesRejectedAttempt(t *testing.T) {
+	upstream := cascadeUpstream(t, map[string]string{
+		"claude-haiku-4-5": "probably spam?",
+		"claude-sonnet-4":  `{"label":"spam"}`,
+	})
+	defer upstream.Close()
+	st, err := store.Open(filepath.Join(t.TempDir(), "cascade.db"))
+	if err != nil {
+		t.Fatalf("store.Open: %v", err)
+	}
+	defer st.Close()
+	budgets := store.NewBudgetAdapter(st)
+	chain := pipeline.NewChain(security.NewBudgetMiddleware(budgets, 0, 0, 100, nil, 0, nil, true))
+	ts := newTestServer(cascadeHandler(t, upstream.URL, chain, nil, st))
+	defer ts.Close()
+
+	postTriage(t, ts.URL)
+
+	rows, err := st.ListRequests(10, 0)
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("ListRequests: %d rows, err %v; want attempt and final request", len(rows), err)
+	}
+	var want float64
+	for _, row := range rows {
+		want += row.CostUSD
+	}
+	y, m, _ := time.Now().UTC().Date()
+	spent, _, err := budgets.GetBudget(security.BudgetScopeGlobal, "monthly", time.Date(y, m, 1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339))
+	if err != nil {
+		t.Fatalf("GetBudget: %v", err)
+	}
+	if math.Abs(spent-want) > 1e-9 {
+		t.Errorf("monthly spending = %g; want %g for the attempt and the final request", spent, want)
+	}
+}
+
+func TestCascade_CheapAttemptRunsWithinRequestSlot(t *testing.T) {
+	upstream := cascadeUpstream(t, map[string]string{"claude-haiku-4-5": `{"label":"spam"}`})
+	defer upstream.Close()
+	chain := pipeline.NewChain(security.NewRateLimitMiddleware(config.RateLimitConfig{
+		Enabled:              true,
+		DefaultRate:          1000,
+		DefaultBurst:         1000,
+		DefaultMaxConcurrent: 1,
+		QueueSize:            10,
+		MaxWaitSeconds:       10,
+	}))
+	ts := newTestServer(cascadeHandler(t, upstream.URL, chain, nil, nil))
+	defer ts.Close()
+
+	start := time.Now()
+	body := postTriage(t, ts.URL)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("request took %s; the cheap attempt queued behind its own request", elapsed)
+	}
+	if !strings.Contains(body, `"model":"claude-haiku-4-5"`) {
+		t.Errorf("body = %s; want the cheap model's answer", body)
+	}
+}
+
+func TestCascade_SkipsCheapModelTheCallerMayNotUse(t *testing.T) {
+	var models []string
+	upstream := mockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		models = append(models, req.Model)
+		_, _ = w.Write([]byte(`{"id":"msg_cascade","type":"message","role":"assistant","content":[{"type":"text","text":"{}"}],"model":"` + req.Model + `","stop_reason":"end_turn","usage":{"input_tokens":10,"output_tokens":20}}`))
+	})
+	defer upstream.Close()
+	reqBody := `{"model":"triage","messages":[{"role":"user","content":"Classify this message as spam or ham."}],"max_tokens":100}`
+
+	tests := []struct {
+		name  string
+		chain *pipeline.Chain
+		id    *auth.Identity
+	}{
+		{
+			name: "policy",
+			chain: pipeline.NewChain(security.NewPolicyMiddleware(config.PolicyConfig{
+				Enabled: true,
+				Rules: []config.PolicyRule{
+					{Name: "no-haiku", Match: config.PolicyMatch{Models: []string{"claude-haiku-*"}}, Action: "deny"},
+				},
+			}, nil)),
+		},
+		{
+			name:  "key allow-list",
+			chain: pipeline.NewChain(),
+			id:    &auth.Identity{KeyID: "vk_test", Scopes: []string{auth.ScopeProxy}, AllowedModels: []string{"claude-sonnet-*"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			models = nil
+			collector := metrics.NewCollector()
+			handler := cascadeHandler(t, upstream.URL, tt.chain, collector, nil)
+			req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(reqBody))
+			if tt.id != nil {
+				req = req.WithContext(auth.WithIdentity(req.Context(), tt.id))
+			}
+			w := httptest.NewRecorder()
+			handler.HandleRequest(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d; want 200; body = %s", w.Code, w.Body.String())
+			}
+			if len(models) != 1 || models[0] != "claude-sonnet-4" {
+				t.Errorf("upstream models = %v; want only the strong model", models)
+			}
+			if out := metricsText(collector); strings.Contains(out, "tokenman_cascade_requests_total{") {
+				t.Errorf("skipped cascade recorded a decision:\n%s", out)
+			}
+		})
+	}
+}
@@ -1,8 +1,11 @@
 package proxy
 
 import (
+	"fmt"
 	"sync"
 	"time"
+
+	"github.com/allaspectsdev/tokenman/internal/alert"
 )
 
 // CBState represents the state of a circuit breaker.
@@ -32,6 +35,9 @@ type CircuitBreaker struct {
 	consecutiveFailures int
 	halfOpenSuccesses   int
 	lastFailureTime     time.Time
+
+	// onOpen, if set, is called (outside the lock) each time the circuit trips.
+	onOpen func()
 }
 
 // NewCircuitBreaker creates a circuit breaker with the given parameters.
@@ -88,19 +94,27 @@ func (cb *CircuitBreaker) RecordSuccess() {
 // directly back to Open.
 func (cb *CircuitBreaker) RecordFailure() {
 	cb.mu.Lock()
-	defer cb.mu.Unlock()
 
 	cb.consecutiveFailures++
 	cb.lastFailureTime = time.Now()
 
+	tripped := false
 	switch cb.state {
 	case CBClosed:
 		if cb.consecutiveFailures >= cb.failureThreshold {
 			cb.state = CBOpen
+			tripped = true
 		}
 	case CBHalfOpen:
 		cb.state = CBOpen
 		cb.halfOpenSuccesses = 0
+		tripped = true
+	}
+	onOpen := cb.onOpen
+	cb.mu.Unlock()
+
+	if tripped && onOpen != nil {
+		onOpen()
 	}
 }
 
@@ -120,6 +134,7 @@ type CircuitBreakerRegistry struct {
 	failureThreshold int
 	resetTimeout     time.Duration
 	halfOpenMax      int
+	notifier         alert.Notifier
 }
 
 // NewCircuitBreakerRegistry creates a new registry with the given default parameters.
@@ -140,7 +155,41 @@ func (r *CircuitBreakerRegistry) Get(provider string) *CircuitBreaker {
 	cb, ok := r.breakers[provider]
 	if !ok {
 		cb = NewCircuitBreaker(r.failureThreshold, r.resetTimeout, r.halfOpenMax)
+		cb.onOpen = func() { r.notifyOpen(provider) }
 		r.breakers[provider] = cb
 	}
 	return cb
 }
+
+// ForKey returns the circuit breaker for one pooled API key of a provider,
+// so a key that keeps failing is skipped while its siblings keep serving.
+func (r *CircuitBreakerRegistry) ForKey(provider, keyID string) *CircuitBreaker {
+	return r.Get(provider + "/" + keyID)
+}
+
+// SetNotifier sets where an alert is sent each time a provider's circuit trips.
+func (r *CircuitBreakerRegistry) SetNotifier(n alert.Notifier) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notifier = n
+}
+
+// notifyOpen raises an alert for a provider whose circuit just opened.
+func (r *CircuitBreakerRegistry) notifyOpen(provider string) {
+	r.mu.Lock()
+	n := r.notifier
+	r.mu.Unlock()
+	if n == nil {
+		return
+	}
+	n.Notify(alert.Alert{
+		Kind:     alert.KindCircuitOpen,
+		Severity: alert.SeverityCritical,
+		Title:    fmt.Sprintf("Circuit open for %s", provider),
+		Message:  fmt.Sprintf("Provider %s is failing; requests will skip it for %s.", provider, r.resetTimeout),
+		Fields: map[string]interface{}{
+			"provider":      provider,
+			"reset_timeout": r.resetTimeout.String(),
+		},
+	})
+}
@@ -3,6 +3,8 @@ package proxy
 import (
 	"testing"
 	"time"
+
+	"github.com/allaspectsdev/tokenman/internal/alert"
 )
 
 func TestCB_ClosedToOpen(t *testing.T) {
@@ -128,3 +130,34 @@ func TestCBRegistry_LazyCreation(t *testing.T) {
 		t.Fatalf("new breaker should be closed, got %d", cb1.State())
 	}
 }
+
+type notifierFunc func(alert.Alert)
+
+func (f notifierFunc) Notify(a alert.Alert) { f(a) }
+
+func TestCBRegistry_NotifiesOnTrip(t *testing.T) {
+	reg := NewCircuitBreakerRegistry(2, 50*time.Millisecond, 1)
+	var got []alert.Alert
+	reg.SetNotifier(notifierFunc(func(a alert.Alert) { got = append(got, a) }))
+
+	cb := reg.Get("openai")
+	cb.RecordFailure()
+	if len(got) != 0 {
+		t.Fatalf("alert before threshold: %+v", got)
+	}
+	cb.RecordFailure() // trips
+	cb.RecordFailure() // already open, no new alert
+	if len(got) != 1 {
+		t.Fatalf("got %d alerts after trip, want 1", len(got))
+	}
+	if got[0].Kind != alert.KindCircuitOpen || got[0].Fields["provider"] != "openai" {
+		t.Errorf("alert = %+v", got[0])
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	cb.Allow()         // half-open
+	cb.RecordFailure() // re-trips
+	if len(got) != 2 {
+		t.Errorf("got %d alerts after half-open failure, want 2", len(got))
+	}
+}
@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/allaspectsdev/tokenman/internal/compress"
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+	"github.com/allaspectsdev/tokenman/internal/tokenizer"
+)
+
+// dryRunMiddlewares names the compression middleware a token count runs to
+// report what it would save. They only rewrite the request; the rest of the
+// chain records state (cache entries, dedup fingerprints, heartbeat windows,
+// budget reservations) that a count must not touch.
+var dryRunMiddlewares = map[string]bool{"rules": true, "history": true}
+
+// imageBlockTypes lists the content block types that carry an image in the
+// Anthropic, OpenAI, and Responses formats.
+var imageBlockTypes = map[string]bool{"image": true, "image_url": true, "input_image": true}
+
+// countResponse is the body of the token counting endpoints. InputTokens
+// matches Anthropic's count_tokens response.
+type countResponse struct {
+	InputTokens int                 `json:"input_tokens"`
+	Model       string              `json:"model"`
+	Breakdown   tokenizer.Breakdown `json:"breakdown"`
+	Compression countCompression    `json:"compression"`
+}
+
+// countCompression reports what the compression middleware would do to the
+// request if it were sent.
+type countCompression struct {
+	InputTokens int         `json:"input_tokens"`
+	TokensSaved int         `json:"tokens_saved"`
+	SavingsUSD  float64     `json:"savings_usd"`
+	Applied     []countStep `json:"applied"`
+}
+
+// countStep is one middleware that would rewrite the request.
+type countStep struct {
+	Middleware  string `json:"middleware"`
+	TokensSaved int    `json:"tokens_saved"`
+}
+
+// HandleCountTokens counts a request's input tokens locally, without
+// forwarding it, and reports the savings the compression middleware would
+// apply. /v1/messages/count_tokens takes an Anthropic Messages body;
+// /v1/tokenman/count takes the body format named by the "format" query
+// parameter (openai, the default, anthropic, or openai_responses).
+func (h *ProxyHandler) HandleCountTokens(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := h.logger.With().Str("path", r.URL.Path).Logger()
+
+	format := pipeline.FormatAnthropic
+	if r.URL.Path != "/v1/messages/count_tokens" {
+		format = pipeline.APIFormat(r.URL.Query().Get("format"))
+		if format == "" {
+			format = pipeline.FormatOpenAI
+		}
+	}
+	switch format {
+	case pipeline.FormatAnthropic, pipeline.FormatOpenAI, pipeline.FormatOpenAIResponses:
+	default:
+		writeJSONError(w, http.StatusBadRequest, "format must be anthropic, openai, or openai_responses")
+		return
+	}
+
+	if h.maxBodySize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxBodySize)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, "failed to read request body")
+		return
+	}
+	defer r.Body.Close()
+
+	req, err := parseRequest(format, body, r.URL.Path)
+	if err != nil {
+		logger.Debug().Err(err).Msg("failed to parse count request")
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if _, model, resolveErr := h.router.ResolveModel(req.Model); resolveErr == nil {
+		req.Model = model
+	}
+
+	before := h.countTokens(req)
+	resp := countResponse{
+		InputTokens: before.Total,
+		Model:       req.Model,
+		Breakdown:   before,
+		Compression: countCompression{InputTokens: before.Total, Applied: []countStep{}},
+	}
+
+	// Run the compression middleware on the request and keep the ones that
+	// changed it.
+	for _, mw := range h.chain.Middlewares() {
+		if !mw.Enabled() || !dryRunMiddlewares[mw.Name()] {
+			continue
+		}
+		prevBody := rebuildRequestBody(req)
+		prevTokens := h.countTokens(req).Total
+		out, mwErr := mw.ProcessRequest(ctx, req)
+		if mwErr != nil || out == nil {
+			logger.Debug().Err(mwErr).Str("middleware", mw.Name()).Msg("dry run failed")
+			continue
+		}
+		req = out
+		if bytes.Equal(prevBody, rebuildRequestBody(req)) {
+			continue
+		}
+		tokens := h.countTokens(req).Total
+		resp.Compression.Applied = append(resp.Compression.Applied, countStep{
+			Middleware:  mw.Name(),
+			TokensSaved: prevTokens - tokens,
+		})
+		resp.Compression.InputTokens = tokens
+	}
+	resp.Compression.TokensSaved = before.Total - resp.Compression.InputTokens
+	resp.Compression.SavingsUSD = tokenizer.EstimateCost(req.Model, resp.Compression.TokensSaved, 0)
+
+	data, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// countTokens counts req's input tokens locally: the messages with their
+// images, the system prompt where the format sends it outside the messages,
+// and the tool definitions.
+func (h *ProxyHandler) countTokens(req *pipeline.Request) tokenizer.Breakdown {
+	if h.tokenizer == nil {
+		return tokenizer.Breakdown{}
+	}
+	msgs := make([]tokenizer.Message, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		msgs = append(msgs, tokenizer.Message{
+			Role:    m.Role,
+			Content: compress.ExtractText(m.Content),
+			Name:    m.Name,
+			Images:  countImages(m.Content),
+		})
+	}
+	// OpenAI system prompts are already among the messages.
+	system := req.System
+	if req.Format == pipeline.FormatOpenAI {
+		system = ""
+	}
+	tools := make([]tokenizer.Tool, 0, len(req.Tools))
+	for _, t := range req.Tools {
+		// OpenAI Chat Completions tools keep everything under "function".
+		schema := t.InputSchema
+		if schema == nil {
+			schema = t.Function
+		}
+		var schemaJSON []byte
+		if schema != nil {
+			schemaJSON, _ = json.Marshal(schema)
+		}
+		tools = append(tools, tokenizer.Tool{Name: t.Name, Description: t.Description, Schema: string(schemaJSON)})
+	}
+	return h.tokenizer.CountRequest(req.Model, system, msgs, tools)
+}
+
+// countImages returns the number of image blocks in message content.
+func countImages(content interface{}) int {
+	n := 0
+	switch blocks := content.(type) {
+	case []pipeline.ContentBlock:
+		for _, b := range blocks {
+			if imageBlockTypes[b.Type] {
+				n++
+			}
+		}
+	case []interface{}:
+		for _, item := range blocks {
+			if b, ok := item.(map[string]interface{}); ok {
+				if t, _ := b["type"].(string); imageBlockTypes[t] {
+					n++
+				}
+			}
+		}
+	}
+	return n
+}
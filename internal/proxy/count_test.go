@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/allaspectsdev/tokenman/internal/compress"
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+	"github.com/allaspectsdev/tokenman/internal/router"
+	"github.com/allaspectsdev/tokenman/internal/tokenizer"
+)
+
+func TestHandleCountTokens(t *testing.T) {
+	up := mockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("count request forwarded upstream to %s", r.URL.Path)
+	})
+	defer up.Close()
+	rtr := router.NewRouter(map[string]*router.ProviderConfig{
+		"anthropic": {
+			Name: "anthropic", BaseURL: up.URL, APIKey: "k", Format: pipeline.FormatAnthropic,
+			Models: []string{"claude-sonnet-4"}, Enabled: true, Priority: 1,
+		},
+	}, nil, "anthropic", false)
+	chain := pipeline.NewChain(
+		compress.NewRulesMiddleware(compress.RulesConfig{CollapseWhitespace: true}),
+		compress.NewHistoryMiddleware(2, true),
+	)
+	handler := NewProxyHandler(chain, NewUpstreamClient(), zerolog.Nop(), nil, tokenizer.New(), nil, 10<<20, 0, 0, nil, RetryConfig{}, rtr, 0, 0, false, 0)
+	ts := httptest.NewServer(NewServer(handler, ":0", 0, 0, 0, false, nil).Router())
+	defer ts.Close()
+
+	body := post(t, ts.URL+"/v1/messages/count_tokens", `{
+		"model": "claude-sonnet-4",
+		"system": "Be brief.",
+		"messages": [
+			{"role": "user", "content": "First question"},
+			{"role": "assistant", "content": "First answer"},
+			{"role": "user", "content": [{"type": "text", "text": "What is this?"}, {"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "iVBORw0KGgo="}}]}
+		],
+		"tools": [{"name": "lookup", "description": "Look something up", "input_schema": {"type": "object"}}]
+	}`)
+	var resp countResponse
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("unmarshal %s: %v", body, err)
+	}
+	if resp.Model != "claude-sonnet-4" || resp.InputTokens != resp.Breakdown.Total || resp.Breakdown.Images != tokenizer.ImageTokens {
+		t.Errorf("count = %s; want the request counted with one image", body)
+	}
+	if len(resp.Compression.Applied) != 1 || resp.Compression.Applied[0].Middleware != "history" {
+		t.Errorf("applied = %+v; want only history, which folds the first turn", resp.Compression.Applied)
+	}
+	if resp.Compression.TokensSaved != resp.InputTokens-resp.Compression.InputTokens {
+		t.Errorf("compression = %+v; tokens saved should be the difference", resp.Compression)
+	}
+
+	// OpenAI bodies use the generic endpoint; Gemini has its own countTokens.
+	body = post(t, ts.URL+"/v1/tokenman/count", `{"model":"claude-sonnet-4","messages":[{"role":"user","content":"Hi"}]}`)
+	if !strings.Contains(body, `"input_tokens"`) || !strings.Contains(body, `"applied":[]`) {
+		t.Errorf("openai count = %s", body)
+	}
+	r, err := http.Post(ts.URL+"/v1/tokenman/count?format=gemini", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	_, _ = io.Copy(io.Discard, r.Body)
+	r.Body.Close()
+	if r.StatusCode != http.StatusBadRequest {
+		t.Errorf("format=gemini status = %d; want 400", r.StatusCode)
+	}
+
+	if got := DetectFormat(httptest.NewRequest(http.MethodPost, "/v1/messages/count_tokens", nil)); got != pipeline.FormatUnknown {
+		t.Errorf("DetectFormat(count_tokens) = %s; want it kept apart from Messages calls", got)
+	}
+}
@@ -0,0 +1,455 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/allaspectsdev/tokenman/internal/auth"
+	"github.com/allaspectsdev/tokenman/internal/cache"
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+	"github.com/allaspectsdev/tokenman/internal/store"
+	"github.com/allaspectsdev/tokenman/internal/tokenizer"
+)
+
+// SetEmbeddingCache enables per-input caching of /v1/embeddings results.
+func (h *ProxyHandler) SetEmbeddingCache(c *cache.EmbeddingCache) {
+	h.embeddings = c
+}
+
+// embeddingsRequest holds the fields of an OpenAI embeddings request that
+// decide which vector each input gets.
+type embeddingsRequest struct {
+	Model          string          `json:"model"`
+	Input          json.RawMessage `json:"input"`
+	EncodingFormat string          `json:"encoding_format"`
+	Dimensions     int             `json:"dimensions"`
+}
+
+// embeddingsResponse is an OpenAI embeddings response. Embeddings are kept
+// as raw JSON so the vectors reach the client byte for byte.
+type embeddingsResponse struct {
+	Object string           `json:"object"`
+	Data   []embeddingDatum `json:"data"`
+	Model  string           `json:"model"`
+	Usage  embeddingsUsage  `json:"usage"`
+}
+
+type embeddingDatum struct {
+	Object    string          `json:"object"`
+	Index     int             `json:"index"`
+	Embedding json.RawMessage `json:"embedding"`
+}
+
+type embeddingsUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// HandleEmbeddings serves OpenAI's /v1/embeddings. Each input is looked up
+// in the embedding cache by model, dimensions, encoding format, and text;
+// only the inputs that miss are sent upstream, in one batched call, and the
+// response is reassembled in input order. That call is admitted and charged
+// by the budget and rate limit middleware. The usage reported to the client
+// counts the tokens sent upstream, and the request is recorded with the
+// cost of those tokens and the savings from the cached ones.
+func (h *ProxyHandler) HandleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	ctx := r.Context()
+	requestID := uuid.New().String()
+
+	project := r.Header.Get("X-Tokenman-Project")
+	if id := auth.IdentityFromContext(ctx); id != nil && id.Project != "" {
+		project = id.Project
+	}
+	if project == "" {
+		project = "default"
+	}
+
+	if h.collector != nil {
+		h.collector.IncrementActive()
+		defer h.collector.DecrementActive()
+	}
+
+	logger := h.logger.With().
+		Str("request_id", requestID).
+		Str("method", r.Method).
+		Str("path", r.URL.Path).
+		Logger()
+
+	if h.maxBodySize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxBodySize)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, "failed to read request body")
+		return
+	}
+	defer r.Body.Close()
+
+	var req embeddingsRequest
+	if err := json.Unmarshal(body, &req); err != nil || req.Model == "" {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	inputs, err := splitEmbeddingInputs(req.Input)
+	if err != nil {
+		logger.Debug().Err(err).Msg("failed to parse embeddings input")
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	pipeReq := &pipeline.Request{
+		ID:             requestID,
+		Format:         pipeline.FormatOpenAIEmbeddings,
+		Model:          req.Model,
+		RequestedModel: req.Model,
+		RawBody:        body,
+		Headers:        make(map[string]string),
+		Metadata:       make(map[string]interface{}),
+		Project:        project,
+		ReceivedAt:     startTime,
+	}
+	if resolved, model, resolveErr := h.router.ResolveModel(req.Model); resolveErr == nil {
+		pipeReq.Model = model
+		pipeReq.Metadata["provider"] = resolved.Name
+	}
+	if id := auth.IdentityFromContext(ctx); id != nil {
+		pipeReq.KeyID = id.KeyID
+		pipeReq.KeyOwner = id.Owner
+		if !id.AllowsModel(pipeReq.Model) {
+			logger.Warn().Str("model", pipeReq.Model).Msg("model not allowed for key")
+			writeJSONError(w, http.StatusForbidden, fmt.Sprintf("model %q is not allowed for this key", pipeReq.Model))
+			return
+		}
+	}
+	for _, key := range []string{"X-Request-Id", "User-Agent"} {
+		if val := r.Header.Get(key); val != "" {
+			pipeReq.Headers[key] = val
+		}
+	}
+	logger = logger.With().Str("model", pipeReq.Model).Int("inputs", len(inputs)).Logger()
+
+	// Look every input up in the cache and collect the ones that missed.
+	vectors := make([]json.RawMessage, len(inputs))
+	keys := make([]string, len(inputs))
+	tokens := make([]int, len(inputs))
+	var missing []int
+	cachedTokens := 0
+	for i, in := range inputs {
+		keys[i] = cache.EmbeddingKey(pipeReq.Model, req.Dimensions, req.EncodingFormat, in)
+		if h.embeddings != nil {
+			if entry := h.embeddings.Get(keys[i]); entry != nil {
+				vectors[i] = entry.Embedding
+				tokens[i] = entry.Tokens
+				cachedTokens += entry.Tokens
+				continue
+			}
+		}
+		tokens[i] = h.countEmbeddingTokens(pipeReq.Model, in)
+		missing = append(missing, i)
+	}
+
+	model := pipeReq.Model
+	upstreamTokens := 0
+	spend := h.spendChain()
+	var respHeader http.Header
+	if len(missing) > 0 {
+		batch := make([]json.RawMessage, len(missing))
+		for j, i := range missing {
+			batch[j] = inputs[i]
+			upstreamTokens += tokens[i]
+		}
+		if pipeReq.RawBody, err = embeddingsBody(body, pipeReq.Model, batch); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		// The uncached inputs are admitted against budgets and rate limits
+		// like any other request, and settled at their actual cost below.
+		pipeReq.TokensIn = upstreamTokens
+		defer spend.Release(context.WithoutCancel(ctx), pipeReq)
+		if _, _, err := spend.ProcessRequest(ctx, pipeReq); err != nil {
+			h.writeChainError(w, err, logger)
+			return
+		}
+
+		upstreamResp, err := h.forward(ctx, pipeReq, logger)
+		if err != nil {
+			logger.Error().Err(err).Msg("upstream request failed")
+			if h.collector != nil {
+				h.collector.RecordError("upstream", "", http.StatusBadGateway)
+			}
+			writeJSONError(w, http.StatusBadGateway, "upstream request failed")
+			return
+		}
+		defer upstreamResp.Body.Close()
+		respHeader = upstreamResp.Header
+
+		var respReader io.Reader = upstreamResp.Body
+		if h.maxResponseSize > 0 {
+			respReader = io.LimitReader(upstreamResp.Body, h.maxResponseSize+1)
+		}
+		respBody, err := io.ReadAll(respReader)
+		if err != nil || (h.maxResponseSize > 0 && int64(len(respBody)) > h.maxResponseSize) {
+			logger.Error().Err(err).Msg("failed to read upstream response")
+			writeJSONError(w, http.StatusBadGateway, "failed to read upstream response")
+			return
+		}
+
+		// Pass upstream errors through unchanged.
+		if upstreamResp.StatusCode >= 400 {
+			logger.Warn().Int("upstream_status", upstreamResp.StatusCode).Msg("upstream returned error")
+			if h.collector != nil {
+				h.collector.RecordError("upstream", "", upstreamResp.StatusCode)
+			}
+			h.recordEmbeddings(r, pipeReq, startTime, &store.Request{
+				TokensIn:     int64(upstreamTokens),
+				StatusCode:   upstreamResp.StatusCode,
+				RequestType:  "upstream_error",
+				ResponseBody: h.storedBody(respBody),
+			})
+			if ra := upstreamResp.Header.Get("Retry-After"); ra != "" {
+				w.Header().Set("Retry-After", ra)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(upstreamResp.StatusCode)
+			_, _ = w.Write(respBody)
+			return
+		}
+
+		var upstream embeddingsResponse
+		if err := json.Unmarshal(respBody, &upstream); err != nil || len(upstream.Data) != len(missing) {
+			logger.Error().Err(err).Int("returned", len(upstream.Data)).Msg("unexpected upstream embeddings response")
+			writeJSONError(w, http.StatusBadGateway, "invalid upstream embeddings response")
+			return
+		}
+		if upstream.Model != "" {
+			model = upstream.Model
+		}
+		if upstream.Usage.PromptTokens > 0 {
+			splitUsage(tokens, missing, upstreamTokens, upstream.Usage.PromptTokens)
+			upstreamTokens = upstream.Usage.PromptTokens
+		}
+		for _, d := range upstream.Data {
+			if d.Index < 0 || d.Index >= len(missing) {
+				logger.Error().Int("index", d.Index).Msg("upstream embedding index out of range")
+				writeJSONError(w, http.StatusBadGateway, "invalid upstream embeddings response")
+				return
+			}
+			i := missing[d.Index]
+			vectors[i] = d.Embedding
+			if h.embeddings != nil {
+				h.embeddings.Put(keys[i], pipeReq.Model, d.Embedding, tokens[i])
+			}
+		}
+		for _, i := range missing {
+			if vectors[i] == nil {
+				writeJSONError(w, http.StatusBadGateway, "invalid upstream embeddings response")
+				return
+			}
+		}
+	}
+
+	out := embeddingsResponse{
+		Object: "list",
+		Data:   make([]embeddingDatum, len(inputs)),
+		Model:  model,
+		Usage:  embeddingsUsage{PromptTokens: upstreamTokens, TotalTokens: upstreamTokens},
+	}
+	for i, v := range vectors {
+		out.Data[i] = embeddingDatum{Object: "embedding", Index: i, Embedding: v}
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to encode response")
+		return
+	}
+
+	cacheStatus := "PARTIAL"
+	switch len(missing) {
+	case 0:
+		cacheStatus = "HIT"
+	case len(inputs):
+		cacheStatus = "MISS"
+	}
+	pipeResp := &pipeline.Response{
+		RequestID:   requestID,
+		StatusCode:  http.StatusOK,
+		Model:       model,
+		TokensSaved: cachedTokens,
+		CostUSD:     tokenizer.EstimateCost(pipeReq.Model, upstreamTokens, 0),
+		SavingsUSD:  tokenizer.EstimateCost(pipeReq.Model, cachedTokens, 0),
+		Latency:     time.Since(startTime),
+		CacheHit:    len(missing) == 0,
+		RequestType: "embedding",
+	}
+	if len(missing) > 0 {
+		pipeReq.TokensIn = upstreamTokens
+		if _, err := spend.ProcessResponse(ctx, pipeReq, pipeResp); err != nil {
+			logger.Error().Err(err).Msg("failed to charge embeddings request")
+		}
+	}
+	pipeReq.TokensIn = upstreamTokens + cachedTokens
+	if h.collector != nil {
+		h.collector.Record(pipeReq, pipeResp)
+		h.collector.ObserveLatency("", pipeReq.Model, false, pipeResp.Latency.Seconds())
+	}
+	h.recordEmbeddings(r, pipeReq, startTime, &store.Request{
+		TokensIn:    int64(pipeReq.TokensIn),
+		TokensSaved: int64(cachedTokens),
+		CostUSD:     pipeResp.CostUSD,
+		SavingsUSD:  pipeResp.SavingsUSD,
+		StatusCode:  http.StatusOK,
+		CacheHit:    pipeResp.CacheHit,
+		RequestType: "embedding",
+	})
+
+	logger.Info().
+		Int("cached", len(inputs)-len(missing)).
+		Int("tokens_saved", cachedTokens).
+		Dur("latency", pipeResp.Latency).
+		Msg("embeddings request completed")
+
+	for _, key := range []string{"X-Request-Id", "Request-Id"} {
+		if val := respHeader.Get(key); val != "" {
+			w.Header().Set(key, val)
+		}
+	}
+	w.Header().Set("X-Tokenman-Cache", cacheStatus)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// recordEmbeddings persists an embeddings request, filling rec's identifying
+// fields from r and pipeReq.
+func (h *ProxyHandler) recordEmbeddings(r *http.Request, pipeReq *pipeline.Request, startTime time.Time, rec *store.Request) {
+	if h.store == nil {
+		return
+	}
+	rec.ID = pipeReq.ID
+	rec.Timestamp = startTime.UTC().Format(time.RFC3339)
+	rec.Method = r.Method
+	rec.Path = r.URL.Path
+	rec.Format = string(pipeReq.Format)
+	rec.Model = pipeReq.Model
+	rec.LatencyMs = time.Since(startTime).Milliseconds()
+	rec.Project = pipeReq.Project
+	rec.KeyID = pipeReq.KeyID
+	rec.ProviderKey = pipeReq.ProviderKey
+	rec.RequestedModel = requestedModel(pipeReq)
+	if err := h.store.InsertRequest(rec); err != nil {
+		h.logger.Error().Err(err).Str("request_id", pipeReq.ID).Msg("failed to persist request record")
+	}
+}
+
+// splitEmbeddingInputs returns the inputs of an embeddings request as raw
+// JSON: a string, an array of strings, a token array, or an array of token
+// arrays. A lone string or token array is a single input.
+func splitEmbeddingInputs(raw json.RawMessage) ([]json.RawMessage, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return nil, errors.New("input is required")
+	}
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		return []json.RawMessage{raw}, nil
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, fmt.Errorf("input must be a string or an array: %w", err)
+	}
+	if len(items) == 0 {
+		return nil, errors.New("input must not be empty")
+	}
+	if first := bytes.TrimSpace(items[0]); len(first) > 0 && first[0] != '"' && first[0] != '[' {
+		return []json.RawMessage{raw}, nil
+	}
+	return items, nil
+}
+
+// splitUsage replaces the local token counts of the missing inputs with
+// their share of the billed prompt tokens, so cached entries record what the
+// provider charged. localTotal is the sum of the local counts; when it is 0
+// the billed tokens are split evenly.
+func splitUsage(tokens []int, missing []int, localTotal, billed int) {
+	left := billed
+	for j, i := range missing {
+		share := billed / len(missing)
+		if localTotal > 0 {
+			share = billed * tokens[i] / localTotal
+		}
+		if j == len(missing)-1 {
+			share = left
+		}
+		tokens[i] = share
+		left -= share
+	}
+}
+
+// countEmbeddingTokens counts the tokens of one embeddings input. A token
+// array is already tokenized.
+func (h *ProxyHandler) countEmbeddingTokens(model string, input json.RawMessage) int {
+	var text string
+	if err := json.Unmarshal(input, &text); err != nil {
+		var ids []int
+		if json.Unmarshal(input, &ids) == nil {
+			return len(ids)
+		}
+		return 0
+	}
+	if h.tokenizer == nil {
+		return 0
+	}
+	return h.tokenizer.CountTokens(model, text)
+}
+
+// embeddingsBody returns the client's embeddings body with its model and
+// input replaced, keeping the other fields (dimensions, encoding_format,
+// user) as sent.
+func embeddingsBody(original []byte, model string, input []json.RawMessage) ([]byte, error) {
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(original, &body); err != nil {
+		return nil, err
+	}
+	var err error
+	if body["model"], err = json.Marshal(model); err != nil {
+		return nil, err
+	}
+	if body["input"], err = json.Marshal(input); err != nil {
+		return nil, err
+	}
+	return json.Marshal(body)
+}
+
+// rebuildEmbeddingsBody sets the model of an embeddings body. The input is
+// chosen by HandleEmbeddings and left as it is.
+func rebuildEmbeddingsBody(req *pipeline.Request) []byte {
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(req.RawBody, &body); err != nil {
+		return req.RawBody
+	}
+	model, _ := json.Marshal(req.Model)
+	body["model"] = model
+	data, err := json.Marshal(body)
+	if err != nil {
+		return req.RawBody
+	}
+	return data
+}
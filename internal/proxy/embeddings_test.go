@@ -0,0 +1,212 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/allaspectsdev/tokenman/internal/cache"
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+	"github.com/allaspectsdev/tokenman/internal/router"
+	"github.com/allaspectsdev/tokenman/internal/security"
+	"github.com/allaspectsdev/tokenman/internal/store"
+	"github.com/allaspectsdev/tokenman/internal/tokenizer"
+)
+
+func serveEmbeddings(t *testing.T, upstreamURL string, chain *pipeline.Chain, st *store.Store) *httptest.Server {
+	t.Helper()
+	rtr := router.NewRouter(map[string]*router.ProviderConfig{
+		"openai": {
+			Name: "openai", BaseURL: upstreamURL, APIKey: "openai-key", Format: pipeline.FormatOpenAI,
+			Models: []string{"text-embedding-3-small"}, Enabled: true, Priority: 1,
+		},
+	}, nil, "openai", false)
+	handler := NewProxyHandler(chain, NewUpstreamClient(), zerolog.Nop(), nil, tokenizer.New(), st, 10<<20, 0, 0, nil, RetryConfig{}, rtr, 0, 0, false, 0)
+	ec, err := cache.NewEmbeddingCache(store.NewEmbeddingAdapter(st), 3600, 100)
+	if err != nil {
+		t.Fatalf("NewEmbeddingCache: %v", err)
+	}
+	handler.SetEmbeddingCache(ec)
+	return httptest.NewServer(NewServer(handler, ":0", 0, 0, 0, false, nil).Router())
+}
+
+func TestEmbeddings_CachesPerInput(t *testing.T) {
+	// The upstream embeds each text as [len(text)], so vectors are easy to
+	// check, and records the inputs it was sent.
+	var sent [][]string
+	up := mockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+			User  string   `json:"user"`
+		}
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path != "/v1/embeddings" || r.Header.Get("Authorization") != "Bearer openai-key" {
+			t.Errorf("upstream request = %s (auth %q); want an authenticated embeddings call", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		if err := json.Unmarshal(body, &req); err != nil || req.User != "indexer" {
+			t.Errorf("upstream body = %s; want string inputs and the other fields kept", body)
+		}
+		sent = append(sent, req.Input)
+		data := make([]string, len(req.Input))
+		for i, in := range req.Input {
+			data[i] = fmt.Sprintf(`{"object":"embedding","index":%d,"embedding":[%d]}`, i, len(in))
+		}
+		fmt.Fprintf(w, `{"object":"list","data":[%s],"model":"text-embedding-3-small","usage":{"prompt_tokens":%d,"total_tokens":%d}}`,
+			strings.Join(data, ","), 10*len(req.Input), 10*len(req.Input))
+	})
+	defer up.Close()
+
+	st, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("store.Open: %v", err)
+	}
+	defer st.Close()
+	ts := serveEmbeddings(t, up.URL, pipeline.NewChain(), st)
+	defer ts.Close()
+
+	embed := func(inputs string) (embeddingsResponse, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/v1/embeddings", strings.NewReader(`{"model":"text-embedding-3-small","user":"indexer","input":`+inputs+`}`))
+		req.Header.Set("X-Tokenman-Project", "rag")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d; body = %s", resp.StatusCode, body)
+		}
+		var out embeddingsResponse
+		if err := json.Unmarshal(body, &out); err != nil {
+			t.Fatalf("unmarshal %s: %v", body, err)
+		}
+		return out, resp.Header.Get("X-Tokenman-Cache")
+	}
+
+	if _, status := embed(`["a", "bb"]`); status != "MISS" {
+		t.Errorf("first call cache = %s; want MISS", status)
+	}
+
+	// Only the new input goes upstream; the answer keeps the client's order.
+	out, status := embed(`["ccc", "a", "bb"]`)
+	if status != "PARTIAL" {
+		t.Errorf("second call cache = %s; want PARTIAL", status)
+	}
+	if len(sent) != 2 || len(sent[1]) != 1 || sent[1][0] != "ccc" {
+		t.Errorf("upstream inputs = %v; want only the uncached input sent", sent)
+	}
+	for i, want := range []string{"[3]", "[1]", "[2]"} {
+		if out.Data[i].Index != i || string(out.Data[i].Embedding) != want {
+			t.Errorf("data[%d] = %+v; want index %d with %s", i, out.Data[i], i, want)
+		}
+	}
+	if out.Usage.PromptTokens != 10 {
+		t.Errorf("usage = %+v; want only the upstream tokens", out.Usage)
+	}
+
+	if _, status := embed(`"a"`); status != "HIT" || len(sent) != 2 {
+		t.Errorf("third call cache = %s after %d upstream calls; want HIT with no call", status, len(sent))
+	}
+
+	reqs, err := st.ListRequests(10, 0)
+	if err != nil {
+		t.Fatalf("ListRequests: %v", err)
+	}
+	if len(reqs) != 3 {
+		t.Fatalf("requests = %d; want 3 recorded", len(reqs))
+	}
+	for _, listed := range reqs {
+		rec, err := st.GetRequest(listed.ID)
+		if err != nil {
+			t.Fatalf("GetRequest: %v", err)
+		}
+		if rec.Project != "rag" || rec.Format != string(pipeline.FormatOpenAIEmbeddings) {
+			t.Errorf("request = %+v; want embeddings attributed to project rag", rec)
+		}
+		if rec.CacheHit && rec.CostUSD != 0 {
+			t.Errorf("cached request cost = %v; want 0", rec.CostUSD)
+		}
+	}
+}
+
+func TestEmbeddings_ChargedToBudget(t *testing.T) {
+	calls := 0
+	up := mockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprint(w, `{"object":"list","data":[{"object":"embedding","index":0,"embedding":[1]}],"model":"text-embedding-3-small","usage":{"prompt_tokens":1000,"total_tokens":1000}}`)
+	})
+	defer up.Close()
+
+	st, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("store.Open: %v", err)
+	}
+	defer st.Close()
+	budgets := store.NewBudgetAdapter(st)
+	ts := serveEmbeddings(t, up.URL, pipeline.NewChain(security.NewBudgetMiddleware(budgets, 0, 0, 1, nil, 0, nil, true)), st)
+	defer ts.Close()
+	y, m, _ := time.Now().UTC().Date()
+	month := time.Date(y, m, 1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339)
+
+	embed := func(input string) int {
+		t.Helper()
+		resp, err := http.Post(ts.URL+"/v1/embeddings", "application/json", strings.NewReader(`{"model":"text-embedding-3-small","input":"`+input+`"}`))
+		if err != nil {
+			t.Fatalf("POST: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := embed("first"); status != http.StatusOK {
+		t.Fatalf("status = %d; want 200 within budget", status)
+	}
+	spent, _, err := budgets.GetBudget(security.BudgetScopeGlobal, "monthly", month)
+	if want := tokenizer.EstimateCost("text-embedding-3-small", 1000, 0); err != nil || math.Abs(spent-want) > 1e-12 {
+		t.Errorf("monthly spending = %g, %v; want the upstream cost %g", spent, err, want)
+	}
+
+	if err := budgets.AddSpending(security.BudgetScopeGlobal, "monthly", month, 1, 1); err != nil {
+		t.Fatalf("AddSpending: %v", err)
+	}
+	if status := embed("second"); status != http.StatusTooManyRequests {
+		t.Errorf("status = %d; want 429 once the budget is spent", status)
+	}
+	if calls != 1 {
+		t.Errorf("upstream calls = %d; want none past the budget", calls)
+	}
+}
+
+func TestSplitEmbeddingInputs(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{`"one"`, 1},
+		{`["a", "b", "c"]`, 3},
+		{`[1, 2, 3]`, 1},
+		{`[[1, 2], [3]]`, 2},
+	}
+	for _, tt := range tests {
+		got, err := splitEmbeddingInputs(json.RawMessage(tt.input))
+		if err != nil || len(got) != tt.want {
+			t.Errorf("splitEmbeddingInputs(%s) = %d inputs, %v; want %d", tt.input, len(got), err, tt.want)
+		}
+	}
+	for _, bad := range []string{``, `[]`, `{}`, `12`} {
+		if _, err := splitEmbeddingInputs(json.RawMessage(bad)); err == nil {
+			t.Errorf("splitEmbeddingInputs(%s) should fail", bad)
+		}
+	}
+}
@@ -10,18 +10,48 @@ import (
 )
 
 // DetectFormat inspects the request path and returns the corresponding API format.
-// /v1/messages maps to Anthropic, /v1/chat/completions maps to OpenAI.
+// /v1/messages maps to Anthropic, /v1/chat/completions maps to OpenAI,
+// /v1/responses maps to the OpenAI Responses API, and
+// /v1beta/models/{model}:generateContent (or :streamGenerateContent) maps to
+// Gemini.
 func DetectFormat(r *http.Request) pipeline.APIFormat {
 	path := r.URL.Path
-	if strings.HasPrefix(path, "/v1/messages") {
+	if path == "/v1/messages" {
 		return pipeline.FormatAnthropic
 	}
 	if strings.HasPrefix(path, "/v1/chat/completions") {
 		return pipeline.FormatOpenAI
 	}
+	if path == "/v1/responses" {
+		return pipeline.FormatOpenAIResponses
+	}
+	if _, _, ok := geminiAction(path); ok {
+		return pipeline.FormatGemini
+	}
 	return pipeline.FormatUnknown
 }
 
+// geminiAction extracts the model and whether the response streams from a
+// Gemini generateContent path.
+func geminiAction(path string) (model string, stream bool, ok bool) {
+	rest, found := strings.CutPrefix(path, "/v1beta/models/")
+	if !found {
+		return "", false, false
+	}
+	model, action, found := strings.Cut(rest, ":")
+	if !found || model == "" || strings.Contains(model, "/") {
+		return "", false, false
+	}
+	switch action {
+	case "generateContent":
+		return model, false, true
+	case "streamGenerateContent":
+		return model, true, true
+	default:
+		return "", false, false
+	}
+}
+
 // anthropicRawRequest is the raw JSON structure for an Anthropic Messages API request.
 type anthropicRawRequest struct {
 	Model       string          `json:"model"`
@@ -224,3 +254,307 @@ func ParseOpenAIRequest(body []byte) (*pipeline.Request, error) {
 
 	return req, nil
 }
+
+// geminiRawRequest is the raw JSON structure for a Gemini generateContent request.
+type geminiRawRequest struct {
+	Contents          []geminiRawContent `json:"contents"`
+	SystemInstruction *geminiRawContent  `json:"systemInstruction,omitempty"`
+	Tools             []struct {
+		FunctionDeclarations []struct {
+			Name        string      `json:"name"`
+			Description string      `json:"description"`
+			Parameters  interface{} `json:"parameters"`
+		} `json:"functionDeclarations"`
+	} `json:"tools,omitempty"`
+	GenerationConfig struct {
+		MaxOutputTokens int      `json:"maxOutputTokens"`
+		Temperature     *float64 `json:"temperature"`
+	} `json:"generationConfig"`
+}
+
+// geminiRawContent is one turn of a Gemini conversation.
+type geminiRawContent struct {
+	Role  string `json:"role"`
+	Parts []struct {
+		Text       string `json:"text"`
+		InlineData *struct {
+			MimeType string `json:"mimeType"`
+			Data     string `json:"data"`
+		} `json:"inlineData"`
+		FunctionCall *struct {
+			ID   string                 `json:"id"`
+			Name string                 `json:"name"`
+			Args map[string]interface{} `json:"args"`
+		} `json:"functionCall"`
+		FunctionResponse *struct {
+			ID       string                 `json:"id"`
+			Name     string                 `json:"name"`
+			Response map[string]interface{} `json:"response"`
+		} `json:"functionResponse"`
+	} `json:"parts"`
+}
+
+// ParseGeminiRequest parses a Gemini generateContent request into a
+// normalized pipeline.Request. The model and stream flag come from the
+// request path. Contents are normalized to Anthropic-style messages: the
+// "model" role becomes "assistant", function calls and responses become
+// tool_use and tool_result blocks (identified by the function name when
+// Gemini gives no ID), and inline data becomes image blocks. A turn with a
+// single text part gets plain string content.
+func ParseGeminiRequest(body []byte, path string) (*pipeline.Request, error) {
+	model, stream, ok := geminiAction(path)
+	if !ok {
+		return nil, fmt.Errorf("parsing gemini request: unsupported path %q", path)
+	}
+	var raw geminiRawRequest
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("parsing gemini request: %w", err)
+	}
+
+	req := &pipeline.Request{
+		Format:      pipeline.FormatGemini,
+		Model:       model,
+		Stream:      stream,
+		MaxTokens:   raw.GenerationConfig.MaxOutputTokens,
+		Temperature: raw.GenerationConfig.Temperature,
+		RawBody:     body,
+		Flags:       make(map[string]bool),
+		Headers:     make(map[string]string),
+	}
+
+	for _, c := range raw.Contents {
+		msg := pipeline.Message{Role: "user"}
+		if c.Role == "model" {
+			msg.Role = "assistant"
+		}
+		var blocks []interface{}
+		for _, part := range c.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				id := part.FunctionCall.ID
+				if id == "" {
+					id = part.FunctionCall.Name
+				}
+				args := part.FunctionCall.Args
+				if args == nil {
+					args = map[string]interface{}{}
+				}
+				blocks = append(blocks, map[string]interface{}{
+					"type": "tool_use", "id": id, "name": part.FunctionCall.Name, "input": args,
+				})
+			case part.FunctionResponse != nil:
+				id := part.FunctionResponse.ID
+				if id == "" {
+					id = part.FunctionResponse.Name
+				}
+				result, err := json.Marshal(part.FunctionResponse.Response)
+				if err != nil {
+					return nil, fmt.Errorf("parsing gemini function response: %w", err)
+				}
+				blocks = append(blocks, map[string]interface{}{
+					"type": "tool_result", "tool_use_id": id, "content": string(result),
+				})
+			case part.InlineData != nil:
+				blocks = append(blocks, map[string]interface{}{
+					"type": "image",
+					"source": map[string]interface{}{
+						"type": "base64", "media_type": part.InlineData.MimeType, "data": part.InlineData.Data,
+					},
+				})
+			default:
+				blocks = append(blocks, map[string]interface{}{"type": "text", "text": part.Text})
+			}
+		}
+		if len(blocks) == 1 {
+			if b := blocks[0].(map[string]interface{}); b["type"] == "text" {
+				msg.Content = b["text"]
+			}
+		}
+		if msg.Content == nil {
+			msg.Content = blocks
+		}
+		req.Messages = append(req.Messages, msg)
+	}
+
+	if raw.SystemInstruction != nil {
+		var parts []string
+		for _, part := range raw.SystemInstruction.Parts {
+			if part.Text != "" {
+				parts = append(parts, part.Text)
+			}
+		}
+		req.System = strings.Join(parts, "\n")
+	}
+
+	for _, tool := range raw.Tools {
+		for _, decl := range tool.FunctionDeclarations {
+			req.Tools = append(req.Tools, pipeline.Tool{
+				Name:        decl.Name,
+				Description: decl.Description,
+				InputSchema: decl.Parameters,
+			})
+		}
+	}
+
+	return req, nil
+}
+
+// responsesRawRequest is the raw JSON structure for an OpenAI Responses API
+// request.
+type responsesRawRequest struct {
+	Model              string            `json:"model"`
+	Input              json.RawMessage   `json:"input"`
+	Instructions       string            `json:"instructions"`
+	Tools              []json.RawMessage `json:"tools,omitempty"`
+	Stream             bool              `json:"stream"`
+	MaxOutputTokens    int               `json:"max_output_tokens"`
+	Temperature        *float64          `json:"temperature,omitempty"`
+	PreviousResponseID string            `json:"previous_response_id"`
+}
+
+// responsesRawItem is one input item of a Responses API request.
+type responsesRawItem struct {
+	Type      string          `json:"type"`
+	Role      string          `json:"role"`
+	Content   json.RawMessage `json:"content"`
+	CallID    string          `json:"call_id"`
+	Name      string          `json:"name"`
+	Arguments string          `json:"arguments"`
+	Output    json.RawMessage `json:"output"`
+}
+
+// ParseResponsesRequest parses an OpenAI Responses API request into a
+// normalized pipeline.Request. Instructions become the system prompt and
+// max_output_tokens becomes MaxTokens. Input items are normalized to
+// messages: input_text and output_text parts become text blocks, function
+// calls become assistant tool calls, and function call outputs become
+// "tool" messages. Other items (reasoning, item references, built-in tool
+// calls) are kept as a single opaque block in an assistant message so they
+// are passed through unchanged.
+func ParseResponsesRequest(body []byte) (*pipeline.Request, error) {
+	var raw responsesRawRequest
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("parsing responses request: %w", err)
+	}
+
+	req := &pipeline.Request{
+		Format:             pipeline.FormatOpenAIResponses,
+		Model:              raw.Model,
+		System:             raw.Instructions,
+		Stream:             raw.Stream,
+		MaxTokens:          raw.MaxOutputTokens,
+		Temperature:        raw.Temperature,
+		PreviousResponseID: raw.PreviousResponseID,
+		RawBody:            body,
+		Flags:              make(map[string]bool),
+		Headers:            make(map[string]string),
+	}
+
+	// Input is either a plain string (one user message) or a list of items.
+	trimmed := strings.TrimSpace(string(raw.Input))
+	if strings.HasPrefix(trimmed, "\"") {
+		var s string
+		if err := json.Unmarshal(raw.Input, &s); err != nil {
+			return nil, fmt.Errorf("parsing responses input string: %w", err)
+		}
+		req.Messages = []pipeline.Message{{Role: "user", Content: s}}
+	} else if strings.HasPrefix(trimmed, "[") {
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw.Input, &items); err != nil {
+			return nil, fmt.Errorf("parsing responses input: %w", err)
+		}
+		for _, data := range items {
+			var item responsesRawItem
+			if err := json.Unmarshal(data, &item); err != nil {
+				return nil, fmt.Errorf("parsing responses input item: %w", err)
+			}
+			msg, err := responsesMessage(item, data)
+			if err != nil {
+				return nil, err
+			}
+			// Consecutive function calls belong to one assistant turn.
+			if n := len(req.Messages); n > 0 && len(msg.ToolCalls) > 0 {
+				if last := &req.Messages[n-1]; last.Role == "assistant" && len(last.ToolCalls) > 0 {
+					last.ToolCalls = append(last.ToolCalls, msg.ToolCalls...)
+					continue
+				}
+			}
+			req.Messages = append(req.Messages, msg)
+		}
+	}
+
+	// Function tools are flat in the Responses API; built-in tools only
+	// carry their type.
+	for _, data := range raw.Tools {
+		var tool struct {
+			Type        string      `json:"type"`
+			Name        string      `json:"name"`
+			Description string      `json:"description"`
+			Parameters  interface{} `json:"parameters"`
+		}
+		if err := json.Unmarshal(data, &tool); err != nil {
+			return nil, fmt.Errorf("parsing responses tools: %w", err)
+		}
+		req.Tools = append(req.Tools, pipeline.Tool{
+			Type:        tool.Type,
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: tool.Parameters,
+		})
+	}
+
+	return req, nil
+}
+
+// responsesMessage converts one Responses input item into a message. data
+// is the item's raw JSON.
+func responsesMessage(item responsesRawItem, data json.RawMessage) (pipeline.Message, error) {
+	switch item.Type {
+	case "", "message":
+		msg := pipeline.Message{Role: item.Role, Content: ""}
+		trimmed := strings.TrimSpace(string(item.Content))
+		if strings.HasPrefix(trimmed, "\"") {
+			var s string
+			if err := json.Unmarshal(item.Content, &s); err != nil {
+				return msg, fmt.Errorf("parsing responses message content string: %w", err)
+			}
+			msg.Content = s
+		} else if strings.HasPrefix(trimmed, "[") {
+			var blocks []pipeline.ContentBlock
+			if err := json.Unmarshal(item.Content, &blocks); err != nil {
+				return msg, fmt.Errorf("parsing responses message content: %w", err)
+			}
+			for i := range blocks {
+				if blocks[i].Type == "input_text" || blocks[i].Type == "output_text" {
+					blocks[i].Type = "text"
+				}
+			}
+			msg.Content = blocks
+		}
+		return msg, nil
+	case "function_call":
+		return pipeline.Message{
+			Role:    "assistant",
+			Content: "",
+			ToolCalls: []pipeline.ToolCall{{
+				ID:       item.CallID,
+				Type:     "function",
+				Function: pipeline.ToolFunction{Name: item.Name, Arguments: item.Arguments},
+			}},
+		}, nil
+	case "function_call_output":
+		msg := pipeline.Message{Role: "tool", ToolCallID: item.CallID, Content: ""}
+		if len(item.Output) > 0 {
+			if err := json.Unmarshal(item.Output, &msg.Content); err != nil {
+				return msg, fmt.Errorf("parsing responses function call output: %w", err)
+			}
+		}
+		return msg, nil
+	default:
+		var block pipeline.ContentBlock
+		if err := json.Unmarshal(data, &block); err != nil {
+			return pipeline.Message{}, fmt.Errorf("parsing responses %s item: %w", item.Type, err)
+		}
+		return pipeline.Message{Role: "assistant", Content: []pipeline.ContentBlock{block}}, nil
+	}
+}
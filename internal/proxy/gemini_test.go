@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+	"github.com/allaspectsdev/tokenman/internal/router"
+	"github.com/allaspectsdev/tokenman/internal/tokenizer"
+)
+
+func TestParseGeminiRequest(t *testing.T) {
+	body := `{
+		"systemInstruction": {"parts": [{"text": "Be brief."}]},
+		"contents": [
+			{"role": "user", "parts": [{"text": "Weather in Paris?"}]},
+			{"role": "model", "parts": [{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}}]},
+			{"role": "user", "parts": [{"functionResponse": {"name": "get_weather", "response": {"temp_c": 18}}}]}
+		],
+		"tools": [{"functionDeclarations": [{"name": "get_weather", "description": "Current weather", "parameters": {"type": "object"}}]}],
+		"generationConfig": {"maxOutputTokens": 128, "temperature": 0.5}
+	}`
+	req, err := ParseGeminiRequest([]byte(body), "/v1beta/models/gemini-2.5-flash:streamGenerateContent")
+	if err != nil {
+		t.Fatalf("ParseGeminiRequest: %v", err)
+	}
+
+	if req.Format != pipeline.FormatGemini || req.Model != "gemini-2.5-flash" || !req.Stream {
+		t.Errorf("format, model, stream = %s, %q, %v; want gemini-2.5-flash streaming from the path", req.Format, req.Model, req.Stream)
+	}
+	if req.System != "Be brief." || req.MaxTokens != 128 || req.Temperature == nil || *req.Temperature != 0.5 {
+		t.Errorf("system, max tokens, temperature = %q, %d, %v", req.System, req.MaxTokens, req.Temperature)
+	}
+	if len(req.Messages) != 3 || req.Messages[0].Content != "Weather in Paris?" || req.Messages[1].Role != "assistant" {
+		t.Fatalf("messages = %+v; want user text, assistant call, user result", req.Messages)
+	}
+	call := req.Messages[1].Content.([]interface{})[0].(map[string]interface{})
+	if call["type"] != "tool_use" || call["id"] != "get_weather" {
+		t.Errorf("function call = %v; want a tool_use block identified by name", call)
+	}
+	result := req.Messages[2].Content.([]interface{})[0].(map[string]interface{})
+	if result["type"] != "tool_result" || result["tool_use_id"] != "get_weather" || result["content"] != `{"temp_c":18}` {
+		t.Errorf("function response = %v; want a tool_result for get_weather", result)
+	}
+	if len(req.Tools) != 1 || req.Tools[0].Name != "get_weather" {
+		t.Errorf("tools = %+v; want get_weather", req.Tools)
+	}
+
+	for path, want := range map[string]pipeline.APIFormat{
+		"/v1beta/models/gemini-2.5-flash:generateContent": pipeline.FormatGemini,
+		"/v1beta/models/gemini-2.5-flash:countTokens":     pipeline.FormatUnknown,
+		"/v1beta/models/gemini-2.5-flash":                 pipeline.FormatUnknown,
+	} {
+		if got := DetectFormat(httptest.NewRequest(http.MethodPost, path, nil)); got != want {
+			t.Errorf("DetectFormat(%s) = %s; want %s", path, got, want)
+		}
+	}
+}
+
+// serveGemini returns a proxy server with an Anthropic and a Gemini
+// provider, both served by upstream.
+func serveGemini(t *testing.T, upstream http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	up := mockUpstream(t, upstream)
+	t.Cleanup(up.Close)
+	rtr := router.NewRouter(map[string]*router.ProviderConfig{
+		"anthropic": {
+			Name: "anthropic", BaseURL: up.URL, APIKey: "anthropic-key", Format: pipeline.FormatAnthropic,
+			Models: []string{"claude-sonnet-4"}, Enabled: true, Priority: 1,
+		},
+		"gemini": {
+			Name: "gemini", BaseURL: up.URL, APIKey: "gemini-key", Format: pipeline.FormatGemini,
+			Models: []string{"gemini-2.5-flash"}, Enabled: true, Priority: 2,
+		},
+	}, nil, "anthropic", false)
+	handler := NewProxyHandler(pipeline.NewChain(), NewUpstreamClient(), zerolog.Nop(), nil, tokenizer.New(), nil, 10<<20, 0, 0, nil, RetryConfig{}, rtr, 0, 0, false, 0)
+	ts := httptest.NewServer(NewServer(handler, ":0", 0, 0, 0, false, nil).Router())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func post(t *testing.T, url, body string) string {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	out, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d; want 200; body = %s", resp.StatusCode, out)
+	}
+	return string(out)
+}
+
+const geminiAnswer = `{"candidates":[{"content":{"role":"model","parts":[{"text":"Bonjour"}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":6,"candidatesTokenCount":3,"totalTokenCount":9},"modelVersion":"gemini-2.5-flash"}`
+
+func TestGemini_ClientToGeminiProvider(t *testing.T) {
+	ts := serveGemini(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path != "/v1beta/models/gemini-2.5-flash:generateContent" || r.Header.Get("x-goog-api-key") != "gemini-key" {
+			t.Errorf("upstream request = %s (key %q); want an authenticated generateContent call", r.URL.Path, r.Header.Get("x-goog-api-key"))
+		}
+		if !strings.Contains(string(body), `"safetySettings"`) || !strings.Contains(string(body), `"text":"Say hello in French"`) {
+			t.Errorf("upstream body = %s; want the rebuilt contents with other fields kept", body)
+		}
+		_, _ = w.Write([]byte(geminiAnswer))
+	})
+
+	body := post(t, ts.URL+"/v1beta/models/gemini-2.5-flash:generateContent",
+		`{"contents":[{"role":"user","parts":[{"text":"Say hello in French"}]}],"safetySettings":[{"category":"HARM_CATEGORY_HARASSMENT","threshold":"BLOCK_NONE"}]}`)
+	if body != geminiAnswer {
+		t.Errorf("body = %s; want the Gemini response unchanged", body)
+	}
+}
+
+func TestGemini_AnthropicClientRoutedToGemini(t *testing.T) {
+	ts := serveGemini(t, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Contents []struct {
+				Role  string `json:"role"`
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"contents"`
+			SystemInstruction struct {
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"systemInstruction"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if r.URL.Path != "/v1beta/models/gemini-2.5-flash:generateContent" {
+			t.Errorf("upstream path = %s; want Gemini generateContent", r.URL.Path)
+		}
+		if len(req.Contents) != 1 || req.Contents[0].Parts[0].Text != "Say hello in French" || req.SystemInstruction.Parts[0].Text != "Be brief." {
+			t.Errorf("upstream request = %+v; want the Anthropic request translated", req)
+		}
+		_, _ = w.Write([]byte(geminiAnswer))
+	})
+
+	body := post(t, ts.URL+"/v1/messages",
+		`{"model":"gemini-2.5-flash","system":"Be brief.","messages":[{"role":"user","content":"Say hello in French"}],"max_tokens":100}`)
+	var msg struct {
+		Type       string `json:"type"`
+		StopReason string `json:"stop_reason"`
+		Content    []struct {
+			Text string `json:"text"`
+		} `json:"content"`
+		Usage struct {
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal([]byte(body), &msg); err != nil {
+		t.Fatalf("unmarshal %s: %v", body, err)
+	}
+	if msg.Type != "message" || msg.StopReason != "end_turn" || len(msg.Content) != 1 || msg.Content[0].Text != "Bonjour" || msg.Usage.OutputTokens != 3 {
+		t.Errorf("body = %s; want an Anthropic message saying Bonjour", body)
+	}
+}
+
+func TestGemini_StreamTranslatedForAnthropicClient(t *testing.T) {
+	ts := serveGemini(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		if r.URL.Path != "/v1beta/models/gemini-2.5-flash:streamGenerateContent" || r.URL.Query().Get("alt") != "sse" {
+			t.Errorf("upstream request = %s; want streamGenerateContent with alt=sse", r.URL)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{
+			`{"candidates":[{"content":{"role":"model","parts":[{"text":"Bon"}]}}],"modelVersion":"gemini-2.5-flash"}`,
+			`{"candidates":[{"content":{"role":"model","parts":[{"text":"jour"}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":6,"candidatesTokenCount":3}}`,
+		} {
+			_, _ = w.Write([]byte("data: " + chunk + "\r\n\r\n"))
+			w.(http.Flusher).Flush()
+		}
+	})
+
+	body := post(t, ts.URL+"/v1/messages",
+		`{"model":"gemini-2.5-flash","messages":[{"role":"user","content":"Say hello in French"}],"max_tokens":100,"stream":true}`)
+	for _, want := range []string{
+		"event: message_start",
+		`"text":"Bon"`,
+		`"text":"jour"`,
+		`"stop_reason":"end_turn"`,
+		"event: message_stop",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("stream missing %s:\n%s", want, body)
+		}
+	}
+}
+
+func TestGemini_GeminiClientRoutedToAnthropic(t *testing.T) {
+	ts := serveGemini(t, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model    string `json:"model"`
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if r.URL.Path != "/v1/messages" || req.Model != "claude-sonnet-4" || len(req.Messages) != 1 || req.Messages[0].Content != "Hi" {
+			t.Errorf("upstream request = %s %+v; want the Gemini request translated to Anthropic", r.URL.Path, req)
+		}
+		answer(w, "Hello")
+	})
+
+	body := post(t, ts.URL+"/v1beta/models/claude-sonnet-4:generateContent", `{"contents":[{"role":"user","parts":[{"text":"Hi"}]}]}`)
+	if !strings.Contains(body, `"candidates"`) || !strings.Contains(body, `"text":"Hello"`) || !strings.Contains(body, `"finishReason":"STOP"`) {
+		t.Errorf("body = %s; want a Gemini response", body)
+	}
+}
@@ -6,11 +6,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/allaspectsdev/tokenman/internal/compress"
+	"github.com/allaspectsdev/tokenman/internal/auth"
+	"github.com/allaspectsdev/tokenman/internal/cache"
+	"github.com/allaspectsdev/tokenman/internal/cascade"
 	"github.com/allaspectsdev/tokenman/internal/metrics"
 	"github.com/allaspectsdev/tokenman/internal/pipeline"
 	"github.com/allaspectsdev/tokenman/internal/router"
@@ -47,6 +51,26 @@ func truncateBody(b []byte, maxBytes int) string {
 	return string(b[:maxBytes]) + "...(truncated)"
 }
 
+// Body sink modes for BodySinks.Log and BodySinks.Store.
+const (
+	BodyRaw      = "raw"
+	BodyRedacted = "redacted"
+	BodyNone     = "none"
+)
+
+// BodySinks controls what request and response bodies reach trace-level
+// logs and the requests table. Empty modes mean BodyRaw.
+type BodySinks struct {
+	Log   string
+	Store string
+	// StoreUpstream stores the request body produced by the pipeline (after
+	// PII redaction, compression, and policy rewrites) instead of the body
+	// the client sent.
+	StoreUpstream bool
+	// Redactor masks PII and secrets for BodyRedacted sinks.
+	Redactor *security.Redactor
+}
+
 // ProviderConfig holds the configuration needed to route a request to an upstream provider.
 type ProviderConfig struct {
 	BaseURL string
@@ -73,6 +97,14 @@ type ProxyHandler struct {
 	retryConfig     RetryConfig
 	storeBody       bool
 	maxLogBody      int
+	quota           *QuotaTracker
+	scheduler       *Scheduler
+	bodies          BodySinks
+	hedge           HedgeConfig
+	hedgeBudget     *HedgeBudget
+	cascades        *cascade.Set
+	health          *HealthChecker
+	embeddings      *cache.EmbeddingCache
 }
 
 // NewProxyHandler creates a new ProxyHandler with the given pipeline chain,
@@ -123,18 +155,309 @@ func (h *ProxyHandler) StartSessionReaper(ctx context.Context) <-chan struct{} {
 	return h.streams.StartReaper(ctx)
 }
 
+// SetQuotaTracker enables tracking of upstream rate-limit headers and
+// proactive pacing of requests against them.
+func (h *ProxyHandler) SetQuotaTracker(q *QuotaTracker) {
+	h.quota = q
+}
+
+// SetBodySinks sets how bodies are written to trace logs and stored.
+func (h *ProxyHandler) SetBodySinks(b BodySinks) {
+	h.bodies = b
+}
+
+// redactBody applies a sink mode to b.
+func (h *ProxyHandler) redactBody(b []byte, mode string) string {
+	switch mode {
+	case BodyNone:
+		return ""
+	case BodyRedacted:
+		if h.bodies.Redactor != nil {
+			return h.bodies.Redactor.Redact(string(b))
+		}
+	}
+	return string(b)
+}
+
+// traceBody logs b at trace level under field, applying the log sink mode
+// before truncating to max_log_body. Redaction is skipped entirely unless
+// trace logging is enabled.
+func (h *ProxyHandler) traceBody(logger zerolog.Logger, field string, b []byte, msg string) {
+	if h.bodies.Log == BodyNone {
+		return
+	}
+	e := logger.Trace()
+	if !e.Enabled() {
+		return
+	}
+	e.Str(field, truncateBody([]byte(h.redactBody(b, h.bodies.Log)), h.maxLogBody)).Msg(msg)
+}
+
+// storedBody returns b as it should be persisted, or an empty string when
+// bodies are not stored.
+func (h *ProxyHandler) storedBody(b []byte) string {
+	body := bodyForStore(b, h.storeBody)
+	if body == "" {
+		return ""
+	}
+	return h.redactBody([]byte(body), h.bodies.Store)
+}
+
+// storedRequestBody is storedBody for the request body, which is either
+// original or the upstream body rebuilt from req.
+func (h *ProxyHandler) storedRequestBody(original []byte, req *pipeline.Request) string {
+	if h.storeBody && h.bodies.StoreUpstream && h.bodies.Store != BodyNone {
+		return h.storedBody(rebuildRequestBody(req))
+	}
+	return h.storedBody(original)
+}
+
+// SetScheduler enables priority scheduling of upstream requests.
+func (h *ProxyHandler) SetScheduler(s *Scheduler) {
+	h.scheduler = s
+}
+
+// paceUpstream holds the request back if the provider's last reported quota
+// for this API key is nearly exhausted, so it is sent once there is room
+// rather than being rejected with a 429.
+func (h *ProxyHandler) paceUpstream(ctx context.Context, provider, apiKey string, pipeReq *pipeline.Request, logger zerolog.Logger) error {
+	if h.quota == nil {
+		return nil
+	}
+	delay := h.quota.Reserve(provider, apiKey, pipeReq)
+	if delay <= 0 {
+		return nil
+	}
+	logger.Debug().Str("provider", provider).Dur("delay", delay).Msg("pacing request to stay within upstream rate limit")
+	return sleepWithContext(ctx, delay)
+}
+
+// observeQuota records the rate-limit headers of an upstream response.
+func (h *ProxyHandler) observeQuota(provider, apiKey string, resp *http.Response) {
+	if h.quota != nil && resp != nil {
+		h.quota.Observe(provider, apiKey, resp.Header)
+	}
+}
+
+// pickKey chooses the upstream API key for one attempt against p. Pooled
+// keys whose circuit breaker is open are skipped. Providers without a pool
+// use their single key, identified by its fingerprint.
+func (h *ProxyHandler) pickKey(p *router.ProviderConfig) (router.APIKey, bool) {
+	if p.Keys == nil {
+		return router.APIKey{ID: router.KeyFingerprint(p.APIKey), Secret: p.APIKey}, true
+	}
+	return p.Keys.Pick(func(id string) bool {
+		return h.cbRegistry == nil || h.cbRegistry.ForKey(p.Name, id).Allow()
+	})
+}
+
+// reportKey records the outcome of an upstream attempt made with key. A 429
+// benches a pooled key until its limit resets and a 401 disables it.
+func (h *ProxyHandler) reportKey(p *router.ProviderConfig, key router.APIKey, resp *http.Response, fwdErr error) {
+	status := "success"
+	switch {
+	case fwdErr != nil:
+		status = "error"
+	case resp.StatusCode == http.StatusTooManyRequests:
+		status = "rate_limited"
+		p.Keys.Bench(key.ID, retryAfterDuration(resp))
+	case resp.StatusCode == http.StatusUnauthorized:
+		status = "unauthorized"
+		p.Keys.Disable(key.ID)
+	case isRetryableStatus(resp.StatusCode):
+		status = "error"
+	}
+	if p.Keys != nil && h.cbRegistry != nil {
+		cb := h.cbRegistry.ForKey(p.Name, key.ID)
+		if status == "success" {
+			cb.RecordSuccess()
+		} else {
+			cb.RecordFailure()
+		}
+	}
+	if h.collector != nil && key.ID != "" {
+		h.collector.RecordProviderKeyRequest(p.Name, key.ID, status)
+	}
+}
+
+// route returns the providers to try for routeModel, ordered by the
+// router's selection strategy from each provider's rolling health, circuit
+// state, and estimated cost for pipeReq. Strategy decisions are added to the
+// trace, logged, and recorded for /api/providers.
+func (h *ProxyHandler) route(ctx context.Context, routeModel string, pipeReq *pipeline.Request, logger zerolog.Logger) ([]*router.ProviderConfig, error) {
+	candidates, rankings, err := h.router.Route(routeModel, func(p *router.ProviderConfig) router.ProviderStats {
+		return h.providerStats(p, routeModel, pipeReq)
+	})
+	if err == nil && openAIOnly(pipeReq.Format) {
+		candidates, rankings = openAIProviders(candidates, rankings)
+		if len(candidates) == 0 {
+			err = fmt.Errorf("no OpenAI provider serves model %q for %s requests", routeModel, pipeReq.Format)
+		}
+	}
+	if err != nil || len(rankings) == 0 {
+		return candidates, err
+	}
+
+	strategy := h.router.StrategyName()
+	reasons := make([]string, len(rankings))
+	now := time.Now()
+	for i, rk := range rankings {
+		reasons[i] = rk.Provider + ": " + rk.Reason
+		if h.collector != nil {
+			h.collector.RecordRouting(rk.Provider, metrics.ProviderRouting{
+				Time:     now,
+				Model:    routeModel,
+				Strategy: strategy,
+				Rank:     rk.Rank,
+				Reason:   rk.Reason,
+			})
+		}
+	}
+	tracing.SetRoutingAttributes(ctx, strategy, rankings[0].Provider, reasons)
+	logger.Debug().Str("strategy", strategy).Strs("ranking", reasons).Msg("routing decision")
+	return candidates, nil
+}
+
+// openAIOnly reports whether requests in format are served only by
+// OpenAI-format providers. The Responses API is not translated to other
+// formats: its stored responses and previous_response_id chains exist only at
+// OpenAI. Embeddings have no equivalent in the other formats.
+func openAIOnly(format pipeline.APIFormat) bool {
+	return format == pipeline.FormatOpenAIResponses || format == pipeline.FormatOpenAIEmbeddings
+}
+
+// openAIProviders keeps the OpenAI-format providers among candidates and
+// their rankings.
+func openAIProviders(candidates []*router.ProviderConfig, rankings []router.Ranking) ([]*router.ProviderConfig, []router.Ranking) {
+	kept := make(map[string]bool, len(candidates))
+	var ps []*router.ProviderConfig
+	for _, p := range candidates {
+		if p.Format == "" || p.Format == pipeline.FormatOpenAI {
+			ps = append(ps, p)
+			kept[p.Name] = true
+		}
+	}
+	var rs []router.Ranking
+	for _, rk := range rankings {
+		if kept[rk.Provider] {
+			rs = append(rs, rk)
+		}
+	}
+	return ps, rs
+}
+
+// providerStats gathers the live view of p used by the routing strategy.
+func (h *ProxyHandler) providerStats(p *router.ProviderConfig, routeModel string, pipeReq *pipeline.Request) router.ProviderStats {
+	st := router.ProviderStats{Cost: -1}
+	if h.collector != nil {
+		health := h.collector.ProviderHealth(p.Name)
+		st.Samples = health.Samples
+		st.P50 = time.Duration(health.P50Ms * float64(time.Millisecond))
+		st.P95 = time.Duration(health.P95Ms * float64(time.Millisecond))
+		st.ErrorRate = health.ErrorRate
+	}
+	if h.cbRegistry != nil {
+		st.CircuitOpen = h.cbRegistry.Get(p.Name).State() == CBOpen
+	}
+	if h.health != nil {
+		st.Unhealthy = !h.health.Healthy(p.Name)
+	}
+	model := h.router.ModelFor(routeModel, p.Name)
+	if _, ok := tokenizer.GetPricing(model); ok {
+		st.Cost = tokenizer.EstimateCost(model, pipeReq.TokensIn, pipeReq.MaxTokens)
+	}
+	return st
+}
+
+// useProviderModel switches pipeReq to the model p serves for routeModel,
+// rebuilding the upstream body, when an alias maps it to a different model
+// on each provider.
+func (h *ProxyHandler) useProviderModel(pipeReq *pipeline.Request, routeModel string, p *router.ProviderConfig) {
+	if routeModel == pipeReq.Model {
+		return
+	}
+	if model := h.router.ModelFor(routeModel, p.Name); model != pipeReq.Model {
+		pipeReq.Model = model
+		pipeReq.RawBody = rebuildRequestBody(pipeReq)
+	}
+}
+
+// observeUpstream feeds the duration and outcome of an upstream call into
+// the provider's rolling health and its health check state.
+func (h *ProxyHandler) observeUpstream(provider string, start time.Time, resp *http.Response, err error) {
+	if h.health != nil {
+		h.health.ObserveRequest(provider, time.Since(start), resp, err)
+	}
+	if h.collector == nil {
+		return
+	}
+	failed := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	h.collector.ObserveUpstream(provider, time.Since(start), failed)
+}
+
+// routeModel returns the model to route pipeReq by: the alias the client
+// asked for, unless the pipeline (e.g. a policy downgrade) has since moved
+// the request to another model.
+func (h *ProxyHandler) routeModel(pipeReq *pipeline.Request) string {
+	rm := pipeReq.RequestedModel
+	if rm == pipeReq.Model || !h.router.IsAlias(rm) {
+		return pipeReq.Model
+	}
+	if _, model, err := h.router.ResolveModel(rm); err == nil && model == pipeReq.Model {
+		return rm
+	}
+	return pipeReq.Model
+}
+
+// requestedModel returns the model the client asked for when an alias or
+// routing rule rewrote it, and "" otherwise.
+func requestedModel(req *pipeline.Request) string {
+	if req.RequestedModel == req.Model {
+		return ""
+	}
+	return req.RequestedModel
+}
+
+// rotateKey reports whether resp was a per-key rejection (rate limit or bad
+// credentials) that another key in p's pool may not hit.
+func rotateKey(p *router.ProviderConfig, resp *http.Response) bool {
+	if p.Keys.Len() < 2 {
+		return false
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusUnauthorized
+}
 
 // forwardWithRetry attempts to forward the request using the retry/circuit-breaker
 // logic. It uses the router to resolve providers with deterministic fallback
 // ordering by priority, and retries on transient failures with exponential backoff.
+// Providers with a key pool retry rate-limited or rejected keys with another
+// key straight away.
 func (h *ProxyHandler) forwardWithRetry(ctx context.Context, pipeReq *pipeline.Request, logger zerolog.Logger) (*http.Response, error) {
-	candidates, err := h.router.ResolveWithFallback(pipeReq.Model)
+	routeModel := h.routeModel(pipeReq)
+	candidates, err := h.route(ctx, routeModel, pipeReq, logger)
 	if err != nil {
 		return nil, fmt.Errorf("no provider for model %q: %w", pipeReq.Model, err)
 	}
 
 	var lastErr error
-	for _, cand := range candidates {
+	for i, cand := range candidates {
+		h.useProviderModel(pipeReq, routeModel, cand)
+
+		key, ok := h.pickKey(cand)
+		if !ok {
+			logger.Debug().Str("provider", cand.Name).Msg("no usable API key, skipping provider")
+			if h.collector != nil {
+				h.collector.RecordProviderRequest(cand.Name, "keys_exhausted")
+			}
+			continue
+		}
+
+		// Prefer a fallback over waiting out an exhausted upstream quota.
+		if h.quota != nil && i < len(candidates)-1 && h.quota.Delay(cand.Name, key.Secret, pipeReq) > h.quota.MaxDelay() {
+			logger.Debug().Str("provider", cand.Name).Msg("upstream rate limit exhausted, trying next provider")
+			continue
+		}
+
 		cb := h.cbRegistry.Get(cand.Name)
 		if !cb.Allow() {
 			logger.Debug().Str("provider", cand.Name).Msg("circuit breaker open, skipping provider")
@@ -145,25 +468,33 @@ func (h *ProxyHandler) forwardWithRetry(ctx context.Context, pipeReq *pipeline.R
 			continue
 		}
 
+		rotated := false
 		for attempt := 0; attempt < h.retryConfig.MaxAttempts; attempt++ {
 			if attempt > 0 {
-				delay := backoffDelay(attempt-1, h.retryConfig.BaseDelay, h.retryConfig.MaxDelay)
-				if err := sleepWithContext(ctx, delay); err != nil {
-					return nil, err
+				if !rotated {
+					delay := backoffDelay(attempt-1, h.retryConfig.BaseDelay, h.retryConfig.MaxDelay)
+					if err := sleepWithContext(ctx, delay); err != nil {
+						return nil, err
+					}
+				}
+				if key, ok = h.pickKey(cand); !ok {
+					logger.Debug().Str("provider", cand.Name).Msg("no usable API key left")
+					break
 				}
 			}
+			rotated = false
 
-			// Apply per-provider timeout via context for non-streaming requests.
-			// Wrapped in an anonymous function so defer cancel() is scoped per iteration.
-			resp, fwdErr := func() (*http.Response, error) {
-				fwdCtx := ctx
-				if cand.Timeout > 0 && !pipeReq.Stream {
-					var cancel context.CancelFunc
-					fwdCtx, cancel = context.WithTimeout(ctx, cand.Timeout)
-					defer cancel()
-				}
-				return h.client.Forward(fwdCtx, pipeReq, cand.BaseURL, cand.APIKey)
-			}()
+			if err := h.paceUpstream(ctx, cand.Name, key.Secret, pipeReq, logger); err != nil {
+				return nil, err
+			}
+
+			call := h.forwardAttempt(ctx, pipeReq, routeModel, cand, key, candidates[i+1:], logger)
+			if call.provider != cand {
+				// A hedged request to a later provider answered first.
+				pipeReq.ProviderKey = call.key.ID
+				return call.resp, nil
+			}
+			resp, fwdErr := call.resp, call.err
 			if fwdErr != nil {
 				lastErr = fwdErr
 				cb.RecordFailure()
@@ -174,6 +505,17 @@ func (h *ProxyHandler) forwardWithRetry(ctx context.Context, pipeReq *pipeline.R
 				logger.Warn().Err(fwdErr).Str("provider", cand.Name).Int("attempt", attempt+1).Msg("upstream forward error, retrying")
 				continue
 			}
+			h.observeQuota(cand.Name, key.Secret, resp)
+
+			// A rate-limited or rejected key says nothing about the provider's
+			// health, so move to the next key without backing off.
+			if rotateKey(cand, resp) {
+				lastErr = fmt.Errorf("upstream returned status %d", resp.StatusCode)
+				_ = resp.Body.Close()
+				rotated = true
+				logger.Warn().Int("status", resp.StatusCode).Str("provider", cand.Name).Str("provider_key", key.ID).Msg("API key rejected, trying next key")
+				continue
+			}
 
 			if isRetryableStatus(resp.StatusCode) {
 				// For streaming, don't retry after the connection is established
@@ -184,6 +526,7 @@ func (h *ProxyHandler) forwardWithRetry(ctx context.Context, pipeReq *pipeline.R
 						h.collector.RecordProviderRequest(cand.Name, "error")
 						h.collector.SetCircuitState(cand.Name, float64(cb.State()))
 					}
+					pipeReq.ProviderKey = key.ID
 					return resp, nil // return the error response; caller handles it
 				}
 
@@ -214,6 +557,7 @@ func (h *ProxyHandler) forwardWithRetry(ctx context.Context, pipeReq *pipeline.R
 				h.collector.RecordProviderRequest(cand.Name, "success")
 				h.collector.SetCircuitState(cand.Name, float64(cb.State()))
 			}
+			pipeReq.ProviderKey = key.ID
 			return resp, nil
 		}
 
@@ -226,6 +570,103 @@ func (h *ProxyHandler) forwardWithRetry(ctx context.Context, pipeReq *pipeline.R
 	return nil, fmt.Errorf("all providers exhausted for model %q", pipeReq.Model)
 }
 
+// forwardOnce forwards the request to the first routed provider without
+// retries or fallback.
+func (h *ProxyHandler) forwardOnce(ctx context.Context, pipeReq *pipeline.Request, logger zerolog.Logger) (*http.Response, error) {
+	routeModel := h.routeModel(pipeReq)
+	candidates, err := h.route(ctx, routeModel, pipeReq, logger)
+	if err != nil {
+		return nil, err
+	}
+	provider := candidates[0]
+	h.useProviderModel(pipeReq, routeModel, provider)
+
+	key, ok := h.pickKey(provider)
+	if !ok {
+		return nil, fmt.Errorf("no usable API key for provider %q", provider.Name)
+	}
+	if err := h.paceUpstream(ctx, provider.Name, key.Secret, pipeReq, logger); err != nil {
+		return nil, err
+	}
+	call := h.forwardAttempt(ctx, pipeReq, routeModel, provider, key, nil, logger)
+	if call.err != nil {
+		return nil, call.err
+	}
+	h.observeQuota(provider.Name, key.Secret, call.resp)
+	pipeReq.ProviderKey = key.ID
+	return call.resp, nil
+}
+
+// spendMiddlewares names the middleware that admit and charge a request
+// against budgets and rate limits. Upstream calls made outside the main
+// chain, such as cascade attempts and embeddings, run just these.
+var spendMiddlewares = map[string]bool{"budget": true, "ratelimit": true}
+
+// spendChain returns a chain of the handler's budget and rate limit
+// middleware, in their configured order.
+func (h *ProxyHandler) spendChain() *pipeline.Chain {
+	var mws []pipeline.Middleware
+	for _, mw := range h.chain.Middlewares() {
+		if spendMiddlewares[mw.Name()] {
+			mws = append(mws, mw)
+		}
+	}
+	return pipeline.NewChain(mws...)
+}
+
+// writeChainError writes the response for an error from the pipeline's
+// request phase: 429 for budget and rate limits, 403 for policy denials,
+// nothing when the client went away while queued, and 500 otherwise.
+func (h *ProxyHandler) writeChainError(w http.ResponseWriter, err error, logger zerolog.Logger) {
+	// Check for budget exceeded error -> return 429.
+	var budgetErr *security.BudgetError
+	if errors.As(err, &budgetErr) {
+		logger.Warn().Str("scope", budgetErr.Scope).Str("period", budgetErr.Period).Float64("spent", budgetErr.Spent).Float64("limit", budgetErr.Limit).Msg("budget limit exceeded")
+		if h.collector != nil {
+			h.collector.RecordError("budget", "", http.StatusTooManyRequests)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write(budgetErr.ToJSON())
+		return
+	}
+	// Check for policy denial -> return 403.
+	var policyErr *security.PolicyError
+	if errors.As(err, &policyErr) {
+		logger.Warn().Str("rule", policyErr.Rule).Msg("request denied by policy")
+		if h.collector != nil {
+			h.collector.RecordError("policy", "", http.StatusForbidden)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write(policyErr.ToJSON())
+		return
+	}
+	// Check for rate limit exceeded error -> return 429.
+	var rateLimitErr *security.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		logger.Warn().Str("provider", rateLimitErr.Provider).Str("reason", rateLimitErr.Reason).Float64("rate", rateLimitErr.Rate).Msg("rate limit exceeded")
+		if h.collector != nil {
+			h.collector.RecordError("ratelimit", rateLimitErr.Provider, http.StatusTooManyRequests)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", fmt.Sprintf("%.0f", math.Ceil(rateLimitErr.RetryAfter)))
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write(rateLimitErr.ToJSON())
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		logger.Debug().Err(err).Msg("client gave up while queued")
+		return
+	}
+	logger.Error().Err(err).Msg("pipeline request processing failed")
+	if h.collector != nil {
+		h.collector.RecordError("pipeline", "", http.StatusInternalServerError)
+	}
+	writeJSONError(w, http.StatusInternalServerError, "internal pipeline error")
+}
+
 // HandleRequest is the main proxy handler. It processes incoming API requests
 // through the pipeline chain, forwards them to the upstream provider, and
 // returns the response to the client.
@@ -236,8 +677,12 @@ func (h *ProxyHandler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 	// Step 1: Generate a unique request ID.
 	requestID := uuid.New().String()
 
-	// Extract project header for per-project tracking.
+	// Extract project header for per-project tracking. A project bound to
+	// the caller's client certificate takes precedence over the header.
 	project := r.Header.Get("X-Tokenman-Project")
+	if id := auth.IdentityFromContext(ctx); id != nil && id.Project != "" {
+		project = id.Project
+	}
 	if project == "" {
 		project = "default"
 	}
@@ -288,17 +733,7 @@ func (h *ProxyHandler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	var pipeReq *pipeline.Request
-	switch format {
-	case pipeline.FormatAnthropic:
-		pipeReq, err = ParseAnthropicRequest(body)
-	case pipeline.FormatOpenAI:
-		pipeReq, err = ParseOpenAIRequest(body)
-	default:
-		writeJSONError(w, http.StatusBadRequest, "unsupported API format")
-		return
-	}
-
+	pipeReq, err := parseRequest(format, body, r.URL.Path)
 	if err != nil {
 		logger.Error().Err(err).Msg("failed to parse request")
 		if h.collector != nil {
@@ -310,17 +745,42 @@ func (h *ProxyHandler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 
 	pipeReq.ID = requestID
 	pipeReq.ReceivedAt = startTime
+	pipeReq.Project = project
+
+	// Resolve aliases and routing rules to the concrete model before the
+	// allow-list, token counting, and pipeline see it, and inject the
+	// provider name into metadata so middleware (e.g. rate limiting) can use
+	// it instead of model-prefix heuristics.
+	pipeReq.RequestedModel = pipeReq.Model
+	casc := h.matchCascade(project, pipeReq, logger)
+	if resolved, model, resolveErr := h.router.ResolveModel(pipeReq.Model); resolveErr == nil {
+		if model != pipeReq.Model {
+			logger.Debug().Str("requested_model", pipeReq.Model).Str("model", model).Str("provider", resolved.Name).Msg("model rewritten by routing")
+			pipeReq.Model = model
+		}
+		if pipeReq.Metadata == nil {
+			pipeReq.Metadata = make(map[string]interface{})
+		}
+		pipeReq.Metadata["provider"] = resolved.Name
+	}
+
+	// Attach the caller's virtual key identity and enforce its model allow-list.
+	if id := auth.IdentityFromContext(ctx); id != nil {
+		pipeReq.KeyID = id.KeyID
+		pipeReq.KeyOwner = id.Owner
+		if id.KeyID != "" {
+			logger = logger.With().Str("key_id", id.KeyID).Logger()
+		}
+		if !id.AllowsModel(pipeReq.Model) {
+			logger.Warn().Str("model", pipeReq.Model).Msg("model not allowed for key")
+			writeJSONError(w, http.StatusForbidden, fmt.Sprintf("model %q is not allowed for this key", pipeReq.Model))
+			return
+		}
+	}
 
 	// Count input tokens before pipeline processing.
 	if h.tokenizer != nil {
-		var msgs []tokenizer.Message
-		for _, m := range pipeReq.Messages {
-			msgs = append(msgs, tokenizer.Message{
-				Role:    m.Role,
-				Content: compress.ExtractText(m.Content),
-			})
-		}
-		pipeReq.TokensIn = h.tokenizer.CountMessages(pipeReq.Model, msgs)
+		pipeReq.TokensIn = h.countTokens(pipeReq).Total
 	}
 
 	// Copy relevant headers from the original request.
@@ -334,17 +794,22 @@ func (h *ProxyHandler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 		Str("model", pipeReq.Model).
 		Bool("stream", pipeReq.Stream).
 		Logger()
+	if pipeReq.RequestedModel != pipeReq.Model {
+		logger = logger.With().Str("requested_model", pipeReq.RequestedModel).Logger()
+	}
 
 	// Enrich the current trace span with request-level attributes.
 	tracing.SetRequestAttributes(ctx, requestID, pipeReq.Model, string(format), pipeReq.Stream)
 
-	// Resolve the provider name and inject it into metadata so middleware
-	// (e.g. rate limiting) can use it instead of model-prefix heuristics.
-	if resolved, resolveErr := h.router.Resolve(pipeReq.Model); resolveErr == nil {
-		if pipeReq.Metadata == nil {
-			pipeReq.Metadata = make(map[string]interface{})
+	// X-Tokenman-Max-Wait lets a client shorten how long (in seconds) the
+	// request may queue for rate limit capacity; "0" fails fast.
+	if mw := r.Header.Get("X-Tokenman-Max-Wait"); mw != "" {
+		if secs, parseErr := strconv.ParseFloat(mw, 64); parseErr == nil && secs >= 0 {
+			if pipeReq.Metadata == nil {
+				pipeReq.Metadata = make(map[string]interface{})
+			}
+			pipeReq.Metadata[security.MaxWaitMetadataKey] = time.Duration(secs * float64(time.Second))
 		}
-		pipeReq.Metadata["provider"] = resolved.Name
 	}
 
 	logger.Info().Msg("processing request")
@@ -355,44 +820,15 @@ func (h *ProxyHandler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 		Int("message_count", len(pipeReq.Messages)).
 		Int("body_size", len(body)).
 		Msg("parsed request")
-	logger.Trace().
-		Str("request_body", truncateBody(body, h.maxLogBody)).
-		Msg("raw request body")
+	h.traceBody(logger, "request_body", body, "raw request body")
 
-	// Step 4: Run the pipeline chain's request phase.
+	// Step 4: Run the pipeline chain's request phase. Resources middleware
+	// holds for the request (budget reservations) are released when the
+	// handler returns, even if the request fails before the response phase.
+	defer h.chain.Release(context.WithoutCancel(ctx), pipeReq)
 	pipeReq, cachedResp, err := h.chain.ProcessRequest(ctx, pipeReq)
 	if err != nil {
-		// Check for budget exceeded error -> return 429.
-		var budgetErr *security.BudgetError
-		if errors.As(err, &budgetErr) {
-			logger.Warn().Str("period", budgetErr.Period).Float64("spent", budgetErr.Spent).Float64("limit", budgetErr.Limit).Msg("budget limit exceeded")
-			if h.collector != nil {
-				h.collector.RecordError("budget", "", http.StatusTooManyRequests)
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("Retry-After", "60")
-			w.WriteHeader(http.StatusTooManyRequests)
-			_, _ = w.Write(budgetErr.ToJSON())
-			return
-		}
-		// Check for rate limit exceeded error -> return 429.
-		var rateLimitErr *security.RateLimitError
-		if errors.As(err, &rateLimitErr) {
-			logger.Warn().Str("provider", rateLimitErr.Provider).Float64("rate", rateLimitErr.Rate).Msg("rate limit exceeded")
-			if h.collector != nil {
-				h.collector.RecordError("ratelimit", rateLimitErr.Provider, http.StatusTooManyRequests)
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("Retry-After", fmt.Sprintf("%.0f", rateLimitErr.RetryAfter))
-			w.WriteHeader(http.StatusTooManyRequests)
-			_, _ = w.Write(rateLimitErr.ToJSON())
-			return
-		}
-		logger.Error().Err(err).Msg("pipeline request processing failed")
-		if h.collector != nil {
-			h.collector.RecordError("pipeline", "", http.StatusInternalServerError)
-		}
-		writeJSONError(w, http.StatusInternalServerError, "internal pipeline error")
+		h.writeChainError(w, err, logger)
 		return
 	}
 
@@ -422,20 +858,22 @@ func (h *ProxyHandler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 		}
 		if h.store != nil {
 			if err := h.store.InsertRequest(&store.Request{
-				ID:           requestID,
-				Timestamp:    startTime.UTC().Format(time.RFC3339),
-				Method:       r.Method,
-				Path:         r.URL.Path,
-				Format:       string(format),
-				Model:        pipeReq.Model,
-				TokensIn:     int64(pipeReq.TokensIn),
-				LatencyMs:    time.Since(startTime).Milliseconds(),
-				StatusCode:   cachedResp.StatusCode,
-				CacheHit:     true,
-				RequestType:  "cache_hit",
-				RequestBody:  bodyForStore(body, h.storeBody),
-				ResponseBody: bodyForStore(cachedResp.Body, h.storeBody),
-				Project:      project,
+				ID:             requestID,
+				Timestamp:      startTime.UTC().Format(time.RFC3339),
+				Method:         r.Method,
+				Path:           r.URL.Path,
+				Format:         string(format),
+				Model:          pipeReq.Model,
+				TokensIn:       int64(pipeReq.TokensIn),
+				LatencyMs:      time.Since(startTime).Milliseconds(),
+				StatusCode:     cachedResp.StatusCode,
+				CacheHit:       true,
+				RequestType:    "cache_hit",
+				RequestBody:    h.storedRequestBody(body, pipeReq),
+				ResponseBody:   h.storedBody(cachedResp.Body),
+				Project:        project,
+				KeyID:          pipeReq.KeyID,
+				RequestedModel: requestedModel(pipeReq),
 			}); err != nil {
 				logger.Error().Err(err).Msg("failed to persist request record")
 			}
@@ -449,28 +887,41 @@ func (h *ProxyHandler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 	logger.Debug().
 		Int("rebuilt_body_size", len(pipeReq.RawBody)).
 		Msg("rebuilt request body for upstream")
-	logger.Trace().
-		Str("rebuilt_body", truncateBody(pipeReq.RawBody, h.maxLogBody)).
-		Msg("upstream request body")
+	h.traceBody(logger, "rebuilt_body", pipeReq.RawBody, "upstream request body")
+
+	// Step 6: Wait for an upstream slot according to the request's priority
+	// class and project share.
+	if h.scheduler != nil {
+		var keyPriority string
+		if id := auth.IdentityFromContext(ctx); id != nil {
+			keyPriority = id.Priority
+		}
+		class := h.scheduler.Classify(r.Header.Get("X-Tokenman-Priority"), keyPriority, project)
+		release, schedErr := h.scheduler.Acquire(ctx, class, project, pipeReq.TokensIn+pipeReq.MaxTokens)
+		if schedErr != nil {
+			if errors.Is(schedErr, ErrSchedulerTimeout) {
+				logger.Warn().Str("priority", class).Msg("timed out waiting in priority queue")
+				if h.collector != nil {
+					h.collector.RecordError("scheduler", "", http.StatusTooManyRequests)
+				}
+				w.Header().Set("Retry-After", "1")
+				writeJSONError(w, http.StatusTooManyRequests, "timed out waiting for upstream capacity")
+				return
+			}
+			logger.Debug().Err(schedErr).Msg("client gave up while queued")
+			return
+		}
+		defer release()
+		logger = logger.With().Str("priority", class).Logger()
+	}
 
-	// Step 6 + 7: Resolve provider and forward with retry/fallback.
+	// Step 7: Resolve provider and forward with retry/fallback.
+	// A cascade tries its cheap model first and escalates on a bad answer.
 	var upstreamResp *http.Response
-
-	if h.cbRegistry != nil && h.retryConfig.MaxAttempts > 0 {
-		upstreamResp, err = h.forwardWithRetry(ctx, pipeReq, logger)
+	if casc != nil {
+		upstreamResp, err = h.forwardCascade(ctx, r, body, pipeReq, casc, logger)
 	} else {
-		provider, resolveErr := h.router.Resolve(pipeReq.Model)
-		if resolveErr != nil {
-			err = resolveErr
-		} else {
-			fwdCtx := ctx
-			if provider.Timeout > 0 && !pipeReq.Stream {
-				var cancel context.CancelFunc
-				fwdCtx, cancel = context.WithTimeout(ctx, provider.Timeout)
-				defer cancel()
-			}
-			upstreamResp, err = h.client.Forward(fwdCtx, pipeReq, provider.BaseURL, provider.APIKey)
-		}
+		upstreamResp, err = h.forward(ctx, pipeReq, logger)
 	}
 
 	if err != nil {
@@ -522,23 +973,31 @@ func (h *ProxyHandler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 			h.collector.Record(pipeReq, errResp)
 			h.collector.ObserveLatency("", pipeReq.Model, pipeReq.Stream, latency.Seconds())
 		}
+		h.finishCascade(casc, pipeReq, 0, 0)
+		cascadeOutcome, cascadeSavings := casc.result()
 
 		// Persist request record for upstream errors.
 		if h.store != nil {
 			if err := h.store.InsertRequest(&store.Request{
-				ID:           requestID,
-				Timestamp:    startTime.UTC().Format(time.RFC3339),
-				Method:       r.Method,
-				Path:         r.URL.Path,
-				Format:       string(format),
-				Model:        pipeReq.Model,
-				TokensIn:     int64(pipeReq.TokensIn),
-				LatencyMs:    time.Since(startTime).Milliseconds(),
-				StatusCode:   upstreamResp.StatusCode,
-				RequestType:  "upstream_error",
-				RequestBody:  bodyForStore(body, h.storeBody),
-				ResponseBody: bodyForStore(errBody, h.storeBody),
-				Project:      project,
+				ID:                requestID,
+				Timestamp:         startTime.UTC().Format(time.RFC3339),
+				Method:            r.Method,
+				Path:              r.URL.Path,
+				Format:            string(format),
+				Model:             pipeReq.Model,
+				TokensIn:          int64(pipeReq.TokensIn),
+				LatencyMs:         time.Since(startTime).Milliseconds(),
+				StatusCode:        upstreamResp.StatusCode,
+				RequestType:       "upstream_error",
+				RequestBody:       h.storedRequestBody(body, pipeReq),
+				ResponseBody:      h.storedBody(errBody),
+				Project:           project,
+				KeyID:             pipeReq.KeyID,
+				ProviderKey:       pipeReq.ProviderKey,
+				RequestedModel:    requestedModel(pipeReq),
+				Cascade:           casc.name(),
+				CascadeOutcome:    cascadeOutcome,
+				CascadeSavingsUSD: cascadeSavings,
 			}); err != nil {
 				logger.Error().Err(err).Msg("failed to persist request record")
 			}
@@ -597,25 +1056,28 @@ func (h *ProxyHandler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 		// Persist request record.
 		if h.store != nil {
 			if err := h.store.InsertRequest(&store.Request{
-				ID:           requestID,
-				Timestamp:    startTime.UTC().Format(time.RFC3339),
-				Method:       r.Method,
-				Path:         r.URL.Path,
-				Format:       string(format),
-				Model:        pipeReq.Model,
-				TokensIn:     int64(pipeReq.TokensIn),
-				TokensOut:    int64(pipeResp.TokensOut),
-				TokensCached: int64(pipeResp.TokensCached),
-				TokensSaved:  int64(pipeResp.TokensSaved),
-				CostUSD:      pipeResp.CostUSD,
-				SavingsUSD:   pipeResp.SavingsUSD,
-				LatencyMs:    pipeResp.Latency.Milliseconds(),
-				StatusCode:   pipeResp.StatusCode,
-				CacheHit:     pipeResp.CacheHit,
-				RequestType:  "normal",
-				Provider:     pipeResp.Provider,
-				RequestBody:  bodyForStore(body, h.storeBody),
-				Project:      project,
+				ID:             requestID,
+				Timestamp:      startTime.UTC().Format(time.RFC3339),
+				Method:         r.Method,
+				Path:           r.URL.Path,
+				Format:         string(format),
+				Model:          pipeReq.Model,
+				TokensIn:       int64(pipeReq.TokensIn),
+				TokensOut:      int64(pipeResp.TokensOut),
+				TokensCached:   int64(pipeResp.TokensCached),
+				TokensSaved:    int64(pipeResp.TokensSaved),
+				CostUSD:        pipeResp.CostUSD,
+				SavingsUSD:     pipeResp.SavingsUSD,
+				LatencyMs:      pipeResp.Latency.Milliseconds(),
+				StatusCode:     pipeResp.StatusCode,
+				CacheHit:       pipeResp.CacheHit,
+				RequestType:    "normal",
+				Provider:       pipeResp.Provider,
+				RequestBody:    h.storedRequestBody(body, pipeReq),
+				Project:        project,
+				KeyID:          pipeReq.KeyID,
+				ProviderKey:    pipeReq.ProviderKey,
+				RequestedModel: requestedModel(pipeReq),
 			}); err != nil {
 				logger.Error().Err(err).Msg("failed to persist request record")
 			}
@@ -672,9 +1134,7 @@ func (h *ProxyHandler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 		Int("status", pipeResp.StatusCode).
 		Int("response_body_size", len(respBody)).
 		Msg("upstream response received")
-	logger.Trace().
-		Str("response_body", truncateBody(respBody, h.maxLogBody)).
-		Msg("upstream response body")
+	h.traceBody(logger, "response_body", respBody, "upstream response body")
 
 	// Step 9: Run the pipeline chain's response phase.
 	pipeResp, err = h.chain.ProcessResponse(ctx, pipeReq, pipeResp)
@@ -688,6 +1148,13 @@ func (h *ProxyHandler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 	}
 	h.recordResponseTimings()
 
+	// Credit an accepted cheap answer with what the strong model would have cost.
+	h.finishCascade(casc, pipeReq, pipeResp.TokensOut, pipeResp.CostUSD)
+	cascadeOutcome, cascadeSavings := casc.result()
+	if cascadeSavings > 0 {
+		pipeResp.SavingsUSD += cascadeSavings
+	}
+
 	// Copy upstream response headers that are relevant.
 	for _, key := range []string{"X-Request-Id", "Request-Id"} {
 		if val := upstreamResp.Header.Get(key); val != "" {
@@ -703,26 +1170,32 @@ func (h *ProxyHandler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 	// Persist request record.
 	if h.store != nil {
 		if err := h.store.InsertRequest(&store.Request{
-			ID:           requestID,
-			Timestamp:    startTime.UTC().Format(time.RFC3339),
-			Method:       r.Method,
-			Path:         r.URL.Path,
-			Format:       string(format),
-			Model:        pipeReq.Model,
-			TokensIn:     int64(pipeReq.TokensIn),
-			TokensOut:    int64(pipeResp.TokensOut),
-			TokensCached: int64(pipeResp.TokensCached),
-			TokensSaved:  int64(pipeResp.TokensSaved),
-			CostUSD:      pipeResp.CostUSD,
-			SavingsUSD:   pipeResp.SavingsUSD,
-			LatencyMs:    pipeResp.Latency.Milliseconds(),
-			StatusCode:   pipeResp.StatusCode,
-			CacheHit:     pipeResp.CacheHit,
-			RequestType:  "normal",
-			Provider:     pipeResp.Provider,
-			RequestBody:  bodyForStore(body, h.storeBody),
-			ResponseBody: bodyForStore(respBody, h.storeBody),
-			Project:      project,
+			ID:                requestID,
+			Timestamp:         startTime.UTC().Format(time.RFC3339),
+			Method:            r.Method,
+			Path:              r.URL.Path,
+			Format:            string(format),
+			Model:             pipeReq.Model,
+			TokensIn:          int64(pipeReq.TokensIn),
+			TokensOut:         int64(pipeResp.TokensOut),
+			TokensCached:      int64(pipeResp.TokensCached),
+			TokensSaved:       int64(pipeResp.TokensSaved),
+			CostUSD:           pipeResp.CostUSD,
+			SavingsUSD:        pipeResp.SavingsUSD,
+			LatencyMs:         pipeResp.Latency.Milliseconds(),
+			StatusCode:        pipeResp.StatusCode,
+			CacheHit:          pipeResp.CacheHit,
+			RequestType:       "normal",
+			Provider:          pipeResp.Provider,
+			RequestBody:       h.storedRequestBody(body, pipeReq),
+			ResponseBody:      h.storedBody(respBody),
+			Project:           project,
+			KeyID:             pipeReq.KeyID,
+			ProviderKey:       pipeReq.ProviderKey,
+			RequestedModel:    requestedModel(pipeReq),
+			Cascade:           casc.name(),
+			CascadeOutcome:    cascadeOutcome,
+			CascadeSavingsUSD: cascadeSavings,
 		}); err != nil {
 			logger.Error().Err(err).Msg("failed to persist request record")
 		}
@@ -794,12 +1267,17 @@ func (h *ProxyHandler) HandleReady(w http.ResponseWriter, r *http.Request) {
 		checks = append(checks, checkResult{Name: "database", Status: "ok", Error: "no store configured"})
 	}
 
-	// Check provider availability.
-	if len(h.router.ListModels()) > 0 {
-		checks = append(checks, checkResult{Name: "providers", Status: "ok"})
-	} else {
+	// Check provider availability. With health checks, at least one
+	// provider must be healthy.
+	switch {
+	case len(h.router.ListModels()) == 0:
 		checks = append(checks, checkResult{Name: "providers", Status: "fail", Error: "no providers configured"})
 		allOK = false
+	case h.health != nil && !h.anyProviderHealthy():
+		checks = append(checks, checkResult{Name: "providers", Status: "fail", Error: "no healthy providers"})
+		allOK = false
+	default:
+		checks = append(checks, checkResult{Name: "providers", Status: "ok"})
 	}
 
 	status := http.StatusOK
@@ -819,6 +1297,17 @@ func (h *ProxyHandler) HandleReady(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(data)
 }
 
+// anyProviderHealthy reports whether health checks consider any enabled
+// provider healthy.
+func (h *ProxyHandler) anyProviderHealthy() bool {
+	for _, p := range h.router.Providers() {
+		if h.health.Healthy(p.Name) {
+			return true
+		}
+	}
+	return false
+}
+
 // HandleModels proxies the /v1/models request to the appropriate upstream provider.
 // It tries to resolve a default provider by checking common model names.
 func (h *ProxyHandler) HandleModels(w http.ResponseWriter, r *http.Request) {
@@ -851,6 +1340,8 @@ func (h *ProxyHandler) HandleModels(w http.ResponseWriter, r *http.Request) {
 		upstreamURL = baseURL + "/v1/models"
 	case pipeline.FormatOpenAI:
 		upstreamURL = baseURL + "/v1/models"
+	case pipeline.FormatGemini:
+		upstreamURL = baseURL + "/v1beta/models"
 	default:
 		upstreamURL = baseURL + "/v1/models"
 	}
@@ -868,6 +1359,8 @@ func (h *ProxyHandler) HandleModels(w http.ResponseWriter, r *http.Request) {
 		httpReq.Header.Set("anthropic-version", "2023-06-01")
 	case pipeline.FormatOpenAI:
 		httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+	case pipeline.FormatGemini:
+		httpReq.Header.Set("x-goog-api-key", apiKey)
 	default:
 		httpReq.Header.Set("Authorization", "Bearer "+apiKey)
 	}
@@ -896,11 +1389,56 @@ func (h *ProxyHandler) HandleModels(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if resp.StatusCode == http.StatusOK {
+		respBody = h.appendAliasModels(respBody, format)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(resp.StatusCode)
 	_, _ = w.Write(respBody)
 }
 
+// appendAliasModels adds the configured model aliases to an upstream model
+// list so clients can discover them. Bodies that do not parse as a model
+// list are returned unchanged.
+func (h *ProxyHandler) appendAliasModels(body []byte, format pipeline.APIFormat) []byte {
+	aliases := h.router.Aliases()
+	if len(aliases) == 0 {
+		return body
+	}
+	var list map[string]json.RawMessage
+	if err := json.Unmarshal(body, &list); err != nil {
+		return body
+	}
+	var data []json.RawMessage
+	if err := json.Unmarshal(list["data"], &data); err != nil {
+		return body
+	}
+	for _, alias := range aliases {
+		var entry map[string]interface{}
+		if format == pipeline.FormatAnthropic {
+			entry = map[string]interface{}{"type": "model", "id": alias, "display_name": alias}
+		} else {
+			entry = map[string]interface{}{"id": alias, "object": "model", "owned_by": "tokenman"}
+		}
+		raw, err := json.Marshal(entry)
+		if err != nil {
+			return body
+		}
+		data = append(data, raw)
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return body
+	}
+	list["data"] = encoded
+	out, err := json.Marshal(list)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
 // writeCachedResponse writes a pipeline.CachedResponse directly to the client.
 func writeCachedResponse(w http.ResponseWriter, cr *pipeline.CachedResponse) {
 	contentType := cr.ContentType
@@ -931,6 +1469,24 @@ func writeJSONError(w http.ResponseWriter, statusCode int, message string) {
 	_, _ = w.Write(data)
 }
 
+// parseRequest parses a request body in the given API format into a
+// normalized pipeline.Request. path is the request path, which carries the
+// model for Gemini.
+func parseRequest(format pipeline.APIFormat, body []byte, path string) (*pipeline.Request, error) {
+	switch format {
+	case pipeline.FormatAnthropic:
+		return ParseAnthropicRequest(body)
+	case pipeline.FormatOpenAI:
+		return ParseOpenAIRequest(body)
+	case pipeline.FormatGemini:
+		return ParseGeminiRequest(body, path)
+	case pipeline.FormatOpenAIResponses:
+		return ParseResponsesRequest(body)
+	default:
+		return nil, fmt.Errorf("unsupported API format %q", format)
+	}
+}
+
 // rebuildRequestBody serializes the modified pipeline.Request back to JSON
 // for forwarding to the upstream provider.
 func rebuildRequestBody(req *pipeline.Request) []byte {
@@ -939,6 +1495,12 @@ func rebuildRequestBody(req *pipeline.Request) []byte {
 		return rebuildAnthropicBody(req)
 	case pipeline.FormatOpenAI:
 		return rebuildOpenAIBody(req)
+	case pipeline.FormatGemini:
+		return rebuildGeminiBody(req)
+	case pipeline.FormatOpenAIResponses:
+		return rebuildResponsesBody(req)
+	case pipeline.FormatOpenAIEmbeddings:
+		return rebuildEmbeddingsBody(req)
 	default:
 		return req.RawBody
 	}
@@ -977,10 +1539,11 @@ func rebuildAnthropicBody(req *pipeline.Request) []byte {
 		for k, v := range req.Metadata {
 			// Skip internal keys.
 			if k == "cache_key" || k == "cached_response" || k == "cached_tokens_saved" ||
-				k == "pii_detections" || k == "pii_mapping" || k == "injection_detections" ||
+				k == "pii_detections" || k == "pii_mapping" || strings.HasPrefix(k, "injection_") ||
 				k == "request_type" || k == "original_model" || k == "provider" ||
 				strings.HasPrefix(k, "cache_") || strings.HasPrefix(k, "budget_") ||
-				strings.HasPrefix(k, "history_") || strings.HasPrefix(k, "rules_") {
+				strings.HasPrefix(k, "history_") || strings.HasPrefix(k, "rules_") ||
+				strings.HasPrefix(k, "ratelimit_") {
 				continue
 			}
 			filtered[k] = v
@@ -1020,6 +1583,137 @@ func rebuildOpenAIBody(req *pipeline.Request) []byte {
 	return data
 }
 
+// rebuildGeminiBody rebuilds the conversation, system instruction, tools,
+// and generation limits of a Gemini body, keeping other fields such as
+// safetySettings. The model is not part of the body; it goes in the URL.
+func rebuildGeminiBody(req *pipeline.Request) []byte {
+	var body map[string]interface{}
+	if err := json.Unmarshal(req.RawBody, &body); err != nil {
+		body = make(map[string]interface{})
+	}
+	rebuilt, err := router.BuildRequestBody(req, pipeline.FormatGemini)
+	if err != nil {
+		return req.RawBody
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(rebuilt, &fields); err != nil {
+		return req.RawBody
+	}
+	for _, key := range []string{"contents", "systemInstruction", "tools"} {
+		if v, ok := fields[key]; ok {
+			body[key] = v
+		} else {
+			delete(body, key)
+		}
+	}
+	if gen, ok := fields["generationConfig"].(map[string]interface{}); ok {
+		orig, _ := body["generationConfig"].(map[string]interface{})
+		if orig == nil {
+			orig = make(map[string]interface{})
+		}
+		for k, v := range gen {
+			orig[k] = v
+		}
+		body["generationConfig"] = orig
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return req.RawBody
+	}
+	return data
+}
+
+// rebuildResponsesBody rebuilds the input items, instructions, and
+// generation limits of a Responses API body. Tools, previous_response_id,
+// and other fields are kept as the client sent them.
+func rebuildResponsesBody(req *pipeline.Request) []byte {
+	var body map[string]interface{}
+	if err := json.Unmarshal(req.RawBody, &body); err != nil {
+		body = make(map[string]interface{})
+	}
+	body["model"] = req.Model
+	body["input"] = responsesInput(req.Messages)
+	body["stream"] = req.Stream
+	if req.System != "" {
+		body["instructions"] = req.System
+	} else {
+		delete(body, "instructions")
+	}
+	if req.MaxTokens > 0 {
+		body["max_output_tokens"] = req.MaxTokens
+	}
+	if req.Temperature != nil {
+		body["temperature"] = *req.Temperature
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return req.RawBody
+	}
+	return data
+}
+
+// responsesContentParts lists the content part types a Responses message
+// item can hold. A message whose only block is of another type is an
+// opaque item kept by ParseResponsesRequest.
+var responsesContentParts = map[string]bool{
+	"text": true, "input_text": true, "output_text": true, "refusal": true,
+	"input_image": true, "input_file": true, "input_audio": true,
+}
+
+// responsesInput converts normalized messages back into Responses input
+// items, reversing ParseResponsesRequest.
+func responsesInput(msgs []pipeline.Message) []interface{} {
+	items := make([]interface{}, 0, len(msgs))
+	for _, m := range msgs {
+		if m.Role == "tool" {
+			output := m.Content
+			if output == nil {
+				output = ""
+			}
+			items = append(items, map[string]interface{}{
+				"type": "function_call_output", "call_id": m.ToolCallID, "output": output,
+			})
+			continue
+		}
+
+		switch content := m.Content.(type) {
+		case nil, string:
+			if text, _ := content.(string); text != "" || len(m.ToolCalls) == 0 {
+				items = append(items, map[string]interface{}{"type": "message", "role": m.Role, "content": text})
+			}
+		default:
+			var parts []map[string]interface{}
+			data, _ := json.Marshal(content)
+			_ = json.Unmarshal(data, &parts)
+			if len(parts) == 1 {
+				if t, _ := parts[0]["type"].(string); !responsesContentParts[t] {
+					items = append(items, parts[0])
+					continue
+				}
+			}
+			textType := "input_text"
+			if m.Role == "assistant" {
+				textType = "output_text"
+			}
+			for _, part := range parts {
+				if part["type"] == "text" {
+					part["type"] = textType
+				}
+			}
+			if len(parts) > 0 {
+				items = append(items, map[string]interface{}{"type": "message", "role": m.Role, "content": parts})
+			}
+		}
+
+		for _, tc := range m.ToolCalls {
+			items = append(items, map[string]interface{}{
+				"type": "function_call", "call_id": tc.ID, "name": tc.Function.Name, "arguments": tc.Function.Arguments,
+			})
+		}
+	}
+	return items
+}
+
 // capCacheControlBlocks ensures no more than 4 cache_control blocks exist across
 // the entire request (system + messages), as required by the Anthropic API.
 // If the limit is exceeded, cache_control is removed from earlier system blocks
@@ -1063,8 +1757,22 @@ func capCacheControlBlocks(systemBlocks []pipeline.ContentBlock, messages []pipe
 }
 
 // extractResponseUsage parses the upstream response body to extract token usage
-// counts. It handles both Anthropic and OpenAI response formats.
+// counts. It handles Anthropic, OpenAI (Chat Completions and Responses), and
+// Gemini response formats.
 func extractResponseUsage(body []byte, format pipeline.APIFormat) (tokensOut, tokensCached int) {
+	if format == pipeline.FormatGemini {
+		var raw struct {
+			UsageMetadata struct {
+				CandidatesTokenCount    int `json:"candidatesTokenCount"`
+				CachedContentTokenCount int `json:"cachedContentTokenCount"`
+			} `json:"usageMetadata"`
+		}
+		if err := json.Unmarshal(body, &raw); err != nil {
+			return 0, 0
+		}
+		return raw.UsageMetadata.CandidatesTokenCount, raw.UsageMetadata.CachedContentTokenCount
+	}
+
 	var raw struct {
 		Usage json.RawMessage `json:"usage"`
 	}
@@ -1090,6 +1798,17 @@ func extractResponseUsage(body []byte, format pipeline.APIFormat) (tokensOut, to
 			return 0, 0
 		}
 		return usage.CompletionTokens, 0
+	case pipeline.FormatOpenAIResponses:
+		var usage struct {
+			OutputTokens       int `json:"output_tokens"`
+			InputTokensDetails struct {
+				CachedTokens int `json:"cached_tokens"`
+			} `json:"input_tokens_details"`
+		}
+		if err := json.Unmarshal(raw.Usage, &usage); err != nil {
+			return 0, 0
+		}
+		return usage.OutputTokens, usage.InputTokensDetails.CachedTokens
 	default:
 		return 0, 0
 	}
@@ -10,6 +10,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/allaspectsdev/tokenman/internal/auth"
+	"github.com/allaspectsdev/tokenman/internal/config"
 	"github.com/allaspectsdev/tokenman/internal/metrics"
 	"github.com/allaspectsdev/tokenman/internal/pipeline"
 	"github.com/allaspectsdev/tokenman/internal/router"
@@ -98,7 +100,7 @@ func newTestHandler(chain *pipeline.Chain, upstreamURL string) *ProxyHandler {
 // newTestServer creates a chi-based Server with the given handler and returns
 // a httptest.Server ready for requests.
 func newTestServer(handler *ProxyHandler) *httptest.Server {
-	srv := NewServer(handler, ":0", 0, 0, 0, false, "")
+	srv := NewServer(handler, ":0", 0, 0, 0, false, nil)
 	return httptest.NewServer(srv.Router())
 }
 
@@ -425,6 +427,96 @@ func TestRetry_Exhausted_Returns502(t *testing.T) {
 	}
 }
 
+func TestRetry_KeyPoolRotatesPastLimitedAndRevokedKeys(t *testing.T) {
+	var seen []string
+	upstream := mockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("x-api-key")
+		seen = append(seen, key)
+		w.Header().Set("Content-Type", "application/json")
+		switch key {
+		case "key-a":
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"error":"rate limited"}`))
+		case "key-b":
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid key"}`))
+		default:
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"id":"msg_pool","type":"message","role":"assistant","content":[{"type":"text","text":"ok"}],"model":"test-model","stop_reason":"end_turn"}`))
+		}
+	})
+	defer upstream.Close()
+
+	pool := router.NewKeyPool(router.KeyRoundRobin, []router.APIKey{
+		{ID: "a", Secret: "key-a"},
+		{ID: "b", Secret: "key-b"},
+		{ID: "c", Secret: "key-c"},
+	})
+	rtr := router.NewRouter(map[string]*router.ProviderConfig{
+		"test-provider": {
+			Name: "test-provider", BaseURL: upstream.URL, APIKey: "key-a", Keys: pool,
+			Format: pipeline.FormatAnthropic, Models: []string{"test-model"},
+			Enabled: true, Priority: 1,
+		},
+	}, nil, "test-provider", false)
+
+	collector := metrics.NewCollector()
+	cbRegistry := NewCircuitBreakerRegistry(5, 60*time.Second, 1)
+	retryConfig := RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	handler := NewProxyHandler(pipeline.NewChain(), NewUpstreamClient(), zerolog.Nop(), collector, tokenizer.New(), nil, 0, 0, 0, cbRegistry, retryConfig, rtr, 0, 0, false, 0)
+	ts := newTestServer(handler)
+	defer ts.Close()
+
+	reqBody := `{"model":"test-model","messages":[{"role":"user","content":"hello"}],"max_tokens":100}`
+	start := time.Now()
+	resp, err := http.Post(ts.URL+"/v1/messages", "application/json", strings.NewReader(reqBody))
+	if err != nil {
+		t.Fatalf("POST /v1/messages failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("status = %d; want %d; body = %s", resp.StatusCode, http.StatusOK, string(body))
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("rotation waited out Retry-After (%s)", elapsed)
+	}
+	if strings.Join(seen, ",") != "key-a,key-b,key-c" {
+		t.Errorf("keys tried = %v; want key-a, key-b, key-c", seen)
+	}
+
+	// Key a is benched and key b disabled, so only c is left.
+	for _, st := range pool.Status() {
+		switch st.ID {
+		case "a":
+			if st.BenchedUntil.IsZero() {
+				t.Error("rate-limited key a should be benched")
+			}
+		case "b":
+			if !st.Disabled {
+				t.Error("unauthorized key b should be disabled")
+			}
+		}
+	}
+	if key, ok := pool.Pick(nil); !ok || key.ID != "c" {
+		t.Errorf("Pick = %q, %v; want c", key.ID, ok)
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.PrometheusHandler(collector)(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`tokenman_provider_key_requests_total{key="a",provider="test-provider",status="rate_limited"} 1`,
+		`tokenman_provider_key_requests_total{key="b",provider="test-provider",status="unauthorized"} 1`,
+		`tokenman_provider_key_requests_total{key="c",provider="test-provider",status="success"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
+
 func TestReadinessProbe_Returns200WhenProvidersConfigured(t *testing.T) {
 	chain := pipeline.NewChain()
 	handler := newTestHandler(chain, "http://localhost:1234")
@@ -490,6 +582,89 @@ func (m *rateLimitExceededMiddleware) ProcessResponse(ctx context.Context, req *
 	return resp, nil
 }
 
+func TestRateLimitQueue_ClientGoneIsNotAnError(t *testing.T) {
+	rl := security.NewRateLimitMiddleware(config.RateLimitConfig{
+		Enabled:              true,
+		DefaultRate:          1000,
+		DefaultBurst:         1000,
+		DefaultMaxConcurrent: 1,
+		QueueSize:            10,
+		MaxWaitSeconds:       10,
+	})
+	// Another request holds the only slot, so ours queues.
+	holder := &pipeline.Request{ID: "holder", Model: "test-model"}
+	if _, err := rl.ProcessRequest(context.Background(), holder); err != nil {
+		t.Fatalf("admitting holder: %v", err)
+	}
+	defer rl.Release(context.Background(), holder)
+	handler := newTestHandler(pipeline.NewChain(rl), "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	reqBody := `{"model":"test-model","messages":[{"role":"user","content":"hello"}],"max_tokens":100}`
+	req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(reqBody)).WithContext(ctx)
+	w := httptest.NewRecorder()
+	handler.HandleRequest(w, req)
+
+	if w.Code == http.StatusInternalServerError || w.Body.Len() != 0 {
+		t.Errorf("response = %d %s; want nothing written for a client that left", w.Code, w.Body.String())
+	}
+	if out := metricsText(handler.collector); strings.Contains(out, `type="pipeline"`) {
+		t.Errorf("client that left was counted as a pipeline error:\n%s", out)
+	}
+}
+
+// --- Policy denial middleware ---
+
+type policyDeniedMiddleware struct{}
+
+func (m *policyDeniedMiddleware) Name() string  { return "test-policy" }
+func (m *policyDeniedMiddleware) Enabled() bool { return true }
+
+func (m *policyDeniedMiddleware) ProcessRequest(ctx context.Context, req *pipeline.Request) (*pipeline.Request, error) {
+	return nil, &security.PolicyError{Rule: "no-opus", Message: "project x may not use opus"}
+}
+
+func (m *policyDeniedMiddleware) ProcessResponse(ctx context.Context, req *pipeline.Request, resp *pipeline.Response) (*pipeline.Response, error) {
+	return resp, nil
+}
+
+func TestPolicyDenied_Returns403(t *testing.T) {
+	chain := pipeline.NewChain(&policyDeniedMiddleware{})
+	handler := newTestHandler(chain, "")
+	ts := newTestServer(handler)
+	defer ts.Close()
+
+	reqBody := `{"model":"test-model","messages":[{"role":"user","content":"hello"}],"max_tokens":100}`
+	resp, err := http.Post(ts.URL+"/v1/messages", "application/json", strings.NewReader(reqBody))
+	if err != nil {
+		t.Fatalf("POST /v1/messages failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("status = %d; want %d; body = %s", resp.StatusCode, http.StatusForbidden, string(body))
+	}
+
+	var result struct {
+		Error struct {
+			Type string `json:"type"`
+			Rule string `json:"rule"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		t.Fatalf("unmarshalling response %q: %v", string(body), err)
+	}
+	if result.Error.Type != "policy_violation" || result.Error.Rule != "no-opus" {
+		t.Errorf("error = %+v; want policy_violation from rule no-opus", result.Error)
+	}
+}
+
 func TestRateLimitExceeded_Returns429(t *testing.T) {
 	chain := pipeline.NewChain(&rateLimitExceededMiddleware{})
 	handler := newTestHandler(chain, "")
@@ -553,3 +728,110 @@ func TestInvalidRequestBody_Returns400(t *testing.T) {
 		t.Errorf("status = %d; want %d; body = %s", resp.StatusCode, http.StatusBadRequest, string(body))
 	}
 }
+
+func TestVirtualKey_ModelNotAllowed_Returns403(t *testing.T) {
+	chain := pipeline.NewChain()
+	handler := newTestHandler(chain, "")
+
+	reqBody := `{"model":"test-model","messages":[{"role":"user","content":"hello"}],"max_tokens":100}`
+	req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(reqBody))
+	req = req.WithContext(auth.WithIdentity(req.Context(), &auth.Identity{
+		KeyID:         "vk_test",
+		Scopes:        []string{auth.ScopeProxy},
+		AllowedModels: []string{"claude-*"},
+	}))
+	w := httptest.NewRecorder()
+	handler.HandleRequest(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d; want %d; body = %s", w.Code, http.StatusForbidden, w.Body.String())
+	}
+}
+
+func TestRebuildAnthropicBody_DropsInternalMetadata(t *testing.T) {
+	req := &pipeline.Request{
+		Format:    pipeline.FormatAnthropic,
+		Model:     "claude-sonnet-4-20250514",
+		MaxTokens: 100,
+		RawBody:   []byte(`{"metadata":{"user_id":"u1"}}`),
+		Metadata: map[string]interface{}{
+			"user_id":                   "u1",
+			"provider":                  "anthropic",
+			security.MaxWaitMetadataKey: 5 * time.Second,
+		},
+	}
+
+	var body struct {
+		Metadata map[string]interface{} `json:"metadata"`
+	}
+	if err := json.Unmarshal(rebuildRequestBody(req), &body); err != nil {
+		t.Fatalf("unmarshal rebuilt body: %v", err)
+	}
+	if len(body.Metadata) != 1 || body.Metadata["user_id"] != "u1" {
+		t.Errorf("metadata = %v, want only user_id", body.Metadata)
+	}
+}
+
+func TestBodySinks_RedactLogsAndStoredBodies(t *testing.T) {
+	h := newTestHandler(pipeline.NewChain(), "")
+	h.storeBody = true
+	h.SetBodySinks(BodySinks{
+		Log:      BodyRedacted,
+		Store:    BodyRedacted,
+		Redactor: security.NewRedactor(nil),
+	})
+	body := []byte(`{"messages":[{"role":"user","content":"key sk-abcdefghijklmnopqrstuvwxyz for bob@example.com"}]}`)
+
+	var buf strings.Builder
+	logger := zerolog.New(&buf).Level(zerolog.TraceLevel)
+	h.traceBody(logger, "request_body", body, "raw request body")
+	if strings.Contains(buf.String(), "sk-abc") || strings.Contains(buf.String(), "bob@example.com") {
+		t.Errorf("trace log leaks secrets: %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "[API_KEY_REDACTED]") {
+		t.Errorf("trace log = %s, want redaction marker", buf.String())
+	}
+
+	stored := h.storedBody(body)
+	if strings.Contains(stored, "bob@example.com") || !strings.Contains(stored, "[EMAIL_REDACTED]") {
+		t.Errorf("stored body = %s", stored)
+	}
+	if !json.Valid([]byte(stored)) {
+		t.Errorf("stored body is no longer JSON: %s", stored)
+	}
+
+	h.SetBodySinks(BodySinks{Log: BodyNone, Store: BodyNone})
+	buf.Reset()
+	h.traceBody(logger, "request_body", body, "raw request body")
+	if buf.Len() != 0 {
+		t.Errorf("log_body = none still logged: %s", buf.String())
+	}
+	if got := h.storedBody(body); got != "" {
+		t.Errorf("store_body_mode = none stored %q", got)
+	}
+}
+
+func TestBodySinks_StoreUpstreamBody(t *testing.T) {
+	h := newTestHandler(pipeline.NewChain(), "")
+	h.storeBody = true
+	h.SetBodySinks(BodySinks{StoreUpstream: true})
+
+	original := []byte(`{"model":"claude-opus-4","max_tokens":100,"messages":[{"role":"user","content":"hi bob@example.com"}]}`)
+	req := &pipeline.Request{
+		Format:    pipeline.FormatAnthropic,
+		Model:     "claude-sonnet-4-20250514",
+		MaxTokens: 100,
+		RawBody:   original,
+		Messages:  []pipeline.Message{{Role: "user", Content: "hi [EMAIL_1]"}},
+	}
+
+	stored := h.storedRequestBody(original, req)
+	if !strings.Contains(stored, "claude-sonnet-4-20250514") || strings.Contains(stored, "bob@example.com") {
+		t.Errorf("stored upstream body = %s", stored)
+	}
+
+	h.SetBodySinks(BodySinks{})
+	if got := h.storedRequestBody(original, req); got != string(original) {
+		t.Errorf("stored original body = %s", got)
+	}
+}
@@ -0,0 +1,295 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/allaspectsdev/tokenman/internal/metrics"
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+	"github.com/allaspectsdev/tokenman/internal/router"
+)
+
+// healthHistorySize is how many health checks are kept per provider for
+// uptime timelines.
+const healthHistorySize = 120
+
+// Sources of health observations.
+const (
+	healthSourceProbe   = "probe"
+	healthSourceRequest = "request"
+)
+
+// HealthCheckConfig configures active provider health checks. A provider
+// becomes unhealthy after UnhealthyThreshold consecutive failures, counting
+// both probes and proxied requests, and healthy again after
+// HealthyThreshold consecutive successes.
+type HealthCheckConfig struct {
+	Interval           time.Duration
+	Timeout            time.Duration
+	UnhealthyThreshold int
+	HealthyThreshold   int
+}
+
+// HealthChecker keeps a health state for every provider from periodic
+// probes and the outcome of proxied requests. Unhealthy providers are
+// tried after healthy ones, so traffic moves away from a failing provider
+// before its circuit breaker trips.
+type HealthChecker struct {
+	cfg       HealthCheckConfig
+	client    *UpstreamClient
+	collector *metrics.Collector
+	logger    zerolog.Logger
+
+	mu        sync.Mutex
+	providers map[string]*providerHealth
+	probes    []*router.ProviderConfig
+}
+
+type providerHealth struct {
+	status    router.ProviderStatus
+	successes int // consecutive
+	history   []router.HealthCheck
+}
+
+// NewHealthChecker creates a health checker for providers, all of which
+// start out healthy.
+func NewHealthChecker(cfg HealthCheckConfig, providers []*router.ProviderConfig, client *UpstreamClient, collector *metrics.Collector, logger zerolog.Logger) *HealthChecker {
+	hc := &HealthChecker{
+		cfg:       cfg,
+		client:    client,
+		collector: collector,
+		logger:    logger,
+		providers: make(map[string]*providerHealth, len(providers)),
+		probes:    providers,
+	}
+	now := time.Now()
+	for _, p := range providers {
+		hc.providers[p.Name] = &providerHealth{
+			status: router.ProviderStatus{Name: p.Name, Healthy: true, Since: now},
+		}
+		if collector != nil {
+			collector.SetProviderHealthy(p.Name, true)
+		}
+	}
+	return hc
+}
+
+// SetHealthChecker enables health-aware routing with hc.
+func (h *ProxyHandler) SetHealthChecker(hc *HealthChecker) {
+	h.health = hc
+}
+
+// Start probes every provider each interval until ctx is cancelled, and
+// returns a channel that is closed when it stops.
+func (hc *HealthChecker) Start(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(hc.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			hc.ProbeAll(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return done
+}
+
+// ProbeAll probes every provider concurrently and waits for the results.
+func (hc *HealthChecker) ProbeAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, p := range hc.probes {
+		wg.Add(1)
+		go func(p *router.ProviderConfig) {
+			defer wg.Done()
+			start := time.Now()
+			err := hc.probe(ctx, p)
+			if ctx.Err() != nil {
+				return
+			}
+			outcome := "ok"
+			if err != nil {
+				outcome = "fail"
+			}
+			if hc.collector != nil {
+				hc.collector.RecordHealthCheck(p.Name, outcome)
+			}
+			hc.record(p.Name, healthSourceProbe, time.Since(start), err)
+		}(p)
+	}
+	wg.Wait()
+}
+
+// probe checks one provider: a one-token completion on its probe model if
+// it has one, and otherwise a model listing. Rate-limited responses count as
+// healthy since the provider is answering. Providers with a key pool are
+// probed with a usable pooled key; one the provider rejects as unauthorized
+// is disabled, as it would be for a proxied request, rather than counted
+// against the provider.
+func (hc *HealthChecker) probe(ctx context.Context, p *router.ProviderConfig) error {
+	ctx, cancel := context.WithTimeout(ctx, hc.cfg.Timeout)
+	defer cancel()
+
+	key, pooled := p.Keys.Pick(nil)
+	if !pooled {
+		key.Secret = p.APIKey
+	}
+	var resp *http.Response
+	var err error
+	if p.ProbeModel != "" {
+		req := &pipeline.Request{
+			Format:    p.Format,
+			Model:     p.ProbeModel,
+			MaxTokens: 1,
+			Messages:  []pipeline.Message{{Role: "user", Content: "ping"}},
+		}
+		if req.RawBody, err = router.BuildRequestBody(req, p.Format); err != nil {
+			return fmt.Errorf("building probe request: %w", err)
+		}
+		resp, err = hc.client.Forward(ctx, req, p.BaseURL, key.Secret)
+	} else {
+		resp, err = hc.listModels(ctx, p, key.Secret)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if pooled && resp.StatusCode == http.StatusUnauthorized {
+		hc.logger.Warn().Str("provider", p.Name).Str("key", key.ID).Msg("probe key rejected as unauthorized, disabling it")
+		p.Keys.Disable(key.ID)
+		return nil
+	}
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("upstream returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// listModels requests the provider's model list with apiKey.
+func (hc *HealthChecker) listModels(ctx context.Context, p *router.ProviderConfig, apiKey string) (*http.Response, error) {
+	path := "/v1/models"
+	if p.Format == pipeline.FormatGemini {
+		path = "/v1beta/models"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.BaseURL+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating models request: %w", err)
+	}
+	switch p.Format {
+	case pipeline.FormatAnthropic:
+		req.Header.Set("x-api-key", apiKey)
+		req.Header.Set("anthropic-version", "2023-06-01")
+	case pipeline.FormatGemini:
+		req.Header.Set("x-goog-api-key", apiKey)
+	default:
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := hc.client.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("listing models on %s: %w", p.Name, err)
+	}
+	return resp, nil
+}
+
+// ObserveRequest feeds the outcome of a proxied request into the provider's
+// health. Only transport errors and 5xx responses count as failures.
+func (hc *HealthChecker) ObserveRequest(provider string, latency time.Duration, resp *http.Response, err error) {
+	if err == nil && resp.StatusCode >= 500 {
+		err = fmt.Errorf("upstream returned status %d", resp.StatusCode)
+	}
+	hc.record(provider, healthSourceRequest, latency, err)
+}
+
+// record applies one observation to a provider's health. Every probe is
+// kept in the history; requests only when they change the provider's
+// health.
+func (hc *HealthChecker) record(provider, source string, latency time.Duration, err error) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	ph, ok := hc.providers[provider]
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	st := &ph.status
+	was := st.Healthy
+	if err != nil {
+		st.ErrorCount++
+		st.LastError = err.Error()
+		ph.successes = 0
+		if st.Healthy && st.ErrorCount >= hc.cfg.UnhealthyThreshold {
+			st.Healthy = false
+		}
+	} else {
+		st.ErrorCount = 0
+		ph.successes++
+		if !st.Healthy && ph.successes >= hc.cfg.HealthyThreshold {
+			st.Healthy = true
+		}
+	}
+	if source == healthSourceProbe {
+		st.LastCheck = now
+	}
+
+	changed := st.Healthy != was
+	if changed {
+		st.Since = now
+		if hc.collector != nil {
+			hc.collector.SetProviderHealthy(provider, st.Healthy)
+		}
+		event := hc.logger.Info()
+		if !st.Healthy {
+			event = hc.logger.Warn().Str("last_error", st.LastError)
+		}
+		event.Str("provider", provider).Bool("healthy", st.Healthy).Str("source", source).Msg("provider health changed")
+	}
+	if source == healthSourceProbe || changed {
+		check := router.HealthCheck{
+			Time:      now,
+			Source:    source,
+			OK:        err == nil,
+			Healthy:   st.Healthy,
+			LatencyMs: latency.Milliseconds(),
+		}
+		if err != nil {
+			check.Error = err.Error()
+		}
+		ph.history = append(ph.history, check)
+		if len(ph.history) > healthHistorySize {
+			ph.history = ph.history[len(ph.history)-healthHistorySize:]
+		}
+	}
+}
+
+// Healthy reports whether provider is healthy. Providers the checker does
+// not know about are considered healthy.
+func (hc *HealthChecker) Healthy(provider string) bool {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	ph, ok := hc.providers[provider]
+	return !ok || ph.status.Healthy
+}
+
+// ProviderStatus returns the health of provider and its recent checks,
+// oldest first.
+func (hc *HealthChecker) ProviderStatus(provider string) (router.ProviderStatus, []router.HealthCheck, bool) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	ph, ok := hc.providers[provider]
+	if !ok {
+		return router.ProviderStatus{}, nil, false
+	}
+	return ph.status, append([]router.HealthCheck(nil), ph.history...), true
+}
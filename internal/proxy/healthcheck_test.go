@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/allaspectsdev/tokenman/internal/metrics"
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+	"github.com/allaspectsdev/tokenman/internal/router"
+)
+
+func newTestHealthChecker(providers []*router.ProviderConfig, collector *metrics.Collector) *HealthChecker {
+	return NewHealthChecker(HealthCheckConfig{
+		Interval:           time.Hour,
+		Timeout:            time.Second,
+		UnhealthyThreshold: 2,
+		HealthyThreshold:   2,
+	}, providers, NewUpstreamClient(), collector, zerolog.Nop())
+}
+
+func TestHealthChecker_ThresholdsAndHistory(t *testing.T) {
+	var failing atomic.Bool
+	upstream := mockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" || r.Header.Get("x-api-key") != "test-key" {
+			t.Errorf("probe = %s %s; want an authenticated model listing", r.Method, r.URL.Path)
+		}
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	})
+	defer upstream.Close()
+
+	p := &router.ProviderConfig{Name: "anthropic", BaseURL: upstream.URL, APIKey: "test-key", Format: pipeline.FormatAnthropic, Enabled: true}
+	collector := metrics.NewCollector()
+	hc := newTestHealthChecker([]*router.ProviderConfig{p}, collector)
+	ctx := context.Background()
+
+	failing.Store(true)
+	hc.ProbeAll(ctx)
+	if !hc.Healthy("anthropic") {
+		t.Fatal("provider unhealthy after one failure; want threshold of 2")
+	}
+	hc.ProbeAll(ctx)
+	status, history, _ := hc.ProviderStatus("anthropic")
+	if status.Healthy || status.ErrorCount != 2 || !strings.Contains(status.LastError, "503") {
+		t.Fatalf("status = %+v; want unhealthy after 2 failed probes", status)
+	}
+	if len(history) != 2 || history[1].OK || history[1].Healthy {
+		t.Errorf("history = %+v; want two failed probes", history)
+	}
+
+	// Successful requests count towards recovery too.
+	ok := &http.Response{StatusCode: http.StatusOK}
+	hc.ObserveRequest("anthropic", 10*time.Millisecond, ok, nil)
+	hc.ObserveRequest("anthropic", 10*time.Millisecond, ok, nil)
+	status, history, _ = hc.ProviderStatus("anthropic")
+	if !status.Healthy {
+		t.Fatalf("status = %+v; want healthy after 2 successful requests", status)
+	}
+	if last := history[len(history)-1]; last.Source != "request" || !last.Healthy {
+		t.Errorf("last history entry = %+v; want the request that restored health", last)
+	}
+
+	out := metricsText(collector)
+	for _, want := range []string{
+		`tokenman_health_checks_total{outcome="fail",provider="anthropic"} 2`,
+		`tokenman_provider_healthy{provider="anthropic"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
+
+func TestHealthChecker_UnhealthyProviderSkipped(t *testing.T) {
+	var primaryCalls int32
+	primary := mockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/models" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		atomic.AddInt32(&primaryCalls, 1)
+		answer(w, "from primary")
+	})
+	defer primary.Close()
+	backup := mockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/models" {
+			_, _ = w.Write([]byte(`{"data":[]}`))
+			return
+		}
+		answer(w, "from backup")
+	})
+	defer backup.Close()
+
+	handler := newHedgeHandler(primary.URL, backup.URL, metrics.NewCollector())
+	hc := newTestHealthChecker(handler.router.Providers(), nil)
+	handler.SetHealthChecker(hc)
+	ts := newTestServer(handler)
+	defer ts.Close()
+
+	hc.ProbeAll(context.Background())
+	hc.ProbeAll(context.Background())
+	if body := postMessages(t, ts.URL); !strings.Contains(body, "from backup") {
+		t.Errorf("body = %s; want the healthy backup's response", body)
+	}
+	if n := atomic.LoadInt32(&primaryCalls); n != 0 {
+		t.Errorf("unhealthy primary called %d times; want it skipped", n)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.HandleReady(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("ready status = %d with a healthy backup; want 200", rec.Code)
+	}
+	hc.ObserveRequest("backup", time.Millisecond, nil, context.DeadlineExceeded)
+	hc.ObserveRequest("backup", time.Millisecond, nil, context.DeadlineExceeded)
+	rec = httptest.NewRecorder()
+	handler.HandleReady(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "no healthy providers") {
+		t.Errorf("ready = %d %s; want 503 with no healthy providers", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHealthChecker_ProbesWithPooledKey(t *testing.T) {
+	upstream := mockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "good-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	})
+	defer upstream.Close()
+
+	keys := []router.APIKey{{ID: "revoked", Secret: "revoked-key"}, {ID: "good", Secret: "good-key"}}
+	p := &router.ProviderConfig{
+		Name: "anthropic", BaseURL: upstream.URL, APIKey: keys[0].Secret, Keys: router.NewKeyPool("", keys),
+		Format: pipeline.FormatAnthropic, Enabled: true,
+	}
+	hc := newTestHealthChecker([]*router.ProviderConfig{p}, nil)
+	for i := 0; i < 4; i++ {
+		hc.ProbeAll(context.Background())
+	}
+
+	if status, _, _ := hc.ProviderStatus("anthropic"); !status.Healthy || status.ErrorCount != 0 {
+		t.Errorf("status = %+v; want healthy while a pooled key works", status)
+	}
+	if st := p.Keys.Status(); !st[0].Disabled || st[1].Disabled {
+		t.Errorf("key status = %+v; want only the revoked key disabled", st)
+	}
+}
@@ -0,0 +1,332 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+	"github.com/allaspectsdev/tokenman/internal/router"
+	"github.com/allaspectsdev/tokenman/internal/tokenizer"
+)
+
+// hedgeMinSamples is how many recent upstream calls a provider needs before
+// its p95 is trusted as the hedge delay.
+const hedgeMinSamples = 20
+
+// hedgeBudgetBurst caps how many unused hedges a provider can save up, so a
+// long quiet spell does not allow a burst of duplicates.
+const hedgeBudgetBurst = 10
+
+// HedgeConfig configures request hedging for non-streaming requests.
+type HedgeConfig struct {
+	// Delay is how long to wait for the primary's response headers before
+	// sending the hedge. Zero uses the primary's rolling p95 latency.
+	Delay time.Duration
+	// BudgetPercent caps hedges at this percentage of each provider's
+	// requests.
+	BudgetPercent float64
+}
+
+// HedgeBudget limits hedged requests to a fraction of each provider's
+// traffic. Every request forwarded to a provider earns it a fraction of a
+// hedge and every hedge sent on its behalf spends a whole one. It is safe
+// for concurrent use.
+type HedgeBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	tokens map[string]float64
+}
+
+// NewHedgeBudget creates a budget allowing hedges for percent% of requests.
+func NewHedgeBudget(percent float64) *HedgeBudget {
+	return &HedgeBudget{ratio: percent / 100, tokens: make(map[string]float64)}
+}
+
+// Deposit credits provider with one request's share of the budget.
+func (b *HedgeBudget) Deposit(provider string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.tokens[provider] + b.ratio
+	if t > hedgeBudgetBurst {
+		t = hedgeBudgetBurst
+	}
+	b.tokens[provider] = t
+}
+
+// Spend takes one hedge from provider's budget, reporting false when the
+// budget is exhausted.
+func (b *HedgeBudget) Spend(provider string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens[provider] < 1 {
+		return false
+	}
+	b.tokens[provider]--
+	return true
+}
+
+// SetHedging enables request hedging: a non-streaming request whose primary
+// provider is slow to answer is also sent to the next fallback provider, and
+// the first response wins.
+func (h *ProxyHandler) SetHedging(cfg HedgeConfig) {
+	h.hedge = cfg
+	h.hedgeBudget = NewHedgeBudget(cfg.BudgetPercent)
+}
+
+// upstreamCall is one in-flight upstream request. cancel releases its
+// context once the response is no longer needed.
+type upstreamCall struct {
+	provider *router.ProviderConfig
+	key      router.APIKey
+	req      *pipeline.Request
+	hedge    bool
+	cancel   context.CancelFunc
+
+	resp *http.Response
+	err  error
+}
+
+// ok reports whether the call produced a response worth returning to the
+// client rather than retrying.
+func (c *upstreamCall) ok() bool {
+	return c.err == nil && !isRetryableStatus(c.resp.StatusCode)
+}
+
+// discard cancels the call and closes any response body.
+func (c *upstreamCall) discard() {
+	c.cancel()
+	if c.resp != nil {
+		_ = c.resp.Body.Close()
+	}
+}
+
+// keep ties the call's context to the client request, since the response
+// body is read after the call returns.
+func (c *upstreamCall) keep(ctx context.Context) {
+	context.AfterFunc(ctx, c.cancel)
+}
+
+// startCall forwards req to p with key in the background, bounded by p's
+// timeout, and delivers the finished call on done.
+func (h *ProxyHandler) startCall(ctx context.Context, req *pipeline.Request, p *router.ProviderConfig, key router.APIKey, hedge bool, done chan<- *upstreamCall) *upstreamCall {
+	call := &upstreamCall{provider: p, key: key, req: req, hedge: hedge}
+	var callCtx context.Context
+	if p.Timeout > 0 && !req.Stream {
+		callCtx, call.cancel = context.WithTimeout(ctx, p.Timeout)
+	} else {
+		callCtx, call.cancel = context.WithCancel(ctx)
+	}
+	go func() {
+		start := time.Now()
+		call.resp, call.err = h.forwardTo(callCtx, req, p, key.Secret)
+		// A call cancelled because another answered first says nothing
+		// about the provider or key.
+		if call.err != nil && errors.Is(callCtx.Err(), context.Canceled) {
+			done <- call
+			return
+		}
+		h.observeUpstream(p.Name, start, call.resp, call.err)
+		h.reportKey(p, key, call.resp, call.err)
+		done <- call
+	}()
+	return call
+}
+
+// forwardAttempt forwards pipeReq to primary with key. When hedging is
+// enabled and the primary has not answered within the hedge delay, the same
+// request is also sent to the first usable provider in rest; whichever
+// answers first is returned and the other is cancelled.
+//
+// The returned call is the primary's unless the hedge won, in which case
+// pipeReq has been switched to the hedge's model and the hedge provider's
+// success already recorded.
+func (h *ProxyHandler) forwardAttempt(ctx context.Context, pipeReq *pipeline.Request, routeModel string, primary *router.ProviderConfig, key router.APIKey, rest []*router.ProviderConfig, logger zerolog.Logger) *upstreamCall {
+	done := make(chan *upstreamCall, 2)
+	// The call gets its own copy so that adopting a hedge's model cannot
+	// race with the primary still being sent.
+	primaryReq := *pipeReq
+	first := h.startCall(ctx, &primaryReq, primary, key, false, done)
+
+	delay := h.hedgeDelay(pipeReq, primary, rest)
+	if delay <= 0 {
+		c := <-done
+		c.keep(ctx)
+		return c
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var hedge *upstreamCall
+	for {
+		select {
+		case <-timer.C:
+			hedge = h.startHedge(ctx, pipeReq, routeModel, primary, rest, done, logger)
+			if hedge == nil {
+				c := <-done
+				c.keep(ctx)
+				return c
+			}
+		case c := <-done:
+			if hedge == nil {
+				// The primary answered before the hedge delay.
+				c.keep(ctx)
+				return c
+			}
+			if c.hedge {
+				if c.ok() && c.resp.StatusCode < 300 {
+					first.cancel()
+					go func() { (<-done).discard() }()
+					h.hedgeWon(pipeReq, c, primary, logger)
+					c.keep(ctx)
+					return c
+				}
+				c.discard()
+				h.hedgeFailed(primary, c)
+				p := <-done
+				p.keep(ctx)
+				return p
+			}
+			// The primary answered first, successfully or not. A failed
+			// primary still gives the hedge its chance.
+			if c.ok() {
+				hedge.cancel()
+				go func() { (<-done).discard() }()
+				h.recordHedge(primary.Name, "lost", hedge.req)
+				c.keep(ctx)
+				return c
+			}
+			hc := <-done
+			if hc.ok() && hc.resp.StatusCode < 300 {
+				c.discard()
+				h.recordFailure(primary)
+				h.hedgeWon(pipeReq, hc, primary, logger)
+				hc.keep(ctx)
+				return hc
+			}
+			hc.discard()
+			h.hedgeFailed(primary, hc)
+			c.keep(ctx)
+			return c
+		}
+	}
+}
+
+// hedgeDelay returns how long to wait for primary before hedging, or 0 when
+// the request must not be hedged. Every hedgeable request earns the
+// primary's hedge budget its share.
+func (h *ProxyHandler) hedgeDelay(pipeReq *pipeline.Request, primary *router.ProviderConfig, rest []*router.ProviderConfig) time.Duration {
+	if h.hedgeBudget == nil || pipeReq.Stream {
+		return 0
+	}
+	h.hedgeBudget.Deposit(primary.Name)
+	if len(rest) == 0 {
+		return 0
+	}
+	if h.hedge.Delay > 0 {
+		return h.hedge.Delay
+	}
+	if h.collector == nil {
+		return 0
+	}
+	health := h.collector.ProviderHealth(primary.Name)
+	if health.Samples < hedgeMinSamples || health.P95Ms <= 0 {
+		return 0
+	}
+	return time.Duration(health.P95Ms * float64(time.Millisecond))
+}
+
+// startHedge sends a copy of pipeReq to the first provider in rest whose
+// circuit is closed and that has a usable key and quota, if the primary's
+// hedge budget allows. It returns nil when no hedge was sent.
+func (h *ProxyHandler) startHedge(ctx context.Context, pipeReq *pipeline.Request, routeModel string, primary *router.ProviderConfig, rest []*router.ProviderConfig, done chan<- *upstreamCall, logger zerolog.Logger) *upstreamCall {
+	for _, p := range rest {
+		if h.cbRegistry != nil && h.cbRegistry.Get(p.Name).State() == CBOpen {
+			continue
+		}
+		key, ok := h.pickKey(p)
+		if !ok {
+			continue
+		}
+		req := *pipeReq
+		if model := h.router.ModelFor(routeModel, p.Name); model != req.Model {
+			req.Model = model
+			req.RawBody = rebuildRequestBody(&req)
+		}
+		if h.quota != nil && h.quota.Delay(p.Name, key.Secret, &req) > 0 {
+			continue
+		}
+		if !h.hedgeBudget.Spend(primary.Name) {
+			if h.collector != nil {
+				h.collector.RecordHedge(primary.Name, "budget_exhausted", 0)
+			}
+			return nil
+		}
+		logger.Debug().Str("provider", primary.Name).Str("hedge_provider", p.Name).Msg("primary slow, sending hedged request")
+		return h.startCall(ctx, &req, p, key, true, done)
+	}
+	return nil
+}
+
+// hedgeWon adopts the hedge's response for pipeReq and records the win and
+// the discarded primary call's cost. The request is switched to the hedge's
+// provider and key so that budgets, rate limits, and the stored request are
+// charged to the provider that answered.
+func (h *ProxyHandler) hedgeWon(pipeReq *pipeline.Request, hedge *upstreamCall, primary *router.ProviderConfig, logger zerolog.Logger) {
+	h.recordHedge(primary.Name, "won", pipeReq)
+	pipeReq.Model = hedge.req.Model
+	pipeReq.RawBody = hedge.req.RawBody
+	// The cancelled primary call may still hold the old metadata map.
+	metadata := make(map[string]interface{}, len(pipeReq.Metadata)+1)
+	for k, v := range pipeReq.Metadata {
+		metadata[k] = v
+	}
+	metadata["provider"] = hedge.provider.Name
+	pipeReq.Metadata = metadata
+	pipeReq.ProviderKey = hedge.key.ID
+	if h.cbRegistry != nil {
+		cb := h.cbRegistry.Get(hedge.provider.Name)
+		cb.RecordSuccess()
+		if h.collector != nil {
+			h.collector.SetCircuitState(hedge.provider.Name, float64(cb.State()))
+		}
+	}
+	if h.collector != nil {
+		h.collector.RecordProviderRequest(hedge.provider.Name, "success")
+	}
+	h.observeQuota(hedge.provider.Name, hedge.key.Secret, hedge.resp)
+	logger.Debug().Str("provider", primary.Name).Str("hedge_provider", hedge.provider.Name).Msg("hedged request answered first")
+}
+
+// hedgeFailed records a hedge that did not produce a usable response.
+func (h *ProxyHandler) hedgeFailed(primary *router.ProviderConfig, hedge *upstreamCall) {
+	h.recordFailure(hedge.provider)
+	h.recordHedge(primary.Name, "failed", hedge.req)
+}
+
+// recordFailure records a failed call to p that the retry loop will not see.
+func (h *ProxyHandler) recordFailure(p *router.ProviderConfig) {
+	if h.cbRegistry != nil {
+		cb := h.cbRegistry.Get(p.Name)
+		cb.RecordFailure()
+		if h.collector != nil {
+			h.collector.SetCircuitState(p.Name, float64(cb.State()))
+		}
+	}
+	if h.collector != nil {
+		h.collector.RecordProviderRequest(p.Name, "error")
+	}
+}
+
+// recordHedge counts a hedge outcome; discarded is the request whose call
+// was thrown away, priced at its input tokens.
+func (h *ProxyHandler) recordHedge(provider, outcome string, discarded *pipeline.Request) {
+	if h.collector == nil {
+		return
+	}
+	h.collector.RecordHedge(provider, outcome, tokenizer.EstimateCost(discarded.Model, discarded.TokensIn, 0))
+}
@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/allaspectsdev/tokenman/internal/metrics"
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+	"github.com/allaspectsdev/tokenman/internal/router"
+	"github.com/allaspectsdev/tokenman/internal/tokenizer"
+)
+
+func TestHedgeBudget_CapsHedgesPerProvider(t *testing.T) {
+	b := NewHedgeBudget(25)
+	for i := 0; i < 3; i++ {
+		b.Deposit("primary")
+	}
+	if b.Spend("primary") {
+		t.Fatal("Spend succeeded after 3 requests at 25%")
+	}
+	b.Deposit("primary")
+	if !b.Spend("primary") {
+		t.Fatal("Spend failed after 4 requests at 25%")
+	}
+	if b.Spend("primary") || b.Spend("other") {
+		t.Fatal("Spend should fail once the budget is used and for providers without one")
+	}
+
+	for i := 0; i < 1000; i++ {
+		b.Deposit("primary")
+	}
+	spent := 0
+	for b.Spend("primary") {
+		spent++
+	}
+	if spent != hedgeBudgetBurst {
+		t.Fatalf("saved hedges = %d; want burst cap %d", spent, hedgeBudgetBurst)
+	}
+}
+
+// newHedgeHandler returns a handler routing test-model to a primary and a
+// backup provider with fallback enabled.
+func newHedgeHandler(primaryURL, backupURL string, collector *metrics.Collector) *ProxyHandler {
+	rtr := router.NewRouter(map[string]*router.ProviderConfig{
+		"primary": {
+			Name: "primary", BaseURL: primaryURL, APIKey: "key-primary",
+			Format: pipeline.FormatAnthropic, Models: []string{"test-model"},
+			Enabled: true, Priority: 1, Timeout: 10 * time.Second,
+		},
+		"backup": {
+			Name: "backup", BaseURL: backupURL, APIKey: "key-backup",
+			Format: pipeline.FormatAnthropic, Models: []string{"test-model"},
+			Enabled: true, Priority: 2, Timeout: 10 * time.Second,
+		},
+	}, nil, "primary", true)
+	cbRegistry := NewCircuitBreakerRegistry(5, 60*time.Second, 1)
+	retryConfig := RetryConfig{MaxAttempts: 1, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	return NewProxyHandler(pipeline.NewChain(), NewUpstreamClient(), zerolog.Nop(), collector, tokenizer.New(), nil, 0, 0, 0, cbRegistry, retryConfig, rtr, 0, 0, false, 0)
+}
+
+func answer(w http.ResponseWriter, text string) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{"id":"msg_hedge","type":"message","role":"assistant","content":[{"type":"text","text":"` + text + `"}],"model":"test-model","stop_reason":"end_turn"}`))
+}
+
+func postMessages(t *testing.T, url string) string {
+	t.Helper()
+	reqBody := `{"model":"test-model","messages":[{"role":"user","content":"hello"}],"max_tokens":100}`
+	resp, err := http.Post(url+"/v1/messages", "application/json", strings.NewReader(reqBody))
+	if err != nil {
+		t.Fatalf("POST /v1/messages failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d; want 200; body = %s", resp.StatusCode, body)
+	}
+	return string(body)
+}
+
+func metricsText(collector *metrics.Collector) string {
+	rec := httptest.NewRecorder()
+	metrics.PrometheusHandler(collector)(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	return rec.Body.String()
+}
+
+// providerRecorder records the provider and provider key a request is
+// charged to in the response phase, where budgets settle.
+type providerRecorder struct {
+	provider, key string
+}
+
+func (m *providerRecorder) Name() string  { return "test-provider" }
+func (m *providerRecorder) Enabled() bool { return true }
+func (m *providerRecorder) ProcessRequest(ctx context.Context, req *pipeline.Request) (*pipeline.Request, error) {
+	return req, nil
+}
+func (m *providerRecorder) ProcessResponse(ctx context.Context, req *pipeline.Request, resp *pipeline.Response) (*pipeline.Response, error) {
+	m.provider, _ = req.Metadata["provider"].(string)
+	m.key = req.ProviderKey
+	return resp, nil
+}
+
+func TestHedge_SlowPrimaryLosesToHedge(t *testing.T) {
+	primaryCancelled := make(chan struct{})
+	primary := mockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices the client going away once the body is read.
+		_, _ = io.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+			close(primaryCancelled)
+		case <-time.After(5 * time.Second):
+			answer(w, "from primary")
+		}
+	})
+	defer primary.Close()
+	backup := mockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		answer(w, "from backup")
+	})
+	defer backup.Close()
+
+	collector := metrics.NewCollector()
+	handler := newHedgeHandler(primary.URL, backup.URL, collector)
+	handler.SetHedging(HedgeConfig{Delay: 50 * time.Millisecond, BudgetPercent: 100})
+	recorder := &providerRecorder{}
+	handler.chain = pipeline.NewChain(recorder)
+	ts := newTestServer(handler)
+	defer ts.Close()
+
+	start := time.Now()
+	body := postMessages(t, ts.URL)
+	if !strings.Contains(body, "from backup") {
+		t.Errorf("body = %s; want the hedge's response", body)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("hedged request took %s; want well under the primary's delay", elapsed)
+	}
+	select {
+	case <-primaryCancelled:
+	case <-time.After(2 * time.Second):
+		t.Error("losing primary request was not cancelled")
+	}
+
+	if !strings.Contains(metricsText(collector), `tokenman_hedges_total{outcome="won",provider="primary"} 1`) {
+		t.Error("metrics missing hedge win for primary")
+	}
+	if recorder.provider != "backup" || recorder.key != router.KeyFingerprint("key-backup") {
+		t.Errorf("request charged to provider %q key %q; want the hedge provider backup", recorder.provider, recorder.key)
+	}
+}
+
+func TestHedge_BudgetExhaustedWaitsForPrimary(t *testing.T) {
+	primary := mockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+		answer(w, "from primary")
+	})
+	defer primary.Close()
+	var backupCalls int32
+	backup := mockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&backupCalls, 1)
+		answer(w, "from backup")
+	})
+	defer backup.Close()
+
+	collector := metrics.NewCollector()
+	handler := newHedgeHandler(primary.URL, backup.URL, collector)
+	handler.SetHedging(HedgeConfig{Delay: 20 * time.Millisecond, BudgetPercent: 0})
+	ts := newTestServer(handler)
+	defer ts.Close()
+
+	if body := postMessages(t, ts.URL); !strings.Contains(body, "from primary") {
+		t.Errorf("body = %s; want the primary's response", body)
+	}
+	if n := atomic.LoadInt32(&backupCalls); n != 0 {
+		t.Errorf("backup called %d times; want no hedge without budget", n)
+	}
+	if !strings.Contains(metricsText(collector), `tokenman_hedges_total{outcome="budget_exhausted",provider="primary"} 1`) {
+		t.Error("metrics missing budget_exhausted hedge outcome")
+	}
+}
+
+func TestHedge_PrimaryWinsCancelsHedge(t *testing.T) {
+	primary := mockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		answer(w, "from primary")
+	})
+	defer primary.Close()
+	backup := mockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+			answer(w, "from backup")
+		}
+	})
+	defer backup.Close()
+
+	collector := metrics.NewCollector()
+	handler := newHedgeHandler(primary.URL, backup.URL, collector)
+	handler.SetHedging(HedgeConfig{Delay: 20 * time.Millisecond, BudgetPercent: 100})
+	ts := newTestServer(handler)
+	defer ts.Close()
+
+	if body := postMessages(t, ts.URL); !strings.Contains(body, "from primary") {
+		t.Errorf("body = %s; want the primary's response", body)
+	}
+	if !strings.Contains(metricsText(collector), `tokenman_hedges_total{outcome="lost",provider="primary"} 1`) {
+		t.Error("metrics missing lost hedge outcome")
+	}
+}
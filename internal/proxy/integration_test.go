@@ -79,7 +79,7 @@ func setupIntegration(t *testing.T, upstreamHandler http.HandlerFunc) (*Server,
 		0,     // no log body limit
 	)
 
-	srv := NewServer(handler, ":0", 0, 0, 0, false, "")
+	srv := NewServer(handler, ":0", 0, 0, 0, false, nil)
 	return srv, upstream
 }
 
@@ -396,7 +396,7 @@ func TestIntegration_RequestPersistence(t *testing.T) {
 	handler := NewProxyHandler(chain, NewUpstreamClient(), logger, collector, nil, st,
 		10<<20, 0, 0, nil, RetryConfig{}, rtr, 0, 0, false, 0)
 
-	srv := NewServer(handler, ":0", 0, 0, 0, false, "")
+	srv := NewServer(handler, ":0", 0, 0, 0, false, nil)
 
 	body := `{"model":"claude-sonnet-4-20250514","messages":[{"role":"user","content":"Hi"}],"max_tokens":100,"stream":false}`
 	req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(body))
@@ -449,7 +449,7 @@ func TestIntegration_ProjectHeader(t *testing.T) {
 
 	handler := NewProxyHandler(chain, NewUpstreamClient(), logger, collector, nil, st,
 		10<<20, 0, 0, nil, RetryConfig{}, rtr, 0, 0, false, 0)
-	srv := NewServer(handler, ":0", 0, 0, 0, false, "")
+	srv := NewServer(handler, ":0", 0, 0, 0, false, nil)
 
 	body := `{"model":"claude-sonnet-4-20250514","messages":[{"role":"user","content":"Hi"}],"max_tokens":100,"stream":false}`
 	req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(body))
@@ -499,7 +499,7 @@ func TestIntegration_MaxBodySize(t *testing.T) {
 	handler := NewProxyHandler(chain, NewUpstreamClient(), logger, collector, nil, nil,
 		100, // 100 byte max body size
 		0, 0, nil, RetryConfig{}, rtr, 0, 0, false, 0)
-	srv := NewServer(handler, ":0", 0, 0, 0, false, "")
+	srv := NewServer(handler, ":0", 0, 0, 0, false, nil)
 
 	// Build a body larger than 100 bytes.
 	largeBody := `{"model":"claude-sonnet-4-20250514","messages":[{"role":"user","content":"` + strings.Repeat("A", 200) + `"}],"max_tokens":100,"stream":false}`
@@ -539,7 +539,7 @@ func TestIntegration_ResponseSizeLimit(t *testing.T) {
 		10<<20,
 		100, // 100 byte max response size
 		0, nil, RetryConfig{}, rtr, 0, 0, false, 0)
-	srv := NewServer(handler, ":0", 0, 0, 0, false, "")
+	srv := NewServer(handler, ":0", 0, 0, 0, false, nil)
 
 	body := `{"model":"claude-sonnet-4-20250514","messages":[{"role":"user","content":"Hi"}],"max_tokens":100,"stream":false}`
 	req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(body))
@@ -628,6 +628,93 @@ func setupIntegrationWithRetry(t *testing.T, upstreamHandler http.HandlerFunc) (
 		0,
 	)
 
-	srv := NewServer(handler, ":0", 0, 0, 0, false, "")
+	srv := NewServer(handler, ":0", 0, 0, 0, false, nil)
 	return srv, upstream
 }
+
+func TestIntegration_ModelAliasRouting(t *testing.T) {
+	okResp := `{"id":"msg_alias","type":"message","role":"assistant","content":[{"type":"text","text":"hi"}],"model":"claude-haiku-4"}`
+
+	var primaryModel, backupModel string
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		primaryModel, _ = body["model"].(string)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/models" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"data":[{"type":"model","id":"claude-haiku-4"}],"has_more":false}`))
+			return
+		}
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		backupModel, _ = body["model"].(string)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(okResp))
+	}))
+	defer backup.Close()
+
+	st, err := store.Open(filepath.Join(t.TempDir(), "alias.db"))
+	if err != nil {
+		t.Fatalf("store.Open: %v", err)
+	}
+	defer st.Close()
+
+	rtr := router.NewRouter(map[string]*router.ProviderConfig{
+		"primary": {
+			Name: "primary", BaseURL: primary.URL, APIKey: "test-key",
+			Format: pipeline.FormatAnthropic, Enabled: true, Priority: 1,
+		},
+		"backup": {
+			Name: "backup", BaseURL: backup.URL, APIKey: "test-key",
+			Format: pipeline.FormatAnthropic, Enabled: true, Priority: 2,
+		},
+	}, nil, "backup", true)
+	rtr.SetAliases(map[string]map[string]string{
+		"fast": {"primary": "claude-haiku-4-primary", "backup": "claude-haiku-4"},
+	})
+
+	handler := NewProxyHandler(pipeline.NewChain(), NewUpstreamClient(), zerolog.Nop(), metrics.NewCollector(), nil, st,
+		10<<20, 0, 0, NewCircuitBreakerRegistry(5, 10*time.Second, 1),
+		RetryConfig{MaxAttempts: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}, rtr, 0, 0, false, 0)
+	srv := NewServer(handler, ":0", 0, 0, 0, false, nil)
+
+	body := `{"model":"fast","messages":[{"role":"user","content":"Hi"}],"max_tokens":100}`
+	req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	srv.Router().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status: got %d, want %d (body: %s)", w.Code, http.StatusOK, w.Body.String())
+	}
+	if primaryModel != "claude-haiku-4-primary" || backupModel != "claude-haiku-4" {
+		t.Errorf("upstream models = %q, %q; want each provider's alias target", primaryModel, backupModel)
+	}
+
+	requests, err := st.ListRequests(10, 0)
+	if err != nil || len(requests) != 1 {
+		t.Fatalf("ListRequests: %d rows, err %v", len(requests), err)
+	}
+	if requests[0].Model != "claude-haiku-4" || requests[0].RequestedModel != "fast" {
+		t.Errorf("recorded model = %q, requested = %q; want claude-haiku-4, fast", requests[0].Model, requests[0].RequestedModel)
+	}
+
+	req = httptest.NewRequest("GET", "/v1/models", nil)
+	w = httptest.NewRecorder()
+	srv.Router().ServeHTTP(w, req)
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+		HasMore *bool `json:"has_more"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("decode models: %v (%s)", err, w.Body.String())
+	}
+	if len(list.Data) != 2 || list.Data[1].ID != "fast" || list.HasMore == nil {
+		t.Errorf("models = %s; want upstream models plus alias fast", w.Body.String())
+	}
+}
@@ -0,0 +1,270 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/allaspectsdev/tokenman/internal/auth"
+	"github.com/allaspectsdev/tokenman/internal/metrics"
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+)
+
+// Rate-limit dimensions reported by upstream providers.
+const (
+	QuotaRequests     = "requests"
+	QuotaTokens       = "tokens"
+	QuotaInputTokens  = "input_tokens"
+	QuotaOutputTokens = "output_tokens"
+)
+
+// lowQuotaFraction is the share of a window's limit below which requests
+// are spread evenly over the time left until the window resets.
+const lowQuotaFraction = 0.1
+
+// quotaWindow is the last known state of one rate-limit dimension. A
+// negative limit means the provider did not report it; a zero reset means
+// the window has no known end and is never paced.
+type quotaWindow struct {
+	limit     int64
+	remaining int64
+	reset     time.Time
+}
+
+type quotaKey struct {
+	provider string
+	key      string
+}
+
+// QuotaTracker keeps a per-provider, per-API-key view of upstream rate-limit
+// quota, fed from the rate-limit headers on every upstream response. It is
+// used to pace requests before the provider starts returning 429s.
+type QuotaTracker struct {
+	mu        sync.Mutex
+	windows   map[quotaKey]map[string]*quotaWindow
+	collector *metrics.Collector
+	maxDelay  time.Duration
+	now       func() time.Time
+}
+
+// NewQuotaTracker creates a tracker that publishes observed quota to
+// collector (which may be nil). maxDelay caps how long a single request is
+// held back; 0 disables pacing while still tracking quota.
+func NewQuotaTracker(collector *metrics.Collector, maxDelay time.Duration) *QuotaTracker {
+	return &QuotaTracker{
+		windows:   make(map[quotaKey]map[string]*quotaWindow),
+		collector: collector,
+		maxDelay:  maxDelay,
+		now:       time.Now,
+	}
+}
+
+// MaxDelay returns the longest pacing delay the tracker will impose.
+func (q *QuotaTracker) MaxDelay() time.Duration {
+	return q.maxDelay
+}
+
+// quotaKeyID returns a short, non-reversible identifier for an upstream API
+// key so quota can be reported per key without exposing it.
+func quotaKeyID(apiKey string) string {
+	if apiKey == "" {
+		return ""
+	}
+	return auth.HashKey(apiKey)[:8]
+}
+
+// Observe records the rate-limit headers from an upstream response.
+// Responses without rate-limit headers leave the tracked state unchanged.
+func (q *QuotaTracker) Observe(provider, apiKey string, header http.Header) {
+	if header == nil {
+		return
+	}
+	parsed := parseRateLimitHeaders(header, q.now())
+	if len(parsed) == 0 {
+		return
+	}
+
+	k := quotaKey{provider: provider, key: quotaKeyID(apiKey)}
+	q.mu.Lock()
+	windows := q.windows[k]
+	if windows == nil {
+		windows = make(map[string]*quotaWindow)
+		q.windows[k] = windows
+	}
+	for dim, w := range parsed {
+		windows[dim] = w
+	}
+	q.mu.Unlock()
+
+	if q.collector != nil {
+		for dim, w := range parsed {
+			q.collector.SetProviderQuota(metrics.ProviderQuota{
+				Provider:  provider,
+				Key:       k.key,
+				Limit:     dim,
+				Max:       w.limit,
+				Remaining: w.remaining,
+				ResetAt:   w.reset,
+			})
+		}
+	}
+}
+
+// Delay returns how long req should wait before being sent to provider with
+// apiKey to stay within the last observed quota. It does not change the
+// tracked state; use Reserve once the request is committed to this provider.
+func (q *QuotaTracker) Delay(provider, apiKey string, req *pipeline.Request) time.Duration {
+	if q.maxDelay <= 0 {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.delayLocked(quotaKey{provider: provider, key: quotaKeyID(apiKey)}, req, false)
+}
+
+// Reserve returns the pacing delay for req like Delay and debits its
+// estimated cost from the tracked remaining quota, so concurrent requests
+// are spread out until the next response refreshes the real figures. The
+// returned delay is capped at MaxDelay.
+func (q *QuotaTracker) Reserve(provider, apiKey string, req *pipeline.Request) time.Duration {
+	if q.maxDelay <= 0 {
+		return 0
+	}
+	q.mu.Lock()
+	d := q.delayLocked(quotaKey{provider: provider, key: quotaKeyID(apiKey)}, req, true)
+	q.mu.Unlock()
+	if d > q.maxDelay {
+		d = q.maxDelay
+	}
+	return d
+}
+
+func (q *QuotaTracker) delayLocked(k quotaKey, req *pipeline.Request, debit bool) time.Duration {
+	now := q.now()
+	var delay time.Duration
+	for dim, w := range q.windows[k] {
+		if w.remaining < 0 || !w.reset.After(now) {
+			continue
+		}
+		need := quotaCost(dim, req)
+		untilReset := w.reset.Sub(now)
+
+		var d time.Duration
+		switch {
+		case w.remaining < need:
+			d = untilReset
+		case w.limit > 0 && float64(w.remaining) < float64(w.limit)*lowQuotaFraction:
+			// Spread what is left of the window evenly across the time
+			// remaining until it resets.
+			d = time.Duration(float64(untilReset) * float64(need) / float64(w.remaining))
+		}
+		if d > delay {
+			delay = d
+		}
+		if debit {
+			w.remaining -= need
+			if w.remaining < 0 {
+				w.remaining = 0
+			}
+		}
+	}
+	return delay
+}
+
+// quotaCost estimates how much of a rate-limit dimension req will consume.
+func quotaCost(dim string, req *pipeline.Request) int64 {
+	if req == nil {
+		return 1
+	}
+	var n int64
+	switch dim {
+	case QuotaTokens:
+		n = int64(req.TokensIn + req.MaxTokens)
+	case QuotaInputTokens:
+		n = int64(req.TokensIn)
+	case QuotaOutputTokens:
+		n = int64(req.MaxTokens)
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// parseRateLimitHeaders extracts rate-limit windows from Anthropic
+// (anthropic-ratelimit-<dim>-{limit,remaining,reset}, reset as RFC 3339) and
+// OpenAI (x-ratelimit-{limit,remaining,reset}-<dim>, reset as a duration
+// such as "6m0s") response headers.
+func parseRateLimitHeaders(h http.Header, now time.Time) map[string]*quotaWindow {
+	out := make(map[string]*quotaWindow)
+
+	for _, dim := range []string{QuotaRequests, QuotaTokens, QuotaInputTokens, QuotaOutputTokens} {
+		prefix := "Anthropic-Ratelimit-" + strings.ReplaceAll(dim, "_", "-") + "-"
+		w, ok := parseQuotaWindow(
+			h.Get(prefix+"Limit"),
+			h.Get(prefix+"Remaining"),
+			h.Get(prefix+"Reset"),
+			func(s string) (time.Time, bool) {
+				t, err := time.Parse(time.RFC3339, s)
+				return t, err == nil
+			},
+		)
+		if ok {
+			out[dim] = w
+		}
+	}
+
+	for _, dim := range []string{QuotaRequests, QuotaTokens} {
+		w, ok := parseQuotaWindow(
+			h.Get("X-Ratelimit-Limit-"+dim),
+			h.Get("X-Ratelimit-Remaining-"+dim),
+			h.Get("X-Ratelimit-Reset-"+dim),
+			func(s string) (time.Time, bool) {
+				d, ok := parseResetDuration(s)
+				return now.Add(d), ok
+			},
+		)
+		if ok {
+			out[dim] = w
+		}
+	}
+
+	return out
+}
+
+// parseQuotaWindow builds a window from raw header values. A window is only
+// reported when the remaining count is present.
+func parseQuotaWindow(limit, remaining, reset string, parseReset func(string) (time.Time, bool)) (*quotaWindow, bool) {
+	if remaining == "" {
+		return nil, false
+	}
+	rem, err := strconv.ParseInt(strings.TrimSpace(remaining), 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	w := &quotaWindow{limit: -1, remaining: rem}
+	if lim, err := strconv.ParseInt(strings.TrimSpace(limit), 10, 64); err == nil {
+		w.limit = lim
+	}
+	if reset != "" {
+		if t, ok := parseReset(strings.TrimSpace(reset)); ok {
+			w.reset = t
+		}
+	}
+	return w, true
+}
+
+// parseResetDuration parses OpenAI-style reset values, which are Go-like
+// durations ("1s", "6m0s", "20ms") or, from some compatible servers, a
+// plain number of seconds.
+func parseResetDuration(s string) (time.Duration, bool) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, true
+	}
+	if secs, err := strconv.ParseFloat(s, 64); err == nil && secs >= 0 {
+		return time.Duration(secs * float64(time.Second)), true
+	}
+	return 0, false
+}
@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/allaspectsdev/tokenman/internal/metrics"
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+)
+
+var quotaNow = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestQuotaTracker(collector *metrics.Collector, maxDelay time.Duration) *QuotaTracker {
+	q := NewQuotaTracker(collector, maxDelay)
+	q.now = func() time.Time { return quotaNow }
+	return q
+}
+
+func TestParseRateLimitHeaders_Anthropic(t *testing.T) {
+	h := http.Header{}
+	h.Set("anthropic-ratelimit-requests-limit", "50")
+	h.Set("anthropic-ratelimit-requests-remaining", "49")
+	h.Set("anthropic-ratelimit-requests-reset", "2026-03-01T12:00:30Z")
+	h.Set("anthropic-ratelimit-input-tokens-limit", "40000")
+	h.Set("anthropic-ratelimit-input-tokens-remaining", "39000")
+	h.Set("anthropic-ratelimit-input-tokens-reset", "2026-03-01T12:00:05Z")
+
+	got := parseRateLimitHeaders(h, quotaNow)
+	if len(got) != 2 {
+		t.Fatalf("parsed %d windows, want 2", len(got))
+	}
+	req := got[QuotaRequests]
+	if req.limit != 50 || req.remaining != 49 || !req.reset.Equal(quotaNow.Add(30*time.Second)) {
+		t.Errorf("requests window = %+v", req)
+	}
+	if in := got[QuotaInputTokens]; in == nil || in.remaining != 39000 {
+		t.Errorf("input_tokens window = %+v", in)
+	}
+}
+
+func TestParseRateLimitHeaders_OpenAI(t *testing.T) {
+	h := http.Header{}
+	h.Set("x-ratelimit-limit-requests", "500")
+	h.Set("x-ratelimit-remaining-requests", "499")
+	h.Set("x-ratelimit-reset-requests", "120ms")
+	h.Set("x-ratelimit-limit-tokens", "30000")
+	h.Set("x-ratelimit-remaining-tokens", "29000")
+	h.Set("x-ratelimit-reset-tokens", "6m0s")
+
+	got := parseRateLimitHeaders(h, quotaNow)
+	if r := got[QuotaRequests]; r == nil || r.remaining != 499 || !r.reset.Equal(quotaNow.Add(120*time.Millisecond)) {
+		t.Errorf("requests window = %+v", r)
+	}
+	if tk := got[QuotaTokens]; tk == nil || tk.limit != 30000 || !tk.reset.Equal(quotaNow.Add(6*time.Minute)) {
+		t.Errorf("tokens window = %+v", tk)
+	}
+}
+
+func TestParseRateLimitHeaders_NoHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("x-ratelimit-remaining-requests", "not-a-number")
+	if got := parseRateLimitHeaders(h, quotaNow); len(got) != 0 {
+		t.Errorf("parsed %d windows from invalid headers, want 0", len(got))
+	}
+}
+
+func TestQuotaTracker_PacesWhenLow(t *testing.T) {
+	q := newTestQuotaTracker(nil, 30*time.Second)
+	req := &pipeline.Request{TokensIn: 100, MaxTokens: 100}
+
+	h := http.Header{}
+	h.Set("x-ratelimit-limit-requests", "100")
+	h.Set("x-ratelimit-remaining-requests", "50")
+	h.Set("x-ratelimit-reset-requests", "10s")
+	q.Observe("openai", "sk-a", h)
+	if d := q.Delay("openai", "sk-a", req); d != 0 {
+		t.Errorf("delay with plenty of quota = %v, want 0", d)
+	}
+
+	// Below 10% of the limit the remaining 5 requests are spread over the
+	// 10 seconds left in the window.
+	h.Set("x-ratelimit-remaining-requests", "5")
+	q.Observe("openai", "sk-a", h)
+	if d := q.Delay("openai", "sk-a", req); d != 2*time.Second {
+		t.Errorf("delay with low quota = %v, want 2s", d)
+	}
+
+	// Exhausted: wait for the reset.
+	h.Set("x-ratelimit-remaining-requests", "0")
+	q.Observe("openai", "sk-a", h)
+	if d := q.Delay("openai", "sk-a", req); d != 10*time.Second {
+		t.Errorf("delay when exhausted = %v, want 10s", d)
+	}
+
+	// Quota is tracked per API key.
+	if d := q.Delay("openai", "sk-b", req); d != 0 {
+		t.Errorf("delay for another key = %v, want 0", d)
+	}
+}
+
+func TestQuotaTracker_ReserveDebitsAndCaps(t *testing.T) {
+	q := newTestQuotaTracker(nil, 5*time.Second)
+	req := &pipeline.Request{TokensIn: 600, MaxTokens: 400}
+
+	h := http.Header{}
+	h.Set("x-ratelimit-limit-tokens", "100000")
+	h.Set("x-ratelimit-remaining-tokens", "1500")
+	h.Set("x-ratelimit-reset-tokens", "1m0s")
+	q.Observe("openai", "sk-a", h)
+
+	// 1500 tokens left is below 10%, so the 1000-token request is paced
+	// (40s) and capped at the 5s maximum.
+	if d := q.Reserve("openai", "sk-a", req); d != 5*time.Second {
+		t.Errorf("first reserve = %v, want 5s", d)
+	}
+	// The first request's estimate was debited, leaving too little for a
+	// second one before the reset.
+	if d := q.Delay("openai", "sk-a", req); d != time.Minute {
+		t.Errorf("delay after debit = %v, want 1m", d)
+	}
+}
+
+func TestQuotaTracker_ExpiredWindowNotPaced(t *testing.T) {
+	q := newTestQuotaTracker(nil, 30*time.Second)
+
+	h := http.Header{}
+	h.Set("anthropic-ratelimit-requests-remaining", "0")
+	h.Set("anthropic-ratelimit-requests-reset", "2026-03-01T11:59:00Z")
+	q.Observe("anthropic", "sk-ant", h)
+
+	if d := q.Delay("anthropic", "sk-ant", &pipeline.Request{}); d != 0 {
+		t.Errorf("delay after window reset = %v, want 0", d)
+	}
+}
+
+func TestQuotaTracker_PublishesToCollector(t *testing.T) {
+	collector := metrics.NewCollector()
+	q := newTestQuotaTracker(collector, 0)
+
+	h := http.Header{}
+	h.Set("x-ratelimit-limit-requests", "500")
+	h.Set("x-ratelimit-remaining-requests", "42")
+	q.Observe("openai", "sk-secret", h)
+
+	quotas := collector.ProviderQuotas("openai")
+	if len(quotas) != 1 {
+		t.Fatalf("collector has %d quotas, want 1", len(quotas))
+	}
+	got := quotas[0]
+	if got.Limit != QuotaRequests || got.Max != 500 || got.Remaining != 42 {
+		t.Errorf("quota = %+v", got)
+	}
+	if got.Key == "" || got.Key == "sk-secret" {
+		t.Errorf("key = %q, want a fingerprint of the API key", got.Key)
+	}
+
+	// With pacing disabled, quota is tracked but never delays requests.
+	h.Set("x-ratelimit-remaining-requests", "0")
+	h.Set("x-ratelimit-reset-requests", "30s")
+	q.Observe("openai", "sk-secret", h)
+	if d := q.Reserve("openai", "sk-secret", &pipeline.Request{}); d != 0 {
+		t.Errorf("reserve with pacing disabled = %v, want 0", d)
+	}
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+	"github.com/allaspectsdev/tokenman/internal/router"
+	"github.com/allaspectsdev/tokenman/internal/tokenizer"
+)
+
+const responsesBody = `{
+	"model": "gpt-4.1",
+	"instructions": "Be brief.",
+	"previous_response_id": "resp_prev",
+	"input": [
+		{"role": "user", "content": [{"type": "input_text", "text": "Weather in Paris and Rome?"}]},
+		{"type": "reasoning", "id": "rs_1", "summary": []},
+		{"type": "function_call", "call_id": "call_1", "name": "get_weather", "arguments": "{\"city\":\"Paris\"}"},
+		{"type": "function_call", "call_id": "call_2", "name": "get_weather", "arguments": "{\"city\":\"Rome\"}"},
+		{"type": "function_call_output", "call_id": "call_1", "output": "18C"}
+	],
+	"tools": [{"type": "function", "name": "get_weather", "parameters": {"type": "object"}}, {"type": "web_search"}],
+	"max_output_tokens": 200,
+	"store": true
+}`
+
+func TestParseResponsesRequest(t *testing.T) {
+	req, err := ParseResponsesRequest([]byte(responsesBody))
+	if err != nil {
+		t.Fatalf("ParseResponsesRequest: %v", err)
+	}
+
+	if req.Format != pipeline.FormatOpenAIResponses || req.Model != "gpt-4.1" || req.System != "Be brief." || req.MaxTokens != 200 || req.PreviousResponseID != "resp_prev" {
+		t.Errorf("request = %+v; want model, instructions, limit, and previous response parsed", req)
+	}
+	if len(req.Messages) != 4 {
+		t.Fatalf("messages = %+v; want user text, reasoning item, merged calls, call output", req.Messages)
+	}
+	if blocks := req.Messages[0].Content.([]pipeline.ContentBlock); blocks[0].Type != "text" || blocks[0].Text != "Weather in Paris and Rome?" {
+		t.Errorf("user content = %+v; want input_text normalized to text", blocks)
+	}
+	if calls := req.Messages[2].ToolCalls; len(calls) != 2 || calls[1].ID != "call_2" {
+		t.Errorf("tool calls = %+v; want both function calls in one assistant turn", calls)
+	}
+	if out := req.Messages[3]; out.Role != "tool" || out.ToolCallID != "call_1" || out.Content != "18C" {
+		t.Errorf("call output = %+v; want a tool message for call_1", out)
+	}
+	if len(req.Tools) != 2 || req.Tools[0].Name != "get_weather" || req.Tools[1].Type != "web_search" {
+		t.Errorf("tools = %+v", req.Tools)
+	}
+
+	// Rebuilding restores the Responses item shapes and keeps other fields.
+	var got, want map[string]interface{}
+	if err := json.Unmarshal(rebuildRequestBody(req), &got); err != nil {
+		t.Fatalf("unmarshal rebuilt body: %v", err)
+	}
+	_ = json.Unmarshal([]byte(responsesBody), &want)
+	wantInput, _ := json.Marshal(want["input"])
+	gotInput, _ := json.Marshal(got["input"])
+	if !strings.Contains(string(gotInput), `"type":"input_text"`) || !strings.Contains(string(gotInput), `{"id":"rs_1","summary":[],"type":"reasoning"}`) {
+		t.Errorf("rebuilt input = %s; want %s", gotInput, wantInput)
+	}
+	for _, key := range []string{"previous_response_id", "store", "tools", "instructions"} {
+		g, _ := json.Marshal(got[key])
+		w, _ := json.Marshal(want[key])
+		if string(g) != string(w) {
+			t.Errorf("rebuilt %s = %s; want %s", key, g, w)
+		}
+	}
+
+	if got := DetectFormat(httptest.NewRequest(http.MethodPost, "/v1/responses", nil)); got != pipeline.FormatOpenAIResponses {
+		t.Errorf("DetectFormat(/v1/responses) = %s", got)
+	}
+}
+
+// serveResponses returns a proxy server with an OpenAI and an Anthropic
+// provider, both served by upstream.
+func serveResponses(t *testing.T, upstream http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	up := mockUpstream(t, upstream)
+	t.Cleanup(up.Close)
+	rtr := router.NewRouter(map[string]*router.ProviderConfig{
+		"openai": {
+			Name: "openai", BaseURL: up.URL, APIKey: "openai-key", Format: pipeline.FormatOpenAI,
+			Models: []string{"gpt-4.1"}, Enabled: true, Priority: 1,
+		},
+		"anthropic": {
+			Name: "anthropic", BaseURL: up.URL, APIKey: "anthropic-key", Format: pipeline.FormatAnthropic,
+			Models: []string{"claude-sonnet-4"}, Enabled: true, Priority: 2,
+		},
+	}, nil, "openai", false)
+	handler := NewProxyHandler(pipeline.NewChain(), NewUpstreamClient(), zerolog.Nop(), nil, tokenizer.New(), nil, 10<<20, 0, 0, nil, RetryConfig{}, rtr, 0, 0, false, 0)
+	ts := httptest.NewServer(NewServer(handler, ":0", 0, 0, 0, false, nil).Router())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+const responsesAnswer = `{"id":"resp_next","object":"response","status":"completed","model":"gpt-4.1","output":[{"type":"message","role":"assistant","content":[{"type":"output_text","text":"Bonjour","annotations":[]}]}],"usage":{"input_tokens":6,"input_tokens_details":{"cached_tokens":0},"output_tokens":3}}`
+
+func TestResponses_ForwardedToOpenAI(t *testing.T) {
+	ts := serveResponses(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path != "/v1/responses" || r.Header.Get("Authorization") != "Bearer openai-key" {
+			t.Errorf("upstream request = %s (auth %q); want an authenticated Responses call", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		if !strings.Contains(string(body), `"previous_response_id":"resp_prev"`) || !strings.Contains(string(body), `"input":[{"content":"Say hello in French","role":"user","type":"message"}]`) {
+			t.Errorf("upstream body = %s; want the chained input forwarded", body)
+		}
+		_, _ = w.Write([]byte(responsesAnswer))
+	})
+
+	body := post(t, ts.URL+"/v1/responses", `{"model":"gpt-4.1","previous_response_id":"resp_prev","input":"Say hello in French"}`)
+	if body != responsesAnswer {
+		t.Errorf("body = %s; want the Responses body unchanged", body)
+	}
+}
+
+func TestResponses_StreamForwarded(t *testing.T) {
+	ts := serveResponses(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, evt := range []string{
+			"event: response.created\ndata: {\"type\":\"response.created\",\"response\":{\"model\":\"gpt-4.1\"}}",
+			"event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"delta\":\"Bonjour\"}",
+			"event: response.completed\ndata: {\"type\":\"response.completed\",\"response\":{\"model\":\"gpt-4.1\",\"usage\":{\"output_tokens\":3}}}",
+		} {
+			_, _ = w.Write([]byte(evt + "\n\n"))
+			w.(http.Flusher).Flush()
+		}
+	})
+
+	body := post(t, ts.URL+"/v1/responses", `{"model":"gpt-4.1","input":"Say hello in French","stream":true}`)
+	for _, want := range []string{"event: response.output_text.delta", `"delta":"Bonjour"`, "event: response.completed"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("stream missing %s:\n%s", want, body)
+		}
+	}
+}
+
+func TestResponses_NotRoutedToOtherFormats(t *testing.T) {
+	ts := serveResponses(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected upstream call to %s", r.URL.Path)
+	})
+
+	resp, err := http.Post(ts.URL+"/v1/responses", "application/json", strings.NewReader(`{"model":"claude-sonnet-4","input":"Hi"}`))
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("status = %d; want 502 for a model only an Anthropic provider serves", resp.StatusCode)
+	}
+}
@@ -0,0 +1,268 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/allaspectsdev/tokenman/internal/config"
+	"github.com/allaspectsdev/tokenman/internal/metrics"
+)
+
+// Scheduler priority classes, highest first.
+const (
+	PriorityInteractive = "interactive"
+	PriorityDefault     = "default"
+	PriorityBatch       = "batch"
+)
+
+// priorityClasses lists the classes in the order they are served.
+var priorityClasses = [...]string{PriorityInteractive, PriorityDefault, PriorityBatch}
+
+// ErrSchedulerTimeout is returned by Scheduler.Acquire when a request waits
+// longer than the configured maximum for an upstream slot.
+var ErrSchedulerTimeout = errors.New("scheduler: timed out waiting for an upstream slot")
+
+// schedWaiter is a request queued for an upstream slot.
+type schedWaiter struct {
+	rank    int
+	project string
+	start   float64 // virtual start tag; lower is served first
+	seq     uint64  // arrival order, breaks start-tag ties
+	ready   chan struct{}
+	granted bool
+}
+
+// Scheduler limits how many requests are forwarded upstream at once and
+// decides who goes next when that limit is reached. Classes are served in
+// strict priority order; within a class, projects share slots by
+// start-time fair queuing, weighted by their configured weight and charged
+// by each request's estimated token cost.
+type Scheduler struct {
+	mu        sync.Mutex
+	cfg       config.SchedulerConfig
+	inFlight  int
+	queues    [len(priorityClasses)][]*schedWaiter
+	virtual   [len(priorityClasses)]float64
+	finish    [len(priorityClasses)]map[string]float64 // last finish tag per project
+	seq       uint64
+	collector *metrics.Collector
+}
+
+// NewScheduler creates a scheduler from cfg, reporting queue depth and wait
+// time to collector (which may be nil).
+func NewScheduler(cfg config.SchedulerConfig, collector *metrics.Collector) *Scheduler {
+	s := &Scheduler{cfg: cfg, collector: collector}
+	for i := range s.finish {
+		s.finish[i] = make(map[string]float64)
+	}
+	return s
+}
+
+// Reconfigure applies new scheduler settings. Raising MaxConcurrent admits
+// waiting requests immediately; lowering it lets in-flight requests finish.
+func (s *Scheduler) Reconfigure(cfg config.SchedulerConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+	s.dispatchLocked()
+}
+
+// Classify picks the priority class for a request. The X-Tokenman-Priority
+// header wins, except that it cannot raise a request above the class
+// assigned to its virtual key. Without a header, the key's class applies,
+// then the project's, then the configured default. The legacy header values
+// "high" and "low" map to interactive and batch.
+func (s *Scheduler) Classify(header, keyPriority, project string) string {
+	s.mu.Lock()
+	cfg := s.cfg
+	s.mu.Unlock()
+
+	switch header {
+	case "high":
+		header = PriorityInteractive
+	case "low":
+		header = PriorityBatch
+	}
+	if !config.IsPriorityClass(header) {
+		header = ""
+	}
+	if !config.IsPriorityClass(keyPriority) {
+		keyPriority = ""
+	}
+
+	switch {
+	case header != "" && keyPriority != "":
+		if priorityRank(header) < priorityRank(keyPriority) {
+			return keyPriority
+		}
+		return header
+	case header != "":
+		return header
+	case keyPriority != "":
+		return keyPriority
+	}
+	if p, ok := cfg.Projects[project]; ok && config.IsPriorityClass(p.Priority) {
+		return p.Priority
+	}
+	if config.IsPriorityClass(cfg.DefaultPriority) {
+		return cfg.DefaultPriority
+	}
+	return PriorityDefault
+}
+
+// priorityRank returns the serving order of class, 0 being served first.
+// Unknown classes rank as default.
+func priorityRank(class string) int {
+	for i, c := range priorityClasses {
+		if c == class {
+			return i
+		}
+	}
+	return 1
+}
+
+// Acquire blocks until the request may be forwarded upstream and returns a
+// function that frees its slot; the caller must call it once the upstream
+// exchange is finished. cost is the request's estimated token usage. It
+// returns ctx.Err() if the context ends first, or ErrSchedulerTimeout after
+// the configured maximum wait.
+func (s *Scheduler) Acquire(ctx context.Context, class, project string, cost int) (func(), error) {
+	if cost < 1 {
+		cost = 1
+	}
+	rank := priorityRank(class)
+	class = priorityClasses[rank]
+	started := time.Now()
+
+	s.mu.Lock()
+	w := &schedWaiter{rank: rank, project: project, seq: s.seq, ready: make(chan struct{})}
+	s.seq++
+	w.start = s.virtual[rank]
+	if f := s.finish[rank][project]; f > w.start {
+		w.start = f
+	}
+	s.finish[rank][project] = w.start + float64(cost)/s.weightLocked(project)
+	s.queues[rank] = append(s.queues[rank], w)
+	s.dispatchLocked()
+	maxWait := time.Duration(s.cfg.MaxWaitSeconds) * time.Second
+	s.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if maxWait > 0 {
+		timer := time.NewTimer(maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-w.ready:
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+		err = ErrSchedulerTimeout
+	}
+	if err != nil {
+		s.mu.Lock()
+		if !w.granted {
+			s.removeLocked(w)
+			s.mu.Unlock()
+			return nil, err
+		}
+		// Granted while giving up: hand the slot to the next request.
+		s.releaseLocked()
+		s.mu.Unlock()
+		return nil, err
+	}
+
+	if s.collector != nil {
+		s.collector.ObserveSchedulerWait(class, time.Since(started).Seconds())
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			s.releaseLocked()
+			s.mu.Unlock()
+		})
+	}, nil
+}
+
+// weightLocked returns the fair-share weight of project.
+func (s *Scheduler) weightLocked(project string) float64 {
+	if p, ok := s.cfg.Projects[project]; ok && p.Weight > 0 {
+		return p.Weight
+	}
+	return 1
+}
+
+// releaseLocked frees one in-flight slot and admits the next waiter.
+func (s *Scheduler) releaseLocked() {
+	s.inFlight--
+	s.dispatchLocked()
+}
+
+// dispatchLocked grants free slots to waiters: the highest non-empty class
+// first, and within it the waiter with the lowest start tag.
+func (s *Scheduler) dispatchLocked() {
+	for s.inFlight < s.cfg.MaxConcurrent {
+		w := s.nextLocked()
+		if w == nil {
+			break
+		}
+		s.removeLocked(w)
+		w.granted = true
+		s.inFlight++
+		s.virtual[w.rank] = w.start
+		close(w.ready)
+	}
+	// Forget projects with no backlog once a class drains so the finish
+	// map does not grow with every project name ever seen.
+	for rank, q := range s.queues {
+		if len(q) > 0 {
+			continue
+		}
+		for project, f := range s.finish[rank] {
+			if f <= s.virtual[rank] {
+				delete(s.finish[rank], project)
+			}
+		}
+	}
+	if s.collector != nil {
+		for rank, class := range priorityClasses {
+			s.collector.SetSchedulerQueueDepth(class, len(s.queues[rank]))
+		}
+	}
+}
+
+// nextLocked returns the waiter that should be served next, or nil.
+func (s *Scheduler) nextLocked() *schedWaiter {
+	for _, q := range s.queues {
+		var best *schedWaiter
+		for _, w := range q {
+			if best == nil || w.start < best.start || (w.start == best.start && w.seq < best.seq) {
+				best = w
+			}
+		}
+		if best != nil {
+			return best
+		}
+	}
+	return nil
+}
+
+// removeLocked deletes w from its class queue.
+func (s *Scheduler) removeLocked(w *schedWaiter) {
+	q := s.queues[w.rank]
+	for i, other := range q {
+		if other == w {
+			s.queues[w.rank] = append(q[:i], q[i+1:]...)
+			break
+		}
+	}
+	if s.collector != nil {
+		s.collector.SetSchedulerQueueDepth(priorityClasses[w.rank], len(s.queues[w.rank]))
+	}
+}
@@ -0,0 +1,198 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allaspectsdev/tokenman/internal/config"
+	"github.com/allaspectsdev/tokenman/internal/metrics"
+)
+
+type grant struct {
+	name    string
+	release func()
+}
+
+func testSchedulerConfig() config.SchedulerConfig {
+	return config.SchedulerConfig{
+		Enabled:         true,
+		MaxConcurrent:   1,
+		MaxWaitSeconds:  5,
+		DefaultPriority: PriorityDefault,
+	}
+}
+
+// enqueue starts an Acquire in the background and waits until it is queued.
+func enqueue(t *testing.T, s *Scheduler, grants chan<- grant, name, class, project string, cost int) {
+	t.Helper()
+	rank := priorityRank(class)
+	s.mu.Lock()
+	before := len(s.queues[rank])
+	s.mu.Unlock()
+
+	go func() {
+		release, err := s.Acquire(context.Background(), class, project, cost)
+		if err != nil {
+			t.Errorf("Acquire(%s): %v", name, err)
+			return
+		}
+		grants <- grant{name: name, release: release}
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		n := len(s.queues[rank])
+		s.mu.Unlock()
+		if n > before {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%s was never queued", name)
+}
+
+// drain releases the current holder and records the order in which the
+// queued requests are granted.
+func drain(t *testing.T, grants <-chan grant, holder func(), n int) string {
+	t.Helper()
+	release := holder
+	var order []string
+	for i := 0; i < n; i++ {
+		release()
+		select {
+		case g := <-grants:
+			order = append(order, g.name)
+			release = g.release
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no grant after %v", order)
+		}
+	}
+	release()
+	return strings.Join(order, ",")
+}
+
+func TestScheduler_Classify(t *testing.T) {
+	cfg := testSchedulerConfig()
+	cfg.Projects = map[string]config.ProjectSchedule{"agents": {Priority: PriorityBatch}}
+	s := NewScheduler(cfg, nil)
+
+	tests := []struct {
+		header, key, project, want string
+	}{
+		{"", "", "web", PriorityDefault},
+		{"", "", "agents", PriorityBatch},
+		{"", PriorityInteractive, "agents", PriorityInteractive},
+		{"interactive", "", "agents", PriorityInteractive},
+		{"high", "", "", PriorityInteractive},
+		{"low", "", "", PriorityBatch},
+		{"bogus", "", "agents", PriorityBatch},
+		// A header cannot raise a request above its key's class, but can lower it.
+		{"interactive", PriorityBatch, "", PriorityBatch},
+		{"batch", PriorityInteractive, "", PriorityBatch},
+	}
+	for _, tt := range tests {
+		if got := s.Classify(tt.header, tt.key, tt.project); got != tt.want {
+			t.Errorf("Classify(%q, %q, %q) = %q, want %q", tt.header, tt.key, tt.project, got, tt.want)
+		}
+	}
+}
+
+func TestScheduler_HigherClassServedFirst(t *testing.T) {
+	s := NewScheduler(testSchedulerConfig(), nil)
+	holder, err := s.Acquire(context.Background(), PriorityDefault, "p", 1)
+	if err != nil {
+		t.Fatalf("holder: %v", err)
+	}
+
+	grants := make(chan grant, 3)
+	enqueue(t, s, grants, "batch", PriorityBatch, "p", 1)
+	enqueue(t, s, grants, "default", PriorityDefault, "p", 1)
+	enqueue(t, s, grants, "interactive", PriorityInteractive, "p", 1)
+
+	if got, want := drain(t, grants, holder, 3), "interactive,default,batch"; got != want {
+		t.Errorf("grant order = %s, want %s", got, want)
+	}
+}
+
+func TestScheduler_FairShareBetweenProjects(t *testing.T) {
+	cfg := testSchedulerConfig()
+	cfg.Projects = map[string]config.ProjectSchedule{"b": {Weight: 2}}
+	s := NewScheduler(cfg, nil)
+	holder, err := s.Acquire(context.Background(), PriorityBatch, "other", 1)
+	if err != nil {
+		t.Fatalf("holder: %v", err)
+	}
+
+	// Project a floods the queue first; b, with twice the weight, still gets
+	// two requests through for each of a's.
+	grants := make(chan grant, 6)
+	for _, name := range []string{"a1", "a2", "a3"} {
+		enqueue(t, s, grants, name, PriorityBatch, "a", 100)
+	}
+	for _, name := range []string{"b1", "b2", "b3"} {
+		enqueue(t, s, grants, name, PriorityBatch, "b", 100)
+	}
+
+	if got, want := drain(t, grants, holder, 6), "a1,b1,b2,a2,b3,a3"; got != want {
+		t.Errorf("grant order = %s, want %s", got, want)
+	}
+}
+
+func TestScheduler_TimeoutAndCancel(t *testing.T) {
+	cfg := testSchedulerConfig()
+	cfg.MaxWaitSeconds = 0
+	s := NewScheduler(cfg, metrics.NewCollector())
+	holder, err := s.Acquire(context.Background(), PriorityDefault, "p", 1)
+	if err != nil {
+		t.Fatalf("holder: %v", err)
+	}
+	defer holder()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.Acquire(ctx, PriorityDefault, "p", 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("cancelled Acquire err = %v, want DeadlineExceeded", err)
+	}
+
+	s.Reconfigure(config.SchedulerConfig{MaxConcurrent: 1, MaxWaitSeconds: 1})
+	start := time.Now()
+	if _, err := s.Acquire(context.Background(), PriorityDefault, "p", 1); !errors.Is(err, ErrSchedulerTimeout) {
+		t.Errorf("Acquire err = %v, want ErrSchedulerTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("timed out after %v, want at least 1s", elapsed)
+	}
+
+	s.mu.Lock()
+	depth := len(s.queues[priorityRank(PriorityDefault)])
+	s.mu.Unlock()
+	if depth != 0 {
+		t.Errorf("queue depth = %d after abandoned waits, want 0", depth)
+	}
+}
+
+func TestScheduler_ReconfigureAdmitsWaiters(t *testing.T) {
+	s := NewScheduler(testSchedulerConfig(), nil)
+	holder, err := s.Acquire(context.Background(), PriorityDefault, "p", 1)
+	if err != nil {
+		t.Fatalf("holder: %v", err)
+	}
+	defer holder()
+
+	grants := make(chan grant, 1)
+	enqueue(t, s, grants, "waiter", PriorityDefault, "p", 1)
+
+	cfg := testSchedulerConfig()
+	cfg.MaxConcurrent = 2
+	s.Reconfigure(cfg)
+	select {
+	case g := <-grants:
+		g.release()
+	case <-time.After(2 * time.Second):
+		t.Fatal("waiter not admitted after raising max_concurrent")
+	}
+}
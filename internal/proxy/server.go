@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net/http"
 	"time"
@@ -9,6 +10,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 
+	"github.com/allaspectsdev/tokenman/internal/auth"
 	"github.com/allaspectsdev/tokenman/internal/tracing"
 )
 
@@ -19,16 +21,25 @@ type Server struct {
 	handler *ProxyHandler
 	addr    string
 	httpSrv *http.Server
+
+	// certAuth, when set, derives caller identities from verified client
+	// certificates.
+	certAuth *auth.CertAuthenticator
 }
 
 // NewServer creates a new Server with the given ProxyHandler, listen address,
-// HTTP timeout durations, and optional auth token. Zero-value timeouts leave
+// HTTP timeout durations, and optional authenticator. Zero-value timeouts leave
 // the corresponding http.Server field at its default (no timeout). If
 // tracingEnabled is true, the OpenTelemetry HTTP middleware is added to
-// extract/inject trace context. If authToken is non-empty, all routes except
-// health checks require a valid Bearer token.
-func NewServer(handler *ProxyHandler, addr string, readTimeout, writeTimeout, idleTimeout time.Duration, tracingEnabled bool, authToken string) *Server {
+// extract/inject trace context. If authenticator is non-nil, all routes except
+// health checks require the shared token or a virtual key with the proxy scope.
+func NewServer(handler *ProxyHandler, addr string, readTimeout, writeTimeout, idleTimeout time.Duration, tracingEnabled bool, authenticator *auth.Authenticator) *Server {
 	r := chi.NewRouter()
+	srv := &Server{
+		router:  r,
+		handler: handler,
+		addr:    addr,
+	}
 
 	// Standard chi middleware.
 	r.Use(middleware.RealIP)
@@ -45,13 +56,21 @@ func NewServer(handler *ProxyHandler, addr string, readTimeout, writeTimeout, id
 
 	// All other routes — conditionally protected by auth.
 	r.Group(func(r chi.Router) {
-		if authToken != "" {
-			r.Use(AuthMiddleware(authToken))
+		r.Use(srv.clientCertIdentity)
+		if authenticator != nil {
+			r.Use(authenticator.Middleware(auth.ScopeProxy))
 		}
 
 		// Mount proxy routes.
 		r.Post("/v1/messages", handler.HandleRequest)
+		r.Post("/v1/messages/count_tokens", handler.HandleCountTokens)
+		r.Post("/v1/tokenman/count", handler.HandleCountTokens)
 		r.Post("/v1/chat/completions", handler.HandleRequest)
+		r.Post("/v1/responses", handler.HandleRequest)
+		r.Post("/v1/embeddings", handler.HandleEmbeddings)
+		// Gemini puts the model and action in the last path segment
+		// (models/{model}:generateContent), which DetectFormat validates.
+		r.Post("/v1beta/models/*", handler.HandleRequest)
 		r.Get("/v1/models", handler.HandleModels)
 
 		// Stream session routes (SSE-based bidirectional streaming).
@@ -61,12 +80,6 @@ func NewServer(handler *ProxyHandler, addr string, readTimeout, writeTimeout, id
 		r.Delete("/v1/stream/{id}", handler.HandleStreamDelete)
 	})
 
-	srv := &Server{
-		router:  r,
-		handler: handler,
-		addr:    addr,
-	}
-
 	srv.httpSrv = &http.Server{
 		Addr:         addr,
 		Handler:      r,
@@ -78,6 +91,24 @@ func NewServer(handler *ProxyHandler, addr string, readTimeout, writeTimeout, id
 	return srv
 }
 
+// SetCertAuthenticator enables identities from client certificates, which
+// are only presented when the server is started with StartTLSConfig and a
+// configuration that requests them.
+func (s *Server) SetCertAuthenticator(c *auth.CertAuthenticator) {
+	s.certAuth = c
+}
+
+// clientCertIdentity applies the cert authenticator, if any.
+func (s *Server) clientCertIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.certAuth == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s.certAuth.Middleware(next).ServeHTTP(w, r)
+	})
+}
+
 // Router returns the underlying chi.Router, useful for testing or additional
 // route mounting by the caller.
 func (s *Server) Router() chi.Router {
@@ -99,6 +130,17 @@ func (s *Server) StartTLS(certFile, keyFile string) error {
 	return nil
 }
 
+// StartTLSConfig begins listening for HTTPS connections using cfg, which
+// supplies the certificates (for example from a certs.Reloader). It blocks
+// until the server is shut down or encounters a fatal error.
+func (s *Server) StartTLSConfig(cfg *tls.Config) error {
+	s.httpSrv.TLSConfig = cfg
+	if err := s.httpSrv.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("proxy server (TLS): %w", err)
+	}
+	return nil
+}
+
 // Shutdown gracefully stops the server, waiting for in-flight requests to
 // complete within the given context deadline.
 func (s *Server) Shutdown(ctx context.Context) error {
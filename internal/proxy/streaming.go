@@ -94,6 +94,10 @@ func extractDelta(data string, format pipeline.APIFormat) (delta, model string,
 	case pipeline.FormatOpenAI:
 		d, m := extractOpenAIDelta(data)
 		return d, m, 0
+	case pipeline.FormatGemini:
+		return extractGeminiDelta(data)
+	case pipeline.FormatOpenAIResponses:
+		return extractResponsesDelta(data)
 	default:
 		return "", "", 0
 	}
@@ -162,3 +166,66 @@ func extractOpenAIDelta(data string) (delta, model string) {
 	}
 	return delta, model
 }
+
+// geminiStreamChunk is a minimal representation of a Gemini streaming chunk.
+type geminiStreamChunk struct {
+	Candidates []struct {
+		Content struct {
+			Parts []struct {
+				Text string `json:"text"`
+			} `json:"parts"`
+		} `json:"content"`
+	} `json:"candidates"`
+	UsageMetadata struct {
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+	ModelVersion string `json:"modelVersion"`
+}
+
+// extractGeminiDelta extracts content from a Gemini streaming chunk. It
+// reads the text parts of the first candidate, modelVersion, and the running
+// output token count in usageMetadata.
+func extractGeminiDelta(data string) (delta, model string, tokensOut int) {
+	var chunk geminiStreamChunk
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return "", "", 0
+	}
+
+	if len(chunk.Candidates) > 0 {
+		var b strings.Builder
+		for _, part := range chunk.Candidates[0].Content.Parts {
+			b.WriteString(part.Text)
+		}
+		delta = b.String()
+	}
+	return delta, chunk.ModelVersion, chunk.UsageMetadata.CandidatesTokenCount
+}
+
+// responsesStreamEvent is a minimal representation of an OpenAI Responses
+// API streaming event.
+type responsesStreamEvent struct {
+	Type     string `json:"type"`
+	Delta    string `json:"delta"`
+	Response struct {
+		Model string `json:"model"`
+		Usage struct {
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	} `json:"response"`
+}
+
+// extractResponsesDelta extracts content from a Responses API streaming
+// event. It reads the delta of "response.output_text.delta" events, and the
+// model and output token usage of the response carried by lifecycle events
+// such as "response.created" and "response.completed".
+func extractResponsesDelta(data string) (delta, model string, tokensOut int) {
+	var evt responsesStreamEvent
+	if err := json.Unmarshal([]byte(data), &evt); err != nil {
+		return "", "", 0
+	}
+
+	if evt.Type == "response.output_text.delta" {
+		return evt.Delta, "", 0
+	}
+	return "", evt.Response.Model, evt.Response.Usage.OutputTokens
+}
@@ -296,6 +296,32 @@ func TestExtractDelta_OpenAI(t *testing.T) {
 	}
 }
 
+func TestExtractDelta_Gemini(t *testing.T) {
+	data := `{"candidates":[{"content":{"role":"model","parts":[{"text":"Bon"},{"text":"jour"}]}}],"usageMetadata":{"candidatesTokenCount":3},"modelVersion":"gemini-2.5-flash"}`
+	delta, model, tokens := extractDelta(data, pipeline.FormatGemini)
+	if delta != "Bonjour" || model != "gemini-2.5-flash" || tokens != 3 {
+		t.Errorf("got delta=%q model=%q tokens=%d; want Bonjour from gemini-2.5-flash with 3 tokens", delta, model, tokens)
+	}
+}
+
+func TestExtractDelta_Responses(t *testing.T) {
+	tests := []struct {
+		data         string
+		delta, model string
+		tokens       int
+	}{
+		{data: `{"type":"response.created","response":{"id":"resp_1","model":"gpt-4.1","status":"in_progress"}}`, model: "gpt-4.1"},
+		{data: `{"type":"response.output_text.delta","item_id":"msg_1","delta":"Bon"}`, delta: "Bon"},
+		{data: `{"type":"response.completed","response":{"model":"gpt-4.1","usage":{"input_tokens":6,"output_tokens":3}}}`, model: "gpt-4.1", tokens: 3},
+	}
+	for _, tt := range tests {
+		delta, model, tokens := extractDelta(tt.data, pipeline.FormatOpenAIResponses)
+		if delta != tt.delta || model != tt.model || tokens != tt.tokens {
+			t.Errorf("extractDelta(%s) = %q, %q, %d; want %q, %q, %d", tt.data, delta, model, tokens, tt.delta, tt.model, tt.tokens)
+		}
+	}
+}
+
 func TestExtractDelta_UnknownFormat(t *testing.T) {
 	delta, model, tokens := extractDelta(`{"data":"test"}`, pipeline.FormatUnknown)
 	if delta != "" || model != "" || tokens != 0 {
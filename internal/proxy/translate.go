@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+	"github.com/allaspectsdev/tokenman/internal/router"
+)
+
+// forwardTo sends req to p. When p speaks a different API format than the
+// client, the request is translated into p's format and a successful
+// response, streaming or not, back into the client's. Error responses are
+// passed through untranslated.
+func (h *ProxyHandler) forwardTo(ctx context.Context, req *pipeline.Request, p *router.ProviderConfig, apiKey string) (*http.Response, error) {
+	upReq, err := providerRequest(req, p)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.client.Forward(ctx, upReq, p.BaseURL, apiKey)
+	if err != nil || upReq == req || resp.StatusCode >= 300 {
+		return resp, err
+	}
+	if err := translateResponseBody(resp, upReq.Format, req.Format, req.Stream); err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// providerRequest returns req as p expects it: req itself when p speaks
+// the client's format (OpenAI providers also serve the Responses API and
+// embeddings), and otherwise a copy whose body is rebuilt in p's format from
+// the normalized request.
+func providerRequest(req *pipeline.Request, p *router.ProviderConfig) (*pipeline.Request, error) {
+	if p.Format == "" || p.Format == req.Format {
+		return req, nil
+	}
+	if openAIOnly(req.Format) {
+		if p.Format == pipeline.FormatOpenAI {
+			return req, nil
+		}
+		return nil, fmt.Errorf("provider %s does not serve %s requests", p.Name, req.Format)
+	}
+	body, err := router.TranslateRequest(req, req.Format, p.Format)
+	if err != nil {
+		return nil, fmt.Errorf("translating request for provider %s: %w", p.Name, err)
+	}
+	out := *req
+	out.Format = p.Format
+	out.RawBody = body
+	// Client headers specific to the Anthropic API mean nothing elsewhere.
+	out.Headers = make(map[string]string, len(req.Headers))
+	for k, v := range req.Headers {
+		if p.Format != pipeline.FormatAnthropic && strings.HasPrefix(http.CanonicalHeaderKey(k), "Anthropic-") {
+			continue
+		}
+		out.Headers[k] = v
+	}
+	return &out, nil
+}
+
+// translateResponseBody replaces resp's body with one that reads as the
+// client's format.
+func translateResponseBody(resp *http.Response, from, to pipeline.APIFormat, stream bool) error {
+	if stream {
+		tr, err := router.NewStreamTranslator(from, to)
+		if err != nil {
+			return err
+		}
+		resp.Body = &translatedStream{src: resp.Body, reader: NewSSEReader(resp.Body), tr: tr}
+	} else {
+		resp.Body = &translatedBody{src: resp.Body, from: from, to: to}
+	}
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	return nil
+}
+
+// translatedBody translates a JSON response body on first read.
+type translatedBody struct {
+	src      io.ReadCloser
+	from, to pipeline.APIFormat
+	buf      *bytes.Reader
+}
+
+func (b *translatedBody) Read(p []byte) (int, error) {
+	if b.buf == nil {
+		body, err := io.ReadAll(b.src)
+		if err != nil {
+			return 0, err
+		}
+		translated, err := router.TranslateResponse(body, b.from, b.to)
+		if err != nil {
+			return 0, fmt.Errorf("translating %s response: %w", b.from, err)
+		}
+		b.buf = bytes.NewReader(translated)
+	}
+	return b.buf.Read(p)
+}
+
+func (b *translatedBody) Close() error {
+	return b.src.Close()
+}
+
+// translatedStream translates a server-sent event stream event by event as
+// it is read.
+type translatedStream struct {
+	src    io.ReadCloser
+	reader *SSEReader
+	tr     *router.StreamTranslator
+	buf    bytes.Buffer
+	eof    bool
+}
+
+func (s *translatedStream) Read(p []byte) (int, error) {
+	for s.buf.Len() == 0 {
+		if s.eof {
+			return 0, io.EOF
+		}
+		evt, err := s.reader.Next()
+		if err == io.EOF {
+			s.eof = true
+			s.write(s.tr.Close())
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		s.write(s.tr.Translate(router.StreamEvent{Event: evt.Event, Data: evt.Data}))
+	}
+	return s.buf.Read(p)
+}
+
+// write appends events to the buffer in the SSE wire format.
+func (s *translatedStream) write(events []router.StreamEvent) {
+	for _, evt := range events {
+		if evt.Event != "" {
+			fmt.Fprintf(&s.buf, "event: %s\n", evt.Event)
+		}
+		for _, line := range strings.Split(evt.Data, "\n") {
+			fmt.Fprintf(&s.buf, "data: %s\n", line)
+		}
+		s.buf.WriteString("\n")
+	}
+}
+
+func (s *translatedStream) Close() error {
+	return s.src.Close()
+}
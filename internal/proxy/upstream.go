@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/allaspectsdev/tokenman/internal/pipeline"
@@ -65,8 +66,10 @@ func (u *UpstreamClient) Forward(ctx context.Context, req *pipeline.Request, bas
 		} else {
 			httpReq.Header.Set("anthropic-version", "2023-06-01")
 		}
-	case pipeline.FormatOpenAI:
+	case pipeline.FormatOpenAI, pipeline.FormatOpenAIResponses, pipeline.FormatOpenAIEmbeddings:
 		httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+	case pipeline.FormatGemini:
+		httpReq.Header.Set("x-goog-api-key", apiKey)
 	default:
 		httpReq.Header.Set("Authorization", "Bearer "+apiKey)
 	}
@@ -75,7 +78,7 @@ func (u *UpstreamClient) Forward(ctx context.Context, req *pipeline.Request, bas
 	// except for those already established above.
 	for key, val := range req.Headers {
 		lk := http.CanonicalHeaderKey(key)
-		if lk == "Content-Type" || lk == "X-Api-Key" || lk == "Authorization" || lk == "Anthropic-Version" {
+		if lk == "Content-Type" || lk == "X-Api-Key" || lk == "Authorization" || lk == "Anthropic-Version" || lk == "X-Goog-Api-Key" {
 			continue
 		}
 		httpReq.Header.Set(key, val)
@@ -108,12 +111,23 @@ func (u *UpstreamClient) Forward(ctx context.Context, req *pipeline.Request, bas
 }
 
 // buildUpstreamURL constructs the full upstream URL based on the provider format.
+// Gemini takes the model in the path and streams server-sent events only
+// when asked to with alt=sse.
 func buildUpstreamURL(baseURL string, req *pipeline.Request) string {
 	switch req.Format {
 	case pipeline.FormatAnthropic:
 		return baseURL + "/v1/messages"
 	case pipeline.FormatOpenAI:
 		return baseURL + "/v1/chat/completions"
+	case pipeline.FormatOpenAIResponses:
+		return baseURL + "/v1/responses"
+	case pipeline.FormatOpenAIEmbeddings:
+		return baseURL + "/v1/embeddings"
+	case pipeline.FormatGemini:
+		if req.Stream {
+			return baseURL + "/v1beta/models/" + url.PathEscape(req.Model) + ":streamGenerateContent?alt=sse"
+		}
+		return baseURL + "/v1beta/models/" + url.PathEscape(req.Model) + ":generateContent"
 	default:
 		return baseURL + "/v1/chat/completions"
 	}
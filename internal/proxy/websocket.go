@@ -196,8 +196,8 @@ func (h *ProxyHandler) HandleStreamCreate(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Resolve provider for the model.
-	pc, err := h.router.Resolve(req.Model)
+	// Resolve provider and concrete model, following aliases and rules.
+	pc, model, err := h.router.ResolveModel(req.Model)
 	if err != nil {
 		writeJSONError(w, http.StatusBadGateway, fmt.Sprintf("no provider for model: %s", req.Model))
 		return
@@ -209,7 +209,7 @@ func (h *ProxyHandler) HandleStreamCreate(w http.ResponseWriter, r *http.Request
 		Format:  pc.Format,
 	}
 
-	session, createErr := h.streams.Create(req.Model, provider)
+	session, createErr := h.streams.Create(model, provider)
 	if createErr != nil {
 		writeJSONError(w, http.StatusTooManyRequests, "stream session limit reached")
 		return
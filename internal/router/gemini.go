@@ -0,0 +1,445 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+)
+
+// --------------------------------------------------------------------------
+// Gemini JSON wire types
+// --------------------------------------------------------------------------
+
+type geminiRequest struct {
+	Contents          []geminiContent         `json:"contents"`
+	SystemInstruction *geminiContent          `json:"systemInstruction,omitempty"`
+	Tools             []geminiTool            `json:"tools,omitempty"`
+	GenerationConfig  *geminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	InlineData       *geminiBlob             `json:"inlineData,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type geminiBlob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type geminiFunctionCall struct {
+	ID   string                 `json:"id,omitempty"`
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+type geminiFunctionResponse struct {
+	ID       string                 `json:"id,omitempty"`
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []geminiFunctionDeclaration `json:"functionDeclarations,omitempty"`
+}
+
+type geminiFunctionDeclaration struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Parameters  interface{} `json:"parameters,omitempty"`
+}
+
+type geminiGenerationConfig struct {
+	MaxOutputTokens int      `json:"maxOutputTokens,omitempty"`
+	Temperature     *float64 `json:"temperature,omitempty"`
+}
+
+type geminiResponse struct {
+	Candidates    []geminiCandidate `json:"candidates"`
+	UsageMetadata *geminiUsage      `json:"usageMetadata,omitempty"`
+	ModelVersion  string            `json:"modelVersion,omitempty"`
+	ResponseID    string            `json:"responseId,omitempty"`
+}
+
+type geminiCandidate struct {
+	Content      geminiContent `json:"content"`
+	FinishReason string        `json:"finishReason,omitempty"`
+	Index        int           `json:"index"`
+}
+
+type geminiUsage struct {
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	TotalTokenCount         int `json:"totalTokenCount"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount,omitempty"`
+}
+
+// buildGeminiBody serializes a normalized request as a Gemini
+// generateContent body. It accepts messages parsed from any format:
+// Anthropic content blocks as well as OpenAI tool calls and tool messages.
+// The model and stream flag are not part of a Gemini body; they go in the
+// URL.
+func buildGeminiBody(req *pipeline.Request) ([]byte, error) {
+	gReq := geminiRequest{Contents: []geminiContent{}}
+
+	if req.System != "" {
+		gReq.SystemInstruction = &geminiContent{Parts: []geminiPart{{Text: req.System}}}
+	}
+
+	// Gemini matches function responses to calls by name, which Anthropic
+	// and OpenAI tool results only reference by ID.
+	toolNames := make(map[string]string)
+
+	for _, msg := range req.Messages {
+		if msg.Role == "system" {
+			continue
+		}
+		content := geminiContent{Role: "user"}
+		if msg.Role == "assistant" {
+			content.Role = "model"
+		}
+
+		if msg.Role == "tool" {
+			content.Parts = append(content.Parts, geminiToolResult(msg.ToolCallID, msg.Content, toolNames))
+		} else {
+			parts, err := geminiParts(msg.Content, toolNames)
+			if err != nil {
+				return nil, err
+			}
+			content.Parts = append(content.Parts, parts...)
+		}
+
+		for _, tc := range msg.ToolCalls {
+			toolNames[tc.ID] = tc.Function.Name
+			call := &geminiFunctionCall{ID: tc.ID, Name: tc.Function.Name}
+			if tc.Function.Arguments != "" {
+				if err := json.Unmarshal([]byte(tc.Function.Arguments), &call.Args); err != nil {
+					return nil, fmt.Errorf("parsing arguments of tool call %q: %w", tc.ID, err)
+				}
+			}
+			content.Parts = append(content.Parts, geminiPart{FunctionCall: call})
+		}
+
+		if len(content.Parts) > 0 {
+			gReq.Contents = append(gReq.Contents, content)
+		}
+	}
+
+	var decls []geminiFunctionDeclaration
+	for _, t := range req.Tools {
+		if decl, ok := geminiDeclaration(t); ok {
+			decls = append(decls, decl)
+		}
+	}
+	if len(decls) > 0 {
+		gReq.Tools = []geminiTool{{FunctionDeclarations: decls}}
+	}
+
+	if req.MaxTokens > 0 || req.Temperature != nil {
+		gReq.GenerationConfig = &geminiGenerationConfig{
+			MaxOutputTokens: req.MaxTokens,
+			Temperature:     req.Temperature,
+		}
+	}
+
+	return json.Marshal(gReq)
+}
+
+// geminiParts converts message content (a string or content blocks) into
+// Gemini parts.
+func geminiParts(content interface{}, toolNames map[string]string) ([]geminiPart, error) {
+	switch c := content.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if c == "" {
+			return nil, nil
+		}
+		return []geminiPart{{Text: c}}, nil
+	}
+
+	blocks, err := contentBlocks(content)
+	if err != nil {
+		return nil, fmt.Errorf("parsing content blocks: %w", err)
+	}
+	var parts []geminiPart
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			if block.Text != "" {
+				parts = append(parts, geminiPart{Text: block.Text})
+			}
+		case "image":
+			data, _ := block.Source["data"].(string)
+			mediaType, _ := block.Source["media_type"].(string)
+			if data != "" {
+				parts = append(parts, geminiPart{InlineData: &geminiBlob{MimeType: mediaType, Data: data}})
+			}
+		case "tool_use":
+			toolNames[block.ID] = block.Name
+			call := &geminiFunctionCall{ID: geminiID(block.ID, block.Name), Name: block.Name}
+			if args, ok := block.Input.(map[string]interface{}); ok {
+				call.Args = args
+			}
+			parts = append(parts, geminiPart{FunctionCall: call})
+		case "tool_result":
+			parts = append(parts, geminiToolResult(block.ToolUseID, block.Content, toolNames))
+		}
+	}
+	return parts, nil
+}
+
+// geminiToolResult converts the result of tool call id into a Gemini
+// function response. Results that are not a JSON object are wrapped in one.
+func geminiToolResult(id string, result interface{}, toolNames map[string]string) geminiPart {
+	name := toolNames[id]
+	if name == "" {
+		// Requests parsed from Gemini use the function name as the ID.
+		name = id
+	}
+	text := extractText(result)
+	var response map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &response); err != nil || response == nil {
+		response = map[string]interface{}{"content": text}
+	}
+	return geminiPart{FunctionResponse: &geminiFunctionResponse{ID: geminiID(id, name), Name: name, Response: response}}
+}
+
+// geminiID returns the ID to send Gemini for a function call or response.
+// An ID equal to the function name stands in for one Gemini did not assign
+// and is left out again.
+func geminiID(id, name string) string {
+	if id == name {
+		return ""
+	}
+	return id
+}
+
+// geminiDeclaration converts a tool definition from either format into a
+// Gemini function declaration.
+func geminiDeclaration(t pipeline.Tool) (geminiFunctionDeclaration, bool) {
+	decl := geminiFunctionDeclaration{Name: t.Name, Description: t.Description, Parameters: t.InputSchema}
+	if fn, ok := t.Function.(map[string]interface{}); ok {
+		// OpenAI nests the definition under "function".
+		if decl.Name == "" {
+			decl.Name, _ = fn["name"].(string)
+		}
+		if decl.Description == "" {
+			decl.Description, _ = fn["description"].(string)
+		}
+		if decl.Parameters == nil {
+			decl.Parameters = fn["parameters"]
+		}
+	}
+	if decl.Name == "" {
+		return decl, false
+	}
+	decl.Parameters = geminiSchema(decl.Parameters)
+	return decl, true
+}
+
+// geminiSchema returns a copy of a JSON schema without the keywords
+// Gemini's OpenAPI schema subset rejects.
+func geminiSchema(schema interface{}) interface{} {
+	switch s := schema.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(s))
+		for k, v := range s {
+			if k == "$schema" || k == "additionalProperties" {
+				continue
+			}
+			out[k] = geminiSchema(v)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(s))
+		for i, v := range s {
+			out[i] = geminiSchema(v)
+		}
+		return out
+	default:
+		return schema
+	}
+}
+
+// contentBlocks decodes message content of any block representation
+// ([]interface{} or []pipeline.ContentBlock) into Anthropic content blocks.
+func contentBlocks(content interface{}) ([]anthropicContentBlock, error) {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	var blocks []anthropicContentBlock
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// extractText returns the text of a tool result, which is either a string
+// or a list of text blocks.
+func extractText(content interface{}) string {
+	if s, ok := content.(string); ok {
+		return s
+	}
+	blocks, err := contentBlocks(content)
+	if err != nil {
+		return ""
+	}
+	var parts []string
+	for _, b := range blocks {
+		if b.Type == "text" {
+			parts = append(parts, b.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// translateGeminiResponseToAnthropic converts a Gemini generateContent
+// response into an Anthropic message.
+func translateGeminiResponseToAnthropic(body []byte) ([]byte, error) {
+	var gResp geminiResponse
+	if err := json.Unmarshal(body, &gResp); err != nil {
+		return nil, fmt.Errorf("parsing gemini response: %w", err)
+	}
+
+	aResp := anthropicResponse{
+		ID:      gResp.ResponseID,
+		Type:    "message",
+		Role:    "assistant",
+		Model:   gResp.ModelVersion,
+		Content: []anthropicContentBlock{},
+	}
+
+	if len(gResp.Candidates) > 0 {
+		cand := gResp.Candidates[0]
+		hasCalls := false
+		for _, part := range cand.Content.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				hasCalls = true
+				aResp.Content = append(aResp.Content, anthropicContentBlock{
+					Type:  "tool_use",
+					ID:    geminiCallID(part.FunctionCall),
+					Name:  part.FunctionCall.Name,
+					Input: geminiArgs(part.FunctionCall.Args),
+				})
+			case part.Text != "":
+				aResp.Content = append(aResp.Content, anthropicContentBlock{
+					Type: "text",
+					Text: part.Text,
+				})
+			}
+		}
+		aResp.StopReason = mapGeminiFinishReason(cand.FinishReason, hasCalls)
+	}
+
+	if gResp.UsageMetadata != nil {
+		aResp.Usage = &anthropicUsage{
+			InputTokens:  gResp.UsageMetadata.PromptTokenCount,
+			OutputTokens: gResp.UsageMetadata.CandidatesTokenCount,
+		}
+	}
+
+	return json.Marshal(aResp)
+}
+
+// translateAnthropicResponseToGemini converts an Anthropic message into a
+// Gemini generateContent response.
+func translateAnthropicResponseToGemini(body []byte) ([]byte, error) {
+	var aResp anthropicResponse
+	if err := json.Unmarshal(body, &aResp); err != nil {
+		return nil, fmt.Errorf("parsing anthropic response: %w", err)
+	}
+
+	content := geminiContent{Role: "model", Parts: []geminiPart{}}
+	for _, block := range aResp.Content {
+		switch block.Type {
+		case "text":
+			content.Parts = append(content.Parts, geminiPart{Text: block.Text})
+		case "tool_use":
+			call := &geminiFunctionCall{ID: block.ID, Name: block.Name}
+			if args, ok := block.Input.(map[string]interface{}); ok {
+				call.Args = args
+			}
+			content.Parts = append(content.Parts, geminiPart{FunctionCall: call})
+		}
+	}
+
+	gResp := geminiResponse{
+		Candidates: []geminiCandidate{{
+			Content:      content,
+			FinishReason: mapAnthropicStopReasonToGemini(aResp.StopReason),
+		}},
+		ModelVersion: aResp.Model,
+		ResponseID:   aResp.ID,
+	}
+	if aResp.Usage != nil {
+		gResp.UsageMetadata = &geminiUsage{
+			PromptTokenCount:     aResp.Usage.InputTokens,
+			CandidatesTokenCount: aResp.Usage.OutputTokens,
+			TotalTokenCount:      aResp.Usage.InputTokens + aResp.Usage.OutputTokens,
+		}
+	}
+
+	return json.Marshal(gResp)
+}
+
+// geminiCallID returns the ID of a function call. Gemini only sometimes
+// assigns one, so the function name stands in for it.
+func geminiCallID(call *geminiFunctionCall) string {
+	if call.ID != "" {
+		return call.ID
+	}
+	return call.Name
+}
+
+// geminiArgs returns function call arguments as a tool_use input, which
+// must be an object.
+func geminiArgs(args map[string]interface{}) map[string]interface{} {
+	if args == nil {
+		return map[string]interface{}{}
+	}
+	return args
+}
+
+// mapGeminiFinishReason converts a Gemini finishReason to an Anthropic
+// stop_reason. Gemini reports STOP for turns that end in function calls.
+func mapGeminiFinishReason(reason string, hasCalls bool) string {
+	switch reason {
+	case "MAX_TOKENS":
+		return "max_tokens"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+		return "refusal"
+	case "":
+		return ""
+	}
+	if hasCalls {
+		return "tool_use"
+	}
+	return "end_turn"
+}
+
+// mapAnthropicStopReasonToGemini converts an Anthropic stop_reason to a
+// Gemini finishReason.
+func mapAnthropicStopReasonToGemini(reason string) string {
+	switch reason {
+	case "max_tokens":
+		return "MAX_TOKENS"
+	case "refusal":
+		return "SAFETY"
+	case "":
+		return ""
+	default:
+		return "STOP"
+	}
+}
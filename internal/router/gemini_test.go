@@ -0,0 +1,172 @@
+package router
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+)
+
+func TestTranslateRequest_AnthropicToGemini(t *testing.T) {
+	temp := 0.2
+	req := &pipeline.Request{
+		Model:       "gemini-2.5-flash",
+		System:      "Be brief.",
+		MaxTokens:   256,
+		Temperature: &temp,
+		Messages: []pipeline.Message{
+			{Role: "user", Content: "Weather in Paris?"},
+			{Role: "assistant", Content: []interface{}{
+				map[string]interface{}{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": map[string]interface{}{"city": "Paris"}},
+			}},
+			{Role: "user", Content: []interface{}{
+				map[string]interface{}{"type": "tool_result", "tool_use_id": "toolu_1", "content": `{"temp_c":18}`},
+			}},
+		},
+		Tools: []pipeline.Tool{{
+			Name:        "get_weather",
+			Description: "Current weather",
+			InputSchema: map[string]interface{}{
+				"$schema":              "http://json-schema.org/draft-07/schema#",
+				"type":                 "object",
+				"additionalProperties": false,
+				"properties":           map[string]interface{}{"city": map[string]interface{}{"type": "string"}},
+			},
+		}},
+	}
+
+	body, err := TranslateRequest(req, pipeline.FormatAnthropic, pipeline.FormatGemini)
+	if err != nil {
+		t.Fatalf("TranslateRequest: %v", err)
+	}
+	var got geminiRequest
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	if got.SystemInstruction == nil || got.SystemInstruction.Parts[0].Text != "Be brief." {
+		t.Errorf("systemInstruction = %+v; want the system prompt", got.SystemInstruction)
+	}
+	if len(got.Contents) != 3 || got.Contents[1].Role != "model" {
+		t.Fatalf("contents = %+v; want user, model, user turns", got.Contents)
+	}
+	call := got.Contents[1].Parts[0].FunctionCall
+	if call == nil || call.Name != "get_weather" || call.Args["city"] != "Paris" {
+		t.Errorf("functionCall = %+v; want get_weather(city=Paris)", call)
+	}
+	resp := got.Contents[2].Parts[0].FunctionResponse
+	if resp == nil || resp.Name != "get_weather" || resp.Response["temp_c"] != float64(18) {
+		t.Errorf("functionResponse = %+v; want the tool result matched to get_weather by name", resp)
+	}
+	decls := got.Tools[0].FunctionDeclarations
+	if len(decls) != 1 || strings.Contains(string(body), "$schema") || strings.Contains(string(body), "additionalProperties") {
+		t.Errorf("functionDeclarations = %+v; want one declaration without unsupported schema keywords", decls)
+	}
+	if got.GenerationConfig == nil || got.GenerationConfig.MaxOutputTokens != 256 || *got.GenerationConfig.Temperature != 0.2 {
+		t.Errorf("generationConfig = %+v; want maxOutputTokens 256 and temperature 0.2", got.GenerationConfig)
+	}
+}
+
+func TestTranslateResponse_GeminiToOpenAI(t *testing.T) {
+	body := []byte(`{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"get_weather","args":{"city":"Paris"}}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":12,"candidatesTokenCount":5,"totalTokenCount":17},"modelVersion":"gemini-2.5-flash"}`)
+
+	out, err := TranslateResponse(body, pipeline.FormatGemini, pipeline.FormatOpenAI)
+	if err != nil {
+		t.Fatalf("TranslateResponse: %v", err)
+	}
+	var got openaiResponse
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got.Model != "gemini-2.5-flash" || len(got.Choices) != 1 {
+		t.Fatalf("response = %s; want one choice from gemini-2.5-flash", out)
+	}
+	choice := got.Choices[0]
+	if choice.FinishReason != "tool_calls" {
+		t.Errorf("finish_reason = %q; want tool_calls for a turn ending in a function call", choice.FinishReason)
+	}
+	if len(choice.Message.ToolCalls) != 1 || choice.Message.ToolCalls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("tool_calls = %+v; want get_weather with its arguments", choice.Message.ToolCalls)
+	}
+	if got.Usage == nil || got.Usage.PromptTokens != 12 || got.Usage.CompletionTokens != 5 {
+		t.Errorf("usage = %+v; want 12 prompt and 5 completion tokens", got.Usage)
+	}
+}
+
+func translateStream(t *testing.T, from, to pipeline.APIFormat, data ...string) []StreamEvent {
+	t.Helper()
+	tr, err := NewStreamTranslator(from, to)
+	if err != nil {
+		t.Fatalf("NewStreamTranslator: %v", err)
+	}
+	var out []StreamEvent
+	for _, d := range data {
+		out = append(out, tr.Translate(StreamEvent{Data: d})...)
+	}
+	return append(out, tr.Close()...)
+}
+
+func TestStreamTranslator_GeminiToAnthropic(t *testing.T) {
+	events := translateStream(t, pipeline.FormatGemini, pipeline.FormatAnthropic,
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"Hel"}]}}],"modelVersion":"gemini-2.5-flash","responseId":"r1"}`,
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"lo"}]},"finishReason":"MAX_TOKENS"}],"usageMetadata":{"promptTokenCount":4,"candidatesTokenCount":2}}`,
+	)
+
+	var types []string
+	var text strings.Builder
+	for _, e := range events {
+		types = append(types, e.Event)
+		var evt struct {
+			Delta struct {
+				Text       string `json:"text"`
+				StopReason string `json:"stop_reason"`
+			} `json:"delta"`
+			Usage struct {
+				OutputTokens int `json:"output_tokens"`
+			} `json:"usage"`
+		}
+		_ = json.Unmarshal([]byte(e.Data), &evt)
+		text.WriteString(evt.Delta.Text)
+		if e.Event == "message_delta" && (evt.Delta.StopReason != "max_tokens" || evt.Usage.OutputTokens != 2) {
+			t.Errorf("message_delta = %s; want max_tokens with 2 output tokens", e.Data)
+		}
+	}
+	want := "message_start content_block_start content_block_delta content_block_delta content_block_stop message_delta message_stop"
+	if got := strings.Join(types, " "); got != want {
+		t.Errorf("events = %s; want %s", got, want)
+	}
+	if text.String() != "Hello" {
+		t.Errorf("streamed text = %q; want Hello", text.String())
+	}
+	if !strings.Contains(events[0].Data, `"model":"gemini-2.5-flash"`) {
+		t.Errorf("message_start = %s; want the Gemini model", events[0].Data)
+	}
+}
+
+func TestStreamTranslator_AnthropicToGeminiToolCall(t *testing.T) {
+	events := translateStream(t, pipeline.FormatAnthropic, pipeline.FormatGemini,
+		`{"type":"message_start","message":{"id":"msg_1","model":"claude-sonnet-4","usage":{"input_tokens":9}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{}}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"\"Paris\"}"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":7}}`,
+		`{"type":"message_stop"}`,
+	)
+
+	if len(events) != 1 {
+		t.Fatalf("got %d events; want the function call whole in one final chunk: %+v", len(events), events)
+	}
+	var chunk geminiResponse
+	if err := json.Unmarshal([]byte(events[0].Data), &chunk); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	cand := chunk.Candidates[0]
+	if call := cand.Content.Parts[0].FunctionCall; call == nil || call.Args["city"] != "Paris" {
+		t.Errorf("parts = %+v; want get_weather(city=Paris)", cand.Content.Parts)
+	}
+	if cand.FinishReason != "STOP" || chunk.UsageMetadata.PromptTokenCount != 9 || chunk.UsageMetadata.CandidatesTokenCount != 7 {
+		t.Errorf("chunk = %s; want STOP with 9 prompt and 7 output tokens", events[0].Data)
+	}
+}
@@ -0,0 +1,227 @@
+package router
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Key selection strategies for a KeyPool.
+const (
+	KeyRoundRobin   = "round_robin"
+	KeyLeastLimited = "least_limited"
+	KeyWeighted     = "weighted"
+)
+
+// DefaultKeyBench is how long a key sits out after a 429 that carried no
+// Retry-After header.
+const DefaultKeyBench = 60 * time.Second
+
+// APIKey is one upstream credential in a provider's key pool. ID labels the
+// key in metrics, logs, and the requests table; the secret never leaves the
+// pool except to authenticate the upstream call.
+type APIKey struct {
+	ID     string
+	Secret string
+	Weight int
+}
+
+// KeyFingerprint returns a short, non-reversible identifier for an API key,
+// used as the key's ID when the configuration does not name it.
+func KeyFingerprint(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
+// KeyStatus is a point-in-time view of one pooled key.
+type KeyStatus struct {
+	ID           string    `json:"id"`
+	Weight       int       `json:"weight"`
+	Disabled     bool      `json:"disabled"`
+	BenchedUntil time.Time `json:"benched_until,omitempty"`
+	LastLimited  time.Time `json:"last_limited,omitempty"`
+}
+
+type pooledKey struct {
+	APIKey
+	benchedUntil time.Time
+	disabled     bool
+	lastLimited  time.Time
+	current      int // smooth weighted round-robin state
+}
+
+// KeyPool distributes requests for one provider across several API keys.
+// Keys that hit a rate limit are benched until the limit resets, and keys
+// the provider rejects as unauthorized are disabled until the pool is
+// rebuilt. It is safe for concurrent use.
+type KeyPool struct {
+	mu       sync.Mutex
+	strategy string
+	keys     []*pooledKey
+	next     int
+	now      func() time.Time
+}
+
+// NewKeyPool creates a pool over keys using strategy; an unknown or empty
+// strategy means round robin. Keys with a non-positive weight get weight 1
+// and keys without an ID are identified by their fingerprint.
+func NewKeyPool(strategy string, keys []APIKey) *KeyPool {
+	switch strategy {
+	case KeyRoundRobin, KeyLeastLimited, KeyWeighted:
+	default:
+		strategy = KeyRoundRobin
+	}
+	p := &KeyPool{strategy: strategy, now: time.Now}
+	for _, k := range keys {
+		if k.Weight <= 0 {
+			k.Weight = 1
+		}
+		if k.ID == "" {
+			k.ID = KeyFingerprint(k.Secret)
+		}
+		p.keys = append(p.keys, &pooledKey{APIKey: k})
+	}
+	return p
+}
+
+// Len returns the number of keys in the pool, including benched ones.
+func (p *KeyPool) Len() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.keys)
+}
+
+// Pick returns the next key to use. Disabled and benched keys are skipped,
+// as are keys for which allow returns false (allow may be nil). It reports
+// false when no key is usable.
+func (p *KeyPool) Pick(allow func(id string) bool) (APIKey, bool) {
+	if p == nil {
+		return APIKey{}, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	usable := make([]*pooledKey, 0, len(p.keys))
+	for _, k := range p.keys {
+		if k.disabled || now.Before(k.benchedUntil) {
+			continue
+		}
+		if allow != nil && !allow(k.ID) {
+			continue
+		}
+		usable = append(usable, k)
+	}
+	if len(usable) == 0 {
+		return APIKey{}, false
+	}
+
+	var chosen *pooledKey
+	switch p.strategy {
+	case KeyLeastLimited:
+		// The key whose last rate limit is furthest in the past; ties
+		// (typically never-limited keys) rotate round robin.
+		start := p.next % len(p.keys)
+		p.next++
+		for i := range p.keys {
+			k := p.keys[(start+i)%len(p.keys)]
+			if !contains(usable, k) {
+				continue
+			}
+			if chosen == nil || k.lastLimited.Before(chosen.lastLimited) {
+				chosen = k
+			}
+		}
+	case KeyWeighted:
+		// Smooth weighted round robin over the usable keys.
+		total := 0
+		for _, k := range usable {
+			k.current += k.Weight
+			total += k.Weight
+			if chosen == nil || k.current > chosen.current {
+				chosen = k
+			}
+		}
+		chosen.current -= total
+	default:
+		for i := range p.keys {
+			k := p.keys[(p.next+i)%len(p.keys)]
+			if contains(usable, k) {
+				chosen = k
+				p.next = (p.next + i + 1) % len(p.keys)
+				break
+			}
+		}
+	}
+	return chosen.APIKey, true
+}
+
+func contains(keys []*pooledKey, k *pooledKey) bool {
+	for _, c := range keys {
+		if c == k {
+			return true
+		}
+	}
+	return false
+}
+
+// Bench takes a key out of rotation for d after it was rate limited. A
+// non-positive d uses DefaultKeyBench.
+func (p *KeyPool) Bench(id string, d time.Duration) {
+	if p == nil {
+		return
+	}
+	if d <= 0 {
+		d = DefaultKeyBench
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	for _, k := range p.keys {
+		if k.ID == id {
+			k.lastLimited = now
+			if until := now.Add(d); until.After(k.benchedUntil) {
+				k.benchedUntil = until
+			}
+		}
+	}
+}
+
+// Disable removes a key from rotation for the life of the pool, after the
+// provider rejected it as unauthorized.
+func (p *KeyPool) Disable(id string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, k := range p.keys {
+		if k.ID == id {
+			k.disabled = true
+		}
+	}
+}
+
+// Status returns the state of every key in the pool, in configuration order.
+func (p *KeyPool) Status() []KeyStatus {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	out := make([]KeyStatus, 0, len(p.keys))
+	for _, k := range p.keys {
+		st := KeyStatus{ID: k.ID, Weight: k.Weight, Disabled: k.disabled, LastLimited: k.lastLimited}
+		if now.Before(k.benchedUntil) {
+			st.BenchedUntil = k.benchedUntil
+		}
+		out = append(out, st)
+	}
+	return out
+}
@@ -0,0 +1,109 @@
+package router
+
+import (
+	"testing"
+	"time"
+)
+
+func pickN(t *testing.T, p *KeyPool, n int) []string {
+	t.Helper()
+	ids := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		k, ok := p.Pick(nil)
+		if !ok {
+			t.Fatalf("Pick %d: no usable key", i)
+		}
+		ids = append(ids, k.ID)
+	}
+	return ids
+}
+
+func TestKeyPool_RoundRobin(t *testing.T) {
+	p := NewKeyPool("", []APIKey{{ID: "a"}, {ID: "b"}, {ID: "c"}})
+	got := pickN(t, p, 6)
+	want := []string{"a", "b", "c", "a", "b", "c"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("picks = %v; want %v", got, want)
+		}
+	}
+}
+
+func TestKeyPool_Weighted(t *testing.T) {
+	p := NewKeyPool(KeyWeighted, []APIKey{{ID: "a", Weight: 3}, {ID: "b", Weight: 1}})
+	counts := map[string]int{}
+	for _, id := range pickN(t, p, 8) {
+		counts[id]++
+	}
+	if counts["a"] != 6 || counts["b"] != 2 {
+		t.Fatalf("counts = %v; want a=6 b=2", counts)
+	}
+}
+
+func TestKeyPool_LeastLimitedPrefersOldestLimit(t *testing.T) {
+	now := time.Now()
+	p := NewKeyPool(KeyLeastLimited, []APIKey{{ID: "a"}, {ID: "b"}})
+	p.now = func() time.Time { return now }
+
+	p.Bench("a", time.Second)
+	now = now.Add(10 * time.Second)
+	p.Bench("b", time.Second)
+	now = now.Add(10 * time.Second)
+
+	// Both are usable again; a was limited longer ago.
+	for _, id := range pickN(t, p, 3) {
+		if id != "a" {
+			t.Fatalf("picked %q; want a", id)
+		}
+	}
+}
+
+func TestKeyPool_BenchAndDisable(t *testing.T) {
+	now := time.Now()
+	p := NewKeyPool(KeyRoundRobin, []APIKey{{ID: "a"}, {ID: "b"}, {ID: "c"}})
+	p.now = func() time.Time { return now }
+
+	p.Bench("a", 30*time.Second)
+	p.Disable("b")
+	for _, id := range pickN(t, p, 3) {
+		if id != "c" {
+			t.Fatalf("picked %q while a is benched and b disabled", id)
+		}
+	}
+
+	if _, ok := p.Pick(func(id string) bool { return id != "c" }); ok {
+		t.Fatal("Pick should fail when allow rejects the only usable key")
+	}
+
+	now = now.Add(31 * time.Second)
+	seen := map[string]bool{}
+	for _, id := range pickN(t, p, 4) {
+		seen[id] = true
+	}
+	if !seen["a"] || seen["b"] {
+		t.Fatalf("after bench expiry picks = %v; want a back and b still disabled", seen)
+	}
+
+	for _, st := range p.Status() {
+		if st.ID == "b" && !st.Disabled {
+			t.Error("Status should report b as disabled")
+		}
+		if st.ID == "a" && !st.BenchedUntil.IsZero() {
+			t.Error("Status should not report an expired bench")
+		}
+	}
+}
+
+func TestKeyPool_DefaultsIDToFingerprint(t *testing.T) {
+	p := NewKeyPool(KeyRoundRobin, []APIKey{{Secret: "sk-secret"}})
+	k, ok := p.Pick(nil)
+	if !ok {
+		t.Fatal("Pick failed")
+	}
+	if k.ID != KeyFingerprint("sk-secret") || len(k.ID) != 8 {
+		t.Fatalf("ID = %q; want 8-char fingerprint", k.ID)
+	}
+	if k.Weight != 1 {
+		t.Fatalf("Weight = %d; want default 1", k.Weight)
+	}
+}
@@ -16,14 +16,38 @@ type ProviderConfig struct {
 	Enabled  bool               `json:"enabled"`
 	Priority int                `json:"priority"`
 	Timeout  time.Duration      `json:"timeout"`
+
+	// Keys, when set, pools several API keys for the provider; APIKey is
+	// then the first pooled key, used where a single key is needed (model
+	// listing, WebSocket upgrades).
+	Keys *KeyPool `json:"-"`
+
+	// ProbeModel, when set, is the model active health checks send a
+	// one-token completion to; otherwise they list the provider's models.
+	ProbeModel string `json:"probe_model,omitempty"`
 }
 
 // ProviderStatus represents the current health status of a provider.
+// ErrorCount is the number of consecutive failed checks and requests.
 type ProviderStatus struct {
 	Name       string    `json:"name"`
 	Healthy    bool      `json:"healthy"`
 	LastCheck  time.Time `json:"last_check"`
 	ErrorCount int       `json:"error_count"`
+	LastError  string    `json:"last_error,omitempty"`
+	// Since is when Healthy last changed.
+	Since time.Time `json:"since"`
+}
+
+// HealthCheck is one entry in a provider's health history: an active probe,
+// or a request that changed the provider's health.
+type HealthCheck struct {
+	Time      time.Time `json:"time"`
+	Source    string    `json:"source"` // "probe" or "request"
+	OK        bool      `json:"ok"`
+	Healthy   bool      `json:"healthy"` // the provider's health after the check
+	LatencyMs int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
 }
 
 // SupportsModel returns true if this provider is configured to serve the
@@ -6,13 +6,17 @@ import (
 )
 
 // Router resolves model names to provider configurations, supports explicit
-// model→provider mappings, automatic discovery from provider model lists,
-// and fallback ordering by priority.
+// model→provider mappings, aliases, pattern rules, automatic discovery from
+// provider model lists, and fallback ordering by priority.
 type Router struct {
 	providers       map[string]*ProviderConfig
-	modelMap        map[string]string // model name → provider name
+	modelMap        map[string]string            // model name → provider name
+	aliases         map[string]map[string]string // alias → provider name → model
+	rules           []Rule
 	defaultProvider string
 	fallbackEnabled bool
+	strategy        Strategy
+	random          func() float64 // for StrategyWeightedRandom; nil means math/rand
 }
 
 // NewRouter creates a new Router.
@@ -32,18 +36,32 @@ func NewRouter(providers map[string]*ProviderConfig, modelMap map[string]string,
 
 // Resolve finds the single best provider for a given model. The resolution
 // order is:
-//  1. Explicit entry in modelMap.
-//  2. First enabled provider whose Models list contains the model.
-//  3. The default provider.
+//  1. The highest-priority enabled provider serving an alias.
+//  2. Explicit entry in modelMap.
+//  3. The first matching pattern rule.
+//  4. First enabled provider whose Models list contains the model.
+//  5. The default provider.
 func (r *Router) Resolve(model string) (*ProviderConfig, error) {
-	// 1. Explicit model → provider mapping.
+	if r.IsAlias(model) {
+		if ps := r.aliasProviders(model); len(ps) > 0 {
+			return ps[0], nil
+		}
+		return nil, fmt.Errorf("router: no enabled provider serves alias %q", model)
+	}
+
+	// 2. Explicit model → provider mapping.
 	if providerName, ok := r.modelMap[model]; ok {
 		if p, exists := r.providers[providerName]; exists && p.Enabled {
 			return p, nil
 		}
 	}
 
-	// 2. Search enabled providers' model lists (prefer higher priority, i.e.
+	// 3. Pattern rules, in order.
+	if rule := r.matchRule(model); rule != nil {
+		return r.providers[rule.Provider], nil
+	}
+
+	// 4. Search enabled providers' model lists (prefer higher priority, i.e.
 	//    lower Priority value).
 	var best *ProviderConfig
 	for _, p := range r.providers {
@@ -60,7 +78,7 @@ func (r *Router) Resolve(model string) (*ProviderConfig, error) {
 		return best, nil
 	}
 
-	// 3. Fall back to the default provider.
+	// 5. Fall back to the default provider.
 	if r.defaultProvider != "" {
 		if p, exists := r.providers[r.defaultProvider]; exists && p.Enabled {
 			return p, nil
@@ -72,14 +90,32 @@ func (r *Router) Resolve(model string) (*ProviderConfig, error) {
 
 // ResolveWithFallback returns the primary provider for a model followed by
 // fallback providers, ordered by priority (ascending). If fallback is disabled,
-// this behaves like Resolve and returns a single-element slice.
+// this behaves like Resolve and returns a single-element slice. The fallbacks
+// for an alias are the other providers that serve it.
 func (r *Router) ResolveWithFallback(model string) ([]*ProviderConfig, error) {
+	return r.candidates(model, r.fallbackEnabled)
+}
+
+// candidates returns the primary provider for model followed, when
+// withFallbacks is set, by the other providers able to serve it.
+func (r *Router) candidates(model string, withFallbacks bool) ([]*ProviderConfig, error) {
+	if r.IsAlias(model) {
+		ps := r.aliasProviders(model)
+		if len(ps) == 0 {
+			return nil, fmt.Errorf("router: no enabled provider serves alias %q", model)
+		}
+		if !withFallbacks {
+			ps = ps[:1]
+		}
+		return ps, nil
+	}
+
 	primary, err := r.Resolve(model)
 	if err != nil {
 		return nil, err
 	}
 
-	if !r.fallbackEnabled {
+	if !withFallbacks {
 		return []*ProviderConfig{primary}, nil
 	}
 
@@ -109,6 +145,61 @@ func (r *Router) ResolveWithFallback(model string) ([]*ProviderConfig, error) {
 	return result, nil
 }
 
+// Route returns the providers to try for model, ordered by the selection
+// strategy using stats, with the reason for each placement. The strategy
+// ranks every provider able to serve the model; with fallback disabled
+// only the top-ranked one is returned. Under StrategyPriority, Route is
+// ResolveWithFallback with unhealthy providers moved last, and returns no
+// rankings.
+func (r *Router) Route(model string, stats func(*ProviderConfig) ProviderStats) ([]*ProviderConfig, []Ranking, error) {
+	if r.StrategyName() == StrategyPriority {
+		ps, err := r.ResolveWithFallback(model)
+		if err != nil {
+			return nil, nil, err
+		}
+		return demoteUnhealthy(ps, stats), nil, nil
+	}
+	ps, err := r.candidates(model, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	ps, rankings := r.Rank(ps, stats)
+	if !r.fallbackEnabled {
+		ps = ps[:1]
+	}
+	return ps, rankings, nil
+}
+
+// demoteUnhealthy moves providers that stats reports as unhealthy behind
+// the healthy ones, keeping the order within each group.
+func demoteUnhealthy(ps []*ProviderConfig, stats func(*ProviderConfig) ProviderStats) []*ProviderConfig {
+	if len(ps) < 2 || stats == nil {
+		return ps
+	}
+	healthy := make([]*ProviderConfig, 0, len(ps))
+	var unhealthy []*ProviderConfig
+	for _, p := range ps {
+		if stats(p).Unhealthy {
+			unhealthy = append(unhealthy, p)
+		} else {
+			healthy = append(healthy, p)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// Providers returns the enabled providers sorted by name.
+func (r *Router) Providers() []*ProviderConfig {
+	ps := make([]*ProviderConfig, 0, len(r.providers))
+	for _, p := range r.providers {
+		if p.Enabled {
+			ps = append(ps, p)
+		}
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].Name < ps[j].Name })
+	return ps
+}
+
 // ListModels returns a de-duplicated, sorted list of all models available
 // across all enabled providers.
 func (r *Router) ListModels() []string {
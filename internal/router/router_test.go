@@ -1,7 +1,9 @@
 package router
 
 import (
+	"strings"
 	"testing"
+	"time"
 )
 
 func makeProviders() map[string]*ProviderConfig {
@@ -305,3 +307,194 @@ func TestSupportsModel(t *testing.T) {
 		t.Fatal("expected SupportsModel to return false for empty string")
 	}
 }
+
+func TestResolve_PatternRules(t *testing.T) {
+	r := NewRouter(makeProviders(), map[string]string{"gpt-4o-pinned": "backup"}, "openai", true)
+	if err := r.SetRules([]Rule{
+		{Match: "claude-sonnet-4-*", Provider: "anthropic"},
+		{Regex: `gpt-4o-\d{4}-\d{2}-\d{2}`, Provider: "openai", Model: "gpt-4o"},
+	}); err != nil {
+		t.Fatalf("SetRules: %v", err)
+	}
+
+	tests := []struct {
+		model, provider, upstream string
+	}{
+		{"claude-sonnet-4-20250514-v2", "anthropic", "claude-sonnet-4-20250514-v2"},
+		{"gpt-4o-2024-11-20", "openai", "gpt-4o"},
+		{"gpt-4o-pinned", "backup", "gpt-4o-pinned"}, // model_map wins over rules
+		{"gpt-4o-latest", "openai", "gpt-4o-latest"}, // regex must match the whole name
+	}
+	for _, tt := range tests {
+		p, model, err := r.ResolveModel(tt.model)
+		if err != nil {
+			t.Fatalf("ResolveModel(%q): %v", tt.model, err)
+		}
+		if p.Name != tt.provider || model != tt.upstream {
+			t.Errorf("ResolveModel(%q) = %s, %q; want %s, %q", tt.model, p.Name, model, tt.provider, tt.upstream)
+		}
+	}
+}
+
+func TestSetRules_RejectsBadPatterns(t *testing.T) {
+	r := NewRouter(makeProviders(), nil, "", false)
+	for _, rule := range []Rule{
+		{Provider: "openai"},
+		{Match: "a", Regex: "b", Provider: "openai"},
+		{Match: "[", Provider: "openai"},
+		{Regex: "(", Provider: "openai"},
+	} {
+		if err := r.SetRules([]Rule{rule}); err == nil {
+			t.Errorf("SetRules(%+v) succeeded; want error", rule)
+		}
+	}
+}
+
+func TestResolve_Aliases(t *testing.T) {
+	r := NewRouter(makeProviders(), nil, "", true)
+	r.SetAliases(map[string]map[string]string{
+		"fast": {"openai": "gpt-4o-mini", "anthropic": "claude-3-haiku"},
+		"gone": {"missing": "x"},
+	})
+
+	p, model, err := r.ResolveModel("fast")
+	if err != nil {
+		t.Fatalf("ResolveModel: %v", err)
+	}
+	if p.Name != "anthropic" || model != "claude-3-haiku" {
+		t.Fatalf("fast = %s, %q; want anthropic (priority 1), claude-3-haiku", p.Name, model)
+	}
+
+	chain, err := r.ResolveWithFallback("fast")
+	if err != nil {
+		t.Fatalf("ResolveWithFallback: %v", err)
+	}
+	if len(chain) != 2 || chain[0].Name != "anthropic" || chain[1].Name != "openai" {
+		t.Fatalf("fallback chain = %v; want anthropic, openai", providerNames(chain))
+	}
+	if got := r.ModelFor("fast", "openai"); got != "gpt-4o-mini" {
+		t.Errorf("ModelFor(fast, openai) = %q; want gpt-4o-mini", got)
+	}
+
+	if _, err := r.Resolve("gone"); err == nil {
+		t.Error("alias without enabled providers should not resolve")
+	}
+	if got := r.Aliases(); len(got) != 2 || got[0] != "fast" || got[1] != "gone" {
+		t.Errorf("Aliases() = %v", got)
+	}
+}
+
+func providerNames(ps []*ProviderConfig) []string {
+	names := make([]string, 0, len(ps))
+	for _, p := range ps {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func TestRank_Strategies(t *testing.T) {
+	providers := makeProviders()
+	candidates := []*ProviderConfig{providers["anthropic"], providers["openai"], providers["backup"]}
+	stats := map[string]ProviderStats{
+		"anthropic": {Samples: 20, P50: 800 * time.Millisecond, P95: 3 * time.Second, Cost: 0.003},
+		"openai":    {Samples: 20, P50: 300 * time.Millisecond, P95: 900 * time.Millisecond, Cost: 0.004, ErrorRate: 0.5},
+		"backup":    {Samples: 20, P50: 200 * time.Millisecond, P95: 500 * time.Millisecond, Cost: 0.001, CircuitOpen: true},
+	}
+	lookup := func(p *ProviderConfig) ProviderStats { return stats[p.Name] }
+
+	tests := []struct {
+		name     string
+		strategy Strategy
+		want     string
+	}{
+		{"priority", Strategy{Name: StrategyPriority}, "anthropic,openai,backup"},
+		{"latency", Strategy{Name: StrategyLowestLatency}, "openai,anthropic,backup"},
+		{"cost", Strategy{Name: StrategyLowestCost}, "anthropic,openai,backup"},
+		{"cost within SLO", Strategy{Name: StrategyLowestCost, SLO: time.Second}, "openai,anthropic,backup"},
+		{"cost within error budget", Strategy{Name: StrategyLowestCost, SLO: 5 * time.Second, MaxErrorRate: 0.2}, "anthropic,openai,backup"},
+	}
+	for _, tt := range tests {
+		r := NewRouter(providers, nil, "", true)
+		r.SetStrategy(tt.strategy)
+		ordered, rankings := r.Rank(candidates, lookup)
+		if got := strings.Join(providerNames(ordered), ","); got != tt.want {
+			t.Errorf("%s: order = %s; want %s", tt.name, got, tt.want)
+		}
+		if last := rankings[len(rankings)-1]; last.Provider != "backup" || last.Reason != "circuit open" {
+			t.Errorf("%s: last ranking = %+v; want backup, circuit open", tt.name, last)
+		}
+	}
+}
+
+func TestRank_WeightedRandomFavoursHealthyProviders(t *testing.T) {
+	providers := makeProviders()
+	candidates := []*ProviderConfig{providers["anthropic"], providers["openai"]}
+	stats := func(p *ProviderConfig) ProviderStats {
+		if p.Name == "openai" {
+			return ProviderStats{Samples: 50, P50: 100 * time.Millisecond}
+		}
+		return ProviderStats{Samples: 50, P50: time.Second, ErrorRate: 0.5}
+	}
+
+	r := NewRouter(providers, nil, "", true)
+	r.SetStrategy(Strategy{Name: StrategyWeightedRandom})
+	seed := uint64(1)
+	r.random = func() float64 {
+		seed = seed*6364136223846793005 + 1442695040888963407
+		return float64(seed>>11) / (1 << 53)
+	}
+
+	first := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		ordered, _ := r.Rank(candidates, stats)
+		first[ordered[0].Name]++
+	}
+	// Weights are 10 vs 0.5, so openai should go first ~95% of the time.
+	if first["openai"] < 900 || first["anthropic"] == 0 {
+		t.Errorf("first picks = %v; want openai to dominate without starving anthropic", first)
+	}
+}
+
+func TestRoute_StrategyPicksAmongAllWithoutFallback(t *testing.T) {
+	r := NewRouter(makeProviders(), nil, "", false)
+	r.SetStrategy(Strategy{Name: StrategyLowestLatency})
+	ps, rankings, err := r.Route("gpt-4o", func(p *ProviderConfig) ProviderStats {
+		if p.Name == "backup" {
+			return ProviderStats{Samples: 10, P95: 100 * time.Millisecond}
+		}
+		return ProviderStats{Samples: 10, P95: time.Second}
+	})
+	if err != nil {
+		t.Fatalf("Route: %v", err)
+	}
+	if len(ps) != 1 || ps[0].Name != "backup" {
+		t.Fatalf("Route = %v; want only the fastest provider, backup", providerNames(ps))
+	}
+	if len(rankings) != 2 {
+		t.Errorf("rankings = %+v; want both candidates explained", rankings)
+	}
+}
+
+func TestRoute_UnhealthyProvidersGoLast(t *testing.T) {
+	unhealthyOpenAI := func(p *ProviderConfig) ProviderStats {
+		return ProviderStats{Unhealthy: p.Name == "openai", Cost: -1}
+	}
+
+	r := NewRouter(makeProviders(), nil, "", true)
+	ps, _, err := r.Route("gpt-4o", unhealthyOpenAI)
+	if err != nil {
+		t.Fatalf("Route: %v", err)
+	}
+	if names := providerNames(ps); len(names) != 2 || names[0] != "backup" || names[1] != "openai" {
+		t.Errorf("priority Route = %v; want [backup openai]", names)
+	}
+
+	r.SetStrategy(Strategy{Name: StrategyLowestCost})
+	ps, rankings, err := r.Route("gpt-4o", unhealthyOpenAI)
+	if err != nil {
+		t.Fatalf("Route: %v", err)
+	}
+	if ps[0].Name != "backup" || rankings[1].Reason != "unhealthy" {
+		t.Errorf("lowest_cost Route = %v, %+v; want unhealthy openai ranked last", providerNames(ps), rankings)
+	}
+}
@@ -0,0 +1,141 @@
+package router
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+)
+
+// Rule routes every model matching a pattern to a provider. Match is a
+// path.Match glob such as "claude-sonnet-4-*"; Regex is an RE2 expression
+// matched against the whole model name. Exactly one of them is set. When
+// Model is set, matching requests are also rewritten to that model.
+type Rule struct {
+	Match    string
+	Regex    string
+	Provider string
+	Model    string
+
+	re *regexp.Regexp
+}
+
+// matches reports whether the rule applies to model.
+func (r *Rule) matches(model string) bool {
+	if r.re != nil {
+		return r.re.MatchString(model)
+	}
+	ok, err := path.Match(r.Match, model)
+	return err == nil && ok
+}
+
+// SetRules replaces the pattern routing rules. Rules are tried in order
+// after explicit model_map entries and before provider model lists.
+func (r *Router) SetRules(rules []Rule) error {
+	compiled := make([]Rule, 0, len(rules))
+	for i, rule := range rules {
+		switch {
+		case rule.Match != "" && rule.Regex != "":
+			return fmt.Errorf("router: rule %d: set match or regex, not both", i)
+		case rule.Regex != "":
+			re, err := regexp.Compile("^(?:" + rule.Regex + ")$")
+			if err != nil {
+				return fmt.Errorf("router: rule %d: %w", i, err)
+			}
+			rule.re = re
+		case rule.Match != "":
+			if _, err := path.Match(rule.Match, ""); err != nil {
+				return fmt.Errorf("router: rule %d: invalid match %q: %w", i, rule.Match, err)
+			}
+		default:
+			return fmt.Errorf("router: rule %d: match or regex is required", i)
+		}
+		compiled = append(compiled, rule)
+	}
+	r.rules = compiled
+	return nil
+}
+
+// SetAliases replaces the model aliases. Each alias (e.g. "fast") maps
+// provider names to the concrete model that provider serves for it.
+func (r *Router) SetAliases(aliases map[string]map[string]string) {
+	r.aliases = aliases
+}
+
+// IsAlias reports whether model is a configured alias.
+func (r *Router) IsAlias(model string) bool {
+	_, ok := r.aliases[model]
+	return ok
+}
+
+// Aliases returns the configured alias names, sorted.
+func (r *Router) Aliases() []string {
+	names := make([]string, 0, len(r.aliases))
+	for name := range r.aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// aliasProviders returns the enabled providers that serve alias, ordered by
+// priority (ascending) and then name.
+func (r *Router) aliasProviders(alias string) []*ProviderConfig {
+	var out []*ProviderConfig
+	for name := range r.aliases[alias] {
+		if p, ok := r.providers[name]; ok && p.Enabled {
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Priority != out[j].Priority {
+			return out[i].Priority < out[j].Priority
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// matchRule returns the first rule matching model whose provider is
+// enabled, or nil.
+func (r *Router) matchRule(model string) *Rule {
+	for i := range r.rules {
+		rule := &r.rules[i]
+		if !rule.matches(model) {
+			continue
+		}
+		if p, ok := r.providers[rule.Provider]; ok && p.Enabled {
+			return rule
+		}
+	}
+	return nil
+}
+
+// ModelFor returns the concrete model to send to provider for a requested
+// model: the provider's target for an alias, the rewrite of the matching
+// rule, or the requested model unchanged.
+func (r *Router) ModelFor(requested, provider string) string {
+	if targets, ok := r.aliases[requested]; ok {
+		if m := targets[provider]; m != "" {
+			return m
+		}
+		return requested
+	}
+	if _, ok := r.modelMap[requested]; ok {
+		return requested
+	}
+	if rule := r.matchRule(requested); rule != nil && rule.Provider == provider && rule.Model != "" {
+		return rule.Model
+	}
+	return requested
+}
+
+// ResolveModel resolves a requested model, which may be an alias or match a
+// rewriting rule, to its provider and the concrete model to send upstream.
+func (r *Router) ResolveModel(requested string) (*ProviderConfig, string, error) {
+	p, err := r.Resolve(requested)
+	if err != nil {
+		return nil, "", err
+	}
+	return p, r.ModelFor(requested, p.Name), nil
+}
@@ -0,0 +1,189 @@
+package router
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Provider selection strategies. StrategyPriority keeps the static Priority
+// order; the others reorder the candidates for each request from live
+// measurements.
+const (
+	StrategyPriority       = "priority"
+	StrategyLowestCost     = "lowest_cost"
+	StrategyLowestLatency  = "lowest_latency"
+	StrategyWeightedRandom = "weighted_random"
+)
+
+// Strategy configures how candidates serving the same model are ordered.
+// SLO and MaxErrorRate bound which providers StrategyLowestCost may pick
+// on price alone; zero disables the respective bound.
+type Strategy struct {
+	Name         string
+	SLO          time.Duration // p95 latency bound
+	MaxErrorRate float64
+}
+
+// ProviderStats is the live view of one candidate used to rank it.
+// Providers with no Samples are treated as meeting any SLO.
+type ProviderStats struct {
+	Samples     int
+	P50         time.Duration
+	P95         time.Duration
+	ErrorRate   float64
+	CircuitOpen bool
+	// Unhealthy is set when health checks consider the provider down.
+	Unhealthy bool
+	// Cost is the estimated cost in USD of the request on this provider;
+	// negative when the model has no known price.
+	Cost float64
+}
+
+// Ranking explains where one candidate was placed and why.
+type Ranking struct {
+	Provider string
+	Rank     int // 1 is tried first
+	Reason   string
+}
+
+// SetStrategy sets the provider selection strategy. An unknown or empty
+// name means StrategyPriority.
+func (r *Router) SetStrategy(s Strategy) {
+	switch s.Name {
+	case StrategyLowestCost, StrategyLowestLatency, StrategyWeightedRandom:
+	default:
+		s.Name = StrategyPriority
+	}
+	r.strategy = s
+}
+
+// StrategyName returns the active selection strategy.
+func (r *Router) StrategyName() string {
+	if r.strategy.Name == "" {
+		return StrategyPriority
+	}
+	return r.strategy.Name
+}
+
+type rankedCandidate struct {
+	p      *ProviderConfig
+	st     ProviderStats
+	key    float64 // sort key, lower first
+	tier   int     // sort tier, lower first
+	reason string
+}
+
+// Rank orders candidates according to the strategy using stats, and
+// explains each placement. Providers that are unhealthy or have an open
+// circuit always go last. With StrategyPriority the order is otherwise
+// unchanged.
+func (r *Router) Rank(candidates []*ProviderConfig, stats func(*ProviderConfig) ProviderStats) ([]*ProviderConfig, []Ranking) {
+	name := r.StrategyName()
+	rc := make([]*rankedCandidate, len(candidates))
+	for i, p := range candidates {
+		rc[i] = &rankedCandidate{p: p, st: stats(p), key: float64(i)}
+	}
+
+	switch name {
+	case StrategyLowestLatency:
+		for _, c := range rc {
+			if c.st.Samples == 0 {
+				c.tier, c.reason = 1, "no latency samples yet"
+				continue
+			}
+			c.key = c.st.P95.Seconds()
+			c.reason = fmt.Sprintf("p95 %s", c.st.P95.Round(time.Millisecond))
+		}
+	case StrategyLowestCost:
+		for _, c := range rc {
+			switch {
+			case r.strategy.SLO > 0 && c.st.Samples > 0 && c.st.P95 > r.strategy.SLO:
+				c.tier, c.key = 2, c.st.P95.Seconds()
+				c.reason = fmt.Sprintf("p95 %s exceeds SLO %s", c.st.P95.Round(time.Millisecond), r.strategy.SLO)
+			case r.strategy.MaxErrorRate > 0 && c.st.Samples > 0 && c.st.ErrorRate > r.strategy.MaxErrorRate:
+				c.tier, c.key = 2, c.st.ErrorRate
+				c.reason = fmt.Sprintf("error rate %.0f%% exceeds %.0f%%", c.st.ErrorRate*100, r.strategy.MaxErrorRate*100)
+			case c.st.Cost < 0:
+				c.tier = 1
+				c.reason = "within SLO, price unknown"
+			default:
+				c.key = c.st.Cost
+				c.reason = fmt.Sprintf("within SLO, estimated cost $%.6f", c.st.Cost)
+			}
+		}
+	case StrategyWeightedRandom:
+		r.weightedOrder(rc)
+	default:
+		for _, c := range rc {
+			c.reason = fmt.Sprintf("priority %d", c.p.Priority)
+		}
+	}
+
+	for _, c := range rc {
+		switch {
+		case c.st.CircuitOpen:
+			c.tier = 3
+			c.reason = "circuit open"
+		case c.st.Unhealthy:
+			c.tier = 3
+			c.reason = "unhealthy"
+		}
+	}
+	sort.SliceStable(rc, func(i, j int) bool {
+		if rc[i].tier != rc[j].tier {
+			return rc[i].tier < rc[j].tier
+		}
+		return rc[i].key < rc[j].key
+	})
+
+	ordered := make([]*ProviderConfig, len(rc))
+	rankings := make([]Ranking, len(rc))
+	for i, c := range rc {
+		ordered[i] = c.p
+		rankings[i] = Ranking{Provider: c.p.Name, Rank: i + 1, Reason: c.reason}
+	}
+	return ordered, rankings
+}
+
+// weightedOrder assigns sort keys for a weighted random order in which a
+// provider's chance of going first is proportional to its success rate over
+// its median latency. Providers without samples get the mean weight so they
+// still receive traffic and are measured.
+func (r *Router) weightedOrder(rc []*rankedCandidate) {
+	var sum float64
+	var measured int
+	weights := make([]float64, len(rc))
+	for i, c := range rc {
+		if c.st.Samples == 0 {
+			continue
+		}
+		p50 := math.Max(c.st.P50.Seconds(), 0.001)
+		weights[i] = math.Max(1-c.st.ErrorRate, 0.01) / p50
+		sum += weights[i]
+		measured++
+	}
+	mean := 1.0
+	if measured > 0 {
+		mean = sum / float64(measured)
+	}
+
+	random := r.random
+	if random == nil {
+		random = rand.Float64
+	}
+	for i, c := range rc {
+		w := weights[i]
+		if c.st.Samples == 0 {
+			w = mean
+			c.reason = "no samples yet, mean weight"
+		} else {
+			c.reason = fmt.Sprintf("weight %.2f (p50 %s, error rate %.0f%%)", w, c.st.P50.Round(time.Millisecond), c.st.ErrorRate*100)
+		}
+		// Weighted random sampling without replacement (Efraimidis–Spirakis):
+		// sort by -u^(1/w), i.e. highest u^(1/w) first.
+		c.key = -math.Pow(random(), 1/w)
+	}
+}
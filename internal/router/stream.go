@@ -0,0 +1,545 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+)
+
+// StreamEvent is one server-sent event of a streaming response.
+type StreamEvent struct {
+	Event string
+	Data  string
+}
+
+// StreamTranslator rewrites the server-sent events of a streaming response
+// from one API format into another. Events are decoded into format-neutral
+// chunks and re-encoded with the framing the target format's clients
+// expect, such as Anthropic's content block start and stop events. A
+// StreamTranslator is not safe for concurrent use.
+type StreamTranslator struct {
+	from     pipeline.APIFormat
+	enc      streamEncoder
+	finished bool
+
+	// sawCalls records whether a Gemini stream returned function calls,
+	// which Gemini finishes with STOP.
+	sawCalls bool
+}
+
+// streamChunk is one format-neutral piece of a streaming response. Stop
+// reasons use the Anthropic vocabulary.
+type streamChunk struct {
+	id, model  string
+	text       string
+	toolStart  *anthropicContentBlock
+	toolArgs   string
+	stopReason string
+	usage      *anthropicUsage
+	done       bool
+}
+
+type streamEncoder interface {
+	encode(c streamChunk) []StreamEvent
+	finish() []StreamEvent
+}
+
+// NewStreamTranslator returns a translator from one streaming format to
+// another.
+func NewStreamTranslator(from, to pipeline.APIFormat) (*StreamTranslator, error) {
+	switch from {
+	case pipeline.FormatAnthropic, pipeline.FormatOpenAI, pipeline.FormatGemini:
+	default:
+		return nil, fmt.Errorf("unsupported stream translation: %s -> %s", from, to)
+	}
+	t := &StreamTranslator{from: from}
+	switch to {
+	case pipeline.FormatAnthropic:
+		t.enc = &anthropicStreamEncoder{}
+	case pipeline.FormatOpenAI:
+		t.enc = &openaiStreamEncoder{toolIndex: -1, created: time.Now().Unix()}
+	case pipeline.FormatGemini:
+		t.enc = &geminiStreamEncoder{}
+	default:
+		return nil, fmt.Errorf("unsupported stream translation: %s -> %s", from, to)
+	}
+	return t, nil
+}
+
+// Translate returns the events that evt becomes in the target format.
+// Events that carry nothing the target format needs translate to none.
+func (t *StreamTranslator) Translate(evt StreamEvent) []StreamEvent {
+	if t.finished || evt.Data == "" {
+		return nil
+	}
+	var out []StreamEvent
+	for _, c := range t.decode(evt.Data) {
+		out = append(out, t.enc.encode(c)...)
+		if c.done {
+			out = append(out, t.Close()...)
+			break
+		}
+	}
+	return out
+}
+
+// Close returns the events that end the stream in the target format, if
+// the source stream ended without its own end marker (Gemini streams
+// simply stop).
+func (t *StreamTranslator) Close() []StreamEvent {
+	if t.finished {
+		return nil
+	}
+	t.finished = true
+	return t.enc.finish()
+}
+
+func (t *StreamTranslator) decode(data string) []streamChunk {
+	switch t.from {
+	case pipeline.FormatAnthropic:
+		return decodeAnthropicStream(data)
+	case pipeline.FormatOpenAI:
+		return decodeOpenAIStream(data)
+	default:
+		return t.decodeGeminiStream(data)
+	}
+}
+
+// decodeAnthropicStream decodes one Anthropic streaming event.
+func decodeAnthropicStream(data string) []streamChunk {
+	var evt struct {
+		Type    string `json:"type"`
+		Message struct {
+			ID    string         `json:"id"`
+			Model string         `json:"model"`
+			Usage anthropicUsage `json:"usage"`
+		} `json:"message"`
+		ContentBlock anthropicContentBlock `json:"content_block"`
+		Delta        struct {
+			Type        string `json:"type"`
+			Text        string `json:"text"`
+			PartialJSON string `json:"partial_json"`
+			StopReason  string `json:"stop_reason"`
+		} `json:"delta"`
+		Usage anthropicUsage `json:"usage"`
+	}
+	if err := json.Unmarshal([]byte(data), &evt); err != nil {
+		return nil
+	}
+
+	switch evt.Type {
+	case "message_start":
+		usage := evt.Message.Usage
+		return []streamChunk{{id: evt.Message.ID, model: evt.Message.Model, usage: &usage}}
+	case "content_block_start":
+		if evt.ContentBlock.Type == "tool_use" {
+			block := evt.ContentBlock
+			return []streamChunk{{toolStart: &block}}
+		}
+	case "content_block_delta":
+		switch evt.Delta.Type {
+		case "text_delta":
+			return []streamChunk{{text: evt.Delta.Text}}
+		case "input_json_delta":
+			return []streamChunk{{toolArgs: evt.Delta.PartialJSON}}
+		}
+	case "message_delta":
+		usage := evt.Usage
+		return []streamChunk{{stopReason: evt.Delta.StopReason, usage: &usage}}
+	case "message_stop":
+		return []streamChunk{{done: true}}
+	}
+	return nil
+}
+
+// decodeOpenAIStream decodes one OpenAI chat completion chunk.
+func decodeOpenAIStream(data string) []streamChunk {
+	if strings.TrimSpace(data) == "[DONE]" {
+		return []streamChunk{{done: true}}
+	}
+	var chunk struct {
+		ID      string `json:"id"`
+		Model   string `json:"model"`
+		Choices []struct {
+			Delta struct {
+				Content   string `json:"content"`
+				ToolCalls []struct {
+					ID       string             `json:"id"`
+					Function openaiToolFunction `json:"function"`
+				} `json:"tool_calls"`
+			} `json:"delta"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage *openaiUsage `json:"usage"`
+	}
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return nil
+	}
+
+	chunks := []streamChunk{{id: chunk.ID, model: chunk.Model}}
+	if len(chunk.Choices) > 0 {
+		choice := chunk.Choices[0]
+		if choice.Delta.Content != "" {
+			chunks = append(chunks, streamChunk{text: choice.Delta.Content})
+		}
+		for _, tc := range choice.Delta.ToolCalls {
+			if tc.ID != "" || tc.Function.Name != "" {
+				chunks = append(chunks, streamChunk{toolStart: &anthropicContentBlock{Type: "tool_use", ID: tc.ID, Name: tc.Function.Name}})
+			}
+			if tc.Function.Arguments != "" {
+				chunks = append(chunks, streamChunk{toolArgs: tc.Function.Arguments})
+			}
+		}
+		if choice.FinishReason != "" {
+			chunks = append(chunks, streamChunk{stopReason: mapOpenAIFinishReason(choice.FinishReason)})
+		}
+	}
+	if chunk.Usage != nil {
+		chunks = append(chunks, streamChunk{usage: &anthropicUsage{
+			InputTokens:  chunk.Usage.PromptTokens,
+			OutputTokens: chunk.Usage.CompletionTokens,
+		}})
+	}
+	return chunks
+}
+
+// decodeGeminiStream decodes one Gemini streamGenerateContent chunk. Each
+// chunk is a complete response holding the parts generated since the last.
+func (t *StreamTranslator) decodeGeminiStream(data string) []streamChunk {
+	var resp geminiResponse
+	if err := json.Unmarshal([]byte(data), &resp); err != nil {
+		return nil
+	}
+
+	chunks := []streamChunk{{id: resp.ResponseID, model: resp.ModelVersion}}
+	if len(resp.Candidates) > 0 {
+		cand := resp.Candidates[0]
+		for _, part := range cand.Content.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				t.sawCalls = true
+				args, err := json.Marshal(geminiArgs(part.FunctionCall.Args))
+				if err != nil {
+					continue
+				}
+				chunks = append(chunks,
+					streamChunk{toolStart: &anthropicContentBlock{Type: "tool_use", ID: geminiCallID(part.FunctionCall), Name: part.FunctionCall.Name}},
+					streamChunk{toolArgs: string(args)},
+				)
+			case part.Text != "":
+				chunks = append(chunks, streamChunk{text: part.Text})
+			}
+		}
+		if cand.FinishReason != "" {
+			chunks = append(chunks, streamChunk{stopReason: mapGeminiFinishReason(cand.FinishReason, t.sawCalls)})
+		}
+	}
+	if u := resp.UsageMetadata; u != nil {
+		chunks = append(chunks, streamChunk{usage: &anthropicUsage{
+			InputTokens:  u.PromptTokenCount,
+			OutputTokens: u.CandidatesTokenCount,
+		}})
+	}
+	return chunks
+}
+
+// streamState is the part of a stream every encoder tracks.
+type streamState struct {
+	id, model  string
+	stopReason string
+	usage      anthropicUsage
+}
+
+// update records the metadata in c.
+func (s *streamState) update(c streamChunk) {
+	if c.id != "" && s.id == "" {
+		s.id = c.id
+	}
+	if c.model != "" {
+		s.model = c.model
+	}
+	if c.stopReason != "" {
+		s.stopReason = c.stopReason
+	}
+	if c.usage != nil {
+		if c.usage.InputTokens > 0 {
+			s.usage.InputTokens = c.usage.InputTokens
+		}
+		if c.usage.OutputTokens > 0 {
+			s.usage.OutputTokens = c.usage.OutputTokens
+		}
+	}
+}
+
+// jsonEvent returns an event carrying v as JSON.
+func jsonEvent(event string, v interface{}) StreamEvent {
+	data, _ := json.Marshal(v)
+	return StreamEvent{Event: event, Data: string(data)}
+}
+
+// anthropicStreamEncoder writes Anthropic message streaming events.
+type anthropicStreamEncoder struct {
+	streamState
+	started    bool
+	blockType  string // type of the open content block, if any
+	blockIndex int
+}
+
+func (e *anthropicStreamEncoder) encode(c streamChunk) []StreamEvent {
+	e.update(c)
+	var out []StreamEvent
+	if !e.started && (c.text != "" || c.toolStart != nil || c.done) {
+		out = append(out, e.start())
+	}
+	switch {
+	case c.text != "":
+		if e.blockType != "text" {
+			out = append(out, e.closeBlock()...)
+			out = append(out, e.openBlock(map[string]interface{}{"type": "text", "text": ""}))
+			e.blockType = "text"
+		}
+		out = append(out, jsonEvent("content_block_delta", map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": e.blockIndex,
+			"delta": map[string]interface{}{"type": "text_delta", "text": c.text},
+		}))
+	case c.toolStart != nil:
+		out = append(out, e.closeBlock()...)
+		out = append(out, e.openBlock(map[string]interface{}{
+			"type": "tool_use", "id": c.toolStart.ID, "name": c.toolStart.Name, "input": map[string]interface{}{},
+		}))
+		e.blockType = "tool_use"
+	case c.toolArgs != "" && e.blockType == "tool_use":
+		out = append(out, jsonEvent("content_block_delta", map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": e.blockIndex,
+			"delta": map[string]interface{}{"type": "input_json_delta", "partial_json": c.toolArgs},
+		}))
+	}
+	return out
+}
+
+func (e *anthropicStreamEncoder) start() StreamEvent {
+	e.started = true
+	e.blockIndex = -1
+	return jsonEvent("message_start", map[string]interface{}{
+		"type": "message_start",
+		"message": map[string]interface{}{
+			"id":            e.id,
+			"type":          "message",
+			"role":          "assistant",
+			"model":         e.model,
+			"content":       []interface{}{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage":         map[string]int{"input_tokens": e.usage.InputTokens, "output_tokens": 0},
+		},
+	})
+}
+
+func (e *anthropicStreamEncoder) openBlock(block map[string]interface{}) StreamEvent {
+	e.blockIndex++
+	return jsonEvent("content_block_start", map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         e.blockIndex,
+		"content_block": block,
+	})
+}
+
+func (e *anthropicStreamEncoder) closeBlock() []StreamEvent {
+	if e.blockType == "" {
+		return nil
+	}
+	e.blockType = ""
+	return []StreamEvent{jsonEvent("content_block_stop", map[string]interface{}{
+		"type":  "content_block_stop",
+		"index": e.blockIndex,
+	})}
+}
+
+func (e *anthropicStreamEncoder) finish() []StreamEvent {
+	var out []StreamEvent
+	if !e.started {
+		out = append(out, e.start())
+	}
+	out = append(out, e.closeBlock()...)
+	stopReason := e.stopReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	out = append(out,
+		jsonEvent("message_delta", map[string]interface{}{
+			"type":  "message_delta",
+			"delta": map[string]interface{}{"stop_reason": stopReason, "stop_sequence": nil},
+			"usage": map[string]int{"output_tokens": e.usage.OutputTokens},
+		}),
+		jsonEvent("message_stop", map[string]string{"type": "message_stop"}),
+	)
+	return out
+}
+
+// openaiStreamEncoder writes OpenAI chat completion chunks.
+type openaiStreamEncoder struct {
+	streamState
+	created   int64
+	sentRole  bool
+	toolIndex int
+}
+
+func (e *openaiStreamEncoder) encode(c streamChunk) []StreamEvent {
+	e.update(c)
+	delta := map[string]interface{}{}
+	switch {
+	case c.text != "":
+		delta["content"] = c.text
+	case c.toolStart != nil:
+		e.toolIndex++
+		delta["tool_calls"] = []interface{}{map[string]interface{}{
+			"index":    e.toolIndex,
+			"id":       c.toolStart.ID,
+			"type":     "function",
+			"function": map[string]string{"name": c.toolStart.Name, "arguments": ""},
+		}}
+	case c.toolArgs != "" && e.toolIndex >= 0:
+		delta["tool_calls"] = []interface{}{map[string]interface{}{
+			"index":    e.toolIndex,
+			"function": map[string]string{"arguments": c.toolArgs},
+		}}
+	default:
+		return nil
+	}
+	return []StreamEvent{e.chunk(delta, nil, nil)}
+}
+
+func (e *openaiStreamEncoder) chunk(delta map[string]interface{}, finishReason interface{}, usage *openaiUsage) StreamEvent {
+	if !e.sentRole {
+		delta["role"] = "assistant"
+		e.sentRole = true
+	}
+	chunk := map[string]interface{}{
+		"id":      e.id,
+		"object":  "chat.completion.chunk",
+		"created": e.created,
+		"model":   e.model,
+		"choices": []interface{}{map[string]interface{}{
+			"index":         0,
+			"delta":         delta,
+			"finish_reason": finishReason,
+		}},
+	}
+	if usage != nil {
+		chunk["usage"] = usage
+	}
+	return jsonEvent("", chunk)
+}
+
+func (e *openaiStreamEncoder) finish() []StreamEvent {
+	finishReason := mapAnthropicStopReason(e.stopReason)
+	if finishReason == "" {
+		finishReason = "stop"
+	}
+	usage := &openaiUsage{
+		PromptTokens:     e.usage.InputTokens,
+		CompletionTokens: e.usage.OutputTokens,
+		TotalTokens:      e.usage.InputTokens + e.usage.OutputTokens,
+	}
+	return []StreamEvent{
+		e.chunk(map[string]interface{}{}, finishReason, usage),
+		{Data: "[DONE]"},
+	}
+}
+
+// geminiStreamEncoder writes Gemini streamGenerateContent chunks. Gemini
+// sends function calls whole, so a call is held until its arguments are
+// complete.
+type geminiStreamEncoder struct {
+	streamState
+	call *geminiFunctionCall
+	args strings.Builder
+}
+
+func (e *geminiStreamEncoder) encode(c streamChunk) []StreamEvent {
+	e.update(c)
+	var out []StreamEvent
+	switch {
+	case c.text != "":
+		if part, ok := e.flushCall(); ok {
+			out = append(out, e.chunk(part, "", nil))
+		}
+		out = append(out, e.chunk(geminiPart{Text: c.text}, "", nil))
+	case c.toolStart != nil:
+		if part, ok := e.flushCall(); ok {
+			out = append(out, e.chunk(part, "", nil))
+		}
+		e.call = &geminiFunctionCall{ID: c.toolStart.ID, Name: c.toolStart.Name}
+	case c.toolArgs != "" && e.call != nil:
+		e.args.WriteString(c.toolArgs)
+	}
+	return out
+}
+
+// flushCall returns the pending function call as a part, if there is one.
+func (e *geminiStreamEncoder) flushCall() (interface{}, bool) {
+	if e.call == nil {
+		return nil, false
+	}
+	call := e.call
+	if e.args.Len() > 0 {
+		_ = json.Unmarshal([]byte(e.args.String()), &call.Args)
+	}
+	e.call = nil
+	e.args.Reset()
+	return geminiPart{FunctionCall: call}, true
+}
+
+func (e *geminiStreamEncoder) chunk(part interface{}, finishReason string, usage *geminiUsage) StreamEvent {
+	return jsonEvent("", geminiStreamChunk{
+		Candidates: []geminiStreamCandidate{{
+			Content:      geminiStreamContent{Role: "model", Parts: []interface{}{part}},
+			FinishReason: finishReason,
+		}},
+		UsageMetadata: usage,
+		ModelVersion:  e.model,
+		ResponseID:    e.id,
+	})
+}
+
+func (e *geminiStreamEncoder) finish() []StreamEvent {
+	part, ok := e.flushCall()
+	if !ok {
+		// The final chunk carries the finish reason with an empty text part.
+		part = map[string]string{"text": ""}
+	}
+	finishReason := mapAnthropicStopReasonToGemini(e.stopReason)
+	if finishReason == "" {
+		finishReason = "STOP"
+	}
+	return []StreamEvent{e.chunk(part, finishReason, &geminiUsage{
+		PromptTokenCount:     e.usage.InputTokens,
+		CandidatesTokenCount: e.usage.OutputTokens,
+		TotalTokenCount:      e.usage.InputTokens + e.usage.OutputTokens,
+	})}
+}
+
+// geminiStreamChunk is a Gemini response whose parts may be any JSON value,
+// so that a final chunk can carry an empty text part.
+type geminiStreamChunk struct {
+	Candidates    []geminiStreamCandidate `json:"candidates"`
+	UsageMetadata *geminiUsage            `json:"usageMetadata,omitempty"`
+	ModelVersion  string                  `json:"modelVersion,omitempty"`
+	ResponseID    string                  `json:"responseId,omitempty"`
+}
+
+type geminiStreamCandidate struct {
+	Content      geminiStreamContent `json:"content"`
+	FinishReason string              `json:"finishReason,omitempty"`
+	Index        int                 `json:"index"`
+}
+
+type geminiStreamContent struct {
+	Role  string        `json:"role"`
+	Parts []interface{} `json:"parts"`
+}
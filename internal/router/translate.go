@@ -28,13 +28,14 @@ type anthropicMessage struct {
 }
 
 type anthropicContentBlock struct {
-	Type      string      `json:"type"`
-	Text      string      `json:"text,omitempty"`
-	ID        string      `json:"id,omitempty"`
-	Name      string      `json:"name,omitempty"`
-	Input     interface{} `json:"input,omitempty"`
-	ToolUseID string      `json:"tool_use_id,omitempty"`
-	Content   interface{} `json:"content,omitempty"`
+	Type      string                 `json:"type"`
+	Text      string                 `json:"text,omitempty"`
+	Source    map[string]interface{} `json:"source,omitempty"`
+	ID        string                 `json:"id,omitempty"`
+	Name      string                 `json:"name,omitempty"`
+	Input     interface{}            `json:"input,omitempty"`
+	ToolUseID string                 `json:"tool_use_id,omitempty"`
+	Content   interface{}            `json:"content,omitempty"`
 }
 
 type anthropicTool struct {
@@ -132,11 +133,18 @@ func TranslateRequest(req *pipeline.Request, from, to pipeline.APIFormat) ([]byt
 		return BuildRequestBody(req, to)
 	}
 
+	// Requests parsed from Gemini are normalized to Anthropic content
+	// blocks, and the Gemini builder accepts messages from either format.
 	switch {
-	case from == pipeline.FormatAnthropic && to == pipeline.FormatOpenAI:
+	case from == pipeline.FormatAnthropic && to == pipeline.FormatOpenAI,
+		from == pipeline.FormatGemini && to == pipeline.FormatOpenAI:
 		return translateAnthropicToOpenAI(req)
 	case from == pipeline.FormatOpenAI && to == pipeline.FormatAnthropic:
 		return translateOpenAIToAnthropic(req)
+	case from == pipeline.FormatGemini && to == pipeline.FormatAnthropic:
+		return buildAnthropicBody(req)
+	case to == pipeline.FormatGemini && (from == pipeline.FormatAnthropic || from == pipeline.FormatOpenAI):
+		return buildGeminiBody(req)
 	default:
 		return nil, fmt.Errorf("unsupported translation: %s -> %s", from, to)
 	}
@@ -409,11 +417,28 @@ func TranslateResponse(body []byte, from, to pipeline.APIFormat) ([]byte, error)
 		return body, nil
 	}
 
+	// Gemini responses translate through the Anthropic format.
 	switch {
 	case from == pipeline.FormatAnthropic && to == pipeline.FormatOpenAI:
 		return translateAnthropicResponseToOpenAI(body)
 	case from == pipeline.FormatOpenAI && to == pipeline.FormatAnthropic:
 		return translateOpenAIResponseToAnthropic(body)
+	case from == pipeline.FormatGemini && to == pipeline.FormatAnthropic:
+		return translateGeminiResponseToAnthropic(body)
+	case from == pipeline.FormatGemini && to == pipeline.FormatOpenAI:
+		aBody, err := translateGeminiResponseToAnthropic(body)
+		if err != nil {
+			return nil, err
+		}
+		return translateAnthropicResponseToOpenAI(aBody)
+	case from == pipeline.FormatAnthropic && to == pipeline.FormatGemini:
+		return translateAnthropicResponseToGemini(body)
+	case from == pipeline.FormatOpenAI && to == pipeline.FormatGemini:
+		aBody, err := translateOpenAIResponseToAnthropic(body)
+		if err != nil {
+			return nil, err
+		}
+		return translateAnthropicResponseToGemini(aBody)
 	default:
 		return nil, fmt.Errorf("unsupported response translation: %s -> %s", from, to)
 	}
@@ -557,6 +582,8 @@ func mapAnthropicStopReason(reason string) string {
 		return "tool_calls"
 	case "stop_sequence":
 		return "stop"
+	case "refusal":
+		return "content_filter"
 	default:
 		return reason
 	}
@@ -571,6 +598,8 @@ func mapOpenAIFinishReason(reason string) string {
 		return "max_tokens"
 	case "tool_calls", "function_call":
 		return "tool_use"
+	case "content_filter":
+		return "refusal"
 	default:
 		return reason
 	}
@@ -585,6 +614,8 @@ func BuildRequestBody(req *pipeline.Request, format pipeline.APIFormat) ([]byte,
 		return buildAnthropicBody(req)
 	case pipeline.FormatOpenAI:
 		return buildOpenAIBody(req)
+	case pipeline.FormatGemini:
+		return buildGeminiBody(req)
 	default:
 		return nil, fmt.Errorf("unsupported format for body building: %s", format)
 	}
@@ -4,17 +4,151 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
 
+	"github.com/allaspectsdev/tokenman/internal/alert"
+	"github.com/allaspectsdev/tokenman/internal/audit"
+	"github.com/allaspectsdev/tokenman/internal/config"
 	"github.com/allaspectsdev/tokenman/internal/pipeline"
+	"github.com/allaspectsdev/tokenman/internal/tokenizer"
 )
 
-// BudgetStore is the persistence interface for budget tracking.
+// BudgetStore is the persistence interface for budget tracking. Spending is
+// tracked per scope: "global" for the proxy-wide budget and "<kind>:<value>"
+// (e.g. "project:search") for scoped budgets.
 type BudgetStore interface {
-	GetBudget(period, periodStart string) (amount, limit float64, err error)
-	AddSpending(period, periodStart string, amount, limit float64) error
+	GetBudget(scope, period, periodStart string) (amount, limit float64, err error)
+	AddSpending(scope, period, periodStart string, amount, limit float64) error
+}
+
+// BudgetLine is one budget a reservation is held against. Committed is set by
+// ReserveBudget to the recorded spending plus all other live reservations,
+// and Spent to the recorded spending alone.
+type BudgetLine struct {
+	Scope       string
+	Period      string
+	PeriodStart string
+	Limit       float64
+	Committed   float64
+	Spent       float64
+}
+
+// BudgetReserver is implemented by budget stores that can reserve spending
+// atomically. When the store passed to NewBudgetMiddleware implements it,
+// each request holds its worst-case cost until it completes, so concurrent
+// requests cannot jointly overshoot a limit.
+type BudgetReserver interface {
+	// ReserveBudget holds amount against every line until expiresAt, or
+	// reserves nothing and returns false if any line lacks the headroom.
+	ReserveBudget(requestID string, amount float64, lines []BudgetLine, expiresAt time.Time) (bool, error)
+	// SettleBudgetReservation replaces the request's reservations with the
+	// actual amount spent.
+	SettleBudgetReservation(requestID string, amount float64, lines []BudgetLine) error
+	// ReleaseBudgetReservation drops the request's reservations.
+	ReleaseBudgetReservation(requestID string) error
+}
+
+// DefaultReservationTTL is how long a reservation is held when the request
+// holding it never completes.
+const DefaultReservationTTL = 10 * time.Minute
+
+// defaultReservationOutputTokens is the output allowance assumed for requests
+// that do not set max_tokens.
+const defaultReservationOutputTokens = 4096
+
+// Budget scope kinds.
+const (
+	BudgetScopeGlobal   = "global"
+	BudgetScopeProject  = "project"
+	BudgetScopeKey      = "key"
+	BudgetScopeModel    = "model"
+	BudgetScopeProvider = "provider"
+)
+
+// BudgetRule is a spending limit that applies to requests whose project,
+// virtual key, model, or provider matches Match. Match is an exact value or a
+// path.Match glob; each distinct matching value is tracked separately, so
+// {Scope: "project", Match: "*", Daily: 10} gives every project $10 a day.
+type BudgetRule struct {
+	Scope   string
+	Match   string
+	Hourly  float64
+	Daily   float64
+	Monthly float64
+}
+
+// BudgetRulesFromConfig converts scoped budget config into rules.
+func BudgetRulesFromConfig(scopes []config.ScopedBudgetConfig) []BudgetRule {
+	rules := make([]BudgetRule, 0, len(scopes))
+	for _, sb := range scopes {
+		rules = append(rules, BudgetRule{
+			Scope:   sb.Scope,
+			Match:   sb.Match,
+			Hourly:  sb.HourlyLimit,
+			Daily:   sb.DailyLimit,
+			Monthly: sb.MonthlyLimit,
+		})
+	}
+	return rules
+}
+
+// limit returns the rule's limit for the named period, 0 if unset.
+func (r BudgetRule) limit(period string) float64 {
+	switch period {
+	case "hourly":
+		return r.Hourly
+	case "daily":
+		return r.Daily
+	case "monthly":
+		return r.Monthly
+	}
+	return 0
+}
+
+// matches reports whether value falls under the rule.
+func (r BudgetRule) matches(value string) bool {
+	if value == "" {
+		return false
+	}
+	if r.Match == value {
+		return true
+	}
+	ok, err := path.Match(r.Match, value)
+	return err == nil && ok
+}
+
+// BudgetScopeName returns the ledger name for a scope kind and value, as
+// stored by BudgetStore and reported in BudgetError.Scope.
+func BudgetScopeName(kind, value string) string {
+	if kind == BudgetScopeGlobal {
+		return BudgetScopeGlobal
+	}
+	return kind + ":" + value
+}
+
+// BudgetLimit returns the tightest limit that rules place on the named scope
+// ledger for period, or 0 if no rule covers it.
+func BudgetLimit(rules []BudgetRule, scope, period string) float64 {
+	kind, value, ok := strings.Cut(scope, ":")
+	if !ok {
+		return 0
+	}
+	var tightest float64
+	for _, r := range rules {
+		if r.Scope != kind || !r.matches(value) {
+			continue
+		}
+		if l := r.limit(period); l > 0 && (tightest == 0 || l < tightest) {
+			tightest = l
+		}
+	}
+	return tightest
 }
 
 // BudgetError is returned when a budget limit is exceeded. It carries
@@ -22,9 +156,13 @@ type BudgetStore interface {
 type BudgetError struct {
 	Type    string  `json:"type"`
 	Message string  `json:"message"`
+	Scope   string  `json:"scope"`
 	Period  string  `json:"period"`
 	Limit   float64 `json:"limit"`
 	Spent   float64 `json:"spent"`
+	// EstimatedCost is the worst-case cost of the rejected request when it
+	// was refused for lack of headroom rather than an exhausted budget.
+	EstimatedCost float64 `json:"estimated_cost,omitempty"`
 }
 
 // Error implements the error interface.
@@ -35,15 +173,18 @@ func (e *BudgetError) Error() string {
 // ToJSON serializes the budget error to a JSON body suitable for an HTTP
 // response.
 func (e *BudgetError) ToJSON() []byte {
-	body := map[string]interface{}{
-		"error": map[string]interface{}{
-			"type":    e.Type,
-			"message": e.Message,
-			"period":  e.Period,
-			"limit":   e.Limit,
-			"spent":   e.Spent,
-		},
+	errObj := map[string]interface{}{
+		"type":    e.Type,
+		"message": e.Message,
+		"scope":   e.Scope,
+		"period":  e.Period,
+		"limit":   e.Limit,
+		"spent":   e.Spent,
+	}
+	if e.EstimatedCost > 0 {
+		errObj["estimated_cost"] = e.EstimatedCost
 	}
+	body := map[string]interface{}{"error": errObj}
 	b, _ := json.Marshal(body)
 	return b
 }
@@ -54,25 +195,50 @@ type budgetPeriod struct {
 	Limit float64
 }
 
+// budgetPeriods lists the tracked periods in check order.
+var budgetPeriods = []string{"hourly", "daily", "monthly"}
+
+// budgetCheck is a single scope and period limit that applies to a request.
+type budgetCheck struct {
+	scope  string
+	period string
+	limit  float64
+}
+
 // BudgetMiddleware is a pipeline.Middleware that enforces spending limits
-// across hourly, daily, and monthly periods.
+// across hourly, daily, and monthly periods, both proxy-wide and for scoped
+// budgets on projects, virtual keys, models, and providers.
 type BudgetMiddleware struct {
 	limits          []budgetPeriod
+	rules           []BudgetRule
+	reservationTTL  time.Duration
 	alertThresholds []float64
 	store           BudgetStore
+	notifier        alert.Notifier
+	auditor         audit.Recorder
 	enabled         bool
 }
 
-// Compile-time assertion that BudgetMiddleware implements pipeline.Middleware.
-var _ pipeline.Middleware = (*BudgetMiddleware)(nil)
+// Compile-time assertions that BudgetMiddleware implements pipeline.Middleware
+// and pipeline.Releaser.
+var (
+	_ pipeline.Middleware = (*BudgetMiddleware)(nil)
+	_ pipeline.Releaser   = (*BudgetMiddleware)(nil)
+)
 
 // NewBudgetMiddleware creates a new BudgetMiddleware.
 //
 //   - store is the persistence backend for budget data.
-//   - hourly, daily, monthly are the spending limits in USD (0 means no limit for that period).
+//   - hourly, daily, monthly are the global spending limits in USD (0 means no limit for that period).
+//   - rules are scoped budgets; a request must fit within every rule that matches it.
+//   - reservationTTL bounds how long an unfinished request holds its reservation (0 means DefaultReservationTTL).
 //   - thresholds are alert percentages (e.g., []float64{0.5, 0.8, 0.95}).
 //   - enabled controls whether the middleware is active.
-func NewBudgetMiddleware(store BudgetStore, hourly, daily, monthly float64, thresholds []float64, enabled bool) *BudgetMiddleware {
+func NewBudgetMiddleware(store BudgetStore, hourly, daily, monthly float64, rules []BudgetRule, reservationTTL time.Duration, thresholds []float64, enabled bool) *BudgetMiddleware {
+	if reservationTTL <= 0 {
+		reservationTTL = DefaultReservationTTL
+	}
+
 	var limits []budgetPeriod
 	if hourly > 0 {
 		limits = append(limits, budgetPeriod{Name: "hourly", Limit: hourly})
@@ -86,12 +252,25 @@ func NewBudgetMiddleware(store BudgetStore, hourly, daily, monthly float64, thre
 
 	return &BudgetMiddleware{
 		limits:          limits,
+		rules:           rules,
+		reservationTTL:  reservationTTL,
 		alertThresholds: thresholds,
 		store:           store,
 		enabled:         enabled,
 	}
 }
 
+// SetNotifier sets where threshold alerts are sent. Each threshold fires at
+// most once per budget period.
+func (b *BudgetMiddleware) SetNotifier(n alert.Notifier) {
+	b.notifier = n
+}
+
+// SetAuditor sets where requests refused by a budget are recorded.
+func (b *BudgetMiddleware) SetAuditor(r audit.Recorder) {
+	b.auditor = r
+}
+
 // Name returns the middleware name.
 func (b *BudgetMiddleware) Name() string {
 	return "budget"
@@ -102,45 +281,94 @@ func (b *BudgetMiddleware) Enabled() bool {
 	return b.enabled
 }
 
-// ProcessRequest checks current spending against all configured limits. If any
-// limit is exceeded, it returns a BudgetError that the HTTP handler should
-// convert to an HTTP 429 response.
+// ProcessRequest checks current spending against every limit that applies to
+// the request. If any limit is exceeded, it returns a BudgetError naming the
+// scope, which the HTTP handler should convert to an HTTP 429 response.
+//
+// When the store implements BudgetReserver, the request's worst-case cost is
+// reserved against every applicable budget in the same atomic step, and a
+// request whose worst case does not fit in the remaining headroom is
+// rejected up front.
 func (b *BudgetMiddleware) ProcessRequest(ctx context.Context, req *pipeline.Request) (*pipeline.Request, error) {
 	if b.store == nil {
 		return req, nil
 	}
 
-	for _, period := range b.limits {
-		start := periodStart(period.Name)
-		amount, _, err := b.store.GetBudget(period.Name, start)
+	provider, _ := req.Metadata["provider"].(string)
+	checks := b.applicable(req, provider)
+	if len(checks) == 0 {
+		return req, nil
+	}
+
+	// spent is the recorded spending on each budget, which alerts are based
+	// on. In-flight reservations count toward admission but are not money
+	// spent, so a burst of requests cannot fire an alert on its own.
+	var (
+		spent    []float64
+		estimate float64
+	)
+	if reserver, ok := b.store.(BudgetReserver); ok {
+		estimate = worstCaseCost(req)
+		lines := budgetLines(checks)
+		reserved, err := reserver.ReserveBudget(req.ID, estimate, lines, time.Now().Add(b.reservationTTL))
 		if err != nil {
-			// If no record exists yet, spending is zero.
-			amount = 0
+			// Fail open like the unreserved path: a store outage should not
+			// take the proxy down with it.
+			log.Error().Err(err).Str("request_id", req.ID).Msg("failed to reserve budget")
+			return req, nil
 		}
-
-		if amount >= period.Limit {
-			return nil, &BudgetError{
-				Type:    "budget_exceeded",
-				Message: fmt.Sprintf("%s budget limit exceeded: spent $%.4f of $%.4f", period.Name, amount, period.Limit),
-				Period:  period.Name,
-				Limit:   period.Limit,
-				Spent:   amount,
+		spent = make([]float64, len(lines))
+		for i, l := range lines {
+			spent[i] = l.Spent
+			if !reserved && (l.Committed >= l.Limit || l.Committed+estimate > l.Limit) {
+				return nil, b.block(req, newBudgetError(checks[i], l.Committed, estimate))
 			}
 		}
+	} else {
+		spent = make([]float64, len(checks))
+		for i, check := range checks {
+			amount, _, err := b.store.GetBudget(check.scope, check.period, periodStart(check.period))
+			if err != nil {
+				// If no record exists yet, spending is zero.
+				amount = 0
+			}
+			if amount >= check.limit {
+				return nil, b.block(req, newBudgetError(check, amount, 0))
+			}
+			spent[i] = amount
+		}
+	}
 
-		// Store alert information in metadata if approaching threshold.
-		if req.Metadata == nil {
-			req.Metadata = make(map[string]interface{})
+	// Store alert information in metadata if approaching threshold.
+	if req.Metadata == nil {
+		req.Metadata = make(map[string]interface{})
+	}
+	for i, check := range checks {
+		amount := spent[i]
+		key := "budget_alert_" + check.period
+		if check.scope != BudgetScopeGlobal {
+			key = fmt.Sprintf("budget_alert_%s_%s", check.scope, check.period)
 		}
+		var crossed []float64
 		for _, threshold := range b.alertThresholds {
-			if period.Limit > 0 && amount/period.Limit >= threshold {
-				key := fmt.Sprintf("budget_alert_%s", period.Name)
+			if amount/check.limit >= threshold {
 				req.Metadata[key] = map[string]interface{}{
+					"scope":     check.scope,
 					"threshold": threshold,
 					"spent":     amount,
-					"limit":     period.Limit,
-					"percent":   amount / period.Limit,
+					"limit":     check.limit,
+					"percent":   amount / check.limit,
 				}
+				crossed = append(crossed, threshold)
+			}
+		}
+		// Every crossed threshold is sent, lowest first, so a jump past
+		// several at once still delivers each one; the dedup keys keep the
+		// ones already sent this period from firing again.
+		if b.notifier != nil {
+			sort.Float64s(crossed)
+			for _, threshold := range crossed {
+				b.notifier.Notify(budgetAlert(check, threshold, amount))
 			}
 		}
 	}
@@ -148,28 +376,209 @@ func (b *BudgetMiddleware) ProcessRequest(ctx context.Context, req *pipeline.Req
 	return req, nil
 }
 
-// ProcessResponse records the cost of the completed request against all
-// configured budget periods.
+// block records that req was refused by a budget and returns err.
+func (b *BudgetMiddleware) block(req *pipeline.Request, err *BudgetError) error {
+	audit.Record(b.auditor, audit.Event{
+		Kind:   audit.KindBudgetBlocked,
+		Actor:  req.KeyID,
+		Action: "block",
+		Detail: map[string]interface{}{
+			"request_id":     req.ID,
+			"project":        req.Project,
+			"scope":          err.Scope,
+			"period":         err.Period,
+			"limit":          err.Limit,
+			"spent":          err.Spent,
+			"estimated_cost": err.EstimatedCost,
+		},
+	})
+	return err
+}
+
+// budgetAlert builds the alert for a budget whose spending has reached
+// threshold. Each threshold has its own dedup key, which makes it fire once
+// per budget period.
+func budgetAlert(check budgetCheck, threshold, spent float64) alert.Alert {
+	label := check.period
+	if check.scope != BudgetScopeGlobal {
+		label = check.scope + " " + check.period
+	}
+	severity := alert.SeverityWarning
+	if threshold >= 0.9 {
+		severity = alert.SeverityCritical
+	}
+	start := periodStart(check.period)
+	return alert.Alert{
+		Kind:     alert.KindBudgetThreshold,
+		Severity: severity,
+		Title:    fmt.Sprintf("%s budget at %.0f%%", label, spent/check.limit*100),
+		Message: fmt.Sprintf("Spent $%.4f of the $%.4f %s budget, crossing the %.0f%% alert threshold.",
+			spent, check.limit, label, threshold*100),
+		Fields: map[string]interface{}{
+			"scope":        check.scope,
+			"period":       check.period,
+			"period_start": start,
+			"threshold":    threshold,
+			"spent":        spent,
+			"limit":        check.limit,
+		},
+		DedupKey: fmt.Sprintf("budget/%s/%s/%s/%g", check.scope, check.period, start, threshold),
+	}
+}
+
+// newBudgetError builds the error for a budget that is full. estimate is the
+// worst-case cost that did not fit, 0 when only recorded spending was checked.
+func newBudgetError(check budgetCheck, spent, estimate float64) *BudgetError {
+	label := check.period
+	if check.scope != BudgetScopeGlobal {
+		label = check.scope + " " + check.period
+	}
+	msg := fmt.Sprintf("%s budget limit exceeded: spent $%.4f of $%.4f", label, spent, check.limit)
+	if spent < check.limit {
+		msg = fmt.Sprintf("%s budget limit would be exceeded: $%.4f committed plus worst-case $%.4f exceeds $%.4f",
+			label, spent, estimate, check.limit)
+	}
+	return &BudgetError{
+		Type:          "budget_exceeded",
+		Message:       msg,
+		Scope:         check.scope,
+		Period:        check.period,
+		Limit:         check.limit,
+		Spent:         spent,
+		EstimatedCost: estimate,
+	}
+}
+
+// ProcessResponse records the cost of the completed request against every
+// budget that applies to it, replacing any reservation made for it.
 func (b *BudgetMiddleware) ProcessResponse(ctx context.Context, req *pipeline.Request, resp *pipeline.Response) (*pipeline.Response, error) {
 	if b.store == nil {
 		return resp, nil
 	}
 
+	provider := resp.Provider
+	if provider == "" {
+		provider, _ = req.Metadata["provider"].(string)
+	}
+	checks := b.applicable(req, provider)
+
+	if reserver, ok := b.store.(BudgetReserver); ok {
+		if err := reserver.SettleBudgetReservation(req.ID, math.Max(resp.CostUSD, 0), budgetLines(checks)); err != nil {
+			log.Error().Err(err).Str("request_id", req.ID).Msg("failed to settle budget reservation")
+		}
+		return resp, nil
+	}
+
 	cost := resp.CostUSD
 	if cost <= 0 {
 		return resp, nil
 	}
 
-	for _, period := range b.limits {
-		start := periodStart(period.Name)
-		if err := b.store.AddSpending(period.Name, start, cost, period.Limit); err != nil {
-			log.Error().Err(err).Str("period", period.Name).Msg("failed to record budget spending")
+	for _, check := range checks {
+		start := periodStart(check.period)
+		if err := b.store.AddSpending(check.scope, check.period, start, cost, check.limit); err != nil {
+			log.Error().Err(err).Str("scope", check.scope).Str("period", check.period).Msg("failed to record budget spending")
 		}
 	}
 
 	return resp, nil
 }
 
+// Release drops any reservation still held for a request that never reached
+// ProcessResponse, such as one that failed upstream. Reservations that are
+// never released expire after the reservation TTL.
+func (b *BudgetMiddleware) Release(ctx context.Context, req *pipeline.Request) {
+	reserver, ok := b.store.(BudgetReserver)
+	if !ok {
+		return
+	}
+	if err := reserver.ReleaseBudgetReservation(req.ID); err != nil {
+		log.Error().Err(err).Str("request_id", req.ID).Msg("failed to release budget reservation")
+	}
+}
+
+// worstCaseCost is the most a request can cost: its input tokens plus the
+// full output allowance. Requests without max_tokens are assumed to use
+// defaultReservationOutputTokens.
+func worstCaseCost(req *pipeline.Request) float64 {
+	maxOut := req.MaxTokens
+	if maxOut <= 0 {
+		maxOut = defaultReservationOutputTokens
+	}
+	return tokenizer.EstimateCost(req.Model, req.TokensIn, maxOut)
+}
+
+// budgetLines converts checks into store lines for the current periods.
+func budgetLines(checks []budgetCheck) []BudgetLine {
+	lines := make([]BudgetLine, len(checks))
+	for i, c := range checks {
+		lines[i] = BudgetLine{
+			Scope:       c.scope,
+			Period:      c.period,
+			PeriodStart: periodStart(c.period),
+			Limit:       c.limit,
+		}
+	}
+	return lines
+}
+
+// applicable returns the budget checks for a request: the global limits
+// followed by every matching scoped rule. Rules that resolve to the same
+// scope ledger and period are merged, keeping the tightest limit, so spending
+// is recorded once per ledger.
+func (b *BudgetMiddleware) applicable(req *pipeline.Request, provider string) []budgetCheck {
+	checks := make([]budgetCheck, 0, len(b.limits))
+	for _, p := range b.limits {
+		checks = append(checks, budgetCheck{scope: BudgetScopeGlobal, period: p.Name, limit: p.Limit})
+	}
+
+	index := make(map[string]int)
+	for _, r := range b.rules {
+		value := budgetScopeValue(r.Scope, req, provider)
+		if !r.matches(value) {
+			continue
+		}
+		scope := BudgetScopeName(r.Scope, value)
+		for _, period := range budgetPeriods {
+			limit := r.limit(period)
+			if limit <= 0 {
+				continue
+			}
+			key := scope + "/" + period
+			if i, ok := index[key]; ok {
+				if limit < checks[i].limit {
+					checks[i].limit = limit
+				}
+				continue
+			}
+			index[key] = len(checks)
+			checks = append(checks, budgetCheck{scope: scope, period: period, limit: limit})
+		}
+	}
+	return checks
+}
+
+// budgetScopeValue returns the request attribute a scope kind is keyed on.
+func budgetScopeValue(kind string, req *pipeline.Request, provider string) string {
+	switch kind {
+	case BudgetScopeProject:
+		return req.Project
+	case BudgetScopeKey:
+		return req.KeyID
+	case BudgetScopeModel:
+		return req.Model
+	case BudgetScopeProvider:
+		return provider
+	}
+	return ""
+}
+
+// BudgetPeriodStart returns the start of the current "hourly", "daily", or
+// "monthly" period in the form budget ledgers are keyed by.
+func BudgetPeriodStart(period string) string {
+	return periodStart(period)
+}
+
 // periodStart returns the start of the current period as an ISO 8601 string.
 //   - "hourly": current hour truncated (e.g., "2024-01-15T14:00:00Z")
 //   - "daily": current day truncated (e.g., "2024-01-15T00:00:00Z")
@@ -4,8 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/allaspectsdev/tokenman/internal/alert"
 	"github.com/allaspectsdev/tokenman/internal/pipeline"
 )
 
@@ -26,16 +29,16 @@ func newMockBudgetStore() *mockBudgetStore {
 	return &mockBudgetStore{budgets: make(map[string]*budgetRecord)}
 }
 
-func (m *mockBudgetStore) GetBudget(period, periodStart string) (float64, float64, error) {
-	key := period + ":" + periodStart
+func (m *mockBudgetStore) GetBudget(scope, period, periodStart string) (float64, float64, error) {
+	key := scope + "/" + period + ":" + periodStart
 	if r, ok := m.budgets[key]; ok {
 		return r.amount, r.limit, nil
 	}
 	return 0, 0, fmt.Errorf("not found")
 }
 
-func (m *mockBudgetStore) AddSpending(period, periodStart string, amount, limit float64) error {
-	key := period + ":" + periodStart
+func (m *mockBudgetStore) AddSpending(scope, period, periodStart string, amount, limit float64) error {
+	key := scope + "/" + period + ":" + periodStart
 	if r, ok := m.budgets[key]; ok {
 		r.amount += amount
 		r.limit = limit
@@ -45,9 +48,14 @@ func (m *mockBudgetStore) AddSpending(period, periodStart string, amount, limit
 	return nil
 }
 
-// setBudget is a helper to pre-seed a budget for testing.
+// setBudget is a helper to pre-seed a global budget for testing.
 func (m *mockBudgetStore) setBudget(period, periodStart string, amount, limit float64) {
-	key := period + ":" + periodStart
+	m.setScopedBudget(BudgetScopeGlobal, period, periodStart, amount, limit)
+}
+
+// setScopedBudget pre-seeds a budget for the given scope.
+func (m *mockBudgetStore) setScopedBudget(scope, period, periodStart string, amount, limit float64) {
+	key := scope + "/" + period + ":" + periodStart
 	m.budgets[key] = &budgetRecord{amount: amount, limit: limit}
 }
 
@@ -57,7 +65,7 @@ func (m *mockBudgetStore) setBudget(period, periodStart string, amount, limit fl
 
 func TestBudget_WithinLimitAllows(t *testing.T) {
 	store := newMockBudgetStore()
-	mw := NewBudgetMiddleware(store, 10.0, 0, 0, nil, true)
+	mw := NewBudgetMiddleware(store, 10.0, 0, 0, nil, 0, nil, true)
 
 	req := &pipeline.Request{
 		Model:    "gpt-4",
@@ -75,7 +83,7 @@ func TestBudget_WithinLimitAllows(t *testing.T) {
 
 func TestBudget_WithinLimitPartialSpending(t *testing.T) {
 	store := newMockBudgetStore()
-	mw := NewBudgetMiddleware(store, 10.0, 0, 0, nil, true)
+	mw := NewBudgetMiddleware(store, 10.0, 0, 0, nil, 0, nil, true)
 
 	// Pre-seed some spending (below the limit).
 	start := periodStart("hourly")
@@ -101,7 +109,7 @@ func TestBudget_WithinLimitPartialSpending(t *testing.T) {
 
 func TestBudget_AtLimitReturnsError(t *testing.T) {
 	store := newMockBudgetStore()
-	mw := NewBudgetMiddleware(store, 10.0, 0, 0, nil, true)
+	mw := NewBudgetMiddleware(store, 10.0, 0, 0, nil, 0, nil, true)
 
 	start := periodStart("hourly")
 	store.setBudget("hourly", start, 10.0, 10.0) // exactly at limit
@@ -130,7 +138,7 @@ func TestBudget_AtLimitReturnsError(t *testing.T) {
 
 func TestBudget_OverLimitReturnsError(t *testing.T) {
 	store := newMockBudgetStore()
-	mw := NewBudgetMiddleware(store, 10.0, 0, 0, nil, true)
+	mw := NewBudgetMiddleware(store, 10.0, 0, 0, nil, 0, nil, true)
 
 	start := periodStart("hourly")
 	store.setBudget("hourly", start, 15.0, 10.0) // over limit
@@ -209,7 +217,7 @@ func TestBudgetError_ToJSON(t *testing.T) {
 
 func TestBudget_ProcessResponseRecordsSpending(t *testing.T) {
 	store := newMockBudgetStore()
-	mw := NewBudgetMiddleware(store, 10.0, 100.0, 0, nil, true)
+	mw := NewBudgetMiddleware(store, 10.0, 100.0, 0, nil, 0, nil, true)
 
 	req := &pipeline.Request{
 		Model:    "gpt-4",
@@ -227,7 +235,7 @@ func TestBudget_ProcessResponseRecordsSpending(t *testing.T) {
 
 	// Both hourly and daily budgets should have recorded the spending.
 	hourlyStart := periodStart("hourly")
-	amount, _, err := store.GetBudget("hourly", hourlyStart)
+	amount, _, err := store.GetBudget(BudgetScopeGlobal, "hourly", hourlyStart)
 	if err != nil {
 		t.Fatalf("GetBudget hourly: %v", err)
 	}
@@ -236,7 +244,7 @@ func TestBudget_ProcessResponseRecordsSpending(t *testing.T) {
 	}
 
 	dailyStart := periodStart("daily")
-	amount, _, err = store.GetBudget("daily", dailyStart)
+	amount, _, err = store.GetBudget(BudgetScopeGlobal, "daily", dailyStart)
 	if err != nil {
 		t.Fatalf("GetBudget daily: %v", err)
 	}
@@ -247,7 +255,7 @@ func TestBudget_ProcessResponseRecordsSpending(t *testing.T) {
 
 func TestBudget_ProcessResponseZeroCostNoOp(t *testing.T) {
 	store := newMockBudgetStore()
-	mw := NewBudgetMiddleware(store, 10.0, 0, 0, nil, true)
+	mw := NewBudgetMiddleware(store, 10.0, 0, 0, nil, 0, nil, true)
 
 	req := &pipeline.Request{
 		Model:    "gpt-4",
@@ -276,7 +284,7 @@ func TestBudget_ProcessResponseZeroCostNoOp(t *testing.T) {
 func TestBudget_AlertThresholds(t *testing.T) {
 	store := newMockBudgetStore()
 	thresholds := []float64{0.5, 0.8}
-	mw := NewBudgetMiddleware(store, 10.0, 0, 0, thresholds, true)
+	mw := NewBudgetMiddleware(store, 10.0, 0, 0, nil, 0, thresholds, true)
 
 	// Set spending at 80% of the limit.
 	start := periodStart("hourly")
@@ -310,13 +318,103 @@ func TestBudget_AlertThresholds(t *testing.T) {
 	}
 }
 
+// captureNotifier records alerts, applying dedup keys like alert.Dispatcher.
+type captureNotifier struct {
+	seen   map[string]bool
+	alerts []alert.Alert
+}
+
+func (c *captureNotifier) Notify(a alert.Alert) {
+	if a.DedupKey != "" {
+		if c.seen == nil {
+			c.seen = make(map[string]bool)
+		}
+		if c.seen[a.DedupKey] {
+			return
+		}
+		c.seen[a.DedupKey] = true
+	}
+	c.alerts = append(c.alerts, a)
+}
+
+func TestBudget_ThresholdAlertFiresOncePerPeriod(t *testing.T) {
+	store := newMockBudgetStore()
+	mw := NewBudgetMiddleware(store, 0, 10.0, 0, nil, 0, []float64{0.5, 0.8}, true)
+	notifier := &captureNotifier{}
+	mw.SetNotifier(notifier)
+
+	start := periodStart("daily")
+	send := func() {
+		t.Helper()
+		req := &pipeline.Request{Model: "gpt-4", Messages: []pipeline.Message{{Role: "user", Content: "hi"}}}
+		if _, err := mw.ProcessRequest(context.Background(), req); err != nil {
+			t.Fatalf("ProcessRequest: %v", err)
+		}
+	}
+
+	store.setBudget("daily", start, 4.0, 10.0)
+	send()
+	if len(notifier.alerts) != 0 {
+		t.Fatalf("alerts below threshold: %+v", notifier.alerts)
+	}
+
+	store.setBudget("daily", start, 6.0, 10.0)
+	send()
+	send()
+	store.setBudget("daily", start, 8.5, 10.0)
+	send()
+	send()
+
+	if len(notifier.alerts) != 2 {
+		t.Fatalf("got %d alerts, want one per threshold: %+v", len(notifier.alerts), notifier.alerts)
+	}
+	for i, want := range []float64{0.5, 0.8} {
+		a := notifier.alerts[i]
+		if a.Kind != alert.KindBudgetThreshold {
+			t.Errorf("alerts[%d].Kind = %q", i, a.Kind)
+		}
+		if a.Fields["threshold"] != want || a.Fields["period_start"] != start {
+			t.Errorf("alerts[%d].Fields = %v, want threshold %v in period %s", i, a.Fields, want, start)
+		}
+	}
+}
+
+func TestBudget_JumpPastSeveralThresholdsAlertsEach(t *testing.T) {
+	store := newMockBudgetStore()
+	mw := NewBudgetMiddleware(store, 0, 10.0, 0, nil, 0, []float64{0.95, 0.5, 0.8}, true)
+	notifier := &captureNotifier{}
+	mw.SetNotifier(notifier)
+
+	start := periodStart("daily")
+	store.setBudget("daily", start, 9.6, 10.0)
+	req := &pipeline.Request{Model: "gpt-4", Messages: []pipeline.Message{{Role: "user", Content: "hi"}}}
+	if _, err := mw.ProcessRequest(context.Background(), req); err != nil {
+		t.Fatalf("ProcessRequest: %v", err)
+	}
+
+	if len(notifier.alerts) != 3 {
+		t.Fatalf("got %d alerts, want one per crossed threshold: %+v", len(notifier.alerts), notifier.alerts)
+	}
+	seen := make(map[string]bool)
+	for i, want := range []float64{0.5, 0.8, 0.95} {
+		a := notifier.alerts[i]
+		if a.Fields["threshold"] != want {
+			t.Errorf("alerts[%d] threshold = %v, want %v (lowest first)", i, a.Fields["threshold"], want)
+		}
+		if seen[a.DedupKey] {
+			t.Errorf("alerts[%d] reuses dedup key %s", i, a.DedupKey)
+		}
+		seen[a.DedupKey] = true
+	}
+}
+
 // ---------------------------------------------------------------------------
 // Disabled middleware is no-op
 // ---------------------------------------------------------------------------
 
 func TestBudget_DisabledIsNoOp(t *testing.T) {
 	store := newMockBudgetStore()
-	mw := NewBudgetMiddleware(store, 10.0, 0, 0, nil, false)
+	mw := NewBudgetMiddleware(store, 10.0, 0, 0, nil, 0, nil, false)
 
 	if mw.Enabled() {
 		t.Error("expected disabled middleware to report Enabled() = false")
@@ -337,7 +435,7 @@ func TestBudget_DisabledIsNoOp(t *testing.T) {
 func TestBudget_MultiplePeriods(t *testing.T) {
 	store := newMockBudgetStore()
 	// hourly=5, daily=50, monthly=500
-	mw := NewBudgetMiddleware(store, 5.0, 50.0, 500.0, nil, true)
+	mw := NewBudgetMiddleware(store, 5.0, 50.0, 500.0, nil, 0, nil, true)
 
 	// Set hourly spending below limit, but daily above limit.
 	hourlyStart := periodStart("hourly")
@@ -366,7 +464,7 @@ func TestBudget_MultiplePeriods(t *testing.T) {
 
 func TestBudget_HourlyLimitDoesNotAffectMonthly(t *testing.T) {
 	store := newMockBudgetStore()
-	mw := NewBudgetMiddleware(store, 5.0, 0, 500.0, nil, true)
+	mw := NewBudgetMiddleware(store, 5.0, 0, 500.0, nil, 0, nil, true)
 
 	// Only hourly is at limit; monthly is fine.
 	hourlyStart := periodStart("hourly")
@@ -387,3 +485,235 @@ func TestBudget_HourlyLimitDoesNotAffectMonthly(t *testing.T) {
 		t.Errorf("expected hourly period to be exceeded, got %q", budgetErr.Period)
 	}
 }
+
+// ---------------------------------------------------------------------------
+// Scoped budgets
+// ---------------------------------------------------------------------------
+
+func TestBudget_ScopedProjectLimitNamesScope(t *testing.T) {
+	store := newMockBudgetStore()
+	rules := []BudgetRule{{Scope: BudgetScopeProject, Match: "*", Daily: 2.0}}
+	mw := NewBudgetMiddleware(store, 0, 100.0, 0, rules, 0, nil, true)
+
+	store.setScopedBudget("project:runaway", "daily", periodStart("daily"), 2.0, 2.0)
+
+	// The runaway project is blocked by its own budget...
+	_, err := mw.ProcessRequest(context.Background(), &pipeline.Request{Model: "gpt-4", Project: "runaway"})
+	budgetErr, ok := err.(*BudgetError)
+	if !ok {
+		t.Fatalf("expected *BudgetError, got %v", err)
+	}
+	if budgetErr.Scope != "project:runaway" || budgetErr.Period != "daily" {
+		t.Errorf("scope/period = %q/%q, want project:runaway/daily", budgetErr.Scope, budgetErr.Period)
+	}
+	if !strings.Contains(string(budgetErr.ToJSON()), `"scope":"project:runaway"`) {
+		t.Errorf("ToJSON missing scope: %s", budgetErr.ToJSON())
+	}
+
+	// ...while other projects keep working.
+	if _, err := mw.ProcessRequest(context.Background(), &pipeline.Request{Model: "gpt-4", Project: "search"}); err != nil {
+		t.Errorf("other project blocked: %v", err)
+	}
+}
+
+func TestBudget_RequestMustFitEveryScope(t *testing.T) {
+	store := newMockBudgetStore()
+	rules := []BudgetRule{
+		{Scope: BudgetScopeModel, Match: "gpt-4*", Hourly: 1.0},
+		{Scope: BudgetScopeKey, Match: "vk_ci", Monthly: 50.0},
+		{Scope: BudgetScopeProvider, Match: "openai", Daily: 20.0},
+	}
+	mw := NewBudgetMiddleware(store, 0, 0, 0, rules, 0, nil, true)
+
+	req := &pipeline.Request{
+		Model:    "gpt-4o",
+		KeyID:    "vk_ci",
+		Metadata: map[string]interface{}{"provider": "openai"},
+	}
+	if _, err := mw.ProcessRequest(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Spending is recorded against each matching scope.
+	if _, err := mw.ProcessResponse(context.Background(), req, &pipeline.Response{CostUSD: 1.0}); err != nil {
+		t.Fatalf("ProcessResponse: %v", err)
+	}
+	for _, c := range []struct{ scope, period string }{
+		{"model:gpt-4o", "hourly"},
+		{"key:vk_ci", "monthly"},
+		{"provider:openai", "daily"},
+	} {
+		amount, _, err := store.GetBudget(c.scope, c.period, periodStart(c.period))
+		if err != nil || amount != 1.0 {
+			t.Errorf("%s %s spending = %v, %v; want 1.0", c.scope, c.period, amount, err)
+		}
+	}
+
+	// The model budget is now exhausted even though the others have room.
+	_, err := mw.ProcessRequest(context.Background(), req)
+	budgetErr, ok := err.(*BudgetError)
+	if !ok || budgetErr.Scope != "model:gpt-4o" {
+		t.Fatalf("expected model:gpt-4o budget error, got %v", err)
+	}
+}
+
+func TestBudget_OverlappingRulesUseTightestLimitAndRecordOnce(t *testing.T) {
+	store := newMockBudgetStore()
+	rules := []BudgetRule{
+		{Scope: BudgetScopeProject, Match: "*", Daily: 10.0},
+		{Scope: BudgetScopeProject, Match: "team-*", Daily: 3.0},
+	}
+	mw := NewBudgetMiddleware(store, 0, 0, 0, rules, 0, nil, true)
+
+	req := &pipeline.Request{Model: "gpt-4", Project: "team-a"}
+	mw.ProcessResponse(context.Background(), req, &pipeline.Response{CostUSD: 3.0})
+
+	amount, limit, _ := store.GetBudget("project:team-a", "daily", periodStart("daily"))
+	if amount != 3.0 || limit != 3.0 {
+		t.Errorf("amount/limit = %v/%v, want 3/3", amount, limit)
+	}
+	if _, err := mw.ProcessRequest(context.Background(), req); err == nil {
+		t.Error("expected tighter team-* budget to block")
+	}
+	if got := BudgetLimit(rules, "project:team-a", "daily"); got != 3.0 {
+		t.Errorf("BudgetLimit = %v, want 3", got)
+	}
+	if got := BudgetLimit(rules, "project:other", "hourly"); got != 0 {
+		t.Errorf("BudgetLimit for unset period = %v, want 0", got)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Reservations
+// ---------------------------------------------------------------------------
+
+// mockReservingStore adds BudgetReserver to mockBudgetStore, keeping
+// reservations in memory keyed by request ID.
+type mockReservingStore struct {
+	*mockBudgetStore
+	reserved map[string]float64 // request ID -> amount held on each line
+	settled  map[string]float64
+}
+
+func newMockReservingStore() *mockReservingStore {
+	return &mockReservingStore{
+		mockBudgetStore: newMockBudgetStore(),
+		reserved:        make(map[string]float64),
+		settled:         make(map[string]float64),
+	}
+}
+
+func (m *mockReservingStore) ReserveBudget(requestID string, amount float64, lines []BudgetLine, expiresAt time.Time) (bool, error) {
+	var held float64
+	for _, a := range m.reserved {
+		held += a
+	}
+	ok := true
+	for i := range lines {
+		spent, _, _ := m.GetBudget(lines[i].Scope, lines[i].Period, lines[i].PeriodStart)
+		lines[i].Spent = spent
+		lines[i].Committed = spent + held
+		if lines[i].Committed >= lines[i].Limit || lines[i].Committed+amount > lines[i].Limit {
+			ok = false
+		}
+	}
+	if ok {
+		m.reserved[requestID] = amount
+	}
+	return ok, nil
+}
+
+func (m *mockReservingStore) SettleBudgetReservation(requestID string, amount float64, lines []BudgetLine) error {
+	delete(m.reserved, requestID)
+	m.settled[requestID] = amount
+	for _, l := range lines {
+		m.AddSpending(l.Scope, l.Period, l.PeriodStart, amount, l.Limit)
+	}
+	return nil
+}
+
+func (m *mockReservingStore) ReleaseBudgetReservation(requestID string) error {
+	delete(m.reserved, requestID)
+	return nil
+}
+
+func TestBudget_ReservesWorstCaseCost(t *testing.T) {
+	store := newMockReservingStore()
+	// gpt-4o: $2.50 in / $10.00 out per million tokens.
+	mw := NewBudgetMiddleware(store, 0, 1.0, 0, nil, 0, nil, true)
+
+	// 40k output tokens is a $0.40 worst case; two fit under $1, a third does not.
+	newReq := func(id string) *pipeline.Request {
+		return &pipeline.Request{ID: id, Model: "gpt-4o", MaxTokens: 40_000}
+	}
+	for _, id := range []string{"r1", "r2"} {
+		if _, err := mw.ProcessRequest(context.Background(), newReq(id)); err != nil {
+			t.Fatalf("%s: unexpected error: %v", id, err)
+		}
+	}
+	_, err := mw.ProcessRequest(context.Background(), newReq("r3"))
+	budgetErr, ok := err.(*BudgetError)
+	if !ok {
+		t.Fatalf("expected *BudgetError for r3, got %v", err)
+	}
+	if budgetErr.EstimatedCost != 0.4 || budgetErr.Spent != 0.8 {
+		t.Errorf("EstimatedCost/Spent = %v/%v, want 0.4/0.8", budgetErr.EstimatedCost, budgetErr.Spent)
+	}
+	if !strings.Contains(string(budgetErr.ToJSON()), `"estimated_cost":0.4`) {
+		t.Errorf("ToJSON missing estimated_cost: %s", budgetErr.ToJSON())
+	}
+
+	// r1 completes cheaply and r2 fails before the response phase; both
+	// reservations are freed, so r3 now fits.
+	r1 := newReq("r1")
+	if _, err := mw.ProcessResponse(context.Background(), r1, &pipeline.Response{CostUSD: 0.05}); err != nil {
+		t.Fatalf("ProcessResponse: %v", err)
+	}
+	mw.Release(context.Background(), r1)
+	mw.Release(context.Background(), newReq("r2"))
+	if store.settled["r1"] != 0.05 {
+		t.Errorf("settled r1 = %v, want 0.05", store.settled["r1"])
+	}
+	if len(store.reserved) != 0 {
+		t.Errorf("reservations left after release: %v", store.reserved)
+	}
+	if _, err := mw.ProcessRequest(context.Background(), newReq("r3")); err != nil {
+		t.Errorf("r3 after release: %v", err)
+	}
+}
+
+func TestBudget_ReservationsDoNotFireAlerts(t *testing.T) {
+	store := newMockReservingStore()
+	mw := NewBudgetMiddleware(store, 0, 1.0, 0, nil, 0, []float64{0.5}, true)
+	notifier := &captureNotifier{}
+	mw.SetNotifier(notifier)
+
+	// Two $0.40 worst cases are in flight, so the third request sees $0.80
+	// committed, but nothing has been spent yet.
+	newReq := func(id string, maxTokens int) *pipeline.Request {
+		return &pipeline.Request{ID: id, Model: "gpt-4o", MaxTokens: maxTokens}
+	}
+	for _, id := range []string{"r1", "r2"} {
+		if _, err := mw.ProcessRequest(context.Background(), newReq(id, 40_000)); err != nil {
+			t.Fatalf("%s: unexpected error: %v", id, err)
+		}
+	}
+	if _, err := mw.ProcessRequest(context.Background(), newReq("r3", 1_000)); err != nil {
+		t.Fatalf("r3: unexpected error: %v", err)
+	}
+	if len(notifier.alerts) != 0 {
+		t.Fatalf("reservations fired alerts: %+v", notifier.alerts)
+	}
+
+	// Once r1 settles at $0.60, real spending has crossed the threshold.
+	if _, err := mw.ProcessResponse(context.Background(), newReq("r1", 40_000), &pipeline.Response{CostUSD: 0.6}); err != nil {
+		t.Fatalf("ProcessResponse: %v", err)
+	}
+	mw.Release(context.Background(), newReq("r2", 40_000))
+	if _, err := mw.ProcessRequest(context.Background(), newReq("r4", 1_000)); err != nil {
+		t.Fatalf("r4: unexpected error: %v", err)
+	}
+	if len(notifier.alerts) != 1 || notifier.alerts[0].Fields["spent"] != 0.6 {
+		t.Errorf("alerts = %+v; want one alert for $0.60 spent", notifier.alerts)
+	}
+}
@@ -2,36 +2,69 @@ package security
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
+	"math"
 	"regexp"
+	"sort"
 	"strings"
 
+	"github.com/allaspectsdev/tokenman/internal/audit"
 	"github.com/allaspectsdev/tokenman/internal/pipeline"
+	"github.com/allaspectsdev/tokenman/internal/tracing"
 )
 
 // injectionPattern holds a compiled regex and a human-readable category
-// for a prompt injection technique.
+// for a prompt injection technique. Weight is the pattern's contribution to
+// a message's risk score, in (0, 1].
 type injectionPattern struct {
 	Name    string
 	Regex   *regexp.Regexp
 	Category string
+	Weight   float64
 }
 
-// InjectionDetection records a single detected injection attempt.
+// obfuscationBoost is added to a pattern's weight when it only matched after
+// normalization or decoding; hiding an instruction is itself a signal.
+const obfuscationBoost = 0.15
+
+// maxTopPatterns caps how many contributing patterns are reported per request.
+const maxTopPatterns = 3
+
+// InjectionDetection records a single detected injection attempt. Score is
+// the risk score of the message the detection belongs to, Obfuscation names
+// the technique that was undone before the pattern matched, and Tool names the
+// tool whose result contained it.
 type InjectionDetection struct {
-	Pattern  string `json:"pattern"`
-	Category string `json:"category"`
-	Match    string `json:"match"`
-	Field    string `json:"field"`
+	Pattern     string  `json:"pattern"`
+	Category    string  `json:"category"`
+	Match       string  `json:"match"`
+	Field       string  `json:"field"`
+	Weight      float64 `json:"weight"`
+	Score       float64 `json:"score"`
+	Obfuscation string  `json:"obfuscation,omitempty"`
+	Tool        string  `json:"tool,omitempty"`
+}
+
+// InjectionRisk summarizes the injection scan of a request. It is stored in
+// request metadata under "injection_risk".
+type InjectionRisk struct {
+	Score       float64  `json:"score"`
+	Action      string   `json:"action"`
+	TopPatterns []string `json:"top_patterns"`
+	Tools       []string `json:"tools,omitempty"`
 }
 
 // InjectionMiddleware is a pipeline.Middleware that scans user messages and
 // tool results for prompt injection patterns.
 type InjectionMiddleware struct {
-	patterns []*injectionPattern
-	action   string // "log", "sanitize", or "block"
-	enabled  bool
+	patterns       []*injectionPattern
+	toolPatterns   []*injectionPattern // extra rules for untrusted tool output
+	action         string              // "log", "sanitize", or "block"
+	logThreshold   float64             // minimum message score that is recorded
+	blockThreshold float64             // message score that blocks regardless of action; 0 disables
+	tools          ToolResultPolicy
+	auditor        audit.Recorder
+	enabled        bool
 }
 
 // Compile-time assertion that InjectionMiddleware implements pipeline.Middleware.
@@ -45,26 +78,31 @@ func compileInjectionPatterns() []*injectionPattern {
 			Name:     "ignore_previous",
 			Regex:    regexp.MustCompile(`(?i)ignore\s+(all\s+)?(previous|prior|above|earlier)\s+(instructions?|prompts?|directives?|rules?)`),
 			Category: "instruction_override",
+			Weight:   0.8,
 		},
 		{
 			Name:     "disregard_above",
 			Regex:    regexp.MustCompile(`(?i)disregard\s+(all\s+)?(above|previous|prior|earlier)\s*(instructions?|prompts?|directives?|text)?`),
 			Category: "instruction_override",
+			Weight:   0.7,
 		},
 		{
 			Name:     "new_instructions",
 			Regex:    regexp.MustCompile(`(?i)(new|updated|revised|real)\s+instructions?\s*:`),
 			Category: "instruction_override",
+			Weight:   0.5,
 		},
 		{
 			Name:     "system_prompt_override",
 			Regex:    regexp.MustCompile(`(?i)system\s+prompt\s*:`),
 			Category: "instruction_override",
+			Weight:   0.6,
 		},
 		{
 			Name:     "forget_instructions",
 			Regex:    regexp.MustCompile(`(?i)forget\s+(all\s+)?(your\s+)?(previous\s+)?(instructions?|rules?|guidelines?)`),
 			Category: "instruction_override",
+			Weight:   0.7,
 		},
 
 		// Delimiter injection patterns.
@@ -72,26 +110,31 @@ func compileInjectionPatterns() []*injectionPattern {
 			Name:     "code_block_system",
 			Regex:    regexp.MustCompile("(?i)```\\s*system"),
 			Category: "delimiter_injection",
+			Weight:   0.5,
 		},
 		{
 			Name:     "markdown_system",
 			Regex:    regexp.MustCompile(`(?i)###\s+SYSTEM`),
 			Category: "delimiter_injection",
+			Weight:   0.4,
 		},
 		{
 			Name:     "chatml_system",
 			Regex:    regexp.MustCompile(`<\|im_start\|>system`),
 			Category: "delimiter_injection",
+			Weight:   0.9,
 		},
 		{
 			Name:     "xml_system_tag",
 			Regex:    regexp.MustCompile(`(?i)<system\s*>`),
 			Category: "delimiter_injection",
+			Weight:   0.5,
 		},
 		{
 			Name:     "chatml_end_start",
 			Regex:    regexp.MustCompile(`<\|im_end\|>\s*<\|im_start\|>`),
 			Category: "delimiter_injection",
+			Weight:   0.9,
 		},
 
 		// Role confusion patterns.
@@ -99,33 +142,34 @@ func compileInjectionPatterns() []*injectionPattern {
 			Name:     "you_are_now",
 			Regex:    regexp.MustCompile(`(?i)you\s+are\s+now\s+`),
 			Category: "role_confusion",
+			Weight:   0.3,
 		},
 		{
 			Name:     "act_as_if",
 			Regex:    regexp.MustCompile(`(?i)act\s+as\s+if\s+you\s+are\s+`),
 			Category: "role_confusion",
+			Weight:   0.3,
 		},
 		{
 			Name:     "pretend_you_are",
 			Regex:    regexp.MustCompile(`(?i)pretend\s+(that\s+)?you\s+are\s+`),
 			Category: "role_confusion",
+			Weight:   0.35,
 		},
 		{
 			Name:     "roleplay_as",
 			Regex:    regexp.MustCompile(`(?i)(roleplay|role[\-\s]play)\s+as\s+`),
 			Category: "role_confusion",
+			Weight:   0.25,
 		},
 
-		// Base64-encoded injection detection.
+		// Literal base64 fragments. Full payloads are decoded and rescanned
+		// by expandVariants, so this only adds a weak signal of its own.
 		{
 			Name:     "base64_ignore",
 			Regex:    regexp.MustCompile(`(?i)aWdub3Jl`), // base64 for "ignore"
 			Category: "encoded_injection",
-		},
-		{
-			Name:     "base64_block",
-			Regex:    regexp.MustCompile(`[A-Za-z0-9+/]{40,}={0,2}`),
-			Category: "encoded_injection",
+			Weight:   0.2,
 		},
 	}
 }
@@ -133,15 +177,30 @@ func compileInjectionPatterns() []*injectionPattern {
 // NewInjectionMiddleware creates a new InjectionMiddleware.
 //
 //   - action is one of "log", "sanitize", or "block".
+//   - logThreshold is the minimum per-message risk score (0–1) at which
+//     detections are recorded and acted on; 0 records every match.
+//   - blockThreshold is the risk score at or above which a request is
+//     blocked whatever the action; 0 disables score-based blocking.
+//   - tools is the policy applied to tool results.
 //   - enabled controls whether the middleware is active.
-func NewInjectionMiddleware(action string, enabled bool) *InjectionMiddleware {
+func NewInjectionMiddleware(action string, logThreshold, blockThreshold float64, tools ToolResultPolicy, enabled bool) *InjectionMiddleware {
 	return &InjectionMiddleware{
-		patterns: compileInjectionPatterns(),
-		action:   action,
-		enabled:  enabled,
+		patterns:       compileInjectionPatterns(),
+		toolPatterns:   compileToolResultPatterns(),
+		tools:          tools,
+		action:         action,
+		logThreshold:   logThreshold,
+		blockThreshold: blockThreshold,
+		enabled:        enabled,
 	}
 }
 
+// SetAuditor sets where detections at or above the log threshold are
+// recorded.
+func (m *InjectionMiddleware) SetAuditor(r audit.Recorder) {
+	m.auditor = r
+}
+
 // Name returns the middleware name.
 func (m *InjectionMiddleware) Name() string {
 	return "injection"
@@ -152,131 +211,320 @@ func (m *InjectionMiddleware) Enabled() bool {
 	return m.enabled
 }
 
-// ProcessRequest scans user messages and tool_result content for injection
-// patterns and takes the configured action.
+// scannedField is a piece of message content that produced detections,
+// along with a callback that writes its sanitized form back into the request.
+type scannedField struct {
+	detections []InjectionDetection
+	sanitize   func()
+}
+
+// scanUnit is the content that is scored as a whole: a user message, or a
+// single tool result. Tool is empty for user content.
+type scanUnit struct {
+	tool       string
+	toolPolicy bool // remediate with the tool-result policy
+	fields     []scannedField
+	quarantine func()
+}
+
+// scanRules is the pattern set and weight scale used for one unit.
+type scanRules struct {
+	patterns []*injectionPattern
+	scale    float64
+}
+
+// actionSeverity orders actions so the request reports the strongest one taken.
+var actionSeverity = map[string]int{"log": 0, "warn": 0, "sanitize": 1, "quarantine": 2, "block": 3}
+
+// ProcessRequest scans user messages and tool results for injection
+// patterns, scores each message or tool result, and takes the configured
+// action. Tool results follow the tool-result policy when it is enabled.
 func (m *InjectionMiddleware) ProcessRequest(ctx context.Context, req *pipeline.Request) (*pipeline.Request, error) {
 	if req.Metadata == nil {
 		req.Metadata = make(map[string]interface{})
 	}
 
-	var detections []InjectionDetection
+	toolNames := toolCallNames(req.Messages)
+	userRules := scanRules{patterns: m.patterns, scale: 1}
 
+	var units []scanUnit
 	for i := range req.Messages {
 		msg := &req.Messages[i]
+		path := fmt.Sprintf("messages[%d].content", i)
+		setContent := func(v interface{}) { msg.Content = v }
+
+		if isToolMessage(msg) {
+			name := toolNames[msg.ToolCallID]
+			if name == "" {
+				name = msg.Name
+			}
+			units = append(units, m.toolUnit(name, msg.Content, path, setContent))
+			continue
+		}
 
 		// Only scan user messages and tool results.
-		if msg.Role != "user" && msg.ToolCallID == "" {
+		if msg.Role != "user" {
 			continue
 		}
 
-		field := fmt.Sprintf("messages[%d].content", i)
+		units = append(units, scanUnit{fields: m.scanContent(msg.Content, path, userRules, setContent)})
 
+		// Anthropic tool_result blocks ride inside user messages.
 		switch c := msg.Content.(type) {
-		case string:
-			dets := m.scanText(c, field)
-			detections = append(detections, dets...)
-			if m.action == "sanitize" && len(dets) > 0 {
-				msg.Content = m.sanitizeText(c)
-			}
-
 		case []interface{}:
 			for j, block := range c {
-				blockPath := fmt.Sprintf("messages[%d].content[%d]", i, j)
-				blockMap, ok := block.(map[string]interface{})
-				if !ok {
+				bm, ok := block.(map[string]interface{})
+				if !ok || bm["type"] != "tool_result" {
 					continue
 				}
-				if text, ok := blockMap["text"].(string); ok {
-					dets := m.scanText(text, blockPath+".text")
-					detections = append(detections, dets...)
-					if m.action == "sanitize" && len(dets) > 0 {
-						blockMap["text"] = m.sanitizeText(text)
-						c[j] = blockMap
-					}
-				}
-				if content, ok := blockMap["content"].(string); ok {
-					dets := m.scanText(content, blockPath+".content")
-					detections = append(detections, dets...)
-					if m.action == "sanitize" && len(dets) > 0 {
-						blockMap["content"] = m.sanitizeText(content)
-						c[j] = blockMap
-					}
-				}
+				id, _ := bm["tool_use_id"].(string)
+				units = append(units, m.toolUnit(toolNames[id], bm["content"], fmt.Sprintf("%s[%d].content", path, j),
+					func(v interface{}) { bm["content"] = v }))
 			}
-			if m.action == "sanitize" {
-				msg.Content = c
+		case []pipeline.ContentBlock:
+			for j := range c {
+				b := &c[j]
+				if b.Type != "tool_result" {
+					continue
+				}
+				units = append(units, m.toolUnit(toolNames[b.ToolUseID], b.Content, fmt.Sprintf("%s[%d].content", path, j),
+					func(v interface{}) { b.Content = v }))
 			}
 		}
 	}
 
-	if len(detections) > 0 {
-		req.Metadata["injection_detections"] = detections
+	var detections []InjectionDetection
+	maxScore := 0.0
+	taken := "log"
+	blocked := false
+	toolSet := make(map[string]bool)
+
+	for _, u := range units {
+		var unitDets []InjectionDetection
+		for _, f := range u.fields {
+			unitDets = append(unitDets, f.detections...)
+		}
+		if len(unitDets) == 0 {
+			continue
+		}
 
-		if m.action == "block" {
-			categories := make(map[string]bool)
-			for _, d := range detections {
-				categories[d.Category] = true
+		action, logThreshold, blockThreshold := m.action, m.logThreshold, m.blockThreshold
+		if u.toolPolicy {
+			if m.tools.Action != "" {
+				action = m.tools.Action
+			}
+			if m.tools.LogThreshold > 0 {
+				logThreshold = m.tools.LogThreshold
 			}
-			catList := make([]string, 0, len(categories))
-			for cat := range categories {
-				catList = append(catList, cat)
+			if m.tools.BlockThreshold > 0 {
+				blockThreshold = m.tools.BlockThreshold
 			}
-			return nil, fmt.Errorf("prompt injection detected: %s", strings.Join(catList, ", "))
 		}
+
+		score := riskScore(unitDets)
+		if score < logThreshold {
+			continue
+		}
+
+		for k := range unitDets {
+			unitDets[k].Score = score
+			unitDets[k].Tool = u.tool
+		}
+		detections = append(detections, unitDets...)
+		if score > maxScore {
+			maxScore = score
+		}
+		if u.tool != "" {
+			toolSet[u.tool] = true
+		}
+
+		if action == "block" || (blockThreshold > 0 && score >= blockThreshold) {
+			blocked = true
+			action = "block"
+		}
+		switch action {
+		case "sanitize":
+			for _, f := range u.fields {
+				f.sanitize()
+			}
+		case "quarantine":
+			if u.quarantine != nil {
+				u.quarantine()
+			}
+		}
+		if actionSeverity[action] > actionSeverity[taken] {
+			taken = action
+		}
+	}
+
+	if len(detections) == 0 {
+		return req, nil
+	}
+
+	tools := make([]string, 0, len(toolSet))
+	for t := range toolSet {
+		tools = append(tools, t)
+	}
+	sort.Strings(tools)
+
+	risk := &InjectionRisk{
+		Score:       maxScore,
+		Action:      taken,
+		TopPatterns: topPatterns(detections),
+		Tools:       tools,
+	}
+	req.Metadata["injection_detections"] = detections
+	req.Metadata["injection_risk"] = risk
+	tracing.SetInjectionAttributes(ctx, risk.Score, risk.TopPatterns, risk.Action)
+	audit.Record(m.auditor, audit.Event{
+		Kind:   audit.KindInjectionDetected,
+		Actor:  req.KeyID,
+		Action: risk.Action,
+		Detail: map[string]interface{}{
+			"request_id": req.ID,
+			"project":    req.Project,
+			"score":      risk.Score,
+			"patterns":   risk.TopPatterns,
+			"tools":      risk.Tools,
+		},
+	})
+
+	if blocked {
+		categories := make(map[string]bool)
+		for _, d := range detections {
+			categories[d.Category] = true
+		}
+		catList := make([]string, 0, len(categories))
+		for cat := range categories {
+			catList = append(catList, cat)
+		}
+		sort.Strings(catList)
+		if len(tools) > 0 {
+			return nil, fmt.Errorf("prompt injection detected (risk %.2f, tools: %s): %s",
+				maxScore, strings.Join(tools, ", "), strings.Join(catList, ", "))
+		}
+		return nil, fmt.Errorf("prompt injection detected (risk %.2f): %s", maxScore, strings.Join(catList, ", "))
 	}
 
 	return req, nil
 }
 
+// toolUnit builds the scan unit for one tool result. Trusted tools are
+// skipped; untrusted tools get the indirect-injection rules and boosted
+// weights. With the tool-result policy disabled, the result is scanned and
+// remediated like a user message.
+func (m *InjectionMiddleware) toolUnit(tool string, content interface{}, path string, set func(interface{})) scanUnit {
+	if tool == "" {
+		tool = "unknown"
+	}
+	if !m.tools.Enabled {
+		rules := scanRules{patterns: m.patterns, scale: 1}
+		return scanUnit{tool: tool, fields: m.scanContent(content, path, rules, set)}
+	}
+
+	rules := scanRules{patterns: m.patterns, scale: 1}
+	switch m.tools.trustFor(tool) {
+	case TrustTrusted:
+		return scanUnit{tool: tool}
+	case TrustUntrusted:
+		rules = scanRules{patterns: append(append([]*injectionPattern{}, m.patterns...), m.toolPatterns...), scale: untrustedWeightScale}
+	}
+
+	return scanUnit{
+		tool:       tool,
+		toolPolicy: true,
+		fields:     m.scanContent(content, path, rules, set),
+		quarantine: func() { set(quarantineContent(tool, content)) },
+	}
+}
+
+// scanContent scans message content (a string, decoded JSON blocks, or typed
+// content blocks) and returns the fields that produced detections. Nested
+// tool_result blocks are skipped; the caller scans them as their own units.
+func (m *InjectionMiddleware) scanContent(content interface{}, path string, rules scanRules, set func(interface{})) []scannedField {
+	var fields []scannedField
+
+	switch c := content.(type) {
+	case string:
+		if dets := m.scanText(c, path, rules); len(dets) > 0 {
+			fields = append(fields, scannedField{dets, func() {
+				set(m.sanitizeText(c, dets, rules.patterns))
+			}})
+		}
+
+	case []interface{}:
+		for j, block := range c {
+			blockMap, ok := block.(map[string]interface{})
+			if !ok || blockMap["type"] == "tool_result" {
+				continue
+			}
+			blockPath := fmt.Sprintf("%s[%d]", path, j)
+			for _, key := range []string{"text", "content"} {
+				if v, ok := blockMap[key]; ok {
+					fields = append(fields, m.scanContent(v, blockPath+"."+key, rules,
+						func(nv interface{}) { blockMap[key] = nv })...)
+				}
+			}
+		}
+
+	case []pipeline.ContentBlock:
+		for j := range c {
+			b := &c[j]
+			if b.Type == "tool_result" {
+				continue
+			}
+			blockPath := fmt.Sprintf("%s[%d]", path, j)
+			if b.Text != "" {
+				fields = append(fields, m.scanContent(b.Text, blockPath+".text", rules,
+					func(nv interface{}) { b.Text, _ = nv.(string) })...)
+			}
+			if b.Content != nil {
+				fields = append(fields, m.scanContent(b.Content, blockPath+".content", rules,
+					func(nv interface{}) { b.Content = nv })...)
+			}
+		}
+	}
+
+	return fields
+}
+
 // ProcessResponse is a no-op for injection detection.
 func (m *InjectionMiddleware) ProcessResponse(ctx context.Context, req *pipeline.Request, resp *pipeline.Response) (*pipeline.Response, error) {
 	return resp, nil
 }
 
-// scanText checks text against all injection patterns, including decoding
-// base64 blocks to check their content.
-func (m *InjectionMiddleware) scanText(text, field string) []InjectionDetection {
+// scanText checks the raw text and each of its normalized and decoded
+// variants against the rule set's patterns. A pattern is reported at most
+// once per field, from the least-transformed variant it matched.
+func (m *InjectionMiddleware) scanText(text, field string, rules scanRules) []InjectionDetection {
 	var detections []InjectionDetection
+	seen := make(map[string]bool)
 
-	for _, pattern := range m.patterns {
-		// Special handling for base64 blocks: decode and scan the decoded content.
-		if pattern.Name == "base64_block" {
-			matches := pattern.Regex.FindAllString(text, -1)
-			for _, match := range matches {
-				decoded, err := base64.StdEncoding.DecodeString(match)
-				if err != nil {
-					// Try URL-safe encoding.
-					decoded, err = base64.URLEncoding.DecodeString(match)
-					if err != nil {
-						continue
-					}
-				}
-				decodedStr := string(decoded)
-				// Scan the decoded content against non-encoded patterns.
-				for _, innerPattern := range m.patterns {
-					if innerPattern.Category == "encoded_injection" {
-						continue // skip to avoid recursion
-					}
-					if innerPattern.Regex.MatchString(decodedStr) {
-						detections = append(detections, InjectionDetection{
-							Pattern:  innerPattern.Name + " (base64 encoded)",
-							Category: "encoded_injection",
-							Match:    match,
-							Field:    field,
-						})
-					}
-				}
+	for _, v := range expandVariants(text) {
+		for _, pattern := range rules.patterns {
+			if seen[pattern.Name] {
+				continue
 			}
-			continue
-		}
+			match := pattern.Regex.FindString(v.Text)
+			if match == "" {
+				continue
+			}
+			seen[pattern.Name] = true
 
-		matches := pattern.Regex.FindAllString(text, -1)
-		for _, match := range matches {
+			weight := math.Min(1, pattern.Weight*rules.scale)
+			if v.Encoding != "" {
+				weight = math.Min(1, weight+obfuscationBoost)
+			}
+			if v.Segment != "" {
+				match = v.Segment
+			}
 			detections = append(detections, InjectionDetection{
-				Pattern:  pattern.Name,
-				Category: pattern.Category,
-				Match:    match,
-				Field:    field,
+				Pattern:     pattern.Name,
+				Category:    pattern.Category,
+				Match:       match,
+				Field:       field,
+				Weight:      weight,
+				Obfuscation: v.Encoding,
 			})
 		}
 	}
@@ -284,35 +532,105 @@ func (m *InjectionMiddleware) scanText(text, field string) []InjectionDetection
 	return detections
 }
 
-// sanitizeText removes or neutralizes detected injection patterns from text.
-func (m *InjectionMiddleware) sanitizeText(text string) string {
+// riskScore combines detection weights with a noisy-OR, so that several weak
+// signals add up without the score ever exceeding 1. Each pattern counts once,
+// at its highest weight.
+func riskScore(detections []InjectionDetection) float64 {
+	weights := make(map[string]float64)
+	for _, d := range detections {
+		if d.Weight > weights[d.Pattern] {
+			weights[d.Pattern] = d.Weight
+		}
+	}
+	miss := 1.0
+	for _, w := range weights {
+		miss *= 1 - w
+	}
+	return 1 - miss
+}
+
+// topPatterns returns the names of the highest-weighted distinct patterns.
+func topPatterns(detections []InjectionDetection) []string {
+	weights := make(map[string]float64)
+	for _, d := range detections {
+		if d.Weight > weights[d.Pattern] {
+			weights[d.Pattern] = d.Weight
+		}
+	}
+	names := make([]string, 0, len(weights))
+	for name := range weights {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if weights[names[i]] != weights[names[j]] {
+			return weights[names[i]] > weights[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > maxTopPatterns {
+		names = names[:maxTopPatterns]
+	}
+	return names
+}
+
+// sanitizeText removes detected injection content from text. Encoded payloads
+// are removed wholesale. Other matches are located on the text and on its
+// leetspeak and ROT13 mappings, which are byte-aligned with it; when a
+// detection relied on Unicode tricks, matches on the normalized text are
+// mapped back to the source spans they render, so only those spans change.
+func (m *InjectionMiddleware) sanitizeText(text string, detections []InjectionDetection, patterns []*injectionPattern) string {
 	result := text
-	for _, pattern := range m.patterns {
-		if pattern.Category == "encoded_injection" && pattern.Name == "base64_block" {
-			// For base64 blocks, remove only those that decode to injection content.
-			matches := pattern.Regex.FindAllString(result, -1)
-			for _, match := range matches {
-				decoded, err := base64.StdEncoding.DecodeString(match)
-				if err != nil {
-					decoded, err = base64.URLEncoding.DecodeString(match)
-					if err != nil {
-						continue
-					}
-				}
-				decodedStr := string(decoded)
-				for _, innerPattern := range m.patterns {
-					if innerPattern.Category == "encoded_injection" {
-						continue
-					}
-					if innerPattern.Regex.MatchString(decodedStr) {
-						result = strings.ReplaceAll(result, match, "[REMOVED]")
-						break
+	unicodeTricks := false
+	for _, d := range detections {
+		switch d.Obfuscation {
+		case obfuscationBase64, obfuscationHex, obfuscationURL:
+			result = strings.ReplaceAll(result, d.Match, "[REMOVED]")
+		case obfuscationUnicode:
+			unicodeTricks = true
+		}
+	}
+
+	// Collect match spans from the text and its mappings, then replace the
+	// merged spans in a single pass.
+	var spans [][2]int
+	for _, view := range []string{result, deleetText(result), rot13Text(result)} {
+		for _, pattern := range patterns {
+			for _, loc := range pattern.Regex.FindAllStringIndex(view, -1) {
+				spans = append(spans, [2]int{loc[0], loc[1]})
+			}
+		}
+	}
+	if unicodeTricks {
+		normalized, starts, ends := normalizeMapped(result)
+		for _, view := range []string{normalized, deleetText(normalized), rot13Text(normalized)} {
+			for _, pattern := range patterns {
+				for _, loc := range pattern.Regex.FindAllStringIndex(view, -1) {
+					if loc[1] > loc[0] {
+						spans = append(spans, [2]int{starts[loc[0]], ends[loc[1]-1]})
 					}
 				}
 			}
+		}
+	}
+	if len(spans) == 0 {
+		return result
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+
+	var b strings.Builder
+	pos := 0
+	for _, s := range spans {
+		if s[1] <= pos {
 			continue
 		}
-		result = pattern.Regex.ReplaceAllString(result, "[REMOVED]")
+		if s[0] > pos {
+			b.WriteString(result[pos:s[0]])
+		}
+		if s[0] >= pos {
+			b.WriteString("[REMOVED]")
+		}
+		pos = s[1]
 	}
-	return result
+	b.WriteString(result[pos:])
+	return b.String()
 }
@@ -0,0 +1,285 @@
+package security
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Bounds for decoding embedded payloads. Every decoded candidate is
+// re-scanned, so these keep a hostile request from turning the scanner into
+// a decompression bomb.
+const (
+	maxDecodeDepth      = 2
+	maxDecodeCandidates = 32
+	maxDecodeSegmentLen = 16 * 1024
+	minDecodeSegmentLen = 16
+)
+
+// Obfuscation techniques recorded on detections that only matched after the
+// text was normalized or decoded.
+const (
+	obfuscationUnicode   = "unicode"
+	obfuscationLeetspeak = "leetspeak"
+	obfuscationRot13     = "rot13"
+	obfuscationBase64    = "base64"
+	obfuscationHex       = "hex"
+	obfuscationURL       = "url"
+)
+
+var (
+	base64SegmentRe = regexp.MustCompile(`[A-Za-z0-9+/_\-]{16,}={0,2}`)
+	hexSegmentRe    = regexp.MustCompile(`\b(?:[0-9a-fA-F]{2}){8,}\b`)
+	urlEscapeRe     = regexp.MustCompile(`%[0-9A-Fa-f]{2}`)
+)
+
+// confusables maps common Cyrillic and Greek homoglyphs to the Latin letters
+// they imitate. NFKC does not fold these because they are distinct letters,
+// not compatibility variants.
+var confusables = map[rune]rune{
+	// Cyrillic lowercase.
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'һ': 'h', 'і': 'i', 'ї': 'i',
+	'ј': 'j', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c',
+	'т': 't', 'у': 'y', 'х': 'x', 'ѕ': 's', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w',
+	'ɡ': 'g',
+	// Cyrillic uppercase.
+	'А': 'A', 'В': 'B', 'Е': 'E', 'Н': 'H', 'І': 'I', 'Ј': 'J', 'К': 'K',
+	'М': 'M', 'О': 'O', 'Р': 'P', 'С': 'C', 'Т': 'T', 'У': 'Y', 'Х': 'X',
+	'Ѕ': 'S', 'Ԁ': 'D', 'Ԛ': 'Q', 'Ԝ': 'W',
+	// Greek.
+	'α': 'a', 'ε': 'e', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p',
+	'τ': 't', 'υ': 'u', 'χ': 'x', 'Α': 'A', 'Β': 'B', 'Ε': 'E', 'Ζ': 'Z',
+	'Η': 'H', 'Ι': 'I', 'Κ': 'K', 'Μ': 'M', 'Ν': 'N', 'Ο': 'O', 'Ρ': 'P',
+	'Τ': 'T', 'Υ': 'Y', 'Χ': 'X',
+}
+
+// leetMap maps leetspeak substitutions back to letters. Every replacement is
+// a single ASCII byte, so byte offsets in the mapped text line up with the
+// source text.
+var leetMap = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't',
+	'@': 'a', '$': 's', '!': 'i', '|': 'l',
+}
+
+// textVariant is one rendering of a scanned string. Encoding names the
+// obfuscation that had to be undone to produce it ("" for the raw text) and
+// Segment holds the encoded substring of the original text for decoded
+// payloads.
+type textVariant struct {
+	Text     string
+	Encoding string
+	Segment  string
+}
+
+// isInvisible reports whether r renders as nothing: zero-width characters,
+// bidi controls, tag characters, soft hyphens, and variation selectors.
+func isInvisible(r rune) bool {
+	if unicode.Is(unicode.Cf, r) {
+		return true
+	}
+	return unicode.Is(unicode.Variation_Selector, r)
+}
+
+// normalizeText strips invisible characters, applies NFKC, and folds
+// homoglyphs onto ASCII so that visually identical text matches the same
+// patterns.
+func normalizeText(text string) string {
+	stripped := strings.Map(func(r rune) rune {
+		if isInvisible(r) {
+			return -1
+		}
+		return r
+	}, text)
+
+	folded := norm.NFKC.String(stripped)
+
+	return strings.Map(func(r rune) rune {
+		if c, ok := confusables[r]; ok {
+			return c
+		}
+		return r
+	}, folded)
+}
+
+// normalizeMapped returns normalizeText(text) along with where each of its
+// bytes came from: byte i of the result renders text[starts[i]:ends[i]].
+// NFKC is applied one normalization segment at a time, which gives the same
+// result as normalizing the whole string.
+func normalizeMapped(text string) (normalized string, starts, ends []int) {
+	var stripped strings.Builder
+	var srcStart, srcEnd []int
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if !isInvisible(r) {
+			stripped.WriteString(text[i : i+size])
+			for j := 0; j < size; j++ {
+				srcStart = append(srcStart, i)
+				srcEnd = append(srcEnd, i+size)
+			}
+		}
+		i += size
+	}
+
+	s := stripped.String()
+	var b strings.Builder
+	for a := 0; a < len(s); {
+		n := norm.NFKC.NextBoundaryInString(s[a:], true)
+		if n <= 0 {
+			n = len(s) - a
+		}
+		start, end := srcStart[a], srcEnd[a+n-1]
+		for _, r := range norm.NFKC.String(s[a : a+n]) {
+			if c, ok := confusables[r]; ok {
+				r = c
+			}
+			before := b.Len()
+			b.WriteRune(r)
+			for j := before; j < b.Len(); j++ {
+				starts = append(starts, start)
+				ends = append(ends, end)
+			}
+		}
+		a += n
+	}
+	return b.String(), starts, ends
+}
+
+// deleetText undoes common leetspeak substitutions.
+func deleetText(text string) string {
+	return strings.Map(func(r rune) rune {
+		if c, ok := leetMap[r]; ok {
+			return c
+		}
+		return r
+	}, text)
+}
+
+// rot13Text applies ROT13 to ASCII letters.
+func rot13Text(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return 'a' + (r-'a'+13)%26
+		case r >= 'A' && r <= 'Z':
+			return 'A' + (r-'A'+13)%26
+		}
+		return r
+	}, text)
+}
+
+// expandVariants returns the raw text followed by every distinct normalized
+// and decoded rendering of it that should also be scanned.
+func expandVariants(text string) []textVariant {
+	var variants []textVariant
+	seen := make(map[string]bool)
+	add := func(v textVariant) {
+		if v.Text == "" || seen[v.Text] {
+			return
+		}
+		seen[v.Text] = true
+		variants = append(variants, v)
+	}
+
+	add(textVariant{Text: text})
+
+	normalized := normalizeText(text)
+	add(textVariant{Text: normalized, Encoding: obfuscationUnicode})
+	add(textVariant{Text: deleetText(normalized), Encoding: obfuscationLeetspeak})
+	add(textVariant{Text: rot13Text(normalized), Encoding: obfuscationRot13})
+
+	budget := maxDecodeCandidates
+	decodeVariants(normalized, "", "", 1, &budget, add)
+
+	return variants
+}
+
+// decodeVariants finds base64, hex, and percent-encoded payloads in text and
+// passes their normalized decodings to add, recursing up to maxDecodeDepth.
+// Nested payloads keep the outermost encoding and segment so that sanitizing
+// removes the span that actually appears in the request.
+func decodeVariants(text, encoding, segment string, depth int, budget *int, add func(textVariant)) {
+	if depth > maxDecodeDepth {
+		return
+	}
+
+	emit := func(decoded, enc, seg string) {
+		if *budget <= 0 {
+			return
+		}
+		*budget--
+		if encoding != "" {
+			enc, seg = encoding, segment
+		}
+		decoded = normalizeText(decoded)
+		add(textVariant{Text: decoded, Encoding: enc, Segment: seg})
+		decodeVariants(decoded, enc, seg, depth+1, budget, add)
+	}
+
+	for _, match := range base64SegmentRe.FindAllString(text, maxDecodeCandidates) {
+		if len(match) > maxDecodeSegmentLen {
+			continue
+		}
+		if decoded, ok := decodeBase64(match); ok {
+			emit(decoded, obfuscationBase64, match)
+		}
+	}
+
+	for _, match := range hexSegmentRe.FindAllString(text, maxDecodeCandidates) {
+		if len(match) > maxDecodeSegmentLen {
+			continue
+		}
+		if decoded, err := hex.DecodeString(match); err == nil && isPrintableText(decoded) {
+			emit(string(decoded), obfuscationHex, match)
+		}
+	}
+
+	if len(text) <= maxDecodeSegmentLen && urlEscapeRe.MatchString(text) {
+		if decoded, err := url.PathUnescape(text); err == nil && decoded != text {
+			emit(decoded, obfuscationURL, text)
+		}
+	}
+}
+
+// decodeBase64 tries the standard and URL-safe alphabets, with and without
+// padding, and only accepts results that look like text.
+func decodeBase64(s string) (string, bool) {
+	if len(s) < minDecodeSegmentLen {
+		return "", false
+	}
+	encodings := []*base64.Encoding{
+		base64.StdEncoding,
+		base64.RawStdEncoding,
+		base64.URLEncoding,
+		base64.RawURLEncoding,
+	}
+	for _, enc := range encodings {
+		decoded, err := enc.DecodeString(s)
+		if err == nil && isPrintableText(decoded) {
+			return string(decoded), true
+		}
+	}
+	return "", false
+}
+
+// isPrintableText reports whether b is valid UTF-8 made up almost entirely
+// of printable characters, which filters out binary blobs and random tokens
+// that happen to be valid base64 or hex.
+func isPrintableText(b []byte) bool {
+	if len(b) == 0 || !utf8.Valid(b) {
+		return false
+	}
+	total, printable := 0, 0
+	for _, r := range string(b) {
+		total++
+		if unicode.IsPrint(r) || unicode.IsSpace(r) {
+			printable++
+		}
+	}
+	return float64(printable)/float64(total) >= 0.9
+}
@@ -9,6 +9,8 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/allaspectsdev/tokenman/internal/alert"
+	"github.com/allaspectsdev/tokenman/internal/audit"
 	"github.com/allaspectsdev/tokenman/internal/pipeline"
 )
 
@@ -71,6 +73,8 @@ type PIIMiddleware struct {
 	patterns  []*PIIPattern
 	action    string
 	allowList map[string]bool
+	notifier  alert.Notifier
+	auditor   audit.Recorder
 	enabled   bool
 }
 
@@ -95,6 +99,17 @@ func NewPIIMiddleware(action string, allowList []string, enabled bool) *PIIMiddl
 	}
 }
 
+// SetNotifier sets where an alert is sent each time a request is blocked.
+func (p *PIIMiddleware) SetNotifier(n alert.Notifier) {
+	p.notifier = n
+}
+
+// SetAuditor sets where PII detections are recorded. Only the detected
+// types and field paths are recorded, never the values.
+func (p *PIIMiddleware) SetAuditor(r audit.Recorder) {
+	p.auditor = r
+}
+
 // Name returns the middleware name.
 func (p *PIIMiddleware) Name() string {
 	return "pii"
@@ -172,6 +187,7 @@ func (p *PIIMiddleware) ProcessRequest(ctx context.Context, req *pipeline.Reques
 	if len(detections) > 0 {
 		req.Metadata["pii_detections"] = detections
 		req.Metadata["pii_mapping"] = mapping
+		p.audit(req, detections)
 
 		if p.action == "block" {
 			types := make(map[string]bool)
@@ -182,6 +198,19 @@ func (p *PIIMiddleware) ProcessRequest(ctx context.Context, req *pipeline.Reques
 			for t := range types {
 				typeList = append(typeList, t)
 			}
+			if p.notifier != nil {
+				p.notifier.Notify(alert.Alert{
+					Kind:     alert.KindPIIBlocked,
+					Severity: alert.SeverityWarning,
+					Title:    "Request blocked for PII",
+					Message:  fmt.Sprintf("Request %s was blocked because it contains %s.", req.ID, strings.Join(typeList, ", ")),
+					Fields: map[string]interface{}{
+						"request_id": req.ID,
+						"pii_types":  typeList,
+						"detections": len(detections),
+					},
+				})
+			}
 			return nil, fmt.Errorf("pii detected: request contains %s", strings.Join(typeList, ", "))
 		}
 	}
@@ -189,6 +218,30 @@ func (p *PIIMiddleware) ProcessRequest(ctx context.Context, req *pipeline.Reques
 	return req, nil
 }
 
+// audit records the detections in req to the audit log.
+func (p *PIIMiddleware) audit(req *pipeline.Request, detections []PIIDetection) {
+	if p.auditor == nil {
+		return
+	}
+	types := make(map[string]int)
+	fields := make([]string, 0, len(detections))
+	for _, d := range detections {
+		types[d.Type]++
+		fields = append(fields, d.FieldPath)
+	}
+	audit.Record(p.auditor, audit.Event{
+		Kind:   audit.KindPIIDetected,
+		Actor:  req.KeyID,
+		Action: p.action,
+		Detail: map[string]interface{}{
+			"request_id": req.ID,
+			"project":    req.Project,
+			"types":      types,
+			"fields":     fields,
+		},
+	})
+}
+
 // ProcessResponse restores redacted placeholders in the response body if
 // the action was "redact".
 func (p *PIIMiddleware) ProcessResponse(ctx context.Context, req *pipeline.Request, resp *pipeline.Response) (*pipeline.Response, error) {
@@ -0,0 +1,464 @@
+package security
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/allaspectsdev/tokenman/internal/audit"
+	"github.com/allaspectsdev/tokenman/internal/config"
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+)
+
+// Policy actions.
+const (
+	PolicyActionAllow     = "allow"
+	PolicyActionDeny      = "deny"
+	PolicyActionRewrite   = "rewrite"
+	PolicyActionDowngrade = "downgrade"
+)
+
+// PolicyDefaultRule is the rule name recorded when no allow or deny rule
+// matched and the configured default action applied.
+const PolicyDefaultRule = "default"
+
+// PolicyDecision is the outcome of one policy rule for one request.
+type PolicyDecision struct {
+	RequestID string `json:"request_id"`
+	Rule      string `json:"rule"`
+	Action    string `json:"action"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// PolicyLogger persists policy decisions.
+type PolicyLogger interface {
+	LogPolicyDecision(d PolicyDecision) error
+}
+
+// PolicyError is returned when a request is denied by policy. The HTTP
+// handler serializes it to a JSON response with HTTP 403 status.
+type PolicyError struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Error implements the error interface.
+func (e *PolicyError) Error() string {
+	return e.Message
+}
+
+// ToJSON serializes the policy error to a JSON body suitable for an HTTP
+// response.
+func (e *PolicyError) ToJSON() []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"type":    "policy_violation",
+			"message": e.Message,
+			"rule":    e.Rule,
+		},
+	})
+	return data
+}
+
+// PolicyEngine evaluates ordered policy rules against requests.
+type PolicyEngine struct {
+	rules         []config.PolicyRule
+	defaultAction string
+	resolve       func(model string) string
+}
+
+// NewPolicyEngine creates an engine for cfg. resolve maps a model to the
+// provider that will serve it and is used to re-route downgraded requests;
+// it may be nil.
+func NewPolicyEngine(cfg config.PolicyConfig, resolve func(model string) string) *PolicyEngine {
+	defaultAction := strings.ToLower(cfg.DefaultAction)
+	if defaultAction != PolicyActionDeny {
+		defaultAction = PolicyActionAllow
+	}
+	return &PolicyEngine{
+		rules:         cfg.Rules,
+		defaultAction: defaultAction,
+		resolve:       resolve,
+	}
+}
+
+// Evaluate runs the rules in order against req, applying rewrite and
+// downgrade rules to it as they match. Evaluation stops at the first
+// matching allow or deny rule; if none matches, the default action applies.
+// It returns every decision taken and a *PolicyError if the request is
+// denied.
+func (e *PolicyEngine) Evaluate(req *pipeline.Request) ([]PolicyDecision, error) {
+	var decisions []PolicyDecision
+	decide := func(rule, action, detail string) {
+		decisions = append(decisions, PolicyDecision{RequestID: req.ID, Rule: rule, Action: action, Detail: detail})
+	}
+
+	tools := requestToolNames(req)
+	contentTypes := requestContentTypes(req)
+
+	for _, rule := range e.rules {
+		if !policyMatches(rule.Match, req, tools, contentTypes) {
+			continue
+		}
+		switch strings.ToLower(rule.Action) {
+		case PolicyActionAllow:
+			decide(rule.Name, PolicyActionAllow, "")
+			return decisions, nil
+		case PolicyActionDeny:
+			msg := rule.Message
+			if msg == "" {
+				msg = fmt.Sprintf("request denied by policy %q", rule.Name)
+			}
+			decide(rule.Name, PolicyActionDeny, msg)
+			return decisions, &PolicyError{Rule: rule.Name, Message: msg}
+		case PolicyActionRewrite:
+			if detail := applyPolicyRewrite(req, rule.Set); detail != "" {
+				decide(rule.Name, PolicyActionRewrite, detail)
+			}
+		case PolicyActionDowngrade:
+			if rule.Model == "" || rule.Model == req.Model {
+				continue
+			}
+			detail := req.Model + " -> " + rule.Model
+			e.downgrade(req, rule.Model)
+			decide(rule.Name, PolicyActionDowngrade, detail)
+		}
+	}
+
+	if e.defaultAction == PolicyActionDeny {
+		msg := "request denied by default policy"
+		decide(PolicyDefaultRule, PolicyActionDeny, msg)
+		return decisions, &PolicyError{Rule: PolicyDefaultRule, Message: msg}
+	}
+	decide(PolicyDefaultRule, PolicyActionAllow, "")
+	return decisions, nil
+}
+
+// downgrade switches req to model and re-resolves its provider.
+func (e *PolicyEngine) downgrade(req *pipeline.Request, model string) {
+	if req.Metadata == nil {
+		req.Metadata = make(map[string]interface{})
+	}
+	if _, ok := req.Metadata["original_model"]; !ok {
+		req.Metadata["original_model"] = req.Model
+	}
+	req.Model = model
+	if e.resolve != nil {
+		if provider := e.resolve(model); provider != "" {
+			req.Metadata["provider"] = provider
+		}
+	}
+}
+
+// policyMatches reports whether every condition set in m holds for req.
+func policyMatches(m config.PolicyMatch, req *pipeline.Request, tools, contentTypes []string) bool {
+	provider, _ := req.Metadata["provider"].(string)
+	switch {
+	case len(m.Projects) > 0 && !containsAny(m.Projects, req.Project):
+		return false
+	case len(m.Keys) > 0 && !containsAny(m.Keys, req.KeyID):
+		return false
+	case len(m.KeyOwners) > 0 && !containsAny(m.KeyOwners, req.KeyOwner):
+		return false
+	case len(m.Providers) > 0 && !containsAny(m.Providers, provider):
+		return false
+	case len(m.Models) > 0 && !globAny(m.Models, req.Model):
+		return false
+	case len(m.Tools) > 0 && !globAny(m.Tools, tools...):
+		return false
+	case len(m.ContentTypes) > 0 && !containsAny(m.ContentTypes, contentTypes...):
+		return false
+	case m.InputTokensOver > 0 && req.TokensIn <= m.InputTokensOver:
+		return false
+	case m.MaxTokensOver > 0 && req.MaxTokens <= m.MaxTokensOver:
+		return false
+	}
+	return true
+}
+
+// containsAny reports whether any of values appears in list. Empty values
+// never match.
+func containsAny(list []string, values ...string) bool {
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		for _, item := range list {
+			if strings.EqualFold(item, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// globAny reports whether any of values matches any pattern.
+func globAny(patterns []string, values ...string) bool {
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		for _, p := range patterns {
+			if p == v {
+				return true
+			}
+			if ok, err := path.Match(p, v); err == nil && ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requestToolNames returns the names of the tools declared on req.
+func requestToolNames(req *pipeline.Request) []string {
+	var names []string
+	for _, t := range req.Tools {
+		if t.Name != "" {
+			names = append(names, t.Name)
+			continue
+		}
+		if fn, ok := t.Function.(map[string]interface{}); ok {
+			if name, ok := fn["name"].(string); ok && name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// requestContentTypes returns the distinct content block types in req's
+// messages, including blocks nested in tool results. OpenAI's image_url
+// blocks are also reported as "image" so one rule covers both formats.
+func requestContentTypes(req *pipeline.Request) []string {
+	seen := make(map[string]bool)
+	var collect func(content interface{})
+	add := func(typ string) {
+		if typ == "" {
+			return
+		}
+		seen[typ] = true
+		if typ == "image_url" {
+			seen["image"] = true
+		}
+	}
+	collect = func(content interface{}) {
+		switch c := content.(type) {
+		case string:
+			if c != "" {
+				add("text")
+			}
+		case []pipeline.ContentBlock:
+			for _, b := range c {
+				add(b.Type)
+				if b.Content != nil {
+					collect(b.Content)
+				}
+			}
+		case []interface{}:
+			for _, item := range c {
+				if m, ok := item.(map[string]interface{}); ok {
+					typ, _ := m["type"].(string)
+					add(typ)
+					if nested, ok := m["content"]; ok {
+						collect(nested)
+					}
+				}
+			}
+		}
+	}
+	for _, msg := range req.Messages {
+		collect(msg.Content)
+	}
+
+	types := make([]string, 0, len(seen))
+	for typ := range seen {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// applyPolicyRewrite overwrites request fields from set and returns a
+// description of what changed, or "" if nothing did. max_tokens and
+// temperature are applied to the parsed request; other fields are written
+// into the raw body, which the proxy uses as the base when it rebuilds the
+// upstream request.
+func applyPolicyRewrite(req *pipeline.Request, set map[string]interface{}) string {
+	fields := make([]string, 0, len(set))
+	for field := range set {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var body map[string]interface{}
+	var changes []string
+	for _, field := range fields {
+		value := set[field]
+		switch field {
+		case "max_tokens":
+			n, ok := config.PolicyNumber(value)
+			if !ok || n < 1 || req.MaxTokens == int(n) {
+				continue
+			}
+			req.MaxTokens = int(n)
+		case "temperature":
+			n, ok := config.PolicyNumber(value)
+			if !ok || (req.Temperature != nil && *req.Temperature == n) {
+				continue
+			}
+			req.Temperature = &n
+		case "model", "messages", "stream", "system", "tools":
+			continue
+		default:
+			if body == nil {
+				if err := json.Unmarshal(req.RawBody, &body); err != nil || body == nil {
+					body = make(map[string]interface{})
+				}
+			}
+			body[field] = value
+		}
+		changes = append(changes, fmt.Sprintf("%s=%v", field, value))
+	}
+
+	if body != nil {
+		if data, err := json.Marshal(body); err == nil {
+			req.RawBody = data
+		}
+	}
+	return strings.Join(changes, ", ")
+}
+
+// PolicyMiddleware enforces the configured request policy. It runs first in
+// the chain so denied requests never reach the cache or the provider, and
+// rewrites and downgrades are reflected in cache keys and budgets.
+type PolicyMiddleware struct {
+	mu      sync.RWMutex
+	engine  *PolicyEngine
+	enabled bool
+	resolve func(model string) string
+	logger  PolicyLogger
+	auditor audit.Recorder
+}
+
+// Compile-time assertion that PolicyMiddleware implements pipeline.Middleware.
+var _ pipeline.Middleware = (*PolicyMiddleware)(nil)
+
+// NewPolicyMiddleware creates a policy middleware from cfg. resolve maps a
+// model to its provider name for downgrade rules; it may be nil.
+func NewPolicyMiddleware(cfg config.PolicyConfig, resolve func(model string) string) *PolicyMiddleware {
+	return &PolicyMiddleware{
+		engine:  NewPolicyEngine(cfg, resolve),
+		enabled: cfg.Enabled,
+		resolve: resolve,
+	}
+}
+
+// SetLogger configures where policy decisions are recorded.
+func (p *PolicyMiddleware) SetLogger(l PolicyLogger) {
+	p.mu.Lock()
+	p.logger = l
+	p.mu.Unlock()
+}
+
+// SetAuditor sets where deny, rewrite, and downgrade decisions are
+// recorded. Allow decisions only go to the decision log.
+func (p *PolicyMiddleware) SetAuditor(r audit.Recorder) {
+	p.mu.Lock()
+	p.auditor = r
+	p.mu.Unlock()
+}
+
+// Reconfigure replaces the rules and default action and enables or
+// disables the middleware according to cfg.
+func (p *PolicyMiddleware) Reconfigure(cfg config.PolicyConfig) {
+	engine := NewPolicyEngine(cfg, p.resolve)
+	p.mu.Lock()
+	p.engine = engine
+	p.enabled = cfg.Enabled
+	p.mu.Unlock()
+}
+
+// Name returns the middleware name.
+func (p *PolicyMiddleware) Name() string {
+	return "policy"
+}
+
+// Enabled reports whether this middleware is active.
+func (p *PolicyMiddleware) Enabled() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.enabled
+}
+
+// ProcessRequest evaluates the policy against req and records each
+// decision. It returns a *PolicyError if the request is denied.
+func (p *PolicyMiddleware) ProcessRequest(ctx context.Context, req *pipeline.Request) (*pipeline.Request, error) {
+	p.mu.RLock()
+	engine, logger, auditor := p.engine, p.logger, p.auditor
+	p.mu.RUnlock()
+
+	decisions, err := engine.Evaluate(req)
+	for _, d := range decisions {
+		log.Debug().
+			Str("request_id", d.RequestID).
+			Str("rule", d.Rule).
+			Str("action", d.Action).
+			Str("detail", d.Detail).
+			Msg("policy decision")
+		if logger != nil {
+			if logErr := logger.LogPolicyDecision(d); logErr != nil {
+				log.Warn().Err(logErr).Str("request_id", d.RequestID).Msg("failed to record policy decision")
+			}
+		}
+		if d.Action != PolicyActionAllow {
+			audit.Record(auditor, audit.Event{
+				Kind:   audit.KindPolicyAction,
+				Actor:  req.KeyID,
+				Action: d.Action,
+				Detail: map[string]interface{}{
+					"request_id": d.RequestID,
+					"project":    req.Project,
+					"rule":       d.Rule,
+					"detail":     d.Detail,
+				},
+			})
+		}
+	}
+	if err != nil {
+		return req, err
+	}
+	return req, nil
+}
+
+// Check evaluates the policy against a copy of req without recording any
+// decision or applying rewrites and downgrades to req. It returns a
+// *PolicyError if the request would be denied, and nil when the middleware
+// is disabled.
+func (p *PolicyMiddleware) Check(req *pipeline.Request) error {
+	p.mu.RLock()
+	engine, enabled := p.engine, p.enabled
+	p.mu.RUnlock()
+	if !enabled {
+		return nil
+	}
+
+	probe := *req
+	probe.Metadata = make(map[string]interface{}, len(req.Metadata))
+	for k, v := range req.Metadata {
+		probe.Metadata[k] = v
+	}
+	_, err := engine.Evaluate(&probe)
+	return err
+}
+
+// ProcessResponse is a no-op for policy enforcement.
+func (p *PolicyMiddleware) ProcessResponse(ctx context.Context, req *pipeline.Request, resp *pipeline.Response) (*pipeline.Response, error) {
+	return resp, nil
+}
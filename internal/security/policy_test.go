@@ -0,0 +1,242 @@
+package security
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/allaspectsdev/tokenman/internal/audit"
+	"github.com/allaspectsdev/tokenman/internal/config"
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+)
+
+type policyLogRecorder struct {
+	decisions []PolicyDecision
+}
+
+func (r *policyLogRecorder) LogPolicyDecision(d PolicyDecision) error {
+	r.decisions = append(r.decisions, d)
+	return nil
+}
+
+func policyRequest(model string) *pipeline.Request {
+	return &pipeline.Request{
+		ID:        "req-1",
+		Model:     model,
+		MaxTokens: 1024,
+		Project:   "default",
+		RawBody:   []byte(`{"model":"` + model + `","top_p":0.9}`),
+		Messages:  []pipeline.Message{{Role: "user", Content: "hello"}},
+		Metadata:  map[string]interface{}{"provider": "anthropic"},
+	}
+}
+
+func TestPolicy_DenyStopsEvaluation(t *testing.T) {
+	engine := NewPolicyEngine(config.PolicyConfig{
+		DefaultAction: "allow",
+		Rules: []config.PolicyRule{
+			{Name: "no-opus", Match: config.PolicyMatch{Projects: []string{"x"}, Models: []string{"claude-opus-*"}}, Action: "deny", Message: "project x may not use opus"},
+			{Name: "cap", Action: "rewrite", Set: map[string]interface{}{"max_tokens": int64(10)}},
+		},
+	}, nil)
+
+	req := policyRequest("claude-opus-4-1")
+	req.Project = "x"
+	decisions, err := engine.Evaluate(req)
+	var polErr *PolicyError
+	if !errors.As(err, &polErr) {
+		t.Fatalf("expected PolicyError, got %v", err)
+	}
+	if polErr.Rule != "no-opus" || polErr.Message != "project x may not use opus" {
+		t.Errorf("PolicyError = %+v", polErr)
+	}
+	if len(decisions) != 1 || decisions[0].Action != PolicyActionDeny || decisions[0].RequestID != "req-1" {
+		t.Errorf("decisions = %+v, want a single deny", decisions)
+	}
+	if req.MaxTokens != 1024 {
+		t.Errorf("rules after a deny must not run; max_tokens = %d", req.MaxTokens)
+	}
+
+	// Another project is not matched and falls through to the default.
+	other := policyRequest("claude-opus-4-1")
+	if _, err := engine.Evaluate(other); err != nil {
+		t.Errorf("other project denied: %v", err)
+	}
+}
+
+func TestPolicy_RewriteAndDowngrade(t *testing.T) {
+	engine := NewPolicyEngine(config.PolicyConfig{
+		DefaultAction: "allow",
+		Rules: []config.PolicyRule{
+			{Name: "intern-cap", Match: config.PolicyMatch{KeyOwners: []string{"interns"}, MaxTokensOver: 512}, Action: "rewrite", Set: map[string]interface{}{"max_tokens": int64(512)}},
+			{Name: "ci", Match: config.PolicyMatch{Projects: []string{"ci"}}, Action: "rewrite", Set: map[string]interface{}{"temperature": int64(0), "top_p": 1.0}},
+			{Name: "cheap", Match: config.PolicyMatch{Models: []string{"gpt-4o"}}, Action: "downgrade", Model: "gpt-4o-mini"},
+		},
+	}, func(model string) string { return "openai" })
+
+	req := policyRequest("gpt-4o")
+	req.Project = "ci"
+	req.KeyOwner = "interns"
+	decisions, err := engine.Evaluate(req)
+	if err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+
+	if req.MaxTokens != 512 {
+		t.Errorf("MaxTokens = %d, want 512", req.MaxTokens)
+	}
+	if req.Temperature == nil || *req.Temperature != 0 {
+		t.Errorf("Temperature = %v, want 0", req.Temperature)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(req.RawBody, &body); err != nil || body["top_p"] != 1.0 {
+		t.Errorf("raw body top_p not rewritten: %s", req.RawBody)
+	}
+	if req.Model != "gpt-4o-mini" || req.Metadata["original_model"] != "gpt-4o" || req.Metadata["provider"] != "openai" {
+		t.Errorf("downgrade: model=%s metadata=%v", req.Model, req.Metadata)
+	}
+
+	var got []string
+	for _, d := range decisions {
+		got = append(got, d.Rule+":"+d.Action)
+	}
+	want := []string{"intern-cap:rewrite", "ci:rewrite", "cheap:downgrade", "default:allow"}
+	if len(got) != len(want) {
+		t.Fatalf("decisions = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("decisions = %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestPolicy_MatchesToolsAndContentTypes(t *testing.T) {
+	engine := NewPolicyEngine(config.PolicyConfig{
+		DefaultAction: "allow",
+		Rules: []config.PolicyRule{
+			{Name: "no-images-y", Match: config.PolicyMatch{Providers: []string{"y"}, ContentTypes: []string{"image"}}, Action: "deny"},
+			{Name: "no-shell", Match: config.PolicyMatch{Tools: []string{"shell_*"}}, Action: "deny"},
+		},
+	}, nil)
+
+	img := policyRequest("m")
+	img.Metadata["provider"] = "y"
+	img.Messages = []pipeline.Message{{Role: "user", Content: []interface{}{
+		map[string]interface{}{"type": "text", "text": "what is this"},
+		map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "data:..."}},
+	}}}
+	if _, err := engine.Evaluate(img); err == nil {
+		t.Error("image request to provider y was allowed")
+	}
+	img.Metadata["provider"] = "z"
+	if _, err := engine.Evaluate(img); err != nil {
+		t.Errorf("image request to provider z denied: %v", err)
+	}
+
+	tool := policyRequest("m")
+	tool.Tools = []pipeline.Tool{{Type: "function", Function: map[string]interface{}{"name": "shell_exec"}}}
+	if _, err := engine.Evaluate(tool); err == nil {
+		t.Error("request declaring shell_exec was allowed")
+	}
+}
+
+func TestPolicyMiddleware_DefaultDenyAndLogging(t *testing.T) {
+	mw := NewPolicyMiddleware(config.PolicyConfig{
+		Enabled:       true,
+		DefaultAction: "deny",
+		Rules: []config.PolicyRule{
+			{Name: "allow-web", Match: config.PolicyMatch{Projects: []string{"web"}}, Action: "allow"},
+		},
+	}, nil)
+	rec := &policyLogRecorder{}
+	mw.SetLogger(rec)
+
+	web := policyRequest("m")
+	web.Project = "web"
+	if _, err := mw.ProcessRequest(context.Background(), web); err != nil {
+		t.Fatalf("web denied: %v", err)
+	}
+	_, err := mw.ProcessRequest(context.Background(), policyRequest("m"))
+	var polErr *PolicyError
+	if !errors.As(err, &polErr) || polErr.Rule != PolicyDefaultRule {
+		t.Fatalf("expected default deny, got %v", err)
+	}
+
+	if len(rec.decisions) != 2 || rec.decisions[0].Rule != "allow-web" || rec.decisions[1].Action != PolicyActionDeny {
+		t.Errorf("logged decisions = %+v", rec.decisions)
+	}
+
+	mw.Reconfigure(config.PolicyConfig{DefaultAction: "deny"})
+	if mw.Enabled() {
+		t.Error("middleware still enabled after reconfigure with enabled = false")
+	}
+}
+
+func TestPolicyMiddleware_AuditsEnforcement(t *testing.T) {
+	mw := NewPolicyMiddleware(config.PolicyConfig{
+		Enabled:       true,
+		DefaultAction: "allow",
+		Rules: []config.PolicyRule{
+			{Name: "no-opus", Match: config.PolicyMatch{Models: []string{"claude-opus-*"}}, Action: "deny"},
+		},
+	}, nil)
+	rec := &auditLog{}
+	mw.SetAuditor(rec)
+
+	if _, err := mw.ProcessRequest(context.Background(), policyRequest("claude-sonnet-4")); err != nil {
+		t.Fatalf("sonnet denied: %v", err)
+	}
+	if len(rec.events) != 0 {
+		t.Errorf("allow decisions were audited: %+v", rec.events)
+	}
+
+	req := policyRequest("claude-opus-4-1")
+	req.KeyID = "vk_intern"
+	if _, err := mw.ProcessRequest(context.Background(), req); err == nil {
+		t.Fatal("opus request allowed")
+	}
+	if len(rec.events) != 1 {
+		t.Fatalf("audit events = %+v, want 1", rec.events)
+	}
+	if e := rec.events[0]; e.Kind != audit.KindPolicyAction || e.Action != PolicyActionDeny || e.Actor != "vk_intern" || e.Detail["rule"] != "no-opus" {
+		t.Errorf("audit event = %+v", e)
+	}
+}
+
+func TestPolicyMiddleware_CheckHasNoSideEffects(t *testing.T) {
+	mw := NewPolicyMiddleware(config.PolicyConfig{
+		Enabled:       true,
+		DefaultAction: "allow",
+		Rules: []config.PolicyRule{
+			{Name: "no-openai", Match: config.PolicyMatch{Providers: []string{"openai"}}, Action: "deny"},
+			{Name: "small", Action: "downgrade", Model: "claude-haiku-4"},
+		},
+	}, func(string) string { return "anthropic" })
+	rec := &policyLogRecorder{}
+	mw.SetLogger(rec)
+
+	req := policyRequest("claude-sonnet-4")
+	if err := mw.Check(req); err != nil {
+		t.Fatalf("Check denied an allowed request: %v", err)
+	}
+	if req.Model != "claude-sonnet-4" || req.Metadata["original_model"] != nil {
+		t.Errorf("Check changed the request: model %s, metadata %v", req.Model, req.Metadata)
+	}
+
+	req.Metadata["provider"] = "openai"
+	var polErr *PolicyError
+	if err := mw.Check(req); !errors.As(err, &polErr) || polErr.Rule != "no-openai" {
+		t.Errorf("Check = %v; want a no-openai denial", err)
+	}
+	if len(rec.decisions) != 0 {
+		t.Errorf("Check logged decisions: %+v", rec.decisions)
+	}
+
+	mw.Reconfigure(config.PolicyConfig{DefaultAction: "deny"})
+	if err := mw.Check(req); err != nil {
+		t.Errorf("disabled policy denied: %v", err)
+	}
+}
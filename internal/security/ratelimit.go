@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"strings"
 	"sync"
 	"time"
@@ -12,11 +13,31 @@ import (
 	"github.com/allaspectsdev/tokenman/internal/pipeline"
 )
 
+// Rate limit rejection reasons reported in RateLimitError.Reason.
+const (
+	RateLimitReasonRequests    = "requests"
+	RateLimitReasonTokens      = "tokens"
+	RateLimitReasonConcurrency = "concurrency"
+	RateLimitReasonQueueFull   = "queue_full"
+	RateLimitReasonMaxWait     = "max_wait"
+)
+
+// MaxWaitMetadataKey is the request metadata key holding a per-request
+// time.Duration that caps how long the request may queue for capacity.
+const MaxWaitMetadataKey = "ratelimit_max_wait"
+
+// ParentMetadataKey is the request metadata key holding the ID of an admitted
+// request on whose behalf this one is sent, such as a cascade's cheap
+// attempt. The request shares the parent's concurrency slots on the limiters
+// both use rather than waiting for slots of its own.
+const ParentMetadataKey = "ratelimit_parent"
+
 // RateLimitError is returned when a provider's rate limit is exceeded. It carries
 // structured data that the HTTP handler can serialize to a JSON response with
 // HTTP 429 status.
 type RateLimitError struct {
 	Provider   string  `json:"provider"`
+	Reason     string  `json:"reason,omitempty"`
 	Rate       float64 `json:"rate"`
 	RetryAfter float64 `json:"retry_after"`
 	Message    string  `json:"message"`
@@ -29,19 +50,21 @@ func (e *RateLimitError) Error() string {
 
 // ToJSON serializes the rate limit error to a JSON body suitable for an HTTP response.
 func (e *RateLimitError) ToJSON() []byte {
-	body := map[string]interface{}{
-		"error": map[string]interface{}{
-			"type":        "rate_limit_error",
-			"message":     e.Message,
-			"provider":    e.Provider,
-			"retry_after": e.RetryAfter,
-		},
-	}
-	data, _ := json.Marshal(body)
+	inner := map[string]interface{}{
+		"type":        "rate_limit_error",
+		"message":     e.Message,
+		"provider":    e.Provider,
+		"retry_after": e.RetryAfter,
+	}
+	if e.Reason != "" {
+		inner["reason"] = e.Reason
+	}
+	data, _ := json.Marshal(map[string]interface{}{"error": inner})
 	return data
 }
 
-// tokenBucket implements a token-bucket rate limiter for a single provider.
+// tokenBucket implements a token-bucket rate limiter. It meters requests
+// (one token each) or LLM tokens (the request's estimated token count).
 type tokenBucket struct {
 	rate       float64 // tokens per second
 	burst      int     // max burst size
@@ -60,59 +83,180 @@ func newTokenBucket(rate float64, burst int) *tokenBucket {
 	}
 }
 
-// allow attempts to consume one token from the bucket. It returns true if the
-// request is allowed, or false if the bucket is empty (rate limited).
-func (tb *tokenBucket) allow() bool {
-	tb.mu.Lock()
-	defer tb.mu.Unlock()
+// newTPMBucket creates a bucket allowing tpm tokens per minute, with a full
+// minute's allowance available as burst.
+func newTPMBucket(tpm int) *tokenBucket {
+	return newTokenBucket(float64(tpm)/60.0, tpm)
+}
 
+// refill adds tokens for the time elapsed since the last refill. The caller
+// must hold tb.mu.
+func (tb *tokenBucket) refill() {
 	now := time.Now()
-	elapsed := now.Sub(tb.lastRefill).Seconds()
+	tb.tokens += now.Sub(tb.lastRefill).Seconds() * tb.rate
 	tb.lastRefill = now
-
-	// Refill tokens based on elapsed time.
-	tb.tokens += elapsed * tb.rate
 	if tb.tokens > float64(tb.burst) {
 		tb.tokens = float64(tb.burst)
 	}
+}
+
+// wait returns how long until n tokens are available, or 0 if they are
+// available now. A cost larger than the burst only needs a full bucket, so
+// oversized requests are delayed rather than rejected forever.
+func (tb *tokenBucket) wait(n float64) time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill()
+	need := math.Min(n, float64(tb.burst))
+	if tb.tokens >= need {
+		return 0
+	}
+	return time.Duration((need - tb.tokens) / tb.rate * float64(time.Second))
+}
+
+// take consumes n tokens. The balance may go negative for oversized costs.
+func (tb *tokenBucket) take(n float64) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill()
+	tb.tokens -= n
+}
 
-	if tb.tokens < 1.0 {
-		return false
+// refund returns n tokens to the bucket; a negative n charges extra.
+func (tb *tokenBucket) refund(n float64) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill()
+	tb.tokens += n
+	if tb.tokens > float64(tb.burst) {
+		tb.tokens = float64(tb.burst)
 	}
+}
+
+// limiter holds the limits for a single provider or model. Any of the limits
+// may be absent. inFlight is guarded by RateLimitMiddleware.admitMu.
+type limiter struct {
+	name          string       // e.g. `provider "openai"`, for error messages
+	requests      *tokenBucket // requests per second
+	tokens        *tokenBucket // tokens per minute
+	maxConcurrent int
+	inFlight      int
+}
 
-	tb.tokens -= 1.0
-	return true
+// grant records the capacity held by an admitted request until it completes.
+// slots lists the limiters whose concurrency slots it took, which excludes
+// those shared with a parent request.
+type grant struct {
+	limiters []*limiter
+	slots    []*limiter
+	charged  float64
+}
+
+// waiter is a request queued for capacity. wake is signalled when capacity
+// may have been freed.
+type waiter struct {
+	wake chan struct{}
 }
 
 // RateLimitMiddleware is a pipeline.Middleware that enforces per-provider
-// token-bucket rate limits.
+// request rates, per-provider and per-model tokens-per-minute, and
+// per-provider and per-model concurrency limits.
+//
+// Requests that do not fit are queued in arrival order per provider and
+// admitted as capacity frees up. A request is rejected only when its
+// provider's queue is full or it has waited longer than its max wait.
+// Token charges are estimated as TokensIn + MaxTokens and reconciled with
+// actual usage when the response arrives.
 type RateLimitMiddleware struct {
-	limiters    map[string]*tokenBucket // keyed by provider name
-	defaultRate float64
-	defaultBurst int
-	enabled     bool
-	mu          sync.RWMutex
+	limiters             map[string]*limiter // keyed by provider name
+	models               map[string]*limiter // keyed by model name
+	defaultRate          float64
+	defaultBurst         int
+	defaultTPM           int
+	defaultMaxConcurrent int
+	queueSize            int
+	maxWait              time.Duration
+	enabled              bool
+	mu                   sync.RWMutex // guards the fields above
+
+	// admitMu guards admission state: in-flight counts, wait queues, and
+	// grants. mu may be acquired while holding admitMu, never the reverse.
+	admitMu sync.Mutex
+	queues  map[string][]*waiter // keyed by provider name
+	grants  map[string]*grant    // keyed by request ID
 }
 
-// Compile-time assertion that RateLimitMiddleware implements pipeline.Middleware.
-var _ pipeline.Middleware = (*RateLimitMiddleware)(nil)
+// Compile-time assertions that RateLimitMiddleware implements
+// pipeline.Middleware and pipeline.Releaser.
+var (
+	_ pipeline.Middleware = (*RateLimitMiddleware)(nil)
+	_ pipeline.Releaser   = (*RateLimitMiddleware)(nil)
+)
+
+// NewRateLimitMiddleware creates a new RateLimitMiddleware from the rate
+// limit configuration.
+func NewRateLimitMiddleware(cfg config.RateLimitConfig) *RateLimitMiddleware {
+	rl := &RateLimitMiddleware{
+		enabled: cfg.Enabled,
+		queues:  make(map[string][]*waiter),
+		grants:  make(map[string]*grant),
+	}
+	rl.configure(cfg)
+	return rl
+}
 
-// NewRateLimitMiddleware creates a new RateLimitMiddleware with per-provider
-// limits and a default fallback rate.
-func NewRateLimitMiddleware(defaultRate float64, defaultBurst int, providerLimits map[string]config.ProviderRateLimit, enabled bool) *RateLimitMiddleware {
-	limiters := make(map[string]*tokenBucket, len(providerLimits))
-	for name, pl := range providerLimits {
-		limiters[strings.ToLower(name)] = newTokenBucket(pl.Rate, pl.Burst)
+// configure applies cfg and rebuilds all limiters. The caller must hold mu
+// or have exclusive access.
+func (rl *RateLimitMiddleware) configure(cfg config.RateLimitConfig) {
+	rl.defaultRate = cfg.DefaultRate
+	rl.defaultBurst = cfg.DefaultBurst
+	rl.defaultTPM = cfg.DefaultTPM
+	rl.defaultMaxConcurrent = cfg.DefaultMaxConcurrent
+	rl.queueSize = cfg.QueueSize
+	rl.maxWait = time.Duration(cfg.MaxWaitSeconds) * time.Second
+
+	rl.limiters = make(map[string]*limiter, len(cfg.ProviderLimits))
+	for name, pl := range cfg.ProviderLimits {
+		name = strings.ToLower(name)
+		rl.limiters[name] = rl.newProviderLimiter(name, pl)
 	}
 
-	return &RateLimitMiddleware{
-		limiters:     limiters,
-		defaultRate:  defaultRate,
-		defaultBurst: defaultBurst,
-		enabled:      enabled,
+	rl.models = make(map[string]*limiter, len(cfg.ModelLimits))
+	for model, ml := range cfg.ModelLimits {
+		l := &limiter{name: fmt.Sprintf("model %q", model), maxConcurrent: ml.MaxConcurrent}
+		if ml.TPM > 0 {
+			l.tokens = newTPMBucket(ml.TPM)
+		}
+		rl.models[model] = l
 	}
 }
 
+// newProviderLimiter builds a provider's limiter, falling back to the
+// defaults for limits pl does not set.
+func (rl *RateLimitMiddleware) newProviderLimiter(provider string, pl config.ProviderRateLimit) *limiter {
+	tpm := pl.TPM
+	if tpm == 0 {
+		tpm = rl.defaultTPM
+	}
+	maxConcurrent := pl.MaxConcurrent
+	if maxConcurrent == 0 {
+		maxConcurrent = rl.defaultMaxConcurrent
+	}
+
+	l := &limiter{
+		name:          fmt.Sprintf("provider %q", provider),
+		requests:      newTokenBucket(pl.Rate, pl.Burst),
+		maxConcurrent: maxConcurrent,
+	}
+	if tpm > 0 {
+		l.tokens = newTPMBucket(tpm)
+	}
+	return l
+}
+
 // Name returns the middleware name.
 func (rl *RateLimitMiddleware) Name() string {
 	return "ratelimit"
@@ -123,36 +267,281 @@ func (rl *RateLimitMiddleware) Enabled() bool {
 	return rl.enabled
 }
 
-// ProcessRequest checks the resolved provider against its rate limit.
-// If the rate limit is exceeded, it returns an error containing "rate_limited".
+// ProcessRequest admits the request against its provider's and model's
+// limits, waiting in the provider's queue if necessary. It returns a
+// RateLimitError when the queue is full or the wait would exceed the max
+// wait, and the context's error if the client goes away while queued.
+//
+// Concurrency is tracked by request ID; requests without an ID are subject
+// to rate and token limits only.
 func (rl *RateLimitMiddleware) ProcessRequest(ctx context.Context, req *pipeline.Request) (*pipeline.Request, error) {
 	provider := rl.resolveProvider(req)
 	if provider == "" {
 		return req, nil
 	}
 
-	bucket := rl.getOrCreateBucket(provider)
-	if !bucket.allow() {
-		retryAfter := 1.0 / bucket.rate
-		if retryAfter < 0.1 {
-			retryAfter = 0.1
+	cost := float64(req.TokensIn + req.MaxTokens)
+
+	rl.mu.RLock()
+	maxWait, queueSize := rl.maxWait, rl.queueSize
+	rl.mu.RUnlock()
+	if d, ok := req.Metadata[MaxWaitMetadataKey].(time.Duration); ok && d >= 0 && d < maxWait {
+		maxWait = d
+	}
+
+	rl.admitMu.Lock()
+	if len(rl.queues[provider]) == 0 {
+		blocked := rl.tryAdmit(req, provider, cost)
+		if blocked == nil {
+			rl.admitMu.Unlock()
+			return req, nil
 		}
+		if maxWait <= 0 || queueSize <= 0 {
+			rl.admitMu.Unlock()
+			return nil, blocked
+		}
+	}
+	if len(rl.queues[provider]) >= queueSize || maxWait <= 0 {
+		rl.admitMu.Unlock()
 		return nil, &RateLimitError{
 			Provider:   provider,
-			Rate:       bucket.rate,
-			RetryAfter: retryAfter,
-			Message:    fmt.Sprintf("rate_limited: provider %q has exceeded its rate limit of %.1f req/s", provider, bucket.rate),
+			Reason:     RateLimitReasonQueueFull,
+			Rate:       rl.providerRate(provider),
+			RetryAfter: 1,
+			Message:    fmt.Sprintf("rate_limited: wait queue for provider %q is full", provider),
+		}
+	}
+	w := &waiter{wake: make(chan struct{}, 1)}
+	rl.queues[provider] = append(rl.queues[provider], w)
+	rl.admitMu.Unlock()
+
+	deadline := time.NewTimer(maxWait)
+	defer deadline.Stop()
+
+	for {
+		var (
+			retry   *time.Timer
+			blocked *RateLimitError
+		)
+		rl.admitMu.Lock()
+		if q := rl.queues[provider]; len(q) > 0 && q[0] == w {
+			blocked = rl.tryAdmit(req, provider, cost)
+			if blocked == nil {
+				rl.removeWaiter(provider, w)
+				rl.admitMu.Unlock()
+				return req, nil
+			}
+		}
+		rl.admitMu.Unlock()
+
+		// Poll again when the blocking bucket refills; concurrency slots
+		// are signalled through wake when released.
+		var retryC <-chan time.Time
+		if blocked != nil && blocked.Reason != RateLimitReasonConcurrency {
+			retry = time.NewTimer(time.Duration(blocked.RetryAfter * float64(time.Second)))
+			retryC = retry.C
+		}
+
+		select {
+		case <-w.wake:
+		case <-retryC:
+		case <-deadline.C:
+			rl.admitMu.Lock()
+			rl.removeWaiter(provider, w)
+			rl.admitMu.Unlock()
+			return nil, &RateLimitError{
+				Provider:   provider,
+				Reason:     RateLimitReasonMaxWait,
+				Rate:       rl.providerRate(provider),
+				RetryAfter: 1,
+				Message:    fmt.Sprintf("rate_limited: no capacity for provider %q within %s", provider, maxWait),
+			}
+		case <-ctx.Done():
+			rl.admitMu.Lock()
+			rl.removeWaiter(provider, w)
+			rl.admitMu.Unlock()
+			return nil, ctx.Err()
+		}
+		if retry != nil {
+			retry.Stop()
+		}
+	}
+}
+
+// tryAdmit admits the request if every applicable limit has capacity,
+// consuming it and recording a grant. Otherwise it consumes nothing and
+// returns an error describing the limit with the longest wait. The caller
+// must hold admitMu.
+func (rl *RateLimitMiddleware) tryAdmit(req *pipeline.Request, provider string, cost float64) *RateLimitError {
+	lims := rl.limitersFor(provider, req.Model)
+
+	shared := make(map[*limiter]bool)
+	if parentID, _ := req.Metadata[ParentMetadataKey].(string); parentID != "" {
+		if parent, ok := rl.grants[parentID]; ok {
+			for _, l := range parent.slots {
+				shared[l] = true
+			}
+		}
+	}
+
+	var blocked *RateLimitError
+	block := func(reason string, wait time.Duration, msg string) {
+		retryAfter := math.Max(wait.Seconds(), 0.1)
+		if reason == RateLimitReasonConcurrency {
+			retryAfter = 1
+		}
+		if blocked == nil || retryAfter > blocked.RetryAfter {
+			blocked = &RateLimitError{
+				Provider:   provider,
+				Reason:     reason,
+				Rate:       rl.providerRate(provider),
+				RetryAfter: retryAfter,
+				Message:    "rate_limited: " + msg,
+			}
+		}
+	}
+
+	for _, l := range lims {
+		if req.ID != "" && !shared[l] && l.maxConcurrent > 0 && l.inFlight >= l.maxConcurrent {
+			block(RateLimitReasonConcurrency, 0,
+				fmt.Sprintf("%s has reached its limit of %d concurrent requests", l.name, l.maxConcurrent))
+		}
+		if l.requests != nil {
+			if wait := l.requests.wait(1); wait > 0 {
+				block(RateLimitReasonRequests, wait,
+					fmt.Sprintf("%s has exceeded its rate limit of %.1f req/s", l.name, l.requests.rate))
+			}
+		}
+		if l.tokens != nil && cost > 0 {
+			if wait := l.tokens.wait(cost); wait > 0 {
+				block(RateLimitReasonTokens, wait,
+					fmt.Sprintf("%s has exceeded its limit of %d tokens/min", l.name, l.tokens.burst))
+			}
+		}
+	}
+	if blocked != nil {
+		return blocked
+	}
+
+	for _, l := range lims {
+		if l.requests != nil {
+			l.requests.take(1)
+		}
+		if l.tokens != nil && cost > 0 {
+			l.tokens.take(cost)
+		}
+	}
+	if req.ID != "" {
+		var slots []*limiter
+		for _, l := range lims {
+			if !shared[l] {
+				l.inFlight++
+				slots = append(slots, l)
+			}
+		}
+		rl.grants[req.ID] = &grant{limiters: lims, slots: slots, charged: cost}
+	}
+	return nil
+}
+
+// removeWaiter drops w from the provider's queue and wakes the new head.
+// The caller must hold admitMu.
+func (rl *RateLimitMiddleware) removeWaiter(provider string, w *waiter) {
+	q := rl.queues[provider]
+	for i, qw := range q {
+		if qw == w {
+			q = append(q[:i], q[i+1:]...)
+			break
+		}
+	}
+	if len(q) == 0 {
+		delete(rl.queues, provider)
+		return
+	}
+	rl.queues[provider] = q
+	signal(q[0])
+}
+
+// wakeAll signals the head of every queue that capacity may be available.
+// The caller must hold admitMu.
+func (rl *RateLimitMiddleware) wakeAll() {
+	for _, q := range rl.queues {
+		if len(q) > 0 {
+			signal(q[0])
 		}
 	}
+}
 
-	return req, nil
+// signal wakes w without blocking.
+func signal(w *waiter) {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
 }
 
-// ProcessResponse is a no-op for rate limiting.
+// ProcessResponse releases the request's concurrency slots and reconciles
+// its estimated token charge with the tokens actually used.
 func (rl *RateLimitMiddleware) ProcessResponse(ctx context.Context, req *pipeline.Request, resp *pipeline.Response) (*pipeline.Response, error) {
+	rl.finish(req.ID, float64(req.TokensIn+resp.TokensOut), true)
 	return resp, nil
 }
 
+// Release frees the concurrency slots of a request that never reached
+// ProcessResponse. Its token charge is kept, since the upstream may have
+// processed part of it.
+func (rl *RateLimitMiddleware) Release(ctx context.Context, req *pipeline.Request) {
+	rl.finish(req.ID, 0, false)
+}
+
+// finish ends a request's grant, optionally refunding the difference between
+// its estimated and actual token usage.
+func (rl *RateLimitMiddleware) finish(requestID string, actual float64, reconcile bool) {
+	if requestID == "" {
+		return
+	}
+
+	rl.admitMu.Lock()
+	defer rl.admitMu.Unlock()
+
+	g, ok := rl.grants[requestID]
+	if !ok {
+		return
+	}
+	delete(rl.grants, requestID)
+	for _, l := range g.slots {
+		l.inFlight--
+	}
+	for _, l := range g.limiters {
+		if reconcile && l.tokens != nil && g.charged > 0 {
+			l.tokens.refund(g.charged - actual)
+		}
+	}
+	rl.wakeAll()
+}
+
+// limitersFor returns the limiters that apply to a request for the given
+// provider and model.
+func (rl *RateLimitMiddleware) limitersFor(provider, model string) []*limiter {
+	lims := []*limiter{rl.getOrCreateLimiter(provider)}
+
+	rl.mu.RLock()
+	ml, ok := rl.models[model]
+	rl.mu.RUnlock()
+	if ok {
+		lims = append(lims, ml)
+	}
+	return lims
+}
+
+// providerRate returns the provider's configured request rate.
+func (rl *RateLimitMiddleware) providerRate(provider string) float64 {
+	if l := rl.getOrCreateLimiter(provider); l.requests != nil {
+		return l.requests.rate
+	}
+	return 0
+}
+
 // resolveProvider determines the provider name from the request metadata
 // or by inferring it from the model name.
 func (rl *RateLimitMiddleware) resolveProvider(req *pipeline.Request) string {
@@ -186,44 +575,41 @@ func (rl *RateLimitMiddleware) resolveProvider(req *pipeline.Request) string {
 	}
 }
 
-// Reconfigure replaces the default rate/burst and rebuilds all token buckets
-// with the new settings. This is called when the config is hot-reloaded.
-func (rl *RateLimitMiddleware) Reconfigure(defaultRate float64, defaultBurst int, providerLimits map[string]config.ProviderRateLimit) {
+// Reconfigure replaces the rate limit settings and rebuilds all limiters.
+// This is called when the config is hot-reloaded. Requests already admitted
+// keep their slots on the old limiters until they finish.
+func (rl *RateLimitMiddleware) Reconfigure(cfg config.RateLimitConfig) {
 	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	rl.defaultRate = defaultRate
-	rl.defaultBurst = defaultBurst
+	rl.configure(cfg)
+	rl.mu.Unlock()
 
-	// Rebuild all buckets.
-	newLimiters := make(map[string]*tokenBucket, len(providerLimits))
-	for name, pl := range providerLimits {
-		newLimiters[strings.ToLower(name)] = newTokenBucket(pl.Rate, pl.Burst)
-	}
-	rl.limiters = newLimiters
+	// Queued requests re-check against the new limits.
+	rl.admitMu.Lock()
+	rl.wakeAll()
+	rl.admitMu.Unlock()
 }
 
-// getOrCreateBucket returns the token bucket for a provider, creating one
+// getOrCreateLimiter returns the limiter for a provider, creating one
 // with default settings if it does not exist yet.
-func (rl *RateLimitMiddleware) getOrCreateBucket(provider string) *tokenBucket {
+func (rl *RateLimitMiddleware) getOrCreateLimiter(provider string) *limiter {
 	rl.mu.RLock()
-	bucket, ok := rl.limiters[provider]
+	l, ok := rl.limiters[provider]
 	rl.mu.RUnlock()
 
 	if ok {
-		return bucket
+		return l
 	}
 
-	// Create a new bucket with default rate/burst.
+	// Create a new limiter with the default settings.
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
 	// Double-check after acquiring write lock.
-	if bucket, ok = rl.limiters[provider]; ok {
-		return bucket
+	if l, ok = rl.limiters[provider]; ok {
+		return l
 	}
 
-	bucket = newTokenBucket(rl.defaultRate, rl.defaultBurst)
-	rl.limiters[provider] = bucket
-	return bucket
+	l = rl.newProviderLimiter(provider, config.ProviderRateLimit{Rate: rl.defaultRate, Burst: rl.defaultBurst})
+	rl.limiters[provider] = l
+	return l
 }
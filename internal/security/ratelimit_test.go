@@ -0,0 +1,265 @@
+package security
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/allaspectsdev/tokenman/internal/config"
+	"github.com/allaspectsdev/tokenman/internal/pipeline"
+)
+
+// testRateLimitConfig returns a config with generous request rates so tests
+// exercise only the limits they set.
+func testRateLimitConfig() config.RateLimitConfig {
+	return config.RateLimitConfig{
+		Enabled:        true,
+		DefaultRate:    1000,
+		DefaultBurst:   1000,
+		QueueSize:      10,
+		MaxWaitSeconds: 5,
+	}
+}
+
+func rlRequest(id, model string, tokensIn, maxTokens int) *pipeline.Request {
+	return &pipeline.Request{
+		ID:        id,
+		Model:     model,
+		TokensIn:  tokensIn,
+		MaxTokens: maxTokens,
+		Metadata:  map[string]interface{}{"provider": "openai"},
+	}
+}
+
+// admitAsync runs ProcessRequest in the background and reports its error.
+func admitAsync(rl *RateLimitMiddleware, req *pipeline.Request) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := rl.ProcessRequest(context.Background(), req)
+		done <- err
+	}()
+	return done
+}
+
+// waitForQueue blocks until the provider's queue holds n waiters.
+func waitForQueue(t *testing.T, rl *RateLimitMiddleware, provider string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		rl.admitMu.Lock()
+		got := len(rl.queues[provider])
+		rl.admitMu.Unlock()
+		if got == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("queue for %s never reached %d waiters", provider, n)
+}
+
+func assertRateLimited(t *testing.T, err error, reason string) {
+	t.Helper()
+	var rlErr *RateLimitError
+	if !errors.As(err, &rlErr) {
+		t.Fatalf("expected RateLimitError, got %v", err)
+	}
+	if rlErr.Reason != reason {
+		t.Errorf("Reason = %q, want %q (%s)", rlErr.Reason, reason, rlErr.Message)
+	}
+}
+
+func TestRateLimit_RequestRateFailsFastWithoutQueue(t *testing.T) {
+	cfg := testRateLimitConfig()
+	cfg.MaxWaitSeconds = 0
+	cfg.ProviderLimits = map[string]config.ProviderRateLimit{"openai": {Rate: 0.001, Burst: 1}}
+	rl := NewRateLimitMiddleware(cfg)
+
+	if _, err := rl.ProcessRequest(context.Background(), rlRequest("r1", "gpt-4o", 10, 10)); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	_, err := rl.ProcessRequest(context.Background(), rlRequest("r2", "gpt-4o", 10, 10))
+	assertRateLimited(t, err, RateLimitReasonRequests)
+}
+
+func TestRateLimit_ConcurrencyQueuesUntilSlotFrees(t *testing.T) {
+	cfg := testRateLimitConfig()
+	cfg.ProviderLimits = map[string]config.ProviderRateLimit{"openai": {Rate: 1000, Burst: 1000, MaxConcurrent: 1}}
+	rl := NewRateLimitMiddleware(cfg)
+
+	first := rlRequest("r1", "gpt-4o", 10, 10)
+	if _, err := rl.ProcessRequest(context.Background(), first); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+
+	second := admitAsync(rl, rlRequest("r2", "gpt-4o", 10, 10))
+	waitForQueue(t, rl, "openai", 1)
+	select {
+	case err := <-second:
+		t.Fatalf("second request admitted while slot held: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if _, err := rl.ProcessResponse(context.Background(), first, &pipeline.Response{TokensOut: 5}); err != nil {
+		t.Fatalf("ProcessResponse: %v", err)
+	}
+	select {
+	case err := <-second:
+		if err != nil {
+			t.Fatalf("second request: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("second request not admitted after slot freed")
+	}
+}
+
+func TestRateLimit_ChildSharesParentSlots(t *testing.T) {
+	cfg := testRateLimitConfig()
+	cfg.ProviderLimits = map[string]config.ProviderRateLimit{"openai": {Rate: 1000, Burst: 1000, MaxConcurrent: 1}}
+	rl := NewRateLimitMiddleware(cfg)
+
+	parent := rlRequest("parent", "gpt-4o", 10, 10)
+	if _, err := rl.ProcessRequest(context.Background(), parent); err != nil {
+		t.Fatalf("parent: %v", err)
+	}
+	child := rlRequest("child", "gpt-4o-mini", 10, 10)
+	child.Metadata[ParentMetadataKey] = "parent"
+	child.Metadata[MaxWaitMetadataKey] = time.Duration(0)
+	if _, err := rl.ProcessRequest(context.Background(), child); err != nil {
+		t.Fatalf("child blocked by its parent's slot: %v", err)
+	}
+	rl.Release(context.Background(), child)
+
+	// Releasing the child must not free the parent's slot.
+	other := rlRequest("other", "gpt-4o", 10, 10)
+	other.Metadata[MaxWaitMetadataKey] = time.Duration(0)
+	_, err := rl.ProcessRequest(context.Background(), other)
+	assertRateLimited(t, err, RateLimitReasonConcurrency)
+	rl.Release(context.Background(), parent)
+	if _, err := rl.ProcessRequest(context.Background(), rlRequest("other", "gpt-4o", 10, 10)); err != nil {
+		t.Fatalf("request after parent finished: %v", err)
+	}
+}
+
+func TestRateLimit_QueueIsFIFO(t *testing.T) {
+	cfg := testRateLimitConfig()
+	cfg.DefaultMaxConcurrent = 1
+	rl := NewRateLimitMiddleware(cfg)
+
+	holder := rlRequest("hold", "gpt-4o", 1, 1)
+	if _, err := rl.ProcessRequest(context.Background(), holder); err != nil {
+		t.Fatalf("holder: %v", err)
+	}
+
+	a := rlRequest("a", "gpt-4o", 1, 1)
+	b := rlRequest("b", "gpt-4o", 1, 1)
+	doneA := admitAsync(rl, a)
+	waitForQueue(t, rl, "openai", 1)
+	doneB := admitAsync(rl, b)
+	waitForQueue(t, rl, "openai", 2)
+
+	rl.Release(context.Background(), holder)
+	if err := <-doneA; err != nil {
+		t.Fatalf("a: %v", err)
+	}
+	select {
+	case <-doneB:
+		t.Fatal("b admitted before a finished")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	rl.Release(context.Background(), a)
+	if err := <-doneB; err != nil {
+		t.Fatalf("b: %v", err)
+	}
+}
+
+func TestRateLimit_QueueFullAndMaxWait(t *testing.T) {
+	cfg := testRateLimitConfig()
+	cfg.DefaultMaxConcurrent = 1
+	cfg.QueueSize = 1
+	rl := NewRateLimitMiddleware(cfg)
+
+	holder := rlRequest("hold", "gpt-4o", 1, 1)
+	if _, err := rl.ProcessRequest(context.Background(), holder); err != nil {
+		t.Fatalf("holder: %v", err)
+	}
+
+	short := rlRequest("short", "gpt-4o", 1, 1)
+	short.Metadata[MaxWaitMetadataKey] = 50 * time.Millisecond
+	done := admitAsync(rl, short)
+	waitForQueue(t, rl, "openai", 1)
+
+	_, err := rl.ProcessRequest(context.Background(), rlRequest("full", "gpt-4o", 1, 1))
+	assertRateLimited(t, err, RateLimitReasonQueueFull)
+
+	assertRateLimited(t, <-done, RateLimitReasonMaxWait)
+	waitForQueue(t, rl, "openai", 0)
+}
+
+func TestRateLimit_CancelledWhileQueued(t *testing.T) {
+	cfg := testRateLimitConfig()
+	cfg.DefaultMaxConcurrent = 1
+	rl := NewRateLimitMiddleware(cfg)
+
+	if _, err := rl.ProcessRequest(context.Background(), rlRequest("hold", "gpt-4o", 1, 1)); err != nil {
+		t.Fatalf("holder: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := rl.ProcessRequest(ctx, rlRequest("gone", "gpt-4o", 1, 1))
+		done <- err
+	}()
+	waitForQueue(t, rl, "openai", 1)
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	waitForQueue(t, rl, "openai", 0)
+}
+
+func TestRateLimit_TokensPerMinuteReconciled(t *testing.T) {
+	cfg := testRateLimitConfig()
+	cfg.ProviderLimits = map[string]config.ProviderRateLimit{"openai": {Rate: 1000, Burst: 1000, TPM: 1000}}
+	rl := NewRateLimitMiddleware(cfg)
+	noWait := func(r *pipeline.Request) *pipeline.Request {
+		r.Metadata[MaxWaitMetadataKey] = time.Duration(0)
+		return r
+	}
+
+	// Charged 100 + 900 = the whole minute's allowance.
+	first := rlRequest("r1", "gpt-4o", 100, 900)
+	if _, err := rl.ProcessRequest(context.Background(), first); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	_, err := rl.ProcessRequest(context.Background(), noWait(rlRequest("r2", "gpt-4o", 100, 600)))
+	assertRateLimited(t, err, RateLimitReasonTokens)
+
+	// Only 200 tokens were actually used, so 800 are refunded.
+	if _, err := rl.ProcessResponse(context.Background(), first, &pipeline.Response{TokensOut: 100}); err != nil {
+		t.Fatalf("ProcessResponse: %v", err)
+	}
+	if _, err := rl.ProcessRequest(context.Background(), noWait(rlRequest("r3", "gpt-4o", 100, 600))); err != nil {
+		t.Errorf("request after reconciliation: %v", err)
+	}
+}
+
+func TestRateLimit_ModelLimitsAreIndependent(t *testing.T) {
+	cfg := testRateLimitConfig()
+	cfg.MaxWaitSeconds = 0
+	cfg.ModelLimits = map[string]config.ModelRateLimit{"gpt-4o": {MaxConcurrent: 1}}
+	rl := NewRateLimitMiddleware(cfg)
+
+	if _, err := rl.ProcessRequest(context.Background(), rlRequest("r1", "gpt-4o", 1, 1)); err != nil {
+		t.Fatalf("first gpt-4o: %v", err)
+	}
+	_, err := rl.ProcessRequest(context.Background(), rlRequest("r2", "gpt-4o", 1, 1))
+	assertRateLimited(t, err, RateLimitReasonConcurrency)
+
+	if _, err := rl.ProcessRequest(context.Background(), rlRequest("r3", "gpt-4o-mini", 1, 1)); err != nil {
+		t.Errorf("other model should not share the gpt-4o limit: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package security
+
+import (
+	"sort"
+	"strings"
+)
+
+// Redactor masks PII and secrets in free-form text such as log lines and
+// stored request/response bodies. It uses the same patterns and allow-list
+// as the PII middleware, but replaces each match with a fixed
+// "[<TYPE>_REDACTED]" marker because nothing needs to be restored.
+type Redactor struct {
+	patterns  []*PIIPattern
+	allowList map[string]bool
+}
+
+// NewRedactor creates a Redactor that leaves allow-listed values intact.
+func NewRedactor(allowList []string) *Redactor {
+	allow := make(map[string]bool, len(allowList))
+	for _, v := range allowList {
+		allow[v] = true
+	}
+	return &Redactor{
+		patterns:  CompilePatterns(),
+		allowList: allow,
+	}
+}
+
+// redactSpan is one match to mask.
+type redactSpan struct {
+	start, end int
+	name       string
+}
+
+// Redact returns text with every detected PII value and secret masked.
+// All patterns are matched against the original text and overlapping
+// matches are merged, so one pattern cannot leave part of a secret that
+// another pattern would have covered (e.g. the digits of an API key
+// matched as a phone number).
+func (r *Redactor) Redact(text string) string {
+	var spans []redactSpan
+	for _, pattern := range r.patterns {
+		for _, loc := range pattern.Regex.FindAllStringIndex(text, -1) {
+			match := text[loc[0]:loc[1]]
+			if r.allowList[match] {
+				continue
+			}
+			if pattern.Validate != nil && !pattern.Validate(match) {
+				continue
+			}
+			spans = append(spans, redactSpan{start: loc[0], end: loc[1], name: pattern.Name})
+		}
+	}
+	if len(spans) == 0 {
+		return text
+	}
+
+	// Earliest first; of matches starting together, the longest.
+	sort.Slice(spans, func(i, j int) bool {
+		if spans[i].start != spans[j].start {
+			return spans[i].start < spans[j].start
+		}
+		return spans[i].end > spans[j].end
+	})
+
+	var b strings.Builder
+	b.Grow(len(text))
+	pos := 0
+	for i := 0; i < len(spans); {
+		cur := spans[i]
+		for i++; i < len(spans) && spans[i].start < cur.end; i++ {
+			if spans[i].end > cur.end {
+				cur.end = spans[i].end
+			}
+		}
+		b.WriteString(text[pos:cur.start])
+		b.WriteString("[" + cur.name + "_REDACTED]")
+		pos = cur.end
+	}
+	b.WriteString(text[pos:])
+	return b.String()
+}
@@ -2,10 +2,16 @@ package store
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
+	"github.com/allaspectsdev/tokenman/internal/alert"
+	"github.com/allaspectsdev/tokenman/internal/audit"
+	"github.com/allaspectsdev/tokenman/internal/auth"
 	cachepkg "github.com/allaspectsdev/tokenman/internal/cache"
+	"github.com/allaspectsdev/tokenman/internal/security"
 )
 
 // FingerprintAdapter adapts Store to compress.FingerprintStore interface.
@@ -92,20 +98,71 @@ func (a *CacheAdapter) DeleteExpired() error {
 	return err
 }
 
-// BudgetAdapter adapts Store to security.BudgetStore interface.
+// EmbeddingAdapter adapts Store to cache.EmbeddingStore interface.
+type EmbeddingAdapter struct {
+	store *Store
+}
+
+// NewEmbeddingAdapter creates a new EmbeddingAdapter wrapping the given Store.
+func NewEmbeddingAdapter(s *Store) *EmbeddingAdapter {
+	return &EmbeddingAdapter{store: s}
+}
+
+// GetEmbedding retrieves a cached embedding by key.
+func (a *EmbeddingAdapter) GetEmbedding(key string) (*cachepkg.EmbeddingEntry, error) {
+	se, err := a.store.GetEmbedding(key)
+	if err != nil {
+		return nil, err
+	}
+	createdAt, _ := time.Parse(time.RFC3339, se.CreatedAt)
+	expiresAt, _ := time.Parse(time.RFC3339, se.ExpiresAt)
+	return &cachepkg.EmbeddingEntry{
+		Embedding: se.Embedding,
+		Tokens:    int(se.Tokens),
+		Model:     se.Model,
+		CreatedAt: createdAt,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// SetEmbedding stores a cached embedding.
+func (a *EmbeddingAdapter) SetEmbedding(key string, entry *cachepkg.EmbeddingEntry) error {
+	return a.store.SetEmbedding(&EmbeddingEntry{
+		Key:       key,
+		Model:     entry.Model,
+		Embedding: entry.Embedding,
+		Tokens:    int64(entry.Tokens),
+		CreatedAt: entry.CreatedAt.Format(time.RFC3339),
+		ExpiresAt: entry.ExpiresAt.Format(time.RFC3339),
+	})
+}
+
+// DeleteExpiredEmbeddings removes all expired embeddings from the store.
+func (a *EmbeddingAdapter) DeleteExpiredEmbeddings() error {
+	_, err := a.store.DeleteExpiredEmbeddings()
+	return err
+}
+
+// BudgetAdapter adapts Store to the security.BudgetStore and
+// security.BudgetReserver interfaces.
 type BudgetAdapter struct {
 	store *Store
 }
 
+var (
+	_ security.BudgetStore    = (*BudgetAdapter)(nil)
+	_ security.BudgetReserver = (*BudgetAdapter)(nil)
+)
+
 // NewBudgetAdapter creates a new BudgetAdapter wrapping the given Store.
 func NewBudgetAdapter(s *Store) *BudgetAdapter {
 	return &BudgetAdapter{store: s}
 }
 
-// GetBudget retrieves the current spending amount and limit for a budget period.
-// Returns zero values if no budget record exists.
-func (a *BudgetAdapter) GetBudget(period, periodStart string) (amount, limit float64, err error) {
-	b, err := a.store.GetBudget(period, periodStart)
+// GetBudget retrieves the current spending amount and limit for a budget
+// scope and period. Returns zero values if no budget record exists.
+func (a *BudgetAdapter) GetBudget(scope, period, periodStart string) (amount, limit float64, err error) {
+	b, err := a.store.GetScopedBudget(scope, period, periodStart)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return 0, 0, nil
@@ -115,9 +172,44 @@ func (a *BudgetAdapter) GetBudget(period, periodStart string) (amount, limit flo
 	return b.AmountUSD, b.LimitUSD, nil
 }
 
-// AddSpending records spending against a budget period.
-func (a *BudgetAdapter) AddSpending(period, periodStart string, amount, limit float64) error {
-	return a.store.AddSpending(period, periodStart, amount, limit)
+// AddSpending records spending against a budget scope and period.
+func (a *BudgetAdapter) AddSpending(scope, period, periodStart string, amount, limit float64) error {
+	return a.store.AddScopedSpending(scope, period, periodStart, amount, limit)
+}
+
+// ReserveBudget atomically reserves amount against every line. Committed and
+// Spent are copied back onto the caller's lines.
+func (a *BudgetAdapter) ReserveBudget(requestID string, amount float64, lines []security.BudgetLine, expiresAt time.Time) (bool, error) {
+	storeLines := toStoreBudgetLines(lines)
+	ok, err := a.store.ReserveBudget(requestID, amount, storeLines, expiresAt)
+	for i := range lines {
+		lines[i].Committed = storeLines[i].Committed
+		lines[i].Spent = storeLines[i].Spent
+	}
+	return ok, err
+}
+
+// SettleBudgetReservation replaces a request's reservations with its actual cost.
+func (a *BudgetAdapter) SettleBudgetReservation(requestID string, amount float64, lines []security.BudgetLine) error {
+	return a.store.SettleBudgetReservation(requestID, amount, toStoreBudgetLines(lines))
+}
+
+// ReleaseBudgetReservation drops a request's outstanding reservations.
+func (a *BudgetAdapter) ReleaseBudgetReservation(requestID string) error {
+	return a.store.ReleaseBudgetReservation(requestID)
+}
+
+func toStoreBudgetLines(lines []security.BudgetLine) []BudgetLine {
+	out := make([]BudgetLine, len(lines))
+	for i, l := range lines {
+		out[i] = BudgetLine{
+			Scope:       l.Scope,
+			Period:      l.Period,
+			PeriodStart: l.PeriodStart,
+			Limit:       l.Limit,
+		}
+	}
+	return out
 }
 
 // PIIAdapter wraps the Store for PII logging.
@@ -141,3 +233,173 @@ func (a *PIIAdapter) LogPII(requestID, piiType, action, fieldPath, context strin
 		Context:   context,
 	})
 }
+
+// PolicyAdapter adapts Store to the security.PolicyLogger interface.
+type PolicyAdapter struct {
+	store *Store
+}
+
+var _ security.PolicyLogger = (*PolicyAdapter)(nil)
+
+// NewPolicyAdapter creates a new PolicyAdapter wrapping the given Store.
+func NewPolicyAdapter(s *Store) *PolicyAdapter {
+	return &PolicyAdapter{store: s}
+}
+
+// LogPolicyDecision records a policy decision.
+func (a *PolicyAdapter) LogPolicyDecision(d security.PolicyDecision) error {
+	return a.store.InsertPolicyDecision(&PolicyDecision{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		RequestID: d.RequestID,
+		Rule:      d.Rule,
+		Action:    d.Action,
+		Detail:    d.Detail,
+	})
+}
+
+// AuditAdapter adapts Store to the audit.Recorder and auth.DenialRecorder
+// interfaces.
+type AuditAdapter struct {
+	store *Store
+}
+
+var (
+	_ audit.Recorder      = (*AuditAdapter)(nil)
+	_ auth.DenialRecorder = (*AuditAdapter)(nil)
+)
+
+// NewAuditAdapter creates a new AuditAdapter wrapping the given Store.
+func NewAuditAdapter(s *Store) *AuditAdapter {
+	return &AuditAdapter{store: s}
+}
+
+// Record appends an event to the audit log.
+func (a *AuditAdapter) Record(e audit.Event) error {
+	detail := ""
+	if len(e.Detail) > 0 {
+		data, err := json.Marshal(e.Detail)
+		if err != nil {
+			return fmt.Errorf("store: marshal audit detail: %w", err)
+		}
+		detail = string(data)
+	}
+	return a.store.InsertAuditEvent(&AuditEvent{
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		Kind:       e.Kind,
+		Actor:      e.Actor,
+		Action:     e.Action,
+		RemoteAddr: e.RemoteAddr,
+		Detail:     detail,
+	})
+}
+
+// RecordDenial records a rejected authentication or authorization attempt.
+func (a *AuditAdapter) RecordDenial(d auth.Denial) error {
+	return a.Record(audit.Event{
+		Kind:       audit.KindAuthDenied,
+		Actor:      d.Actor,
+		Action:     d.Method + " " + d.Path,
+		RemoteAddr: d.RemoteAddr,
+		Detail: map[string]interface{}{
+			"status": d.Status,
+			"reason": d.Reason,
+		},
+	})
+}
+
+// AlertAdapter adapts Store to the alert.Store interface.
+type AlertAdapter struct {
+	store *Store
+}
+
+var _ alert.Store = (*AlertAdapter)(nil)
+
+// NewAlertAdapter creates a new AlertAdapter wrapping the given Store.
+func NewAlertAdapter(s *Store) *AlertAdapter {
+	return &AlertAdapter{store: s}
+}
+
+// RecordAlert stores an alert, returning false if an alert with the same
+// dedup key has already been recorded.
+func (a *AlertAdapter) RecordAlert(al alert.Alert) (bool, error) {
+	var fields string
+	if len(al.Fields) > 0 {
+		b, err := json.Marshal(al.Fields)
+		if err != nil {
+			return false, err
+		}
+		fields = string(b)
+	}
+	return a.store.InsertAlert(&Alert{
+		Timestamp: al.Timestamp.UTC().Format(time.RFC3339),
+		Kind:      al.Kind,
+		Severity:  al.Severity,
+		Title:     al.Title,
+		Message:   al.Message,
+		Fields:    fields,
+		DedupKey:  al.DedupKey,
+	})
+}
+
+// VirtualKeyAdapter adapts Store to the auth.KeyStore interface.
+type VirtualKeyAdapter struct {
+	store *Store
+}
+
+// NewVirtualKeyAdapter creates a new VirtualKeyAdapter wrapping the given Store.
+func NewVirtualKeyAdapter(s *Store) *VirtualKeyAdapter {
+	return &VirtualKeyAdapter{store: s}
+}
+
+// LookupVirtualKey retrieves a virtual key by hash, converting it to an
+// auth.KeyRecord. Returns nil with no error if no key matches.
+func (a *VirtualKeyAdapter) LookupVirtualKey(hash string) (*auth.KeyRecord, error) {
+	k, err := a.store.GetVirtualKeyByHash(hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return toKeyRecord(k), nil
+}
+
+// LookupVirtualKeyByID retrieves a virtual key by ID, converting it to an
+// auth.KeyRecord. Returns nil with no error if no key has the ID.
+func (a *VirtualKeyAdapter) LookupVirtualKeyByID(id string) (*auth.KeyRecord, error) {
+	k, err := a.store.GetVirtualKey(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return toKeyRecord(k), nil
+}
+
+// TouchVirtualKey records the time a key was last used.
+func (a *VirtualKeyAdapter) TouchVirtualKey(id string, at time.Time) error {
+	return a.store.TouchVirtualKey(id, at)
+}
+
+// toKeyRecord converts a stored virtual key to an auth.KeyRecord.
+func toKeyRecord(k *VirtualKey) *auth.KeyRecord {
+	parse := func(s string) time.Time {
+		t, _ := time.Parse(time.RFC3339, s)
+		return t
+	}
+	return &auth.KeyRecord{
+		ID:            k.ID,
+		Name:          k.Name,
+		Owner:         k.Owner,
+		Prefix:        k.KeyPrefix,
+		Hash:          k.KeyHash,
+		Scopes:        k.Scopes,
+		AllowedModels: k.AllowedModels,
+		Priority:      k.Priority,
+		CreatedAt:     parse(k.CreatedAt),
+		ExpiresAt:     parse(k.ExpiresAt),
+		RevokedAt:     parse(k.RevokedAt),
+		LastUsedAt:    parse(k.LastUsedAt),
+	}
+}
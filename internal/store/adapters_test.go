@@ -1,10 +1,14 @@
 package store
 
 import (
+	"database/sql"
+	"errors"
 	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/allaspectsdev/tokenman/internal/alert"
+	"github.com/allaspectsdev/tokenman/internal/auth"
 	"github.com/allaspectsdev/tokenman/internal/cache"
 )
 
@@ -250,6 +254,50 @@ func TestCacheAdapter_DeleteExpired(t *testing.T) {
 	}
 }
 
+// ---------------------------------------------------------------------------
+// EmbeddingAdapter
+// ---------------------------------------------------------------------------
+
+func TestEmbeddingAdapter_SetGetAndDeleteExpired(t *testing.T) {
+	s := openTestStore(t)
+	ea := NewEmbeddingAdapter(s)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	valid := &cache.EmbeddingEntry{
+		Embedding: []byte(`[0.0123,-0.5]`),
+		Tokens:    7,
+		Model:     "text-embedding-3-small",
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+	if err := ea.SetEmbedding("valid-key", valid); err != nil {
+		t.Fatalf("SetEmbedding (valid): %v", err)
+	}
+	expired := *valid
+	expired.ExpiresAt = now.Add(-time.Hour)
+	if err := ea.SetEmbedding("expired-key", &expired); err != nil {
+		t.Fatalf("SetEmbedding (expired): %v", err)
+	}
+
+	got, err := ea.GetEmbedding("valid-key")
+	if err != nil {
+		t.Fatalf("GetEmbedding: %v", err)
+	}
+	if string(got.Embedding) != string(valid.Embedding) || got.Tokens != 7 || got.Model != valid.Model || !got.ExpiresAt.Equal(valid.ExpiresAt) {
+		t.Errorf("GetEmbedding = %+v; want %+v", got, valid)
+	}
+
+	if err := ea.DeleteExpiredEmbeddings(); err != nil {
+		t.Fatalf("DeleteExpiredEmbeddings: %v", err)
+	}
+	if _, err := ea.GetEmbedding("expired-key"); err == nil {
+		t.Error("expired embedding should have been deleted")
+	}
+	if _, err := ea.GetEmbedding("valid-key"); err != nil {
+		t.Errorf("valid embedding should remain: %v", err)
+	}
+}
+
 // ---------------------------------------------------------------------------
 // BudgetAdapter
 // ---------------------------------------------------------------------------
@@ -258,7 +306,7 @@ func TestBudgetAdapter_GetNonExistent(t *testing.T) {
 	s := openTestStore(t)
 	ba := NewBudgetAdapter(s)
 
-	amount, limit, err := ba.GetBudget("daily", "2025-01-01")
+	amount, limit, err := ba.GetBudget(GlobalBudgetScope, "daily", "2025-01-01")
 	if err != nil {
 		t.Fatalf("GetBudget: unexpected error: %v", err)
 	}
@@ -279,11 +327,11 @@ func TestBudgetAdapter_AddSpendingCreatesRecord(t *testing.T) {
 	spendAmount := 1.50
 	spendLimit := 10.00
 
-	if err := ba.AddSpending(period, periodStart, spendAmount, spendLimit); err != nil {
+	if err := ba.AddSpending(GlobalBudgetScope, period, periodStart, spendAmount, spendLimit); err != nil {
 		t.Fatalf("AddSpending: %v", err)
 	}
 
-	amount, limit, err := ba.GetBudget(period, periodStart)
+	amount, limit, err := ba.GetBudget(GlobalBudgetScope, period, periodStart)
 	if err != nil {
 		t.Fatalf("GetBudget: %v", err)
 	}
@@ -302,11 +350,11 @@ func TestBudgetAdapter_GetBudgetAfterAddSpending(t *testing.T) {
 	period := "monthly"
 	periodStart := "2025-06-01"
 
-	if err := ba.AddSpending(period, periodStart, 5.00, 100.00); err != nil {
+	if err := ba.AddSpending(GlobalBudgetScope, period, periodStart, 5.00, 100.00); err != nil {
 		t.Fatalf("AddSpending: %v", err)
 	}
 
-	amount, limit, err := ba.GetBudget(period, periodStart)
+	amount, limit, err := ba.GetBudget(GlobalBudgetScope, period, periodStart)
 	if err != nil {
 		t.Fatalf("GetBudget: %v", err)
 	}
@@ -325,17 +373,17 @@ func TestBudgetAdapter_MultipleAddSpendingAccumulate(t *testing.T) {
 	period := "weekly"
 	periodStart := "2025-06-02"
 
-	if err := ba.AddSpending(period, periodStart, 2.50, 50.00); err != nil {
+	if err := ba.AddSpending(GlobalBudgetScope, period, periodStart, 2.50, 50.00); err != nil {
 		t.Fatalf("AddSpending #1: %v", err)
 	}
-	if err := ba.AddSpending(period, periodStart, 3.25, 50.00); err != nil {
+	if err := ba.AddSpending(GlobalBudgetScope, period, periodStart, 3.25, 50.00); err != nil {
 		t.Fatalf("AddSpending #2: %v", err)
 	}
-	if err := ba.AddSpending(period, periodStart, 1.00, 50.00); err != nil {
+	if err := ba.AddSpending(GlobalBudgetScope, period, periodStart, 1.00, 50.00); err != nil {
 		t.Fatalf("AddSpending #3: %v", err)
 	}
 
-	amount, limit, err := ba.GetBudget(period, periodStart)
+	amount, limit, err := ba.GetBudget(GlobalBudgetScope, period, periodStart)
 	if err != nil {
 		t.Fatalf("GetBudget: %v", err)
 	}
@@ -349,6 +397,35 @@ func TestBudgetAdapter_MultipleAddSpendingAccumulate(t *testing.T) {
 	}
 }
 
+func TestBudgetAdapter_ScopesAreIndependent(t *testing.T) {
+	s := openTestStore(t)
+	ba := NewBudgetAdapter(s)
+
+	if err := ba.AddSpending(GlobalBudgetScope, "daily", "2025-06-01", 4.00, 100.00); err != nil {
+		t.Fatalf("AddSpending global: %v", err)
+	}
+	if err := ba.AddSpending("project:search", "daily", "2025-06-01", 1.50, 10.00); err != nil {
+		t.Fatalf("AddSpending project: %v", err)
+	}
+
+	amount, _, _ := ba.GetBudget("project:search", "daily", "2025-06-01")
+	if amount != 1.50 {
+		t.Errorf("project amount = %f, want 1.50", amount)
+	}
+	amount, _, _ = ba.GetBudget(GlobalBudgetScope, "daily", "2025-06-01")
+	if amount != 4.00 {
+		t.Errorf("global amount = %f, want 4.00", amount)
+	}
+
+	budgets, err := s.ListBudgets("daily", "2025-06-01")
+	if err != nil {
+		t.Fatalf("ListBudgets: %v", err)
+	}
+	if len(budgets) != 2 || budgets[0].Scope != GlobalBudgetScope || budgets[1].Scope != "project:search" {
+		t.Errorf("ListBudgets = %+v", budgets)
+	}
+}
+
 // ---------------------------------------------------------------------------
 // PIIAdapter
 // ---------------------------------------------------------------------------
@@ -429,3 +506,104 @@ func TestPIIAdapter_LogPIIMultipleEntries(t *testing.T) {
 		t.Errorf("entries[1].PIIType = %q, want %q", entries[1].PIIType, "phone")
 	}
 }
+
+// ---------------------------------------------------------------------------
+// AlertAdapter
+// ---------------------------------------------------------------------------
+
+func TestAlertAdapter_RecordAlertDeduplicates(t *testing.T) {
+	s := openTestStore(t)
+	aa := NewAlertAdapter(s)
+
+	a := alert.Alert{
+		Kind:      alert.KindBudgetThreshold,
+		Severity:  alert.SeverityWarning,
+		Title:     "daily budget at 90%",
+		Fields:    map[string]interface{}{"period": "daily"},
+		DedupKey:  "budget/global/daily/2026-01-01/0.9",
+		Timestamp: time.Now(),
+	}
+	for i, want := range []bool{true, false} {
+		inserted, err := aa.RecordAlert(a)
+		if err != nil {
+			t.Fatalf("RecordAlert #%d: %v", i+1, err)
+		}
+		if inserted != want {
+			t.Errorf("RecordAlert #%d inserted = %v, want %v", i+1, inserted, want)
+		}
+	}
+
+	// Alerts without a dedup key are always recorded.
+	for i := 0; i < 2; i++ {
+		if inserted, err := aa.RecordAlert(alert.Alert{Kind: alert.KindPIIBlocked, Title: "blocked", Timestamp: time.Now()}); err != nil || !inserted {
+			t.Fatalf("RecordAlert without dedup key: inserted=%v err=%v", inserted, err)
+		}
+	}
+
+	alerts, err := s.ListAlerts(10, 0, "")
+	if err != nil {
+		t.Fatalf("ListAlerts: %v", err)
+	}
+	if len(alerts) != 3 {
+		t.Fatalf("len(alerts) = %d, want 3", len(alerts))
+	}
+
+	budget, err := s.ListAlerts(10, 0, alert.KindBudgetThreshold)
+	if err != nil {
+		t.Fatalf("ListAlerts(kind): %v", err)
+	}
+	if len(budget) != 1 || budget[0].Fields != `{"period":"daily"}` {
+		t.Errorf("ListAlerts(kind) = %+v", budget)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// VirtualKeyAdapter
+// ---------------------------------------------------------------------------
+
+func TestVirtualKeyAdapter_Lifecycle(t *testing.T) {
+	s := openTestStore(t)
+	adapter := NewVirtualKeyAdapter(s)
+
+	plaintext, k, err := s.CreateVirtualKey("ci", "platform", []string{"proxy"}, []string{"claude-*"}, "batch", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("CreateVirtualKey: %v", err)
+	}
+
+	rec, err := adapter.LookupVirtualKey(auth.HashKey(plaintext))
+	if err != nil {
+		t.Fatalf("LookupVirtualKey: %v", err)
+	}
+	if rec == nil || rec.ID != k.ID || rec.Owner != "platform" {
+		t.Fatalf("LookupVirtualKey = %+v", rec)
+	}
+	if len(rec.AllowedModels) != 1 || rec.AllowedModels[0] != "claude-*" {
+		t.Errorf("AllowedModels = %v", rec.AllowedModels)
+	}
+	if rec.Priority != "batch" {
+		t.Errorf("Priority = %q, want batch", rec.Priority)
+	}
+	if rec.ExpiresAt.IsZero() || rec.Revoked() {
+		t.Errorf("unexpected expiry/revocation state: %+v", rec)
+	}
+
+	if rec, err := adapter.LookupVirtualKey(auth.HashKey("tkm_unknown")); err != nil || rec != nil {
+		t.Errorf("unknown key: got %+v, %v; want nil, nil", rec, err)
+	}
+
+	if err := s.RevokeVirtualKey(k.ID); err != nil {
+		t.Fatalf("RevokeVirtualKey: %v", err)
+	}
+	rec, _ = adapter.LookupVirtualKey(auth.HashKey(plaintext))
+	if !rec.Revoked() {
+		t.Error("key should be revoked")
+	}
+	if err := s.RevokeVirtualKey("vk_missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("revoke missing key: got %v, want sql.ErrNoRows", err)
+	}
+
+	keys, err := s.ListVirtualKeys()
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("ListVirtualKeys = %d keys, %v", len(keys), err)
+	}
+}
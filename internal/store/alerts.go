@@ -0,0 +1,79 @@
+package store
+
+import (
+	"fmt"
+)
+
+// Alert is a delivered operational alert. Fields holds the alert's
+// structured details as a JSON object string.
+type Alert struct {
+	ID        int64
+	Timestamp string
+	Kind      string
+	Severity  string
+	Title     string
+	Message   string
+	Fields    string
+	DedupKey  string
+}
+
+// InsertAlert records an alert. When the alert has a non-empty DedupKey that
+// was already recorded, nothing is inserted and inserted is false.
+func (s *Store) InsertAlert(a *Alert) (inserted bool, err error) {
+	result, err := s.writer.Exec(`
+		INSERT OR IGNORE INTO alerts (timestamp, kind, severity, title, message, fields, dedup_key)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		a.Timestamp, a.Kind, a.Severity, a.Title, a.Message, a.Fields, a.DedupKey,
+	)
+	if err != nil {
+		return false, fmt.Errorf("store: insert alert: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("store: insert alert rows affected: %w", err)
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return false, fmt.Errorf("store: insert alert last insert id: %w", err)
+	}
+	a.ID = id
+	return true, nil
+}
+
+// ListAlerts returns a page of alerts ordered newest first. A non-empty kind
+// restricts the result to alerts of that kind.
+func (s *Store) ListAlerts(limit, offset int, kind string) ([]*Alert, error) {
+	rows, err := s.reader.Query(`
+		SELECT id, timestamp, kind, severity, title, message, fields, dedup_key
+		FROM alerts
+		WHERE ? = '' OR kind = ?
+		ORDER BY timestamp DESC, id DESC
+		LIMIT ? OFFSET ?`, kind, kind, limit, offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("store: list alerts: %w", err)
+	}
+	defer rows.Close()
+
+	var results []*Alert
+	for rows.Next() {
+		a := &Alert{}
+		if err := rows.Scan(
+			&a.ID, &a.Timestamp, &a.Kind, &a.Severity,
+			&a.Title, &a.Message, &a.Fields, &a.DedupKey,
+		); err != nil {
+			return nil, fmt.Errorf("store: scan alert row: %w", err)
+		}
+		results = append(results, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: list alerts iteration: %w", err)
+	}
+	return results, nil
+}
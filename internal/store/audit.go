@@ -0,0 +1,209 @@
+package store
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// AuditEvent is a security-relevant event. Detail holds the event's
+// structured details as a JSON object string.
+//
+// Events form a hash chain: PrevHash is the Hash of the event before it,
+// and Hash covers PrevHash and every other field except ID (see
+// AuditEventHash), so editing, removing, or reordering stored events breaks
+// the chain.
+type AuditEvent struct {
+	ID         int64
+	Timestamp  string
+	Kind       string
+	Actor      string
+	Action     string
+	RemoteAddr string
+	Detail     string
+	PrevHash   string
+	Hash       string
+}
+
+// AuditEventHash returns the chain hash of e: the hex SHA-256 of the JSON
+// array [prev_hash, timestamp, kind, actor, action, remote_addr, detail].
+func AuditEventHash(e *AuditEvent) string {
+	data, _ := json.Marshal([]string{e.PrevHash, e.Timestamp, e.Kind, e.Actor, e.Action, e.RemoteAddr, e.Detail})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// InsertAuditEvent appends an audit event to the chain, filling in its ID,
+// PrevHash, and Hash.
+func (s *Store) InsertAuditEvent(e *AuditEvent) error {
+	tx, err := s.writer.Begin()
+	if err != nil {
+		return fmt.Errorf("store: insert audit event begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	var prev string
+	err = tx.QueryRow(`SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1`).Scan(&prev)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("store: insert audit event chain head: %w", err)
+	}
+	e.PrevHash = prev
+	e.Hash = AuditEventHash(e)
+
+	result, err := tx.Exec(`
+		INSERT INTO audit_events (timestamp, kind, actor, action, remote_addr, detail, prev_hash, hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Timestamp, e.Kind, e.Actor, e.Action, e.RemoteAddr, e.Detail, e.PrevHash, e.Hash,
+	)
+	if err != nil {
+		return fmt.Errorf("store: insert audit event: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("store: insert audit event last insert id: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("store: insert audit event commit: %w", err)
+	}
+	e.ID = id
+	return nil
+}
+
+const auditEventColumns = `id, timestamp, kind, actor, action, remote_addr, detail, prev_hash, hash`
+
+// scanAuditEvents reads audit event rows selected with auditEventColumns.
+func scanAuditEvents(rows *sql.Rows) ([]*AuditEvent, error) {
+	defer rows.Close()
+
+	var results []*AuditEvent
+	for rows.Next() {
+		e := &AuditEvent{}
+		if err := rows.Scan(&e.ID, &e.Timestamp, &e.Kind, &e.Actor, &e.Action, &e.RemoteAddr, &e.Detail, &e.PrevHash, &e.Hash); err != nil {
+			return nil, fmt.Errorf("store: scan audit event row: %w", err)
+		}
+		results = append(results, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: audit events iteration: %w", err)
+	}
+	return results, nil
+}
+
+// ListAuditEvents returns a page of audit events ordered newest first. A
+// non-empty kind restricts the result to events of that kind.
+func (s *Store) ListAuditEvents(limit, offset int, kind string) ([]*AuditEvent, error) {
+	rows, err := s.reader.Query(`
+		SELECT `+auditEventColumns+`
+		FROM audit_events
+		WHERE ? = '' OR kind = ?
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?`, kind, kind, limit, offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("store: list audit events: %w", err)
+	}
+	return scanAuditEvents(rows)
+}
+
+// ExportAuditEvents returns up to limit events with an ID greater than
+// afterID, oldest first, so that a collector can page through the log by
+// passing the last ID it received.
+func (s *Store) ExportAuditEvents(afterID int64, limit int) ([]*AuditEvent, error) {
+	rows, err := s.reader.Query(`
+		SELECT `+auditEventColumns+`
+		FROM audit_events
+		WHERE id > ?
+		ORDER BY id ASC
+		LIMIT ?`, afterID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("store: export audit events: %w", err)
+	}
+	return scanAuditEvents(rows)
+}
+
+// FindAuditHash returns the ID of the event with the given hash. Returns
+// sql.ErrNoRows (wrapped) if no event has it.
+func (s *Store) FindAuditHash(hash string) (int64, error) {
+	var id int64
+	err := s.reader.QueryRow(`SELECT id FROM audit_events WHERE hash = ? AND hash != ''`, hash).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("store: find audit hash: %w", err)
+	}
+	return id, nil
+}
+
+// AuditChainReport is the result of VerifyAuditChain.
+type AuditChainReport struct {
+	Events int // events checked
+	// Unchained counts events at the start of the log that were written
+	// before the hash chain was introduced and so cannot be verified. The
+	// migration that introduced it records which events those are.
+	Unchained int
+	Head      string // hash of the newest event
+	BrokenID  int64  // first event that fails verification; 0 if intact
+	Problem   string // why BrokenID failed
+}
+
+// Intact reports whether every chained event verified.
+func (r *AuditChainReport) Intact() bool {
+	return r.BrokenID == 0
+}
+
+// VerifyAuditChain walks the audit log from oldest to newest, recomputing
+// each event's hash and checking that it links to the event before it. It
+// stops at the first event that fails. Only events written before the hash
+// chain was introduced, whose IDs the migration that added it recorded, may
+// be unhashed.
+func (s *Store) VerifyAuditChain() (*AuditChainReport, error) {
+	// A missing row means no event may be unhashed.
+	var unchainedThrough int64
+	err := s.reader.QueryRow(`SELECT COALESCE(MIN(unchained_through), 0) FROM audit_chain_start`).Scan(&unchainedThrough)
+	if err != nil {
+		return nil, fmt.Errorf("store: read audit chain start: %w", err)
+	}
+
+	rows, err := s.reader.Query(`SELECT ` + auditEventColumns + ` FROM audit_events ORDER BY id ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("store: verify audit chain: %w", err)
+	}
+	defer rows.Close()
+
+	report := &AuditChainReport{}
+	chained := false
+	for rows.Next() {
+		e := &AuditEvent{}
+		if err := rows.Scan(&e.ID, &e.Timestamp, &e.Kind, &e.Actor, &e.Action, &e.RemoteAddr, &e.Detail, &e.PrevHash, &e.Hash); err != nil {
+			return nil, fmt.Errorf("store: scan audit event row: %w", err)
+		}
+		report.Events++
+
+		switch {
+		case e.Hash == "" && !chained && e.ID <= unchainedThrough:
+			report.Unchained++
+			continue
+		case e.Hash == "":
+			report.Problem = "event has no hash; it was written or blanked after the hash chain began"
+		case e.PrevHash != report.Head:
+			report.Problem = "prev_hash does not match the preceding event; events were removed, inserted, or reordered"
+		case AuditEventHash(e) != e.Hash:
+			report.Problem = "event contents do not match its hash; the event was modified"
+		}
+		if report.Problem != "" {
+			report.BrokenID = e.ID
+			return report, nil
+		}
+		chained = true
+		report.Head = e.Hash
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: verify audit chain iteration: %w", err)
+	}
+	return report, nil
+}
@@ -6,9 +6,14 @@ import (
 	"time"
 )
 
+// GlobalBudgetScope is the scope of the proxy-wide budget. Scoped budgets
+// use "<kind>:<value>", e.g. "project:search" or "model:gpt-4o".
+const GlobalBudgetScope = "global"
+
 // Budget represents a spending-limit record for a given billing period.
 type Budget struct {
 	ID          int64
+	Scope       string
 	Period      string
 	PeriodStart string
 	AmountUSD   float64
@@ -16,25 +21,62 @@ type Budget struct {
 	LastUpdated string
 }
 
-// GetBudget retrieves the budget for a specific period and period_start.
-// Returns sql.ErrNoRows (wrapped) if no matching budget exists.
+// GetBudget retrieves the global budget for a specific period and
+// period_start. Returns sql.ErrNoRows (wrapped) if no matching budget exists.
 func (s *Store) GetBudget(period, periodStart string) (*Budget, error) {
+	return s.GetScopedBudget(GlobalBudgetScope, period, periodStart)
+}
+
+// GetScopedBudget retrieves the budget for a scope, period, and
+// period_start. Returns sql.ErrNoRows (wrapped) if no matching budget exists.
+func (s *Store) GetScopedBudget(scope, period, periodStart string) (*Budget, error) {
 	b := &Budget{}
 	err := s.reader.QueryRow(`
-		SELECT id, period, period_start, amount_usd, limit_usd, last_updated
+		SELECT id, scope, period, period_start, amount_usd, limit_usd, last_updated
 		FROM budgets
-		WHERE period = ? AND period_start = ?`, period, periodStart,
+		WHERE scope = ? AND period = ? AND period_start = ?`, scope, period, periodStart,
 	).Scan(
-		&b.ID, &b.Period, &b.PeriodStart,
+		&b.ID, &b.Scope, &b.Period, &b.PeriodStart,
 		&b.AmountUSD, &b.LimitUSD, &b.LastUpdated,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("store: get budget (%s, %s): %w", period, periodStart, err)
+		return nil, fmt.Errorf("store: get budget (%s, %s, %s): %w", scope, period, periodStart, err)
 	}
 	return b, nil
 }
 
-// AddSpending increments the spending amount for a budget period. If
+// ListBudgets returns every scope's budget record for a period and
+// period_start, ordered by scope.
+func (s *Store) ListBudgets(period, periodStart string) ([]*Budget, error) {
+	rows, err := s.reader.Query(`
+		SELECT id, scope, period, period_start, amount_usd, limit_usd, last_updated
+		FROM budgets
+		WHERE period = ? AND period_start = ?
+		ORDER BY scope`, period, periodStart,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("store: list budgets: %w", err)
+	}
+	defer rows.Close()
+
+	var budgets []*Budget
+	for rows.Next() {
+		b := &Budget{}
+		if err := rows.Scan(
+			&b.ID, &b.Scope, &b.Period, &b.PeriodStart,
+			&b.AmountUSD, &b.LimitUSD, &b.LastUpdated,
+		); err != nil {
+			return nil, fmt.Errorf("store: scan budget row: %w", err)
+		}
+		budgets = append(budgets, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: list budgets iteration: %w", err)
+	}
+	return budgets, nil
+}
+
+// AddSpending increments the spending amount for a global budget period. If
 // the budget row does not exist yet it is created with the given limit.
 // If it already exists the amount is incremented and the limit is
 // updated to the provided value.
@@ -44,13 +86,28 @@ func (s *Store) GetBudget(period, periodStart string) (*Budget, error) {
 // We use an UPDATE-first approach: try to update an existing row, and
 // only insert when no matching row is found.
 func (s *Store) AddSpending(period, periodStart string, amount, limit float64) error {
+	return s.AddScopedSpending(GlobalBudgetScope, period, periodStart, amount, limit)
+}
+
+// AddScopedSpending is AddSpending for a specific budget scope.
+func (s *Store) AddScopedSpending(scope, period, periodStart string, amount, limit float64) error {
+	return addSpending(s.writer, scope, period, periodStart, amount, limit)
+}
+
+// execer is satisfied by *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// addSpending implements AddScopedSpending on a connection or transaction.
+func addSpending(db execer, scope, period, periodStart string, amount, limit float64) error {
 	now := time.Now().UTC().Format(time.RFC3339)
 
-	result, err := s.writer.Exec(`
+	result, err := db.Exec(`
 		UPDATE budgets
 		SET amount_usd = amount_usd + ?, limit_usd = ?, last_updated = ?
-		WHERE period = ? AND period_start = ?`,
-		amount, limit, now, period, periodStart,
+		WHERE scope = ? AND period = ? AND period_start = ?`,
+		amount, limit, now, scope, period, periodStart,
 	)
 	if err != nil {
 		return fmt.Errorf("store: update budget spending: %w", err)
@@ -62,10 +119,10 @@ func (s *Store) AddSpending(period, periodStart string, amount, limit float64) e
 	}
 
 	if n == 0 {
-		_, err = s.writer.Exec(`
-			INSERT INTO budgets (period, period_start, amount_usd, limit_usd, last_updated)
-			VALUES (?, ?, ?, ?, ?)`,
-			period, periodStart, amount, limit, now,
+		_, err = db.Exec(`
+			INSERT INTO budgets (scope, period, period_start, amount_usd, limit_usd, last_updated)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			scope, period, periodStart, amount, limit, now,
 		)
 		if err != nil {
 			return fmt.Errorf("store: insert budget: %w", err)
@@ -75,15 +132,22 @@ func (s *Store) AddSpending(period, periodStart string, amount, limit float64) e
 	return nil
 }
 
-// ResetBudget resets the spending amount to zero for the given period
+// ResetBudget resets the global spending amount to zero for the given period
 // and period_start. Returns sql.ErrNoRows (wrapped) if no matching
 // budget exists.
 func (s *Store) ResetBudget(period, periodStart string) error {
+	return s.ResetScopedBudget(GlobalBudgetScope, period, periodStart)
+}
+
+// ResetScopedBudget resets the spending amount of scope to zero for the
+// given period and period_start. Returns sql.ErrNoRows (wrapped) if no
+// matching budget exists.
+func (s *Store) ResetScopedBudget(scope, period, periodStart string) error {
 	now := time.Now().UTC().Format(time.RFC3339)
 	result, err := s.writer.Exec(`
 		UPDATE budgets SET amount_usd = 0.0, last_updated = ?
-		WHERE period = ? AND period_start = ?`,
-		now, period, periodStart,
+		WHERE scope = ? AND period = ? AND period_start = ?`,
+		now, scope, period, periodStart,
 	)
 	if err != nil {
 		return fmt.Errorf("store: reset budget: %w", err)
@@ -94,7 +158,117 @@ func (s *Store) ResetBudget(period, periodStart string) error {
 		return fmt.Errorf("store: reset budget rows affected: %w", err)
 	}
 	if n == 0 {
-		return fmt.Errorf("store: reset budget (%s, %s): %w", period, periodStart, sql.ErrNoRows)
+		return fmt.Errorf("store: reset budget (%s, %s, %s): %w", scope, period, periodStart, sql.ErrNoRows)
+	}
+	return nil
+}
+
+// BudgetLine identifies one budget a reservation is held against. Committed
+// is filled in by ReserveBudget with the recorded spending plus every other
+// live reservation on that budget, and Spent with the recorded spending.
+type BudgetLine struct {
+	Scope       string
+	Period      string
+	PeriodStart string
+	Limit       float64
+	Committed   float64
+	Spent       float64
+}
+
+// ReserveBudget atomically checks that amount fits within every line's limit
+// and, if it does, holds amount against each line for requestID until
+// expiresAt. A line is full when its committed amount has reached the limit
+// or amount would take it past the limit. When any line is full nothing is
+// reserved and ok is false. Committed and Spent are set on every line either
+// way.
+//
+// The check and the insert run in one transaction on the single writer
+// connection, so concurrent requests cannot all pass against the same
+// remaining headroom.
+func (s *Store) ReserveBudget(requestID string, amount float64, lines []BudgetLine, expiresAt time.Time) (ok bool, err error) {
+	tx, err := s.writer.Begin()
+	if err != nil {
+		return false, fmt.Errorf("store: begin budget reservation: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UnixMilli()
+	if _, err := tx.Exec(`DELETE FROM budget_reservations WHERE expires_at <= ?`, now); err != nil {
+		return false, fmt.Errorf("store: purge expired reservations: %w", err)
+	}
+
+	ok = true
+	for i := range lines {
+		l := &lines[i]
+		var spent, reserved float64
+		if err := tx.QueryRow(`
+			SELECT COALESCE(SUM(amount_usd), 0) FROM budgets
+			WHERE scope = ? AND period = ? AND period_start = ?`,
+			l.Scope, l.Period, l.PeriodStart,
+		).Scan(&spent); err != nil {
+			return false, fmt.Errorf("store: read budget spending: %w", err)
+		}
+		if err := tx.QueryRow(`
+			SELECT COALESCE(SUM(amount_usd), 0) FROM budget_reservations
+			WHERE scope = ? AND period = ? AND period_start = ?`,
+			l.Scope, l.Period, l.PeriodStart,
+		).Scan(&reserved); err != nil {
+			return false, fmt.Errorf("store: read budget reservations: %w", err)
+		}
+		l.Spent = spent
+		l.Committed = spent + reserved
+		if l.Committed >= l.Limit || l.Committed+amount > l.Limit {
+			ok = false
+		}
+	}
+	if !ok || amount <= 0 {
+		return ok, nil
+	}
+
+	for _, l := range lines {
+		if _, err := tx.Exec(`
+			INSERT INTO budget_reservations (request_id, scope, period, period_start, amount_usd, expires_at)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			requestID, l.Scope, l.Period, l.PeriodStart, amount, expiresAt.UnixMilli(),
+		); err != nil {
+			return false, fmt.Errorf("store: insert budget reservation: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("store: commit budget reservation: %w", err)
+	}
+	return true, nil
+}
+
+// SettleBudgetReservation replaces the reservations held for requestID with
+// the actual amount spent, recorded against every line, in one transaction.
+func (s *Store) SettleBudgetReservation(requestID string, amount float64, lines []BudgetLine) error {
+	tx, err := s.writer.Begin()
+	if err != nil {
+		return fmt.Errorf("store: begin budget settlement: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM budget_reservations WHERE request_id = ?`, requestID); err != nil {
+		return fmt.Errorf("store: delete budget reservations: %w", err)
+	}
+	if amount > 0 {
+		for _, l := range lines {
+			if err := addSpending(tx, l.Scope, l.Period, l.PeriodStart, amount, l.Limit); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("store: commit budget settlement: %w", err)
+	}
+	return nil
+}
+
+// ReleaseBudgetReservation drops any reservations still held for requestID.
+func (s *Store) ReleaseBudgetReservation(requestID string) error {
+	if _, err := s.writer.Exec(`DELETE FROM budget_reservations WHERE request_id = ?`, requestID); err != nil {
+		return fmt.Errorf("store: release budget reservation: %w", err)
 	}
 	return nil
 }
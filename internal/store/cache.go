@@ -34,18 +34,25 @@ func (s *Store) GetCache(key string) (*CacheEntry, error) {
 	if err != nil {
 		return nil, fmt.Errorf("store: get cache %s: %w", key, err)
 	}
+	if c.ResponseBody, err = s.decrypt(c.ResponseBody, cacheBodyAAD(c.Key)); err != nil {
+		return nil, err
+	}
 	return c, nil
 }
 
 // SetCache inserts or replaces a cache entry. If an entry with the same
 // key already exists it is overwritten.
 func (s *Store) SetCache(c *CacheEntry) error {
-	_, err := s.writer.Exec(`
+	body, err := s.encrypt(c.ResponseBody, cacheBodyAAD(c.Key))
+	if err != nil {
+		return err
+	}
+	_, err = s.writer.Exec(`
 		INSERT OR REPLACE INTO cache (
 			key, model, request_hash, response_body, tokens_saved,
 			created_at, expires_at, hit_count, last_hit
 		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		c.Key, c.Model, c.RequestHash, c.ResponseBody, c.TokensSaved,
+		c.Key, c.Model, c.RequestHash, body, c.TokensSaved,
 		c.CreatedAt, c.ExpiresAt, c.HitCount, c.LastHit,
 	)
 	if err != nil {
@@ -69,6 +76,20 @@ func (s *Store) DeleteExpired() (int64, error) {
 	return n, nil
 }
 
+// PurgeCache removes every cache entry. It returns the number of rows
+// deleted.
+func (s *Store) PurgeCache() (int64, error) {
+	result, err := s.writer.Exec("DELETE FROM cache")
+	if err != nil {
+		return 0, fmt.Errorf("store: purge cache: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("store: purge cache rows affected: %w", err)
+	}
+	return n, nil
+}
+
 // IncrementHitCount atomically increments the hit_count for a cache
 // entry and updates last_hit to the current time.
 func (s *Store) IncrementHitCount(key string) error {
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// EmbeddingEntry is one cached embedding vector stored in the
+// embedding_cache table. Embedding holds the provider's JSON value.
+type EmbeddingEntry struct {
+	Key       string
+	Model     string
+	Embedding []byte
+	Tokens    int64
+	CreatedAt string
+	ExpiresAt string
+}
+
+// GetEmbedding retrieves a cached embedding by its key.
+// Returns sql.ErrNoRows (wrapped) if the key does not exist.
+func (s *Store) GetEmbedding(key string) (*EmbeddingEntry, error) {
+	e := &EmbeddingEntry{}
+	err := s.reader.QueryRow(`
+		SELECT key, model, embedding, tokens, created_at, expires_at
+		FROM embedding_cache WHERE key = ?`, key,
+	).Scan(&e.Key, &e.Model, &e.Embedding, &e.Tokens, &e.CreatedAt, &e.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("store: get embedding %s: %w", key, err)
+	}
+	if e.Embedding, err = s.decrypt(e.Embedding, embeddingAAD(e.Key)); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// SetEmbedding inserts or replaces a cached embedding.
+func (s *Store) SetEmbedding(e *EmbeddingEntry) error {
+	embedding, err := s.encrypt(e.Embedding, embeddingAAD(e.Key))
+	if err != nil {
+		return err
+	}
+	_, err = s.writer.Exec(`
+		INSERT OR REPLACE INTO embedding_cache (
+			key, model, embedding, tokens, created_at, expires_at
+		) VALUES (?, ?, ?, ?, ?, ?)`,
+		e.Key, e.Model, embedding, e.Tokens, e.CreatedAt, e.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("store: set embedding: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredEmbeddings removes all cached embeddings whose expires_at
+// timestamp is in the past. It returns the number of rows deleted.
+func (s *Store) DeleteExpiredEmbeddings() (int64, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	result, err := s.writer.Exec("DELETE FROM embedding_cache WHERE expires_at < ?", now)
+	if err != nil {
+		return 0, fmt.Errorf("store: delete expired embeddings: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("store: delete expired embeddings rows affected: %w", err)
+	}
+	return n, nil
+}
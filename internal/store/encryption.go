@@ -0,0 +1,507 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stored bodies are encrypted with AES-256-GCM under a data key. Data keys
+// are generated by the store, wrapped (encrypted) with a master key that
+// never touches the database, and kept in the data_keys table. An encrypted
+// value has the form "enc:v1:<data key id>:<base64 nonce||ciphertext>";
+// values without the prefix are plaintext written before encryption was
+// enabled and are returned as is.
+const sealedPrefix = "enc:v1:"
+
+// MasterKeySize is the length in bytes of the master key.
+const MasterKeySize = 32
+
+// ErrEncryptionDisabled is returned when reading an encrypted value from a
+// store that has no master key.
+var ErrEncryptionDisabled = errors.New("store: value is encrypted but encryption is not enabled")
+
+// ParseMasterKey decodes a master key given as 64 hex characters or as
+// base64 (standard or URL alphabet, padded or not) of 32 bytes.
+func ParseMasterKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == hex.EncodedLen(MasterKeySize) {
+		if key, err := hex.DecodeString(s); err == nil {
+			return key, nil
+		}
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if key, err := enc.DecodeString(s); err == nil && len(key) == MasterKeySize {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("store: master key must be %d bytes, hex or base64 encoded", MasterKeySize)
+}
+
+// GenerateMasterKey returns a new random master key, base64 encoded.
+func GenerateMasterKey() (string, error) {
+	key := make([]byte, MasterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("store: generate master key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// bodyCipher holds the unwrapped data keys of an encrypting store.
+type bodyCipher struct {
+	master cipher.AEAD
+
+	mu     sync.RWMutex
+	keys   map[int64]cipher.AEAD
+	active int64
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with aead, binding it to aad.
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// open decrypts a value produced by seal.
+func open(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	n := aead.NonceSize()
+	return aead.Open(nil, sealed[:n], sealed[n:], aad)
+}
+
+// wrapAAD binds a wrapped data key to its ID.
+func wrapAAD(id int64) []byte {
+	return []byte("data_key:" + strconv.FormatInt(id, 10))
+}
+
+// EnableEncryption turns on encryption of request, response, and cache
+// bodies using masterKey to wrap data keys. A data key is created on first
+// use. It fails if the stored data keys were wrapped with a different
+// master key.
+func (s *Store) EnableEncryption(masterKey []byte) error {
+	master, err := newAEAD(masterKey)
+	if err != nil {
+		return fmt.Errorf("store: master key: %w", err)
+	}
+	c := &bodyCipher{master: master}
+	if err := s.loadDataKeys(c); err != nil {
+		return err
+	}
+	if c.active == 0 {
+		if _, err := s.createDataKey(c); err != nil {
+			return err
+		}
+	}
+	s.enc = c
+	return nil
+}
+
+// EncryptionEnabled reports whether the store encrypts bodies.
+func (s *Store) EncryptionEnabled() bool {
+	return s.enc != nil
+}
+
+// DataKeys returns the ID of the data key used for new writes and the
+// number of data keys loaded. Both are zero when encryption is disabled.
+func (s *Store) DataKeys() (active int64, count int) {
+	if s.enc == nil {
+		return 0, 0
+	}
+	s.enc.mu.RLock()
+	defer s.enc.mu.RUnlock()
+	return s.enc.active, len(s.enc.keys)
+}
+
+// loadDataKeys unwraps every stored data key into c. The newest key is
+// used for new writes.
+func (s *Store) loadDataKeys(c *bodyCipher) error {
+	rows, err := s.reader.Query(`SELECT id, wrapped_key FROM data_keys ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("store: load data keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make(map[int64]cipher.AEAD)
+	var active int64
+	for rows.Next() {
+		var (
+			id      int64
+			wrapped []byte
+		)
+		if err := rows.Scan(&id, &wrapped); err != nil {
+			return fmt.Errorf("store: scan data key: %w", err)
+		}
+		raw, err := open(c.master, wrapped, wrapAAD(id))
+		if err != nil {
+			return fmt.Errorf("store: unwrap data key %d (wrong master key?): %w", id, err)
+		}
+		aead, err := newAEAD(raw)
+		if err != nil {
+			return fmt.Errorf("store: data key %d: %w", id, err)
+		}
+		keys[id] = aead
+		active = id
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("store: load data keys iteration: %w", err)
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.active = active
+	c.mu.Unlock()
+	return nil
+}
+
+// createDataKey generates a data key, stores it wrapped with c's master
+// key, and makes it the active key.
+func (s *Store) createDataKey(c *bodyCipher) (int64, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return 0, fmt.Errorf("store: generate data key: %w", err)
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return 0, fmt.Errorf("store: data key: %w", err)
+	}
+
+	tx, err := s.writer.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("store: create data key begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`INSERT INTO data_keys (wrapped_key, created_at) VALUES (x'', ?)`,
+		time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, fmt.Errorf("store: create data key: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("store: create data key last insert id: %w", err)
+	}
+	wrapped, err := seal(c.master, raw, wrapAAD(id))
+	if err != nil {
+		return 0, fmt.Errorf("store: wrap data key: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE data_keys SET wrapped_key = ? WHERE id = ?`, wrapped, id); err != nil {
+		return 0, fmt.Errorf("store: create data key: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("store: create data key commit: %w", err)
+	}
+
+	c.mu.Lock()
+	if c.keys == nil {
+		c.keys = make(map[int64]cipher.AEAD)
+	}
+	c.keys[id] = aead
+	c.active = id
+	c.mu.Unlock()
+	return id, nil
+}
+
+// encrypt seals plaintext under the active data key. aad names the column
+// and row the value belongs to, so sealed values cannot be moved between
+// rows. Empty values and stores without encryption pass through unchanged.
+func (s *Store) encrypt(plaintext []byte, aad string) ([]byte, error) {
+	if s.enc == nil || len(plaintext) == 0 {
+		return plaintext, nil
+	}
+	s.enc.mu.RLock()
+	id, aead := s.enc.active, s.enc.keys[s.enc.active]
+	s.enc.mu.RUnlock()
+
+	sealed, err := seal(aead, plaintext, []byte(aad))
+	if err != nil {
+		return nil, fmt.Errorf("store: encrypt %s: %w", aad, err)
+	}
+	out := make([]byte, 0, len(sealedPrefix)+24+base64.StdEncoding.EncodedLen(len(sealed)))
+	out = append(out, sealedPrefix...)
+	out = strconv.AppendInt(out, id, 10)
+	out = append(out, ':')
+	out = base64.StdEncoding.AppendEncode(out, sealed)
+	return out, nil
+}
+
+// decrypt reverses encrypt. Plaintext values are returned unchanged.
+func (s *Store) decrypt(value []byte, aad string) ([]byte, error) {
+	rest, ok := strings.CutPrefix(string(value), sealedPrefix)
+	if !ok {
+		return value, nil
+	}
+	if s.enc == nil {
+		return nil, ErrEncryptionDisabled
+	}
+	idStr, payload, ok := strings.Cut(rest, ":")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if !ok || err != nil {
+		return nil, fmt.Errorf("store: decrypt %s: malformed value", aad)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("store: decrypt %s: %w", aad, err)
+	}
+
+	s.enc.mu.RLock()
+	aead, ok := s.enc.keys[id]
+	s.enc.mu.RUnlock()
+	if !ok {
+		// Another process (such as "tokenman encryption rotate") may
+		// have added the key since it was loaded.
+		if err := s.loadDataKeys(s.enc); err != nil {
+			return nil, err
+		}
+		s.enc.mu.RLock()
+		aead, ok = s.enc.keys[id]
+		s.enc.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("store: decrypt %s: unknown data key %d", aad, id)
+		}
+	}
+
+	plaintext, err := open(aead, sealed, []byte(aad))
+	if err != nil {
+		return nil, fmt.Errorf("store: decrypt %s: %w", aad, err)
+	}
+	return plaintext, nil
+}
+
+// encryptString and decryptString adapt encrypt and decrypt to TEXT columns.
+func (s *Store) encryptString(plaintext, aad string) (string, error) {
+	out, err := s.encrypt([]byte(plaintext), aad)
+	return string(out), err
+}
+
+func (s *Store) decryptString(value, aad string) (string, error) {
+	out, err := s.decrypt([]byte(value), aad)
+	return string(out), err
+}
+
+// requestBodyAAD, cacheBodyAAD, and embeddingAAD bind sealed values to
+// their row.
+func requestBodyAAD(column, id string) string { return "requests." + column + ":" + id }
+func cacheBodyAAD(key string) string          { return "cache.response_body:" + key }
+func embeddingAAD(key string) string          { return "embedding_cache.embedding:" + key }
+
+// RotateDataKey creates a new data key and re-encrypts every stored
+// request, response, and cache body and cached embedding with it, including plaintext bodies
+// written before encryption was enabled. It returns the new key's ID and
+// the number of rows rewritten. Older data keys are kept so that rows
+// written concurrently by a running daemon stay readable.
+func (s *Store) RotateDataKey() (int64, int64, error) {
+	if s.enc == nil {
+		return 0, 0, errors.New("store: rotate data key: encryption is not enabled")
+	}
+	id, err := s.createDataKey(s.enc)
+	if err != nil {
+		return 0, 0, err
+	}
+	n, err := s.reencryptRequests()
+	if err != nil {
+		return id, n, err
+	}
+	m, err := s.reencryptKeyed("cache", "response_body", cacheBodyAAD)
+	if err != nil {
+		return id, n + m, err
+	}
+	e, err := s.reencryptKeyed("embedding_cache", "embedding", embeddingAAD)
+	return id, n + m + e, err
+}
+
+// reencryptBatch is the number of rows rewritten per query.
+const reencryptBatch = 500
+
+func (s *Store) reencryptRequests() (int64, error) {
+	var (
+		total  int64
+		lastID string
+	)
+	for {
+		rows, err := s.reader.Query(`
+			SELECT id, COALESCE(request_body, ''), COALESCE(response_body, '') FROM requests
+			WHERE id > ? AND (request_body != '' OR response_body != '')
+			ORDER BY id LIMIT ?`, lastID, reencryptBatch)
+		if err != nil {
+			return total, fmt.Errorf("store: re-encrypt requests: %w", err)
+		}
+		type row struct{ id, req, resp string }
+		var batch []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.req, &r.resp); err != nil {
+				rows.Close()
+				return total, fmt.Errorf("store: re-encrypt requests scan: %w", err)
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return total, fmt.Errorf("store: re-encrypt requests iteration: %w", err)
+		}
+		if len(batch) == 0 {
+			return total, nil
+		}
+
+		for _, r := range batch {
+			req, err := s.reseal(r.req, requestBodyAAD("request_body", r.id))
+			if err != nil {
+				return total, err
+			}
+			resp, err := s.reseal(r.resp, requestBodyAAD("response_body", r.id))
+			if err != nil {
+				return total, err
+			}
+			if _, err := s.writer.Exec(`UPDATE requests SET request_body = ?, response_body = ? WHERE id = ?`, req, resp, r.id); err != nil {
+				return total, fmt.Errorf("store: re-encrypt request %s: %w", r.id, err)
+			}
+			total++
+		}
+		lastID = batch[len(batch)-1].id
+	}
+}
+
+// reencryptKeyed re-encrypts column of every row in a table keyed by a
+// TEXT "key" column. table and column are constants, never user input.
+func (s *Store) reencryptKeyed(table, column string, aad func(key string) string) (int64, error) {
+	var (
+		total   int64
+		lastKey string
+	)
+	for {
+		rows, err := s.reader.Query(`
+			SELECT key, `+column+` FROM `+table+`
+			WHERE key > ? ORDER BY key LIMIT ?`, lastKey, reencryptBatch)
+		if err != nil {
+			return total, fmt.Errorf("store: re-encrypt %s: %w", table, err)
+		}
+		type row struct {
+			key  string
+			body []byte
+		}
+		var batch []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.key, &r.body); err != nil {
+				rows.Close()
+				return total, fmt.Errorf("store: re-encrypt %s scan: %w", table, err)
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return total, fmt.Errorf("store: re-encrypt %s iteration: %w", table, err)
+		}
+		if len(batch) == 0 {
+			return total, nil
+		}
+
+		for _, r := range batch {
+			plain, err := s.decrypt(r.body, aad(r.key))
+			if err != nil {
+				return total, err
+			}
+			body, err := s.encrypt(plain, aad(r.key))
+			if err != nil {
+				return total, err
+			}
+			if _, err := s.writer.Exec(`UPDATE `+table+` SET `+column+` = ? WHERE key = ?`, body, r.key); err != nil {
+				return total, fmt.Errorf("store: re-encrypt %s entry: %w", table, err)
+			}
+			total++
+		}
+		lastKey = batch[len(batch)-1].key
+	}
+}
+
+// reseal decrypts a TEXT value with whatever key sealed it and encrypts it
+// again under the active key.
+func (s *Store) reseal(value, aad string) (string, error) {
+	plain, err := s.decryptString(value, aad)
+	if err != nil {
+		return "", err
+	}
+	return s.encryptString(plain, aad)
+}
+
+// RewrapDataKeys re-wraps every data key with newMasterKey. Bodies are not
+// touched. After it returns, the store must be reopened with the new master
+// key.
+func (s *Store) RewrapDataKeys(newMasterKey []byte) (int, error) {
+	if s.enc == nil {
+		return 0, errors.New("store: rewrap data keys: encryption is not enabled")
+	}
+	next, err := newAEAD(newMasterKey)
+	if err != nil {
+		return 0, fmt.Errorf("store: new master key: %w", err)
+	}
+
+	tx, err := s.writer.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("store: rewrap data keys begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id, wrapped_key FROM data_keys ORDER BY id`)
+	if err != nil {
+		return 0, fmt.Errorf("store: rewrap data keys: %w", err)
+	}
+	type wrappedKey struct {
+		id      int64
+		wrapped []byte
+	}
+	var keys []wrappedKey
+	for rows.Next() {
+		var k wrappedKey
+		if err := rows.Scan(&k.id, &k.wrapped); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("store: rewrap data keys scan: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("store: rewrap data keys iteration: %w", err)
+	}
+
+	for _, k := range keys {
+		raw, err := open(s.enc.master, k.wrapped, wrapAAD(k.id))
+		if err != nil {
+			return 0, fmt.Errorf("store: unwrap data key %d: %w", k.id, err)
+		}
+		wrapped, err := seal(next, raw, wrapAAD(k.id))
+		if err != nil {
+			return 0, fmt.Errorf("store: wrap data key %d: %w", k.id, err)
+		}
+		if _, err := tx.Exec(`UPDATE data_keys SET wrapped_key = ? WHERE id = ?`, wrapped, k.id); err != nil {
+			return 0, fmt.Errorf("store: rewrap data key %d: %w", k.id, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("store: rewrap data keys commit: %w", err)
+	}
+
+	s.enc.master = next
+	return len(keys), nil
+}
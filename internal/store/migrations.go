@@ -28,6 +28,153 @@ ALTER TABLE requests ADD COLUMN response_body TEXT DEFAULT '';`,
 		Version: 3,
 		SQL:     `ALTER TABLE requests ADD COLUMN project TEXT DEFAULT '';`,
 	},
+	{
+		Version: 4,
+		SQL: `CREATE TABLE IF NOT EXISTS virtual_keys (
+    id             TEXT PRIMARY KEY,
+    name           TEXT NOT NULL,
+    owner          TEXT NOT NULL DEFAULT '',
+    key_prefix     TEXT NOT NULL,
+    key_hash       TEXT NOT NULL UNIQUE,
+    scopes         TEXT NOT NULL DEFAULT '',
+    allowed_models TEXT NOT NULL DEFAULT '',
+    created_at     TEXT NOT NULL,
+    expires_at     TEXT NOT NULL DEFAULT '',
+    revoked_at     TEXT NOT NULL DEFAULT '',
+    last_used_at   TEXT NOT NULL DEFAULT ''
+);
+ALTER TABLE requests ADD COLUMN key_id TEXT DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_requests_key_id ON requests(key_id);`,
+	},
+	{
+		Version: 5,
+		SQL: `ALTER TABLE budgets ADD COLUMN scope TEXT NOT NULL DEFAULT 'global';
+CREATE INDEX IF NOT EXISTS idx_budgets_scope ON budgets(scope, period, period_start);`,
+	},
+	{
+		Version: 6,
+		SQL: `CREATE TABLE IF NOT EXISTS budget_reservations (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    request_id   TEXT NOT NULL,
+    scope        TEXT NOT NULL,
+    period       TEXT NOT NULL,
+    period_start TEXT NOT NULL,
+    amount_usd   REAL NOT NULL,
+    expires_at   INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_budget_reservations_request ON budget_reservations(request_id);
+CREATE INDEX IF NOT EXISTS idx_budget_reservations_scope ON budget_reservations(scope, period, period_start);`,
+	},
+	{
+		Version: 7,
+		SQL: `CREATE TABLE IF NOT EXISTS alerts (
+    id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    timestamp TEXT NOT NULL,
+    kind      TEXT NOT NULL,
+    severity  TEXT NOT NULL,
+    title     TEXT NOT NULL,
+    message   TEXT NOT NULL DEFAULT '',
+    fields    TEXT NOT NULL DEFAULT '',
+    dedup_key TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_alerts_timestamp ON alerts(timestamp);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_alerts_dedup_key ON alerts(dedup_key) WHERE dedup_key != '';`,
+	},
+	{
+		Version: 8,
+		SQL:     `ALTER TABLE virtual_keys ADD COLUMN priority TEXT NOT NULL DEFAULT '';`,
+	},
+	{
+		Version: 9,
+		SQL: `CREATE TABLE IF NOT EXISTS policy_decisions (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    timestamp  TEXT NOT NULL,
+    request_id TEXT NOT NULL,
+    rule       TEXT NOT NULL,
+    action     TEXT NOT NULL,
+    detail     TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_policy_decisions_request ON policy_decisions(request_id);
+CREATE INDEX IF NOT EXISTS idx_policy_decisions_timestamp ON policy_decisions(timestamp);`,
+	},
+	{
+		Version: 10,
+		SQL: `CREATE TABLE IF NOT EXISTS audit_events (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    timestamp   TEXT NOT NULL,
+    kind        TEXT NOT NULL,
+    actor       TEXT NOT NULL DEFAULT '',
+    action      TEXT NOT NULL DEFAULT '',
+    remote_addr TEXT NOT NULL DEFAULT '',
+    detail      TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_audit_events_timestamp ON audit_events(timestamp);
+CREATE INDEX IF NOT EXISTS idx_audit_events_kind ON audit_events(kind);`,
+	},
+	{
+		Version: 11,
+		SQL: `ALTER TABLE audit_events ADD COLUMN prev_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE audit_events ADD COLUMN hash TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_audit_events_hash ON audit_events(hash);
+CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+CREATE TABLE IF NOT EXISTS audit_chain_start (
+    unchained_through INTEGER NOT NULL
+);
+INSERT INTO audit_chain_start (unchained_through) SELECT COALESCE(MAX(id), 0) FROM audit_events;
+CREATE TRIGGER IF NOT EXISTS audit_chain_start_no_update BEFORE UPDATE ON audit_chain_start
+BEGIN
+    SELECT RAISE(ABORT, 'audit_chain_start is append-only');
+END;
+CREATE TRIGGER IF NOT EXISTS audit_chain_start_no_delete BEFORE DELETE ON audit_chain_start
+BEGIN
+    SELECT RAISE(ABORT, 'audit_chain_start is append-only');
+END;`,
+	},
+	{
+		Version: 12,
+		SQL: `CREATE TABLE IF NOT EXISTS data_keys (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    wrapped_key BLOB NOT NULL,
+    created_at  TEXT NOT NULL
+);`,
+	},
+	{
+		Version: 13,
+		SQL: `ALTER TABLE requests ADD COLUMN provider_key TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_requests_provider_key ON requests(provider_key);`,
+	},
+	{
+		Version: 14,
+		SQL:     `ALTER TABLE requests ADD COLUMN requested_model TEXT NOT NULL DEFAULT '';`,
+	},
+	{
+		Version: 15,
+		SQL: `ALTER TABLE requests ADD COLUMN parent_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE requests ADD COLUMN cascade TEXT NOT NULL DEFAULT '';
+ALTER TABLE requests ADD COLUMN cascade_outcome TEXT NOT NULL DEFAULT '';
+ALTER TABLE requests ADD COLUMN cascade_savings_usd REAL NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS idx_requests_parent_id ON requests(parent_id);
+CREATE INDEX IF NOT EXISTS idx_requests_cascade ON requests(cascade);`,
+	},
+	{
+		Version: 16,
+		SQL: `CREATE TABLE IF NOT EXISTS embedding_cache (
+    key        TEXT PRIMARY KEY,
+    model      TEXT NOT NULL,
+    embedding  BLOB NOT NULL,
+    tokens     INTEGER NOT NULL DEFAULT 0,
+    created_at TEXT NOT NULL,
+    expires_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_embedding_cache_expires ON embedding_cache(expires_at);`,
+	},
 }
 
 // Migrate brings the database up to the latest schema version.
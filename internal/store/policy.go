@@ -0,0 +1,65 @@
+package store
+
+import (
+	"fmt"
+)
+
+// PolicyDecision is a recorded outcome of a policy rule for one request.
+type PolicyDecision struct {
+	ID        int64
+	Timestamp string
+	RequestID string
+	Rule      string
+	Action    string
+	Detail    string
+}
+
+// InsertPolicyDecision records a policy decision. The ID field is ignored
+// and auto-assigned by the database.
+func (s *Store) InsertPolicyDecision(d *PolicyDecision) error {
+	result, err := s.writer.Exec(`
+		INSERT INTO policy_decisions (timestamp, request_id, rule, action, detail)
+		VALUES (?, ?, ?, ?, ?)`,
+		d.Timestamp, d.RequestID, d.Rule, d.Action, d.Detail,
+	)
+	if err != nil {
+		return fmt.Errorf("store: insert policy decision: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("store: insert policy decision last insert id: %w", err)
+	}
+	d.ID = id
+	return nil
+}
+
+// ListPolicyDecisions returns a page of policy decisions ordered newest
+// first. A non-empty requestID restricts the result to that request.
+func (s *Store) ListPolicyDecisions(limit, offset int, requestID string) ([]*PolicyDecision, error) {
+	rows, err := s.reader.Query(`
+		SELECT id, timestamp, request_id, rule, action, detail
+		FROM policy_decisions
+		WHERE ? = '' OR request_id = ?
+		ORDER BY timestamp DESC, id DESC
+		LIMIT ? OFFSET ?`, requestID, requestID, limit, offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("store: list policy decisions: %w", err)
+	}
+	defer rows.Close()
+
+	var results []*PolicyDecision
+	for rows.Next() {
+		d := &PolicyDecision{}
+		if err := rows.Scan(&d.ID, &d.Timestamp, &d.RequestID, &d.Rule, &d.Action, &d.Detail); err != nil {
+			return nil, fmt.Errorf("store: scan policy decision row: %w", err)
+		}
+		results = append(results, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: list policy decisions iteration: %w", err)
+	}
+	return results, nil
+}
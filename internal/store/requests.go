@@ -29,6 +29,24 @@ type Request struct {
 	RequestBody  string
 	ResponseBody string
 	Project      string
+	KeyID        string
+	ProviderKey  string // ID of the upstream API key that served the request
+	// RequestedModel is the model the client asked for when an alias or
+	// routing rule rewrote it to Model; empty when they are the same.
+	RequestedModel string
+	// ParentID links an attempt that was not returned to the client, such
+	// as a cheap cascade answer that was escalated, to the request it was
+	// made for.
+	ParentID string
+	// Cascade names the model cascade that handled the request, and
+	// CascadeOutcome records what it decided: "accepted" or "escalated" on
+	// the request itself, and why the answer was rejected on an attempt.
+	Cascade        string
+	CascadeOutcome string
+	// CascadeSavingsUSD is what the cascade saved against sending the
+	// request straight to the strong model: positive when the cheap answer
+	// was accepted, and the cost of the wasted attempt when it escalated.
+	CascadeSavingsUSD float64
 }
 
 // RequestStats holds aggregate statistics for a range of requests.
@@ -50,20 +68,32 @@ func (s *Store) InsertRequest(r *Request) error {
 	if r.CacheHit {
 		cacheHitInt = 1
 	}
+	reqBody, err := s.encryptString(r.RequestBody, requestBodyAAD("request_body", r.ID))
+	if err != nil {
+		return err
+	}
+	respBody, err := s.encryptString(r.ResponseBody, requestBodyAAD("response_body", r.ID))
+	if err != nil {
+		return err
+	}
 
-	_, err := s.writer.Exec(`
+	_, err = s.writer.Exec(`
 		INSERT INTO requests (
 			id, timestamp, method, path, format, model,
 			tokens_in, tokens_out, tokens_cached, tokens_saved,
 			cost_usd, savings_usd, latency_ms, status_code,
 			cache_hit, request_type, provider, error_message,
-			request_body, response_body, project
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			request_body, response_body, project, key_id, provider_key,
+			requested_model, parent_id, cascade, cascade_outcome,
+			cascade_savings_usd
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		r.ID, r.Timestamp, r.Method, r.Path, r.Format, r.Model,
 		r.TokensIn, r.TokensOut, r.TokensCached, r.TokensSaved,
 		r.CostUSD, r.SavingsUSD, r.LatencyMs, r.StatusCode,
 		cacheHitInt, r.RequestType, r.Provider, r.ErrorMessage,
-		r.RequestBody, r.ResponseBody, r.Project,
+		reqBody, respBody, r.Project, r.KeyID, r.ProviderKey,
+		r.RequestedModel, r.ParentID, r.Cascade, r.CascadeOutcome,
+		r.CascadeSavingsUSD,
 	)
 	if err != nil {
 		return fmt.Errorf("store: insert request: %w", err)
@@ -82,18 +112,28 @@ func (s *Store) GetRequest(id string) (*Request, error) {
 		       tokens_in, tokens_out, tokens_cached, tokens_saved,
 		       cost_usd, savings_usd, latency_ms, status_code,
 		       cache_hit, request_type, provider, error_message,
-		       request_body, response_body, project
+		       request_body, response_body, project, key_id, provider_key,
+		       requested_model, parent_id, cascade, cascade_outcome,
+		       cascade_savings_usd
 		FROM requests WHERE id = ?`, id,
 	).Scan(
 		&r.ID, &r.Timestamp, &r.Method, &r.Path, &r.Format, &r.Model,
 		&r.TokensIn, &r.TokensOut, &r.TokensCached, &r.TokensSaved,
 		&r.CostUSD, &r.SavingsUSD, &r.LatencyMs, &r.StatusCode,
 		&cacheHitInt, &r.RequestType, &r.Provider, &r.ErrorMessage,
-		&r.RequestBody, &r.ResponseBody, &r.Project,
+		&r.RequestBody, &r.ResponseBody, &r.Project, &r.KeyID, &r.ProviderKey,
+		&r.RequestedModel, &r.ParentID, &r.Cascade, &r.CascadeOutcome,
+		&r.CascadeSavingsUSD,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("store: get request %s: %w", id, err)
 	}
+	if r.RequestBody, err = s.decryptString(r.RequestBody, requestBodyAAD("request_body", r.ID)); err != nil {
+		return nil, err
+	}
+	if r.ResponseBody, err = s.decryptString(r.ResponseBody, requestBodyAAD("response_body", r.ID)); err != nil {
+		return nil, err
+	}
 
 	r.CacheHit = cacheHitInt != 0
 	return r, nil
@@ -105,7 +145,9 @@ func (s *Store) ListRequests(limit, offset int) ([]*Request, error) {
 		SELECT id, timestamp, method, path, format, model,
 		       tokens_in, tokens_out, tokens_cached, tokens_saved,
 		       cost_usd, savings_usd, latency_ms, status_code,
-		       cache_hit, request_type, provider, error_message
+		       cache_hit, request_type, provider, error_message, key_id, provider_key,
+		       requested_model, parent_id, cascade, cascade_outcome,
+		       cascade_savings_usd
 		FROM requests
 		ORDER BY timestamp DESC
 		LIMIT ? OFFSET ?`, limit, offset,
@@ -123,7 +165,9 @@ func (s *Store) ListRequests(limit, offset int) ([]*Request, error) {
 			&r.ID, &r.Timestamp, &r.Method, &r.Path, &r.Format, &r.Model,
 			&r.TokensIn, &r.TokensOut, &r.TokensCached, &r.TokensSaved,
 			&r.CostUSD, &r.SavingsUSD, &r.LatencyMs, &r.StatusCode,
-			&cacheHitInt, &r.RequestType, &r.Provider, &r.ErrorMessage,
+			&cacheHitInt, &r.RequestType, &r.Provider, &r.ErrorMessage, &r.KeyID, &r.ProviderKey,
+			&r.RequestedModel, &r.ParentID, &r.Cascade, &r.CascadeOutcome,
+			&r.CascadeSavingsUSD,
 		); err != nil {
 			return nil, fmt.Errorf("store: scan request row: %w", err)
 		}
@@ -137,6 +181,29 @@ func (s *Store) ListRequests(limit, offset int) ([]*Request, error) {
 	return results, nil
 }
 
+// RequestAttempts returns the IDs of the attempts linked to a request
+// through their parent ID, oldest first.
+func (s *Store) RequestAttempts(parentID string) ([]string, error) {
+	rows, err := s.reader.Query(`SELECT id FROM requests WHERE parent_id = ? ORDER BY timestamp, rowid`, parentID)
+	if err != nil {
+		return nil, fmt.Errorf("store: list request attempts: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("store: scan request attempt: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: list request attempts iteration: %w", err)
+	}
+	return ids, nil
+}
+
 // GetRequestStats computes aggregate statistics for all requests whose
 // timestamp is >= since.
 func (s *Store) GetRequestStats(since time.Time) (*RequestStats, error) {
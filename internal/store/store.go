@@ -20,6 +20,10 @@ type Store struct {
 	reader    *sql.DB
 	path      string
 	closeOnce sync.Once
+
+	// enc is set by EnableEncryption; nil means bodies are stored in
+	// plaintext.
+	enc *bodyCipher
 }
 
 // Open creates a new Store backed by the SQLite database at path.
@@ -129,7 +133,7 @@ func (s *Store) Ping() error {
 }
 
 // Prune removes data older than retentionDays from requests, cache,
-// and pii_log tables. It returns the total number of rows deleted.
+// pii_log, alerts, and policy_decisions tables. It returns the total number of rows deleted.
 func (s *Store) Prune(retentionDays int) (int64, error) {
 	cutoff := time.Now().UTC().AddDate(0, 0, -retentionDays).Format(time.RFC3339)
 	var total int64
@@ -138,6 +142,8 @@ func (s *Store) Prune(retentionDays int) (int64, error) {
 		"DELETE FROM requests WHERE timestamp < ?",
 		"DELETE FROM cache WHERE expires_at < ?",
 		"DELETE FROM pii_log WHERE timestamp < ?",
+		"DELETE FROM alerts WHERE timestamp < ?",
+		"DELETE FROM policy_decisions WHERE timestamp < ?",
 	}
 
 	for _, q := range queries {
@@ -1,10 +1,15 @@
 package store
 
 import (
+	"errors"
+	"fmt"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/allaspectsdev/tokenman/internal/audit"
 )
 
 func openCoreTestStore(t *testing.T) *Store {
@@ -328,3 +333,322 @@ func TestInsertRequest_CacheHitFlag(t *testing.T) {
 		t.Error("CacheHit: got false, want true")
 	}
 }
+
+func TestInsertRequest_CascadeAttemptsLinkedToParent(t *testing.T) {
+	st := openCoreTestStore(t)
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	reqs := []*Request{
+		{ID: "attempt", Timestamp: now, Model: "cheap", StatusCode: 200, RequestType: "cascade_attempt", ParentID: "final", Cascade: "classify", CascadeOutcome: "rejected by json"},
+		{ID: "final", Timestamp: now, Model: "strong", StatusCode: 200, RequestType: "normal", Cascade: "classify", CascadeOutcome: "escalated", CascadeSavingsUSD: -0.002},
+	}
+	for _, r := range reqs {
+		if err := st.InsertRequest(r); err != nil {
+			t.Fatalf("InsertRequest(%s): %v", r.ID, err)
+		}
+	}
+
+	got, err := st.GetRequest("final")
+	if err != nil {
+		t.Fatalf("GetRequest: %v", err)
+	}
+	if got.Cascade != "classify" || got.CascadeOutcome != "escalated" || got.CascadeSavingsUSD != -0.002 || got.ParentID != "" {
+		t.Errorf("final request cascade fields = %q, %q, %v, parent %q", got.Cascade, got.CascadeOutcome, got.CascadeSavingsUSD, got.ParentID)
+	}
+
+	attempts, err := st.RequestAttempts("final")
+	if err != nil {
+		t.Fatalf("RequestAttempts: %v", err)
+	}
+	if len(attempts) != 1 || attempts[0] != "attempt" {
+		t.Errorf("RequestAttempts = %v; want [attempt]", attempts)
+	}
+}
+
+func TestReserveBudget_ConcurrentRequestsCannotOvershoot(t *testing.T) {
+	st := openCoreTestStore(t)
+	lines := func() []BudgetLine {
+		return []BudgetLine{{Scope: GlobalBudgetScope, Period: "daily", PeriodStart: "2025-06-01T00:00:00Z", Limit: 10.0}}
+	}
+
+	// Twenty concurrent requests each reserve a $1 worst case against a $10
+	// budget; exactly ten may proceed.
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		accepted []string
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ok, err := st.ReserveBudget(fmt.Sprintf("req-%d", i), 1.0, lines(), time.Now().Add(time.Minute))
+			if err != nil {
+				t.Errorf("ReserveBudget: %v", err)
+				return
+			}
+			if ok {
+				mu.Lock()
+				accepted = append(accepted, fmt.Sprintf("req-%d", i))
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if len(accepted) != 10 {
+		t.Fatalf("accepted %d reservations, want 10", len(accepted))
+	}
+
+	// Settling at a lower actual cost frees the difference.
+	if err := st.SettleBudgetReservation(accepted[0], 0.25, lines()); err != nil {
+		t.Fatalf("SettleBudgetReservation: %v", err)
+	}
+	l := lines()
+	ok, err := st.ReserveBudget("req-late", 0.5, l, time.Now().Add(time.Minute))
+	if err != nil || !ok {
+		t.Fatalf("ReserveBudget after settle: ok=%v err=%v", ok, err)
+	}
+	if l[0].Committed != 9.25 || l[0].Spent != 0.25 {
+		t.Errorf("Committed/Spent = %v/%v, want 9.25/0.25", l[0].Committed, l[0].Spent)
+	}
+	b, err := st.GetBudget("daily", "2025-06-01T00:00:00Z")
+	if err != nil || b.AmountUSD != 0.25 {
+		t.Errorf("recorded spending = %+v, %v; want 0.25", b, err)
+	}
+}
+
+func TestReserveBudget_ReleaseAndExpiry(t *testing.T) {
+	st := openCoreTestStore(t)
+	lines := []BudgetLine{{Scope: "project:a", Period: "hourly", PeriodStart: "2025-06-01T10:00:00Z", Limit: 1.0}}
+
+	if ok, _ := st.ReserveBudget("held", 1.0, lines, time.Now().Add(time.Minute)); !ok {
+		t.Fatal("first reservation should fit")
+	}
+	if ok, _ := st.ReserveBudget("blocked", 0.1, lines, time.Now().Add(time.Minute)); ok {
+		t.Fatal("budget is fully reserved; second reservation should fail")
+	}
+
+	if err := st.ReleaseBudgetReservation("held"); err != nil {
+		t.Fatalf("ReleaseBudgetReservation: %v", err)
+	}
+	// An expired reservation no longer counts against the budget.
+	if ok, _ := st.ReserveBudget("stale", 1.0, lines, time.Now().Add(-time.Second)); !ok {
+		t.Fatal("reservation after release should fit")
+	}
+	if ok, _ := st.ReserveBudget("fresh", 1.0, lines, time.Now().Add(time.Minute)); !ok {
+		t.Error("expired reservation still counted against the budget")
+	}
+}
+
+func TestAuditChain(t *testing.T) {
+	st := openCoreTestStore(t)
+	rec := NewAuditAdapter(st)
+
+	for i, kind := range []string{audit.KindKeyCreated, audit.KindPIIDetected, audit.KindCachePurged, audit.KindKeyRevoked} {
+		if err := rec.Record(audit.Event{Kind: kind, Actor: "admin", Action: "test", Detail: map[string]interface{}{"n": i}}); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+
+	report, err := st.VerifyAuditChain()
+	if err != nil {
+		t.Fatalf("VerifyAuditChain: %v", err)
+	}
+	if !report.Intact() || report.Events != 4 || report.Head == "" {
+		t.Fatalf("fresh chain report = %+v", report)
+	}
+	if _, err := st.FindAuditHash(report.Head); err != nil {
+		t.Errorf("FindAuditHash(head): %v", err)
+	}
+
+	// The table rejects updates and deletes outright.
+	if _, err := st.Writer().Exec(`UPDATE audit_events SET actor = 'x' WHERE id = 2`); err == nil {
+		t.Fatal("update of audit_events succeeded")
+	}
+	if _, err := st.Writer().Exec(`DELETE FROM audit_events WHERE id = 2`); err == nil {
+		t.Fatal("delete from audit_events succeeded")
+	}
+
+	// Someone with raw database access can drop the triggers, but the
+	// chain still exposes the edit.
+	for _, trig := range []string{"audit_events_no_update", "audit_events_no_delete"} {
+		if _, err := st.Writer().Exec(`DROP TRIGGER ` + trig); err != nil {
+			t.Fatalf("drop trigger: %v", err)
+		}
+	}
+	if _, err := st.Writer().Exec(`UPDATE audit_events SET actor = 'someone-else' WHERE id = 2`); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	report, err = st.VerifyAuditChain()
+	if err != nil {
+		t.Fatalf("VerifyAuditChain: %v", err)
+	}
+	if report.BrokenID != 2 {
+		t.Errorf("modified event: report = %+v, want broken at 2", report)
+	}
+
+	// Removing the edited event breaks the link from its successor.
+	if _, err := st.Writer().Exec(`DELETE FROM audit_events WHERE id = 2`); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	report, err = st.VerifyAuditChain()
+	if err != nil {
+		t.Fatalf("VerifyAuditChain: %v", err)
+	}
+	if report.BrokenID != 3 {
+		t.Errorf("deleted event: report = %+v, want broken at 3", report)
+	}
+
+	events, err := st.ExportAuditEvents(1, 10)
+	if err != nil {
+		t.Fatalf("ExportAuditEvents: %v", err)
+	}
+	if len(events) != 2 || events[0].ID != 3 || events[0].PrevHash == "" {
+		t.Errorf("export after id 1 = %+v", events)
+	}
+}
+
+func TestAuditChain_BlankedHashesAreTampering(t *testing.T) {
+	st := openCoreTestStore(t)
+	rec := NewAuditAdapter(st)
+	for _, kind := range []string{audit.KindKeyCreated, audit.KindKeyRevoked} {
+		if err := rec.Record(audit.Event{Kind: kind, Actor: "admin", Action: "test"}); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+
+	// Blanking every hash must not pass as a log that predates the chain.
+	if _, err := st.Writer().Exec(`DROP TRIGGER audit_events_no_update`); err != nil {
+		t.Fatalf("drop trigger: %v", err)
+	}
+	if _, err := st.Writer().Exec(`UPDATE audit_events SET prev_hash = '', hash = ''`); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	report, err := st.VerifyAuditChain()
+	if err != nil {
+		t.Fatalf("VerifyAuditChain: %v", err)
+	}
+	if report.Intact() || report.BrokenID != 1 || report.Unchained != 0 {
+		t.Errorf("blanked chain report = %+v; want broken at 1", report)
+	}
+}
+
+func TestEncryption_RoundTripAndRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	st, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer st.Close()
+
+	// A body written before encryption is enabled stays readable and is
+	// encrypted by the first rotation.
+	legacy := &Request{ID: "legacy", Timestamp: time.Now().UTC().Format(time.RFC3339), RequestBody: `{"legacy":true}`}
+	if err := st.InsertRequest(legacy); err != nil {
+		t.Fatalf("InsertRequest: %v", err)
+	}
+
+	masterKey, err := GenerateMasterKey()
+	if err != nil {
+		t.Fatalf("GenerateMasterKey: %v", err)
+	}
+	master, err := ParseMasterKey(masterKey)
+	if err != nil {
+		t.Fatalf("ParseMasterKey: %v", err)
+	}
+	if err := st.EnableEncryption(master); err != nil {
+		t.Fatalf("EnableEncryption: %v", err)
+	}
+
+	req := &Request{
+		ID:           "secret",
+		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+		RequestBody:  `{"prompt":"my ssn is 123-45-6789"}`,
+		ResponseBody: `{"content":"noted"}`,
+	}
+	if err := st.InsertRequest(req); err != nil {
+		t.Fatalf("InsertRequest: %v", err)
+	}
+	if err := st.SetCache(&CacheEntry{Key: "k1", ResponseBody: []byte(`{"cached":true}`), CreatedAt: "now", ExpiresAt: "2999-01-01T00:00:00Z"}); err != nil {
+		t.Fatalf("SetCache: %v", err)
+	}
+
+	var rawReq string
+	var rawCache []byte
+	st.Reader().QueryRow(`SELECT request_body FROM requests WHERE id = 'secret'`).Scan(&rawReq)
+	st.Reader().QueryRow(`SELECT response_body FROM cache WHERE key = 'k1'`).Scan(&rawCache)
+	if !strings.HasPrefix(rawReq, sealedPrefix) || strings.Contains(rawReq, "123-45-6789") {
+		t.Errorf("stored request body is not encrypted: %q", rawReq)
+	}
+	if !strings.HasPrefix(string(rawCache), sealedPrefix) {
+		t.Errorf("stored cache body is not encrypted: %q", rawCache)
+	}
+
+	got, err := st.GetRequest("secret")
+	if err != nil {
+		t.Fatalf("GetRequest: %v", err)
+	}
+	if got.RequestBody != req.RequestBody || got.ResponseBody != req.ResponseBody {
+		t.Errorf("GetRequest bodies = %q, %q", got.RequestBody, got.ResponseBody)
+	}
+	entry, err := NewCacheAdapter(st).GetCache("k1")
+	if err != nil {
+		t.Fatalf("GetCache: %v", err)
+	}
+	if string(entry.Body) != `{"cached":true}` {
+		t.Errorf("GetCache body = %q", entry.Body)
+	}
+
+	// A sealed value copied to another row does not decrypt.
+	if _, err := st.Writer().Exec(`UPDATE requests SET request_body = ? WHERE id = 'legacy'`, rawReq); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if _, err := st.GetRequest("legacy"); err == nil {
+		t.Error("GetRequest with a value moved from another row: want error")
+	}
+	if _, err := st.Writer().Exec(`UPDATE requests SET request_body = ? WHERE id = 'legacy'`, legacy.RequestBody); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+
+	id, rows, err := st.RotateDataKey()
+	if err != nil {
+		t.Fatalf("RotateDataKey: %v", err)
+	}
+	if rows != 3 {
+		t.Errorf("RotateDataKey rewrote %d rows, want 3", rows)
+	}
+	st.Reader().QueryRow(`SELECT request_body FROM requests WHERE id = 'legacy'`).Scan(&rawReq)
+	if !strings.HasPrefix(rawReq, fmt.Sprintf("%s%d:", sealedPrefix, id)) {
+		t.Errorf("legacy body after rotation = %q, want sealed with key %d", rawReq, id)
+	}
+
+	// Re-wrap under a new master key; the old one no longer opens the store.
+	nextKey, _ := GenerateMasterKey()
+	next, _ := ParseMasterKey(nextKey)
+	if _, err := st.RewrapDataKeys(next); err != nil {
+		t.Fatalf("RewrapDataKeys: %v", err)
+	}
+	st.Close()
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	if _, err := reopened.GetRequest("secret"); !errors.Is(err, ErrEncryptionDisabled) {
+		t.Errorf("GetRequest without master key: err = %v, want ErrEncryptionDisabled", err)
+	}
+	if err := reopened.EnableEncryption(master); err == nil {
+		t.Error("EnableEncryption with the old master key: want error")
+	}
+	if err := reopened.EnableEncryption(next); err != nil {
+		t.Fatalf("EnableEncryption with the new master key: %v", err)
+	}
+	got, err = reopened.GetRequest("legacy")
+	if err != nil {
+		t.Fatalf("GetRequest after rewrap: %v", err)
+	}
+	if got.RequestBody != legacy.RequestBody {
+		t.Errorf("legacy body = %q", got.RequestBody)
+	}
+}
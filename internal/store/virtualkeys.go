@@ -0,0 +1,189 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/allaspectsdev/tokenman/internal/auth"
+)
+
+// VirtualKey is a tokenman-issued API key. Only the hash of the key is
+// stored; KeyPrefix keeps the first few characters for display. Scopes and
+// AllowedModels are stored comma-separated. Timestamps are RFC 3339 strings,
+// empty when unset.
+type VirtualKey struct {
+	ID            string
+	Name          string
+	Owner         string
+	KeyPrefix     string
+	KeyHash       string
+	Scopes        []string
+	AllowedModels []string
+	Priority      string
+	CreatedAt     string
+	ExpiresAt     string
+	RevokedAt     string
+	LastUsedAt    string
+}
+
+// virtualKeyColumns is the column list shared by virtual key queries.
+const virtualKeyColumns = `id, name, owner, key_prefix, key_hash, scopes, allowed_models,
+	priority, created_at, expires_at, revoked_at, last_used_at`
+
+// CreateVirtualKey generates a new virtual key, stores its hash, and returns
+// the plaintext key alongside the stored record. The plaintext cannot be
+// recovered later. A zero expiresAt creates a key that never expires; an
+// empty priority leaves the scheduler priority to the project or header.
+func (s *Store) CreateVirtualKey(name, owner string, scopes, allowedModels []string, priority string, expiresAt time.Time) (string, *VirtualKey, error) {
+	id, err := auth.GenerateKeyID()
+	if err != nil {
+		return "", nil, err
+	}
+	plaintext, err := auth.GenerateKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	k := &VirtualKey{
+		ID:            id,
+		Name:          name,
+		Owner:         owner,
+		KeyPrefix:     auth.DisplayPrefix(plaintext),
+		KeyHash:       auth.HashKey(plaintext),
+		Scopes:        scopes,
+		AllowedModels: allowedModels,
+		Priority:      priority,
+		CreatedAt:     time.Now().UTC().Format(time.RFC3339),
+	}
+	if !expiresAt.IsZero() {
+		k.ExpiresAt = expiresAt.UTC().Format(time.RFC3339)
+	}
+
+	if err := s.InsertVirtualKey(k); err != nil {
+		return "", nil, err
+	}
+	return plaintext, k, nil
+}
+
+// InsertVirtualKey stores a new virtual key.
+func (s *Store) InsertVirtualKey(k *VirtualKey) error {
+	_, err := s.writer.Exec(`
+		INSERT INTO virtual_keys (`+virtualKeyColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		k.ID, k.Name, k.Owner, k.KeyPrefix, k.KeyHash,
+		strings.Join(k.Scopes, ","), strings.Join(k.AllowedModels, ","),
+		k.Priority, k.CreatedAt, k.ExpiresAt, k.RevokedAt, k.LastUsedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("store: insert virtual key: %w", err)
+	}
+	return nil
+}
+
+// GetVirtualKeyByHash retrieves a virtual key by the hash of its secret.
+// Returns sql.ErrNoRows (wrapped) if no key matches.
+func (s *Store) GetVirtualKeyByHash(hash string) (*VirtualKey, error) {
+	row := s.reader.QueryRow(`SELECT `+virtualKeyColumns+` FROM virtual_keys WHERE key_hash = ?`, hash)
+	k, err := scanVirtualKey(row)
+	if err != nil {
+		return nil, fmt.Errorf("store: get virtual key: %w", err)
+	}
+	return k, nil
+}
+
+// GetVirtualKey retrieves a virtual key by its ID. Returns sql.ErrNoRows
+// (wrapped) if no key has the ID.
+func (s *Store) GetVirtualKey(id string) (*VirtualKey, error) {
+	row := s.reader.QueryRow(`SELECT `+virtualKeyColumns+` FROM virtual_keys WHERE id = ?`, id)
+	k, err := scanVirtualKey(row)
+	if err != nil {
+		return nil, fmt.Errorf("store: get virtual key %s: %w", id, err)
+	}
+	return k, nil
+}
+
+// ListVirtualKeys returns all virtual keys, newest first, including revoked
+// and expired ones.
+func (s *Store) ListVirtualKeys() ([]*VirtualKey, error) {
+	rows, err := s.reader.Query(`SELECT ` + virtualKeyColumns + ` FROM virtual_keys ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("store: list virtual keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*VirtualKey
+	for rows.Next() {
+		k, err := scanVirtualKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("store: scan virtual key row: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: list virtual keys iteration: %w", err)
+	}
+	return keys, nil
+}
+
+// RevokeVirtualKey marks a key as revoked. Revoking an already revoked key
+// keeps the original revocation time. Returns sql.ErrNoRows (wrapped) if no
+// key has the given ID.
+func (s *Store) RevokeVirtualKey(id string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	result, err := s.writer.Exec(`
+		UPDATE virtual_keys
+		SET revoked_at = CASE WHEN revoked_at = '' THEN ? ELSE revoked_at END
+		WHERE id = ?`, now, id,
+	)
+	if err != nil {
+		return fmt.Errorf("store: revoke virtual key: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("store: revoke virtual key rows affected: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("store: revoke virtual key %s: %w", id, sql.ErrNoRows)
+	}
+	return nil
+}
+
+// TouchVirtualKey records the time a key was last used.
+func (s *Store) TouchVirtualKey(id string, at time.Time) error {
+	_, err := s.writer.Exec(`UPDATE virtual_keys SET last_used_at = ? WHERE id = ?`,
+		at.UTC().Format(time.RFC3339), id)
+	if err != nil {
+		return fmt.Errorf("store: touch virtual key: %w", err)
+	}
+	return nil
+}
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanVirtualKey reads one virtual key row.
+func scanVirtualKey(row rowScanner) (*VirtualKey, error) {
+	k := &VirtualKey{}
+	var scopes, models string
+	if err := row.Scan(
+		&k.ID, &k.Name, &k.Owner, &k.KeyPrefix, &k.KeyHash, &scopes, &models,
+		&k.Priority, &k.CreatedAt, &k.ExpiresAt, &k.RevokedAt, &k.LastUsedAt,
+	); err != nil {
+		return nil, err
+	}
+	k.Scopes = splitList(scopes)
+	k.AllowedModels = splitList(models)
+	return k, nil
+}
+
+// splitList splits a comma-separated column value, returning nil when empty.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
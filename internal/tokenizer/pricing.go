@@ -29,6 +29,17 @@ var Pricing = map[string]ModelPricing{
 	"gpt-4o":      {2.50, 10.00},
 	"gpt-4o-mini": {0.15, 0.60},
 	"gpt-4-turbo": {10.00, 30.00},
+
+	// OpenAI embedding models (input only)
+	"text-embedding-3-small": {0.02, 0},
+	"text-embedding-3-large": {0.13, 0},
+	"text-embedding-ada-002": {0.10, 0},
+
+	// Gemini models
+	"gemini-2.5-pro":        {1.25, 10.00},
+	"gemini-2.5-flash":      {0.30, 2.50},
+	"gemini-2.5-flash-lite": {0.10, 0.40},
+	"gemini-2.0-flash":      {0.10, 0.40},
 }
 
 // GetPricing returns the pricing for the given model. It first attempts an
@@ -12,6 +12,28 @@ type Message struct {
 	Role    string
 	Content string
 	Name    string // optional
+	Images  int    // number of image inputs in the message
+}
+
+// Tool represents a tool definition for token counting purposes.
+type Tool struct {
+	Name        string
+	Description string
+	Schema      string // JSON input schema
+}
+
+// ImageTokens is the estimated cost of one image input. Providers bill
+// images by their dimensions; this is roughly what a large image costs on
+// Anthropic (about 1.15 megapixels) and a high-detail one on OpenAI.
+const ImageTokens = 1600
+
+// Breakdown is a token count split by the part of the request it comes from.
+type Breakdown struct {
+	Messages int `json:"messages"`
+	System   int `json:"system"`
+	Tools    int `json:"tools"`
+	Images   int `json:"images"`
+	Total    int `json:"total"`
 }
 
 // Tokenizer provides token counting using tiktoken encodings.
@@ -102,7 +124,8 @@ func (t *Tokenizer) CountTokens(model, text string) int {
 
 // CountMessages counts the total number of tokens across a slice of chat messages
 // for the specified model. Each message incurs a 4-token overhead (role framing),
-// and an additional 3 tokens are added for reply priming.
+// each image costs ImageTokens, and an additional 3 tokens are added for reply
+// priming.
 func (t *Tokenizer) CountMessages(model string, messages []Message) int {
 	enc, err := t.getEncoder(model)
 	if err != nil {
@@ -122,6 +145,7 @@ func (t *Tokenizer) CountMessages(model string, messages []Message) int {
 			// simplicity and consistency with the OpenAI reference implementation,
 			// we just add the name tokens here.
 		}
+		total += msg.Images * ImageTokens
 	}
 
 	// 3 tokens for reply priming (<im_start>assistant<im_sep>)
@@ -129,3 +153,25 @@ func (t *Tokenizer) CountMessages(model string, messages []Message) int {
 
 	return total
 }
+
+// CountRequest counts the tokens of a whole request: the messages, a system
+// prompt sent outside them (framed like one more message), and the tool
+// definitions. Images are included in Messages and reported on their own in
+// Images.
+func (t *Tokenizer) CountRequest(model, system string, messages []Message, tools []Tool) Breakdown {
+	var b Breakdown
+	if len(messages) > 0 {
+		b.Messages = t.CountMessages(model, messages)
+	}
+	for _, msg := range messages {
+		b.Images += msg.Images * ImageTokens
+	}
+	if system != "" {
+		b.System = 4 + t.CountTokens(model, system)
+	}
+	for _, tool := range tools {
+		b.Tools += t.CountTokens(model, tool.Name) + t.CountTokens(model, tool.Description) + t.CountTokens(model, tool.Schema)
+	}
+	b.Total = b.Messages + b.System + b.Tools
+	return b
+}
@@ -115,3 +115,26 @@ func TestGetEncoding_PrefixMatchForVersionedModelNames(t *testing.T) {
 		}
 	}
 }
+
+func TestCountRequest_SplitsSystemToolsAndImages(t *testing.T) {
+	tok := New()
+	messages := []Message{
+		{Role: "user", Content: "What is in these pictures?", Images: 2},
+	}
+	tools := []Tool{{Name: "lookup", Description: "Look something up", Schema: `{"type":"object"}`}}
+
+	b := tok.CountRequest("gpt-4", "Be brief.", messages, tools)
+	if b.Images != 2*ImageTokens {
+		t.Errorf("Images = %d; want %d", b.Images, 2*ImageTokens)
+	}
+	if b.System < 4 {
+		t.Errorf("System = %d; want at least the 4-token framing", b.System)
+	}
+	if b.Total != b.Messages+b.System+b.Tools {
+		t.Errorf("Total = %d; want messages %d + system %d + tools %d", b.Total, b.Messages, b.System, b.Tools)
+	}
+
+	if b := tok.CountRequest("gpt-4", "", nil, nil); b.Total != 0 {
+		t.Errorf("empty request Total = %d; want 0", b.Total)
+	}
+}
@@ -69,6 +69,29 @@ func SetResponseAttributes(ctx context.Context, statusCode int, tokensIn, tokens
 	)
 }
 
+// SetInjectionAttributes adds the prompt injection risk score, the patterns
+// that contributed most to it, and the action taken to the current span.
+func SetInjectionAttributes(ctx context.Context, score float64, topPatterns []string, action string) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(
+		attribute.Float64("injection.score", score),
+		attribute.StringSlice("injection.top_patterns", topPatterns),
+		attribute.String("injection.action", action),
+	)
+}
+
+// SetRoutingAttributes adds the routing strategy, the provider it ranked
+// first, and the ranked candidates with their reasons ("provider: reason")
+// to the current span.
+func SetRoutingAttributes(ctx context.Context, strategy, selected string, ranking []string) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(
+		attribute.String("routing.strategy", strategy),
+		attribute.String("routing.selected", selected),
+		attribute.StringSlice("routing.ranking", ranking),
+	)
+}
+
 // RecordError records an error on the current span.
 func RecordError(ctx context.Context, err error) {
 	if err != nil {
@@ -195,6 +195,73 @@ func TestSetResponseAttributes(t *testing.T) {
 	}
 }
 
+func TestSetInjectionAttributes(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter), sdktrace.WithSampler(sdktrace.AlwaysSample()))
+	otel.SetTracerProvider(tp)
+	defer func() {
+		tp.Shutdown(context.Background())
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	}()
+
+	ctx, span := Tracer().Start(context.Background(), "test")
+	SetInjectionAttributes(ctx, 0.85, []string{"ignore_previous", "chatml_system"}, "block")
+	span.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) == 0 {
+		t.Fatal("expected at least one span")
+	}
+
+	attrs := map[string]interface{}{}
+	for _, attr := range spans[0].Attributes {
+		attrs[string(attr.Key)] = attr.Value.AsInterface()
+	}
+
+	if attrs["injection.score"] != 0.85 {
+		t.Errorf("expected injection.score 0.85, got %v", attrs["injection.score"])
+	}
+	if attrs["injection.action"] != "block" {
+		t.Errorf("expected injection.action 'block', got %v", attrs["injection.action"])
+	}
+	top, ok := attrs["injection.top_patterns"].([]string)
+	if !ok || len(top) != 2 || top[0] != "ignore_previous" {
+		t.Errorf("unexpected injection.top_patterns: %v", attrs["injection.top_patterns"])
+	}
+}
+
+func TestSetRoutingAttributes(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter), sdktrace.WithSampler(sdktrace.AlwaysSample()))
+	otel.SetTracerProvider(tp)
+	defer func() {
+		tp.Shutdown(context.Background())
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	}()
+
+	ctx, span := Tracer().Start(context.Background(), "test")
+	SetRoutingAttributes(ctx, "lowest_cost", "openai", []string{"openai: within SLO", "anthropic: circuit open"})
+	span.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) == 0 {
+		t.Fatal("expected at least one span")
+	}
+
+	attrs := map[string]interface{}{}
+	for _, attr := range spans[0].Attributes {
+		attrs[string(attr.Key)] = attr.Value.AsInterface()
+	}
+
+	if attrs["routing.strategy"] != "lowest_cost" || attrs["routing.selected"] != "openai" {
+		t.Errorf("unexpected routing attributes: %v", attrs)
+	}
+	ranking, ok := attrs["routing.ranking"].([]string)
+	if !ok || len(ranking) != 2 || ranking[1] != "anthropic: circuit open" {
+		t.Errorf("unexpected routing.ranking: %v", attrs["routing.ranking"])
+	}
+}
+
 func TestRecordError_NilDoesNotPanic(t *testing.T) {
 	// Should not panic with a nil error.
 	RecordError(context.Background(), nil)
@@ -296,9 +296,42 @@
             data.forEach(function (p) {
                 var statusClass = p.enabled ? "provider-enabled" : "provider-disabled";
                 var statusText = p.enabled ? "Enabled" : "Disabled";
+                if (p.enabled && p.status) {
+                    statusClass = p.status.healthy ? "provider-enabled" : "provider-disabled";
+                    statusText = p.status.healthy ? "Healthy" : "Unhealthy";
+                }
 
                 html += '<div class="provider-item">';
                 html += '<span class="provider-name">' + escapeHtml(p.name) + "</span>";
+                if (p.rate_limits && p.rate_limits.length > 0) {
+                    var quotas = p.rate_limits.map(function (q) {
+                        var text = q.limit.replace("_", " ") + " " + formatCompact(q.remaining);
+                        if (q.max >= 0) text += "/" + formatCompact(q.max);
+                        return text;
+                    });
+                    html +=
+                        '<span class="provider-quota" title="Remaining upstream rate limit">' +
+                        escapeHtml(quotas.join(" · ")) +
+                        "</span>";
+                }
+                if (p.history && p.history.length > 0) {
+                    html += '<span class="provider-timeline">';
+                    p.history.forEach(function (c) {
+                        var title = new Date(c.time).toLocaleTimeString() + " " + c.source +
+                            (c.ok ? " ok" : " failed: " + (c.error || "")) + " (" + c.latency_ms + " ms)";
+                        html +=
+                            '<span class="timeline-tick ' +
+                            (c.healthy ? "tick-up" : "tick-down") +
+                            '" title="' +
+                            escapeHtml(title).replace(/"/g, "&quot;") +
+                            '"></span>';
+                    });
+                    html += "</span>";
+                    html +=
+                        '<span class="provider-uptime" title="Healthy share of recent checks">' +
+                        p.uptime_percent.toFixed(1) +
+                        "%</span>";
+                }
                 html +=
                     '<span class="provider-status ' +
                     statusClass +
@@ -341,6 +374,37 @@
         });
     }
 
+    // ---- Alerts Update ----
+    function updateAlerts() {
+        fetchJSON("/api/alerts?page=1&limit=20").then(function (data) {
+            if (!data || !data.alerts) return;
+
+            var tbody = document.getElementById("alerts-body");
+            if (!tbody) return;
+
+            if (data.alerts.length === 0) {
+                tbody.innerHTML =
+                    '<tr><td colspan="5" class="empty-state">No alerts</td></tr>';
+                return;
+            }
+
+            var severityTags = { critical: "tag-red", warning: "tag-yellow" };
+            var html = "";
+            data.alerts.forEach(function (a) {
+                var tag = severityTags[a.severity] || "tag-blue";
+                html += "<tr>";
+                html += "<td>" + formatTimestamp(a.timestamp) + "</td>";
+                html += '<td><span class="tag ' + tag + '">' + escapeHtml(a.severity) + "</span></td>";
+                html += "<td>" + escapeHtml(a.kind) + "</td>";
+                html += "<td>" + escapeHtml(a.title) + "</td>";
+                html += "<td>" + escapeHtml(a.message) + "</td>";
+                html += "</tr>";
+            });
+
+            tbody.innerHTML = html;
+        });
+    }
+
     // ---- Utility ----
     function escapeHtml(text) {
         if (!text) return "";
@@ -360,6 +424,7 @@
         updateBudget();
         updateProviders();
         updatePIILog();
+        updateAlerts();
 
         // Polling intervals.
         setInterval(updateStats, 5000);
@@ -368,6 +433,7 @@
         setInterval(updateBudget, 15000);
         setInterval(updateProviders, 30000);
         setInterval(updatePIILog, 15000);
+        setInterval(updateAlerts, 15000);
     }
 
     // Start when DOM is ready.